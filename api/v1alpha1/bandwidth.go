@@ -0,0 +1,68 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// bitswapTuningSince Is the first kubo release reading the settings of its
+// bitswap engine from the Internal section of its config.
+var bitswapTuningSince = MinorVersion{Major: 0, Minor: 13}
+
+// BitswapTuningSupported Returns whether the kubo image reads the settings of
+// its bitswap engine the operator writes. Images whose version cannot be
+// told from their tag are taken to be recent releases.
+func BitswapTuningSupported(image string) bool {
+	version, ok := ImageMinorVersion(image)
+	return !ok || !version.before(bitswapTuningSince)
+}
+
+// validateBandwidthConfig Returns an error for each cap which is not a
+// positive rate, and for the settings of the bitswap engine on kubo
+// releases ignoring them.
+func validateBandwidthConfig(path *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	bandwidth := spec.Bandwidth
+	quantities := []struct {
+		name  string
+		value *resource.Quantity
+	}{
+		{"ingress", bandwidth.Ingress},
+		{"egress", bandwidth.Egress},
+		{"maxOutstandingBytesPerPeer", bandwidth.MaxOutstandingBytesPerPeer},
+	}
+	for _, q := range quantities {
+		if q.value != nil && q.value.Sign() <= 0 {
+			errs = append(errs, field.Invalid(path.Child(q.name), q.value.String(), "must be positive"))
+		}
+	}
+	if spec.IpfsImage == "" || BitswapTuningSupported(spec.IpfsImage) {
+		return errs
+	}
+	reason := fmt.Sprintf("kubo only reads the settings of its bitswap engine since v%s", bitswapTuningSince)
+	if bandwidth.EngineWorkers != nil {
+		errs = append(errs, field.Forbidden(path.Child("engineWorkers"), reason))
+	}
+	if bandwidth.MaxOutstandingBytesPerPeer != nil {
+		errs = append(errs, field.Forbidden(path.Child("maxOutstandingBytesPerPeer"), reason))
+	}
+	return errs
+}
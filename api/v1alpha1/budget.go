@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// AnnotationBudget is set on a namespace to a budget, in YAML or JSON, which
+// replaces the budget of the operator for the Ipfs resources of the
+// namespace. The limits it leaves unset do not apply.
+const AnnotationBudget = "ipfs.cluster.io/budget"
+
+// Budget bounds the resources a single Ipfs resource may claim, so that a
+// mistyped spec cannot flood a shared cluster with volumes. The limits left
+// unset do not apply.
+type Budget struct {
+	// MaxReplicas is the largest number of peers of a cluster. The maximum of
+	// the autoscaler counts for autoscaled clusters.
+	MaxReplicas int32 `json:"maxReplicas,omitempty"`
+	// MaxStorage is the largest storage the volumes of all the peers of a
+	// cluster may claim together.
+	MaxStorage *resource.Quantity `json:"maxStorage,omitempty"`
+	// StorageClasses lists the storage classes the volumes may use. The
+	// volumes using the default storage class are always allowed.
+	StorageClasses []string `json:"storageClasses,omitempty"`
+}
+
+// BudgetSource returns the budget of the Ipfs resources of a namespace, along
+// with a description of where it is set.
+type BudgetSource interface {
+	BudgetFor(ctx context.Context, namespace string) (Budget, string, error)
+}
+
+// ValidateBudget Returns an error for each limit of the budget the spec
+// exceeds, naming the source of the limit. When old is set, the limits are
+// only enforced on the values the spec grows, so that a cluster created
+// before the limits can still shrink.
+func ValidateBudget(spec, old *IpfsSpec, budget Budget, source string) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	replicas, replicasPath := budgetedReplicas(spec, specPath)
+	if budget.MaxReplicas > 0 && replicas > budget.MaxReplicas {
+		if previous, _ := budgetedReplicas(old, specPath); old == nil || replicas > previous {
+			errs = append(errs, field.Invalid(replicasPath, replicas,
+				fmt.Sprintf("exceeds the limit of %d peers set by %s", budget.MaxReplicas, source)))
+		}
+	}
+
+	if limit := budget.MaxStorage; limit != nil {
+		storage := budgetedStorage(spec)
+		if storage.Cmp(*limit) > 0 && (old == nil || storage.Cmp(budgetedStorage(old)) > 0) {
+			errs = append(errs, field.Invalid(specPath.Child("ipfsStorage"), spec.IpfsStorage,
+				fmt.Sprintf("the %d peers claim %s of storage, exceeding the limit of %s set by %s",
+					replicas, storage.String(), limit.String(), source)))
+		}
+	}
+
+	if class := spec.StorageClassName; class != nil && len(budget.StorageClasses) > 0 {
+		allowed := false
+		for _, c := range budget.StorageClasses {
+			allowed = allowed || c == *class
+		}
+		if !allowed {
+			errs = append(errs, field.Invalid(specPath.Child("storageClassName"), *class,
+				fmt.Sprintf("must be one of %s, as set by %s", strings.Join(budget.StorageClasses, ", "), source)))
+		}
+	}
+	return errs
+}
+
+// budgetedReplicas Returns the largest number of peers of the spec, along
+// with the path of the field setting it.
+func budgetedReplicas(spec *IpfsSpec, specPath *field.Path) (int32, *field.Path) {
+	if spec == nil {
+		return 0, nil
+	}
+	if spec.Autoscaling != nil {
+		return spec.Autoscaling.MaxReplicas, specPath.Child("autoscaling", "maxReplicas")
+	}
+	return spec.Replicas, specPath.Child("replicas")
+}
+
+// budgetedStorage Returns the storage the volumes of all the peers of the
+// spec claim together. Sizes which do not parse are left to the validation
+// of the spec.
+func budgetedStorage(spec *IpfsSpec) resource.Quantity {
+	replicas, _ := budgetedReplicas(spec, nil)
+	var perPeer int64
+	for _, size := range []string{spec.IpfsStorage, spec.ClusterStorage} {
+		if q, err := resource.ParseQuantity(size); err == nil {
+			perPeer += q.Value()
+		}
+	}
+	return *resource.NewQuantity(perPeer*int64(replicas), resource.BinarySI)
+}
@@ -17,10 +17,14 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"fmt"
+
 	"github.com/libp2p/go-libp2p-core/peer"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/redhat-et/ipfs-operator/pkg/maddr"
 )
 
 // This is intended to mimic peer.AddrInfo.
@@ -30,19 +34,21 @@ type AddrInfoBasicType struct {
 	addrInfo peer.AddrInfo `json:"-"`
 }
 
+// Parse Parses the peer ID and the addresses, which leave it out, into the
+// AddrInfo. The addresses are normalized, so that the configurations they are
+// rendered into are stable.
 func (a *AddrInfoBasicType) Parse() error {
 	id, err := peer.IDB58Decode(a.ID)
+	if err != nil {
+		return fmt.Errorf("status.addrInfo.id: invalid peer ID %q: %w", a.ID, err)
+	}
+	normalized, err := maddr.Normalize("status.addrInfo.addrs", a.Addrs, maddr.PeerForbidden)
 	if err != nil {
 		return err
 	}
-	addrs := make([]ma.Multiaddr, len(a.Addrs))
-	for i, addr := range a.Addrs {
-		var maddr ma.Multiaddr
-		maddr, err = ma.NewMultiaddr(addr)
-		if err != nil {
-			return err
-		}
-		addrs[i] = maddr
+	addrs := make([]ma.Multiaddr, len(normalized))
+	for i, addr := range normalized {
+		addrs[i] = ma.StringCast(addr)
 	}
 	ai := peer.AddrInfo{
 		ID:    id,
@@ -0,0 +1,182 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// AnnotationAllowUnsupportedVersions lets the kubo and ipfs-cluster images
+// of the spec be combined although the compatibility table of the operator
+// marks them as unsupported, when set to "true".
+const AnnotationAllowUnsupportedVersions = "ipfs.cluster.io/allow-unsupported-versions"
+
+// MinorVersion is the major and minor version of a release.
+// +kubebuilder:object:generate=false
+type MinorVersion struct {
+	Major int
+	Minor int
+}
+
+// String Returns the version as major.minor.
+func (v MinorVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// before Returns whether the version is older than the other one.
+func (v MinorVersion) before(other MinorVersion) bool {
+	return v.Major < other.Major || (v.Major == other.Major && v.Minor < other.Minor)
+}
+
+// VersionRange is an inclusive range of minor versions.
+// +kubebuilder:object:generate=false
+type VersionRange struct {
+	From MinorVersion
+	To   MinorVersion
+}
+
+// Contains Returns whether the version is within the range.
+func (r VersionRange) Contains(v MinorVersion) bool {
+	return !v.before(r.From) && !r.To.before(v)
+}
+
+// String Returns the range as from-to.
+func (r VersionRange) String() string {
+	if r.From == r.To {
+		return r.From.String()
+	}
+	return r.From.String() + "-" + r.To.String()
+}
+
+// VersionCompatibility pairs a range of ipfs-cluster releases with the kubo
+// releases they are known to work with.
+// +kubebuilder:object:generate=false
+type VersionCompatibility struct {
+	Cluster VersionRange
+	Kubo    VersionRange
+}
+
+// CompatibilityTable lists the kubo releases each ipfs-cluster release is
+// known to work with. The ipfs-cluster releases it does not list are not
+// verified against any kubo release.
+var CompatibilityTable = []VersionCompatibility{
+	{
+		Cluster: VersionRange{From: MinorVersion{0, 13}, To: MinorVersion{0, 14}},
+		Kubo:    VersionRange{From: MinorVersion{0, 5}, To: MinorVersion{0, 11}},
+	},
+	{
+		Cluster: VersionRange{From: MinorVersion{1, 0}, To: MinorVersion{1, 0}},
+		Kubo:    VersionRange{From: MinorVersion{0, 12}, To: MinorVersion{0, 29}},
+	},
+	{
+		Cluster: VersionRange{From: MinorVersion{1, 1}, To: MinorVersion{1, 1}},
+		Kubo:    VersionRange{From: MinorVersion{0, 28}, To: MinorVersion{0, 39}},
+	},
+}
+
+// VersionCombination is the outcome of looking the images up in the
+// compatibility table.
+type VersionCombination string
+
+const (
+	// VersionCombinationSupported means the table lists the combination.
+	VersionCombinationSupported VersionCombination = "Supported"
+	// VersionCombinationUnsupported means the table lists the ipfs-cluster
+	// release with other kubo releases.
+	VersionCombinationUnsupported VersionCombination = "Unsupported"
+	// VersionCombinationUnknown means the version of an image cannot be told
+	// from its reference, such as for digest-only or latest references, or
+	// the table does not list the ipfs-cluster release.
+	VersionCombinationUnknown VersionCombination = "Unknown"
+)
+
+// ImageMinorVersion Returns the minor version of the tag of the image, which
+// is unknown for references without a tag, such as digest-only references,
+// and for tags which are not versions, such as latest.
+func ImageMinorVersion(image string) (MinorVersion, bool) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return MinorVersion{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(image[i+1:], "v"), ".", 3)
+	if len(parts) < 2 {
+		return MinorVersion{}, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return MinorVersion{}, false
+	}
+	minor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return MinorVersion{}, false
+	}
+	return MinorVersion{Major: major, Minor: minor}, true
+}
+
+// CheckVersionCombination Looks the kubo and ipfs-cluster images up in the
+// compatibility table, with a message describing the outcome.
+func CheckVersionCombination(ipfsImage, clusterImage string) (VersionCombination, string) {
+	kubo, ok := ImageMinorVersion(ipfsImage)
+	if !ok {
+		return VersionCombinationUnknown, fmt.Sprintf("the kubo version of %s cannot be told from its tag", ipfsImage)
+	}
+	cluster, ok := ImageMinorVersion(clusterImage)
+	if !ok {
+		return VersionCombinationUnknown, fmt.Sprintf("the ipfs-cluster version of %s cannot be told from its tag",
+			clusterImage)
+	}
+	var supported []string
+	for _, entry := range CompatibilityTable {
+		if !entry.Cluster.Contains(cluster) {
+			continue
+		}
+		if entry.Kubo.Contains(kubo) {
+			return VersionCombinationSupported, fmt.Sprintf("ipfs-cluster %s supports kubo %s", cluster, kubo)
+		}
+		supported = append(supported, entry.Kubo.String())
+	}
+	if len(supported) == 0 {
+		return VersionCombinationUnknown, fmt.Sprintf("ipfs-cluster %s is not verified against any kubo release",
+			cluster)
+	}
+	return VersionCombinationUnsupported, fmt.Sprintf("ipfs-cluster %s supports kubo %s, not %s",
+		cluster, strings.Join(supported, ", "), kubo)
+}
+
+// ValidateVersionCombination Returns an error when the images of the spec
+// are a combination the compatibility table marks as unsupported, unless
+// the annotations allow it. The images left unset are the defaults of the
+// operator and are not checked, nor are the images whose version is unknown,
+// so that digest-pinned deployments are not refused.
+func ValidateVersionCombination(annotations map[string]string, spec *IpfsSpec) field.ErrorList {
+	if spec.IpfsImage == "" || spec.ClusterImage == "" || annotations[AnnotationAllowUnsupportedVersions] == "true" {
+		return nil
+	}
+	combination, message := CheckVersionCombination(spec.IpfsImage, spec.ClusterImage)
+	if combination != VersionCombinationUnsupported {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(field.NewPath("spec", "ipfsImage"), spec.IpfsImage,
+		message+"; set the "+AnnotationAllowUnsupportedVersions+" annotation to \"true\" to run it anyway")}
+}
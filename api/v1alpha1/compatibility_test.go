@@ -0,0 +1,56 @@
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Version compatibility", func() {
+	It("reads the minor version from the tag of the image", func() {
+		version, ok := ImageMinorVersion("ipfs/kubo:v0.14.0")
+		Expect(ok).To(BeTrue())
+		Expect(version).To(Equal(MinorVersion{Major: 0, Minor: 14}))
+		version, ok = ImageMinorVersion("registry.example.com:5000/ipfs/ipfs-cluster:1.0.1@sha256:0123456789abcdef")
+		Expect(ok).To(BeTrue())
+		Expect(version).To(Equal(MinorVersion{Major: 1, Minor: 0}))
+		version, ok = ImageMinorVersion("ipfs/kubo:v0.20-rc1")
+		Expect(ok).To(BeTrue())
+		Expect(version).To(Equal(MinorVersion{Major: 0, Minor: 20}))
+
+		for _, image := range []string{
+			"ipfs/kubo",
+			"ipfs/kubo:latest",
+			"ipfs/kubo@sha256:0123456789abcdef",
+			"registry.example.com:5000/ipfs/kubo",
+		} {
+			_, ok = ImageMinorVersion(image)
+			Expect(ok).To(BeFalse(), image)
+		}
+	})
+
+	It("supports the default images of the operator", func() {
+		combination, _ := CheckVersionCombination("ipfs/go-ipfs:v0.12.2", "ipfs/ipfs-cluster:v1.0.1")
+		Expect(combination).To(Equal(VersionCombinationSupported))
+	})
+
+	It("names the supported kubo releases of unsupported combinations", func() {
+		combination, message := CheckVersionCombination("ipfs/go-ipfs:v0.9.1", "ipfs/ipfs-cluster:v1.0.1")
+		Expect(combination).To(Equal(VersionCombinationUnsupported))
+		Expect(message).To(Equal("ipfs-cluster 1.0 supports kubo 0.12-0.29, not 0.9"))
+	})
+
+	It("does not verify the images whose version is unknown", func() {
+		combination, message := CheckVersionCombination("ipfs/kubo@sha256:0123456789abcdef", "ipfs/ipfs-cluster:v1.0.1")
+		Expect(combination).To(Equal(VersionCombinationUnknown))
+		Expect(message).To(ContainSubstring("cannot be told from its tag"))
+		combination, _ = CheckVersionCombination("ipfs/kubo:v0.14.0", "ipfs/ipfs-cluster:v9.0.0")
+		Expect(combination).To(Equal(VersionCombinationUnknown))
+	})
+
+	It("keeps the ranges of the table ordered", func() {
+		for _, entry := range CompatibilityTable {
+			Expect(entry.Cluster.To.before(entry.Cluster.From)).To(BeFalse(), entry.Cluster.String())
+			Expect(entry.Kubo.To.before(entry.Kubo.From)).To(BeFalse(), entry.Kubo.String())
+		}
+	})
+})
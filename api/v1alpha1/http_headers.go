@@ -0,0 +1,128 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// HeaderAllowOrigin Is the header naming the origins whose pages may read a
+// response.
+const HeaderAllowOrigin = "Access-Control-Allow-Origin"
+
+// corsMethods Are the HTTP methods the pages of other origins may be allowed
+// to call an API with.
+var corsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// validHeaderName Returns whether the name is an HTTP header name: a token of
+// RFC 7230.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		alnum := ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+		if !alnum && strings.IndexByte("!#$%&'*+-.^_`|~", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue Returns whether the value can be sent as the value of an
+// HTTP header, which excludes the control characters but the tab.
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// validateGatewayConfig Returns an error for each header the gateway cannot
+// send, for the names differing only by case, which kubo merges, and for the
+// origins set both by the headers and by allowAllOrigins.
+func validateGatewayConfig(gatewayPath *field.Path, gateway *GatewayConfig) field.ErrorList {
+	var errs field.ErrorList
+	names := make([]string, 0, len(gateway.HTTPHeaders))
+	for name := range gateway.HTTPHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	seen := map[string]bool{}
+	for _, name := range names {
+		headerPath := gatewayPath.Child("httpHeaders").Key(name)
+		if !validHeaderName(name) {
+			errs = append(errs, field.Invalid(headerPath, name,
+				"must be an HTTP header name, made of letters, digits and !#$%&'*+-.^_`|~"))
+			continue
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if seen[canonical] {
+			errs = append(errs, field.Duplicate(headerPath, name))
+		}
+		seen[canonical] = true
+		if canonical == HeaderAllowOrigin && gateway.AllowAllOrigins {
+			errs = append(errs, field.Forbidden(headerPath, "must be unset when allowAllOrigins is set"))
+		}
+		for i, value := range gateway.HTTPHeaders[name] {
+			if !validHeaderValue(value) {
+				errs = append(errs, field.Invalid(headerPath.Index(i), value,
+					"must not hold control characters"))
+			}
+		}
+	}
+	return errs
+}
+
+// validateCORSConfig Returns an error for each origin which is neither "*"
+// nor an http or https origin, and for each method which is not an HTTP
+// method.
+func validateCORSConfig(corsPath *field.Path, cors *CORSConfig) field.ErrorList {
+	var errs field.ErrorList
+	for i, origin := range cors.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			errs = append(errs, field.Invalid(corsPath.Child("allowedOrigins").Index(i), origin,
+				`must be "*" or an origin such as https://app.example.com`))
+		}
+	}
+	for i, method := range cors.AllowedMethods {
+		known := false
+		for _, m := range corsMethods {
+			known = known || m == method
+		}
+		if !known {
+			errs = append(errs, field.NotSupported(corsPath.Child("allowedMethods").Index(i), method, corsMethods))
+		}
+	}
+	return errs
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -27,8 +29,533 @@ const (
 	// ReconciledReasonComplete indicates the CR was successfully reconciled.
 	ReconciledReasonComplete string = "ReconcileComplete"
 	// ReconciledReasonError indicates an error was encountered while
-	// reconciling the CR.
+	// reconciling the CR. Transient errors are retried with backoff rather
+	// than reported.
 	ReconciledReasonError string = "ReconcileError"
+	// ReconciledReasonIdentityNotReady indicates the Secrets holding the
+	// identities and credentials of the cluster could not be applied.
+	ReconciledReasonIdentityNotReady string = "IdentityNotReady"
+	// ReconciledReasonConfigNotReady indicates the ConfigMaps of the peers
+	// could not be applied.
+	ReconciledReasonConfigNotReady string = "ConfigNotReady"
+	// ReconciledReasonServiceNotReady indicates the Services and Ingress of
+	// the cluster could not be applied.
+	ReconciledReasonServiceNotReady string = "ServiceNotReady"
+	// ReconciledReasonWorkloadNotReady indicates the StatefulSet of the peers
+	// could not be applied.
+	ReconciledReasonWorkloadNotReady string = "WorkloadNotReady"
+	// ReconciledReasonInvalidSpec indicates the spec cannot be reconciled as
+	// it is, such as when the API server refuses an object rendered from it.
+	// The reconcile is not retried until the spec changes.
+	ReconciledReasonInvalidSpec string = "InvalidSpec"
+	// ReconciledReasonDependencyMissing indicates an object the spec refers
+	// to, such as a referenced Secret, does not exist. The reconcile is
+	// retried after a minute, or as soon as the cluster changes.
+	ReconciledReasonDependencyMissing string = "DependencyMissing"
+	// ReconciledReasonExternalAPIError indicates a call to the REST API of
+	// the cluster or to the RPC API of a kubo node failed. The reconcile is
+	// retried after a minute.
+	ReconciledReasonExternalAPIError string = "ExternalAPIError"
+
+	// ConditionReady indicates whether every peer of the cluster is ready.
+	ConditionReady string = "Ready"
+	// ReadyReasonPeersReady indicates every peer is running the latest spec and is ready.
+	ReadyReasonPeersReady string = "PeersReady"
+	// ReadyReasonPeersNotReady indicates some peers are not ready or not updated yet.
+	ReadyReasonPeersNotReady string = "PeersNotReady"
+
+	// ConditionUpgrading indicates whether the peers are being restarted with
+	// a new revision of the StatefulSet. Its message includes the configured
+	// pacing of the rollout.
+	ConditionUpgrading string = "Upgrading"
+	// UpgradingReasonRollingOut indicates some peers still run the previous revision.
+	UpgradingReasonRollingOut string = "RollingOut"
+	// UpgradingReasonUpToDate indicates every peer runs the latest revision.
+	UpgradingReasonUpToDate string = "UpToDate"
+	// UpgradingReasonHookFailed indicates a rollout hook failed, which halts
+	// the rollout until the next revision.
+	UpgradingReasonHookFailed string = "HookFailed"
+
+	// ConditionWaitingForMaintenanceWindow indicates whether disruptive
+	// operations, such as rollouts and rotations, were requested outside the
+	// maintenance window and wait for it to open. Its message tells when.
+	ConditionWaitingForMaintenanceWindow string = "WaitingForMaintenanceWindow"
+	// WaitingForMaintenanceWindowReasonOutsideWindow indicates some operations wait for the window.
+	WaitingForMaintenanceWindowReasonOutsideWindow string = "OutsideWindow"
+	// WaitingForMaintenanceWindowReasonNothingWaiting indicates no operation waits for the window.
+	WaitingForMaintenanceWindowReasonNothingWaiting string = "NothingWaiting"
+
+	// ConditionDNSReady indicates whether the hostname requested in
+	// spec.expose.dns resolves to the address of the exposed endpoint.
+	ConditionDNSReady string = "DNSReady"
+	// DNSReadyReasonResolved indicates the hostname points at the exposed endpoint.
+	DNSReadyReasonResolved string = "Resolved"
+	// DNSReadyReasonPending indicates the hostname does not resolve to the
+	// exposed endpoint yet.
+	DNSReadyReasonPending string = "Pending"
+
+	// ConditionRotating indicates whether a cluster secret rotation is in
+	// progress. While it is true, peers that restarted with the new secret
+	// cannot talk to peers still running with the old one.
+	ConditionRotating string = "Rotating"
+	// RotatingReasonInProgress indicates peers are restarting with the new secret.
+	RotatingReasonInProgress string = "InProgress"
+	// RotatingReasonComplete indicates every peer rejoined the cluster with the new secret.
+	RotatingReasonComplete string = "RotationComplete"
+	// RotatingReasonFailed indicates the peers did not rejoin in time and the
+	// rotation was halted.
+	RotatingReasonFailed string = "RotationFailed"
+
+	// ConditionIdentityRotating indicates whether the identity of a single
+	// peer is being replaced. It uses the same reasons as ConditionRotating.
+	ConditionIdentityRotating string = "IdentityRotating"
+	// IdentityRotatingReasonInvalidOrdinal indicates the requested ordinal
+	// does not name a peer of the cluster.
+	IdentityRotatingReasonInvalidOrdinal string = "InvalidOrdinal"
+
+	// ConditionCompacting indicates whether the CRDT state of the peers is
+	// being compacted, one peer at a time.
+	ConditionCompacting string = "Compacting"
+	// CompactingReasonInProgress indicates a peer is out of the cluster while
+	// its state is compacted.
+	CompactingReasonInProgress string = "InProgress"
+	// CompactingReasonComplete indicates the state of every peer was compacted.
+	CompactingReasonComplete string = "CompactionComplete"
+	// CompactingReasonAborted indicates the compaction stopped because other
+	// peers became unavailable meanwhile. It starts over on the next schedule.
+	CompactingReasonAborted string = "CompactionAborted"
+	// CompactingReasonFailed indicates the compaction Job of a peer failed.
+	CompactingReasonFailed string = "CompactionFailed"
+
+	// ConditionCollectingGarbage indicates whether the repos of the peers
+	// are being garbage collected after unpins, one peer at a time.
+	ConditionCollectingGarbage string = "CollectingGarbage"
+	// GCReasonInProgress indicates a pass collects the repos of the peers.
+	GCReasonInProgress string = "InProgress"
+	// GCReasonComplete indicates the last pass went over every peer.
+	GCReasonComplete string = "GCComplete"
+	// GCReasonDeferred indicates a pass waits for a rollout, a rotation, a
+	// compaction or a repair of the pinset to complete, or for the cluster
+	// to be ready again. A pass in progress resumes where it stopped.
+	GCReasonDeferred string = "GCDeferred"
+
+	// ConditionProvisioningFailed indicates the persistent volumes of some
+	// peers cannot be provisioned.
+	ConditionProvisioningFailed string = "ProvisioningFailed"
+	// ProvisioningFailedReasonStorageClassNotFound indicates the requested
+	// storage class does not exist.
+	ProvisioningFailedReasonStorageClassNotFound string = "StorageClassNotFound"
+	// ProvisioningFailedReasonProvisionerError indicates the provisioner
+	// reported an error.
+	ProvisioningFailedReasonProvisionerError string = "ProvisionerError"
+
+	// ConditionQuotaExceeded indicates a ResourceQuota rejects the pods or
+	// the persistent volume claims of some peers.
+	ConditionQuotaExceeded string = "QuotaExceeded"
+	// QuotaExceededReasonFailedCreate indicates the StatefulSet failed to
+	// create pods or claims because of a quota.
+	QuotaExceededReasonFailedCreate string = "FailedCreate"
+	// QuotaExceededReasonClaimsRejected indicates a quota rejected the claims
+	// the operator creates ahead of a scale-up, which waits until they fit.
+	QuotaExceededReasonClaimsRejected string = "ClaimsRejected"
+
+	// ConditionUnschedulable indicates some peer pods fit on no node.
+	ConditionUnschedulable string = "Unschedulable"
+	// UnschedulableReasonNoFit indicates the scheduler found no node fitting the pod.
+	UnschedulableReasonNoFit string = "NoFit"
+
+	// ConditionPodAdmissionBlocked indicates the admission of the API server
+	// rejects a pod of the peers, as found by creating one in dry-run mode
+	// before applying the StatefulSet. The StatefulSet is not changed while
+	// it is true.
+	ConditionPodAdmissionBlocked string = "PodAdmissionBlocked"
+	// PodAdmissionBlockedReasonPodSecurity indicates the PodSecurity level
+	// enforced on the namespace rejects the pod.
+	PodAdmissionBlockedReasonPodSecurity string = "PodSecurity"
+	// PodAdmissionBlockedReasonSecurityContextConstraints indicates no
+	// OpenShift SecurityContextConstraints admits the pod.
+	PodAdmissionBlockedReasonSecurityContextConstraints string = "SecurityContextConstraints"
+	// PodAdmissionBlockedReasonDenied indicates another admission plugin or
+	// webhook rejects the pod.
+	PodAdmissionBlockedReasonDenied string = "Denied"
+
+	// ConditionConfigInvalid indicates a setting the operator renders for the
+	// daemons does not fit the schema of the kubo or ipfs-cluster release of
+	// the spec, such as an unknown field or a value of the wrong type. Its
+	// message names the path of the setting. The objects holding it are not
+	// changed while it is true.
+	ConditionConfigInvalid string = "ConfigInvalid"
+	// ConfigInvalidReasonKubo indicates a setting of the kubo config.
+	ConfigInvalidReasonKubo string = "KuboConfigInvalid"
+	// ConfigInvalidReasonCluster indicates a setting of the service.json of
+	// ipfs-cluster.
+	ConfigInvalidReasonCluster string = "ClusterConfigInvalid"
+
+	// ConditionRelayLinkDown indicates some ready peers are not connected to
+	// a circuit relay of the cluster, or do not protect their connection to
+	// it from the connection manager. Its message names the relays.
+	ConditionRelayLinkDown string = "RelayLinkDown"
+	// RelayLinkDownReasonDisconnected indicates some peers are not connected to a relay.
+	RelayLinkDownReasonDisconnected string = "RelayDisconnected"
+	// RelayLinkDownReasonUnprotected indicates some peers are connected to a
+	// relay which is missing from their peering set.
+	RelayLinkDownReasonUnprotected string = "RelayUnprotected"
+
+	// ConditionVersionSkew indicates whether some peers run images other than
+	// the ones requested by the spec, or other than the ones of their peers.
+	ConditionVersionSkew string = "VersionSkew"
+	// ConditionConfigSkew indicates whether some peers were started with a
+	// configuration other than the current one.
+	ConditionConfigSkew string = "ConfigSkew"
+	// SkewReasonPeersDiffer indicates some peers differ from the spec or from each other.
+	SkewReasonPeersDiffer string = "PeersDiffer"
+	// SkewReasonPeersAgree indicates every peer matches the spec.
+	SkewReasonPeersAgree string = "PeersAgree"
+
+	// ConditionUnverifiedVersionCombination indicates whether the kubo and
+	// ipfs-cluster images the peers run are not a combination listed as
+	// supported in the compatibility table of the operator. It is a warning:
+	// the peers run regardless.
+	ConditionUnverifiedVersionCombination string = "UnverifiedVersionCombination"
+	// UnverifiedVersionCombinationReasonSupported indicates the table lists the combination.
+	UnverifiedVersionCombinationReasonSupported string = "Supported"
+	// UnverifiedVersionCombinationReasonUnknownVersion indicates the version
+	// of an image cannot be told from its reference, such as for digest-only
+	// references, or the table does not list the ipfs-cluster release.
+	UnverifiedVersionCombinationReasonUnknownVersion string = "UnknownVersion"
+	// UnverifiedVersionCombinationReasonUnsupported indicates the table lists
+	// the ipfs-cluster release with other kubo releases. The peers run it
+	// anyway when it is allowed by the allow-unsupported-versions annotation,
+	// or when the validating webhook is not deployed.
+	UnverifiedVersionCombinationReasonUnsupported string = "Unsupported"
+
+	// ConditionOverridesPending indicates some peer pods run without the node
+	// selector or resources of their override, because they were created
+	// before the override or without the webhooks of the operator.
+	ConditionOverridesPending string = "OverridesPending"
+	// OverridesPendingReasonPodsNotOverridden indicates some pods lack their override.
+	OverridesPendingReasonPodsNotOverridden string = "PodsNotOverridden"
+	// OverridesPendingReasonPodsOverridden indicates every pod runs with its override.
+	OverridesPendingReasonPodsOverridden string = "PodsOverridden"
+	// OverridesPendingReasonWebhooksDisabled indicates the node selector and
+	// resources overrides are refused, as the operator does not serve the
+	// webhook applying them.
+	OverridesPendingReasonWebhooksDisabled string = "WebhooksDisabled"
+
+	// ConditionRejectedChange indicates the spec changes fields which are
+	// frozen once the cluster is created. The operator keeps reconciling the
+	// values recorded in status.frozen.
+	ConditionRejectedChange string = "RejectedChange"
+	// RejectedChangeReasonFrozenField indicates a frozen field was changed.
+	RejectedChangeReasonFrozenField string = "FrozenFieldChanged"
+	// RejectedChangeReasonInvalidSpec indicates the spec sets conflicting fields.
+	RejectedChangeReasonInvalidSpec string = "InvalidSpec"
+
+	// ConditionStoragePressure indicates the repo of some peers fills more of
+	// their StorageMax than the storage pressure threshold.
+	ConditionStoragePressure string = "StoragePressure"
+	// StoragePressureReasonThresholdExceeded indicates some peers are above the threshold.
+	StoragePressureReasonThresholdExceeded string = "ThresholdExceeded"
+	// StoragePressureReasonWithinThreshold indicates every peer is below the threshold.
+	StoragePressureReasonWithinThreshold string = "WithinThreshold"
+
+	// ConditionStorageMaxMismatch indicates the StorageMax the repo of some
+	// peers report differs from the size of their volume, which misleads the
+	// freespace informer allocating pins.
+	ConditionStorageMaxMismatch string = "StorageMaxMismatch"
+	// StorageMaxMismatchReasonVolumeSize indicates some peers report a
+	// StorageMax other than the size of their volume.
+	StorageMaxMismatchReasonVolumeSize string = "VolumeSizeMismatch"
+	// StorageMaxMismatchReasonMatchesVolume indicates every peer reports the
+	// size of its volume as StorageMax.
+	StorageMaxMismatchReasonMatchesVolume string = "MatchesVolume"
+
+	// ConditionPreflightFailed indicates the network checks some peers ran
+	// before starting failed.
+	ConditionPreflightFailed string = "PreflightFailed"
+	// PreflightFailedReasonChecksFailed indicates some checks failed.
+	PreflightFailedReasonChecksFailed string = "ChecksFailed"
+	// PreflightFailedReasonChecksPassed indicates every check passed.
+	PreflightFailedReasonChecksPassed string = "ChecksPassed"
+
+	// ConditionBudgetExceeded indicates the spec exceeds the budget of its
+	// namespace, which happens when the validating webhook is not deployed.
+	// The operator stops reconciling the cluster until the spec fits.
+	ConditionBudgetExceeded string = "BudgetExceeded"
+	// BudgetExceededReasonLimitExceeded indicates some limits of the budget are exceeded.
+	BudgetExceededReasonLimitExceeded string = "LimitExceeded"
+
+	// ConditionDrifted indicates whether the operator would change some
+	// objects of an audited cluster. It is only set in verify-only mode.
+	ConditionDrifted string = "Drifted"
+	// DriftedReasonObjectsDiffer indicates some objects differ from those the operator renders.
+	DriftedReasonObjectsDiffer string = "ObjectsDiffer"
+	// DriftedReasonObjectsMatch indicates every object matches those the operator renders.
+	DriftedReasonObjectsMatch string = "ObjectsMatch"
+
+	// ConditionMeshDegraded indicates some ready peers have not been connected
+	// to each other for longer than the mesh degradation threshold, so that
+	// content does not replicate between them.
+	ConditionMeshDegraded string = "MeshDegraded"
+	// MeshDegradedReasonPeersUnreachable indicates some peers cannot reach others.
+	MeshDegradedReasonPeersUnreachable string = "PeersUnreachable"
+	// MeshDegradedReasonSettling indicates some peers cannot reach others for
+	// less than the threshold.
+	MeshDegradedReasonSettling string = "Settling"
+	// MeshDegradedReasonMeshComplete indicates every ready peer is connected to every other one.
+	MeshDegradedReasonMeshComplete string = "MeshComplete"
+
+	// ConditionUnexpectedAnnounceAddresses indicates whether the kubo nodes
+	// announce addresses outside of the expected ranges, which could make
+	// them reachable from the internet. Its message lists the offending
+	// addresses by peer.
+	ConditionUnexpectedAnnounceAddresses string = "UnexpectedAnnounceAddresses"
+	// UnexpectedAnnounceAddressesReasonFound indicates some peers announce
+	// unexpected addresses.
+	UnexpectedAnnounceAddressesReasonFound string = "AddressesOutsideRanges"
+	// UnexpectedAnnounceAddressesReasonNone indicates every announced address
+	// is within the expected ranges.
+	UnexpectedAnnounceAddressesReasonNone string = "AddressesWithinRanges"
+
+	// ConditionAPIUnreachable indicates the REST API of the cluster, or the
+	// RPC API of its kubo nodes, failed consecutive calls, so that the
+	// operator stops calling it until a probe succeeds.
+	ConditionAPIUnreachable string = "APIUnreachable"
+	// APIUnreachableReasonBreakerOpen indicates the circuit breaker of some APIs is open.
+	APIUnreachableReasonBreakerOpen string = "BreakerOpen"
+	// APIUnreachableReasonBreakerClosed indicates the APIs of the cluster answer.
+	APIUnreachableReasonBreakerClosed string = "BreakerClosed"
+
+	// ConditionFederationDegraded indicates some of the peer bundles the
+	// cluster imports from federated clusters cannot be used.
+	ConditionFederationDegraded string = "FederationDegraded"
+	// FederationDegradedReasonBundleInvalid indicates some bundles are
+	// missing, malformed or not signed by a trusted signer.
+	FederationDegradedReasonBundleInvalid string = "BundleInvalid"
+	// FederationDegradedReasonBundleExpired indicates some bundles expired.
+	FederationDegradedReasonBundleExpired string = "BundleExpired"
+	// FederationDegradedReasonBundlesImported indicates every bundle is imported.
+	FederationDegradedReasonBundlesImported string = "BundlesImported"
+
+	// ConditionReprovideBehind indicates some peers are estimated to take
+	// longer to announce their content than the reprovider interval, so
+	// that part of it becomes undiscoverable.
+	ConditionReprovideBehind string = "ReprovideBehind"
+	// ReprovideBehindReasonEstimateExceedsInterval indicates the estimated
+	// reprovide time of some peers exceeds the interval.
+	ReprovideBehindReasonEstimateExceedsInterval string = "EstimateExceedsInterval"
+	// ReprovideBehindReasonWithinInterval indicates every peer is estimated
+	// to reprovide its content within the interval.
+	ReprovideBehindReasonWithinInterval string = "WithinInterval"
+
+	// ConditionBandwidthConstrained indicates the egress cap of the peers is
+	// too low for them to pass on the replicas of the content they can take
+	// in through their ingress cap, so that sustained adds never finish
+	// replicating. It is a heuristic, which assumes every peer holding pins
+	// replicates every pin, as ipfs-cluster does by default.
+	ConditionBandwidthConstrained string = "BandwidthConstrained"
+	// BandwidthConstrainedReasonEgressBelowReplication indicates the egress
+	// cap is below what the replication of the ingress requires.
+	BandwidthConstrainedReasonEgressBelowReplication string = "EgressBelowReplication"
+	// BandwidthConstrainedReasonEgressSufficient indicates the egress cap
+	// allows the replication of the ingress.
+	BandwidthConstrainedReasonEgressSufficient string = "EgressSufficient"
+
+	// ConditionMigratedFromLegacyNaming indicates the cluster was created by
+	// an older operator and its finalizer was replaced with the current one.
+	ConditionMigratedFromLegacyNaming string = "MigratedFromLegacyNaming"
+	// MigratedFromLegacyNamingReasonFinalizerReplaced indicates the
+	// misspelled finalizer of older operators was replaced.
+	MigratedFromLegacyNamingReasonFinalizerReplaced string = "FinalizerReplaced"
+
+	// ConditionTLSPending indicates the Ingress of the gateway is served
+	// without TLS because the certificate Secret is not available yet.
+	ConditionTLSPending string = "TLSPending"
+	// TLSPendingReasonSecretNotFound indicates the certificate Secret does not
+	// exist or holds no certificate yet.
+	TLSPendingReasonSecretNotFound string = "SecretNotFound"
+	// TLSPendingReasonSecretReady indicates the Ingress serves the certificate.
+	TLSPendingReasonSecretReady string = "SecretReady"
+
+	// ConditionNoSyncWrites indicates the datastore of the peers does not
+	// sync its writes to disk, so that a crash of a node may lose the latest
+	// blocks of its peer or corrupt its repo.
+	ConditionNoSyncWrites string = "NoSyncWrites"
+	// NoSyncWritesReasonNoSyncSet indicates spec.datastore.noSync is set.
+	NoSyncWritesReasonNoSyncSet string = "NoSyncSet"
+
+	// ConditionSelectorOutdated indicates the selector of the StatefulSet,
+	// which is immutable, was created by an older operator with labels the
+	// current ones conflict with. The operator keeps the selector, and the
+	// pods keep the conflicting labels, until the StatefulSet is recreated
+	// through the recreate-statefulset annotation.
+	ConditionSelectorOutdated string = "SelectorOutdated"
+	// SelectorOutdatedReasonConflictingLabels indicates the selector matches
+	// labels with other values than those of the current operator.
+	SelectorOutdatedReasonConflictingLabels string = "ConflictingLabels"
+
+	// ConditionPeerServiceOutdated indicates the StatefulSet, whose Service
+	// is immutable, is still governed by the ipfs-cluster-<name> Service of
+	// older operators rather than the headless -swarm Service. The pods keep
+	// their DNS names under the former until the StatefulSet is recreated
+	// through the recreate-statefulset annotation.
+	ConditionPeerServiceOutdated string = "PeerServiceOutdated"
+	// PeerServiceOutdatedReasonLegacyService indicates the StatefulSet is
+	// governed by the legacy Service.
+	PeerServiceOutdatedReasonLegacyService string = "LegacyService"
+
+	// ConditionPeerCordoned indicates a peer is taken out of the cluster for
+	// maintenance through the cordon-peer annotation, or comes back from it.
+	ConditionPeerCordoned string = "PeerCordoned"
+	// PeerCordonedReasonDraining indicates the pins of the peer are being
+	// re-allocated to the other peers.
+	PeerCordonedReasonDraining string = "Draining"
+	// PeerCordonedReasonCordoned indicates the pins of the peer are
+	// replicated elsewhere and its pod is held off.
+	PeerCordonedReasonCordoned string = "Cordoned"
+	// PeerCordonedReasonRecovering indicates the peer was uncordoned and its
+	// pins are recovered once its pod is ready again.
+	PeerCordonedReasonRecovering string = "Recovering"
+	// PeerCordonedReasonInvalidOrdinal indicates the annotation does not name
+	// a peer which can be cordoned.
+	PeerCordonedReasonInvalidOrdinal string = "InvalidOrdinal"
+	// PeerCordonedReasonWebhooksDisabled indicates the cordon is refused, as
+	// the operator does not serve the webhook refusing the pods of the peer.
+	PeerCordonedReasonWebhooksDisabled string = "WebhooksDisabled"
+
+	// ConditionDeletionLosesData indicates deleting the cluster loses data
+	// for good, as the validating webhook warns kubectl delete. Its message
+	// lists the data lost.
+	ConditionDeletionLosesData string = "DeletionLosesData"
+	// DeletionLosesDataReasonNotRetained indicates the spec does not retain
+	// some data once the cluster is deleted.
+	DeletionLosesDataReasonNotRetained string = "NotRetained"
+
+	// ConditionDegraded indicates the cluster fails to serve content
+	// although its peers are ready, as found by the smoke test. Its message
+	// names the stage of the smoke test which failed.
+	ConditionDegraded string = "Degraded"
+	// DegradedReasonSmokeTestFailed indicates the last smoke test failed.
+	DegradedReasonSmokeTestFailed string = "SmokeTestFailed"
+	// DegradedReasonSmokeTestPassed indicates the last smoke test passed.
+	DegradedReasonSmokeTestPassed string = "SmokeTestPassed"
+)
+
+// Stages of the smoke test, in the order they run.
+const (
+	// SmokeTestStageAdd adds the payload through the kubo node of a peer.
+	SmokeTestStageAdd = "Add"
+	// SmokeTestStagePin pins the payload through the REST API of the cluster.
+	SmokeTestStagePin = "Pin"
+	// SmokeTestStageAllocations waits for every allocation of the pin to be pinned.
+	SmokeTestStageAllocations = "Allocations"
+	// SmokeTestStageFetch reads the payload back through another peer.
+	SmokeTestStageFetch = "Fetch"
+)
+
+// Modes of the RPC API exposed through the authenticating proxy.
+const (
+	// APIModeReadOnly only serves the commands reading content.
+	APIModeReadOnly = "ReadOnly"
+	// APIModeReadWrite serves every command.
+	APIModeReadWrite = "ReadWrite"
+)
+
+// Scopes of the credentials of the REST API of the cluster.
+const (
+	// APIScopeReadOnly lists pins, their status and the health of the
+	// cluster, without changing them.
+	APIScopeReadOnly = "ReadOnly"
+	// APIScopeAdmin sends any request.
+	APIScopeAdmin = "Admin"
+)
+
+// Backends of the datastore holding the blocks of the peers.
+const (
+	// DatastoreBackendLocal stores the blocks in the volume of each peer.
+	DatastoreBackendLocal = "local"
+	// DatastoreBackendS3 stores the blocks in an S3-compatible bucket.
+	DatastoreBackendS3 = "s3"
+)
+
+// Profiles captured through the capture-profile annotation.
+const (
+	// ProfileHeap samples the memory allocated by the daemons.
+	ProfileHeap = "heap"
+	// ProfileGoroutine lists the stacks of the goroutines of the daemons.
+	ProfileGoroutine = "goroutine"
+)
+
+// Modes of the logging of the daemons.
+const (
+	// LoggingModeStdout writes the logs of the daemons to the container logs.
+	LoggingModeStdout = "stdout"
+	// LoggingModeFile writes the logs of the daemons to a volume of the pod.
+	LoggingModeFile = "file"
+)
+
+const (
+	// AnnotationRotateSecret requests the rotation of the cluster secret.
+	// The operator removes the annotation once the rotation completes.
+	AnnotationRotateSecret = "ipfs.cluster.io/rotate-secret"
+	// AnnotationRotateIdentity requests a new identity for the peer with the
+	// given ordinal. The operator removes the annotation once the new
+	// identity joined the cluster.
+	AnnotationRotateIdentity = "ipfs.cluster.io/rotate-identity"
+	// AnnotationRunCtl requests a Job running ipfs-cluster-ctl with the given
+	// arguments against the cluster. The operator reports the outcome in an
+	// event and removes the annotation once the Job completes.
+	AnnotationRunCtl = "ipfs.cluster.io/run-ctl"
+	// AnnotationRotateAPICredentials requests new credentials of the given
+	// scope, Admin or ReadOnly, for the REST API of the cluster. The operator
+	// removes the annotation once the credentials are rotated.
+	AnnotationRotateAPICredentials = "ipfs.cluster.io/rotate-api-credentials"
+	// AnnotationCollectDiagnostics requests a diagnostics bundle of the
+	// cluster when set to "true". The operator writes the bundle to a new
+	// ConfigMap, names it in an event and removes the annotation.
+	AnnotationCollectDiagnostics = "ipfs.cluster.io/collect-diagnostics"
+	// AnnotationConfigHash is set on the pod template to the hash of the
+	// Secrets and ConfigMaps referenced by the spec, so that the pods roll
+	// whenever their contents change.
+	AnnotationConfigHash = "ipfs.cluster.io/config-hash"
+	// AnnotationDebugDiff logs the changes the operator makes to the objects
+	// of the cluster when set to "true".
+	AnnotationDebugDiff = "ipfs.cluster.io/debug-diff"
+	// AnnotationVerifyOnly audits the cluster instead of reconciling it when
+	// set to "true". The changes the operator would make are written to the
+	// audit ConfigMap of the cluster and summed up in the Drifted condition.
+	AnnotationVerifyOnly = "ipfs.cluster.io/verify-only"
+	// AnnotationSkipMaintenanceWindow lets the next disruptive operation
+	// start outside the maintenance window when set to "true". The operator
+	// removes the annotation once the operation started.
+	AnnotationSkipMaintenanceWindow = "ipfs.cluster.io/skip-maintenance-window"
+	// AnnotationRecreateStatefulSet lets the operator recreate the
+	// StatefulSet of the cluster when set to "true" while its selector or
+	// its Service is outdated. The StatefulSet is deleted without its pods,
+	// which are relabeled for the new StatefulSet to adopt them, then rolled.
+	// The operator removes the annotation once the StatefulSet is deleted.
+	AnnotationRecreateStatefulSet = "ipfs.cluster.io/recreate-statefulset"
+	// AnnotationRunSmokeTest runs the smoke test again when set to "true",
+	// once every peer is ready. The operator removes the annotation once the
+	// test completed.
+	AnnotationRunSmokeTest = "ipfs.cluster.io/run-smoke-test"
+	// AnnotationCaptureProfile requests a heap or goroutine profile of the
+	// daemons of every peer, as named by its value, while profiling is
+	// enabled. The operator writes the profiles to a new ConfigMap, names it
+	// in an event and removes the annotation.
+	AnnotationCaptureProfile = "ipfs.cluster.io/capture-profile"
+	// AnnotationCordonPeer takes the peer with the given ordinal out of the
+	// cluster for maintenance while the others keep running. Its pins are
+	// re-allocated, then its pod is deleted and held off, keeping its volumes
+	// and identity. Removing the annotation brings the peer back.
+	AnnotationCordonPeer = "ipfs.cluster.io/cordon-peer"
+	// PodConditionClusterMember is the readiness gate of the peer pods. The
+	// operator sets it once the peer joined the cluster peerset, so that the
+	// Services only route to the peers which are part of the cluster.
+	PodConditionClusterMember = "ipfs.cluster.io/cluster-member"
+	// LabelOwnerUID is set on every object the operator manages to the UID
+	// of the Ipfs resource it belongs to.
+	LabelOwnerUID = "ipfs.cluster.io/owner-uid"
 )
 
 type followParams struct {
@@ -40,23 +567,1844 @@ type networkConfig struct {
 	CircuitRelays int32 `json:"circuitRelays"`
 }
 
+// DNSConfig describes the external-dns records to request for the exposed endpoints.
+type DNSConfig struct {
+	// Hostname is the fully qualified name external-dns should register.
+	Hostname string `json:"hostname"`
+	// TTL is the TTL, in seconds, of the registered record.
+	// +optional
+	TTL *int64 `json:"ttl,omitempty"`
+	// Verify makes the operator resolve the hostname and report the DNSReady
+	// condition once it points at the exposed address.
+	// +optional
+	Verify bool `json:"verify,omitempty"`
+}
+
+// IssuerReference selects the cert-manager issuer of a certificate.
+type IssuerReference struct {
+	Name string `json:"name"`
+	// Kind is Issuer, for an issuer in the same namespace, or ClusterIssuer.
+	// +kubebuilder:default=Issuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// +kubebuilder:default=cert-manager.io
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// TLSConfig describes where the certificate of the gateway comes from.
+// Exactly one of SecretName and IssuerRef must be set.
+type TLSConfig struct {
+	// SecretName is the name of an existing kubernetes.io/tls Secret in the
+	// same namespace.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// IssuerRef makes the operator request a certificate from cert-manager,
+	// which stores it in the Secret ipfs-gateway-<name>-tls.
+	// +optional
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+}
+
+// OIDCConfig describes the OpenID Connect provider users of the gateway log in with.
+type OIDCConfig struct {
+	// IssuerURL is the URL of the OpenID Connect issuer.
+	IssuerURL string `json:"issuerURL"`
+	// ClientSecretRef names a Secret in the same namespace holding the
+	// client-id and client-secret keys of the OAuth client of the gateway.
+	ClientSecretRef corev1.LocalObjectReference `json:"clientSecretRef"`
+}
+
+// AuthConfig puts an authenticating proxy in front of the gateway. Exactly
+// one of BasicAuthSecretRef and OIDC must be set.
+type AuthConfig struct {
+	// BasicAuthSecretRef names a Secret in the same namespace holding
+	// htpasswd entries, hashed with bcrypt, under the key auth.
+	// +optional
+	BasicAuthSecretRef *corev1.LocalObjectReference `json:"basicAuthSecretRef,omitempty"`
+	// +optional
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+	// Image is the container image of the proxy, an oauth2-proxy release.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// SeedConfig describes the repo each peer starts from on its first boot.
+// Exactly one of ExistingClaimName and Image must be set.
+type SeedConfig struct {
+	// ExistingClaimName is the prefix of the PersistentVolumeClaims holding
+	// the repos to start from: the volume of the peer with ordinal N is
+	// cloned from the claim <existingClaimName>-N when it exists.
+	// +optional
+	ExistingClaimName string `json:"existingClaimName,omitempty"`
+	// Image is a container image holding a repo snapshot under /repo, along
+	// with sh and cp to copy it.
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// S3Datastore describes the bucket holding the blocks of the peers.
+type S3Datastore struct {
+	Bucket string `json:"bucket"`
+	Region string `json:"region"`
+	// Endpoint is the URL of an S3-compatible service. Defaults to AWS.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// RootDirectory is the prefix of the blocks in the bucket. Peers using
+	// the same prefix share their blocks.
+	// +optional
+	RootDirectory string `json:"rootDirectory,omitempty"`
+	// CredentialsSecretRef names a Secret in the same namespace holding the
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys.
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+}
+
+// DatastoreConfig describes where the peers store their blocks.
+type DatastoreConfig struct {
+	// Backend is local to store the blocks in the volume of each peer, or s3
+	// to store them in a bucket. It cannot change once the cluster is created.
+	// +kubebuilder:validation:Enum=local;s3
+	// +kubebuilder:default=local
+	// +optional
+	Backend string `json:"backend,omitempty"`
+	// S3 describes the bucket of the s3 backend.
+	// +optional
+	S3 *S3Datastore `json:"s3,omitempty"`
+	// NoSync stops the flatfs datastore of the repos initialized after it is
+	// set from syncing each write to disk. Adding blocks gets faster, but a
+	// crash of the node loses the latest writes and may corrupt the repo.
+	// The badger datastore never syncs its writes. Only for the local backend,
+	// along with flatfsShardingDepth.
+	// +optional
+	NoSync bool `json:"noSync,omitempty"`
+	// BadgerGCInterval runs the garbage collection of the repos at this
+	// interval, which also reclaims the space of the value log of the badger
+	// datastore. The garbage collection removes the blocks which are not
+	// pinned. Only for the local backend, on badger.
+	// +optional
+	BadgerGCInterval *metav1.Duration `json:"badgerGCInterval,omitempty"`
+	// FlatfsShardingDepth stores the blocks of the new repos in flatfs rather
+	// than badger, in directories named after the given number of characters
+	// before the last one of their key. Only for the local backend. It
+	// cannot change once the first repo is initialized.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4
+	// +optional
+	FlatfsShardingDepth *int32 `json:"flatfsShardingDepth,omitempty"`
+}
+
+// RepoLayout records how the repos of the peers are laid out, as of the
+// initialization of the first one.
+type RepoLayout struct {
+	// FlatfsShardingDepth is the sharding depth of the flatfs datastore of
+	// the repos, or zero for badger.
+	// +optional
+	FlatfsShardingDepth int32 `json:"flatfsShardingDepth,omitempty"`
+}
+
+// APIConfig describes how the RPC API of the IPFS daemons is exposed.
+type APIConfig struct {
+	// Mode is ReadOnly to only serve the commands reading content, such as
+	// cat, get and ls, or ReadWrite to serve every command.
+	// +kubebuilder:validation:Enum=ReadOnly;ReadWrite
+	// +kubebuilder:default=ReadOnly
+	// +optional
+	Mode string `json:"mode,omitempty"`
+	// CORS lets the pages of other origins call the RPC API. Without it,
+	// the kubo nodes only answer the pages of their own origin. Changing it
+	// restarts the peers.
+	// +optional
+	CORS *CORSConfig `json:"cors,omitempty"`
+}
+
+// CORSConfig describes the pages of other origins allowed to call an API.
+type CORSConfig struct {
+	// AllowedOrigins are the origins, such as https://app.example.com, whose
+	// pages may call the API. "*" allows every origin.
+	// +kubebuilder:validation:MinItems=1
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods are the HTTP methods those pages may call the API with.
+	// Defaults to POST, the only method the RPC API answers.
+	// +optional
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+}
+
+// ExposeConfig describes how the cluster is reached from outside of Kubernetes.
+type ExposeConfig struct {
+	// +optional
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// Host is the hostname of the Ingress serving the gateway. No Ingress is
+	// created when it is unset.
+	// +optional
+	Host string `json:"host,omitempty"`
+	// IngressClassName selects the ingress controller serving the gateway.
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+	// LegacyService keeps the ipfs-cluster-<name> Service, which served the
+	// swarm, the APIs and the gateway of the peers before they were split
+	// into the -swarm, -api and -gw Services, for the clients still using
+	// its name. It is kept regardless while the StatefulSet of the peers is
+	// governed by it. Defaults to true; deprecated, and to default to false
+	// in a future release.
+	// +optional
+	LegacyService *bool `json:"legacyService,omitempty"`
+	// TLS serves the gateway over HTTPS. It requires Host.
+	// +optional
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// Auth requires the users of the exposed gateway to authenticate.
+	// +optional
+	Auth *AuthConfig `json:"auth,omitempty"`
+}
+
+// ResourcesConfig describes the compute resources of the cluster containers.
+type ResourcesConfig struct {
+	// Limits describes the maximum amount of compute resources of the ipfs container.
+	// +optional
+	Limits corev1.ResourceList `json:"limits,omitempty"`
+	// Requests describes the minimum amount of compute resources of the ipfs container.
+	// +optional
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	// Cluster describes the compute resources of the ipfs-cluster container.
+	// +optional
+	Cluster *corev1.ResourceRequirements `json:"cluster,omitempty"`
+	// EphemeralStorage sizes the scratch space of the ipfs and ipfs-cluster
+	// containers, which keeps the peers from being evicted when their node
+	// runs low on disk.
+	// +optional
+	EphemeralStorage *EphemeralStorageConfig `json:"ephemeralStorage,omitempty"`
+}
+
+// EphemeralStorageConfig describes the ephemeral storage of each daemon container.
+type EphemeralStorageConfig struct {
+	// Request is the ephemeral storage each daemon container requests.
+	// Defaults to 256Mi.
+	// +optional
+	Request *resource.Quantity `json:"request,omitempty"`
+	// Limit is the ephemeral storage each daemon container may use before
+	// its pod is evicted. Defaults to 2Gi.
+	// +optional
+	Limit *resource.Quantity `json:"limit,omitempty"`
+}
+
+// PreflightConfig describes the network checks each peer runs before its
+// daemons start: that the Service of the cluster resolves, that the peers
+// serving it are reachable, and that the public network is reachable.
+type PreflightConfig struct {
+	// Disabled skips the checks.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+	// SkipOutbound skips checking that the public network is reachable, for
+	// clusters without outbound connectivity.
+	// +optional
+	SkipOutbound bool `json:"skipOutbound,omitempty"`
+}
+
+// NetworkAuditConfig describes the addresses the kubo nodes are expected to
+// announce.
+type NetworkAuditConfig struct {
+	// AuditAnnounceAddresses checks, on every status sync, that the kubo
+	// nodes only announce addresses within the pod CIDRs of the nodes, the
+	// addresses of the pods and Services of the cluster and the allowed
+	// ranges, and reports the others in the UnexpectedAnnounceAddresses
+	// condition. The configuration of the nodes is left as is.
+	// +optional
+	AuditAnnounceAddresses bool `json:"auditAnnounceAddresses,omitempty"`
+	// AllowedAnnounceCIDRs lists the other ranges the nodes may announce,
+	// such as the service CIDR of the cluster, as CIDRs or IP addresses.
+	// +optional
+	AllowedAnnounceCIDRs []string `json:"allowedAnnounceCIDRs,omitempty"`
+}
+
+// SmokeTestConfig describes the smoke test of the content path of the cluster.
+type SmokeTestConfig struct {
+	// Enabled runs the smoke test once every peer is ready, again after each
+	// rollout, and on demand through the run-smoke-test annotation. A small
+	// payload, the same for every run of the cluster, is added through a
+	// peer, pinned through the cluster, and read back through another peer.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// PartitionRecoveryConfig describes the pass recovering the pinset once the
+// peers reach each other again after a network partition.
+type PartitionRecoveryConfig struct {
+	// Disabled skips the pass.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// MonitoringConfig describes how Prometheus scrapes the gateway metrics of
+// the peers. They are only scraped while the gateway is exposed, through a
+// Service and a ServiceMonitor of the Prometheus operator.
+type MonitoringConfig struct {
+	// Interval is how often Prometheus scrapes the peers. Defaults to the
+	// interval of Prometheus.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ReproviderConfig describes how often the peers announce their content to
+// the DHT again. It applies when the peers restart.
+type ReproviderConfig struct {
+	// Interval is how often each peer announces its content again. Zero
+	// disables reproviding. Defaults to the interval of kubo, 12h.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ProvideConfig describes how the peers announce their content to the DHT,
+// whatever the reprovider strategy. It applies when the peers restart.
+type ProvideConfig struct {
+	// Workers is how many announcements each peer sends at once. It maps to
+	// Provider.WorkerCount, which kubo supports since v0.35: older releases
+	// announce one record at a time. Defaults to 16.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Workers *int32 `json:"workers,omitempty"`
+}
+
+// ProvidersConfig deploys dedicated provider nodes which take over announcing
+// the content of the cluster to the DHT from the peers. The pinset is sharded
+// between the provider nodes: each of them pins the root block of the items
+// of its shard directly, fetching it from the peers it is peered with, and
+// announces it.
+type ProvidersConfig struct {
+	// Replicas is the number of provider nodes, hence of shards.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1
+	Replicas int32 `json:"replicas"`
+	// Workers is how many announcements each provider node sends at once.
+	// Defaults to 64.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Workers *int32 `json:"workers,omitempty"`
+	// PeerWorkers is how many announcements each peer still sends at once,
+	// for the blocks below the roots. It takes precedence over
+	// provide.workers. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeerWorkers *int32 `json:"peerWorkers,omitempty"`
+	// Resources are the resources of the provider nodes.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// GatewayMode is where the gateway of a cluster runs.
+// +kubebuilder:validation:Enum=Deployment;DaemonSet
+type GatewayMode string
+
+const (
+	// GatewayModeDeployment serves the gateway from the peers.
+	GatewayModeDeployment GatewayMode = "Deployment"
+	// GatewayModeDaemonSet also runs a gateway node on every selected
+	// Kubernetes node, behind a Service routing the clients to the gateway
+	// of their own node.
+	GatewayModeDaemonSet GatewayMode = "DaemonSet"
+)
+
+// GatewayConfig places the gateway of a cluster.
+type GatewayConfig struct {
+	// Mode is Deployment, where the peers serve the gateway, or DaemonSet,
+	// where a lightweight kubo node peered with the peers serves it on every
+	// selected node. Defaults to Deployment.
+	// +kubebuilder:default=Deployment
+	// +optional
+	Mode GatewayMode `json:"mode,omitempty"`
+	// NodeSelector selects the nodes running a gateway node in DaemonSet
+	// mode. Defaults to every node.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations let the gateway nodes run on tainted nodes.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Resources are the resources of each gateway node. Defaults to requests
+	// of 50m of CPU and 128Mi of memory, and a limit of 512Mi of memory.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// HTTPHeaders are the headers the gateway adds to its responses, by
+	// name, such as Cache-Control. They take precedence over the headers
+	// allowAllOrigins sets. Changing them restarts the peers.
+	// +optional
+	HTTPHeaders map[string][]string `json:"httpHeaders,omitempty"`
+	// AllowAllOrigins lets the pages of any origin read the content the
+	// gateway serves, through the Access-Control-Allow-Origin: * header.
+	// Without it, browsers only let the pages of the origin of the gateway
+	// read its responses.
+	// +optional
+	AllowAllOrigins bool `json:"allowAllOrigins,omitempty"`
+}
+
+// KuboDNSConfig describes how the kubo nodes resolve DNS names, such as the
+// DNSLink names of the content served by the gateway. It applies when the
+// peers restart, which the changes trigger.
+type KuboDNSConfig struct {
+	// Resolvers maps domains, ending with a dot, to the DNS over HTTPS
+	// resolvers of their names. The "." domain sets the resolver of all the
+	// other names. Names of domains without a resolver are resolved by the
+	// resolver of the node.
+	// +optional
+	Resolvers map[string]string `json:"resolvers,omitempty"`
+	// DisableDNSLink stops the gateway from serving the content DNSLink
+	// names point at. Defaults to true for clusters without outbound
+	// connectivity, which skip the outbound preflight check, and to false
+	// otherwise.
+	// +optional
+	DisableDNSLink *bool `json:"disableDnslink,omitempty"`
+}
+
+// ClusterAPIConfig describes the access to the REST API of the cluster.
+type ClusterAPIConfig struct {
+	// CtlScope is the scope of the credentials bundled for ipfs-cluster-ctl,
+	// ReadOnly or Admin. Defaults to ReadOnly. Clusters whose operator cannot
+	// scope the credentials always bundle the Admin ones.
+	// +kubebuilder:validation:Enum=ReadOnly;Admin
+	// +optional
+	CtlScope string `json:"ctlScope,omitempty"`
+}
+
+// ServiceAccountConfig selects the ServiceAccount of the peers.
+type ServiceAccountConfig struct {
+	// Create makes the operator create and own the ServiceAccount. When
+	// false, the peers run as the existing ServiceAccount named by Name,
+	// which the operator leaves alone. Defaults to true.
+	// +optional
+	Create *bool `json:"create,omitempty"`
+	// Name is the name of the ServiceAccount. Defaults to ipfs-cluster-<name>
+	// when the operator creates it, and is required otherwise.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// RBAC grants the ServiceAccount access to objects of the cluster through
+	// a Role and a RoleBinding in its namespace. Every rule of the Role names
+	// the objects it applies to.
+	// +optional
+	RBAC *ServiceAccountRBAC `json:"rbac,omitempty"`
+}
+
+// ServiceAccountRBAC selects the objects of the cluster the peers may access.
+type ServiceAccountRBAC struct {
+	// Pods lets the peers get and annotate the pods of the cluster.
+	// +optional
+	Pods bool `json:"pods,omitempty"`
+	// ConfigMaps lets the peers read the bootstrap and scripts ConfigMaps of
+	// the cluster.
+	// +optional
+	ConfigMaps bool `json:"configMaps,omitempty"`
+	// Secrets lets the peers read the Secrets of the cluster, which hold the
+	// cluster secret and the identities of the peers.
+	// +optional
+	Secrets bool `json:"secrets,omitempty"`
+}
+
+// Routing types of kubo.
+const (
+	// RoutingTypeDHT nodes serve the DHT once reachable from the outside, and
+	// only query it otherwise.
+	RoutingTypeDHT = "dht"
+	// RoutingTypeDHTClient nodes only query the DHT.
+	RoutingTypeDHTClient = "dhtclient"
+	// RoutingTypeDHTServer nodes always serve the DHT.
+	RoutingTypeDHTServer = "dhtserver"
+	// RoutingTypeCustom nodes query the delegated routers of the spec.
+	RoutingTypeCustom = "custom"
+	// RoutingTypeNone nodes only find content and peers they are connected to.
+	RoutingTypeNone = "none"
+	// ReservedRouterName names the router through which kubo queries the
+	// delegated routers in parallel. No delegated router may take it.
+	ReservedRouterName = "parallel"
+)
+
+// RoutingConfig selects how the kubo nodes find content and peers.
+type RoutingConfig struct {
+	// Type is the routing type of kubo: dht, dhtclient, dhtserver, custom to
+	// query the delegated routers, or none.
+	// +kubebuilder:validation:Enum=dht;dhtclient;dhtserver;custom;none
+	Type string `json:"type"`
+	// Routers lists the delegated routers the nodes query over HTTP. They
+	// require the custom type.
+	// +optional
+	Routers []DelegatedRouter `json:"routers,omitempty"`
+}
+
+// DelegatedRouter is a router kubo delegates routing to over HTTP.
+type DelegatedRouter struct {
+	// Name is the name of the router in the routing configuration of kubo.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// Endpoint is the URL of the router, such as https://cid.contact.
+	Endpoint string `json:"endpoint"`
+	// AuthSecretRef selects the key of a Secret holding the user:password
+	// the nodes authenticate to the router with, URL-escaped.
+	// +optional
+	AuthSecretRef *corev1.SecretKeySelector `json:"authSecretRef,omitempty"`
+}
+
+// BootstrapConfig dedicates the first peers of the cluster to bootstrapping.
+type BootstrapConfig struct {
+	// Peers is the number of peers, counted from the first one, serving as
+	// bootstrap peers. They are listed first in the bootstrap configuration
+	// of every peer and in the bootstrap ConfigMap, and hold no pins.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=2
+	Peers int32 `json:"peers"`
+}
+
+// SwarmConfig tunes the swarm of the kubo nodes.
+type SwarmConfig struct {
+	// Transports adds the listeners browsers can dial to the TCP and QUIC
+	// ones of the peers.
+	// +optional
+	Transports *SwarmTransports `json:"transports,omitempty"`
+	// ResourceManager bounds the connections, streams and memory of the
+	// libp2p resource manager of the peers. It requires kubo v0.19 or later.
+	// +optional
+	ResourceManager *ResourceManagerConfig `json:"resourceManager,omitempty"`
+}
+
+// Policies of the volume claims of the peers once the cluster is deleted.
+const (
+	// VolumeReclaimRetain keeps the volume claims of a deleted cluster.
+	VolumeReclaimRetain = "Retain"
+	// VolumeReclaimDelete deletes the volume claims along with the cluster.
+	VolumeReclaimDelete = "Delete"
+)
+
+// Roles of the peers, which select the preset limits of their resource manager.
+const (
+	// PeerRoleGateway peers serve the public, and are dialed by the whole
+	// DHT: they hold few connections and streams.
+	PeerRoleGateway = "Gateway"
+	// PeerRoleStorage peers transfer content within the cluster: they hold
+	// many streams.
+	PeerRoleStorage = "Storage"
+)
+
+// ResourceManagerConfig bounds the libp2p resource manager of the kubo nodes.
+// Unset limits are those of the preset of the role of the peers.
+type ResourceManagerConfig struct {
+	// Role selects the preset limits of the peers. Defaults to Gateway when
+	// the gateway is public, and to Storage otherwise.
+	// +kubebuilder:validation:Enum=Gateway;Storage
+	// +optional
+	Role string `json:"role,omitempty"`
+	// MaxConnections is the number of connections a peer may hold. Half of
+	// them may be inbound.
+	// +kubebuilder:validation:Minimum=8
+	// +optional
+	MaxConnections *int32 `json:"maxConnections,omitempty"`
+	// MaxStreams is the number of streams a peer may hold. Half of them may
+	// be inbound.
+	// +kubebuilder:validation:Minimum=8
+	// +optional
+	MaxStreams *int32 `json:"maxStreams,omitempty"`
+	// MemoryPercent is the share of the memory limit of the ipfs container,
+	// in percent, the resource manager may reserve. Peers without a memory
+	// limit keep the default of kubo.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MemoryPercent *int32 `json:"memoryPercent,omitempty"`
+}
+
+// SwarmTransports selects the transports the peers listen on besides TCP and QUIC.
+type SwarmTransports struct {
+	// +optional
+	WebSocket *WebSocketTransport `json:"websocket,omitempty"`
+	// +optional
+	WebTransport *WebTransportTransport `json:"webtransport,omitempty"`
+}
+
+// WebSocketTransport describes the WebSocket listener of the peers.
+type WebSocketTransport struct {
+	// Enabled makes the peers listen for WebSocket connections.
+	Enabled bool `json:"enabled"`
+	// Port is the TCP port of the listener. Defaults to 8081.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// Public serves the listener of each peer as secure WebSockets through
+	// an Ingress, at the host <pod>.<expose.host>, and announces it. It
+	// requires expose.host and expose.tls, whose certificate must cover the
+	// hosts of the peers.
+	// +optional
+	Public bool `json:"public,omitempty"`
+}
+
+// WebTransportTransport describes the WebTransport listener of the peers.
+type WebTransportTransport struct {
+	// Enabled makes the peers listen for WebTransport sessions. It requires
+	// kubo v0.18 or later.
+	Enabled bool `json:"enabled"`
+	// Port is the UDP port of the listener. Defaults to 4002.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+// FederationConfig describes how the cluster exchanges its peers with the
+// clusters running in other Kubernetes clusters, through signed peer bundles
+// copied between them.
+type FederationConfig struct {
+	// Export writes the signed bundle of the peers of the cluster to the
+	// Secret <name>-federation, to be copied to the federated clusters.
+	// +optional
+	Export bool `json:"export,omitempty"`
+	// BundleValidity is how long an exported bundle is valid. The bundle is
+	// signed again once half of it passed, or as soon as its peers change.
+	// Defaults to 24h.
+	// +optional
+	BundleValidity *metav1.Duration `json:"bundleValidity,omitempty"`
+	// ImportSecretRefs are the Secrets holding the bundles of the federated
+	// clusters, under the key bundle.json. Their peers are added to the
+	// peering and bootstrap configuration of the kubo nodes.
+	// +optional
+	ImportSecretRefs []corev1.LocalObjectReference `json:"importSecretRefs,omitempty"`
+	// TrustedSigners are the peer IDs allowed to sign the imported bundles.
+	// Bundles signed by any valid key are imported when it is empty.
+	// +optional
+	TrustedSigners []string `json:"trustedSigners,omitempty"`
+}
+
+// DebugConfig describes the troubleshooting aids of a cluster.
+type DebugConfig struct {
+	// ExportResolvedConfig writes the spec after defaulting, the kubo and
+	// ipfs-cluster configuration of the peers and the build of the operator
+	// to the ConfigMap <name>-resolved, kept up to date while it is set. The
+	// secret material is replaced by fingerprints.
+	// +optional
+	ExportResolvedConfig bool `json:"exportResolvedConfig,omitempty"`
+	// EnableProfiling exposes the pprof endpoints of kubo and ipfs-cluster
+	// on the pprof and cluster-pprof ports of the peers, behind a
+	// NetworkPolicy only letting in the operator and the pods matched by
+	// profilingSources. It cannot be set while the cluster is exposed
+	// through an Ingress.
+	// +optional
+	EnableProfiling bool `json:"enableProfiling,omitempty"`
+	// ProfilingSources selects the pods of the namespace of the cluster
+	// allowed to reach the pprof endpoints. Only the operator reaches them
+	// when it is unset.
+	// +optional
+	ProfilingSources *metav1.LabelSelector `json:"profilingSources,omitempty"`
+}
+
+// LoggingConfig describes where the daemons write their logs.
+type LoggingConfig struct {
+	// Mode is stdout to write the logs of the daemons to the container logs,
+	// or file to write them to a volume of the pod, rotated by a sidecar.
+	// +kubebuilder:validation:Enum=stdout;file
+	// +kubebuilder:default=stdout
+	// +optional
+	Mode string `json:"mode,omitempty"`
+	// SizeLimit is the size of the volume holding the logs in file mode.
+	// Defaults to 256Mi.
+	// +optional
+	SizeLimit *resource.Quantity `json:"sizeLimit,omitempty"`
+}
+
+// UpdateStrategy describes how the peers are restarted when the spec changes.
+type UpdateStrategy struct {
+	// Type is RollingUpdate to restart the peers one at a time, or OnDelete to
+	// restart them only when their pods are deleted.
+	// +kubebuilder:validation:Enum=RollingUpdate;OnDelete
+	// +kubebuilder:default=RollingUpdate
+	// +optional
+	Type string `json:"type,omitempty"`
+	// Partition makes a rolling update restart only the peers with an ordinal
+	// greater than or equal to it.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Partition *int32 `json:"partition,omitempty"`
+}
+
+// PeerOverride changes the storage, placement or resources of some peers.
+type PeerOverride struct {
+	// Ordinals lists the peers the override applies to, as ordinals such as
+	// "0" or inclusive ranges of ordinals such as "2-4".
+	// +kubebuilder:validation:MinItems=1
+	Ordinals []string `json:"ordinals"`
+	// IpfsStorage is the size of the volume holding the repo of the peers,
+	// instead of ipfsStorage. It applies to the volumes created after it is set.
+	// +optional
+	IpfsStorage string `json:"ipfsStorage,omitempty"`
+	// StorageClassName is the storage class of the volume holding the repo of
+	// the peers, instead of storageClassName. It applies to the volumes
+	// created after it is set.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// NodeSelector is added to the node selector of the pods of the peers.
+	// It requires the webhooks of the operator.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Resources replaces the limits and requests of the ipfs container of the
+	// peers. It requires the webhooks of the operator.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// RolloutConfig describes how fast a rolling update moves from one peer to
+// the next.
+type RolloutConfig struct {
+	// MinReadySeconds is how long a restarted peer must be ready before it
+	// counts as available and the StatefulSet restarts the next one.
+	// Defaults to 0.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty"`
+	// PauseBetweenPods is how long the operator waits, once a restarted peer
+	// is available, before letting the next one restart, so that it has time
+	// to announce its content again. Defaults to 0.
+	// +optional
+	PauseBetweenPods *metav1.Duration `json:"pauseBetweenPods,omitempty"`
+	// Hooks runs Jobs of your own around the rollouts, which halt the
+	// rollout when they fail. They only run with the RollingUpdate strategy.
+	// +optional
+	Hooks *RolloutHooks `json:"hooks,omitempty"`
+}
+
+// RolloutHooks describes the Jobs run around the rollouts of the peers.
+type RolloutHooks struct {
+	// PreUpgrade runs once before the first peer of a rollout restarts.
+	// +optional
+	PreUpgrade *RolloutHook `json:"preUpgrade,omitempty"`
+	// PostPodUpdate runs after each peer restarted with the new revision and
+	// has been available for minReadySeconds and the pause, before the next
+	// peer restarts.
+	// +optional
+	PostPodUpdate *RolloutHook `json:"postPodUpdate,omitempty"`
+	// Timeout is how long a hook Job may run before it fails. Defaults to
+	// 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// TTLAfterFinished is how long the hook Jobs are kept once finished.
+	// Defaults to 1h.
+	// +optional
+	TTLAfterFinished *metav1.Duration `json:"ttlAfterFinished,omitempty"`
+}
+
+// RolloutHook describes the container of a hook Job. It is given the
+// ROLLOUT_HOOK, ROLLOUT_REVISION and, after a peer restarted, ROLLOUT_PEER
+// environment variables.
+type RolloutHook struct {
+	// Image is the image the hook runs.
+	// +kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
+	// Command replaces the entrypoint of the image.
+	// +optional
+	Command []string `json:"command,omitempty"`
+	// Env adds environment variables to the hook.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// MaintenanceWindow describes when disruptive operations may start.
+type MaintenanceWindow struct {
+	// Schedules list when the windows open, as cron expressions with five
+	// fields: minute, hour, day of month, month and day of week, such as
+	// "0 2 * * SAT" for every Saturday at 2am.
+	// +kubebuilder:validation:MinItems=1
+	Schedules []string `json:"schedules"`
+	// Duration is how long each window stays open.
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the IANA time zone of the schedules, such as
+	// "Europe/Paris". Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Autoscaling describes how the number of peers follows the repo utilization.
+type Autoscaling struct {
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas int32 `json:"minReplicas"`
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetUtilization is the share of StorageMax, in percent, the repos of
+	// the peers should use on average.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=70
+	// +optional
+	TargetUtilization int32 `json:"targetUtilization,omitempty"`
+	// ScaleUpCooldown is the time to wait after scaling before adding peers.
+	// +kubebuilder:default="5m"
+	// +optional
+	ScaleUpCooldown metav1.Duration `json:"scaleUpCooldown,omitempty"`
+	// ScaleDownCooldown is the time to wait after scaling before removing peers.
+	// +kubebuilder:default="30m"
+	// +optional
+	ScaleDownCooldown metav1.Duration `json:"scaleDownCooldown,omitempty"`
+}
+
+// AutoscalingStatus records the decisions of the autoscaler.
+type AutoscalingStatus struct {
+	// Replicas is the number of peers the autoscaler runs.
+	Replicas int32 `json:"replicas"`
+	// Utilization is the share of StorageMax used by the repos of all the
+	// peers, in percent, as of the last decision.
+	// +optional
+	Utilization int32 `json:"utilization,omitempty"`
+	// LastScaleTime is when the autoscaler last changed the number of peers.
+	// +optional
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+}
+
 type IpfsSpec struct {
-	URL            string         `json:"url"`
-	Public         bool           `json:"public"`
-	IpfsStorage    string         `json:"ipfsStorage"`
-	ClusterStorage string         `json:"clusterStorage"`
-	Replicas       int32          `json:"replicas"`
-	Networking     networkConfig  `json:"networking"`
-	Follows        []followParams `json:"follows"`
+	URL            string `json:"url"`
+	Public         bool   `json:"public"`
+	IpfsStorage    string `json:"ipfsStorage"`
+	ClusterStorage string `json:"clusterStorage"`
+	// Replicas is the number of peers. It must be left unset when autoscaling is set.
+	// +optional
+	Replicas   int32          `json:"replicas,omitempty"`
+	Networking networkConfig  `json:"networking"`
+	Follows    []followParams `json:"follows"`
+	// +optional
+	Expose ExposeConfig `json:"expose,omitempty"`
+	// +optional
+	Resources ResourcesConfig `json:"resources,omitempty"`
+	// IpfsImage is the container image running the IPFS daemon.
+	// +optional
+	IpfsImage string `json:"ipfsImage,omitempty"`
+	// ClusterImage is the container image running the IPFS Cluster daemon.
+	// +optional
+	ClusterImage string `json:"clusterImage,omitempty"`
+	// StorageClassName is the storage class of the persistent volumes. It
+	// cannot change once the cluster is created.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// VolumeReclaimPolicy is Retain to keep the volume claims of the peers
+	// once the cluster is deleted, or Delete to have them garbage collected
+	// along with the cluster. Defaults to Retain.
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +optional
+	VolumeReclaimPolicy string `json:"volumeReclaimPolicy,omitempty"`
+	// PodManagementPolicy is OrderedReady to start the peers one after the
+	// other, or Parallel to start them all at once. Changing it recreates the
+	// StatefulSet while keeping the pods running.
+	// +kubebuilder:validation:Enum=OrderedReady;Parallel
+	// +optional
+	PodManagementPolicy string `json:"podManagementPolicy,omitempty"`
+	// +optional
+	UpdateStrategy *UpdateStrategy `json:"updateStrategy,omitempty"`
+	// Rollout paces the rolling updates of the peers.
+	// +optional
+	Rollout *RolloutConfig `json:"rollout,omitempty"`
+	// MaintenanceWindow restricts when the rollouts of the peers and the
+	// rotations of the cluster secret and of the peer identities start. The
+	// operations already started go on once the window closes. Operations
+	// may start at any time when unset.
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+	// Overrides change the storage, placement or resources of some peers,
+	// selected by ordinal. A peer may be selected by a single override.
+	// +optional
+	Overrides []PeerOverride `json:"overrides,omitempty"`
+	// PriorityClassName is the priority class of the pods of the peers, so
+	// that they outrank the batch workloads sharing their nodes.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// SecurityProfile makes the security contexts of the pods of the peers
+	// satisfy a Pod Security Standard: restricted runs them as a non-root
+	// user without privileges, baseline only forbids privilege escalation,
+	// and custom sets no security context, leaving it to the
+	// SecurityContextConstraints or webhooks of the cluster. Defaults to
+	// custom.
+	// +kubebuilder:validation:Enum=restricted;baseline;custom
+	// +optional
+	SecurityProfile string `json:"securityProfile,omitempty"`
+	// AdoptNewDefaults makes the cluster resolve the fields it leaves empty
+	// against the current built-in defaults of the operator, rather than
+	// those it was created with. Changed built-in defaults roll the peers.
+	// +optional
+	AdoptNewDefaults bool `json:"adoptNewDefaults,omitempty"`
+	// SafeToEvict tells the cluster autoscaler whether it may evict the pods
+	// of the peers to remove their nodes. Defaults to false when the storage
+	// class of the peers provisions volumes bound to a node, which the pods
+	// cannot follow to another node, and to the autoscaler default otherwise.
+	// +optional
+	SafeToEvict *bool `json:"safeToEvict,omitempty"`
+	// Env lists extra environment variables set in the ipfs and ipfs-cluster
+	// containers. They take precedence over the variables set by the operator.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// StoragePressureThreshold is the share of StorageMax, in percent, a peer
+	// repo may fill before the StoragePressure condition is set.
+	// Defaults to 85.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	StoragePressureThreshold *int32 `json:"storagePressureThreshold,omitempty"`
+	// Autoscaling adds and removes peers to keep the repo utilization near a target.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+	// API serves the RPC API of the IPFS daemons under /api/v0 of the
+	// exposed gateway. It requires expose.auth.
+	// +optional
+	API *APIConfig `json:"api,omitempty"`
+	// Seed fills the repo of each peer from existing data on its first boot.
+	// It cannot change once the cluster is created.
+	// +optional
+	Seed *SeedConfig `json:"seed,omitempty"`
+	// CloneFrom is the name of an Ipfs resource of the namespace the cluster
+	// takes over: its identity, its cluster secret and the volumes of its
+	// peers. The source must be deleted, or set to verify-only so that the
+	// operator leaves it alone. It cannot change once the cluster is created.
+	// +optional
+	CloneFrom string `json:"cloneFrom,omitempty"`
+	// Datastore selects where the peers store their blocks. The s3 backend
+	// requires an ipfsImage bundling the go-ds-s3 plugin.
+	// +optional
+	Datastore *DatastoreConfig `json:"datastore,omitempty"`
+	// Cluster tunes the ipfs-cluster daemons of the peers.
+	// +optional
+	Cluster *ClusterConfig `json:"cluster,omitempty"`
+	// Logging selects where the daemons write their logs.
+	// +optional
+	Logging *LoggingConfig `json:"logging,omitempty"`
+	// Preflight checks the network of each peer before its daemons start.
+	// +optional
+	Preflight *PreflightConfig `json:"preflight,omitempty"`
+	// Monitoring scrapes the gateway metrics of the peers while the gateway
+	// is exposed, and sums them up in the status.
+	// +optional
+	Monitoring *MonitoringConfig `json:"monitoring,omitempty"`
+	// Reprovider tunes how often the peers announce their content again.
+	// +optional
+	Reprovider *ReproviderConfig `json:"reprovider,omitempty"`
+	// Provide tunes how the peers announce their content.
+	// +optional
+	Provide *ProvideConfig `json:"provide,omitempty"`
+	// Providers deploys dedicated provider nodes announcing the content of
+	// the cluster in place of the peers.
+	// +optional
+	Providers *ProvidersConfig `json:"providers,omitempty"`
+	// Gateway places the gateway of the cluster and sets the headers of its
+	// responses.
+	// +optional
+	Gateway *GatewayConfig `json:"gateway,omitempty"`
+	// DNS tunes how the kubo nodes resolve DNS names.
+	// +optional
+	DNS *KuboDNSConfig `json:"dns,omitempty"`
+	// Swarm tunes the transports the peers listen on, such as those browsers
+	// dial, and the limits of their resource manager. Changing it restarts
+	// the peers.
+	// +optional
+	Swarm *SwarmConfig `json:"swarm,omitempty"`
+	// Routing selects how the kubo nodes find content and peers. Changing it
+	// restarts the peers.
+	// +optional
+	Routing *RoutingConfig `json:"routing,omitempty"`
+	// Bootstrap dedicates the first peers of the cluster to bootstrapping
+	// the other peers and external nodes, behind Services with stable DNS
+	// names.
+	// +optional
+	Bootstrap *BootstrapConfig `json:"bootstrap,omitempty"`
+	// ClusterAPI describes the access to the REST API of the cluster.
+	// +optional
+	ClusterAPI *ClusterAPIConfig `json:"clusterAPI,omitempty"`
+	// ServiceAccount selects the ServiceAccount the peers run as and what it
+	// may access.
+	// +optional
+	ServiceAccount *ServiceAccountConfig `json:"serviceAccount,omitempty"`
+	// Federation meshes the peers with the peers of clusters running in
+	// other Kubernetes clusters.
+	// +optional
+	Federation *FederationConfig `json:"federation,omitempty"`
+	// Network audits the addresses the kubo nodes announce.
+	// +optional
+	Network *NetworkAuditConfig `json:"network,omitempty"`
+	// Peering lists the multiaddresses of the peers outside the cluster the
+	// kubo nodes stay connected to, each ending with /p2p/ and the peer ID.
+	// +optional
+	Peering []string `json:"peering,omitempty"`
+	// PartitionRecovery tunes the pass recovering the pinset once the mesh
+	// heals from a partition.
+	// +optional
+	PartitionRecovery *PartitionRecoveryConfig `json:"partitionRecovery,omitempty"`
+	// SmokeTest checks that the cluster serves content once its peers are
+	// ready.
+	// +optional
+	SmokeTest *SmokeTestConfig `json:"smokeTest,omitempty"`
+	// Debug holds the troubleshooting aids of the cluster.
+	// +optional
+	Debug *DebugConfig `json:"debug,omitempty"`
+	// GC tunes the garbage collection of the repos of the peers.
+	// +optional
+	GC *GCConfig `json:"gc,omitempty"`
+	// Bandwidth caps the traffic of the peers and tunes how their kubo nodes
+	// send blocks. Changing it restarts the peers.
+	// +optional
+	Bandwidth *BandwidthConfig `json:"bandwidth,omitempty"`
+}
+
+// BandwidthConfig caps the traffic of the peers, which otherwise saturates
+// the network of their nodes while large pins replicate. Kubo cannot limit
+// its transfer rate, so the caps are applied to the network of the pods by
+// the bandwidth CNI plugin, and the bitswap engine of the kubo nodes is
+// tuned to spread the blocks it sends among the nodes asking for them. The
+// transfers of ipfs-cluster are bounded by spec.cluster.pinTracker.concurrentPins.
+type BandwidthConfig struct {
+	// Ingress caps the inbound traffic of each peer, in bits per second,
+	// such as 100M. It requires the bandwidth CNI plugin on the nodes.
+	// +optional
+	Ingress *resource.Quantity `json:"ingress,omitempty"`
+	// Egress caps the outbound traffic of each peer, in bits per second,
+	// such as 100M. It requires the bandwidth CNI plugin on the nodes.
+	// +optional
+	Egress *resource.Quantity `json:"egress,omitempty"`
+	// EngineWorkers is how many blocks the kubo node of each peer sends to
+	// other nodes at once. Defaults to 8. It requires kubo v0.13 or later.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	EngineWorkers *int32 `json:"engineWorkers,omitempty"`
+	// MaxOutstandingBytesPerPeer is how much data the kubo node of each peer
+	// queues for a single other node before serving the others. Defaults to
+	// 1Mi. It requires kubo v0.13 or later.
+	// +optional
+	MaxOutstandingBytesPerPeer *resource.Quantity `json:"maxOutstandingBytesPerPeer,omitempty"`
+}
+
+// GCConfig tunes the garbage collection of the repos of the peers, which
+// otherwise only runs once a repo reaches its storage limit.
+type GCConfig struct {
+	// AfterUnpin collects the repo of every peer, one peer at a time, once
+	// the IpfsContent resources of the cluster unpinned content and no other
+	// unpin followed for the quiet period.
+	// +optional
+	AfterUnpin bool `json:"afterUnpin,omitempty"`
+	// QuietPeriod is how long after the last unpin a pass starts, so that
+	// the unpins made meanwhile are collected by a single pass.
+	// Defaults to 10m.
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// +optional
+	QuietPeriod *metav1.Duration `json:"quietPeriod,omitempty"`
+}
+
+// ClusterConfig tunes the ipfs-cluster daemons. Empty fields keep the
+// defaults of ipfs-cluster. Changing a field restarts the peers.
+type ClusterConfig struct {
+	// MonitorPingInterval is how often each peer signals the others it is
+	// alive. Peers missing their pings are detected as down sooner with a
+	// shorter interval. Defaults to 15s.
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// +optional
+	MonitorPingInterval *metav1.Duration `json:"monitorPingInterval,omitempty"`
+	// PinRecoverInterval is how often the pins in error are retried.
+	// Defaults to 12m.
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// +optional
+	PinRecoverInterval *metav1.Duration `json:"pinRecoverInterval,omitempty"`
+	// +optional
+	PinTracker *PinTrackerConfig `json:"pinTracker,omitempty"`
+	// +optional
+	Informer *InformerConfig `json:"informer,omitempty"`
+	// +optional
+	Allocator *AllocatorConfig `json:"allocator,omitempty"`
+	// +optional
+	CRDT *CRDTConfig `json:"crdt,omitempty"`
+}
+
+// CRDTConfig tunes the CRDT consensus of the peers, whose pinset is a DAG of
+// every update growing for the life of the cluster.
+type CRDTConfig struct {
+	// BatchingMaxAge is how long the peers gather pinset updates into a
+	// single one before publishing it. Set along with batchingMaxSize.
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// +optional
+	BatchingMaxAge *metav1.Duration `json:"batchingMaxAge,omitempty"`
+	// BatchingMaxSize is how many pinset updates the peers gather into a
+	// single one before publishing it. Set along with batchingMaxAge.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	BatchingMaxSize *int32 `json:"batchingMaxSize,omitempty"`
+	// CompactSchedule compacts the state of the peers when it starts, as a
+	// cron expression with five fields in UTC, such as "0 3 * * SUN". Each
+	// peer leaves the cluster in turn while a Job rebuilds its state from
+	// its pinset. Compactions start within the maintenance window.
+	// +optional
+	CompactSchedule string `json:"compactSchedule,omitempty"`
+}
+
+// PinTrackerConfig tunes the pin tracker of the peers.
+type PinTrackerConfig struct {
+	// ConcurrentPins is how many pins each peer fetches at once.
+	// Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ConcurrentPins *int32 `json:"concurrentPins,omitempty"`
+}
+
+// InformerConfig tunes the informers publishing the metrics of each peer.
+type InformerConfig struct {
+	// +optional
+	Freespace *FreespaceInformerConfig `json:"freespace,omitempty"`
+}
+
+// FreespaceInformerConfig tunes the informer publishing the free space of
+// the repo of each peer, which the allocator places pins by.
+type FreespaceInformerConfig struct {
+	// MetricTTL is how long the free space published by a peer is valid.
+	// Peers publish it again before it expires. Defaults to 30s.
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	// +optional
+	MetricTTL *metav1.Duration `json:"metricTTL,omitempty"`
+}
+
+// AllocatorConfig tunes how the peers holding a pin are chosen.
+type AllocatorConfig struct {
+	// Order lists the metrics the peers are sorted by, the first one
+	// grouping them before the next ones are compared, such as tag:group to
+	// spread pins over the groups of peers before picking the peers with
+	// the most free space. Defaults to tag:group then freespace.
+	// +kubebuilder:validation:MinItems=1
+	// +optional
+	Order []string `json:"order,omitempty"`
+}
+
+// ClusterConfigStatus records the values the ipfs-cluster daemons run with.
+type ClusterConfigStatus struct {
+	MonitorPingInterval metav1.Duration `json:"monitorPingInterval"`
+	PinRecoverInterval  metav1.Duration `json:"pinRecoverInterval"`
+	ConcurrentPins      int32           `json:"concurrentPins"`
+	FreespaceMetricTTL  metav1.Duration `json:"freespaceMetricTTL"`
+	AllocatorOrder      []string        `json:"allocatorOrder"`
+}
+
+// SecretRotationStatus records the progress of cluster secret rotations.
+type SecretRotationStatus struct {
+	// StartedAt is when the rotation in progress started.
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// LastRotationTime is when the cluster secret was last rotated successfully.
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+}
+
+// IdentityRotation records the replacement of a peer identity.
+type IdentityRotation struct {
+	// +optional
+	OldID string      `json:"oldID,omitempty"`
+	NewID string      `json:"newID"`
+	Time  metav1.Time `json:"time"`
+}
+
+// PeerStatus describes a single cluster peer.
+type PeerStatus struct {
+	// Name is the name of the pod running the peer.
+	Name string `json:"name"`
+	// NodeName is the name of the node the pod of the peer is scheduled on.
+	// +optional
+	NodeName string `json:"nodeName,omitempty"`
+	// ID is the ipfs-cluster peer ID of the peer, from the identity the
+	// operator stores for it.
+	// +optional
+	ID string `json:"id,omitempty"`
+	// KuboID is the peer ID of the kubo node of the peer, which is distinct
+	// from its ipfs-cluster peer ID.
+	// +optional
+	KuboID string `json:"kuboID,omitempty"`
+	// History lists the latest identity rotations of the peer, oldest first.
+	// +optional
+	History []IdentityRotation `json:"history,omitempty"`
+	// IpfsImage is the image the ipfs container of the peer runs.
+	// +optional
+	IpfsImage string `json:"ipfsImage,omitempty"`
+	// ClusterImage is the image the ipfs-cluster container of the peer runs.
+	// +optional
+	ClusterImage string `json:"clusterImage,omitempty"`
+	// ConfigHash is the hash of the referenced configuration the peer was started with.
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+	// Revision is the StatefulSet revision of the pod running the peer.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+	// Repo is the usage of the kubo repo of the peer, as of its last check.
+	// +optional
+	Repo *RepoUsage `json:"repo,omitempty"`
+	// Provide is how the peer announces its content, as of its last check.
+	// +optional
+	Provide *ProvideStatus `json:"provide,omitempty"`
+	// ResourceLimits is what the resource manager of the kubo node of the
+	// peer enforces, as of its last check.
+	// +optional
+	ResourceLimits *ResourceLimits `json:"resourceLimits,omitempty"`
+	// Bandwidth is the traffic of the kubo node of the peer, as of its last
+	// check.
+	// +optional
+	Bandwidth *PeerBandwidth `json:"bandwidth,omitempty"`
+	// Seeded is true once the seed container of the peer completed.
+	// +optional
+	Seeded bool `json:"seeded,omitempty"`
+	// RepoInitialized is true once the repo of the peer was initialized.
+	// +optional
+	RepoInitialized bool `json:"repoInitialized,omitempty"`
+	// Compaction is the last compaction of the CRDT state of the peer.
+	// +optional
+	Compaction *PeerCompaction `json:"compaction,omitempty"`
+	// ConnectedPeers is the number of the other ready peers the kubo node of
+	// the peer is connected to, as of the last mesh check.
+	// +optional
+	ConnectedPeers *int32 `json:"connectedPeers,omitempty"`
+	// Storage is the volume holding the repo of the peer, as claimed.
+	// +optional
+	Storage *PeerStorage `json:"storage,omitempty"`
+	// BrowserAddresses lists the multiaddresses browsers dial the kubo node
+	// of the peer at: its public secure WebSocket address and the
+	// WebTransport addresses it reports, as of the last mesh check.
+	// +optional
+	BrowserAddresses []string `json:"browserAddresses,omitempty"`
+	// Swarm lists the multiaddresses of the kubo node of the peer, as of
+	// the last mesh check.
+	// +optional
+	Swarm *SwarmAddresses `json:"swarm,omitempty"`
+	// Cordon is how far the cordon of the peer got, while the cordon-peer
+	// annotation names it or until it caught up after being uncordoned.
+	// +kubebuilder:validation:Enum=Draining;Cordoned;Recovering
+	// +optional
+	Cordon CordonState `json:"cordon,omitempty"`
 }
 
+// CordonState is how far the cordon of a peer got.
+type CordonState string
+
+// States of the cordon of a peer.
+const (
+	// CordonDraining is set while the pins of the peer are re-allocated.
+	CordonDraining CordonState = "Draining"
+	// CordonCordoned is set once the pins of the peer are replicated on the
+	// other peers, while its pod is held off.
+	CordonCordoned CordonState = "Cordoned"
+	// CordonRecovering is set once the peer is uncordoned, until its pod is
+	// ready and its pins were recovered.
+	CordonRecovering CordonState = "Recovering"
+)
+
+// SwarmAddresses lists the multiaddresses of the kubo node of a peer.
+type SwarmAddresses struct {
+	// Listen lists the multiaddresses the node listens on.
+	// +optional
+	Listen []string `json:"listen,omitempty"`
+	// Announce lists the multiaddresses the node announces to its peers.
+	// +optional
+	Announce []string `json:"announce,omitempty"`
+}
+
+// PeerStorage describes the volume holding the repo of a peer.
+type PeerStorage struct {
+	// Size is the capacity of the volume, or its requested size until it is bound.
+	Size resource.Quantity `json:"size"`
+	// StorageClassName is the storage class of the volume.
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+}
+
+// MeshStatus records the checks of the connections between the kubo nodes
+// of the peers.
+type MeshStatus struct {
+	// CheckedAt is the time of the last mesh check.
+	CheckedAt metav1.Time `json:"checkedAt"`
+	// IncompleteSince is the time the mesh was first found incomplete, unset
+	// while every ready peer is connected to every other one.
+	// +optional
+	IncompleteSince *metav1.Time `json:"incompleteSince,omitempty"`
+}
+
+// PostPartitionAudit records the pass which, once the mesh is complete again
+// after being degraded, recovers the items of the pinset whose state on the
+// peers disagrees with their allocations.
+type PostPartitionAudit struct {
+	// HealedAt is the time the mesh was found complete again.
+	HealedAt metav1.Time `json:"healedAt"`
+	// CompletedAt is the time the pass completed, unset while it is pending.
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// Checked is the number of items of the pinset the pass checked.
+	// +optional
+	Checked int32 `json:"checked,omitempty"`
+	// Recovered is the number of diverged items the pass recovered.
+	// +optional
+	Recovered int32 `json:"recovered,omitempty"`
+	// DivergedCount is the number of items still diverged after the pass.
+	// +optional
+	DivergedCount int32 `json:"divergedCount,omitempty"`
+	// Diverged lists the first items still diverged after the pass.
+	// +optional
+	Diverged []DivergedPin `json:"diverged,omitempty"`
+}
+
+// SmokeTestStatus is the outcome of a run of the smoke test.
+type SmokeTestStatus struct {
+	// CID is the content identifier of the payload.
+	// +optional
+	CID string `json:"cid,omitempty"`
+	// Revision is the revision of the StatefulSet the peers ran.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+	// Passed tells whether every stage succeeded.
+	Passed bool `json:"passed"`
+	// FailedStage is the stage which failed: Add, Pin, Allocations or Fetch.
+	// +optional
+	FailedStage string `json:"failedStage,omitempty"`
+	// Message tells why the stage failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Latency is how long the test took, from adding the payload to reading
+	// it back.
+	// +optional
+	Latency *metav1.Duration `json:"latency,omitempty"`
+	// CompletedAt is the time the test completed.
+	CompletedAt metav1.Time `json:"completedAt"`
+}
+
+// DivergedPin is an item of the pinset whose state on some peers disagrees
+// with its allocations.
+type DivergedPin struct {
+	// CID is the content identifier of the item.
+	CID string `json:"cid"`
+	// Peers lists the peers pinning the item without being allocated it, or
+	// allocated it without pinning it.
+	Peers []string `json:"peers"`
+}
+
+// GatewayStatus sums up the requests served by the gateways of the peers.
+// The sums are coarse: the increments of a peer are lost while it cannot
+// be queried.
+type GatewayStatus struct {
+	// SampledAt is the time the gateways were last queried.
+	SampledAt metav1.Time `json:"sampledAt"`
+	// RequestsLastHour is the number of requests served in the last hour.
+	RequestsLastHour int64 `json:"requestsLastHour"`
+	// ResponseBytesLastHour is the number of bytes served in the last hour.
+	ResponseBytesLastHour int64 `json:"responseBytesLastHour"`
+}
+
+// GatewayCoverage counts the nodes running a gateway node in DaemonSet mode.
+type GatewayCoverage struct {
+	// Ready is the number of nodes running a ready gateway node.
+	Ready int32 `json:"ready"`
+	// Selected is the number of nodes selected to run one.
+	Selected int32 `json:"selected"`
+}
+
+// FederationStatus records the peer bundles of a federated cluster.
+type FederationStatus struct {
+	// ExportedAt is the time the exported bundle was last signed.
+	// +optional
+	ExportedAt *metav1.Time `json:"exportedAt,omitempty"`
+	// ExportExpiresAt is the time the exported bundle expires.
+	// +optional
+	ExportExpiresAt *metav1.Time `json:"exportExpiresAt,omitempty"`
+	// Imports describes the bundles referenced by importSecretRefs.
+	// +optional
+	Imports []ImportedBundle `json:"imports,omitempty"`
+}
+
+// ImportedBundle describes a peer bundle imported from a federated cluster.
+type ImportedBundle struct {
+	// SecretName is the name of the Secret holding the bundle.
+	SecretName string `json:"secretName"`
+	// Cluster is the federated cluster which exported the bundle.
+	// +optional
+	Cluster string `json:"cluster,omitempty"`
+	// Peers is the number of peers imported from the bundle.
+	Peers int32 `json:"peers"`
+	// ExpiresAt is the time the bundle expires.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// Error tells why the bundle is not imported.
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// ResourceLimits describes the limits the libp2p resource manager of a peer
+// enforces across the whole node.
+type ResourceLimits struct {
+	// Connections is the number of connections the node may hold, or -1
+	// when unlimited.
+	Connections int64 `json:"connections"`
+	// ConnectionsInbound is the number of inbound connections the node may
+	// hold, or -1 when unlimited.
+	ConnectionsInbound int64 `json:"connectionsInbound"`
+	// Streams is the number of streams the node may hold, or -1 when unlimited.
+	Streams int64 `json:"streams"`
+	// StreamsInbound is the number of inbound streams the node may hold, or
+	// -1 when unlimited.
+	StreamsInbound int64 `json:"streamsInbound"`
+	// Memory is the memory the node may reserve, unless unlimited.
+	// +optional
+	Memory *resource.Quantity `json:"memory,omitempty"`
+	// CheckedAt is when the limits were read from the node.
+	CheckedAt metav1.Time `json:"checkedAt"`
+}
+
+// PeerBandwidth describes the traffic of the kubo node of a peer.
+type PeerBandwidth struct {
+	// TotalIn is the data the node received since it started.
+	TotalIn resource.Quantity `json:"totalIn"`
+	// TotalOut is the data the node sent since it started.
+	TotalOut resource.Quantity `json:"totalOut"`
+	// RateIn is the rate the node receives data at, in bytes per second.
+	RateIn int64 `json:"rateIn"`
+	// RateOut is the rate the node sends data at, in bytes per second.
+	RateOut int64 `json:"rateOut"`
+	// CheckedAt is when the traffic was read from the node.
+	CheckedAt metav1.Time `json:"checkedAt"`
+}
+
+// ProvideStatus describes how a peer announces its content to the DHT.
+type ProvideStatus struct {
+	// AvgProvideDuration is the average time the peer takes to announce a
+	// record, as kubo measures it.
+	AvgProvideDuration metav1.Duration `json:"avgProvideDuration"`
+	// EstimatedReprovide is the time the peer is estimated to take to
+	// announce all of its objects.
+	EstimatedReprovide metav1.Duration `json:"estimatedReprovide"`
+}
+
+// ProvidersStatus describes how the provider nodes keep up with the pinset.
+type ProvidersStatus struct {
+	// ReadyReplicas is the number of provider nodes ready.
+	ReadyReplicas int32 `json:"readyReplicas"`
+	// Pinset is the number of items of the pinset, as of the last check.
+	Pinset int64 `json:"pinset"`
+	// Queue is the number of items of the pinset no provider node announces
+	// yet.
+	Queue int64 `json:"queue"`
+	// Nodes describes each provider node.
+	// +optional
+	Nodes     []ProviderNodeStatus `json:"nodes,omitempty"`
+	CheckedAt metav1.Time          `json:"checkedAt"`
+}
+
+// ProviderNodeStatus describes the shard of the pinset a provider node
+// announces.
+type ProviderNodeStatus struct {
+	Name string `json:"name"`
+	// Assigned is the number of items of the shard of the node.
+	Assigned int64 `json:"assigned"`
+	// Queue is the number of items of the shard the node does not announce
+	// yet.
+	Queue int64 `json:"queue"`
+	// Error is why the node could not be checked, if it could not.
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// RepoUsage describes how full the kubo repo of a peer is.
+type RepoUsage struct {
+	Size       resource.Quantity `json:"size"`
+	StorageMax resource.Quantity `json:"storageMax"`
+	NumObjects int64             `json:"numObjects"`
+	// Utilization is the share of StorageMax used, in percent.
+	Utilization int32       `json:"utilization"`
+	CheckedAt   metav1.Time `json:"checkedAt"`
+}
+
+// Methods the volumes of a cloned cluster are taken over with.
+const (
+	// CloneMethodRebound means the volume of the source was bound to the
+	// claim of the new peer, without copying it.
+	CloneMethodRebound = "Rebound"
+	// CloneMethodCloned means the claim of the new peer is a clone of the
+	// claim of the source, created by the CSI driver.
+	CloneMethodCloned = "Cloned"
+)
+
+// ClonedVolume records how the claim of a peer was taken over from the source.
+type ClonedVolume struct {
+	// Claim is the claim of the new peer.
+	Claim string `json:"claim"`
+	// Source is the claim of the source peer.
+	Source string `json:"source"`
+	// Method is Rebound or Cloned.
+	Method string `json:"method"`
+	// VolumeName is the PersistentVolume rebound to the claim.
+	// +optional
+	VolumeName string `json:"volumeName,omitempty"`
+}
+
+// CloneStatus records what a cluster took over from the cluster it was cloned from.
+type CloneStatus struct {
+	// Source is the name of the Ipfs resource the cluster was cloned from.
+	Source string `json:"source"`
+	// SourceUID is the UID of the source, as recorded on its Secret.
+	// +optional
+	SourceUID string `json:"sourceUID,omitempty"`
+	// BootstrapPeerID is the identity of the bootstrap peer taken over from
+	// the source. It is unset when the Secret of the source was gone.
+	// +optional
+	BootstrapPeerID string `json:"bootstrapPeerID,omitempty"`
+	// ClusterSecret is whether the cluster secret was taken over.
+	ClusterSecret bool `json:"clusterSecret"`
+	// CopiedIdentities lists the ordinals of the peers whose identity was
+	// copied from the Secret of the source. The other peers keep the
+	// identity held in their volume.
+	// +optional
+	CopiedIdentities []int32 `json:"copiedIdentities,omitempty"`
+	// MintedIdentities lists the ordinals of the peers the source had no
+	// identity for, which were given a new one.
+	// +optional
+	MintedIdentities []int32 `json:"mintedIdentities,omitempty"`
+	// Volumes lists the claims taken over from the source.
+	// +optional
+	Volumes []ClonedVolume `json:"volumes,omitempty"`
+	// CompletedAt is the time the cloning completed, unset while the
+	// volumes are being taken over.
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// CompactionStatus tracks the compactions of the CRDT state of the peers.
+type CompactionStatus struct {
+	// Ordinal is the peer whose state is compacted next or in progress,
+	// while a round of compactions runs.
+	// +optional
+	Ordinal *int32 `json:"ordinal,omitempty"`
+	// StartedAt is when the peer in progress left the cluster for its
+	// compaction. It is unset while the peer waits for its turn.
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// PeerStopped is true once the pod of the peer in progress was
+	// restarted to hand its state over to the compaction Job.
+	// +optional
+	PeerStopped bool `json:"peerStopped,omitempty"`
+	// LastScheduleTime is when the last round of compactions started.
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// LastCompactionTime is when the state of every peer was last compacted.
+	// +optional
+	LastCompactionTime *metav1.Time `json:"lastCompactionTime,omitempty"`
+}
+
+// GCStatus tracks the garbage collections of the repos of the peers.
+type GCStatus struct {
+	// Pass is the pass in progress, if any.
+	// +optional
+	Pass *GCPass `json:"pass,omitempty"`
+	// LastPass is the last pass which went over every peer.
+	// +optional
+	LastPass *GCPass `json:"lastPass,omitempty"`
+}
+
+// GCPass records a garbage collection of the repos of the peers, one peer
+// at a time.
+type GCPass struct {
+	// UnpinnedAt is the time of the last unpin the pass collects.
+	UnpinnedAt metav1.Time `json:"unpinnedAt"`
+	StartedAt  metav1.Time `json:"startedAt"`
+	// CompletedAt is when the pass went over the last peer.
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// ReclaimedBytes is the space the pass freed across the peers.
+	// +optional
+	ReclaimedBytes int64 `json:"reclaimedBytes,omitempty"`
+	// Peers lists the peers the pass went over, in order.
+	// +optional
+	Peers []PeerGC `json:"peers,omitempty"`
+}
+
+// PeerGC records the garbage collection of the repo of a peer.
+type PeerGC struct {
+	Name string `json:"name"`
+	// ReclaimedBytes is how much the repo shrank.
+	// +optional
+	ReclaimedBytes int64 `json:"reclaimedBytes,omitempty"`
+	// RemovedBlocks is the number of blocks removed from the repo.
+	// +optional
+	RemovedBlocks int64 `json:"removedBlocks,omitempty"`
+	// Skipped tells why the repo was not collected, if it was not.
+	// +optional
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// RolloutHooksStatus records the hooks run for the rollout of a revision.
+type RolloutHooksStatus struct {
+	// Revision is the revision of the StatefulSet the hooks ran for.
+	Revision string `json:"revision"`
+	// Results lists the hooks which completed, in the order they ran.
+	// +optional
+	Results []RolloutHookResult `json:"results,omitempty"`
+}
+
+// RolloutHookResult records the outcome of a hook Job.
+type RolloutHookResult struct {
+	// Hook is preUpgrade or postPodUpdate.
+	Hook string `json:"hook"`
+	// Peer is the pod of the peer a postPodUpdate hook ran after.
+	// +optional
+	Peer string `json:"peer,omitempty"`
+	// Job is the name of the hook Job.
+	Job string `json:"job"`
+	// Succeeded tells whether the Job succeeded.
+	Succeeded bool `json:"succeeded"`
+	// Message is the termination message of the hook, or why it failed.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// CompletedAt is when the Job completed.
+	CompletedAt metav1.Time `json:"completedAt"`
+}
+
+// ChangeOutcome is how the rollout of a change of the pod template ended.
+// +kubebuilder:validation:Enum=Progressing;Succeeded;Failed;Superseded
+type ChangeOutcome string
+
+const (
+	// ChangeOutcomeProgressing means the peers are still rolling.
+	ChangeOutcomeProgressing ChangeOutcome = "Progressing"
+	// ChangeOutcomeSucceeded means every peer runs the changed template.
+	ChangeOutcomeSucceeded ChangeOutcome = "Succeeded"
+	// ChangeOutcomeFailed means a rollout hook failed for the change.
+	ChangeOutcomeFailed ChangeOutcome = "Failed"
+	// ChangeOutcomeSuperseded means another change was applied before the
+	// rollout completed.
+	ChangeOutcomeSuperseded ChangeOutcome = "Superseded"
+)
+
+// ChangeRecord records a change of the pod template of the peers and the
+// rollout it triggered.
+type ChangeRecord struct {
+	Time metav1.Time `json:"time"`
+	// Fields lists the sections of the spec which changed, such as image or
+	// storage, or operator when none did and the operator rendered the
+	// template differently.
+	Fields []string `json:"fields"`
+	// HashBefore and HashAfter are the hashes of the pod template before
+	// and after the change.
+	// +optional
+	HashBefore string `json:"hashBefore,omitempty"`
+	HashAfter  string `json:"hashAfter"`
+	// Generation is the generation of the StatefulSet carrying the change.
+	Generation int64         `json:"generation"`
+	Outcome    ChangeOutcome `json:"outcome"`
+}
+
+// AppliedHashes records the hashes of the pod template of the peers and of
+// each section of the resolved spec, as of the last change applied.
+type AppliedHashes struct {
+	Template string `json:"template"`
+	// +optional
+	Sections map[string]string `json:"sections,omitempty"`
+}
+
+// PeerCompaction records the last compaction of the state of a peer.
+type PeerCompaction struct {
+	Time metav1.Time `json:"time"`
+	// StateSize is the size of the state of the peer once compacted.
+	// +optional
+	StateSize *resource.Quantity `json:"stateSize,omitempty"`
+}
+
+// IdentityRotationStatus tracks the identity rotation in progress.
+type IdentityRotationStatus struct {
+	Ordinal int32 `json:"ordinal"`
+	// +optional
+	OldID     string      `json:"oldID,omitempty"`
+	NewID     string      `json:"newID"`
+	StartedAt metav1.Time `json:"startedAt"`
+}
+
+// FrozenSpec records the spec fields which cannot change once the cluster is created.
+type FrozenSpec struct {
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	IpfsStorage      string  `json:"ipfsStorage"`
+	ClusterStorage   string  `json:"clusterStorage"`
+	// +optional
+	Seed *SeedConfig `json:"seed,omitempty"`
+	// +optional
+	DatastoreBackend string `json:"datastoreBackend,omitempty"`
+	// +optional
+	CloneFrom string `json:"cloneFrom,omitempty"`
+}
+
+// AppliedDefaults records the built-in defaults of the operator the cluster
+// resolves the fields it leaves empty against, so that upgrading the
+// operator does not change them.
+type AppliedDefaults struct {
+	// Version is the version of the built-in defaults.
+	Version int32 `json:"version"`
+	// +optional
+	IpfsImage string `json:"ipfsImage,omitempty"`
+	// +optional
+	ClusterImage string `json:"clusterImage,omitempty"`
+	// +optional
+	AuthProxyImage string `json:"authProxyImage,omitempty"`
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// +optional
+	Resources *ResourcesConfig `json:"resources,omitempty"`
+	// +optional
+	StoragePressureThreshold int32 `json:"storagePressureThreshold,omitempty"`
+}
+
+// Phase is a coarse summary of the conditions of a cluster.
+// +kubebuilder:validation:Enum=Pending;Initializing;Running;Upgrading;Degraded;Terminating
+type Phase string
+
+const (
+	// PhasePending means the peers have not been created yet.
+	PhasePending Phase = "Pending"
+	// PhaseInitializing means the peers are starting for the first time.
+	PhaseInitializing Phase = "Initializing"
+	// PhaseRunning means every peer is ready.
+	PhaseRunning Phase = "Running"
+	// PhaseUpgrading means the peers are being restarted with a new spec, secret or identity.
+	PhaseUpgrading Phase = "Upgrading"
+	// PhaseDegraded means some peers stopped being ready, or an operation failed.
+	PhaseDegraded Phase = "Degraded"
+	// PhaseTerminating means the cluster is being deleted.
+	PhaseTerminating Phase = "Terminating"
+)
+
+// InventoryEntry identifies an object the operator applied for a cluster,
+// in the namespace of the cluster.
+type InventoryEntry struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// CleanupStep is a step of the cleanup run by the finalizer of a cluster.
+type CleanupStep string
+
+const (
+	// CleanupStepReadiness deletes the ready ConfigMap of the cluster, so
+	// that the workloads waiting on it see the cluster go away first.
+	CleanupStepReadiness CleanupStep = "Readiness"
+	// CleanupStepExposure deletes the Ingress, the Service and the
+	// Certificates exposing the gateway, so that external-dns withdraws their
+	// records and cert-manager stops renewing them.
+	CleanupStepExposure CleanupStep = "Exposure"
+	// CleanupStepPeers deletes the exported peer bundle and the peer
+	// directory of the cluster, so that the federated clusters and the
+	// workloads listing its peers stop dialing them.
+	CleanupStepPeers CleanupStep = "Peers"
+	// CleanupStepRelays deletes the circuit relays of the peers.
+	CleanupStepRelays CleanupStep = "Relays"
+	// CleanupStepVolumes deletes the volume claims of the peers when the
+	// reclaim policy is Delete, and releases them from the cluster otherwise.
+	CleanupStepVolumes CleanupStep = "Volumes"
+	// CleanupStepMetrics deletes the metrics of the cluster.
+	CleanupStepMetrics CleanupStep = "Metrics"
+)
+
 type IpfsStatus struct {
 	Conditions    []metav1.Condition `json:"conditions,omitempty"`
 	CircuitRelays []string           `json:"circuitRelays,omitempty"`
+	// +optional
+	SecretRotation SecretRotationStatus `json:"secretRotation,omitempty"`
+	// +optional
+	Peers []PeerStatus `json:"peers,omitempty"`
+	// PeerService is the headless Service governing the StatefulSet of the
+	// peers, under which their pods have DNS names.
+	// +optional
+	PeerService string `json:"peerService,omitempty"`
+	// +optional
+	IdentityRotation *IdentityRotationStatus `json:"identityRotation,omitempty"`
+	// Compaction tracks the compactions of the CRDT state of the peers.
+	// +optional
+	Compaction *CompactionStatus `json:"compaction,omitempty"`
+	// GC tracks the garbage collections of the repos of the peers after
+	// unpins.
+	// +optional
+	GC *GCStatus `json:"gc,omitempty"`
+	// RolloutHooks records the hooks run for the latest rollout.
+	// +optional
+	RolloutHooks *RolloutHooksStatus `json:"rolloutHooks,omitempty"`
+	// History lists the last changes of the pod template of the peers,
+	// oldest first.
+	// +optional
+	History []ChangeRecord `json:"history,omitempty"`
+	// AppliedHashes records what the last change in the history was
+	// computed against.
+	// +optional
+	AppliedHashes *AppliedHashes `json:"appliedHashes,omitempty"`
+	// ObservedGeneration is the generation of the spec last reconciled successfully.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Phase summarizes the conditions of the cluster.
+	// +optional
+	Phase Phase `json:"phase,omitempty"`
+	// Frozen records the values of the frozen fields the cluster was created with.
+	// +optional
+	Frozen *FrozenSpec `json:"frozen,omitempty"`
+	// AppliedDefaults records the built-in defaults the cluster was created
+	// with, or last adopted.
+	// +optional
+	AppliedDefaults *AppliedDefaults `json:"appliedDefaults,omitempty"`
+	// Clone records what the cluster took over from spec.cloneFrom.
+	// +optional
+	Clone *CloneStatus `json:"clone,omitempty"`
+	// Autoscaling records the decisions of the autoscaler when it is enabled.
+	// +optional
+	Autoscaling *AutoscalingStatus `json:"autoscaling,omitempty"`
+	// APIMode is the mode the RPC API is exposed with, once applied.
+	// +optional
+	APIMode string `json:"apiMode,omitempty"`
+	// Routing is the routing type the kubo nodes run with, once applied.
+	// +optional
+	Routing string `json:"routing,omitempty"`
+	// Cluster records the values the ipfs-cluster daemons run with, once applied.
+	// +optional
+	Cluster *ClusterConfigStatus `json:"cluster,omitempty"`
+	// Mesh records the checks of the connections between the peers.
+	// +optional
+	Mesh *MeshStatus `json:"mesh,omitempty"`
+	// PostPartitionAudit records the last pass recovering the pinset after
+	// the mesh healed from a partition.
+	// +optional
+	PostPartitionAudit *PostPartitionAudit `json:"postPartitionAudit,omitempty"`
+	// SmokeTest records the last run of the smoke test.
+	// +optional
+	SmokeTest *SmokeTestStatus `json:"smokeTest,omitempty"`
+	// Providers describes how the provider nodes keep up with the pinset,
+	// while they are deployed.
+	// +optional
+	Providers *ProvidersStatus `json:"providers,omitempty"`
+	// Gateway sums up the requests served by the gateways of the peers,
+	// while monitoring is enabled.
+	// +optional
+	Gateway *GatewayStatus `json:"gateway,omitempty"`
+	// GatewayCoverage counts the nodes serving a node-local gateway in
+	// DaemonSet mode.
+	// +optional
+	GatewayCoverage *GatewayCoverage `json:"gatewayCoverage,omitempty"`
+	// CleanupProgress lists the cleanup steps completed since the cluster
+	// started being deleted.
+	// +optional
+	CleanupProgress []CleanupStep `json:"cleanupProgress,omitempty"`
+	// Federation records the bundles the cluster exports and imports.
+	// +optional
+	Federation *FederationStatus `json:"federation,omitempty"`
+	// Inventory lists the objects the last complete reconcile applied for
+	// the cluster. The next one deletes those the spec no longer produces.
+	// +optional
+	Inventory []InventoryEntry `json:"inventory,omitempty"`
+	// RepoLayout records the layout the repos of the peers were initialized
+	// with, once the first one was. The layout cannot change afterwards.
+	// +optional
+	RepoLayout *RepoLayout `json:"repoLayout,omitempty"`
+	// Profiling lists the pprof endpoints of the daemons of every peer,
+	// while profiling is enabled.
+	// +optional
+	Profiling []ProfilingEndpoints `json:"profiling,omitempty"`
+}
+
+// ProfilingEndpoints are the URLs of the pprof endpoints of the daemons of a
+// peer.
+type ProfilingEndpoints struct {
+	// Name is the name of the pod of the peer.
+	Name string `json:"name"`
+	// Kubo is the URL of the pprof index of the kubo node.
+	Kubo string `json:"kubo"`
+	// Cluster is the URL of the pprof index of the ipfs-cluster daemon.
+	Cluster string `json:"cluster"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Ipfs is the Schema for the ipfs API.
 type Ipfs struct {
@@ -0,0 +1,794 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/redhat-et/ipfs-operator/pkg/maddr"
+)
+
+// log is for logging in this package.
+var ipfslog = logf.Log.WithName("ipfs-resource")
+
+// Migration procedures for the frozen fields.
+const (
+	migrateStorageClass = "to move to another storage class, back up the pins, " +
+		"create a new cluster and restore them"
+	migrateStorageSize = "to grow the volumes, expand the %s PersistentVolumeClaims " +
+		"directly when their storage class allows it"
+	migrateSeed      = "seeding only applies to the first boot of the peers of a new cluster"
+	migrateDatastore = "to move to another datastore backend, back up the pins, " +
+		"create a new cluster and restore them"
+	migrateClone = "cloning only applies to the creation of a cluster"
+)
+
+// validatePath Is the path the validating webhook of the Ipfs resources is served at.
+const validatePath = "/validate-cluster-ipfs-io-v1alpha1-ipfs"
+
+// SetupWebhookWithManager Registers the validating webhook of the Ipfs
+// resources, which also holds them within the budgets of their namespace,
+// checks the clusters they are cloned from and warns about the data their
+// deletion loses.
+func (r *Ipfs) SetupWebhookWithManager(mgr ctrl.Manager, budgets BudgetSource) error {
+	validator := admission.WithCustomValidator(r, &ipfsValidator{budgets: budgets, reader: mgr.GetAPIReader()})
+	mgr.GetWebhookServer().Register(validatePath, &webhook.Admission{Handler: deletionWarnings{Webhook: validator}})
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-cluster-ipfs-io-v1alpha1-ipfs,mutating=false,failurePolicy=fail,sideEffects=None,groups=cluster.ipfs.io,resources=ipfs,verbs=create;update;delete,versions=v1alpha1,name=vipfs.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Ipfs{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+func (r *Ipfs) ValidateCreate() error {
+	ipfslog.Info("validate create", "name", r.Name)
+	errs := append(ValidateSpec(&r.Spec), ValidateVersionCombination(r.Annotations, &r.Spec)...)
+	if r.Spec.CloneFrom == r.Name {
+		errs = append(errs, field.Invalid(field.NewPath("spec", "cloneFrom"), r.Spec.CloneFrom,
+			"must name another Ipfs resource"))
+	}
+	return r.invalid(errs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+func (r *Ipfs) ValidateUpdate(old runtime.Object) error {
+	ipfslog.Info("validate update", "name", r.Name)
+	oldIpfs, ok := old.(*Ipfs)
+	if !ok {
+		return apierrors.NewBadRequest("expected an Ipfs resource")
+	}
+	errs := ValidateFrozenFields(FrozenSpecOf(&oldIpfs.Spec), &r.Spec)
+	if layout := oldIpfs.Status.RepoLayout; layout != nil {
+		errs = append(errs, ValidateRepoLayout(*layout, &r.Spec)...)
+	}
+	errs = append(errs, ValidateSpec(&r.Spec)...)
+	return r.invalid(append(errs, ValidateVersionCombination(r.Annotations, &r.Spec)...))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+// Deletions are never refused; the data they lose is reported as warnings.
+func (r *Ipfs) ValidateDelete() error {
+	ipfslog.Info("validate delete", "name", r.Name, "warnings", r.DeletionWarnings())
+	return nil
+}
+
+// DeletionWarnings Returns a warning for each piece of data deleting the
+// cluster loses for good.
+func (r *Ipfs) DeletionWarnings() []string {
+	var warnings []string
+	if r.Spec.VolumeReclaimPolicy == "Delete" {
+		warnings = append(warnings, fmt.Sprintf("deleting Ipfs %s deletes the volume claims of its peers "+
+			"and the pins they hold; set spec.volumeReclaimPolicy to Retain first to keep them", r.Name))
+	}
+	return warnings
+}
+
+// deletionWarnings Adds the warnings of DeletionWarnings to the responses
+// allowing the deletion of an Ipfs resource, as the validators of
+// controller-runtime cannot return warnings. The embedded webhook receives
+// the scheme and the decoder the server injects.
+type deletionWarnings struct {
+	*admission.Webhook
+}
+
+// Handle Validates the request, then warns about the data a deletion loses.
+func (h deletionWarnings) Handle(ctx context.Context, req admission.Request) admission.Response {
+	resp := h.Handler.Handle(ctx, req)
+	if req.Operation != admissionv1.Delete || !resp.Allowed {
+		return resp
+	}
+	r := &Ipfs{}
+	if err := h.GetDecoder().DecodeRaw(req.OldObject, r); err != nil {
+		return resp
+	}
+	return resp.WithWarnings(r.DeletionWarnings()...)
+}
+
+// ipfsValidator Validates the Ipfs resources, then holds them within the
+// budget of their namespace. The clusters new resources are cloned from are
+// read through the reader.
+// +kubebuilder:object:generate=false
+type ipfsValidator struct {
+	budgets BudgetSource
+	reader  client.Reader
+}
+
+var _ admission.CustomValidator = &ipfsValidator{}
+
+func (v *ipfsValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*Ipfs)
+	if !ok {
+		return apierrors.NewBadRequest("expected an Ipfs resource")
+	}
+	if err := r.ValidateCreate(); err != nil {
+		return err
+	}
+	if err := v.validateCloneSource(ctx, r); err != nil {
+		return err
+	}
+	return v.validateBudget(ctx, r, nil)
+}
+
+func (v *ipfsValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	r, ok := newObj.(*Ipfs)
+	if !ok {
+		return apierrors.NewBadRequest("expected an Ipfs resource")
+	}
+	if err := r.ValidateUpdate(oldObj); err != nil {
+		return err
+	}
+	oldIpfs, ok := oldObj.(*Ipfs)
+	if !ok {
+		return apierrors.NewBadRequest("expected an Ipfs resource")
+	}
+	return v.validateBudget(ctx, r, &oldIpfs.Spec)
+}
+
+func (v *ipfsValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*Ipfs)
+	if !ok {
+		return apierrors.NewBadRequest("expected an Ipfs resource")
+	}
+	return r.ValidateDelete()
+}
+
+// validateBudget Refuses the resource when it exceeds the budget of its
+// namespace.
+func (v *ipfsValidator) validateBudget(ctx context.Context, r *Ipfs, old *IpfsSpec) error {
+	if v.budgets == nil {
+		return nil
+	}
+	budget, source, err := v.budgets.BudgetFor(ctx, r.Namespace)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	return r.invalid(ValidateBudget(&r.Spec, old, budget, source))
+}
+
+// validateCloneSource Refuses the resource when the cluster it is cloned
+// from is still reconciled.
+func (v *ipfsValidator) validateCloneSource(ctx context.Context, r *Ipfs) error {
+	if v.reader == nil || r.Spec.CloneFrom == "" {
+		return nil
+	}
+	source := &Ipfs{}
+	err := v.reader.Get(ctx, client.ObjectKey{Namespace: r.Namespace, Name: r.Spec.CloneFrom}, source)
+	switch {
+	case apierrors.IsNotFound(err):
+		source = nil
+	case err != nil:
+		return apierrors.NewInternalError(err)
+	}
+	return r.invalid(ValidateCloneSource(source))
+}
+
+// invalid Returns the error refusing the resource for the given reasons, or
+// nil when there are none.
+func (r *Ipfs) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "Ipfs"}, r.Name, errs)
+}
+
+// specValidators Validate the sections of the spec, in the order their
+// errors are reported.
+var specValidators = []func(*field.Path, *IpfsSpec) field.ErrorList{
+	validateAutoscaling,
+	validateExposeTLS,
+	validateExposeAuth,
+	validateSeed,
+	validateDatastoreBackend,
+	validateDaemonConfigs,
+	validateBootstrap,
+	validatePeering,
+	validatePeerOverrides,
+	validateRollout,
+	validateServiceAccount,
+	validateAPI,
+	validateDebug,
+}
+
+// ValidateSpec Returns an error for each combination of fields the spec
+// cannot set together.
+func ValidateSpec(spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	for _, validate := range specValidators {
+		errs = append(errs, validate(specPath, spec)...)
+	}
+	return errs
+}
+
+// validateAutoscaling Returns an error when the replicas are set along with
+// the autoscaler, or when its bounds are reversed.
+func validateAutoscaling(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.Autoscaling == nil {
+		return nil
+	}
+	if spec.Replicas != 0 {
+		errs = append(errs, field.Forbidden(specPath.Child("replicas"),
+			"must be unset when spec.autoscaling is set, as the autoscaler manages the number of peers"))
+	}
+	if spec.Autoscaling.MinReplicas > spec.Autoscaling.MaxReplicas {
+		errs = append(errs, field.Invalid(specPath.Child("autoscaling", "minReplicas"),
+			spec.Autoscaling.MinReplicas, "must not be greater than maxReplicas"))
+	}
+	return errs
+}
+
+// validateExposeTLS Returns an error unless the TLS certificate of the
+// gateway comes from exactly one of a Secret and an issuer, for a host.
+func validateExposeTLS(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	tls := spec.Expose.TLS
+	if tls == nil {
+		return nil
+	}
+	tlsPath := specPath.Child("expose", "tls")
+	if spec.Expose.Host == "" {
+		errs = append(errs, field.Required(specPath.Child("expose", "host"),
+			"the certificate is served by the Ingress of the host"))
+	}
+	switch {
+	case tls.SecretName == "" && tls.IssuerRef == nil:
+		errs = append(errs, field.Required(tlsPath, "one of secretName and issuerRef must be set"))
+	case tls.SecretName != "" && tls.IssuerRef != nil:
+		errs = append(errs, field.Forbidden(tlsPath.Child("issuerRef"), "must be unset when secretName is set"))
+	}
+	return errs
+}
+
+// validateExposeAuth Returns an error unless the users of the gateway are
+// authenticated by exactly one of basic auth and OIDC.
+func validateExposeAuth(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	auth := spec.Expose.Auth
+	if auth == nil {
+		return nil
+	}
+	authPath := specPath.Child("expose", "auth")
+	switch {
+	case auth.BasicAuthSecretRef == nil && auth.OIDC == nil:
+		return field.ErrorList{field.Required(authPath, "one of basicAuthSecretRef and oidc must be set")}
+	case auth.BasicAuthSecretRef != nil && auth.OIDC != nil:
+		return field.ErrorList{field.Forbidden(authPath.Child("oidc"), "must be unset when basicAuthSecretRef is set")}
+	}
+	return nil
+}
+
+// validateSeed Returns an error unless the seed comes from exactly one of a
+// claim and an image.
+func validateSeed(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	seed := spec.Seed
+	if seed == nil {
+		return nil
+	}
+	seedPath := specPath.Child("seed")
+	switch {
+	case seed.ExistingClaimName == "" && seed.Image == "":
+		return field.ErrorList{field.Required(seedPath, "one of existingClaimName and image must be set")}
+	case seed.ExistingClaimName != "" && seed.Image != "":
+		return field.ErrorList{field.Forbidden(seedPath.Child("image"), "must be unset when existingClaimName is set")}
+	}
+	return nil
+}
+
+// validateDatastoreBackend Returns an error when the s3 backend has no
+// bucket, or is seeded.
+func validateDatastoreBackend(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if DatastoreBackendOf(spec) != DatastoreBackendS3 {
+		return nil
+	}
+	if spec.Datastore.S3 == nil {
+		errs = append(errs, field.Required(specPath.Child("datastore", "s3"), "the s3 backend needs a bucket"))
+	}
+	if spec.Seed != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("seed"),
+			"seeds hold local datastores, which cannot be mixed with the s3 backend"))
+	}
+	return errs
+}
+
+// validateDaemonConfigs Returns the errors of the sections configuring the
+// daemons of the peers.
+func validateDaemonConfigs(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.Datastore != nil {
+		errs = append(errs, validateDatastoreConfig(specPath.Child("datastore"), spec.Datastore)...)
+	}
+	if spec.Cluster != nil {
+		errs = append(errs, validateClusterConfig(specPath.Child("cluster"), spec.Cluster)...)
+	}
+	if spec.DNS != nil {
+		errs = append(errs, validateDNSConfig(specPath.Child("dns"), spec.DNS)...)
+	}
+	if spec.Swarm != nil {
+		errs = append(errs, validateSwarmConfig(specPath.Child("swarm"), spec)...)
+	}
+	if spec.Routing != nil {
+		errs = append(errs, validateRoutingConfig(specPath.Child("routing"), spec)...)
+	}
+	if spec.Bandwidth != nil {
+		errs = append(errs, validateBandwidthConfig(specPath.Child("bandwidth"), spec)...)
+	}
+	return errs
+}
+
+// validateBootstrap Returns an error when the bootstrap peers leave no peer
+// to hold the pins, or when the allocator could place pins on them.
+func validateBootstrap(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	bootstrap := spec.Bootstrap
+	if bootstrap == nil {
+		return nil
+	}
+	bootstrapPath := specPath.Child("bootstrap", "peers")
+	if bootstrap.Peers >= spec.Replicas {
+		errs = append(errs, field.Invalid(bootstrapPath, bootstrap.Peers,
+			"must leave at least one peer to hold the pins"))
+	}
+	if cluster := spec.Cluster; cluster != nil && cluster.Allocator != nil && len(cluster.Allocator.Order) > 0 {
+		grouped := false
+		for _, metric := range cluster.Allocator.Order {
+			grouped = grouped || metric == "tag:group"
+		}
+		if !grouped {
+			errs = append(errs, field.Forbidden(bootstrapPath,
+				"the allocator order must include tag:group, which keeps the pins off the bootstrap peers"))
+		}
+	}
+	return errs
+}
+
+// validatePeering Returns the errors of the network audit and of the
+// addresses the peers are peered with.
+func validatePeering(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.Network != nil {
+		errs = append(errs, validateNetworkAuditConfig(specPath.Child("network"), spec.Network)...)
+	}
+	for i, addr := range spec.Peering {
+		if _, err := maddr.Parse("", addr, maddr.PeerRequired); err != nil {
+			reason := err.Error()
+			var invalid *maddr.Error
+			if errors.As(err, &invalid) {
+				reason = invalid.Reason
+			}
+			errs = append(errs, field.Invalid(specPath.Child("peering").Index(i), addr, reason))
+		}
+	}
+	return errs
+}
+
+// validatePeerOverrides Returns the errors of the overrides of single peers.
+func validatePeerOverrides(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	if len(spec.Overrides) == 0 {
+		return nil
+	}
+	return validateOverrides(specPath.Child("overrides"), spec)
+}
+
+// validateRollout Returns the errors of the maintenance window, of the pace
+// and of the hooks of the rollouts, and of a clone which is also seeded.
+func validateRollout(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.MaintenanceWindow != nil {
+		errs = append(errs, validateMaintenanceWindow(specPath.Child("maintenanceWindow"), spec.MaintenanceWindow)...)
+	}
+	if rollout := spec.Rollout; rollout != nil && rollout.PauseBetweenPods != nil &&
+		rollout.PauseBetweenPods.Duration < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("rollout", "pauseBetweenPods"),
+			rollout.PauseBetweenPods.Duration.String(), "must not be negative"))
+	}
+	if spec.Rollout != nil && spec.Rollout.Hooks != nil {
+		errs = append(errs, validateRolloutHooks(specPath.Child("rollout", "hooks"), spec)...)
+	}
+	if spec.CloneFrom != "" && spec.Seed != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("seed"),
+			"must be unset when cloneFrom is set, as both fill the volumes of the new peers"))
+	}
+	return errs
+}
+
+// validateServiceAccount Returns an error when the peers run as an existing
+// ServiceAccount without naming it, or name it wrongly.
+func validateServiceAccount(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	sa := spec.ServiceAccount
+	if sa == nil {
+		return nil
+	}
+	saPath := specPath.Child("serviceAccount")
+	if sa.Create != nil && !*sa.Create && sa.Name == "" {
+		errs = append(errs, field.Required(saPath.Child("name"),
+			"the peers run as an existing ServiceAccount when create is false"))
+	}
+	if sa.Name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(sa.Name) {
+			errs = append(errs, field.Invalid(saPath.Child("name"), sa.Name, msg))
+		}
+	}
+	return errs
+}
+
+// validateAPI Returns the errors of the exposure of the RPC API and of the
+// headers of the gateway.
+func validateAPI(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.API != nil && spec.Expose.Auth == nil {
+		errs = append(errs, field.Required(specPath.Child("expose", "auth"),
+			"the RPC API is only exposed to authenticated users"))
+	}
+	if spec.API != nil && spec.API.CORS != nil {
+		errs = append(errs, validateCORSConfig(specPath.Child("api", "cors"), spec.API.CORS)...)
+	}
+	if spec.Gateway != nil {
+		errs = append(errs, validateGatewayConfig(specPath.Child("gateway"), spec.Gateway)...)
+	}
+	return errs
+}
+
+// validateDebug Returns an error when the pprof endpoints would be enabled on
+// an exposed cluster, or let in from an invalid selector.
+func validateDebug(specPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	debug := spec.Debug
+	if debug == nil {
+		return nil
+	}
+	debugPath := specPath.Child("debug")
+	if debug.EnableProfiling && spec.Expose.Host != "" {
+		errs = append(errs, field.Forbidden(debugPath.Child("enableProfiling"),
+			"the pprof endpoints are never enabled on a cluster exposed through an Ingress"))
+	}
+	if debug.ProfilingSources != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(debug.ProfilingSources,
+			debugPath.Child("profilingSources"))...)
+	}
+	return errs
+}
+
+// validateRolloutHooks Returns an error for each hook the rollouts cannot
+// run.
+func validateRolloutHooks(path *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	hooks := spec.Rollout.Hooks
+	if strategy := spec.UpdateStrategy; strategy != nil && strategy.Type == "OnDelete" {
+		errs = append(errs, field.Forbidden(path,
+			"the hooks run around rolling updates, which OnDelete updates are not"))
+	}
+	if hooks.Timeout != nil && hooks.Timeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("timeout"), hooks.Timeout.Duration.String(),
+			"must be positive"))
+	}
+	if hooks.TTLAfterFinished != nil && hooks.TTLAfterFinished.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("ttlAfterFinished"), hooks.TTLAfterFinished.Duration.String(),
+			"must not be negative"))
+	}
+	return errs
+}
+
+// ValidateCloneSource Returns an error when the cluster a new one is cloned
+// from is still reconciled, which would keep it using the identity and the
+// volumes the new cluster takes over. A nil source was deleted.
+func ValidateCloneSource(source *Ipfs) field.ErrorList {
+	if source == nil || source.DeletionTimestamp != nil || source.Annotations[AnnotationVerifyOnly] == "true" {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(field.NewPath("spec", "cloneFrom"),
+		fmt.Sprintf("Ipfs %s is still reconciled; delete it or set its %s annotation to \"true\" first",
+			source.Name, AnnotationVerifyOnly))}
+}
+
+// validateClusterConfig Returns an error for each value ipfs-cluster cannot
+// run with.
+func validateClusterConfig(clusterPath *field.Path, cluster *ClusterConfig) field.ErrorList {
+	var errs field.ErrorList
+	if d := cluster.MonitorPingInterval; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(clusterPath.Child("monitorPingInterval"), d.Duration.String(),
+			"must be a positive duration"))
+	}
+	if d := cluster.PinRecoverInterval; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(clusterPath.Child("pinRecoverInterval"), d.Duration.String(),
+			"must be a positive duration"))
+	}
+	if informer := cluster.Informer; informer != nil && informer.Freespace != nil {
+		if d := informer.Freespace.MetricTTL; d != nil && d.Duration <= 0 {
+			errs = append(errs, field.Invalid(clusterPath.Child("informer", "freespace", "metricTTL"),
+				d.Duration.String(), "must be a positive duration"))
+		}
+	}
+	if allocator := cluster.Allocator; allocator != nil {
+		for i, metric := range allocator.Order {
+			if metric == "" || strings.Contains(metric, ",") {
+				errs = append(errs, field.Invalid(clusterPath.Child("allocator", "order").Index(i),
+					metric, "must name a single metric"))
+			}
+		}
+	}
+	if cluster.CRDT != nil {
+		errs = append(errs, validateCRDTConfig(clusterPath.Child("crdt"), cluster.CRDT)...)
+	}
+	return errs
+}
+
+// validateCRDTConfig Returns an error for batching settings ipfs-cluster
+// would ignore, which only batches with both limits set, and for a
+// compaction schedule which cannot be parsed or never starts.
+func validateCRDTConfig(crdtPath *field.Path, crdt *CRDTConfig) field.ErrorList {
+	var errs field.ErrorList
+	if d := crdt.BatchingMaxAge; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(crdtPath.Child("batchingMaxAge"), d.Duration.String(),
+			"must be a positive duration"))
+	}
+	if (crdt.BatchingMaxAge == nil) != (crdt.BatchingMaxSize == nil) {
+		errs = append(errs, field.Required(crdtPath.Child("batchingMaxAge"),
+			"batchingMaxAge and batchingMaxSize are set together"))
+	}
+	if crdt.CompactSchedule != "" {
+		schedule, err := ParseSchedule(crdt.CompactSchedule)
+		switch {
+		case err != nil:
+			errs = append(errs, field.Invalid(crdtPath.Child("compactSchedule"), crdt.CompactSchedule, err.Error()))
+		case schedule.Next(time.Now(), time.UTC).IsZero():
+			errs = append(errs, field.Invalid(crdtPath.Child("compactSchedule"), crdt.CompactSchedule,
+				"never starts within the next five years"))
+		}
+	}
+	return errs
+}
+
+// validateDNSConfig Returns an error for each resolver kubo cannot use: the
+// domains must be fully qualified and the resolvers DNS over HTTPS URLs.
+func validateDNSConfig(dnsPath *field.Path, dns *KuboDNSConfig) field.ErrorList {
+	var errs field.ErrorList
+	domains := make([]string, 0, len(dns.Resolvers))
+	for domain := range dns.Resolvers {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		resolverPath := dnsPath.Child("resolvers").Key(domain)
+		if !strings.HasSuffix(domain, ".") {
+			errs = append(errs, field.Invalid(resolverPath, domain, "the domain must end with a dot"))
+		}
+		resolver := dns.Resolvers[domain]
+		u, err := url.Parse(resolver)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, field.Invalid(resolverPath, resolver, "must be an https:// URL"))
+		}
+	}
+	return errs
+}
+
+// FrozenSpecOf Returns the values of the frozen fields of the spec.
+func FrozenSpecOf(spec *IpfsSpec) FrozenSpec {
+	frozen := FrozenSpec{
+		IpfsStorage:    spec.IpfsStorage,
+		ClusterStorage: spec.ClusterStorage,
+	}
+	if spec.StorageClassName != nil {
+		name := *spec.StorageClassName
+		frozen.StorageClassName = &name
+	}
+	frozen.Seed = spec.Seed.DeepCopy()
+	frozen.DatastoreBackend = DatastoreBackendOf(spec)
+	frozen.CloneFrom = spec.CloneFrom
+	return frozen
+}
+
+// DatastoreBackendOf Returns the datastore backend of the spec, local by default.
+func DatastoreBackendOf(spec *IpfsSpec) string {
+	if spec.Datastore == nil || spec.Datastore.Backend == "" {
+		return DatastoreBackendLocal
+	}
+	return spec.Datastore.Backend
+}
+
+// ApplyTo Sets the frozen fields of the spec to the recorded values.
+func (f *FrozenSpec) ApplyTo(spec *IpfsSpec) {
+	spec.IpfsStorage = f.IpfsStorage
+	spec.ClusterStorage = f.ClusterStorage
+	if f.StorageClassName != nil {
+		name := *f.StorageClassName
+		spec.StorageClassName = &name
+	}
+	spec.Seed = f.Seed.DeepCopy()
+	spec.CloneFrom = f.CloneFrom
+	if f.DatastoreBackend == DatastoreBackendS3 || spec.Datastore != nil {
+		if spec.Datastore == nil {
+			spec.Datastore = &DatastoreConfig{}
+		}
+		spec.Datastore.Backend = f.backend()
+	}
+}
+
+// backend Returns the recorded datastore backend. Clusters created before
+// the backend could be selected use the local one.
+func (f *FrozenSpec) backend() string {
+	if f.DatastoreBackend == "" {
+		return DatastoreBackendLocal
+	}
+	return f.DatastoreBackend
+}
+
+// ValidateFrozenFields Returns an error for each frozen field the spec
+// changes from the recorded values. Optional fields left unset in the spec
+// keep their recorded value and are not a change. The frozen fields are
+// spec.storageClassName, spec.ipfsStorage, spec.clusterStorage, spec.seed,
+// spec.datastore.backend and spec.cloneFrom.
+func ValidateFrozenFields(frozen FrozenSpec, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	if spec.StorageClassName != nil && frozen.StorageClassName != nil &&
+		*spec.StorageClassName != *frozen.StorageClassName {
+		errs = append(errs, frozenFieldError(specPath.Child("storageClassName"),
+			*spec.StorageClassName, migrateStorageClass))
+	}
+	if !sameQuantity(spec.IpfsStorage, frozen.IpfsStorage) {
+		errs = append(errs, frozenFieldError(specPath.Child("ipfsStorage"),
+			spec.IpfsStorage, fmt.Sprintf(migrateStorageSize, "ipfs-storage-*")))
+	}
+	if !sameQuantity(spec.ClusterStorage, frozen.ClusterStorage) {
+		errs = append(errs, frozenFieldError(specPath.Child("clusterStorage"),
+			spec.ClusterStorage, fmt.Sprintf(migrateStorageSize, "cluster-storage-*")))
+	}
+	if spec.Seed != nil && !equality.Semantic.DeepEqual(spec.Seed, frozen.Seed) {
+		errs = append(errs, frozenFieldError(specPath.Child("seed"), *spec.Seed, migrateSeed))
+	}
+	if spec.CloneFrom != "" && spec.CloneFrom != frozen.CloneFrom {
+		errs = append(errs, frozenFieldError(specPath.Child("cloneFrom"), spec.CloneFrom, migrateClone))
+	}
+	// An unset datastore is the local backend, so the s3 backend cannot be
+	// dropped from the spec.
+	if DatastoreBackendOf(spec) != frozen.backend() {
+		errs = append(errs, frozenFieldError(specPath.Child("datastore", "backend"),
+			DatastoreBackendOf(spec), migrateDatastore))
+	}
+	return errs
+}
+
+// validateDatastoreConfig Returns an error for each tuning of the datastore
+// which does not apply to its backend.
+func validateDatastoreConfig(datastorePath *field.Path, datastore *DatastoreConfig) field.ErrorList {
+	var errs field.ErrorList
+	local := datastore.Backend == "" || datastore.Backend == DatastoreBackendLocal
+	if datastore.NoSync && (!local || datastore.FlatfsShardingDepth == nil) {
+		errs = append(errs, field.Forbidden(datastorePath.Child("noSync"),
+			"only applies to the local backend on flatfs, badger never syncs its writes"))
+	}
+	if depth := datastore.FlatfsShardingDepth; depth != nil {
+		if !local {
+			errs = append(errs, field.Forbidden(datastorePath.Child("flatfsShardingDepth"),
+				"only applies to the local backend"))
+		}
+		if *depth < 1 || *depth > 4 {
+			errs = append(errs, field.Invalid(datastorePath.Child("flatfsShardingDepth"), *depth,
+				"must be between 1 and 4"))
+		}
+	}
+	if interval := datastore.BadgerGCInterval; interval != nil {
+		switch {
+		case !local || datastore.FlatfsShardingDepth != nil:
+			errs = append(errs, field.Forbidden(datastorePath.Child("badgerGCInterval"),
+				"only applies to the local backend on badger"))
+		case interval.Duration < time.Minute:
+			errs = append(errs, field.Invalid(datastorePath.Child("badgerGCInterval"), interval.Duration.String(),
+				"must be at least 1m"))
+		}
+	}
+	return errs
+}
+
+// RepoLayoutOf Returns the layout the repos of the peers are initialized
+// with by the spec.
+func RepoLayoutOf(spec *IpfsSpec) RepoLayout {
+	layout := RepoLayout{}
+	if spec.Datastore != nil && spec.Datastore.FlatfsShardingDepth != nil {
+		layout.FlatfsShardingDepth = *spec.Datastore.FlatfsShardingDepth
+	}
+	return layout
+}
+
+// ApplyTo Sets the layout of the repos of the spec to the recorded one.
+func (l *RepoLayout) ApplyTo(spec *IpfsSpec) {
+	if RepoLayoutOf(spec) == *l {
+		return
+	}
+	if spec.Datastore == nil {
+		spec.Datastore = &DatastoreConfig{}
+	}
+	spec.Datastore.FlatfsShardingDepth = nil
+	if l.FlatfsShardingDepth != 0 {
+		depth := l.FlatfsShardingDepth
+		spec.Datastore.FlatfsShardingDepth = &depth
+	}
+}
+
+// ValidateRepoLayout Returns an error when the spec changes the layout the
+// repos were initialized with, which cannot change on an existing repo.
+func ValidateRepoLayout(layout RepoLayout, spec *IpfsSpec) field.ErrorList {
+	if RepoLayoutOf(spec) == layout {
+		return nil
+	}
+	var value interface{} = "unset"
+	if depth := RepoLayoutOf(spec).FlatfsShardingDepth; depth != 0 {
+		value = depth
+	}
+	return field.ErrorList{field.Forbidden(field.NewPath("spec", "datastore", "flatfsShardingDepth"),
+		fmt.Sprintf("field is immutable once the first repo is initialized (got %v); %s", value, migrateDatastore))}
+}
+
+func frozenFieldError(path *field.Path, value interface{}, migration string) *field.Error {
+	return field.Forbidden(path, fmt.Sprintf("field is immutable once the cluster is created (got %v); %s",
+		value, migration))
+}
+
+// sameQuantity Returns whether both strings denote the same quantity, such as
+// 1Gi and 1024Mi.
+func sameQuantity(a, b string) bool {
+	qa, errA := resource.ParseQuantity(a)
+	qb, errB := resource.ParseQuantity(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return qa.Cmp(qb) == 0
+}
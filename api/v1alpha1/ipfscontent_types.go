@@ -0,0 +1,260 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MaxContentSize is the size of the largest content an IpfsContent adds,
+// which is the largest a ConfigMap or a Secret holds.
+const MaxContentSize = 1 << 20
+
+// ContentSourceKind is the kind of the object holding the content.
+// +kubebuilder:validation:Enum=ConfigMap;Secret
+type ContentSourceKind string
+
+const (
+	ContentSourceConfigMap ContentSourceKind = "ConfigMap"
+	ContentSourceSecret    ContentSourceKind = "Secret"
+)
+
+// Reasons of the Ready condition of an IpfsContent.
+const (
+	// ContentReasonPinned is set once the content is added and pinned
+	// through the cluster.
+	ContentReasonPinned = "Pinned"
+	// ContentReasonAdded is set once the content is added, when it is not
+	// to be pinned.
+	ContentReasonAdded = "Added"
+	// ContentReasonSourceNotFound is set when the object or its key do not
+	// exist.
+	ContentReasonSourceNotFound = "SourceNotFound"
+	// ContentReasonTooLarge is set when the content exceeds MaxContentSize.
+	ContentReasonTooLarge = "TooLarge"
+	// ContentReasonClusterNotFound is set when the cluster does not exist.
+	ContentReasonClusterNotFound = "ClusterNotFound"
+	// ContentReasonAddFailed is set when the kubo node refused the content.
+	ContentReasonAddFailed = "AddFailed"
+	// ContentReasonPinFailed is set when the cluster refused to pin or
+	// unpin the content.
+	ContentReasonPinFailed = "PinFailed"
+	// ContentReasonWaiting is set while the pin is held until the cluster
+	// recovers, or until the pins held before it were submitted.
+	ContentReasonWaiting = "Waiting"
+	// ContentReasonPinning is set while the peers the pin is allocated to
+	// pin the content.
+	ContentReasonPinning = "Pinning"
+	// ContentReasonStalled is set once no peer progressed in pinning the
+	// content for longer than spec.stallAfter.
+	ContentReasonStalled = "Stalled"
+	// ContentReasonPinTimedOut is set once the pin took longer than
+	// spec.timeout.
+	ContentReasonPinTimedOut = "PinTimedOut"
+)
+
+// ContentSourceRef names the key of a ConfigMap or a Secret of the namespace.
+type ContentSourceRef struct {
+	Kind ContentSourceKind `json:"kind"`
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Key is the key holding the content. The data and the binary data of a
+	// ConfigMap are both looked up.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	Key string `json:"key"`
+}
+
+// ContentClusterRef names an Ipfs resource of the namespace.
+type ContentClusterRef struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// PinPriority is the class a pin is submitted in, among the pins the
+// operator holds for a cluster.
+// +kubebuilder:validation:Enum=High;Normal;Low
+type PinPriority string
+
+const (
+	PinPriorityHigh   PinPriority = "High"
+	PinPriorityNormal PinPriority = "Normal"
+	PinPriorityLow    PinPriority = "Low"
+)
+
+// PinQueueStatus reports a pin while it is held.
+type PinQueueStatus struct {
+	// HeldSince is when the pin was first held. Its priority is raised by
+	// one class every ten minutes held, so that it is not held forever.
+	HeldSince metav1.Time `json:"heldSince"`
+	// Position estimates the number of pins the cluster handles before it,
+	// counting the pins it is still pinning and those held before it, itself
+	// included, as of the last check.
+	// +optional
+	Position int32 `json:"position,omitempty"`
+}
+
+// PinProgress reports the pin of a CID from its submission until every peer
+// it is allocated to pinned it. The stall and the timeout of the pin are
+// derived from the times it records, so that they survive restarts of the
+// operator.
+type PinProgress struct {
+	// CID is the CID pinned.
+	CID string `json:"cid"`
+	// Generation is the generation of the spec the pin is tracked for. A pin
+	// which timed out is tracked again once the spec changes.
+	Generation int64 `json:"generation"`
+	// StartedAt is when the pin was submitted.
+	StartedAt metav1.Time `json:"startedAt"`
+	// LastProgressTime is when a peer last moved on in pinning the CID, or
+	// when the pin was submitted.
+	LastProgressTime metav1.Time `json:"lastProgressTime"`
+	// Peers is the number of peers the pin is allocated to, as of the last
+	// check.
+	// +optional
+	Peers int32 `json:"peers,omitempty"`
+	// Queued is the number of those peers which did not start pinning.
+	// +optional
+	Queued int32 `json:"queued,omitempty"`
+	// Pinning is the number of those peers fetching the blocks.
+	// +optional
+	Pinning int32 `json:"pinning,omitempty"`
+	// Pinned is the number of those peers which pinned the CID.
+	// +optional
+	Pinned int32 `json:"pinned,omitempty"`
+	// Failed is the number of those peers which failed to pin the CID.
+	// +optional
+	Failed int32 `json:"failed,omitempty"`
+	// Percent estimates how far the pin got, a peer pinning counting for
+	// half of a peer which pinned it.
+	// +optional
+	Percent int32 `json:"percent,omitempty"`
+	// RecoveredAt is when the stalled pin was recovered, which is tried once.
+	// +optional
+	RecoveredAt *metav1.Time `json:"recoveredAt,omitempty"`
+	// CompletedAt is when every peer the pin is allocated to pinned it.
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// TimedOutAt is when the pin failed for taking longer than the timeout.
+	// +optional
+	TimedOutAt *metav1.Time `json:"timedOutAt,omitempty"`
+}
+
+// IpfsContentSpec names the content to add to a cluster.
+type IpfsContentSpec struct {
+	SourceRef  ContentSourceRef  `json:"sourceRef"`
+	ClusterRef ContentClusterRef `json:"clusterRef"`
+	// Pin pins the content through the cluster. Without it, the content is
+	// only added to a kubo node, whose garbage collection may drop it.
+	// +kubebuilder:default=true
+	// +optional
+	Pin *bool `json:"pin,omitempty"`
+	// Force submits the pin while the cluster is degraded, rather than
+	// holding it until the cluster recovers.
+	// +optional
+	Force bool `json:"force,omitempty"`
+	// Priority orders the pin among the pins held for the cluster. Low
+	// priority pins are also held while many high priority pins are held or
+	// pinning.
+	// +kubebuilder:default=Normal
+	// +optional
+	Priority PinPriority `json:"priority,omitempty"`
+	// RetainPrevious is the number of previous versions of the content kept
+	// pinned once the source changes; older versions are unpinned. Unless
+	// set, the previous versions are left pinned and are not tracked.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=16
+	// +optional
+	RetainPrevious *int32 `json:"retainPrevious,omitempty"`
+	// StallAfter is how long the pin may go without any peer progressing
+	// before it is marked Stalled and recovered, once. Defaults to 30m.
+	// +optional
+	StallAfter *metav1.Duration `json:"stallAfter,omitempty"`
+	// Timeout fails the pin once it took longer to complete. Unless set, the
+	// pin is tracked until it completes.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// UnpinOnTimeout unpins the content once the pin timed out, so that the
+	// peers stop fetching it.
+	// +optional
+	UnpinOnTimeout bool `json:"unpinOnTimeout,omitempty"`
+}
+
+// IpfsContentStatus reports the CID of the content.
+type IpfsContentStatus struct {
+	// ObservedGeneration is the generation of the spec last added.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// CID is the CID of the current version of the content.
+	// +optional
+	CID string `json:"cid,omitempty"`
+	// Size is the size of the content, in bytes.
+	// +optional
+	Size int64 `json:"size,omitempty"`
+	// SourceHash is the digest of the content, which tells whether the
+	// source changed since it was added.
+	// +optional
+	SourceHash string `json:"sourceHash,omitempty"`
+	// Pinned is whether the current version is pinned through the cluster.
+	// +optional
+	Pinned bool `json:"pinned,omitempty"`
+	// Previous lists the CIDs of the previous versions kept pinned, the
+	// most recent first.
+	// +optional
+	Previous []string `json:"previous,omitempty"`
+	// LastUnpinTime is when a version of the content was last unpinned,
+	// which the garbage collection of the cluster waits a quiet period after.
+	// +optional
+	LastUnpinTime *metav1.Time `json:"lastUnpinTime,omitempty"`
+	// Queue reports the pin of the current version while it is held.
+	// +optional
+	Queue *PinQueueStatus `json:"queue,omitempty"`
+	// PinProgress reports the pin of the current version once submitted.
+	// +optional
+	PinProgress *PinProgress `json:"pinProgress,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="CID",type=string,JSONPath=`.status.cid`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IpfsContent is the Schema for the ipfscontents API.
+type IpfsContent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IpfsContentSpec   `json:"spec,omitempty"`
+	Status IpfsContentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IpfsContentList contains a list of IpfsContent.
+type IpfsContentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IpfsContent `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IpfsContent{}, &IpfsContentList{})
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FleetReportName is the name of the single IpfsFleetReport the operator
+// maintains.
+const FleetReportName = "fleet"
+
+// Kinds of the resources the IpfsFleetReport flags as shared too widely.
+const (
+	// SharedResourceNode is a node running most of the peers of several
+	// clusters, which its failure would all take down.
+	SharedResourceNode = "Node"
+	// SharedResourceRelay is a circuit relay many clusters depend on.
+	SharedResourceRelay = "Relay"
+)
+
+// FleetCluster lists the resources an Ipfs resource depends on.
+type FleetCluster struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Nodes lists the nodes running the peers of the cluster.
+	// +optional
+	Nodes []FleetNode `json:"nodes,omitempty"`
+	// StorageClass is the storage class of the volumes of the peers, empty
+	// for the default storage class.
+	// +optional
+	StorageClass string `json:"storageClass,omitempty"`
+	// Relays lists the peer IDs of the circuit relays of the cluster.
+	// +optional
+	Relays []string `json:"relays,omitempty"`
+}
+
+// FleetNode is a node running peers of a cluster.
+type FleetNode struct {
+	Name string `json:"name"`
+	// Peers is the number of peers of the cluster the node runs.
+	Peers int32 `json:"peers"`
+}
+
+// SharedResource is a resource shared by more clusters than is safe.
+type SharedResource struct {
+	// Kind is Node or Relay.
+	Kind string `json:"kind"`
+	// Name is the name of the node, or the peer ID of the relay.
+	Name string `json:"name"`
+	// Clusters lists the clusters sharing the resource, as namespace/name.
+	Clusters []string `json:"clusters"`
+	// Message tells why the sharing is risky.
+	Message string `json:"message"`
+}
+
+// IpfsFleetReportSpec is empty: the report is maintained by the operator.
+type IpfsFleetReportSpec struct {
+}
+
+// IpfsFleetReportStatus maps every Ipfs resource to the nodes, storage class
+// and relays it depends on, and flags the risky sharing.
+type IpfsFleetReportStatus struct {
+	// GeneratedAt is the time the report was built.
+	GeneratedAt metav1.Time `json:"generatedAt"`
+	// Clusters lists the Ipfs resources, sorted by namespace and name.
+	// +optional
+	Clusters []FleetCluster `json:"clusters,omitempty"`
+	// SharedResources lists the resources shared by more clusters than is
+	// safe.
+	// +optional
+	SharedResources []SharedResource `json:"sharedResources,omitempty"`
+	// SharedResourceCount is the number of resources shared by more clusters
+	// than is safe.
+	// +optional
+	SharedResourceCount int32 `json:"sharedResourceCount,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Shared",type=integer,JSONPath=`.status.sharedResourceCount`
+//+kubebuilder:printcolumn:name="Generated",type=date,JSONPath=`.status.generatedAt`
+
+// IpfsFleetReport is the Schema for the ipfsfleetreports API.
+type IpfsFleetReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IpfsFleetReportSpec   `json:"spec,omitempty"`
+	Status IpfsFleetReportStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IpfsFleetReportList contains a list of IpfsFleetReport.
+type IpfsFleetReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IpfsFleetReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IpfsFleetReport{}, &IpfsFleetReportList{})
+}
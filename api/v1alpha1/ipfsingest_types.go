@@ -0,0 +1,211 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MaxIngestCheckpoints is the number of top-level entries the status of an
+// IpfsIngest lists, so that directories of many entries fit in the object.
+// The count of the entries added is kept regardless.
+const MaxIngestCheckpoints = 256
+
+// AnnotationReingest runs the ingestion of an IpfsIngest again when set to
+// "true", or resumes it once its Job failed. The operator removes the
+// annotation once the run completed.
+const AnnotationReingest = "ipfs.cluster.io/reingest"
+
+// Reasons of the Ready condition of an IpfsIngest.
+const (
+	// IngestReasonPinned is set once the directory is added and its root
+	// pinned through the cluster.
+	IngestReasonPinned = "Pinned"
+	// IngestReasonIngesting is set while the Job adds the directory.
+	IngestReasonIngesting = "Ingesting"
+	// IngestReasonSourceNotFound is set when the claim does not exist.
+	IngestReasonSourceNotFound = "SourceNotFound"
+	// IngestReasonClusterNotFound is set when the cluster does not exist.
+	IngestReasonClusterNotFound = "ClusterNotFound"
+	// IngestReasonFailed is set when the Job failed.
+	IngestReasonFailed = "IngestFailed"
+	// IngestReasonPinFailed is set when the cluster refused to pin the root,
+	// or to unpin the previous one.
+	IngestReasonPinFailed = "PinFailed"
+	// IngestReasonWaiting is set while the pin of the root is held until the
+	// cluster recovers, or until the pins held before it were submitted.
+	IngestReasonWaiting = "Waiting"
+	// IngestReasonPinning is set while the peers the pin is allocated to pin
+	// the root.
+	IngestReasonPinning = "Pinning"
+	// IngestReasonStalled is set once no peer progressed in pinning the root
+	// for longer than spec.stallAfter.
+	IngestReasonStalled = "Stalled"
+	// IngestReasonPinTimedOut is set once the pin of the root took longer
+	// than spec.timeout.
+	IngestReasonPinTimedOut = "PinTimedOut"
+)
+
+// IngestSource names the directory of a PersistentVolumeClaim of the
+// namespace.
+type IngestSource struct {
+	// ClaimName is the PersistentVolumeClaim holding the directory, which is
+	// mounted read-only.
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+	// SubPath is the directory within the volume, relative to its root.
+	// Unless set, the whole volume is added.
+	// +kubebuilder:validation:MaxLength=4096
+	// +kubebuilder:validation:Pattern=`^[^/]+(/[^/]+)*$`
+	// +optional
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// IpfsIngestSpec names the directory to add to a cluster, and how.
+type IpfsIngestSpec struct {
+	Source     IngestSource      `json:"source"`
+	ClusterRef ContentClusterRef `json:"clusterRef"`
+	// WrapWithDirectory pins a directory holding the directory under its
+	// name, the last element of the subPath or the name of the claim, rather
+	// than the directory itself.
+	// +optional
+	WrapWithDirectory bool `json:"wrapWithDirectory,omitempty"`
+	// Chunker is how the files are split into blocks, as ipfs add takes it.
+	// Unless set, the default of kubo is used.
+	// +kubebuilder:validation:Pattern=`^(size-[1-9][0-9]*|rabin(-[1-9][0-9]*){0,3}|buzhash)$`
+	// +optional
+	Chunker string `json:"chunker,omitempty"`
+	// RawLeaves stores the data of the files in raw blocks rather than in
+	// UnixFS nodes. Unless set, the default of kubo is used.
+	// +optional
+	RawLeaves *bool `json:"rawLeaves,omitempty"`
+	// Force submits the pin of the root while the cluster is degraded,
+	// rather than holding it until the cluster recovers.
+	// +optional
+	Force bool `json:"force,omitempty"`
+	// Priority orders the pin of the root among the pins held for the
+	// cluster. Low priority pins are also held while many high priority pins
+	// are held or pinning.
+	// +kubebuilder:default=Normal
+	// +optional
+	Priority PinPriority `json:"priority,omitempty"`
+	// StallAfter is how long the pin of the root may go without any peer
+	// progressing before it is marked Stalled and recovered, once. Defaults
+	// to 30m.
+	// +optional
+	StallAfter *metav1.Duration `json:"stallAfter,omitempty"`
+	// Timeout fails the pin of the root once it took longer to complete.
+	// Unless set, the pin is tracked until it completes.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// UnpinOnTimeout unpins the root once the pin timed out, so that the
+	// peers stop fetching it.
+	// +optional
+	UnpinOnTimeout bool `json:"unpinOnTimeout,omitempty"`
+}
+
+// IngestCheckpoint is a top-level entry of the directory added by the
+// current run.
+type IngestCheckpoint struct {
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+}
+
+// IngestProgress reports how far the current run got.
+type IngestProgress struct {
+	// Generation is the generation of the spec the run adds.
+	Generation int64 `json:"generation"`
+	// StartTime is when the Job of the run was created.
+	StartTime metav1.Time `json:"startTime"`
+	// Entries is the number of top-level entries of the directory, once the
+	// Job listed them.
+	// +optional
+	Entries int32 `json:"entries,omitempty"`
+	// Completed is the number of top-level entries added so far.
+	// +optional
+	Completed int32 `json:"completed,omitempty"`
+	// Checkpoints lists the top-level entries added so far, up to
+	// MaxIngestCheckpoints of them. A Job resuming the run skips them.
+	// +optional
+	Checkpoints []IngestCheckpoint `json:"checkpoints,omitempty"`
+}
+
+// IpfsIngestStatus reports the root CID of the directory.
+type IpfsIngestStatus struct {
+	// ObservedGeneration is the generation of the spec last ingested.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// CID is the root CID of the directory last ingested.
+	// +optional
+	CID string `json:"cid,omitempty"`
+	// TotalBytes is the size of the files of the directory, in bytes.
+	// +optional
+	TotalBytes int64 `json:"totalBytes,omitempty"`
+	// Files is the number of files of the directory.
+	// +optional
+	Files int64 `json:"files,omitempty"`
+	// Pinned is whether the root is pinned through the cluster.
+	// +optional
+	Pinned bool `json:"pinned,omitempty"`
+	// CompletionTime is when the last run completed.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// LastUnpinTime is when the previous root was last unpinned, which the
+	// garbage collection of the cluster waits a quiet period after.
+	// +optional
+	LastUnpinTime *metav1.Time `json:"lastUnpinTime,omitempty"`
+	// Progress reports the current run, or the last one once it completed.
+	// +optional
+	Progress *IngestProgress `json:"progress,omitempty"`
+	// Queue reports the pin of the root while it is held.
+	// +optional
+	Queue *PinQueueStatus `json:"queue,omitempty"`
+	// PinProgress reports the pin of the root once submitted.
+	// +optional
+	PinProgress *PinProgress `json:"pinProgress,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="CID",type=string,JSONPath=`.status.cid`
+//+kubebuilder:printcolumn:name="Files",type=integer,JSONPath=`.status.files`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IpfsIngest is the Schema for the ipfsingests API.
+type IpfsIngest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IpfsIngestSpec   `json:"spec,omitempty"`
+	Status IpfsIngestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IpfsIngestList contains a list of IpfsIngest.
+type IpfsIngestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IpfsIngest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IpfsIngest{}, &IpfsIngestList{})
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// OperatorConfigName is the name of the single IpfsOperatorConfig the
+// operator reads. The others are ignored.
+const OperatorConfigName = "cluster"
+
+// Conditions of the IpfsOperatorConfig.
+const (
+	// ConditionApplied is true while the operator applies the
+	// configuration, and false while it is invalid or ignored.
+	ConditionApplied = "Applied"
+)
+
+// Reasons of the Applied condition of the IpfsOperatorConfig.
+const (
+	AppliedReasonApplied = "Applied"
+	AppliedReasonInvalid = "Invalid"
+	AppliedReasonIgnored = "Ignored"
+)
+
+// IpfsOperatorConfigSpec sets the policies of the operator for every Ipfs
+// resource. The fields left unset keep the values of the defaults file of
+// the operator, or its built-in defaults.
+type IpfsOperatorConfigSpec struct {
+	// IpfsImage is the image of the ipfs containers of the clusters which do
+	// not set one.
+	// +optional
+	IpfsImage string `json:"ipfsImage,omitempty"`
+	// ClusterImage is the image of the ipfs-cluster containers of the
+	// clusters which do not set one.
+	// +optional
+	ClusterImage string `json:"clusterImage,omitempty"`
+	// AuthProxyImage is the image of the authenticating proxy of the
+	// clusters which do not set one.
+	// +optional
+	AuthProxyImage string `json:"authProxyImage,omitempty"`
+	// StorageClassName is the storage class of the volumes of the clusters
+	// which do not set one.
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// MaintenanceWindow is the maintenance window of the clusters which do
+	// not set one.
+	// +optional
+	MaintenanceWindow *MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+	// Budget bounds the resources of every cluster, unless its namespace
+	// sets its own budget.
+	// +optional
+	Budget *Budget `json:"budget,omitempty"`
+}
+
+// OutOfPolicyIpfs names an Ipfs resource exceeding the budget which applies to it.
+type OutOfPolicyIpfs struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Message lists the limits the resource exceeds.
+	Message string `json:"message"`
+}
+
+// IpfsOperatorConfigStatus reports how the configuration applies.
+type IpfsOperatorConfigStatus struct {
+	// ObservedGeneration is the generation of the spec last applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// OutOfPolicy lists the Ipfs resources exceeding their budget, as of
+	// the last check.
+	// +optional
+	OutOfPolicy []OutOfPolicyIpfs `json:"outOfPolicy,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Applied",type=string,JSONPath=`.status.conditions[?(@.type=="Applied")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IpfsOperatorConfig is the Schema for the ipfsoperatorconfigs API.
+type IpfsOperatorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IpfsOperatorConfigSpec   `json:"spec,omitempty"`
+	Status IpfsOperatorConfigStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IpfsOperatorConfigList contains a list of IpfsOperatorConfig.
+type IpfsOperatorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IpfsOperatorConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IpfsOperatorConfig{}, &IpfsOperatorConfigList{})
+}
+
+// ValidateOperatorConfigSpec Returns an error for each field of the
+// configuration the operator cannot apply.
+func ValidateOperatorConfigSpec(spec *IpfsOperatorConfigSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.MaintenanceWindow != nil {
+		errs = append(errs, validateMaintenanceWindow(field.NewPath("spec", "maintenanceWindow"),
+			spec.MaintenanceWindow)...)
+	}
+	return errs
+}
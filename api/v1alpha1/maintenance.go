@@ -0,0 +1,286 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// scheduleHorizon is how far ahead the next start of a schedule is looked
+// for, so that schedules which never match, such as February 30, end.
+const scheduleHorizon = 5 * 366 * 24 * time.Hour
+
+// scheduleField is the range and the names of the values of a field of a
+// cron expression.
+type scheduleField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{
+		"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC",
+	}},
+	{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+// Schedule is a parsed cron expression. Each field holds a bit per value it
+// matches.
+// +kubebuilder:object:generate=false
+type Schedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// anyDay is true when either day field is "*", in which case a day must
+	// match both fields instead of either of them.
+	anyDay bool
+}
+
+// ParseSchedule Parses a cron expression with five fields: minute, hour, day
+// of month, month and day of week. Each field is "*", a value, a range of
+// values such as "1-5", or a list of them such as "1,3,5", each optionally
+// stepped such as "*/15". Months and days of week may be named, such as
+// "JAN" or "SAT", and Sunday is either 0 or 7.
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("%q has %d fields instead of 5", expr, len(fields))
+	}
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		var err error
+		if bits[i], err = parseScheduleField(f, scheduleFields[i]); err != nil {
+			return nil, fmt.Errorf("%q: %w", expr, err)
+		}
+	}
+	// Sunday is both 0 and 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &Schedule{
+		minute:     bits[0],
+		hour:       bits[1],
+		dayOfMonth: bits[2],
+		month:      bits[3],
+		dayOfWeek:  bits[4],
+		anyDay:     strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseScheduleField Returns the bits of the values matched by a field.
+func parseScheduleField(expr string, f scheduleField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		values, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			values = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", f.name, part)
+			}
+		}
+		first, last := f.min, f.max
+		if values != "*" {
+			bounds := strings.SplitN(values, "-", 2)
+			var err error
+			if first, err = scheduleValue(bounds[0], f); err != nil {
+				return 0, err
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = scheduleValue(bounds[1], f); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				last = f.max
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid range in %s %q", f.name, part)
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// scheduleValue Parses a value of a field, either a number or a name.
+func scheduleValue(value string, f scheduleField) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s %q is not within %d-%d", f.name, value, f.min, f.max)
+	}
+	return v, nil
+}
+
+// matchesDay Returns whether the schedule matches the day of the wall clock
+// time.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// nextWallClock Returns the first wall clock time after the given one the
+// schedule matches. Wall clock times are held in UTC, so that no minute is
+// skipped or repeated. It returns the zero time when the schedule does not
+// match within the horizon.
+func (s *Schedule) nextWallClock(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	end := after.Add(scheduleHorizon)
+	for t.Before(end) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// wallClock Returns the wall clock time of the instant in the location,
+// held in UTC.
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// instant Returns the instant the wall clock time reads in the location. A
+// wall clock time which is skipped when the clocks move forward reads as
+// much later as the clocks moved, and a wall clock time which is repeated
+// when the clocks move back reads at its first occurrence.
+func instant(wall time.Time, loc *time.Location) time.Time {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, loc)
+	if skipped := wall.Sub(wallClock(t, loc)); skipped != 0 {
+		t = t.Add(skipped)
+	}
+	return t
+}
+
+// Next Returns the first instant after the given one the schedule starts at
+// in the location, or the zero time when it does not start within the next
+// five years.
+func (s *Schedule) Next(after time.Time, loc *time.Location) time.Time {
+	// The wall clock times skipped when the clocks move forward read later
+	// than they are, so the lookup starts a day early.
+	wall := wallClock(after, loc).Add(-24 * time.Hour)
+	for {
+		wall = s.nextWallClock(wall)
+		if wall.IsZero() {
+			return wall
+		}
+		if t := instant(wall, loc); t.After(after) {
+			return t
+		}
+	}
+}
+
+// Location Returns the time zone of the schedules of the window.
+func (w *MaintenanceWindow) Location() (*time.Location, error) {
+	if w.TimeZone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(w.TimeZone)
+}
+
+// Open Returns whether the window is open at the given time, along with when
+// it closes if it is, or when it next opens otherwise. The latter is the
+// zero time when the window never opens again.
+func (w *MaintenanceWindow) Open(now time.Time) (bool, time.Time, error) {
+	loc, err := w.Location()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	open, closes, opens := false, time.Time{}, time.Time{}
+	for _, expr := range w.Schedules {
+		schedule, err := ParseSchedule(expr)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		// The windows started within the last duration are still open.
+		start := schedule.Next(now.Add(-w.Duration.Duration), loc)
+		switch {
+		case start.IsZero():
+		case !start.After(now):
+			if end := start.Add(w.Duration.Duration); !open || end.After(closes) {
+				closes = end
+			}
+			open = true
+		case opens.IsZero() || start.Before(opens):
+			opens = start
+		}
+	}
+	if open {
+		return true, closes.In(loc), nil
+	}
+	if opens.IsZero() {
+		return false, opens, nil
+	}
+	return false, opens.In(loc), nil
+}
+
+// validateMaintenanceWindow Returns an error for each schedule which cannot
+// be parsed or never starts, and for an unknown time zone or a duration
+// which is not positive.
+func validateMaintenanceWindow(windowPath *field.Path, w *MaintenanceWindow) field.ErrorList {
+	var errs field.ErrorList
+	loc, err := w.Location()
+	if err != nil {
+		errs = append(errs, field.Invalid(windowPath.Child("timeZone"), w.TimeZone, "unknown time zone"))
+		loc = time.UTC
+	}
+	if w.Duration.Duration <= 0 {
+		errs = append(errs, field.Invalid(windowPath.Child("duration"), w.Duration.Duration.String(),
+			"must be positive"))
+	}
+	for i, expr := range w.Schedules {
+		schedule, err := ParseSchedule(expr)
+		if err != nil {
+			errs = append(errs, field.Invalid(windowPath.Child("schedules").Index(i), expr, err.Error()))
+			continue
+		}
+		if schedule.Next(time.Now(), loc).IsZero() {
+			errs = append(errs, field.Invalid(windowPath.Child("schedules").Index(i), expr,
+				"never starts within the next five years"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,115 @@
+package v1alpha1
+
+import (
+	"time"
+	_ "time/tzdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Maintenance windows", func() {
+	var newYork *time.Location
+
+	BeforeEach(func() {
+		var err error
+		newYork, err = time.LoadLocation("America/New_York")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	next := func(expr string, after time.Time, loc *time.Location) time.Time {
+		schedule, err := ParseSchedule(expr)
+		Expect(err).NotTo(HaveOccurred())
+		return schedule.Next(after, loc)
+	}
+
+	It("parses cron expressions", func() {
+		saturday := time.Date(2024, 6, 1, 2, 0, 0, 0, time.UTC)
+		Expect(next("0 2 * * SAT", time.Date(2024, 5, 29, 12, 0, 0, 0, time.UTC), time.UTC)).To(Equal(saturday))
+		Expect(next("0 2 * * 6", saturday, time.UTC)).To(Equal(saturday.AddDate(0, 0, 7)))
+		Expect(next("*/20 9-17 * * MON-FRI", time.Date(2024, 5, 31, 17, 45, 0, 0, time.UTC), time.UTC)).
+			To(Equal(time.Date(2024, 6, 3, 9, 0, 0, 0, time.UTC)))
+		Expect(next("0 0 * * 7", saturday, time.UTC)).To(Equal(time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)))
+		Expect(next("0 0 1 jan *", saturday, time.UTC)).To(Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+		By("matching either day field when both are restricted")
+		Expect(next("0 0 15 * MON", saturday, time.UTC)).To(Equal(time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)))
+		Expect(next("0 0 3 * SUN", saturday, time.UTC)).To(Equal(time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)))
+
+		for _, expr := range []string{"0 2 * *", "60 * * * *", "0 2 * * FUNDAY", "0 5-2 * * *", "*/0 * * * *"} {
+			_, err := ParseSchedule(expr)
+			Expect(err).To(HaveOccurred(), expr)
+		}
+	})
+
+	It("starts the windows skipped by the clocks moving forward as much later", func() {
+		// The clocks of New York moved from 2am to 3am on March 10, 2024.
+		start := next("30 2 * * *", time.Date(2024, 3, 9, 12, 0, 0, 0, newYork), newYork)
+		Expect(start).To(BeTemporally("==", time.Date(2024, 3, 10, 3, 30, 0, 0, newYork)))
+		Expect(next("30 2 * * *", start, newYork)).To(BeTemporally("==", time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)))
+
+		By("finding the shifted start from within the skipped hour")
+		Expect(next("30 2 * * *", time.Date(2024, 3, 10, 3, 10, 0, 0, newYork), newYork)).
+			To(BeTemporally("==", time.Date(2024, 3, 10, 3, 30, 0, 0, newYork)))
+	})
+
+	It("starts the windows repeated by the clocks moving back once", func() {
+		// The clocks of New York moved from 2am back to 1am on November 3, 2024.
+		start := next("30 1 * * *", time.Date(2024, 11, 2, 12, 0, 0, 0, newYork), newYork)
+		Expect(start).To(BeTemporally("==", time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC)))
+		Expect(next("30 1 * * *", start, newYork)).To(BeTemporally("==", time.Date(2024, 11, 4, 1, 30, 0, 0, newYork)))
+
+		By("skipping the repeated hour")
+		Expect(next("0 * * * *", time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), newYork)).
+			To(BeTemporally("==", time.Date(2024, 11, 3, 2, 0, 0, 0, newYork)))
+	})
+
+	It("keeps the windows open for their duration across the clocks changing", func() {
+		window := &MaintenanceWindow{
+			Schedules: []string{"0 0 * * SUN"},
+			Duration:  metav1.Duration{Duration: 4 * time.Hour},
+			TimeZone:  "America/New_York",
+		}
+		open, closes, err := window.Open(time.Date(2024, 3, 10, 4, 30, 0, 0, newYork))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(open).To(BeTrue())
+		Expect(closes).To(BeTemporally("==", time.Date(2024, 3, 10, 5, 0, 0, 0, newYork)))
+
+		open, opens, err := window.Open(time.Date(2024, 3, 10, 5, 0, 0, 0, newYork))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(open).To(BeFalse())
+		Expect(opens).To(BeTemporally("==", time.Date(2024, 3, 17, 0, 0, 0, 0, newYork)))
+		Expect(opens.Location()).To(Equal(newYork))
+
+		open, closes, err = window.Open(time.Date(2024, 11, 3, 1, 30, 0, 0, time.FixedZone("EST", -5*3600)))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(open).To(BeTrue())
+		Expect(closes).To(BeTemporally("==", time.Date(2024, 11, 3, 3, 0, 0, 0, newYork)))
+	})
+
+	It("opens at the earliest of the schedules", func() {
+		window := &MaintenanceWindow{
+			Schedules: []string{"0 22 * * FRI", "0 3 * * *"},
+			Duration:  metav1.Duration{Duration: time.Hour},
+		}
+		open, opens, err := window.Open(time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(open).To(BeFalse())
+		Expect(opens).To(Equal(time.Date(2024, 5, 31, 22, 0, 0, 0, time.UTC)))
+	})
+
+	It("validates the schedules, the duration and the time zone", func() {
+		spec := &IpfsSpec{Replicas: 1, MaintenanceWindow: &MaintenanceWindow{
+			Schedules: []string{"0 2 * * SAT", "0 0 30 2 *", "whenever"},
+			TimeZone:  "Mars/Olympus_Mons",
+		}}
+		errs := ValidateSpec(spec).ToAggregate().Error()
+		Expect(errs).To(ContainSubstring("spec.maintenanceWindow.timeZone"))
+		Expect(errs).To(ContainSubstring("spec.maintenanceWindow.duration"))
+		Expect(errs).To(ContainSubstring("spec.maintenanceWindow.schedules[1]"))
+		Expect(errs).To(ContainSubstring("never starts"))
+		Expect(errs).To(ContainSubstring("spec.maintenanceWindow.schedules[2]"))
+		Expect(errs).NotTo(ContainSubstring("spec.maintenanceWindow.schedules[0]"))
+	})
+})
@@ -0,0 +1,61 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ParseCIDR parses a range of addresses in CIDR notation, such as
+// "10.0.0.0/8" or "fd00::/8", or a single IPv4 or IPv6 address, which is the
+// range holding only it. The address of a range is masked, so that
+// "10.1.2.3/8" is 10.0.0.0/8.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return ipNet, nil
+}
+
+// validateNetworkAuditConfig returns an error for each allowed range which cannot
+// be parsed.
+func validateNetworkAuditConfig(path *field.Path, network *NetworkAuditConfig) field.ErrorList {
+	var errs field.ErrorList
+	for i, cidr := range network.AllowedAnnounceCIDRs {
+		if _, err := ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(path.Child("allowedAnnounceCIDRs").Index(i), cidr,
+				"must be a CIDR such as 10.0.0.0/8 or fd00::/8, or an IP address"))
+		}
+	}
+	return errs
+}
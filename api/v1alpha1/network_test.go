@@ -0,0 +1,38 @@
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Announce ranges", func() {
+	table.DescribeTable("parses CIDRs and single addresses",
+		func(cidr, expected string) {
+			ipNet, err := ParseCIDR(cidr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ipNet.String()).To(Equal(expected))
+		},
+		table.Entry("an IPv4 range", "10.244.0.0/16", "10.244.0.0/16"),
+		table.Entry("an IPv4 range with host bits", "10.244.3.7/16", "10.244.0.0/16"),
+		table.Entry("an IPv4 address", " 203.0.113.7 ", "203.0.113.7/32"),
+		table.Entry("an IPv6 range", "fd00:10:244::/56", "fd00:10:244::/56"),
+		table.Entry("an IPv6 range with host bits", "2001:db8::1/32", "2001:db8::/32"),
+		table.Entry("an IPv6 address", "2001:db8::7", "2001:db8::7/128"),
+		table.Entry("an IPv4-mapped IPv6 address", "::ffff:192.0.2.1", "192.0.2.1/32"),
+	)
+
+	table.DescribeTable("refuses what is not a range",
+		func(cidr string) {
+			_, err := ParseCIDR(cidr)
+			Expect(err).To(HaveOccurred())
+		},
+		table.Entry("an empty string", ""),
+		table.Entry("a host name", "example.com"),
+		table.Entry("an IPv4 mask too long", "10.0.0.0/33"),
+		table.Entry("an IPv6 mask too long", "fd00::/129"),
+		table.Entry("a mask without address", "/8"),
+		table.Entry("an incomplete IPv4 address", "10.0.0/8"),
+		table.Entry("an IPv6 zone", "fe80::1%eth0"),
+	)
+})
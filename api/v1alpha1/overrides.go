@@ -0,0 +1,111 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ParseOrdinals Returns the first and last ordinals of an ordinal, such as
+// "0", or of an inclusive range of ordinals, such as "2-4".
+func ParseOrdinals(ordinals string) (int32, int32, error) {
+	parts := strings.SplitN(ordinals, "-", 2)
+	first, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("%q is not an ordinal or a range of ordinals", ordinals)
+	}
+	last := first
+	if len(parts) == 2 {
+		last, err = strconv.ParseInt(parts[1], 10, 32)
+		if err != nil || last < first {
+			return 0, 0, fmt.Errorf("%q is not an ordinal or a range of ordinals", ordinals)
+		}
+	}
+	return int32(first), int32(last), nil
+}
+
+// Selects Returns whether the override applies to the peer with the given
+// ordinal. Invalid ordinals select no peer.
+func (o *PeerOverride) Selects(ordinal int32) bool {
+	for _, ordinals := range o.Ordinals {
+		first, last, err := ParseOrdinals(ordinals)
+		if err == nil && ordinal >= first && ordinal <= last {
+			return true
+		}
+	}
+	return false
+}
+
+// OverrideFor Returns the override of the peer with the given ordinal, or
+// nil when the peer has none.
+func (s *IpfsSpec) OverrideFor(ordinal int32) *PeerOverride {
+	for i := range s.Overrides {
+		if s.Overrides[i].Selects(ordinal) {
+			return &s.Overrides[i]
+		}
+	}
+	return nil
+}
+
+// validateOverrides Returns an error for each override selecting ordinals
+// beyond the peers of the cluster, or peers another override already
+// selects, and for each invalid storage size.
+func validateOverrides(overridesPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	peers := spec.Replicas
+	if spec.Autoscaling != nil {
+		peers = spec.Autoscaling.MaxReplicas
+	}
+	selected := map[int32]int{}
+	for i := range spec.Overrides {
+		override := &spec.Overrides[i]
+		overridePath := overridesPath.Index(i)
+		for j, ordinals := range override.Ordinals {
+			ordinalsPath := overridePath.Child("ordinals").Index(j)
+			first, last, err := ParseOrdinals(ordinals)
+			if err != nil {
+				errs = append(errs, field.Invalid(ordinalsPath, ordinals, err.Error()))
+				continue
+			}
+			if last >= peers {
+				errs = append(errs, field.Invalid(ordinalsPath, ordinals,
+					fmt.Sprintf("the cluster has %d peers, with ordinals 0 to %d", peers, peers-1)))
+				continue
+			}
+			for ordinal := first; ordinal <= last; ordinal++ {
+				if other, ok := selected[ordinal]; ok && other != i {
+					errs = append(errs, field.Invalid(ordinalsPath, ordinals,
+						fmt.Sprintf("peer %d is already selected by override %d", ordinal, other)))
+					break
+				}
+				selected[ordinal] = i
+			}
+		}
+		if override.IpfsStorage != "" {
+			if _, err := resource.ParseQuantity(override.IpfsStorage); err != nil {
+				errs = append(errs, field.Invalid(overridePath.Child("ipfsStorage"), override.IpfsStorage,
+					"must be a quantity, such as 200Gi"))
+			}
+		}
+	}
+	return errs
+}
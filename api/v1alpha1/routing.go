@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// customRoutingSince Is the first kubo release reading delegated routers
+// from its routing configuration along with the routing methods, which the
+// operator writes.
+var customRoutingSince = MinorVersion{Major: 0, Minor: 18}
+
+// CustomRoutingSupported Returns whether the kubo image reads the delegated
+// routers the operator writes. Images whose version cannot be told from their
+// tag are taken to be recent releases.
+func CustomRoutingSupported(image string) bool {
+	version, ok := ImageMinorVersion(image)
+	return !ok || !version.before(customRoutingSince)
+}
+
+// validateRoutingConfig Returns an error for each routing setting the peers
+// cannot run with: delegated routers without the custom type, or with
+// endpoints kubo cannot query, and DHT servers no node outside of the
+// cluster can reach.
+func validateRoutingConfig(routingPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	routing := spec.Routing
+	routersPath := routingPath.Child("routers")
+	switch {
+	case routing.Type == RoutingTypeCustom && len(routing.Routers) == 0:
+		errs = append(errs, field.Required(routersPath, "the custom type queries the delegated routers only"))
+	case routing.Type != RoutingTypeCustom && len(routing.Routers) > 0:
+		errs = append(errs, field.Forbidden(routersPath, "delegated routers require the custom type"))
+	}
+	if routing.Type == RoutingTypeCustom && spec.IpfsImage != "" && !CustomRoutingSupported(spec.IpfsImage) {
+		errs = append(errs, field.Forbidden(routingPath.Child("type"),
+			fmt.Sprintf("kubo only reads delegated routers since v%s", customRoutingSince)))
+	}
+	if routing.Type == RoutingTypeDHTServer {
+		ws := webSocketOf(spec)
+		if ws == nil || !ws.Enabled || !ws.Public {
+			errs = append(errs, field.Forbidden(routingPath.Child("type"),
+				"DHT servers must be reachable from the outside, which requires "+
+					"spec.swarm.transports.websocket.public"))
+		}
+	}
+	names := map[string]bool{}
+	for i, router := range routing.Routers {
+		routerPath := routersPath.Index(i)
+		switch {
+		case router.Name == ReservedRouterName:
+			errs = append(errs, field.Invalid(routerPath.Child("name"), router.Name,
+				"is reserved for the router querying the others in parallel"))
+		case names[router.Name]:
+			errs = append(errs, field.Duplicate(routerPath.Child("name"), router.Name))
+		}
+		names[router.Name] = true
+		u, err := url.Parse(router.Endpoint)
+		switch {
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			errs = append(errs, field.Invalid(routerPath.Child("endpoint"), router.Endpoint,
+				"must be an http or https URL"))
+		case u.User != nil:
+			errs = append(errs, field.Invalid(routerPath.Child("endpoint"), u.Redacted(),
+				"must not hold credentials, which authSecretRef selects"))
+		}
+	}
+	return errs
+}
+
+// webSocketOf Returns the WebSocket transport of the spec, if any.
+func webSocketOf(spec *IpfsSpec) *WebSocketTransport {
+	if spec.Swarm == nil || spec.Swarm.Transports == nil {
+		return nil
+	}
+	return spec.Swarm.Transports.WebSocket
+}
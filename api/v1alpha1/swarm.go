@@ -0,0 +1,119 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// DefaultWebSocketPort is the TCP port of the WebSocket listener of the peers.
+	DefaultWebSocketPort int32 = 8081
+	// DefaultWebTransportPort is the UDP port of the WebTransport listener of the peers.
+	DefaultWebTransportPort int32 = 4002
+)
+
+// reservedTCPPorts Maps the TCP ports the containers of a peer already
+// listen on to their use.
+var reservedTCPPorts = map[int32]string{
+	4001: "the swarm",
+	4180: "the authenticating proxy",
+	5001: "the RPC API",
+	6060: "pprof",
+	8080: "the gateway",
+	9094: "the ipfs-cluster API",
+	9095: "the ipfs-cluster proxy",
+	9096: "the ipfs-cluster swarm",
+}
+
+// reservedUDPPorts Maps the UDP ports the containers of a peer already
+// listen on to their use.
+var reservedUDPPorts = map[int32]string{
+	4001: "the QUIC swarm",
+}
+
+// webTransportSince Is the first kubo release listening for WebTransport sessions.
+var webTransportSince = MinorVersion{Major: 0, Minor: 18}
+
+// resourceManagerSince Is the first kubo release reading the limits of its
+// resource manager from a file of the repo.
+var resourceManagerSince = MinorVersion{Major: 0, Minor: 19}
+
+// WebTransportSupported Returns whether the kubo image listens for
+// WebTransport sessions. Images whose version cannot be told from their tag
+// are taken to be recent releases.
+func WebTransportSupported(image string) bool {
+	version, ok := ImageMinorVersion(image)
+	return !ok || !version.before(webTransportSince)
+}
+
+// ResourceManagerSupported Returns whether the kubo image reads the limits
+// of its resource manager the operator writes. Images whose version cannot
+// be told from their tag are taken to be recent releases.
+func ResourceManagerSupported(image string) bool {
+	version, ok := ImageMinorVersion(image)
+	return !ok || !version.before(resourceManagerSince)
+}
+
+// validateSwarmConfig Returns an error for each transport the peers cannot
+// listen on: ports already taken in the pods, WebTransport on kubo releases
+// without it, and public WebSockets which cannot be served securely. Limits
+// of the resource manager are refused for kubo releases ignoring them.
+func validateSwarmConfig(swarmPath *field.Path, spec *IpfsSpec) field.ErrorList {
+	var errs field.ErrorList
+	if spec.Swarm.ResourceManager != nil && spec.IpfsImage != "" && !ResourceManagerSupported(spec.IpfsImage) {
+		errs = append(errs, field.Forbidden(swarmPath.Child("resourceManager"),
+			fmt.Sprintf("kubo only reads the limits of its resource manager since v%s", resourceManagerSince)))
+	}
+	transports := spec.Swarm.Transports
+	if transports == nil {
+		return errs
+	}
+	if ws := transports.WebSocket; ws != nil {
+		wsPath := swarmPath.Child("transports", "websocket")
+		if use, ok := reservedTCPPorts[ws.Port]; ok {
+			errs = append(errs, field.Invalid(wsPath.Child("port"), ws.Port, fmt.Sprintf("is the port of %s", use)))
+		}
+		if ws.Public {
+			switch {
+			case !ws.Enabled:
+				errs = append(errs, field.Forbidden(wsPath.Child("public"), "requires enabled to be true"))
+			case spec.Expose.Host == "" || spec.Expose.TLS == nil:
+				errs = append(errs, field.Required(field.NewPath("spec", "expose", "tls"),
+					"browsers only dial public WebSockets over TLS, served by the Ingress of expose.host"))
+			default:
+				for _, msg := range validation.IsDNS1123Subdomain(spec.Expose.Host) {
+					errs = append(errs, field.Invalid(field.NewPath("spec", "expose", "host"), spec.Expose.Host, msg))
+				}
+			}
+		}
+	}
+	if wt := transports.WebTransport; wt != nil {
+		wtPath := swarmPath.Child("transports", "webtransport")
+		if use, ok := reservedUDPPorts[wt.Port]; ok {
+			errs = append(errs, field.Invalid(wtPath.Child("port"), wt.Port, fmt.Sprintf("is the port of %s", use)))
+		}
+		if wt.Enabled && spec.IpfsImage != "" && !WebTransportSupported(spec.IpfsImage) {
+			errs = append(errs, field.Invalid(wtPath.Child("enabled"), wt.Enabled,
+				fmt.Sprintf("kubo only listens for WebTransport sessions since v%s", webTransportSince)))
+		}
+	}
+	return errs
+}
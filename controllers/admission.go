@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=create
+
+const (
+	securityProfileRestricted = "restricted"
+	securityProfileBaseline   = "baseline"
+
+	// restrictedUID Is the user the daemons run as under the restricted
+	// profile, the ipfs user of the kubo and ipfs-cluster images.
+	restrictedUID = 1000
+	// admissionRecheckInterval Is how long a rejection is trusted before the
+	// pod is probed again, so that fixing the policy of the namespace
+	// unblocks the cluster without changing its spec.
+	admissionRecheckInterval = 5 * time.Minute
+)
+
+// securityContextField Matches the fields of the security contexts quoted
+// by the messages of the PodSecurity and SCC admissions.
+var securityContextField = regexp.MustCompile(`[A-Za-z]*[sS]ecurityContext(\.[A-Za-z]+)+`)
+
+// applySecurityProfile Sets the security contexts of the pod and of its
+// containers required by the security profile of the cluster.
+func applySecurityProfile(m *clusterv1alpha1.Ipfs, spec *corev1.PodSpec) {
+	profile := m.Spec.SecurityProfile
+	if profile != securityProfileRestricted && profile != securityProfileBaseline {
+		return
+	}
+	noEscalation := false
+	spec.SecurityContext = &corev1.PodSecurityContext{
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if profile == securityProfileRestricted {
+		nonRoot := true
+		uid := int64(restrictedUID)
+		spec.SecurityContext.RunAsNonRoot = &nonRoot
+		spec.SecurityContext.RunAsUser = &uid
+		spec.SecurityContext.FSGroup = &uid
+	}
+	containerContext := func() *corev1.SecurityContext {
+		sc := &corev1.SecurityContext{AllowPrivilegeEscalation: &noEscalation}
+		if profile == securityProfileRestricted {
+			sc.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+		}
+		return sc
+	}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].SecurityContext = containerContext()
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].SecurityContext = containerContext()
+	}
+}
+
+// admissionProbe Is the outcome of probing the admission of a pod template.
+type admissionProbe struct {
+	hash     string
+	blocked  *diagnosis
+	probedAt time.Time
+}
+
+// admissionProbes Caches the outcome of the last probe of the pods of each
+// cluster, keyed by the hash of their template, so that the admission is
+// only probed again when the template changes or a rejection gets old.
+type admissionProbes struct {
+	mu       sync.Mutex
+	clusters map[types.NamespacedName]admissionProbe
+}
+
+// get Returns the cached outcome of the probe of the template, if any.
+func (a *admissionProbes) get(key types.NamespacedName, hash string, now time.Time) (*diagnosis, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	probe, ok := a.clusters[key]
+	if !ok || probe.hash != hash {
+		return nil, false
+	}
+	if probe.blocked != nil && now.Sub(probe.probedAt) >= admissionRecheckInterval {
+		return nil, false
+	}
+	return probe.blocked, true
+}
+
+// set Records the outcome of the probe of the template.
+func (a *admissionProbes) set(key types.NamespacedName, probe admissionProbe) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clusters == nil {
+		a.clusters = map[types.NamespacedName]admissionProbe{}
+	}
+	a.clusters[key] = probe
+}
+
+// forget Drops the outcome of the probes of a deleted cluster.
+func (a *admissionProbes) forget(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.clusters, key)
+}
+
+// admissionRejection Translates the error of a dry-run creation into the
+// diagnosis of the PodAdmissionBlocked condition, or nil when the pod was not
+// rejected by the admission. Quotas are left to the QuotaExceeded condition.
+func admissionRejection(err error) *diagnosis {
+	if err == nil || !errors.IsForbidden(err) || isQuotaError(err) {
+		return nil
+	}
+	message := err.Error()
+	reason := clusterv1alpha1.PodAdmissionBlockedReasonDenied
+	switch {
+	case strings.Contains(message, "PodSecurity"):
+		reason = clusterv1alpha1.PodAdmissionBlockedReasonPodSecurity
+	case strings.Contains(message, "security context constraint"):
+		reason = clusterv1alpha1.PodAdmissionBlockedReasonSecurityContextConstraints
+	}
+	seen := map[string]bool{}
+	fields := []string{}
+	for _, field := range securityContextField.FindAllString(message, -1) {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	if len(fields) > 0 {
+		message = fmt.Sprintf("%s (fields: %s)", message, strings.Join(fields, ", "))
+	}
+	return &diagnosis{reason: reason, message: message}
+}
+
+// probeAdmission Creates a pod of the peers from the pod template in dry-run
+// mode, so that the admission of the API server rejects it before the
+// StatefulSet is applied rather than when the StatefulSet creates its pods.
+// It returns the rejection, or nil when the pod is admitted. The outcome is
+// cached for the template. A probe which fails for another reason admits the
+// pod, as the StatefulSet would report the failure anyway.
+func (r *IpfsReconciler) probeAdmission(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	template *corev1.PodTemplateSpec,
+) (*diagnosis, error) {
+	hash, err := shortHash(template)
+	if err != nil {
+		return nil, fmt.Errorf("cannot hash the pod template of the peers: %w", err)
+	}
+	key := client.ObjectKeyFromObject(m)
+	now := time.Now()
+	if blocked, ok := r.admission.get(key, hash, now); ok {
+		return blocked, nil
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "ipfs-cluster-" + m.Name + "-",
+			Namespace:    m.Namespace,
+			Labels:       template.Labels,
+			Annotations:  template.Annotations,
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+	err = r.Create(ctx, pod, client.DryRunAll)
+	blocked := admissionRejection(err)
+	if err != nil && blocked == nil {
+		ctrllog.FromContext(ctx).Info("cannot probe the admission of the pods of the peers", "error", err.Error())
+		return nil, nil
+	}
+	r.admission.set(key, admissionProbe{hash: hash, blocked: blocked, probedAt: now})
+	return blocked, nil
+}
+
+// guardAdmission Probes the admission of the pods of the StatefulSet of the
+// peers among the phases, and sets the PodAdmissionBlocked condition. While
+// the pods are rejected, the StatefulSet is left as it is, so that running
+// peers are not replaced by pods which cannot be created.
+func (r *IpfsReconciler) guardAdmission(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	phases []reconcilePhase,
+) error {
+	for _, phase := range phases {
+		for obj := range phase.objects {
+			sts, ok := obj.(*appsv1.StatefulSet)
+			if !ok || sts.Name != "ipfs-cluster-"+instance.Name {
+				continue
+			}
+			blocked, err := r.probeAdmission(ctx, instance, &sts.Spec.Template)
+			if err != nil {
+				return err
+			}
+			setDiagnosisCondition(instance, clusterv1alpha1.ConditionPodAdmissionBlocked, blocked)
+			if blocked != nil {
+				message := blocked.message
+				phase.objects[obj] = func() error {
+					return fmt.Errorf("the pods of the peers are rejected at admission: %s", message)
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// admittingClient Rejects the dry-run creations of pods with the given
+// error, and counts them.
+type admittingClient struct {
+	client.Client
+	rejection error
+	probes    int
+}
+
+func (c *admittingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.Pod); ok {
+		c.probes++
+		return c.rejection
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("Ipfs pod admission", func() {
+	var (
+		ctx        context.Context
+		fakeClient *admittingClient
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	podSecurityRejection := errors.NewForbidden(schema.GroupResource{Resource: "pods"}, "ipfs-cluster-guarded-x",
+		fmt.Errorf(`violates PodSecurity "restricted:latest": allowPrivilegeEscalation != false `+
+			`(containers "ipfs" must set securityContext.allowPrivilegeEscalation=false), runAsNonRoot != true `+
+			`(pod or containers "ipfs" must set securityContext.runAsNonRoot=true)`))
+
+	// phasesOf Returns the workload phase of the peers of the cluster.
+	phasesOf := func() ([]reconcilePhase, *appsv1.StatefulSet) {
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-" + instance.Name
+		sts.Namespace = instance.Namespace
+		sts.Spec.Template.Spec.Containers = []corev1.Container{{Name: "ipfs", Image: "ipfs/kubo:v0.15.0"}}
+		applySecurityProfile(instance, &sts.Spec.Template.Spec)
+		return []reconcilePhase{{
+			reason:  clusterv1alpha1.ReconciledReasonWorkloadNotReady,
+			objects: map[client.Object]controllerutil.MutateFn{sts: func() error { return nil }},
+		}}, sts
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "guarded"
+		instance.Namespace = "default"
+		fakeClient = &admittingClient{Client: fake.NewClientBuilder().Build(), rejection: podSecurityRejection}
+		reconciler = &IpfsReconciler{Client: fakeClient}
+	})
+
+	It("renders the security contexts of the profiles", func() {
+		spec := &corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "configure-ipfs"}},
+			Containers:     []corev1.Container{{Name: "ipfs"}, {Name: "ipfs-cluster"}},
+		}
+		applySecurityProfile(instance, spec)
+		Expect(spec.SecurityContext).To(BeNil())
+		Expect(spec.Containers[0].SecurityContext).To(BeNil())
+
+		instance.Spec.SecurityProfile = "baseline"
+		applySecurityProfile(instance, spec)
+		Expect(spec.SecurityContext.RunAsNonRoot).To(BeNil())
+		Expect(*spec.InitContainers[0].SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+		Expect(spec.Containers[1].SecurityContext.Capabilities).To(BeNil())
+
+		instance.Spec.SecurityProfile = "restricted"
+		applySecurityProfile(instance, spec)
+		Expect(*spec.SecurityContext.RunAsNonRoot).To(BeTrue())
+		Expect(*spec.SecurityContext.RunAsUser).To(Equal(int64(restrictedUID)))
+		Expect(spec.SecurityContext.SeccompProfile.Type).To(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+		for _, c := range append(spec.InitContainers, spec.Containers...) {
+			Expect(*c.SecurityContext.AllowPrivilegeEscalation).To(BeFalse())
+			Expect(c.SecurityContext.Capabilities.Drop).To(ConsistOf(corev1.Capability("ALL")))
+		}
+	})
+
+	It("holds the StatefulSet while its pods are rejected", func() {
+		phases, sts := phasesOf()
+		Expect(reconciler.guardAdmission(ctx, instance, phases)).To(Succeed())
+		condition := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionPodAdmissionBlocked)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal(clusterv1alpha1.PodAdmissionBlockedReasonPodSecurity))
+		Expect(condition.Message).To(ContainSubstring(
+			"(fields: securityContext.allowPrivilegeEscalation, securityContext.runAsNonRoot)"))
+		Expect(phases[0].objects[sts]()).To(MatchError(ContainSubstring("rejected at admission")))
+
+		By("reusing the outcome while the template is unchanged")
+		phases, _ = phasesOf()
+		Expect(reconciler.guardAdmission(ctx, instance, phases)).To(Succeed())
+		Expect(fakeClient.probes).To(Equal(1))
+
+		By("probing again once the template satisfies the profile")
+		instance.Spec.SecurityProfile = "restricted"
+		fakeClient.rejection = nil
+		phases, sts = phasesOf()
+		Expect(reconciler.guardAdmission(ctx, instance, phases)).To(Succeed())
+		Expect(fakeClient.probes).To(Equal(2))
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionPodAdmissionBlocked)).To(BeNil())
+		Expect(phases[0].objects[sts]()).To(Succeed())
+	})
+
+	It("tells the rejections of other admissions apart", func() {
+		scc := errors.NewForbidden(schema.GroupResource{Resource: "pods"}, "x", fmt.Errorf(
+			"unable to validate against any security context constraint: "+
+				"[spec.containers[0].securityContext.runAsUser: Invalid value: 1000: must be in the ranges: "+
+				"[1000680000, 1000689999]]"))
+		Expect(admissionRejection(scc).reason).To(Equal(
+			clusterv1alpha1.PodAdmissionBlockedReasonSecurityContextConstraints))
+		Expect(admissionRejection(scc).message).To(HaveSuffix("(fields: securityContext.runAsUser)"))
+		quota := errors.NewForbidden(schema.GroupResource{Resource: "pods"}, "x",
+			fmt.Errorf("exceeded quota: compute, requested: cpu=1"))
+		Expect(admissionRejection(quota)).To(BeNil())
+		Expect(admissionRejection(fmt.Errorf("connection refused"))).To(BeNil())
+	})
+})
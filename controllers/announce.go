@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// maxUnexpectedAddresses Is how many unexpected addresses the condition
+// lists.
+const maxUnexpectedAddresses = 20
+
+// unroutableRanges Lists the loopback and link-local ranges, which no other
+// host can reach.
+var unroutableRanges = []string{"127.0.0.0/8", "169.254.0.0/16", "::1/128", "fe80::/10"}
+
+// cidrSet Is a set of ranges of addresses.
+type cidrSet []*net.IPNet
+
+// add Adds the range in CIDR notation, or the single address, to the set.
+func (s *cidrSet) add(cidr string) error {
+	ipNet, err := clusterv1alpha1.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, ipNet)
+	return nil
+}
+
+// contains Returns whether a range of the set holds the address. IPv4
+// addresses mapped to IPv6 match the IPv4 ranges.
+func (s cidrSet) contains(ip net.IP) bool {
+	for _, ipNet := range s {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// announceAuditEnabled Returns whether the addresses announced by the kubo
+// nodes are audited.
+func announceAuditEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Network != nil && m.Spec.Network.AuditAnnounceAddresses
+}
+
+// expectedAnnounceRanges Returns the ranges the kubo nodes may announce: the
+// loopback and link-local ranges, the pod CIDRs of the nodes, the addresses
+// of the pods of the peers and of the Services of the cluster, and the
+// allowed ranges of the spec. The service CIDR cannot be read from the API,
+// hence the addresses of the Services.
+func (r *IpfsReconciler) expectedAnnounceRanges(ctx context.Context, m *clusterv1alpha1.Ipfs) (cidrSet, error) {
+	var ranges cidrSet
+	for _, cidr := range unroutableRanges {
+		_ = ranges.add(cidr)
+	}
+	nodes := corev1.NodeList{}
+	if err := r.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("cannot list nodes: %w", err)
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		cidrs := node.Spec.PodCIDRs
+		if len(cidrs) == 0 && node.Spec.PodCIDR != "" {
+			cidrs = []string{node.Spec.PodCIDR}
+		}
+		for _, cidr := range cidrs {
+			// The node controller only sets valid ranges.
+			_ = ranges.add(cidr)
+		}
+	}
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(m.Namespace),
+		client.MatchingLabels(peerSelector(m))); err != nil {
+		return nil, fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	for i := range pods.Items {
+		for _, ip := range pods.Items[i].Status.PodIPs {
+			_ = ranges.add(ip.IP)
+		}
+	}
+	services := corev1.ServiceList{}
+	if err := r.List(ctx, &services, client.InNamespace(m.Namespace),
+		client.MatchingLabels{clusterv1alpha1.LabelOwnerUID: string(m.UID)}); err != nil {
+		return nil, fmt.Errorf("cannot list services: %w", err)
+	}
+	for i := range services.Items {
+		for _, ip := range services.Items[i].Spec.ClusterIPs {
+			_ = ranges.add(ip)
+		}
+	}
+	for _, cidr := range m.Spec.Network.AllowedAnnounceCIDRs {
+		if err := ranges.add(cidr); err != nil {
+			return nil, invalidSpec(fmt.Errorf("cannot parse spec.network.allowedAnnounceCIDRs: %w", err))
+		}
+	}
+	return ranges, nil
+}
+
+// inClusterName Returns whether the DNS name only resolves within the
+// Kubernetes cluster: the names of Services and pods, and the names without
+// a domain.
+func inClusterName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return !strings.Contains(name, ".") || strings.HasSuffix(name, ".svc") || strings.Contains(name, ".svc.")
+}
+
+// announceExpected Returns whether the announced multiaddress reaches the
+// node through the expected ranges or in-cluster DNS names. The network part
+// of relayed addresses is that of the relay. The multiaddresses without a
+// network part, or which cannot be parsed, are unexpected, since where they
+// lead cannot be told.
+func announceExpected(addr string, ranges cidrSet) bool {
+	m, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return false
+	}
+	expected, found := false, false
+	ma.ForEach(m, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6:
+			ip := net.ParseIP(c.Value())
+			expected, found = ip != nil && ranges.contains(ip), true
+		case ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+			expected, found = inClusterName(c.Value()), true
+		}
+		return !found
+	})
+	return expected
+}
+
+// syncAnnounceAudit Checks the addresses the kubo nodes announced when the
+// mesh was last checked against the expected ranges, and sets the
+// UnexpectedAnnounceAddresses condition listing those outside of them. An
+// Event is recorded whenever the list changes. The configuration of the
+// nodes is left as is.
+func (r *IpfsReconciler) syncAnnounceAudit(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) error {
+	if !announceAuditEnabled(resolved) {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionUnexpectedAnnounceAddresses)
+		return nil
+	}
+	ranges, err := r.expectedAnnounceRanges(ctx, resolved)
+	if err != nil {
+		return err
+	}
+	var unexpected []string
+	for _, p := range instance.Status.Peers {
+		if p.Swarm == nil {
+			continue
+		}
+		announced := append([]string(nil), p.Swarm.Announce...)
+		sort.Strings(announced)
+		for _, addr := range announced {
+			if !announceExpected(addr, ranges) {
+				unexpected = append(unexpected, p.Name+": "+addr)
+			}
+		}
+	}
+
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionUnexpectedAnnounceAddresses,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.UnexpectedAnnounceAddressesReasonNone,
+		Message:            "every announced address is within the expected ranges",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(unexpected) > 0 {
+		listed := unexpected
+		if len(listed) > maxUnexpectedAddresses {
+			listed = listed[:maxUnexpectedAddresses]
+		}
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.UnexpectedAnnounceAddressesReasonFound
+		cond.Message = fmt.Sprintf("%d addresses are announced outside of the expected ranges: %s",
+			len(unexpected), strings.Join(listed, ", "))
+		if len(unexpected) > len(listed) {
+			cond.Message += fmt.Sprintf(" and %d more", len(unexpected)-len(listed))
+		}
+		if previous := meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionUnexpectedAnnounceAddresses); previous == nil ||
+			previous.Message != cond.Message {
+			r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.ConditionUnexpectedAnnounceAddresses,
+				"%s", cond.Message)
+		}
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+	return nil
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const announceRelayID = "12D3KooWSAxzBsGMkwFHQCtcCCLjDYS8s2KW5hC7dPoZkh6mXTe8"
+
+var _ = Describe("Ipfs announce address audit", func() {
+	var ranges cidrSet
+
+	BeforeEach(func() {
+		ranges = nil
+		for _, cidr := range unroutableRanges {
+			Expect(ranges.add(cidr)).To(Succeed())
+		}
+		for _, cidr := range []string{"10.244.0.0/16", "fd00:10:244::/56", "10.96.0.10"} {
+			Expect(ranges.add(cidr)).To(Succeed())
+		}
+	})
+
+	table.DescribeTable("tells the expected announce addresses from the others",
+		func(addr string, expected bool) {
+			Expect(announceExpected(addr, ranges)).To(Equal(expected))
+		},
+		table.Entry("an IPv4 pod address", "/ip4/10.244.1.5/tcp/4001", true),
+		table.Entry("an IPv4 pod address over QUIC", "/ip4/10.244.1.5/udp/4001/quic", true),
+		table.Entry("a public IPv4 address", "/ip4/203.0.113.7/tcp/4001", false),
+		table.Entry("an IPv4 loopback address", "/ip4/127.0.0.1/tcp/4001", true),
+		table.Entry("an IPv6 loopback address", "/ip6/::1/tcp/4001", true),
+		table.Entry("an IPv6 pod address", "/ip6/fd00:10:244::5/tcp/4001", true),
+		table.Entry("an IPv6 address next to the pod range", "/ip6/fd00:10:245::5/tcp/4001", false),
+		table.Entry("a public IPv6 address", "/ip6/2001:db8::5/udp/4001/quic", false),
+		table.Entry("a zoned IPv6 link-local address", "/ip6zone/eth0/ip6/fe80::1/tcp/4001", true),
+		table.Entry("an IPv4-mapped IPv6 pod address", "/ip6/::ffff:10.244.1.5/tcp/4001", true),
+		table.Entry("the name of a pod", "/dns4/ipfs-cluster-x-0.ipfs-cluster-x.default.svc/tcp/4001", true),
+		table.Entry("the full name of a Service", "/dns4/ipfs-cluster-x.default.svc.cluster.local./tcp/4001", true),
+		table.Entry("a name without domain", "/dns4/localhost/tcp/4001", true),
+		table.Entry("a public name", "/dns6/node.example.com/tcp/4001", false),
+		table.Entry("a public dnsaddr", "/dnsaddr/bootstrap.libp2p.io", false),
+		table.Entry("a public relay", "/ip4/203.0.113.9/tcp/4001/p2p/"+announceRelayID+"/p2p-circuit", false),
+		table.Entry("a relay behind a Service", "/ip4/10.96.0.10/tcp/4001/p2p/"+announceRelayID+"/p2p-circuit", true),
+		table.Entry("a relay without address", "/p2p/"+announceRelayID+"/p2p-circuit", false),
+		table.Entry("garbage", "garbage", false),
+	)
+
+	It("reports the unexpected addresses by peer", func() {
+		ctx := context.Background()
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = "private"
+		instance.Namespace = "default"
+		instance.Spec.Network = &clusterv1alpha1.NetworkAuditConfig{
+			AuditAnnounceAddresses: true,
+			AllowedAnnounceCIDRs:   []string{"fd00:10:96::/112"},
+		}
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{
+			{Name: "ipfs-cluster-private-0", Swarm: &clusterv1alpha1.SwarmAddresses{Announce: []string{
+				"/ip4/10.244.1.5/tcp/4001", "/ip6/fd00:10:96::7/tcp/4001", "/ip4/203.0.113.7/tcp/4001",
+			}}},
+			{Name: "ipfs-cluster-private-1", Swarm: &clusterv1alpha1.SwarmAddresses{Announce: []string{
+				"/ip4/10.0.0.8/tcp/4001", "/ip6/2001:db8::8/tcp/4001",
+			}}},
+		}
+		node := &corev1.Node{}
+		node.Name = "node-a"
+		node.Spec.PodCIDRs = []string{"10.244.1.0/24", "fd00:10:244:1::/64"}
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-private-1"
+		pod.Namespace = instance.Namespace
+		pod.Labels = peerSelector(instance)
+		pod.Status.PodIPs = []corev1.PodIP{{IP: "10.0.0.8"}}
+		recorder := record.NewFakeRecorder(10)
+		r := &IpfsReconciler{Client: fake.NewClientBuilder().WithObjects(node, pod).Build(), Recorder: recorder}
+
+		Expect(r.syncAnnounceAudit(ctx, instance, instance)).To(Succeed())
+		cond := meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionUnexpectedAnnounceAddresses)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UnexpectedAnnounceAddressesReasonFound))
+		Expect(cond.Message).To(Equal("2 addresses are announced outside of the expected ranges: " +
+			"ipfs-cluster-private-0: /ip4/203.0.113.7/tcp/4001, ipfs-cluster-private-1: /ip6/2001:db8::8/tcp/4001"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("UnexpectedAnnounceAddresses")))
+
+		By("recording a single Event while the addresses stay the same")
+		Expect(r.syncAnnounceAudit(ctx, instance, instance)).To(Succeed())
+		Expect(recorder.Events).NotTo(Receive())
+
+		By("clearing the condition once the addresses are allowed")
+		instance.Spec.Network.AllowedAnnounceCIDRs = append(instance.Spec.Network.AllowedAnnounceCIDRs,
+			"203.0.113.0/24", "2001:db8::/32")
+		Expect(r.syncAnnounceAudit(ctx, instance, instance)).To(Succeed())
+		Expect(meta.IsStatusConditionFalse(instance.Status.Conditions,
+			clusterv1alpha1.ConditionUnexpectedAnnounceAddresses)).To(BeTrue())
+
+		instance.Spec.Network = nil
+		Expect(r.syncAnnounceAudit(ctx, instance, instance)).To(Succeed())
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionUnexpectedAnnounceAddresses)).To(BeNil())
+	})
+})
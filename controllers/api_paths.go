@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// apiPathPrefix Is the prefix of the commands of the RPC API.
+const apiPathPrefix = "/api/v0/"
+
+// apiCommands Classifies the commands of the RPC API: true for the commands
+// which only read, false for those changing the state of the node. Commands
+// missing from the table are refused in ReadOnly mode, so new commands must
+// be added deliberately.
+var apiCommands = map[string]bool{
+	"bitswap/stat":      true,
+	"block/get":         true,
+	"block/stat":        true,
+	"cat":               true,
+	"cid/base32":        true,
+	"cid/format":        true,
+	"dag/export":        true,
+	"dag/get":           true,
+	"dag/resolve":       true,
+	"dag/stat":          true,
+	"dht/findprovs":     true,
+	"dns":               true,
+	"files/ls":          true,
+	"files/read":        true,
+	"files/stat":        true,
+	"get":               true,
+	"id":                true,
+	"ls":                true,
+	"name/resolve":      true,
+	"pin/ls":            true,
+	"refs":              true,
+	"resolve":           true,
+	"routing/findprovs": true,
+	"version":           true,
+
+	"add":              false,
+	"block/put":        false,
+	"block/rm":         false,
+	"bootstrap/add":    false,
+	"bootstrap/rm":     false,
+	"config":           false,
+	"config/replace":   false,
+	"dag/import":       false,
+	"dag/put":          false,
+	"files/cp":         false,
+	"files/mkdir":      false,
+	"files/mv":         false,
+	"files/rm":         false,
+	"files/write":      false,
+	"key/gen":          false,
+	"key/import":       false,
+	"key/list":         false,
+	"key/rename":       false,
+	"key/rm":           false,
+	"name/publish":     false,
+	"pin/add":          false,
+	"pin/remote/add":   false,
+	"pin/rm":           false,
+	"pin/update":       false,
+	"repo/gc":          false,
+	"routing/put":      false,
+	"shutdown":         false,
+	"swarm/connect":    false,
+	"swarm/disconnect": false,
+}
+
+// apiMode Returns the mode the RPC API is exposed with, or an empty string
+// when it is not exposed.
+func apiMode(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.API == nil || m.Spec.Expose.Auth == nil {
+		return ""
+	}
+	if m.Spec.API.Mode == "" {
+		return clusterv1alpha1.APIModeReadOnly
+	}
+	return m.Spec.API.Mode
+}
+
+// apiUpstreams Returns the upstreams of the authenticating proxy serving the
+// RPC API in the given mode. The proxy routes each request to the upstream
+// with the longest matching path, so in ReadOnly mode the read commands reach
+// the daemon while every other command is answered with 403.
+func apiUpstreams(mode string) []string {
+	api := fmt.Sprintf("http://127.0.0.1:%d", portAPI)
+	switch mode {
+	case clusterv1alpha1.APIModeReadWrite:
+		return []string{api + apiPathPrefix}
+	case clusterv1alpha1.APIModeReadOnly:
+		upstreams := []string{"static://403" + apiPathPrefix}
+		for _, cmd := range readOnlyCommands() {
+			upstreams = append(upstreams, api+apiPathPrefix+cmd)
+		}
+		return upstreams
+	}
+	return nil
+}
+
+// readOnlyCommands Returns the commands served in ReadOnly mode, sorted.
+func readOnlyCommands() []string {
+	var commands []string
+	for cmd, readOnly := range apiCommands {
+		if readOnly {
+			commands = append(commands, cmd)
+		}
+	}
+	sort.Strings(commands)
+	return commands
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("RPC API exposure", func() {
+	// Changing this table changes what ReadOnly consumers can do: review
+	// every command added to or moved in it.
+	It("classifies every known command", func() {
+		Expect(apiCommands).To(Equal(map[string]bool{
+			"bitswap/stat":      true,
+			"block/get":         true,
+			"block/stat":        true,
+			"cat":               true,
+			"cid/base32":        true,
+			"cid/format":        true,
+			"dag/export":        true,
+			"dag/get":           true,
+			"dag/resolve":       true,
+			"dag/stat":          true,
+			"dht/findprovs":     true,
+			"dns":               true,
+			"files/ls":          true,
+			"files/read":        true,
+			"files/stat":        true,
+			"get":               true,
+			"id":                true,
+			"ls":                true,
+			"name/resolve":      true,
+			"pin/ls":            true,
+			"refs":              true,
+			"resolve":           true,
+			"routing/findprovs": true,
+			"version":           true,
+
+			"add":              false,
+			"block/put":        false,
+			"block/rm":         false,
+			"bootstrap/add":    false,
+			"bootstrap/rm":     false,
+			"config":           false,
+			"config/replace":   false,
+			"dag/import":       false,
+			"dag/put":          false,
+			"files/cp":         false,
+			"files/mkdir":      false,
+			"files/mv":         false,
+			"files/rm":         false,
+			"files/write":      false,
+			"key/gen":          false,
+			"key/import":       false,
+			"key/list":         false,
+			"key/rename":       false,
+			"key/rm":           false,
+			"name/publish":     false,
+			"pin/add":          false,
+			"pin/remote/add":   false,
+			"pin/rm":           false,
+			"pin/update":       false,
+			"repo/gc":          false,
+			"routing/put":      false,
+			"shutdown":         false,
+			"swarm/connect":    false,
+			"swarm/disconnect": false,
+		}))
+	})
+
+	It("never serves a command writing state in ReadOnly mode", func() {
+		upstreams := apiUpstreams(clusterv1alpha1.APIModeReadOnly)
+		Expect(upstreams[0]).To(Equal("static://403/api/v0/"))
+		for cmd, readOnly := range apiCommands {
+			upstream := "http://127.0.0.1:5001/api/v0/" + cmd
+			if readOnly {
+				Expect(upstreams).To(ContainElement(upstream))
+			} else {
+				Expect(upstreams).NotTo(ContainElement(upstream))
+			}
+		}
+		Expect(apiUpstreams(clusterv1alpha1.APIModeReadWrite)).To(ConsistOf("http://127.0.0.1:5001/api/v0/"))
+	})
+
+	It("is only exposed through the authenticating proxy", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Spec.API = &clusterv1alpha1.APIConfig{}
+		Expect(apiMode(instance)).To(BeEmpty())
+
+		instance.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+			BasicAuthSecretRef: &corev1.LocalObjectReference{Name: "users"},
+		}
+		Expect(apiMode(instance)).To(Equal(clusterv1alpha1.APIModeReadOnly))
+		proxy, _ := authProxyContainer(instance)
+		Expect(proxy.Args).To(ContainElement("--upstream=static://403/api/v0/"))
+
+		instance.Spec.API.Mode = clusterv1alpha1.APIModeReadWrite
+		proxy, _ = authProxyContainer(instance)
+		Expect(proxy.Args).To(ContainElement("--upstream=http://127.0.0.1:5001/api/v0/"))
+		Expect(proxy.Args).NotTo(ContainElement("--upstream=static://403/api/v0/"))
+	})
+})
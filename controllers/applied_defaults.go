@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// appliedDefaultsOf Returns the record of the built-in defaults at the given
+// version, restricted to the values the clusters resolve their spec against.
+func appliedDefaultsOf(version int32, builtin OperatorDefaults) *clusterv1alpha1.AppliedDefaults {
+	applied := &clusterv1alpha1.AppliedDefaults{
+		Version:                  version,
+		IpfsImage:                builtin.IpfsImage,
+		ClusterImage:             builtin.ClusterImage,
+		AuthProxyImage:           builtin.AuthProxyImage,
+		StorageClassName:         builtin.StorageClassName,
+		StoragePressureThreshold: builtin.StoragePressureThreshold,
+	}
+	if resources := builtin.Resources.DeepCopy(); resources.Limits != nil || resources.Requests != nil ||
+		resources.Cluster != nil || resources.EphemeralStorage != nil {
+		applied.Resources = resources
+	}
+	return applied
+}
+
+// recordedBuiltin Returns the given built-in defaults with the values
+// recorded by a cluster in place of their own.
+func recordedBuiltin(builtin OperatorDefaults, applied *clusterv1alpha1.AppliedDefaults) OperatorDefaults {
+	recorded := builtin
+	recorded.MaintenanceWindow = builtin.MaintenanceWindow.DeepCopy()
+	recorded.IpfsImage = applied.IpfsImage
+	recorded.ClusterImage = applied.ClusterImage
+	recorded.AuthProxyImage = applied.AuthProxyImage
+	recorded.StorageClassName = applied.StorageClassName
+	recorded.StoragePressureThreshold = applied.StoragePressureThreshold
+	recorded.Resources = clusterv1alpha1.ResourcesConfig{}
+	if applied.Resources != nil {
+		recorded.Resources = *applied.Resources.DeepCopy()
+	}
+	return recorded
+}
+
+// pinsDefaults Returns whether the cluster resolves its spec against built-in
+// defaults older than the given version.
+func pinsDefaults(m *clusterv1alpha1.Ipfs, version int32) bool {
+	applied := m.Status.AppliedDefaults
+	return applied != nil && applied.Version != version && !m.Spec.AdoptNewDefaults
+}
+
+// For Returns the defaults the given cluster resolves its spec against: the
+// current defaults, unless the cluster recorded older built-in defaults and
+// does not adopt new ones. These are then layered under the defaults file,
+// the IpfsOperatorConfig and the command-line flags as the current built-in
+// defaults are, so that only the changes of the operator are held back.
+func (s *DefaultsStore) For(m *clusterv1alpha1.Ipfs) OperatorDefaults {
+	if s == nil {
+		if !pinsDefaults(m, builtinDefaultsVersion) {
+			return builtinDefaults()
+		}
+		return recordedBuiltin(builtinDefaults(), m.Status.AppliedDefaults)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !pinsDefaults(m, s.builtinVersion) {
+		return s.current
+	}
+	file := recordedBuiltin(s.builtin, m.Status.AppliedDefaults)
+	if s.fileData != nil {
+		// The file was parsed as it was loaded, so it cannot fail here.
+		if layered, err := layerFile(file, s.fileData); err == nil {
+			file = layered
+		}
+	}
+	return resolveDefaults(file, s.config, s.flags)
+}
+
+// recordDefaults Records the current built-in defaults in the status of the
+// clusters which have not recorded any yet, which are new or were created
+// before the defaults were recorded, and of those adopting new defaults.
+func (s *DefaultsStore) recordDefaults(m *clusterv1alpha1.Ipfs) {
+	builtin, version := builtinDefaults(), builtinDefaultsVersion
+	if s != nil {
+		builtin, version = s.builtin, s.builtinVersion
+	}
+	applied := m.Status.AppliedDefaults
+	if applied != nil && (applied.Version == version || !m.Spec.AdoptNewDefaults) {
+		return
+	}
+	m.Status.AppliedDefaults = appliedDefaultsOf(version, builtin)
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs applied defaults", func() {
+	var (
+		instance   *clusterv1alpha1.Ipfs
+		reconciler *IpfsReconciler
+	)
+
+	// storeAt Returns a store of an operator whose built-in defaults are at
+	// the given version and run the given ipfs image.
+	storeAt := func(path string, version int32, image string) *DefaultsStore {
+		store, err := NewDefaultsStore(path)
+		Expect(err).NotTo(HaveOccurred())
+		store.builtin.IpfsImage = image
+		store.builtinVersion = version
+		store.update(func() {
+			store.file = store.builtin
+			if store.fileData != nil {
+				store.file, err = layerFile(store.builtin, store.fileData)
+			}
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return store
+	}
+
+	// reconcile Records the defaults of the cluster as a reconcile does and
+	// returns the pod template the peers would run.
+	reconcile := func(store *DefaultsStore, m *clusterv1alpha1.Ipfs) *appsv1.StatefulSet {
+		store.recordDefaults(m)
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(store.For(m).apply(m), sts, "ipfs-cluster-pinned", "ipfs-cluster-pinned",
+			"ipfs-cluster-pinned", "ipfs-cluster-scripts-pinned", "")()).To(Succeed())
+		return sts
+	}
+
+	BeforeEach(func() {
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "pinned"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		scheme := runtime.NewScheme()
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler = &IpfsReconciler{Scheme: scheme}
+	})
+
+	It("keeps the defaults of the existing clusters across an operator upgrade", func() {
+		before := reconcile(storeAt("", 1, "ipfs/kubo:v0.14.0"), instance)
+		Expect(instance.Status.AppliedDefaults.Version).To(Equal(int32(1)))
+		Expect(instance.Status.AppliedDefaults.IpfsImage).To(Equal("ipfs/kubo:v0.14.0"))
+
+		By("restarting no pod once the built-in defaults change")
+		upgraded := storeAt("", 2, "ipfs/kubo:v0.17.0")
+		after := reconcile(upgraded, instance)
+		beforeHash, err := shortHash(before.Spec.Template)
+		Expect(err).NotTo(HaveOccurred())
+		afterHash, err := shortHash(after.Spec.Template)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(afterHash).To(Equal(beforeHash))
+		Expect(after.Spec.Template.Spec.Containers[0].Image).To(Equal("ipfs/kubo:v0.14.0"))
+		Expect(instance.Status.AppliedDefaults.Version).To(Equal(int32(1)))
+
+		By("giving the new defaults to the new clusters")
+		created := &clusterv1alpha1.Ipfs{}
+		instance.DeepCopyInto(created)
+		created.Status = clusterv1alpha1.IpfsStatus{}
+		Expect(reconcile(upgraded, created).Spec.Template.Spec.Containers[0].Image).To(Equal("ipfs/kubo:v0.17.0"))
+		Expect(created.Status.AppliedDefaults.Version).To(Equal(int32(2)))
+
+		By("preferring the fields the spec sets")
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.15.0"
+		Expect(reconcile(upgraded, instance).Spec.Template.Spec.Containers[0].Image).To(Equal("ipfs/kubo:v0.15.0"))
+		instance.Spec.IpfsImage = ""
+
+		By("adopting the new defaults once asked to")
+		instance.Spec.AdoptNewDefaults = true
+		Expect(reconcile(upgraded, instance).Spec.Template.Spec.Containers[0].Image).To(Equal("ipfs/kubo:v0.17.0"))
+		Expect(instance.Status.AppliedDefaults.Version).To(Equal(int32(2)))
+		instance.Spec.AdoptNewDefaults = false
+		Expect(reconcile(upgraded, instance).Spec.Template.Spec.Containers[0].Image).To(Equal("ipfs/kubo:v0.17.0"))
+	})
+
+	It("layers the defaults file over the recorded built-in defaults", func() {
+		dir, err := os.MkdirTemp("", "defaults")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "defaults.yaml")
+		Expect(os.WriteFile(path, []byte("clusterImage: ipfs/ipfs-cluster:v1.0.5\n"), 0o600)).To(Succeed())
+
+		reconcile(storeAt(path, 1, "ipfs/kubo:v0.14.0"), instance)
+		defaults := storeAt(path, 2, "ipfs/kubo:v0.17.0").For(instance)
+		Expect(defaults.IpfsImage).To(Equal("ipfs/kubo:v0.14.0"))
+		Expect(defaults.ClusterImage).To(Equal("ipfs/ipfs-cluster:v1.0.5"))
+	})
+})
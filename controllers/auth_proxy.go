@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// authProxyImage Defines which container image to use for the proxy
+	// authenticating the users of the gateway.
+	authProxyImage = "quay.io/oauth2-proxy/oauth2-proxy:v7.4.0"
+	// authProxyPortName Is the name of the port of the proxy, which the
+	// exposed Services and the Ingress target instead of the gateway.
+	authProxyPortName = "auth-proxy"
+	// authProxyCookieSecretKey Holds the secret the proxy signs its session cookies with.
+	authProxyCookieSecretKey = "cookie-secret"
+	// authProxyHtpasswdKey Is the key of the htpasswd entries in the basic auth Secret.
+	authProxyHtpasswdKey = "auth"
+	// authProxyHtpasswdPath Is where the htpasswd entries are mounted in the proxy.
+	authProxyHtpasswdPath = "/etc/auth-proxy"
+	// cookieSecretLen Is the length of the cookie secret; the proxy accepts 16, 24 or 32 bytes.
+	cookieSecretLen = 32
+)
+
+// authProxyName Returns the name of the Secret holding the configuration of
+// the proxy authenticating the users of the gateway.
+func authProxyName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-auth-proxy"
+}
+
+// gatewayPortName Returns the name of the port the exposed gateway is
+// reached through: the authenticating proxy when auth is requested, the
+// gateway itself otherwise.
+func gatewayPortName(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.Expose.Auth != nil {
+		return authProxyPortName
+	}
+	return "http"
+}
+
+// authProxyConfig Generates the cookie secret of the proxy once and keeps it
+// afterwards.
+func (r *IpfsReconciler) authProxyConfig(
+	m *clusterv1alpha1.Ipfs,
+	sec *corev1.Secret,
+) (controllerutil.MutateFn, string) {
+	secName := authProxyName(m)
+	expected := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secName,
+			Namespace: m.Namespace,
+		},
+	}
+	expected.DeepCopyInto(sec)
+	if err := ctrl.SetControllerReference(m, sec, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		if sec.Data == nil {
+			sec.Data = map[string][]byte{}
+		}
+		if len(sec.Data[authProxyCookieSecretKey]) != cookieSecretLen {
+			secret, err := newClusterSecret()
+			if err != nil {
+				return fmt.Errorf("cannot generate auth proxy cookie secret: %w", err)
+			}
+			sec.Data[authProxyCookieSecretKey] = []byte(secret[:cookieSecretLen])
+		}
+		return nil
+	}, secName
+}
+
+// deleteAuthProxyConfig Deletes the configuration of the proxy once auth is
+// no longer requested.
+func (r *IpfsReconciler) deleteAuthProxyConfig(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	if m.Spec.Expose.Auth != nil {
+		return nil
+	}
+	sec := corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: authProxyName(m)}, &sec); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.Delete(ctx, &sec); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete auth proxy configuration: %w", err)
+	}
+	return nil
+}
+
+// authProxyContainer Returns the container of the proxy authenticating the
+// users of the gateway and of the RPC API, along with the volumes it mounts.
+// The credentials are read from the Secrets referenced by the spec, whose
+// contents are part of the config hash, so the pods roll when they change.
+// Changing the mode of the RPC API changes the arguments of the proxy, which
+// rolls the pods as well.
+func authProxyContainer(m *clusterv1alpha1.Ipfs) (corev1.Container, []corev1.Volume) {
+	var mounts []corev1.VolumeMount
+	var volumes []corev1.Volume
+	if ref := m.Spec.Expose.Auth.BasicAuthSecretRef; ref != nil {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      "auth-proxy-htpasswd",
+			MountPath: authProxyHtpasswdPath,
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "auth-proxy-htpasswd",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: ref.Name,
+					Items:      []corev1.KeyToPath{{Key: authProxyHtpasswdKey, Path: authProxyHtpasswdKey}},
+				},
+			},
+		})
+	}
+	return corev1.Container{
+		Name:            "auth-proxy",
+		Image:           m.Spec.Expose.Auth.Image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args:            authProxyArgs(m),
+		Env:             authProxyEnv(m),
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          authProxyPortName,
+				ContainerPort: portAuthProxy,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		ReadinessProbe: authProxyProbe(),
+		VolumeMounts:   mounts,
+	}, volumes
+}
+
+// authProxyArgs Returns the arguments of the auth proxy: its upstreams, and
+// the providers users authenticate against.
+func authProxyArgs(m *clusterv1alpha1.Ipfs) []string {
+	auth := m.Spec.Expose.Auth
+	args := []string{
+		fmt.Sprintf("--http-address=0.0.0.0:%d", portAuthProxy),
+		fmt.Sprintf("--upstream=http://127.0.0.1:%d/", portHTTP),
+		"--email-domain=*",
+		"--skip-provider-button",
+	}
+	for _, upstream := range apiUpstreams(apiMode(m)) {
+		args = append(args, "--upstream="+upstream)
+	}
+	// Browsers send the preflight requests of the pages of other origins
+	// without credentials, and kubo answers them from the headers of the spec.
+	_, gatewayCORS := gatewayHTTPHeaders(m)[clusterv1alpha1.HeaderAllowOrigin]
+	if gatewayCORS || (apiMode(m) != "" && m.Spec.API.CORS != nil) {
+		args = append(args, "--skip-auth-preflight")
+	}
+	if auth.OIDC != nil {
+		args = append(args, "--provider=oidc", "--oidc-issuer-url="+auth.OIDC.IssuerURL)
+		if m.Spec.Expose.Host != "" {
+			scheme := "http"
+			if m.Spec.Expose.TLS != nil {
+				scheme = "https"
+			}
+			args = append(args, fmt.Sprintf("--redirect-url=%s://%s/oauth2/callback", scheme, m.Spec.Expose.Host))
+		}
+	}
+	if auth.BasicAuthSecretRef != nil {
+		// The proxy always needs an OAuth client, which goes unused when
+		// users only authenticate against the htpasswd entries.
+		args = append(args,
+			"--htpasswd-file="+authProxyHtpasswdPath+"/"+authProxyHtpasswdKey,
+			"--display-htpasswd-form",
+			"--client-id=unused",
+			"--client-secret=unused",
+		)
+	}
+	return args
+}
+
+// authProxyEnv Returns the environment of the auth proxy, holding the
+// cookie secret and the OIDC client read from their Secrets.
+func authProxyEnv(m *clusterv1alpha1.Ipfs) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{
+			Name: "OAUTH2_PROXY_COOKIE_SECRET",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: authProxyName(m)},
+					Key:                  authProxyCookieSecretKey,
+				},
+			},
+		},
+	}
+	oidc := m.Spec.Expose.Auth.OIDC
+	if oidc == nil {
+		return env
+	}
+	for _, v := range [][2]string{
+		{"OAUTH2_PROXY_CLIENT_ID", "client-id"},
+		{"OAUTH2_PROXY_CLIENT_SECRET", "client-secret"},
+	} {
+		env = append(env, corev1.EnvVar{
+			Name: v[0],
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: oidc.ClientSecretRef,
+					Key:                  v[1],
+				},
+			},
+		})
+	}
+	return env
+}
+
+// authProxyProbe Returns the readiness probe of the auth proxy.
+func authProxyProbe() *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: "/ping",
+				Port: intstr.FromString(authProxyPortName),
+			},
+		},
+	}
+}
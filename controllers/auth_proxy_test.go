@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs gateway auth proxy", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	// proxied Returns whether the StatefulSet runs the proxy and the Service
+	// the Ingress targets forwards to it.
+	proxied := func() bool {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: key.Namespace,
+			Name:      "ipfs-cluster-" + key.Name,
+		}, sts)).To(Succeed())
+		ing := &networkingv1.Ingress{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: key.Namespace,
+			Name:      "ipfs-gateway-" + key.Name,
+		}, ing)).To(Succeed())
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: key.Namespace,
+			Name:      ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name,
+		}, svc)).To(Succeed())
+		Expect(svc.Name).To(Equal("ipfs-cluster-" + key.Name + "-gw"))
+		backend := svc.Spec.Ports[0].TargetPort.StrVal
+
+		sidecar := false
+		for _, c := range sts.Spec.Template.Spec.Containers {
+			if c.Name == "auth-proxy" {
+				sidecar = true
+				Expect(c.Args).To(ContainElement("--htpasswd-file=/etc/auth-proxy/auth"))
+			}
+		}
+		Expect(sidecar).To(Equal(backend == authProxyPortName))
+		return sidecar
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "auth"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Expose.Host = "gateway.example.com"
+		instance.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+			BasicAuthSecretRef: &corev1.LocalObjectReference{Name: "gateway-users"},
+		}
+		users := &corev1.Secret{}
+		users.Name = "gateway-users"
+		users.Namespace = key.Namespace
+		users.Data = map[string][]byte{"auth": []byte("alice:$2y$05$hash")}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, users).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("puts the proxy in front of the gateway until auth is removed", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(proxied()).To(BeTrue())
+		config := &corev1.Secret{}
+		configKey := types.NamespacedName{Namespace: key.Namespace, Name: authProxyName(instance)}
+		Expect(fakeClient.Get(ctx, configKey, config)).To(Succeed())
+		Expect(config.Data[authProxyCookieSecretKey]).To(HaveLen(cookieSecretLen))
+
+		By("removing auth")
+		instance.Spec.Expose.Auth = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		reconcile()
+		Expect(proxied()).To(BeFalse())
+		err := fakeClient.Get(ctx, configKey, config)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("rolls the peers when the users change", func() {
+		instance := reconcile()
+		before, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		users := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "gateway-users"},
+			users)).To(Succeed())
+		users.Data["auth"] = []byte("bob:$2y$05$hash")
+		Expect(fakeClient.Update(ctx, users)).To(Succeed())
+		after, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).NotTo(Equal(before))
+	})
+})
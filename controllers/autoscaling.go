@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	defaultTargetUtilization = 70
+	defaultScaleUpCooldown   = 5 * time.Minute
+	defaultScaleDownCooldown = 30 * time.Minute
+	// autoscalingTolerance Is how far utilization may drift from the target,
+	// in percent of the target, before peers are added or removed.
+	autoscalingTolerance = 10
+)
+
+// peerCount Returns the number of peers of the cluster: the number chosen by
+// the autoscaler when it is enabled, spec.replicas otherwise.
+func peerCount(m *clusterv1alpha1.Ipfs) int32 {
+	if m.Spec.Autoscaling != nil && m.Status.Autoscaling != nil {
+		return m.Status.Autoscaling.Replicas
+	}
+	return m.Spec.Replicas
+}
+
+// reconcileAutoscaling Adjusts the number of peers so that their repos use
+// the target share of their StorageMax on average. Decisions driven by the
+// utilization wait for the usage of every peer and for the cooldown following
+// the previous decision, while changes of the bounds apply at once. Peers are
+// removed from the cluster peerset before the StatefulSet scales down, so that
+// their pins are allocated to the remaining peers. Every decision is recorded
+// as an event.
+func (r *IpfsReconciler) reconcileAutoscaling(ctx context.Context, instance *clusterv1alpha1.Ipfs) {
+	spec := instance.Spec.Autoscaling
+	if spec == nil {
+		instance.Status.Autoscaling = nil
+		return
+	}
+	status := instance.Status.Autoscaling
+	if status == nil {
+		status = &clusterv1alpha1.AutoscalingStatus{Replicas: boundReplicas(spec, instance.Spec.Replicas)}
+		instance.Status.Autoscaling = status
+	}
+
+	target := spec.TargetUtilization
+	if target == 0 {
+		target = defaultTargetUtilization
+	}
+	desired := status.Replicas
+	reason := fmt.Sprintf("the number of peers must lie between %d and %d", spec.MinReplicas, spec.MaxReplicas)
+	utilizationDriven := false
+	if util, ok := aggregateUtilization(instance.Status.Peers, status.Replicas); ok {
+		status.Utilization = util
+		if abs(util-target)*100 > autoscalingTolerance*target {
+			desired = (status.Replicas*util + target - 1) / target
+			reason = fmt.Sprintf("repo utilization is %d%% for a target of %d%%", util, target)
+			utilizationDriven = true
+		}
+	}
+	desired = boundReplicas(spec, desired)
+	if desired == status.Replicas {
+		return
+	}
+	if utilizationDriven && status.LastScaleTime != nil {
+		cooldown := durationOr(spec.ScaleUpCooldown, defaultScaleUpCooldown)
+		if desired < status.Replicas {
+			cooldown = durationOr(spec.ScaleDownCooldown, defaultScaleDownCooldown)
+		}
+		if time.Since(status.LastScaleTime.Time) < cooldown {
+			return
+		}
+	}
+
+	if desired < status.Replicas {
+		if err := r.removePeers(ctx, instance, desired, status.Replicas); err != nil {
+			ctrllog.FromContext(ctx).Error(err, "cannot remove peers before scaling down")
+			r.eventf(instance, corev1.EventTypeWarning, "ScaleDownBlocked",
+				"cannot scale from %d to %d peers as %s: %v", status.Replicas, desired, reason, err)
+			return
+		}
+	}
+	r.eventf(instance, corev1.EventTypeNormal, "Scaled",
+		"scaled from %d to %d peers as %s", status.Replicas, desired, reason)
+	now := metav1.Now()
+	status.Replicas = desired
+	status.LastScaleTime = &now
+}
+
+// removePeers Removes the peers with an ordinal from `from` up to, but
+// excluding, `to` from the cluster peerset.
+func (r *IpfsReconciler) removePeers(ctx context.Context, instance *clusterv1alpha1.Ipfs, from, to int32) error {
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return err
+	}
+	peers, err := api.Peers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range peers {
+		if ordinal := peerOrdinal(p.Peername); ordinal >= from && ordinal < to {
+			if err = api.RemovePeer(ctx, p.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// aggregateUtilization Returns the share of StorageMax used by the repos of
+// the given number of peers, in percent, once the usage of each is known.
+func aggregateUtilization(peers []clusterv1alpha1.PeerStatus, replicas int32) (int32, bool) {
+	var size, storageMax, known int64
+	for _, p := range peers {
+		if p.Repo == nil || peerOrdinal(p.Name) >= replicas {
+			continue
+		}
+		size += p.Repo.Size.Value()
+		storageMax += p.Repo.StorageMax.Value()
+		known++
+	}
+	if known < int64(replicas) || storageMax == 0 {
+		return 0, false
+	}
+	return int32(size * 100 / storageMax), true
+}
+
+// boundReplicas Returns the number of peers closest to n within the bounds
+// of the autoscaler.
+func boundReplicas(spec *clusterv1alpha1.Autoscaling, n int32) int32 {
+	if n < spec.MinReplicas {
+		return spec.MinReplicas
+	}
+	if n > spec.MaxReplicas {
+		return spec.MaxReplicas
+	}
+	return n
+}
+
+func durationOr(d metav1.Duration, fallback time.Duration) time.Duration {
+	if d.Duration == 0 {
+		return fallback
+	}
+	return d.Duration
+}
+
+func abs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+)
+
+var _ = Describe("Ipfs autoscaling", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	// withUsage Reports repos of the given size, in GiB out of 10, for the first peers.
+	withUsage := func(sizes ...int64) {
+		instance.Status.Peers = nil
+		for i, size := range sizes {
+			peer := peerStatus(instance, peerPodName(instance, int32(i)))
+			peer.Repo = &clusterv1alpha1.RepoUsage{
+				Size:       *resource.NewQuantity(size<<30, resource.BinarySI),
+				StorageMax: *resource.NewQuantity(10<<30, resource.BinarySI),
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{Client: fake.NewClientBuilder().Build(), Recorder: recorder}
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "autoscaling"
+		instance.Namespace = "default"
+		instance.Spec.Autoscaling = &clusterv1alpha1.Autoscaling{
+			MinReplicas:       2,
+			MaxReplicas:       5,
+			TargetUtilization: 50,
+		}
+	})
+
+	It("starts at the minimum and waits for the usage of every peer", func() {
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(2)))
+
+		withUsage(9)
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(2)))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("adds peers above the target and honors the cooldown", func() {
+		reconciler.reconcileAutoscaling(ctx, instance)
+		withUsage(8, 8)
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(4)))
+		Expect(instance.Status.Autoscaling.Utilization).To(Equal(int32(80)))
+		Expect(recorder.Events).To(Receive(And(
+			ContainSubstring("from 2 to 4 peers"),
+			ContainSubstring("80%"),
+		)))
+
+		By("staying within the cooldown")
+		withUsage(9, 9, 9, 9)
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(4)))
+
+		By("scaling up to the maximum once the cooldown is over")
+		past := metav1.NewTime(time.Now().Add(-time.Hour))
+		instance.Status.Autoscaling.LastScaleTime = &past
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(5)))
+	})
+
+	It("keeps the peers within the tolerance", func() {
+		reconciler.reconcileAutoscaling(ctx, instance)
+		withUsage(5, 5)
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(2)))
+	})
+
+	It("does not scale down until the peers leave the cluster", func() {
+		instance.Status.Autoscaling = &clusterv1alpha1.AutoscalingStatus{Replicas: 4}
+		withUsage(1, 1, 1, 1)
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(4)))
+		Expect(recorder.Events).To(Receive(ContainSubstring("ScaleDownBlocked")))
+	})
+
+	It("removes the peers leaving the cluster before scaling down", func() {
+		var peers []clusterapi.Peer
+		for i, id := range []string{"12D3KooWA", "12D3KooWB", "12D3KooWC", "12D3KooWD"} {
+			peers = append(peers, clusterapi.Peer{ID: id, Peername: peerPodName(instance, int32(i))})
+		}
+		cluster := clusterfake.NewCluster(peers...)
+		defer cluster.Close()
+		cluster.SetCredentials("admin", "secret")
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		reconciler.Client = fake.NewClientBuilder().WithObjects(credentials).Build()
+		reconciler.ClusterAPI = []clusterapi.Option{clusterapi.WithBaseURL(cluster.URL())}
+		instance.Status.Autoscaling = &clusterv1alpha1.AutoscalingStatus{Replicas: 4}
+		withUsage(1, 1, 1, 1)
+
+		By("keeping the peers while the cluster fails to remove them")
+		cluster.FailNext(clusterfake.RouteRemovePeer, http.StatusInternalServerError)
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(4)))
+		Expect(recorder.Events).To(Receive(ContainSubstring("ScaleDownBlocked")))
+
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(2)))
+		Expect(recorder.Events).To(Receive(ContainSubstring("from 4 to 2 peers")))
+		Expect(cluster.Peers()).To(Equal(peers[:2]))
+	})
+
+	It("ignores spec.replicas", func() {
+		instance.Spec.Replicas = 3
+		instance.Status.Autoscaling = &clusterv1alpha1.AutoscalingStatus{Replicas: 2}
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(peerCount(instance)).To(Equal(int32(2)))
+
+		instance.Spec.Autoscaling = nil
+		reconciler.reconcileAutoscaling(ctx, instance)
+		Expect(instance.Status.Autoscaling).To(BeNil())
+		Expect(peerCount(instance)).To(Equal(int32(3)))
+	})
+})
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// ingressBandwidthAnnotation Caps the inbound traffic of a pod, in bits
+	// per second, through the bandwidth CNI plugin.
+	ingressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	// egressBandwidthAnnotation Caps the outbound traffic of a pod, in bits
+	// per second, through the bandwidth CNI plugin.
+	egressBandwidthAnnotation = "kubernetes.io/egress-bandwidth"
+	// bitswapMarkerPath Marks a repo whose bitswap engine the operator
+	// tuned, to reset it once the settings are removed.
+	bitswapMarkerPath = "/data/ipfs/.bitswap"
+)
+
+// Settings of the bitswap engine of kubo, in its config.
+const (
+	engineWorkersKey       = "Internal.Bitswap.EngineTaskWorkerCount"
+	maxOutstandingBytesKey = "Internal.Bitswap.MaxOutstandingBytesPerPeer"
+	// bitswapDefault Resets a setting to the default of kubo.
+	bitswapDefault = "null"
+)
+
+// bandwidthAnnotations Returns the annotations of the pods of the peers
+// capping their traffic, if any.
+func bandwidthAnnotations(m *clusterv1alpha1.Ipfs) map[string]string {
+	bandwidth := m.Spec.Bandwidth
+	if bandwidth == nil || (bandwidth.Ingress == nil && bandwidth.Egress == nil) {
+		return nil
+	}
+	annotations := map[string]string{}
+	if bandwidth.Ingress != nil {
+		annotations[ingressBandwidthAnnotation] = bandwidth.Ingress.String()
+	}
+	if bandwidth.Egress != nil {
+		annotations[egressBandwidthAnnotation] = bandwidth.Egress.String()
+	}
+	return annotations
+}
+
+// bitswapTuned Returns whether the operator writes the settings of the
+// bitswap engine of the peers. They are left out for kubo releases which
+// would ignore them.
+func bitswapTuned(m *clusterv1alpha1.Ipfs) bool {
+	bandwidth := m.Spec.Bandwidth
+	return bandwidth != nil && (bandwidth.EngineWorkers != nil || bandwidth.MaxOutstandingBytesPerPeer != nil) &&
+		clusterv1alpha1.BitswapTuningSupported(m.Spec.IpfsImage)
+}
+
+// bitswapSettings Returns the JSON values of the settings of the bitswap
+// engine of the peers, null for those the spec leaves to kubo.
+func bitswapSettings(m *clusterv1alpha1.Ipfs) (workers, maxOutstanding string) {
+	workers, maxOutstanding = bitswapDefault, bitswapDefault
+	if n := m.Spec.Bandwidth.EngineWorkers; n != nil {
+		workers = fmt.Sprint(*n)
+	}
+	if q := m.Spec.Bandwidth.MaxOutstandingBytesPerPeer; q != nil {
+		maxOutstanding = fmt.Sprint(q.Value())
+	}
+	return workers, maxOutstanding
+}
+
+// bandwidthCommands Returns the commands of the configure script writing
+// the settings of the bitswap engine of the peers, at every start of the
+// peers. The settings the spec leaves empty, or no longer sets, are reset to
+// the defaults of kubo.
+func bandwidthCommands(m *clusterv1alpha1.Ipfs) string {
+	if !clusterv1alpha1.BitswapTuningSupported(m.Spec.IpfsImage) {
+		return ""
+	}
+	var b strings.Builder
+	if !bitswapTuned(m) {
+		fmt.Fprintf(&b, "\tif [ -f %[1]s ]; then\n\t\tipfs config --json %[2]s %[4]s\n"+
+			"\t\tipfs config --json %[3]s %[4]s\n\t\trm %[1]s\n\tfi\n",
+			bitswapMarkerPath, engineWorkersKey, maxOutstandingBytesKey, bitswapDefault)
+		return b.String()
+	}
+	workers, maxOutstanding := bitswapSettings(m)
+	fmt.Fprintf(&b, "\tipfs config --json %s %s\n", engineWorkersKey, workers)
+	fmt.Fprintf(&b, "\tipfs config --json %s %s\n", maxOutstandingBytesKey, maxOutstanding)
+	fmt.Fprintf(&b, "\ttouch %s\n", bitswapMarkerPath)
+	return b.String()
+}
+
+// bandwidthSettings Returns the settings of the bitswap engine of the peers,
+// for the config hash rolling the peers when they change. The caps roll the
+// peers through the annotations of their pods.
+func bandwidthSettings(m *clusterv1alpha1.Ipfs) []string {
+	if !bitswapTuned(m) {
+		return nil
+	}
+	workers, maxOutstanding := bitswapSettings(m)
+	return []string{fmt.Sprintf("bandwidth/bitswap=%s/%s", workers, maxOutstanding)}
+}
+
+// peerBandwidth Returns the traffic of the kubo node of a peer. The previous
+// traffic is kept when it cannot be read.
+func peerBandwidth(
+	ctx context.Context,
+	api kuboapi.API,
+	peer *clusterv1alpha1.PeerStatus,
+) *clusterv1alpha1.PeerBandwidth {
+	stats, err := api.StatsBW(ctx)
+	if err != nil {
+		ctrllog.FromContext(ctx).Info("cannot get bandwidth stats", "peer", peer.Name, "error", err.Error())
+		return peer.Bandwidth
+	}
+	return &clusterv1alpha1.PeerBandwidth{
+		TotalIn:   *resource.NewQuantity(stats.TotalIn, resource.BinarySI),
+		TotalOut:  *resource.NewQuantity(stats.TotalOut, resource.BinarySI),
+		RateIn:    int64(stats.RateIn),
+		RateOut:   int64(stats.RateOut),
+		CheckedAt: metav1.Now(),
+	}
+}
+
+// forgetPeerBandwidth Deletes the bandwidth gauges of a peer.
+func forgetPeerBandwidth(m *clusterv1alpha1.Ipfs, peer string) {
+	peerBandwidthRate.DeleteLabelValues(m.Namespace, m.Name, peer, "in")
+	peerBandwidthRate.DeleteLabelValues(m.Namespace, m.Name, peer, "out")
+}
+
+// replicatingPeers Returns the number of peers holding a replica of every
+// pin, as ipfs-cluster replicates the pins on every peer by default. The
+// bootstrap peers hold none.
+func replicatingPeers(m *clusterv1alpha1.Ipfs) int64 {
+	return int64(peerCount(m) - bootstrapPeers(m))
+}
+
+// setBandwidthCondition Sets the BandwidthConstrained condition from the
+// caps of the peers. Content entering the cluster is received once and sent
+// to every other replicating peer, so that the peers receive n times what
+// they send n-1 times: peers sending less than (n-1)/n of what they may
+// receive fall behind for as long as content keeps coming at their ingress
+// cap. The condition is removed unless both caps are set.
+func setBandwidthCondition(instance *clusterv1alpha1.Ipfs, resolved *clusterv1alpha1.Ipfs) {
+	bandwidth := resolved.Spec.Bandwidth
+	if bandwidth == nil || bandwidth.Ingress == nil || bandwidth.Egress == nil {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionBandwidthConstrained)
+		return
+	}
+	replicas := replicatingPeers(resolved)
+	egress := bandwidth.Egress.String()
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionBandwidthConstrained,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.BandwidthConstrainedReasonEgressSufficient,
+		Message:            fmt.Sprintf("the egress of %s lets the peers replicate what they receive", egress),
+		ObservedGeneration: instance.Generation,
+	}
+	if replicas > 1 {
+		required := bandwidth.Ingress.Value() / replicas * (replicas - 1)
+		if bandwidth.Egress.Value() < required {
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = clusterv1alpha1.BandwidthConstrainedReasonEgressBelowReplication
+			cond.Message = fmt.Sprintf("the egress of %s is below the %s the peers need to send %d replicas "+
+				"of the content they receive at the ingress of %s", egress,
+				resource.NewQuantity(required, resource.DecimalSI).String(), replicas-1, bandwidth.Ingress.String())
+		}
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs bandwidth", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		node       *kubofake.Node
+	)
+
+	quantity := func(s string) *resource.Quantity {
+		q := resource.MustParse(s)
+		return &q
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "shaped"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.29.0"
+		instance.Spec.ClusterImage = ipfsClusterImage
+		workers := int32(4)
+		instance.Spec.Bandwidth = &clusterv1alpha1.BandwidthConfig{
+			Ingress:       quantity("100M"),
+			Egress:        quantity("50M"),
+			EngineWorkers: &workers,
+		}
+
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-shaped-0"
+		pod.Namespace = instance.Namespace
+		pod.Labels = peerSelector(instance)
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, pod).Build()
+
+		node = kubofake.NewNode("12D3KooWA")
+		node.Repo = kuboapi.RepoStat{RepoSize: 10, StorageMax: 1 << 30}
+		node.Bandwidth = kuboapi.BandwidthStats{TotalIn: 4096, TotalOut: 1024, RateIn: 2048.5, RateOut: 512}
+		dialer := kubofake.NewDialer()
+		dialer.Add(instance.Namespace, pod.Name, node)
+		reconciler = &IpfsReconciler{
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Kubo:     dialer,
+			Recorder: record.NewFakeRecorder(10),
+		}
+	})
+
+	It("caps the traffic of the pods and tunes the bitswap engine", func() {
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-shaped", "ipfs-cluster-shaped",
+			"ipfs-cluster-shaped", "ipfs-cluster-scripts-shaped", "")()).To(Succeed())
+		Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(ingressBandwidthAnnotation, "100M"))
+		Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(egressBandwidthAnnotation, "50M"))
+		Expect(bandwidthCommands(instance)).To(Equal(
+			"\tipfs config --json Internal.Bitswap.EngineTaskWorkerCount 4\n" +
+				"\tipfs config --json Internal.Bitswap.MaxOutstandingBytesPerPeer null\n" +
+				"\ttouch /data/ipfs/.bitswap\n"))
+		Expect(bandwidthSettings(instance)).To(Equal([]string{"bandwidth/bitswap=4/null"}))
+
+		By("resetting the bitswap engine once its settings are removed")
+		instance.Spec.Bandwidth.EngineWorkers = nil
+		Expect(bandwidthCommands(instance)).To(ContainSubstring(
+			"ipfs config --json Internal.Bitswap.EngineTaskWorkerCount null\n\t\tipfs config --json " +
+				"Internal.Bitswap.MaxOutstandingBytesPerPeer null\n\t\trm /data/ipfs/.bitswap"))
+		Expect(bandwidthSettings(instance)).To(BeNil())
+
+		By("leaving the config of kubo releases without the settings alone")
+		instance.Spec.IpfsImage = "ipfs/go-ipfs:v0.12.2"
+		Expect(bandwidthCommands(instance)).To(BeEmpty())
+	})
+
+	It("records the traffic of the peers", func() {
+		key := client.ObjectKeyFromObject(instance)
+		for i := 0; i < 2; i++ {
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(reconciler.Get(ctx, key, instance)).To(Succeed())
+		peer := peerStatus(instance, "ipfs-cluster-shaped-0")
+		Expect(peer.Bandwidth).NotTo(BeNil())
+		Expect(peer.Bandwidth.TotalIn.Value()).To(Equal(int64(4096)))
+		Expect(peer.Bandwidth.RateIn).To(Equal(int64(2048)))
+		Expect(testutil.ToFloat64(peerBandwidthRate.WithLabelValues(
+			instance.Namespace, instance.Name, peer.Name, "out"))).To(Equal(512.0))
+
+		By("keeping the last traffic while the node cannot be queried")
+		node.Errs["stats/bw"] = &kuboapi.Error{StatusCode: 500, Message: "unavailable"}
+		Expect(peerBandwidth(ctx, node, peer)).To(Equal(peer.Bandwidth))
+	})
+
+	It("warns when the egress cannot keep up with the replication of the ingress", func() {
+		setBandwidthCondition(instance, instance)
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionBandwidthConstrained)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.BandwidthConstrainedReasonEgressBelowReplication))
+		Expect(cond.Message).To(ContainSubstring("below the 66666666 the peers need to send 2 replicas"))
+
+		instance.Spec.Bandwidth.Egress = quantity("70M")
+		setBandwidthCondition(instance, instance)
+		Expect(meta.IsStatusConditionFalse(instance.Status.Conditions,
+			clusterv1alpha1.ConditionBandwidthConstrained)).To(BeTrue())
+
+		By("dropping the condition when only one direction is capped")
+		instance.Spec.Bandwidth.Ingress = nil
+		setBandwidthCondition(instance, instance)
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionBandwidthConstrained)).To(BeNil())
+	})
+})
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// bootstrapDefaultsPath Holds the bootstrap list of the repo from before
+	// the operator put the bootstrap peers first, to restore it once they
+	// are no longer dedicated.
+	bootstrapDefaultsPath = "/data/ipfs/.bootstrap-defaults"
+	// bootstrapPeersEnv Tells the entrypoint how many peers, counted from the
+	// first one, are bootstrap peers.
+	bootstrapPeersEnv = "BOOTSTRAP_PEERS"
+	// bootstrapAddressesKey Is the key of the bootstrap ConfigMap listing the
+	// multiaddresses of the bootstrap peers, one per line.
+	bootstrapAddressesKey = "addresses"
+)
+
+// bootstrapPeers Returns the number of peers dedicated to bootstrapping.
+func bootstrapPeers(m *clusterv1alpha1.Ipfs) int32 {
+	if m.Spec.Bootstrap == nil {
+		return 0
+	}
+	return m.Spec.Bootstrap.Peers
+}
+
+// bootstrapServiceName Returns the name of the Service of the bootstrap peer
+// with the given ordinal.
+func bootstrapServiceName(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	return fmt.Sprintf("ipfs-bootstrap-%s-%d", m.Name, ordinal)
+}
+
+// bootstrapConfigMapName Returns the name of the ConfigMap listing the
+// multiaddresses of the bootstrap peers.
+func bootstrapConfigMapName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-bootstrap"
+}
+
+// bootstrapPeerAddresses Returns the multiaddresses of the bootstrap peers
+// whose kubo identity is known, through the DNS names of their Services.
+// The names and the identities, kept in the repos, outlive the pods, so the
+// addresses stay the same when a bootstrap peer is replaced.
+func bootstrapPeerAddresses(m *clusterv1alpha1.Ipfs) []string {
+	var addrs []string
+	for ordinal := int32(0); ordinal < bootstrapPeers(m); ordinal++ {
+		pod := peerPodName(m, ordinal)
+		for _, peer := range m.Status.Peers {
+			if peer.Name == pod && peer.KuboID != "" {
+				addrs = append(addrs, fmt.Sprintf("/dns4/%s.%s.svc/tcp/%d/p2p/%s",
+					bootstrapServiceName(m, ordinal), m.Namespace, portSwarm, peer.KuboID))
+			}
+		}
+	}
+	return addrs
+}
+
+// bootstrapCommands Returns the commands of the configure script putting the
+// bootstrap peers first in the bootstrap list of the repo, at every start of
+// the peers. The list of the repo is saved before the first change, so that
+// removing the bootstrap peers restores it.
+func bootstrapCommands(m *clusterv1alpha1.Ipfs) string {
+	addrs := bootstrapPeerAddresses(m)
+	if len(addrs) == 0 {
+		return fmt.Sprintf("\tif [ -f %[1]s ]; then\n\t\tipfs config --json Bootstrap \"$(cat %[1]s)\"\n"+
+			"\t\trm %[1]s\n\tfi\n", bootstrapDefaultsPath)
+	}
+	quoted := make([]string, len(addrs))
+	for i, addr := range addrs {
+		quoted[i] = `"` + addr + `"`
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\t[ -f %[1]s ] || ipfs config Bootstrap > %[1]s\n", bootstrapDefaultsPath)
+	fmt.Fprintf(&b, "\tipfs config --json Bootstrap \"$(tr -d ' \\n' < %s | "+
+		"sed 's|^null$|[]|; s|^\\[|[%s,|; s|,]$|]|')\"\n", bootstrapDefaultsPath, strings.Join(quoted, ","))
+	return b.String()
+}
+
+// bootstrapEnv Returns the environment telling the entrypoint of the
+// ipfs-cluster daemons which peers are bootstrap peers.
+func bootstrapEnv(m *clusterv1alpha1.Ipfs) []corev1.EnvVar {
+	if bootstrapPeers(m) == 0 {
+		return nil
+	}
+	return []corev1.EnvVar{{Name: bootstrapPeersEnv, Value: fmt.Sprint(bootstrapPeers(m))}}
+}
+
+// serviceBootstrap Returns a mutate function for the Service selecting the
+// single bootstrap peer with the given ordinal, whose DNS name the bootstrap
+// multiaddresses point at.
+func (r *IpfsReconciler) serviceBootstrap(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+	ordinal int32,
+) (controllerutil.MutateFn, string) {
+	svcName := bootstrapServiceName(m, ordinal)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "swarm",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portSwarm,
+					TargetPort: intstr.FromString("swarm"),
+				},
+			},
+			Selector: peerPodSelector(m, ordinal),
+		},
+	}
+	expected.DeepCopyInto(svc)
+	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		svc.Spec.Ports = expected.Spec.Ports
+		svc.Spec.Selector = expected.Spec.Selector
+		return nil
+	}, svcName
+}
+
+// syncBootstrapConfigMap Writes the multiaddresses of the bootstrap peers to
+// the bootstrap ConfigMap, for the nodes outside of the cluster to bootstrap
+// from. The ConfigMap is deleted once no bootstrap peer is dedicated.
+func (r *IpfsReconciler) syncBootstrapConfigMap(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if bootstrapPeers(instance) == 0 || instance.DeletionTimestamp != nil {
+		return r.deleteBootstrapConfigMap(ctx, instance)
+	}
+	addrs := bootstrapPeerAddresses(instance)
+	if len(addrs) == 0 {
+		return nil
+	}
+	cm := corev1.ConfigMap{}
+	cm.Name = bootstrapConfigMapName(instance)
+	cm.Namespace = instance.Namespace
+	_, err := controllerutil.CreateOrPatch(ctx, r.Client, &cm, func() error {
+		cm.Labels = ipfsLabels(instance, componentBootstrap)
+		cm.Data = map[string]string{bootstrapAddressesKey: strings.Join(addrs, "\n") + "\n"}
+		return ctrl.SetControllerReference(instance, &cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write bootstrap configmap: %w", err)
+	}
+	return nil
+}
+
+// deleteBootstrapConfigMap Deletes the bootstrap ConfigMap of the cluster, if any.
+func (r *IpfsReconciler) deleteBootstrapConfigMap(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	cm := corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: bootstrapConfigMapName(instance)}
+	if err := r.Get(ctx, key, &cm); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.Delete(ctx, &cm); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete bootstrap configmap: %w", err)
+	}
+	return nil
+}
+
+// deleteBootstrapServices Deletes the Services of the peers which are no
+// longer bootstrap peers.
+func (r *IpfsReconciler) deleteBootstrapServices(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	services := corev1.ServiceList{}
+	if err := r.List(ctx, &services, client.InNamespace(m.Namespace),
+		client.MatchingLabels{labelInstance: m.Name, labelComponent: componentBootstrap}); err != nil {
+		return fmt.Errorf("cannot list bootstrap services: %w", err)
+	}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if ordinal := peerOrdinal(svc.Name); ordinal >= 0 && ordinal < bootstrapPeers(m) {
+			continue
+		}
+		if err := r.Delete(ctx, svc); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete bootstrap service %s: %w", svc.Name, err)
+		}
+	}
+	return nil
+}
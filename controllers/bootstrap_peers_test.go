@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs bootstrap peers", func() {
+	const address = "/dns4/ipfs-bootstrap-boot-0.default.svc/tcp/4001/p2p/12D3KooWA"
+
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	script := func() string {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-scripts-" + key.Name}, cm)).To(Succeed())
+		return cm.Data["configure-ipfs.sh"]
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "boot"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Bootstrap = &clusterv1alpha1.BootstrapConfig{Peers: 1}
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-boot-0"
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{"app.kubernetes.io/name": "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, pod).Build()
+
+		dialer := kubofake.NewDialer()
+		dialer.Add(key.Namespace, "ipfs-cluster-boot-0", kubofake.NewNode("12D3KooWA"))
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer}
+	})
+
+	It("exposes the bootstrap peers and lists them first", func() {
+		reconcile()
+		reconcile()
+		instance := reconcile()
+
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-bootstrap-boot-0"}, svc)).To(Succeed())
+		Expect(svc.Spec.Selector).To(HaveKeyWithValue(appsv1.StatefulSetPodNameLabel, "ipfs-cluster-boot-0"))
+		Expect(svc.Spec.Ports).To(ConsistOf(HaveField("Port", int32(portSwarm))))
+
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "boot-bootstrap"}, cm)).To(Succeed())
+		Expect(cm.Data).To(HaveKeyWithValue("addresses", address+"\n"))
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+
+		Expect(script()).To(ContainSubstring(`ipfs config --json Bootstrap "$(tr -d ' \n' < ` +
+			`/data/ipfs/.bootstrap-defaults | sed 's|^null$|[]|; s|^\[|["` + address + `",|; s|,]$|]|')"`))
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-boot"}, sts)).To(Succeed())
+		Expect(sts.Spec.Template.Spec.Containers[1].Env).To(ContainElement(
+			corev1.EnvVar{Name: "BOOTSTRAP_PEERS", Value: "1"}))
+
+		By("restoring the bootstrap list once the peers are no longer dedicated")
+		instance.Spec.Bootstrap = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		reconcile()
+		Expect(script()).To(ContainSubstring(
+			`ipfs config --json Bootstrap "$(cat /data/ipfs/.bootstrap-defaults)"`))
+		err := fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-bootstrap-boot-0"}, svc)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		err = fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "boot-bootstrap"}, cm)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("keeps the bootstrap peers out of the pin allocations", func() {
+		Expect(entrypoint).To(ContainSubstring(`if [ "${ORDINAL}" -lt "${BOOTSTRAP_PEERS:-0}" ]; then
+	export CLUSTER_TAGS_TAGS=role:bootstrap`))
+		Expect(defaultAllocatorOrder()).To(ContainElement("tag:group"))
+	})
+})
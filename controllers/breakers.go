@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+)
+
+// APIs of a cluster the operator calls, each guarded by a breaker.
+const (
+	apiCluster = "cluster"
+	apiKubo    = "kubo"
+)
+
+// guardedAPIs Lists the APIs of a cluster in the order they are reported.
+var guardedAPIs = []string{apiCluster, apiKubo}
+
+// apiBreakers Keeps the circuit breakers of the APIs of each cluster in
+// memory, so that a cluster which cannot be reached costs its reconciles no
+// more than the calls probing it. The breakers are closed again when the
+// operator restarts.
+type apiBreakers struct {
+	mu       sync.Mutex
+	clusters map[types.NamespacedName]map[string]*breaker.Breaker
+}
+
+// get Returns the breaker of the API of the cluster, creating it closed.
+func (a *apiBreakers) get(key types.NamespacedName, api string, settings breaker.Settings) *breaker.Breaker {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clusters == nil {
+		a.clusters = map[types.NamespacedName]map[string]*breaker.Breaker{}
+	}
+	breakers, ok := a.clusters[key]
+	if !ok {
+		breakers = map[string]*breaker.Breaker{}
+		a.clusters[key] = breakers
+	}
+	b, ok := breakers[api]
+	if !ok {
+		b = breaker.New(settings)
+		breakers[api] = b
+	}
+	return b
+}
+
+// states Returns the state of the breakers of the APIs of the cluster which
+// were called.
+func (a *apiBreakers) states(key types.NamespacedName) map[string]breaker.State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	states := map[string]breaker.State{}
+	for api, b := range a.clusters[key] {
+		states[api] = b.State()
+	}
+	return states
+}
+
+// forget Drops the breakers of the cluster.
+func (a *apiBreakers) forget(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.clusters, key)
+}
+
+// syncAPIUnreachable Records the state of the breakers of the APIs of the
+// cluster in the APIUnreachable condition and in the metrics. The condition
+// is only set once the operator called the APIs of the cluster.
+func (r *IpfsReconciler) syncAPIUnreachable(instance *clusterv1alpha1.Ipfs) {
+	states := r.breakers.states(client.ObjectKeyFromObject(instance))
+	if len(states) == 0 {
+		return
+	}
+	var open []string
+	for _, api := range guardedAPIs {
+		state, ok := states[api]
+		if !ok {
+			continue
+		}
+		value := 0.0
+		if state != breaker.Closed {
+			value = 1
+			open = append(open, fmt.Sprintf("%s (%s)", api, state))
+		}
+		apiBreakerOpen.WithLabelValues(instance.Namespace, instance.Name, api).Set(value)
+	}
+	condition := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionAPIUnreachable,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.APIUnreachableReasonBreakerClosed,
+		Message:            "the APIs of the cluster answer",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(open) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = clusterv1alpha1.APIUnreachableReasonBreakerOpen
+		condition.Message = "calls stopped after consecutive failures: " + strings.Join(open, ", ")
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+}
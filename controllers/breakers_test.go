@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs API circuit breakers", func() {
+	const deadline = 200 * time.Millisecond
+
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		server     *httptest.Server
+		requests   int32
+	)
+
+	// reconcile Reconciles the cluster and returns how long it took.
+	reconcile := func() (*clusterv1alpha1.Ipfs, time.Duration) {
+		start := time.Now()
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		elapsed := time.Since(start)
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance, elapsed
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "hanging"}
+		requests = 0
+		// The REST API accepts the connections but never answers.
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			<-r.Context().Done()
+		}))
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-hanging-0"
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, pod).Build()
+		dialer := kubofake.NewDialer()
+		dialer.Add(key.Namespace, pod.Name, kubofake.NewNode("12D3KooWA"))
+		reconciler = &IpfsReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			Kubo:   dialer,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(server.URL),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+			APIBreaker: breaker.Settings{Threshold: 1, Cooldown: time.Hour, Deadline: deadline},
+		}
+		reconcile()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("stops calling a cluster which does not answer", func() {
+		instance, elapsed := reconcile()
+		Expect(elapsed).To(BeNumerically(">=", deadline))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionAPIUnreachable)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Message).To(ContainSubstring("cluster (Open)"))
+
+		By("failing the calls at once while the breaker is open")
+		for i := 0; i < 3; i++ {
+			_, elapsed = reconcile()
+			Expect(elapsed).To(BeNumerically("<", deadline))
+		}
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Budgets Serves the budget of the Ipfs resources of each namespace: the one
+// the namespace sets in its budget annotation, or else the one of the
+// operator defaults.
+type Budgets struct {
+	// Reader reads the namespaces, which are not worth caching.
+	Reader   client.Reader
+	Defaults *DefaultsStore
+}
+
+var _ clusterv1alpha1.BudgetSource = Budgets{}
+
+// BudgetFor Returns the budget of the Ipfs resources of the namespace, along
+// with where it is set. Namespaces which cannot be found get the budget of
+// the operator.
+func (b Budgets) BudgetFor(ctx context.Context, namespace string) (clusterv1alpha1.Budget, string, error) {
+	ns := corev1.Namespace{}
+	if err := b.Reader.Get(ctx, client.ObjectKey{Name: namespace}, &ns); client.IgnoreNotFound(err) != nil {
+		return clusterv1alpha1.Budget{}, "", fmt.Errorf("cannot get namespace %s: %w", namespace, err)
+	}
+	value, ok := ns.Annotations[clusterv1alpha1.AnnotationBudget]
+	if !ok {
+		return b.Defaults.Get().Budget, "the operator configuration", nil
+	}
+	budget := clusterv1alpha1.Budget{}
+	if err := yaml.UnmarshalStrict([]byte(value), &budget); err != nil {
+		return clusterv1alpha1.Budget{}, "", fmt.Errorf("cannot parse the %s annotation of namespace %s: %w",
+			clusterv1alpha1.AnnotationBudget, namespace, err)
+	}
+	return budget, fmt.Sprintf("the %s annotation of namespace %s", clusterv1alpha1.AnnotationBudget, namespace), nil
+}
+
+// enforceBudget Sets the BudgetExceeded condition when the spec exceeds the
+// budget of its namespace, as happens to resources created before the
+// validating webhook was deployed, and returns whether the reconcile must
+// pause. The condition is removed once the spec fits.
+func (r *IpfsReconciler) enforceBudget(ctx context.Context, instance *clusterv1alpha1.Ipfs) (bool, error) {
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	budget, source, err := Budgets{Reader: reader, Defaults: r.Defaults}.BudgetFor(ctx, instance.Namespace)
+	if err != nil {
+		return false, err
+	}
+	errs := clusterv1alpha1.ValidateBudget(&instance.Spec, nil, budget, source)
+	if len(errs) == 0 {
+		if meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionBudgetExceeded) == nil {
+			return false, nil
+		}
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionBudgetExceeded)
+		return false, r.Status().Update(ctx, instance)
+	}
+
+	message := errs.ToAggregate().Error()
+	current := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionBudgetExceeded)
+	if current != nil && current.Message == message && current.ObservedGeneration == instance.Generation {
+		return true, nil
+	}
+	r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.ConditionBudgetExceeded,
+		"Reconciling is paused until the spec fits the budget: %s", message)
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionBudgetExceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             clusterv1alpha1.BudgetExceededReasonLimitExceeded,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+	return true, r.Status().Update(ctx, instance)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs budget", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		namespace  *corev1.Namespace
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "trusted", Name: "budget"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		namespace = &corev1.Namespace{}
+		namespace.Name = key.Namespace
+		namespace.Annotations = map[string]string{clusterv1alpha1.AnnotationBudget: "maxReplicas: 2"}
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespace, instance).Build()
+		defaults := &DefaultsStore{current: builtinDefaults()}
+		defaults.current.Budget.MaxReplicas = 10
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Defaults: defaults}
+	})
+
+	It("serves the budget of the namespace over the one of the operator", func() {
+		budgets := Budgets{Reader: fakeClient, Defaults: reconciler.Defaults}
+		budget, source, err := budgets.BudgetFor(ctx, key.Namespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(budget.MaxReplicas).To(Equal(int32(2)))
+		Expect(source).To(Equal("the ipfs.cluster.io/budget annotation of namespace trusted"))
+
+		budget, source, err = budgets.BudgetFor(ctx, "default")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(budget.MaxReplicas).To(Equal(int32(10)))
+		Expect(source).To(Equal("the operator configuration"))
+
+		By("refusing a malformed budget")
+		namespace.Annotations[clusterv1alpha1.AnnotationBudget] = "maxReplicas: many"
+		Expect(fakeClient.Update(ctx, namespace)).To(Succeed())
+		_, _, err = budgets.BudgetFor(ctx, key.Namespace)
+		Expect(err).To(MatchError(ContainSubstring("cannot parse the ipfs.cluster.io/budget annotation")))
+	})
+
+	It("pauses the clusters exceeding their budget", func() {
+		reconcile()
+		instance := reconcile()
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionBudgetExceeded)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.BudgetExceededReasonLimitExceeded))
+		Expect(cond.Message).To(ContainSubstring("spec.replicas"))
+		Expect(cond.Message).To(ContainSubstring("namespace trusted"))
+		err := fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-budget"},
+			&appsv1.StatefulSet{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("resuming once the spec fits")
+		instance.Spec.Replicas = 2
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionBudgetExceeded)).To(BeNil())
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-budget"},
+			sts)).To(Succeed())
+		Expect(*sts.Spec.Replicas).To(Equal(int32(2)))
+	})
+})
@@ -50,7 +50,6 @@ type CircuitRelayReconciler struct {
 	Scheme *runtime.Scheme
 }
 
-//+kubebuilder:rbac:groups=*,resources=*,verbs=get;list
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cluster.ipfs.io,resources=circuitrelays,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cluster.ipfs.io,resources=circuitrelays/status,verbs=get;update;patch
@@ -65,8 +64,9 @@ func (r *CircuitRelayReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
+	labels := relayLabels(instance)
 	svc := corev1.Service{}
-	svcMut := r.serviceRelay(instance, &svc)
+	svcMut := labeled(&svc, labels, r.serviceRelay(instance, &svc))
 	_, err = controllerutil.CreateOrPatch(ctx, r.Client, &svc, svcMut)
 	if err != nil {
 		log.Error(err, "error during CreateOrPatch service", "name", instance.Name)
@@ -114,7 +114,7 @@ func (r *CircuitRelayReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		}
 		sec := corev1.Secret{}
 		mutsec := r.secretIdentity(instance, &sec, identity)
-		trackedObjects[&sec] = mutsec
+		trackedObjects[&sec] = labeled(&sec, labels, mutsec)
 	}
 
 	if err = instance.Status.AddrInfo.Parse(); err != nil {
@@ -128,11 +128,11 @@ func (r *CircuitRelayReconciler) Reconcile(ctx context.Context, req ctrl.Request
 
 	cm := corev1.ConfigMap{}
 	mutcm := r.configRelay(instance, &cm)
-	trackedObjects[&cm] = mutcm
+	trackedObjects[&cm] = labeled(&cm, labels, mutcm)
 
 	dep := appsv1.Deployment{}
 	mutdep := r.deploymentRelay(instance, &dep)
-	trackedObjects[&dep] = mutdep
+	trackedObjects[&dep] = labeled(&dep, labels, mutdep)
 
 	shouldRequeue := utils.CreateOrPatchTrackedObjects(ctx, trackedObjects, r.Client, log)
 	return ctrl.Result{Requeue: shouldRequeue}, nil
@@ -245,6 +245,10 @@ func (r *CircuitRelayReconciler) serviceRelay(
 		}
 	}
 	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
 		svc.Spec = expected.Spec
 		return nil
 	}
@@ -365,72 +369,14 @@ func (r *CircuitRelayReconciler) deploymentRelay(
 					},
 				},
 				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "relay",
-							Image: "coryschwartz/libp2p-relay-daemon:latest",
-							Args: []string{
-								"-config",
-								"/config.json",
-								"-id",
-								"/identity",
-							},
-							Ports: []corev1.ContainerPort{
-								{
-									Name:          "swarm",
-									ContainerPort: portSwarm,
-									Protocol:      "TCP",
-								},
-								{
-									// Should this port number be the same as portSwarm or should it be a different one?
-									Name:          "swarm-udp",
-									ContainerPort: portSwarmUDP,
-									Protocol:      "UDP",
-								},
-								{
-									Name:          "pprof",
-									ContainerPort: portPprof,
-									Protocol:      "UDP",
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "config",
-									MountPath: "/config.json",
-									SubPath:   "config.json",
-								},
-								{
-									Name:      "identity",
-									MountPath: "/identity",
-									SubPath:   "identity",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: "libp2p-relay-daemon-config-" + m.Name,
-									},
-								},
-							},
-						},
-						{
-							Name: "identity",
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: "libp2p-relay-daemon-identity-" + m.Name,
-								},
-							},
-						},
-					},
+					Containers: []corev1.Container{relayContainer()},
+					Volumes:    relayVolumes(m),
 				},
 			},
 		},
 	}
+	// The selector is immutable and keeps matching on the name label only.
+	mergeLabels(&expected.Spec.Template, relayLabels(m))
 	expected.DeepCopyInto(dep)
 	// FIXME: return an error before returning a function which errors
 	if err := ctrl.SetControllerReference(m, dep, r.Scheme); err != nil {
@@ -439,7 +385,80 @@ func (r *CircuitRelayReconciler) deploymentRelay(
 		}
 	}
 	return func() error {
+		unchanged, err := specUnchanged(dep, expected.Spec, dep.Spec)
+		if err != nil || unchanged {
+			return err
+		}
 		dep.Spec = expected.Spec
 		return nil
 	}
 }
+
+// relayContainer Returns the container of the relay daemon.
+func relayContainer() corev1.Container {
+	return corev1.Container{
+		Name:  "relay",
+		Image: relayImage,
+		Args: []string{
+			"-config",
+			"/config.json",
+			"-id",
+			"/identity",
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "swarm",
+				ContainerPort: portSwarm,
+				Protocol:      "TCP",
+			},
+			{
+				// Should this port number be the same as portSwarm or should it be a different one?
+				Name:          "swarm-udp",
+				ContainerPort: portSwarmUDP,
+				Protocol:      "UDP",
+			},
+			{
+				Name:          "pprof",
+				ContainerPort: portPprof,
+				Protocol:      "UDP",
+			},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "config",
+				MountPath: "/config.json",
+				SubPath:   "config.json",
+			},
+			{
+				Name:      "identity",
+				MountPath: "/identity",
+				SubPath:   "identity",
+			},
+		},
+	}
+}
+
+// relayVolumes Returns the volumes of the relay daemon: its config and its
+// identity.
+func relayVolumes(m *clusterv1alpha1.CircuitRelay) []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: "libp2p-relay-daemon-config-" + m.Name,
+					},
+				},
+			},
+		},
+		{
+			Name: "identity",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: "libp2p-relay-daemon-identity-" + m.Name,
+				},
+			},
+		},
+	}
+}
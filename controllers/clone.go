@@ -0,0 +1,393 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// cloneRequeueInterval Is how often a cluster being cloned checks on the
+// volumes it takes over.
+const cloneRequeueInterval = 10 * time.Second
+
+// Keys of the Secrets holding the cluster secret and the bootstrap identity.
+const (
+	clusterSecretKey       = "CLUSTER_SECRET"
+	bootstrapPrivateKeyKey = "BOOTSTRAP_PEER_PRIV_KEY"
+)
+
+// cloneCluster Takes over the identity, the cluster secret and the volumes of
+// the cluster named by spec.cloneFrom, before the objects of the cluster are
+// created. It returns whether the cluster must wait for the cloning to
+// progress. What was taken over is recorded in the status.
+func (r *IpfsReconciler) cloneCluster(ctx context.Context, m *clusterv1alpha1.Ipfs) (bool, error) {
+	if m.Spec.CloneFrom == "" || (m.Status.Clone != nil && m.Status.Clone.CompletedAt != nil) {
+		return false, nil
+	}
+	source := &clusterv1alpha1.Ipfs{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: m.Spec.CloneFrom}, source)
+	switch {
+	case errors.IsNotFound(err):
+		source = nil
+	case err != nil:
+		return false, fmt.Errorf("cannot get Ipfs %s: %w", m.Spec.CloneFrom, err)
+	}
+	// The webhook refuses such clones, but it may not be deployed.
+	if errs := clusterv1alpha1.ValidateCloneSource(source); len(errs) > 0 {
+		r.eventf(m, corev1.EventTypeWarning, "CloneSourceActive", "cannot clone: %s", errs.ToAggregate().Error())
+		return true, nil
+	}
+
+	if m.Status.Clone == nil {
+		clone, err := r.cloneIdentity(ctx, m)
+		if err != nil {
+			return false, err
+		}
+		m.Status.Clone = clone
+	}
+	pending := false
+	for ordinal := int32(0); ordinal < peerCount(m); ordinal++ {
+		for _, volume := range []string{"cluster-storage", "ipfs-storage"} {
+			done, err := r.cloneVolume(ctx, m, volume, ordinal, source == nil)
+			if err != nil {
+				return false, err
+			}
+			pending = pending || !done
+		}
+	}
+	if pending {
+		return true, nil
+	}
+	now := metav1.Now()
+	m.Status.Clone.CompletedAt = &now
+	r.eventf(m, corev1.EventTypeNormal, "Cloned", "took over the identity and %d volumes of Ipfs %s",
+		len(m.Status.Clone.Volumes), m.Spec.CloneFrom)
+	return false, nil
+}
+
+// cloneIdentity Creates the Secrets and the ConfigMap of the cluster from
+// those of the source, before the cluster would create them with a new
+// identity. The identities of the peers found in the Secret of the source are
+// copied. Peers the source has neither an identity nor a volume for are given
+// a new identity, while the others keep the identity held in their volume.
+func (r *IpfsReconciler) cloneIdentity(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) (*clusterv1alpha1.CloneStatus, error) {
+	clone := &clusterv1alpha1.CloneStatus{Source: m.Spec.CloneFrom}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: identitiesSecretName(m)}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		// The identity was taken over by a reconcile which failed to record it.
+		return clone, err
+	}
+	sourceName := identitiesSecretName(&clusterv1alpha1.Ipfs{ObjectMeta: metav1.ObjectMeta{Name: m.Spec.CloneFrom}})
+	source, err := r.configSecret(ctx, m.Namespace, m.Spec.CloneFrom)
+	if err != nil {
+		return nil, err
+	}
+	if source == nil {
+		r.eventf(m, corev1.EventTypeWarning, "CloneIdentityNotFound",
+			"secret %s of Ipfs %s is gone; the cluster gets a new identity", sourceName, m.Spec.CloneFrom)
+		return clone, nil
+	}
+	clone.SourceUID = source.Labels[clusterv1alpha1.LabelOwnerUID]
+	bootstrapID, err := peerIDOf(source.Data[bootstrapPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the bootstrap identity of secret %s: %w", source.Name, err)
+	}
+	clone.BootstrapPeerID = bootstrapID.String()
+	clone.ClusterSecret = len(source.Data[clusterSecretKey]) > 0
+	data, err := r.clonePeerIdentities(ctx, m, source, clone)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.createClonedIdentity(ctx, m, source, clone, data); err != nil {
+		return nil, err
+	}
+	r.eventf(m, corev1.EventTypeNormal, "Cloning", "took over the identity of Ipfs %s", m.Spec.CloneFrom)
+	return clone, nil
+}
+
+// clonePeerIdentities Returns the data of the identities Secret of the
+// clone: the bootstrap identity and the identities of the peers found in the
+// source, and new identities for the peers the source has neither an identity
+// nor a volume for. The copied and minted ordinals are recorded in clone.
+func (r *IpfsReconciler) clonePeerIdentities(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	source *corev1.Secret,
+	clone *clusterv1alpha1.CloneStatus,
+) (map[string][]byte, error) {
+	data := map[string][]byte{
+		bootstrapPrivateKeyKey: source.Data[bootstrapPrivateKeyKey],
+	}
+	for ordinal := int32(0); ordinal < peerCount(m); ordinal++ {
+		idKey, privKey := peerIdentityKeys(ordinal)
+		if len(source.Data[privKey]) > 0 {
+			data[idKey], data[privKey] = source.Data[idKey], source.Data[privKey]
+			clone.CopiedIdentities = append(clone.CopiedIdentities, ordinal)
+			continue
+		}
+		if ordinal == 0 {
+			continue
+		}
+		claim := fmt.Sprintf("cluster-storage-ipfs-cluster-%s-%d", m.Spec.CloneFrom, ordinal)
+		err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: claim}, &corev1.PersistentVolumeClaim{})
+		if !errors.IsNotFound(err) {
+			if err != nil {
+				return nil, fmt.Errorf("cannot get volume claim %s: %w", claim, err)
+			}
+			continue
+		}
+		id, priv, err := generateIdentity()
+		if err != nil {
+			return nil, err
+		}
+		data[idKey], data[privKey] = []byte(id.String()), []byte(priv)
+		clone.MintedIdentities = append(clone.MintedIdentities, ordinal)
+	}
+	return data, nil
+}
+
+// createClonedIdentity Creates the ConfigMap and the Secrets of the cluster
+// holding the identity taken over from the source.
+func (r *IpfsReconciler) createClonedIdentity(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	source *corev1.Secret,
+	clone *clusterv1alpha1.CloneStatus,
+	data map[string][]byte,
+) error {
+	cm := corev1.ConfigMap{}
+	cm.Name = "ipfs-cluster-" + m.Name
+	cm.Namespace = m.Namespace
+	cm.Labels = ipfsLabels(m, componentPeer)
+	cm.Data = map[string]string{"BOOTSTRAP_PEER_ID": clone.BootstrapPeerID}
+	secCluster := corev1.Secret{}
+	secCluster.Name = clusterSecretName(m)
+	secCluster.Namespace = m.Namespace
+	secCluster.Labels = ipfsLabels(m, componentPeer)
+	secCluster.Data = map[string][]byte{clusterSecretKey: source.Data[clusterSecretKey]}
+	// The identities come last, as their Secret tells the identity was taken over.
+	secIdentities := corev1.Secret{}
+	secIdentities.Name = identitiesSecretName(m)
+	secIdentities.Namespace = m.Namespace
+	secIdentities.Labels = ipfsLabels(m, componentPeer)
+	secIdentities.Data = data
+	for _, obj := range []client.Object{&cm, &secCluster, &secIdentities} {
+		if err := ctrl.SetControllerReference(m, obj, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, obj); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("cannot create %s %s: %w", r.kindOf(obj), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// peerIDOf Returns the peer ID of the private key, encoded as the Secrets
+// hold it.
+func peerIDOf(encoded []byte) (peer.ID, error) {
+	priv, err := privateKeyOf(encoded)
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPrivateKey(priv)
+}
+
+// privateKeyOf Decodes a private key encoded as the Secrets hold it.
+func privateKeyOf(encoded []byte) (ci.PrivKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return ci.UnmarshalPrivateKey(raw)
+}
+
+// cloneVolume Takes over the claim of the source peer with the given ordinal
+// as the claim of the new peer, before the StatefulSet creates an empty one.
+// When the source was deleted and its volume is retained, the volume is
+// rebound to the new claim: the claim of the source is deleted, then the
+// volume is reserved for the new claim. Otherwise the new claim is a CSI
+// clone of the source claim. It returns whether the claim is taken over, or
+// the source has none.
+func (r *IpfsReconciler) cloneVolume(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	volume string,
+	ordinal int32,
+	sourceDeleted bool,
+) (bool, error) {
+	claimName := fmt.Sprintf("%s-ipfs-cluster-%s-%d", volume, m.Name, ordinal)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: claimName},
+		&corev1.PersistentVolumeClaim{}); !errors.IsNotFound(err) {
+		return err == nil, err
+	}
+	source := corev1.PersistentVolumeClaim{}
+	sourceName := fmt.Sprintf("%s-ipfs-cluster-%s-%d", volume, m.Spec.CloneFrom, ordinal)
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: sourceName}, &source)
+	if err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("cannot get volume claim %s: %w", sourceName, err)
+	}
+	sourceFound := err == nil
+
+	cloned := clonedVolume(m, claimName)
+	if cloned == nil {
+		if !sourceFound {
+			return true, nil
+		}
+		planned, err := r.planClonedVolume(ctx, &source, claimName, sourceDeleted)
+		if err != nil {
+			return false, err
+		}
+		m.Status.Clone.Volumes = append(m.Status.Clone.Volumes, planned)
+		cloned = &m.Status.Clone.Volumes[len(m.Status.Clone.Volumes)-1]
+	}
+
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: m.Namespace,
+			Labels:    ipfsLabels(m, componentPeer),
+		},
+	}
+	if cloned.Method == clusterv1alpha1.CloneMethodCloned {
+		if !sourceFound {
+			r.eventf(m, corev1.EventTypeWarning, "CloneVolumeNotFound", "volume claim %s is gone", sourceName)
+			return true, nil
+		}
+		if err = r.cloneClaim(ctx, m, claim, &source); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	// The volume is only released once the claim of the source is gone.
+	if sourceFound {
+		if source.DeletionTimestamp == nil {
+			if err = r.Delete(ctx, &source); client.IgnoreNotFound(err) != nil {
+				return false, fmt.Errorf("cannot release volume claim %s: %w", sourceName, err)
+			}
+			r.eventf(m, corev1.EventTypeNormal, "Cloning", "releasing volume %s of claim %s",
+				cloned.VolumeName, sourceName)
+		}
+		return false, nil
+	}
+	return r.rebindVolume(ctx, m, claim, cloned.VolumeName)
+}
+
+// cloneClaim Creates the claim as a CSI clone of the source claim.
+func (r *IpfsReconciler) cloneClaim(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	claim *corev1.PersistentVolumeClaim,
+	source *corev1.PersistentVolumeClaim,
+) error {
+	claim.Spec = corev1.PersistentVolumeClaimSpec{
+		StorageClassName: source.Spec.StorageClassName,
+		AccessModes:      source.Spec.AccessModes,
+		Resources:        corev1.ResourceRequirements{Requests: source.Spec.Resources.Requests},
+		DataSource: &corev1.TypedLocalObjectReference{
+			Kind: "PersistentVolumeClaim",
+			Name: source.Name,
+		},
+	}
+	if err := r.Create(ctx, claim); err != nil {
+		return fmt.Errorf("cannot clone volume claim %s: %w", source.Name, err)
+	}
+	r.eventf(m, corev1.EventTypeNormal, "Cloning", "cloning volume claim %s into %s", source.Name, claim.Name)
+	return nil
+}
+
+// rebindVolume Reserves the released volume of the source for the claim,
+// then creates the claim bound to it. It returns whether the claim is taken
+// over, or the volume is gone.
+func (r *IpfsReconciler) rebindVolume(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	claim *corev1.PersistentVolumeClaim,
+	volumeName string,
+) (bool, error) {
+	pv := corev1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: volumeName}, &pv); err != nil {
+		if errors.IsNotFound(err) {
+			r.eventf(m, corev1.EventTypeWarning, "CloneVolumeNotFound", "volume %s is gone", volumeName)
+			return true, nil
+		}
+		return false, fmt.Errorf("cannot get volume %s: %w", volumeName, err)
+	}
+	claimName := claim.Name
+	if ref := pv.Spec.ClaimRef; ref == nil || ref.Namespace != m.Namespace || ref.Name != claimName || ref.UID != "" {
+		patch := client.MergeFrom(pv.DeepCopy())
+		pv.Spec.ClaimRef = &corev1.ObjectReference{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+			Namespace:  m.Namespace,
+			Name:       claimName,
+		}
+		if err := r.Patch(ctx, &pv, patch); err != nil {
+			return false, fmt.Errorf("cannot reserve volume %s: %w", pv.Name, err)
+		}
+	}
+	claim.Spec = corev1.PersistentVolumeClaimSpec{
+		StorageClassName: &pv.Spec.StorageClassName,
+		AccessModes:      pv.Spec.AccessModes,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: pv.Spec.Capacity[corev1.ResourceStorage]},
+		},
+		VolumeName: pv.Name,
+	}
+	if err := r.Create(ctx, claim); err != nil {
+		return false, fmt.Errorf("cannot rebind volume %s: %w", pv.Name, err)
+	}
+	r.eventf(m, corev1.EventTypeNormal, "Cloning", "rebinding volume %s to claim %s", pv.Name, claimName)
+	return true, nil
+}
+
+// planClonedVolume Returns how the source claim is taken over: its volume is
+// rebound when the source was deleted and the volume is retained once
+// released, and the claim is cloned otherwise.
+func (r *IpfsReconciler) planClonedVolume(
+	ctx context.Context,
+	source *corev1.PersistentVolumeClaim,
+	claimName string,
+	sourceDeleted bool,
+) (clusterv1alpha1.ClonedVolume, error) {
+	cloned := clusterv1alpha1.ClonedVolume{
+		Claim:  claimName,
+		Source: source.Name,
+		Method: clusterv1alpha1.CloneMethodCloned,
+	}
+	if !sourceDeleted || source.Spec.VolumeName == "" {
+		return cloned, nil
+	}
+	pv := corev1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: source.Spec.VolumeName}, &pv); err != nil {
+		return cloned, client.IgnoreNotFound(err)
+	}
+	if pv.Spec.PersistentVolumeReclaimPolicy == corev1.PersistentVolumeReclaimRetain {
+		cloned.Method = clusterv1alpha1.CloneMethodRebound
+		cloned.VolumeName = pv.Name
+	}
+	return cloned, nil
+}
+
+// clonedVolume Returns the record of the claim taken over, if any.
+func clonedVolume(m *clusterv1alpha1.Ipfs, claimName string) *clusterv1alpha1.ClonedVolume {
+	for i := range m.Status.Clone.Volumes {
+		if m.Status.Clone.Volumes[i].Claim == claimName {
+			return &m.Status.Clone.Volumes[i]
+		}
+	}
+	return nil
+}
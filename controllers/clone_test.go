@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs clone", func() {
+	var (
+		ctx         context.Context
+		fakeClient  client.Client
+		reconciler  *IpfsReconciler
+		key         types.NamespacedName
+		bootstrapID string
+	)
+
+	reconcile := func() (*clusterv1alpha1.Ipfs, ctrl.Result) {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance, result
+	}
+
+	// sourceClaim Returns a bound claim of the source cluster and its volume.
+	sourceClaim := func(name string, policy corev1.PersistentVolumeReclaimPolicy) []client.Object {
+		pv := &corev1.PersistentVolume{}
+		pv.Name = "pv-" + name
+		pv.Spec.PersistentVolumeReclaimPolicy = policy
+		pv.Spec.StorageClassName = "standard"
+		pv.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+		pv.Spec.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")}
+		pv.Spec.ClaimRef = &corev1.ObjectReference{Namespace: key.Namespace, Name: name, UID: "claim-uid"}
+		pvc := &corev1.PersistentVolumeClaim{}
+		pvc.Name = name
+		pvc.Namespace = key.Namespace
+		pvc.Spec.VolumeName = pv.Name
+		pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("5Gi")}
+		return []client.Object{pv, pvc}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "renamed"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		id, priv, err := generateIdentity()
+		Expect(err).NotTo(HaveOccurred())
+		bootstrapID = id.String()
+		peerID, peerPriv, err := generateIdentity()
+		Expect(err).NotTo(HaveOccurred())
+		secret := &corev1.Secret{}
+		secret.Name = "ipfs-cluster-original"
+		secret.Namespace = key.Namespace
+		secret.Labels = map[string]string{clusterv1alpha1.LabelOwnerUID: "original-uid"}
+		secret.Data = map[string][]byte{
+			"CLUSTER_SECRET":          []byte("0123abcd"),
+			"BOOTSTRAP_PEER_PRIV_KEY": []byte(priv),
+			"PEER_ID_1":               []byte(peerID.String()),
+			"PEER_PRIV_KEY_1":         []byte(peerPriv),
+		}
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.CloneFrom = "original"
+		objects := []client.Object{instance, secret}
+		objects = append(objects, sourceClaim("cluster-storage-ipfs-cluster-original-0",
+			corev1.PersistentVolumeReclaimRetain)...)
+		for _, name := range []string{
+			"ipfs-storage-ipfs-cluster-original-0",
+			"cluster-storage-ipfs-cluster-original-1",
+			"ipfs-storage-ipfs-cluster-original-1",
+		} {
+			objects = append(objects, sourceClaim(name, corev1.PersistentVolumeReclaimDelete)...)
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("takes over the identity and the volumes of a deleted cluster", func() {
+		reconcile()
+		instance, result := reconcile()
+		Expect(result.RequeueAfter).To(Equal(cloneRequeueInterval))
+		Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhasePending))
+		Expect(instance.Status.Clone).NotTo(BeNil())
+		Expect(instance.Status.Clone.CompletedAt).To(BeNil())
+		err := fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-renamed"},
+			&appsv1.StatefulSet{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("rebinding the retained volume once its claim is gone")
+		instance, _ = reconcile()
+		clone := instance.Status.Clone
+		Expect(clone.CompletedAt).NotTo(BeNil())
+		Expect(clone.SourceUID).To(Equal("original-uid"))
+		Expect(clone.BootstrapPeerID).To(Equal(bootstrapID))
+		Expect(clone.ClusterSecret).To(BeTrue())
+		Expect(clone.CopiedIdentities).To(Equal([]int32{1}))
+		Expect(clone.MintedIdentities).To(Equal([]int32{2}))
+		Expect(clone.Volumes).To(ContainElement(clusterv1alpha1.ClonedVolume{
+			Claim:      "cluster-storage-ipfs-cluster-renamed-0",
+			Source:     "cluster-storage-ipfs-cluster-original-0",
+			Method:     clusterv1alpha1.CloneMethodRebound,
+			VolumeName: "pv-cluster-storage-ipfs-cluster-original-0",
+		}))
+		Expect(clone.Volumes).To(HaveLen(4))
+
+		claim := &corev1.PersistentVolumeClaim{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "cluster-storage-ipfs-cluster-renamed-0"}, claim)).To(Succeed())
+		Expect(claim.Spec.VolumeName).To(Equal("pv-cluster-storage-ipfs-cluster-original-0"))
+		pv := &corev1.PersistentVolume{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Name: claim.Spec.VolumeName}, pv)).To(Succeed())
+		Expect(pv.Spec.ClaimRef.Name).To(Equal(claim.Name))
+		Expect(pv.Spec.ClaimRef.UID).To(BeEmpty())
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-storage-ipfs-cluster-renamed-1"}, claim)).To(Succeed())
+		Expect(claim.Spec.DataSource.Name).To(Equal("ipfs-storage-ipfs-cluster-original-1"))
+
+		By("creating the cluster with the identity of the source")
+		secret := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-renamed-cluster-secret"}, secret)).To(Succeed())
+		Expect(secret.Data["CLUSTER_SECRET"]).To(Equal([]byte("0123abcd")))
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-renamed-identities"}, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKey("PEER_PRIV_KEY_2"))
+		Expect(secret.Data).NotTo(HaveKey("CLUSTER_SECRET"))
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-renamed"},
+			cm)).To(Succeed())
+		Expect(cm.Data["BOOTSTRAP_PEER_ID"]).To(Equal(bootstrapID))
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-renamed"},
+			&appsv1.StatefulSet{})).To(Succeed())
+	})
+
+	It("waits for the source to stop being reconciled", func() {
+		source := &clusterv1alpha1.Ipfs{}
+		source.Name = "original"
+		source.Namespace = key.Namespace
+		source.Spec.Replicas = 2
+		Expect(fakeClient.Create(ctx, source)).To(Succeed())
+		reconcile()
+		instance, result := reconcile()
+		Expect(result.RequeueAfter).To(Equal(cloneRequeueInterval))
+		Expect(instance.Status.Clone).To(BeNil())
+		err := fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-renamed-identities"}, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("cloning the volumes of a source set to verify-only")
+		source.Annotations = map[string]string{clusterv1alpha1.AnnotationVerifyOnly: "true"}
+		Expect(fakeClient.Update(ctx, source)).To(Succeed())
+		instance, _ = reconcile()
+		Expect(instance.Status.Clone.CompletedAt).NotTo(BeNil())
+		for _, volume := range instance.Status.Clone.Volumes {
+			Expect(volume.Method).To(Equal(clusterv1alpha1.CloneMethodCloned))
+		}
+	})
+})
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Defaults of ipfs-cluster, which the daemons keep for the fields the spec
+// leaves empty.
+const (
+	defaultMonitorPingInterval = 15 * time.Second
+	defaultPinRecoverInterval  = 12 * time.Minute
+	defaultConcurrentPins      = 10
+	defaultFreespaceMetricTTL  = 30 * time.Second
+)
+
+// defaultAllocatorOrder Returns the metrics ipfs-cluster sorts the peers by
+// by default: their group, then their free space.
+func defaultAllocatorOrder() []string {
+	return []string{"tag:group", "freespace"}
+}
+
+// Environment variables ipfs-cluster reads its configuration overrides from.
+const (
+	monitorPingIntervalEnv = "CLUSTER_MONITORPINGINTERVAL"
+	pinRecoverIntervalEnv  = "CLUSTER_PINRECOVERINTERVAL"
+	concurrentPinsEnv      = "CLUSTER_STATELESS_CONCURRENTPINS"
+	freespaceMetricTTLEnv  = "CLUSTER_DISK_METRICTTL"
+	allocatorOrderEnv      = "CLUSTER_BALANCED_ALLOCATEBY"
+	batchingMaxAgeEnv      = "CLUSTER_CRDT_BATCHING_MAXBATCHAGE"
+	batchingMaxSizeEnv     = "CLUSTER_CRDT_BATCHING_MAXBATCHSIZE"
+)
+
+// clusterConfigOf Returns the values the ipfs-cluster daemons of the cluster
+// run with.
+func clusterConfigOf(m *clusterv1alpha1.Ipfs) *clusterv1alpha1.ClusterConfigStatus {
+	status := &clusterv1alpha1.ClusterConfigStatus{
+		MonitorPingInterval: metav1.Duration{Duration: defaultMonitorPingInterval},
+		PinRecoverInterval:  metav1.Duration{Duration: defaultPinRecoverInterval},
+		ConcurrentPins:      defaultConcurrentPins,
+		FreespaceMetricTTL:  metav1.Duration{Duration: defaultFreespaceMetricTTL},
+		AllocatorOrder:      defaultAllocatorOrder(),
+	}
+	cluster := m.Spec.Cluster
+	if cluster == nil {
+		return status
+	}
+	if cluster.MonitorPingInterval != nil {
+		status.MonitorPingInterval = *cluster.MonitorPingInterval
+	}
+	if cluster.PinRecoverInterval != nil {
+		status.PinRecoverInterval = *cluster.PinRecoverInterval
+	}
+	if cluster.PinTracker != nil && cluster.PinTracker.ConcurrentPins != nil {
+		status.ConcurrentPins = *cluster.PinTracker.ConcurrentPins
+	}
+	if ttl := freespaceMetricTTL(cluster); ttl != nil {
+		status.FreespaceMetricTTL = *ttl
+	}
+	if cluster.Allocator != nil && len(cluster.Allocator.Order) > 0 {
+		status.AllocatorOrder = cluster.Allocator.Order
+	}
+	return status
+}
+
+// clusterConfigEnv Returns the environment setting the values of the spec in
+// the service.json of the ipfs-cluster daemons. ipfs-cluster writes them to
+// service.json when it initializes it, and applies them over it every time it
+// starts, so that changing them restarts the peers with the new values.
+func clusterConfigEnv(m *clusterv1alpha1.Ipfs) []corev1.EnvVar {
+	cluster := m.Spec.Cluster
+	if cluster == nil {
+		return nil
+	}
+	var env []corev1.EnvVar
+	if cluster.MonitorPingInterval != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  monitorPingIntervalEnv,
+			Value: cluster.MonitorPingInterval.Duration.String(),
+		})
+	}
+	if cluster.PinRecoverInterval != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  pinRecoverIntervalEnv,
+			Value: cluster.PinRecoverInterval.Duration.String(),
+		})
+	}
+	if cluster.PinTracker != nil && cluster.PinTracker.ConcurrentPins != nil {
+		env = append(env, corev1.EnvVar{
+			Name:  concurrentPinsEnv,
+			Value: strconv.Itoa(int(*cluster.PinTracker.ConcurrentPins)),
+		})
+	}
+	if ttl := freespaceMetricTTL(cluster); ttl != nil {
+		env = append(env, corev1.EnvVar{Name: freespaceMetricTTLEnv, Value: ttl.Duration.String()})
+	}
+	if cluster.Allocator != nil && len(cluster.Allocator.Order) > 0 {
+		env = append(env, corev1.EnvVar{Name: allocatorOrderEnv, Value: strings.Join(cluster.Allocator.Order, ",")})
+	}
+	if crdt := cluster.CRDT; crdt != nil && crdt.BatchingMaxAge != nil && crdt.BatchingMaxSize != nil {
+		env = append(env,
+			corev1.EnvVar{Name: batchingMaxAgeEnv, Value: crdt.BatchingMaxAge.Duration.String()},
+			corev1.EnvVar{Name: batchingMaxSizeEnv, Value: strconv.Itoa(int(*crdt.BatchingMaxSize))},
+		)
+	}
+	return env
+}
+
+// freespaceMetricTTL Returns the TTL of the freespace metric set in the
+// spec, if any.
+func freespaceMetricTTL(cluster *clusterv1alpha1.ClusterConfig) *metav1.Duration {
+	if cluster.Informer == nil || cluster.Informer.Freespace == nil {
+		return nil
+	}
+	return cluster.Informer.Freespace.MetricTTL
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs cluster tuning", func() {
+	var instance *clusterv1alpha1.Ipfs
+
+	// renderEnv Renders the environment the way it is set on the daemons,
+	// one variable per line.
+	renderEnv := func(env []corev1.EnvVar) string {
+		var rendered strings.Builder
+		for _, e := range env {
+			fmt.Fprintf(&rendered, "%s=%s\n", e.Name, e.Value)
+		}
+		return rendered.String()
+	}
+
+	BeforeEach(func() {
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "tuning"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+	})
+
+	It("keeps the defaults of ipfs-cluster when the spec is empty", func() {
+		Expect(clusterConfigEnv(instance)).To(BeEmpty())
+		Expect(*clusterConfigOf(instance)).To(Equal(clusterv1alpha1.ClusterConfigStatus{
+			MonitorPingInterval: metav1.Duration{Duration: 15 * time.Second},
+			PinRecoverInterval:  metav1.Duration{Duration: 12 * time.Minute},
+			ConcurrentPins:      10,
+			FreespaceMetricTTL:  metav1.Duration{Duration: 30 * time.Second},
+			AllocatorOrder:      []string{"tag:group", "freespace"},
+		}))
+	})
+
+	It("sets the values of the spec on the ipfs-cluster daemons", func() {
+		pins := int32(32)
+		instance.Spec.Cluster = &clusterv1alpha1.ClusterConfig{
+			MonitorPingInterval: &metav1.Duration{Duration: 5 * time.Second},
+			PinTracker:          &clusterv1alpha1.PinTrackerConfig{ConcurrentPins: &pins},
+		}
+		sts := &appsv1.StatefulSet{}
+		scheme := runtime.NewScheme()
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler := &IpfsReconciler{Scheme: scheme}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-tuning", "ipfs-cluster-tuning",
+			"ipfs-cluster-tuning", "ipfs-cluster-scripts-tuning", "")()).To(Succeed())
+		env := sts.Spec.Template.Spec.Containers[1].Env
+		Expect(env).To(ContainElement(corev1.EnvVar{Name: "CLUSTER_MONITORPINGINTERVAL", Value: "5s"}))
+		Expect(env).To(ContainElement(corev1.EnvVar{Name: "CLUSTER_STATELESS_CONCURRENTPINS", Value: "32"}))
+		for _, e := range env {
+			Expect(e.Name).NotTo(Equal("CLUSTER_PINRECOVERINTERVAL"))
+		}
+
+		status := clusterConfigOf(instance)
+		Expect(status.MonitorPingInterval.Duration).To(Equal(5 * time.Second))
+		Expect(status.PinRecoverInterval.Duration).To(Equal(12 * time.Minute))
+		Expect(status.ConcurrentPins).To(Equal(pins))
+	})
+
+	It("renders the informer and allocator of the ipfs-cluster daemons", func() {
+		instance.Spec.Cluster = &clusterv1alpha1.ClusterConfig{
+			Informer: &clusterv1alpha1.InformerConfig{
+				Freespace: &clusterv1alpha1.FreespaceInformerConfig{
+					MetricTTL: &metav1.Duration{Duration: 2 * time.Minute},
+				},
+			},
+			Allocator: &clusterv1alpha1.AllocatorConfig{Order: []string{"tag:zone", "tag:group", "freespace"}},
+		}
+		expectGolden("cluster-informer-allocator", renderEnv(clusterConfigEnv(instance)))
+
+		status := clusterConfigOf(instance)
+		Expect(status.FreespaceMetricTTL.Duration).To(Equal(2 * time.Minute))
+		Expect(status.AllocatorOrder).To(Equal([]string{"tag:zone", "tag:group", "freespace"}))
+	})
+
+	It("keeps the informer and allocator of ipfs-cluster by default", func() {
+		instance.Spec.Cluster = &clusterv1alpha1.ClusterConfig{
+			Informer:  &clusterv1alpha1.InformerConfig{},
+			Allocator: &clusterv1alpha1.AllocatorConfig{},
+		}
+		Expect(clusterConfigEnv(instance)).To(BeEmpty())
+		status := clusterConfigOf(instance)
+		Expect(status.FreespaceMetricTTL.Duration).To(Equal(30 * time.Second))
+		Expect(status.AllocatorOrder).To(Equal([]string{"tag:group", "freespace"}))
+	})
+})
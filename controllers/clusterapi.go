@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/apiproxy"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// apiUsername Is the user of the admin credentials of the REST API.
+	apiUsername = "admin"
+	// apiReadOnlyUsername Is the user of the read-only credentials.
+	apiReadOnlyUsername = "readonly"
+	// apiCredentialsEnv Holds the credentials in the format ipfs-cluster
+	// reads its basic_auth_credentials from. The operator authenticates
+	// with them, and they never rotate.
+	apiCredentialsEnv = apiproxy.UpstreamCredentialsKey
+	// apiListenEnv Holds the address the REST API listens on.
+	apiListenEnv = "CLUSTER_RESTAPI_HTTPLISTENMULTIADDRESS"
+	// portAPIUpstream Is the local port the REST API listens on behind the
+	// proxy scoping its credentials.
+	portAPIUpstream = 9097
+	// apiProxyContainerName Is the name of the container scoping the
+	// credentials of the REST API.
+	apiProxyContainerName = "cluster-api-proxy"
+	// apiProxyMountPath Is where the proxy mounts the API credentials.
+	apiProxyMountPath = "/etc/cluster-api"
+)
+
+// apiCredentialsName Returns the name of the Secret holding the credentials
+// of the REST API of the cluster.
+func apiCredentialsName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-api"
+}
+
+// apiScoped Returns whether the peers run the proxy scoping the credentials
+// of the REST API, which needs the operator binary. Otherwise the REST API
+// only accepts the admin credentials.
+func (r *IpfsReconciler) apiScoped(m *clusterv1alpha1.Ipfs) bool {
+	return r.OperatorImage != ""
+}
+
+// apiCredentials Generates the admin and read-only credentials of the REST
+// API once and keeps them until they rotate. The credentials the REST API
+// itself is configured with start as the admin ones and are kept when those
+// rotate, the proxy mapping both to the admin scope.
+func (r *IpfsReconciler) apiCredentials(
+	m *clusterv1alpha1.Ipfs,
+	sec *corev1.Secret,
+) (controllerutil.MutateFn, string) {
+	secName := apiCredentialsName(m)
+	expected := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secName,
+			Namespace: m.Namespace,
+		},
+	}
+	expected.DeepCopyInto(sec)
+	// FIXME: catch this error before we run the function being returned
+	if err := ctrl.SetControllerReference(m, sec, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		if sec.Data == nil {
+			sec.Data = map[string][]byte{}
+		}
+		for _, scope := range []string{clusterv1alpha1.APIScopeAdmin, clusterv1alpha1.APIScopeReadOnly} {
+			if len(sec.Data[apiPasswordKey(scope)]) > 0 {
+				continue
+			}
+			if err := newAPICredentials(sec, scope); err != nil {
+				return err
+			}
+		}
+		if len(sec.Data[apiCredentialsEnv]) == 0 {
+			sec.Data[apiCredentialsEnv] = []byte(fmt.Sprintf("%s:%s",
+				sec.Data[clusterapi.UsernameKey], sec.Data[clusterapi.PasswordKey]))
+		}
+		return nil
+	}, secName
+}
+
+// apiUsernameKey Returns the key of the API Secret holding the username of
+// the credentials of the given scope.
+func apiUsernameKey(scope string) string {
+	if scope == clusterv1alpha1.APIScopeReadOnly {
+		return clusterapi.ReadOnlyUsernameKey
+	}
+	return clusterapi.UsernameKey
+}
+
+// apiPasswordKey Returns the key of the API Secret holding the password of
+// the credentials of the given scope.
+func apiPasswordKey(scope string) string {
+	if scope == clusterv1alpha1.APIScopeReadOnly {
+		return clusterapi.ReadOnlyPasswordKey
+	}
+	return clusterapi.PasswordKey
+}
+
+// newAPICredentials Writes new credentials of the given scope to the API
+// Secret, leaving those of the other scope alone.
+func newAPICredentials(sec *corev1.Secret, scope string) error {
+	password, err := newClusterSecret()
+	if err != nil {
+		return fmt.Errorf("cannot generate cluster API password: %w", err)
+	}
+	username := apiUsername
+	if scope == clusterv1alpha1.APIScopeReadOnly {
+		username = apiReadOnlyUsername
+	}
+	sec.Data[apiUsernameKey(scope)] = []byte(username)
+	sec.Data[apiPasswordKey(scope)] = []byte(password)
+	return nil
+}
+
+// reconcileAPICredentialRotation Rotates the credentials of the scope
+// requested through the rotate-api-credentials annotation and removes the
+// annotation. The proxies of the peers pick the new credentials up once the
+// kubelet updated their mounted Secret, without restarting. Without the
+// proxy, the REST API only knows the credentials it started with, so the
+// rotation is refused.
+func (r *IpfsReconciler) reconcileAPICredentialRotation(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	scope, requested := instance.Annotations[clusterv1alpha1.AnnotationRotateAPICredentials]
+	if !requested {
+		return nil
+	}
+	switch {
+	case scope != clusterv1alpha1.APIScopeAdmin && scope != clusterv1alpha1.APIScopeReadOnly:
+		r.eventf(instance, corev1.EventTypeWarning, "APICredentialsRotationFailed",
+			"unknown scope %q, expected %s or %s", scope,
+			clusterv1alpha1.APIScopeAdmin, clusterv1alpha1.APIScopeReadOnly)
+	case !r.apiScoped(instance):
+		r.eventf(instance, corev1.EventTypeWarning, "APICredentialsRotationFailed",
+			"the credentials of the cluster API cannot rotate without the operator image")
+	default:
+		sec := corev1.Secret{}
+		key := client.ObjectKey{Namespace: instance.Namespace, Name: apiCredentialsName(instance)}
+		if err := r.Get(ctx, key, &sec); err != nil {
+			return fmt.Errorf("cannot get cluster API credentials: %w", err)
+		}
+		if sec.Data == nil {
+			sec.Data = map[string][]byte{}
+		}
+		if err := newAPICredentials(&sec, scope); err != nil {
+			return err
+		}
+		if err := r.Update(ctx, &sec); err != nil {
+			return fmt.Errorf("cannot update cluster API credentials: %w", err)
+		}
+		r.eventf(instance, corev1.EventTypeNormal, "APICredentialsRotated",
+			"rotated the %s credentials of the cluster API", scope)
+	}
+	updated := instance.DeepCopy()
+	delete(updated.Annotations, clusterv1alpha1.AnnotationRotateAPICredentials)
+	if err := r.Patch(ctx, updated, client.MergeFrom(instance)); err != nil {
+		return fmt.Errorf("cannot remove %s annotation: %w", clusterv1alpha1.AnnotationRotateAPICredentials, err)
+	}
+	instance.Annotations = updated.Annotations
+	instance.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// apiProxyContainer Returns the container serving the REST API on its port
+// with the operator binary, scoping the admin and read-only credentials, and
+// forwarding the allowed requests to the REST API listening locally.
+func apiProxyContainer(m *clusterv1alpha1.Ipfs, image string) (corev1.Container, corev1.Volume) {
+	container := corev1.Container{
+		Name:            apiProxyContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/manager", "--cluster-api-proxy"},
+		Env: []corev1.EnvVar{
+			{Name: apiproxy.ListenEnv, Value: fmt.Sprintf(":%d", portAPIHTTP)},
+			{Name: apiproxy.UpstreamEnv, Value: fmt.Sprintf("http://127.0.0.1:%d", portAPIUpstream)},
+			{Name: apiproxy.CredentialsEnv, Value: apiProxyMountPath},
+		},
+		Ports: []corev1.ContainerPort{{
+			Name:          "api-http",
+			ContainerPort: portAPIHTTP,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "api-credentials",
+			MountPath: apiProxyMountPath,
+			ReadOnly:  true,
+		}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("16Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+		},
+	}
+	volume := corev1.Volume{
+		Name: "api-credentials",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: apiCredentialsName(m)},
+		},
+	}
+	return container, volume
+}
+
+// clusterAPI Returns a client of the REST API of the cluster, authenticated
+// with the credentials the REST API is configured with, which never rotate,
+// and guarded by the breaker of the cluster.
+func (r *IpfsReconciler) clusterAPI(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) (*clusterapi.Client, error) {
+	sec := corev1.Secret{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: apiCredentialsName(m)}
+	if err := r.Get(ctx, key, &sec); err != nil {
+		return nil, fmt.Errorf("cannot get cluster API credentials: %w", err)
+	}
+	if creds := strings.SplitN(string(sec.Data[apiCredentialsEnv]), ":", 2); len(creds) == 2 {
+		sec.Data[clusterapi.UsernameKey] = []byte(creds[0])
+		sec.Data[clusterapi.PasswordKey] = []byte(creds[1])
+	}
+	opts := []clusterapi.Option{
+		clusterapi.WithBreaker(r.breakers.get(client.ObjectKeyFromObject(m), apiCluster, r.APIBreaker)),
+	}
+	if r.Pool != nil {
+		opts = append(opts, clusterapi.WithPool(r.Pool))
+	}
+	opts = append(opts, r.ClusterAPI...)
+	return clusterapi.NewForService(apiServiceName(m), m.Namespace, portAPIHTTP, &sec, opts...)
+}
+
+// statusSyncInterval Returns how often the peers are queried, stretched
+// while the connection pool of the operator is saturated.
+func (r *IpfsReconciler) statusSyncInterval() time.Duration {
+	interval := r.Defaults.Get().StatusSyncInterval.Duration
+	if r.Pool == nil {
+		return interval
+	}
+	return r.Pool.Stretch(interval)
+}
+
+// kuboDialer Returns the dialer of the kubo nodes, which sends the commands
+// through the connection pool of the operator, if any.
+func (r *IpfsReconciler) kuboDialer() kuboapi.Dialer {
+	if r.Kubo != nil {
+		return r.Kubo
+	}
+	dialer := kuboapi.PodDialer{Port: portAPI}
+	if r.Pool != nil {
+		dialer.Options = []kuboapi.Option{kuboapi.WithPool(r.Pool)}
+	}
+	return dialer
+}
+
+// kuboAPI Returns a client of the kubo node of the peer with the given
+// ordinal, guarded by the breaker shared by the kubo nodes of the cluster.
+func (r *IpfsReconciler) kuboAPI(m *clusterv1alpha1.Ipfs, ordinal int32) (kuboapi.API, error) {
+	api, err := r.kuboDialer().Dial(m.Namespace, peerServiceName(m), peerPodName(m, ordinal))
+	if err != nil {
+		return nil, err
+	}
+	return kuboapi.Guarded(api, r.breakers.get(client.ObjectKeyFromObject(m), apiKubo, r.APIBreaker)), nil
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/apiproxy"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+var _ = Describe("Ipfs cluster API credentials", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+		api        *corev1.Secret
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "scopes"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{
+			Client:        fakeClient,
+			Scheme:        scheme,
+			Recorder:      recorder,
+			OperatorImage: "quay.io/example/ipfs-operator:v1",
+		}
+
+		api = &corev1.Secret{}
+		mutate, _ := reconciler.apiCredentials(instance, api)
+		Expect(mutate()).To(Succeed())
+		Expect(fakeClient.Create(ctx, api)).To(Succeed())
+	})
+
+	rotate := func(scope string) {
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationRotateAPICredentials: scope}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		Expect(reconciler.reconcileAPICredentialRotation(ctx, instance)).To(Succeed())
+		Expect(instance.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRotateAPICredentials))
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(api), api)).To(Succeed())
+	}
+
+	It("generates admin and read-only credentials and rotates each on its own", func() {
+		Expect(string(api.Data[clusterapi.UsernameKey])).To(Equal("admin"))
+		Expect(string(api.Data[clusterapi.ReadOnlyUsernameKey])).To(Equal("readonly"))
+		Expect(api.Data[clusterapi.ReadOnlyPasswordKey]).NotTo(Equal(api.Data[clusterapi.PasswordKey]))
+		upstream := string(api.Data[apiCredentialsEnv])
+		Expect(upstream).To(Equal("admin:" + string(api.Data[clusterapi.PasswordKey])))
+		admin := string(api.Data[clusterapi.PasswordKey])
+		readOnly := string(api.Data[clusterapi.ReadOnlyPasswordKey])
+
+		By("rotating the read-only credentials")
+		rotate(clusterv1alpha1.APIScopeReadOnly)
+		Expect(string(api.Data[clusterapi.ReadOnlyPasswordKey])).NotTo(Equal(readOnly))
+		Expect(string(api.Data[clusterapi.PasswordKey])).To(Equal(admin))
+		Expect(recorder.Events).To(Receive(ContainSubstring("APICredentialsRotated")))
+		readOnly = string(api.Data[clusterapi.ReadOnlyPasswordKey])
+
+		By("rotating the admin credentials")
+		rotate(clusterv1alpha1.APIScopeAdmin)
+		Expect(string(api.Data[clusterapi.PasswordKey])).NotTo(Equal(admin))
+		Expect(string(api.Data[clusterapi.ReadOnlyPasswordKey])).To(Equal(readOnly))
+		Expect(recorder.Events).To(Receive(ContainSubstring("APICredentialsRotated")))
+
+		By("keeping the credentials of the REST API itself")
+		Expect(string(api.Data[apiCredentialsEnv])).To(Equal(upstream))
+		mutate, _ := reconciler.apiCredentials(instance, api)
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(api), api)).To(Succeed())
+		Expect(mutate()).To(Succeed())
+		Expect(string(api.Data[apiCredentialsEnv])).To(Equal(upstream))
+	})
+
+	It("refuses rotations the REST API cannot follow", func() {
+		password := string(api.Data[clusterapi.PasswordKey])
+		rotate("everything")
+		Expect(recorder.Events).To(Receive(ContainSubstring(`unknown scope "everything"`)))
+
+		reconciler.OperatorImage = ""
+		rotate(clusterv1alpha1.APIScopeAdmin)
+		Expect(recorder.Events).To(Receive(ContainSubstring("cannot rotate without the operator image")))
+		Expect(string(api.Data[clusterapi.PasswordKey])).To(Equal(password))
+	})
+
+	It("rejects the mutations sent with the read-only credentials", func() {
+		dir, err := os.MkdirTemp("", "api-credentials")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { Expect(os.RemoveAll(dir)).To(Succeed()) }()
+		for key, value := range api.Data {
+			Expect(os.WriteFile(filepath.Join(dir, key), value, 0o600)).To(Succeed())
+		}
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		defer peer.Close()
+		target, err := url.Parse(peer.URL)
+		Expect(err).NotTo(HaveOccurred())
+		creds := &apiproxy.FileCredentials{Dir: dir}
+		proxy := httptest.NewServer(apiproxy.NewHandler(target, creds.Get))
+		defer proxy.Close()
+
+		send := func(method, scope string) int {
+			req, err := http.NewRequest(method, proxy.URL+"/pins/bafybeigdyrzt", nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.SetBasicAuth(string(api.Data[apiUsernameKey(scope)]), string(api.Data[apiPasswordKey(scope)]))
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Body.Close()).To(Succeed())
+			return res.StatusCode
+		}
+		Expect(send(http.MethodGet, clusterv1alpha1.APIScopeReadOnly)).To(Equal(http.StatusOK))
+		Expect(send(http.MethodPost, clusterv1alpha1.APIScopeReadOnly)).To(Equal(http.StatusForbidden))
+		Expect(send(http.MethodDelete, clusterv1alpha1.APIScopeReadOnly)).To(Equal(http.StatusForbidden))
+		Expect(send(http.MethodPost, clusterv1alpha1.APIScopeAdmin)).To(Equal(http.StatusOK))
+	})
+
+	It("bundles the read-only credentials for ipfs-cluster-ctl unless asked for the admin ones", func() {
+		key := types.NamespacedName{Namespace: "default", Name: "scopes-ctl-credentials"}
+		bundle := &corev1.Secret{}
+		Expect(reconciler.syncCtlCredentials(ctx, instance)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, bundle)).To(Succeed())
+		Expect(bundle.Data["username"]).To(Equal([]byte("readonly")))
+		Expect(bundle.Data["password"]).To(Equal(api.Data[clusterapi.ReadOnlyPasswordKey]))
+
+		instance.Spec.ClusterAPI = &clusterv1alpha1.ClusterAPIConfig{CtlScope: clusterv1alpha1.APIScopeAdmin}
+		Expect(reconciler.syncCtlCredentials(ctx, instance)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, bundle)).To(Succeed())
+		Expect(bundle.Data["username"]).To(Equal([]byte("admin")))
+		Expect(bundle.Data["password"]).To(Equal(api.Data[clusterapi.PasswordKey]))
+
+		By("bundling the admin credentials when the REST API cannot scope them")
+		instance.Spec.ClusterAPI = nil
+		reconciler.OperatorImage = ""
+		Expect(reconciler.syncCtlCredentials(ctx, instance)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, bundle)).To(Succeed())
+		Expect(bundle.Data["username"]).To(Equal([]byte("admin")))
+	})
+
+	It("serves the REST API of the peers through the proxy", func() {
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-scopes", "ipfs-cluster-scopes",
+			"ipfs-cluster-scopes", "ipfs-cluster-scripts-scopes", "")()).To(Succeed())
+		containers := sts.Spec.Template.Spec.Containers
+		Expect(containers[1].Ports).NotTo(ContainElement(HaveField("Name", "api-http")))
+		Expect(containers[1].Env).To(ContainElement(corev1.EnvVar{
+			Name: apiListenEnv, Value: "/ip4/127.0.0.1/tcp/9097",
+		}))
+		proxy := containers[len(containers)-1]
+		Expect(proxy.Name).To(Equal(apiProxyContainerName))
+		Expect(proxy.Command).To(Equal([]string{"/manager", "--cluster-api-proxy"}))
+		Expect(proxy.Ports).To(ConsistOf(HaveField("Name", "api-http")))
+		Expect(sts.Spec.Template.Spec.Volumes).To(ContainElement(HaveField("Name", "api-credentials")))
+
+		By("leaving the REST API in place without the operator image")
+		reconciler.OperatorImage = ""
+		sts = &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-scopes", "ipfs-cluster-scopes",
+			"ipfs-cluster-scopes", "ipfs-cluster-scripts-scopes", "")()).To(Succeed())
+		Expect(sts.Spec.Template.Spec.Containers).NotTo(ContainElement(HaveField("Name", apiProxyContainerName)))
+		Expect(sts.Spec.Template.Spec.Containers[1].Ports).To(ContainElement(HaveField("Name", "api-http")))
+	})
+})
@@ -0,0 +1,457 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// compactionMarker Is the file of the cluster volume of a peer which
+	// holds its ipfs-cluster daemon back while a compaction Job owns its
+	// state.
+	compactionMarker = ipfsClusterMountPath + "/.compacting"
+	// compactionDeadline Is how long the compaction of a peer may take,
+	// including its rejoining the cluster. The peers start regardless of a
+	// marker older than that.
+	compactionDeadline = time.Hour
+	// compactionPollInterval Is how often a compaction in progress is
+	// checked.
+	compactionPollInterval = 15 * time.Second
+
+	memberReasonCompacting = "Compacting"
+)
+
+// compactState Rebuilds the CRDT state of the peer from its pinset once its
+// daemon released the state, and reports the size of the cluster volume in
+// kibibytes as the termination message. The marker is removed however the
+// Job ends, so that the peer starts again.
+const compactState = `
+set -e
+trap 'rm -f ` + compactionMarker + `' EXIT
+trap 'exit 1' TERM INT
+# The state stays locked until the daemon of the peer stopped.
+until ipfs-cluster-service state export --file /tmp/state.json; do
+	sleep 5
+done
+ipfs-cluster-service state cleanup --force
+ipfs-cluster-service state import --force /tmp/state.json
+echo "stateSize=$(du -sk ` + ipfsClusterMountPath + ` | cut -f1)" > /dev/termination-log
+`
+
+// compactionJobName Returns the name of the Job compacting the state of a
+// peer.
+func compactionJobName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-compact"
+}
+
+// compactSchedule Returns the schedule of the compactions of the cluster, or
+// an empty string when the state is never compacted.
+func compactSchedule(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.Cluster == nil || m.Spec.Cluster.CRDT == nil {
+		return ""
+	}
+	return m.Spec.Cluster.CRDT.CompactSchedule
+}
+
+// nextCompaction Returns when the next round of compactions starts: the
+// first start of the schedule after the last round, or after the creation of
+// the cluster. It is the zero time when the schedule never starts.
+func nextCompaction(m *clusterv1alpha1.Ipfs, schedule string) (time.Time, error) {
+	parsed, err := clusterv1alpha1.ParseSchedule(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	last := m.CreationTimestamp.Time
+	if c := m.Status.Compaction; c != nil && c.LastScheduleTime != nil {
+		last = c.LastScheduleTime.Time
+	}
+	return parsed.Next(last, time.UTC), nil
+}
+
+// compactingPeer Returns the name of the pod of the peer out of the cluster
+// for its compaction, if any.
+func compactingPeer(m *clusterv1alpha1.Ipfs) string {
+	c := m.Status.Compaction
+	if c == nil || c.Ordinal == nil || c.StartedAt == nil {
+		return ""
+	}
+	return peerPodName(m, *c.Ordinal)
+}
+
+// reconcileCompaction Compacts the CRDT state of the peers, one at a time,
+// whenever the compaction schedule starts. The peer leaves the cluster
+// through its membership condition, its pod restarts and holds its daemon
+// back while a Job rebuilds its state, then it rejoins before the next peer
+// leaves. A round only starts while the cluster is ready and within the
+// maintenance window, and stops as soon as another peer becomes unavailable.
+// It returns how long to wait before checking on the compactions again.
+func (r *IpfsReconciler) reconcileCompaction(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+	gate *maintenanceGate,
+) (time.Duration, error) {
+	schedule := compactSchedule(resolved)
+	compaction := instance.Status.Compaction
+	if schedule == "" {
+		if compaction != nil && compaction.Ordinal != nil {
+			return 0, r.stopCompaction(ctx, instance, clusterv1alpha1.CompactingReasonAborted,
+				"the compaction schedule was removed")
+		}
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionCompacting)
+		return 0, nil
+	}
+	if compaction == nil || compaction.Ordinal == nil {
+		return r.startCompactionRound(instance, schedule, gate), nil
+	}
+
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return 0, fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	ordinal := *compaction.Ordinal
+	if unready := unreadyPeers(instance, &pods, ordinal); len(unready) > 0 {
+		return 0, r.stopCompaction(ctx, instance, clusterv1alpha1.CompactingReasonAborted,
+			fmt.Sprintf("peers %v became unavailable during the compaction of %s", unready,
+				peerPodName(instance, ordinal)))
+	}
+
+	job := batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: compactionJobName(instance)}, &job)
+	switch {
+	case err != nil && !errors.IsNotFound(err):
+		return 0, fmt.Errorf("cannot get compaction job: %w", err)
+	case errors.IsNotFound(err) && compaction.StartedAt != nil:
+		if time.Since(compaction.StartedAt.Time) < compactionPollInterval {
+			// The cache may not hold the new Job yet.
+			return compactionPollInterval, nil
+		}
+		return 0, r.stopCompaction(ctx, instance, clusterv1alpha1.CompactingReasonFailed,
+			fmt.Sprintf("the compaction job of %s disappeared", peerPodName(instance, ordinal)))
+	case errors.IsNotFound(err):
+		if len(unreadyPeers(instance, &pods, -1)) > 0 {
+			// The previous peer is rejoining the cluster.
+			return compactionPollInterval, nil
+		}
+		return r.startPeerCompaction(ctx, instance, resolved, &pods)
+	case job.Status.Failed > 0:
+		message := jobTerminationMessage(ctx, r.Client, &job)
+		return 0, r.stopCompaction(ctx, instance, clusterv1alpha1.CompactingReasonFailed,
+			fmt.Sprintf("the compaction of %s failed: %s", peerPodName(instance, ordinal), message))
+	case job.Status.Succeeded > 0:
+		return compactionPollInterval, r.finishPeerCompaction(ctx, instance, &job)
+	}
+
+	if compaction.StartedAt == nil {
+		// The status did not record the start of the Job.
+		started := metav1.NewTime(job.CreationTimestamp.Time)
+		compaction.StartedAt = &started
+	}
+	if !compaction.PeerStopped && compactionMarkerPlaced(ctx, r.Client, &job) {
+		// The pod restarts with its daemon held back by the marker, so that
+		// the Job can take over the state.
+		pod := corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		if err = r.Delete(ctx, &pod); client.IgnoreNotFound(err) != nil {
+			return 0, fmt.Errorf("cannot stop peer %s for its compaction: %w", pod.Name, err)
+		}
+		compaction.PeerStopped = true
+	}
+	return compactionPollInterval, nil
+}
+
+// startCompactionRound Queues the first peer for compaction once the
+// schedule started, the cluster is ready and the maintenance window is open.
+// It returns how long to wait before the schedule starts.
+func (r *IpfsReconciler) startCompactionRound(
+	instance *clusterv1alpha1.Ipfs,
+	schedule string,
+	gate *maintenanceGate,
+) time.Duration {
+	next, err := nextCompaction(instance, schedule)
+	if err != nil || next.IsZero() {
+		// The webhook refuses the schedules which cannot be evaluated.
+		return 0
+	}
+	now := time.Now()
+	if next.After(now) {
+		return next.Sub(now)
+	}
+	if !meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionReady) {
+		return compactionPollInterval
+	}
+	if !gate.allow(operationCompaction) {
+		return 0
+	}
+	if instance.Status.Compaction == nil {
+		instance.Status.Compaction = &clusterv1alpha1.CompactionStatus{}
+	}
+	first := int32(0)
+	started := metav1.NewTime(now)
+	instance.Status.Compaction.Ordinal = &first
+	instance.Status.Compaction.LastScheduleTime = &started
+	setCompactingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.CompactingReasonInProgress,
+		fmt.Sprintf("compacting the state of %s", peerPodName(instance, first)))
+	return compactionPollInterval
+}
+
+// startPeerCompaction Takes the queued peer out of the cluster and creates
+// the Job compacting its state, on the node of the peer so that both can
+// mount its volume.
+func (r *IpfsReconciler) startPeerCompaction(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+	pods *corev1.PodList,
+) (time.Duration, error) {
+	compaction := instance.Status.Compaction
+	name := peerPodName(instance, *compaction.Ordinal)
+	nodeName := ""
+	for i := range pods.Items {
+		if pods.Items[i].Name == name {
+			nodeName = pods.Items[i].Spec.NodeName
+		}
+	}
+	job, err := r.compactionJob(resolved, *compaction.Ordinal, nodeName)
+	if err != nil {
+		return 0, err
+	}
+	if err = r.Create(ctx, job); err != nil {
+		return 0, fmt.Errorf("cannot create compaction job: %w", err)
+	}
+	now := metav1.Now()
+	compaction.StartedAt = &now
+	compaction.PeerStopped = false
+	setCompactingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.CompactingReasonInProgress,
+		fmt.Sprintf("compacting the state of %s", name))
+	r.eventf(instance, corev1.EventTypeNormal, "CompactionStarted", "compacting the state of %s", name)
+	return compactionPollInterval, nil
+}
+
+// finishPeerCompaction Records the compaction of the peer, deletes its Job
+// and queues the next peer, or completes the round after the last one.
+func (r *IpfsReconciler) finishPeerCompaction(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	job *batchv1.Job,
+) error {
+	compaction := instance.Status.Compaction
+	ordinal := *compaction.Ordinal
+	name := peerPodName(instance, ordinal)
+	record := &clusterv1alpha1.PeerCompaction{Time: metav1.Now()}
+	var kib int64
+	if _, err := fmt.Sscanf(jobTerminationMessage(ctx, r.Client, job), "stateSize=%d", &kib); err == nil {
+		record.StateSize = resource.NewQuantity(kib*1024, resource.BinarySI)
+	}
+	peerStatus(instance, name).Compaction = record
+	if err := r.deleteCompactionJob(ctx, instance); err != nil {
+		return err
+	}
+	r.eventf(instance, corev1.EventTypeNormal, "Compacted", "compacted the state of %s", name)
+
+	compaction.StartedAt = nil
+	compaction.PeerStopped = false
+	if next := ordinal + 1; next < peerCount(instance) {
+		compaction.Ordinal = &next
+		return nil
+	}
+	compaction.Ordinal = nil
+	compaction.LastCompactionTime = &record.Time
+	setCompactingCondition(instance, metav1.ConditionFalse, clusterv1alpha1.CompactingReasonComplete,
+		fmt.Sprintf("compacted the state of the %d peers", peerCount(instance)))
+	return nil
+}
+
+// stopCompaction Ends the round of compactions in progress, deleting the Job
+// of the peer in progress, which lets the peer start again.
+func (r *IpfsReconciler) stopCompaction(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	reason, message string,
+) error {
+	if err := r.deleteCompactionJob(ctx, instance); err != nil {
+		return err
+	}
+	compaction := instance.Status.Compaction
+	compaction.Ordinal = nil
+	compaction.StartedAt = nil
+	compaction.PeerStopped = false
+	setCompactingCondition(instance, metav1.ConditionFalse, reason, message)
+	ctrllog.FromContext(ctx).Info("stopped compacting the state of the peers", "reason", reason, "message", message)
+	r.eventf(instance, corev1.EventTypeWarning, reason, "%s", message)
+	return nil
+}
+
+// deleteCompactionJob Deletes the compaction Job, if any, along with its pod.
+func (r *IpfsReconciler) deleteCompactionJob(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	job := batchv1.Job{}
+	job.Name = compactionJobName(instance)
+	job.Namespace = instance.Namespace
+	if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+		!errors.IsNotFound(err) {
+		return fmt.Errorf("cannot delete compaction job: %w", err)
+	}
+	return nil
+}
+
+// unreadyPeers Returns the peers, besides the one being compacted, which are
+// not ready. The previous peer is let back in until the compaction deadline
+// once its own compaction completed.
+func unreadyPeers(instance *clusterv1alpha1.Ipfs, pods *corev1.PodList, compacted int32) []string {
+	ready := map[string]bool{}
+	for i := range pods.Items {
+		ready[pods.Items[i].Name] = pods.Items[i].DeletionTimestamp == nil && podIsReady(&pods.Items[i])
+	}
+	var unready []string
+	for ordinal := int32(0); ordinal < peerCount(instance); ordinal++ {
+		name := peerPodName(instance, ordinal)
+		if ordinal == compacted || ready[name] {
+			continue
+		}
+		if ordinal == compacted-1 && rejoining(instance, name) {
+			continue
+		}
+		unready = append(unready, name)
+	}
+	return unready
+}
+
+// rejoining Returns whether the named peer completed its compaction within
+// the compaction deadline.
+func rejoining(instance *clusterv1alpha1.Ipfs, name string) bool {
+	for _, p := range instance.Status.Peers {
+		if p.Name == name {
+			return p.Compaction != nil && time.Since(p.Compaction.Time.Time) < compactionDeadline
+		}
+	}
+	return false
+}
+
+// compactionMarkerPlaced Returns whether the Job placed the marker holding
+// the daemon of the peer back, which its init container does.
+func compactionMarkerPlaced(ctx context.Context, c client.Client, job *batchv1.Job) bool {
+	pods := corev1.PodList{}
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace),
+		client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return false
+	}
+	for i := range pods.Items {
+		for _, cs := range pods.Items[i].Status.InitContainerStatuses {
+			if cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jobTerminationMessage Returns the termination message of the main
+// container of the pod of the Job, if any.
+func jobTerminationMessage(ctx context.Context, c client.Client, job *batchv1.Job) string {
+	pods := corev1.PodList{}
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace),
+		client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return ""
+	}
+	for i := range pods.Items {
+		for _, cs := range pods.Items[i].Status.ContainerStatuses {
+			if cs.State.Terminated != nil {
+				return cs.State.Terminated.Message
+			}
+		}
+	}
+	return ""
+}
+
+// compactionJob Returns the Job compacting the state of the peer with the
+// given ordinal. Its init container places the marker before the pod of the
+// peer is restarted, and it runs on the node of the peer, when known, so
+// that it can mount the volume of the peer while the new pod of the peer
+// waits for it.
+func (r *IpfsReconciler) compactionJob(m *clusterv1alpha1.Ipfs, ordinal int32, nodeName string) (*batchv1.Job, error) {
+	backoffLimit := int32(0)
+	deadline := int64(compactionDeadline.Seconds())
+	mounts := []corev1.VolumeMount{{Name: "cluster-storage", MountPath: ipfsClusterMountPath}}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      compactionJobName(m),
+			Namespace: m.Namespace,
+			Labels:    ipfsLabels(m, componentCompaction),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ipfsLabels(m, componentCompaction),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					NodeName:      nodeName,
+					InitContainers: []corev1.Container{
+						{
+							Name:            "hold-peer",
+							Image:           m.Spec.ClusterImage,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         []string{"touch", compactionMarker},
+							VolumeMounts:    mounts,
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:            "compact",
+							Image:           m.Spec.ClusterImage,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         []string{"sh", "-c", compactState},
+							Env:             []corev1.EnvVar{{Name: "IPFS_CLUSTER_PATH", Value: ipfsClusterMountPath}},
+							VolumeMounts:    mounts,
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "cluster-storage",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: fmt.Sprintf("cluster-storage-ipfs-cluster-%s-%d", m.Name, ordinal),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(m, job, r.Scheme); err != nil {
+		return nil, fmt.Errorf("cannot set controller reference for compaction job: %w", err)
+	}
+	return job, nil
+}
+
+func setCompactingCondition(
+	instance *clusterv1alpha1.Ipfs,
+	status metav1.ConditionStatus,
+	reason, message string,
+) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionCompacting,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs CRDT state compaction", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	compact := func() time.Duration {
+		requeue, err := reconciler.reconcileCompaction(ctx, instance, instance, maintenanceGateOf(instance, time.Now()))
+		Expect(err).NotTo(HaveOccurred())
+		return requeue
+	}
+
+	job := func() *batchv1.Job {
+		j := &batchv1.Job{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: compactionJobName(instance)}, j)).To(Succeed())
+		return j
+	}
+
+	jobExists := func() bool {
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: compactionJobName(instance)}, &batchv1.Job{})
+		return !errors.IsNotFound(err)
+	}
+
+	// jobPod Creates the pod of the compaction Job in the given state.
+	jobPod := func(init, main *corev1.ContainerStateTerminated) {
+		pod := &corev1.Pod{}
+		pod.Name = compactionJobName(instance) + "-abcde"
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{"job-name": compactionJobName(instance)}
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+			Name: "hold-peer", State: corev1.ContainerState{Terminated: init},
+		}}
+		if main != nil {
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name: "compact", State: corev1.ContainerState{Terminated: main},
+			}}
+		}
+		existing := &corev1.Pod{}
+		if fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), existing) == nil {
+			Expect(fakeClient.Delete(ctx, existing)).To(Succeed())
+		}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	peerPod := func(ordinal int32, ready bool) {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		pod.Labels = peerSelector(instance)
+		pod.Spec.NodeName = "node-a"
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+		existing := &corev1.Pod{}
+		if fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), existing) == nil {
+			Expect(fakeClient.Delete(ctx, existing)).To(Succeed())
+		}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	compacting := func() *metav1.Condition {
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionCompacting)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "compact"
+		instance.Namespace = "default"
+		instance.UID = "compact-uid"
+		instance.CreationTimestamp = metav1.NewTime(time.Now().Add(-48 * time.Hour))
+		instance.Spec.Replicas = 2
+		instance.Spec.ClusterImage = ipfsClusterImage
+		instance.Spec.Cluster = &clusterv1alpha1.ClusterConfig{
+			CRDT: &clusterv1alpha1.CRDTConfig{CompactSchedule: "0 3 * * *"},
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:   clusterv1alpha1.ConditionReady,
+			Status: metav1.ConditionTrue,
+			Reason: clusterv1alpha1.ReadyReasonPeersReady,
+		})
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+		peerPod(0, true)
+		peerPod(1, true)
+	})
+
+	It("compacts the peers one at a time and records the state size", func() {
+		Expect(compact()).To(Equal(compactionPollInterval))
+		Expect(*instance.Status.Compaction.Ordinal).To(Equal(int32(0)))
+		Expect(instance.Status.Compaction.LastScheduleTime).NotTo(BeNil())
+		Expect(compacting().Status).To(Equal(metav1.ConditionTrue))
+
+		By("taking the first peer out of the cluster while a Job holds its state")
+		compact()
+		Expect(compactingPeer(instance)).To(Equal(peerPodName(instance, 0)))
+		spec := job().Spec.Template.Spec
+		Expect(spec.NodeName).To(Equal("node-a"))
+		Expect(spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("cluster-storage-ipfs-cluster-compact-0"))
+
+		By("restarting the peer once the marker is placed")
+		jobPod(&corev1.ContainerStateTerminated{ExitCode: 0}, nil)
+		compact()
+		Expect(instance.Status.Compaction.PeerStopped).To(BeTrue())
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: peerPodName(instance, 0)}, &corev1.Pod{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("moving on to the next peer once the Job completed and the peer rejoined")
+		jobPod(&corev1.ContainerStateTerminated{ExitCode: 0},
+			&corev1.ContainerStateTerminated{ExitCode: 0, Message: "stateSize=2048\n"})
+		completed := job()
+		completed.Status.Succeeded = 1
+		Expect(fakeClient.Status().Update(ctx, completed)).To(Succeed())
+		compact()
+		Expect(jobExists()).To(BeFalse())
+		Expect(*instance.Status.Compaction.Ordinal).To(Equal(int32(1)))
+		record := peerStatus(instance, peerPodName(instance, 0)).Compaction
+		Expect(record).NotTo(BeNil())
+		Expect(record.StateSize.Cmp(resource.MustParse("2Mi"))).To(Equal(0))
+		compact()
+		Expect(jobExists()).To(BeFalse())
+		peerPod(0, true)
+		compact()
+		Expect(compactingPeer(instance)).To(Equal(peerPodName(instance, 1)))
+
+		By("completing the round after the last peer")
+		completed = job()
+		completed.Status.Succeeded = 1
+		Expect(fakeClient.Status().Update(ctx, completed)).To(Succeed())
+		compact()
+		Expect(instance.Status.Compaction.Ordinal).To(BeNil())
+		Expect(instance.Status.Compaction.LastCompactionTime).NotTo(BeNil())
+		Expect(compacting().Reason).To(Equal(clusterv1alpha1.CompactingReasonComplete))
+
+		By("waiting for the next start of the schedule")
+		Expect(compact()).To(BeNumerically(">", compactionPollInterval))
+		Expect(instance.Status.Compaction.Ordinal).To(BeNil())
+	})
+
+	It("aborts when another peer becomes unavailable", func() {
+		compact()
+		compact()
+		Expect(jobExists()).To(BeTrue())
+
+		peerPod(1, false)
+		Expect(compact()).To(BeZero())
+		Expect(jobExists()).To(BeFalse())
+		Expect(compactingPeer(instance)).To(BeEmpty())
+		Expect(compacting().Status).To(Equal(metav1.ConditionFalse))
+		Expect(compacting().Reason).To(Equal(clusterv1alpha1.CompactingReasonAborted))
+
+		By("waiting for the next start of the schedule rather than starting over")
+		peerPod(1, true)
+		Expect(compact()).To(BeNumerically(">", compactionPollInterval))
+		Expect(instance.Status.Compaction.Ordinal).To(BeNil())
+	})
+
+	It("batches the pinset updates", func() {
+		size := int32(500)
+		instance.Spec.Cluster.CRDT.BatchingMaxAge = &metav1.Duration{Duration: time.Minute}
+		instance.Spec.Cluster.CRDT.BatchingMaxSize = &size
+		Expect(clusterConfigEnv(instance)).To(ContainElements(
+			corev1.EnvVar{Name: batchingMaxAgeEnv, Value: "1m0s"},
+			corev1.EnvVar{Name: batchingMaxSizeEnv, Value: "500"},
+		))
+	})
+})
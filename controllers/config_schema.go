@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/configschema"
+)
+
+// shellPlaceholder Stands for the expansions of the shell in the values of
+// the scripts, which are only known once the pod runs.
+const shellPlaceholder = "x"
+
+// kuboRelease Returns the kubo release the image runs, or the latest release
+// when its version cannot be told from its tag.
+func kuboRelease(image string) configschema.Release {
+	version, ok := clusterv1alpha1.ImageMinorVersion(image)
+	if !ok {
+		return configschema.Release{}
+	}
+	return configschema.Release{Major: version.Major, Minor: version.Minor}
+}
+
+// kuboConfigCommand Is an ipfs config command of a script. Its value is nil
+// for the commands reading the key, and for the values the shell expands
+// into something other than valid JSON.
+type kuboConfigCommand struct {
+	key   string
+	value []byte
+	read  bool
+}
+
+// kuboConfigCommands Returns the ipfs config commands of the script, in the
+// order the shell runs them.
+func kuboConfigCommands(script string) []kuboConfigCommand {
+	var commands []kuboConfigCommand
+	for _, line := range expandFunctions(script) {
+		i := strings.Index(line, "ipfs config ")
+		if i < 0 || (i > 0 && line[i-1] != ' ' && line[i-1] != '\t') {
+			continue
+		}
+		words := shellWords(line[i+len("ipfs config "):])
+		asJSON := len(words) > 0 && words[0].text == "--json"
+		if asJSON {
+			words = words[1:]
+		}
+		if len(words) == 0 || words[0].text == "" || words[0].text[0] < 'A' || words[0].text[0] > 'Z' {
+			// The subcommands, such as show and profile, are in lower case.
+			continue
+		}
+		command := kuboConfigCommand{key: words[0].text, read: len(words) == 1}
+		if len(words) > 1 {
+			value := words[1]
+			switch {
+			case asJSON && (!value.expanded || json.Valid([]byte(value.text))):
+				command.value = []byte(value.text)
+			case !asJSON && !value.expanded:
+				command.value, _ = json.Marshal(value.text)
+			}
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// expandFunctions Returns the lines of the script in the order the shell
+// runs them, with the calls of the functions the script defines replaced by
+// their body. The functions are those written as name() { on a line, up to
+// the line closing them.
+func expandFunctions(script string) []string {
+	functions := map[string][]string{}
+	var lines []string
+	function := ""
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case function != "" && trimmed == "}":
+			function = ""
+		case function != "":
+			functions[function] = append(functions[function], trimmed)
+		case strings.HasSuffix(trimmed, "() {"):
+			function = strings.TrimSuffix(trimmed, "() {")
+			functions[function] = nil
+		default:
+			if body, ok := functions[trimmed]; ok {
+				lines = append(lines, body...)
+				continue
+			}
+			lines = append(lines, trimmed)
+		}
+	}
+	return lines
+}
+
+// shellWord Is a word of a command, with the expansions of the shell
+// replaced by shellPlaceholder.
+type shellWord struct {
+	text     string
+	expanded bool
+}
+
+// shellWords Splits the arguments of a command into words, removing the
+// quotes, up to the first redirection or control operator.
+func shellWords(s string) []shellWord {
+	var words []shellWord
+	var current strings.Builder
+	word, expanded := false, false
+	flush := func() {
+		if word {
+			words = append(words, shellWord{text: current.String(), expanded: expanded})
+		}
+		current.Reset()
+		word, expanded = false, false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			flush()
+			continue
+		case strings.IndexByte("<>|;&", c) >= 0:
+			flush()
+			return words
+		case c == '\'':
+			i = readSingleQuoted(s, i, &current)
+		case c == '"':
+			var quotedExpansion bool
+			i, quotedExpansion = readDoubleQuoted(s, i, &current)
+			expanded = expanded || quotedExpansion
+		case c == '$':
+			i = skipExpansion(s, i) - 1
+			current.WriteString(shellPlaceholder)
+			expanded = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			current.WriteByte(s[i])
+		default:
+			current.WriteByte(c)
+		}
+		word = true
+	}
+	flush()
+	return words
+}
+
+// readSingleQuoted Writes the text quoted by the single quote at i to
+// current, and returns the index of the closing quote.
+func readSingleQuoted(s string, i int, current *strings.Builder) int {
+	end := strings.IndexByte(s[i+1:], '\'')
+	if end < 0 {
+		end = len(s) - i - 1
+	}
+	current.WriteString(s[i+1 : i+1+end])
+	return i + end + 1
+}
+
+// readDoubleQuoted Writes the text quoted by the double quote at i to
+// current, with its expansions replaced by shellPlaceholder, and returns the
+// index of the closing quote and whether the text holds an expansion.
+func readDoubleQuoted(s string, i int, current *strings.Builder) (int, bool) {
+	expanded := false
+	for i++; i < len(s) && s[i] != '"'; i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			current.WriteByte(s[i])
+		case s[i] == '$':
+			i = skipExpansion(s, i) - 1
+			current.WriteString(shellPlaceholder)
+			expanded = true
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	return i, expanded
+}
+
+// skipExpansion Returns the index following the expansion starting with
+// the dollar sign at i.
+func skipExpansion(s string, i int) int {
+	i++
+	if i < len(s) && (s[i] == '{' || s[i] == '(') {
+		return closingBracket(s, i)
+	}
+	for i < len(s) && (s[i] == '_' || ('a' <= s[i] && s[i] <= 'z') || ('A' <= s[i] && s[i] <= 'Z') ||
+		('0' <= s[i] && s[i] <= '9')) {
+		i++
+	}
+	return i
+}
+
+// closingBracket Returns the index following the bracket closing the one at
+// i, skipping the quoted text within.
+func closingBracket(s string, i int) int {
+	open := s[i]
+	closing := map[byte]byte{'{': '}', '(': ')'}[open]
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			if end := strings.IndexByte(s[i+1:], '\''); end >= 0 {
+				i += end + 1
+			}
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case open:
+			depth++
+		case closing:
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// checkKuboScript Checks the settings the script writes into the kubo
+// config against the schema of the release, along with the ipfs-cluster
+// settings it exports.
+func checkKuboScript(script string, release configschema.Release) (string, error) {
+	config := configschema.NewKuboConfig(release)
+	for _, command := range kuboConfigCommands(script) {
+		var err error
+		if command.read {
+			err = config.CheckKey(command.key)
+		} else {
+			err = config.Set(command.key, command.value)
+		}
+		if err != nil {
+			return clusterv1alpha1.ConfigInvalidReasonKubo, err
+		}
+	}
+	if err := config.Check(); err != nil {
+		return clusterv1alpha1.ConfigInvalidReasonKubo, err
+	}
+	for _, line := range expandFunctions(script) {
+		if !strings.HasPrefix(line, "export ") {
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !configschema.IsClusterEnv(name) {
+			continue
+		}
+		var known *string
+		if words := shellWords(value); len(words) == 1 && !words[0].expanded {
+			known = &words[0].text
+		}
+		if err := configschema.CheckClusterEnv(name, known); err != nil {
+			return clusterv1alpha1.ConfigInvalidReasonCluster, err
+		}
+	}
+	return "", nil
+}
+
+// checkClusterEnv Checks the environment of the containers overriding the
+// service.json of ipfs-cluster against its schema. The values read from
+// Secrets and ConfigMaps are only known once the pod runs.
+func checkClusterEnv(spec *corev1.PodSpec) error {
+	for _, container := range spec.Containers {
+		for i := range container.Env {
+			env := &container.Env[i]
+			if !configschema.IsClusterEnv(env.Name) {
+				continue
+			}
+			var value *string
+			if env.ValueFrom == nil {
+				value = &env.Value
+			}
+			if err := configschema.CheckClusterEnv(env.Name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configScripts Returns the scripts of the ConfigMap configuring the
+// daemons, by their key. The scripts of the provider and gateway nodes are
+// rendered by the mutate functions of their ConfigMaps, and are rendered
+// again here.
+func configScripts(m *clusterv1alpha1.Ipfs, cm *corev1.ConfigMap) (map[string]string, error) {
+	switch cm.Name {
+	case providersName(m):
+		script, err := renderProviderScript(m)
+		return map[string]string{providerScriptKey: script}, err
+	case gatewayNodesName(m):
+		script, err := renderGatewayNodeScript(m)
+		return map[string]string{gatewayNodeScriptKey: script}, err
+	}
+	scripts := map[string]string{}
+	for key, script := range cm.Data {
+		if strings.HasSuffix(key, ".sh") {
+			scripts[key] = script
+		}
+	}
+	return scripts, nil
+}
+
+// guardConfig Checks the settings the objects of the phases write into the
+// config of kubo and the service.json of ipfs-cluster against the schema of
+// the releases of the spec, before they are applied. The objects holding an
+// invalid setting are not applied, and the ConfigInvalid condition names
+// the first such setting.
+func guardConfig(instance *clusterv1alpha1.Ipfs, resolved *clusterv1alpha1.Ipfs, phases []reconcilePhase) {
+	release := kuboRelease(resolved.Spec.IpfsImage)
+	var found *diagnosis
+	for _, phase := range phases {
+		objects := make([]client.Object, 0, len(phase.objects))
+		for obj := range phase.objects {
+			objects = append(objects, obj)
+		}
+		sort.Slice(objects, func(i, j int) bool { return objects[i].GetName() < objects[j].GetName() })
+		for _, obj := range objects {
+			d := checkObjectConfig(obj, resolved, release)
+			if d == nil {
+				continue
+			}
+			message := d.message
+			phase.objects[obj] = func() error { return fmt.Errorf("%s", message) }
+			if found == nil {
+				found = d
+			}
+		}
+	}
+	setDiagnosisCondition(instance, clusterv1alpha1.ConditionConfigInvalid, found)
+}
+
+// checkObjectConfig Returns the invalid setting the object writes into the
+// config of the daemons, if any.
+func checkObjectConfig(obj client.Object, m *clusterv1alpha1.Ipfs, release configschema.Release) *diagnosis {
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		scripts, err := configScripts(m, o)
+		if err != nil {
+			return nil
+		}
+		keys := make([]string, 0, len(scripts))
+		for key := range scripts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if reason, err := checkKuboScript(scripts[key], release); err != nil {
+				return &diagnosis{reason: reason, message: fmt.Sprintf("%s of the ConfigMap %s writes an invalid setting: %s",
+					key, o.Name, err.Error())}
+			}
+		}
+	case *appsv1.StatefulSet:
+		if err := checkClusterEnv(&o.Spec.Template.Spec); err != nil {
+			return &diagnosis{reason: clusterv1alpha1.ConfigInvalidReasonCluster,
+				message: fmt.Sprintf("the StatefulSet %s sets an invalid setting: %s", o.Name, err.Error())}
+		}
+	}
+	return nil
+}
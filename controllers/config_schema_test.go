@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs config schema", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	// customRouting Queries a public router and a router behind credentials.
+	customRouting := func(m *clusterv1alpha1.Ipfs) {
+		m.Spec.Routing = &clusterv1alpha1.RoutingConfig{
+			Type: clusterv1alpha1.RoutingTypeCustom,
+			Routers: []clusterv1alpha1.DelegatedRouter{
+				{Name: "cid-contact", Endpoint: "https://cid.contact"},
+				{
+					Name:     "private",
+					Endpoint: "https://routing.example.com",
+					AuthSecretRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "router-auth"},
+						Key:                  "token",
+					},
+				},
+			},
+		}
+	}
+
+	// scripts Returns the ConfigMap holding the scripts of the peers and its
+	// mutate function.
+	scripts := func() (*corev1.ConfigMap, controllerutil.MutateFn) {
+		cm := &corev1.ConfigMap{}
+		mut, _ := reconciler.configMapScripts(ctx, instance, cm)
+		Expect(mut).NotTo(BeNil())
+		return cm, mut
+	}
+
+	// renderCommands Renders the ipfs config commands of the script, one per
+	// line, for comparison with the golden files.
+	renderCommands := func(script string) string {
+		var rendered strings.Builder
+		for _, command := range kuboConfigCommands(script) {
+			switch {
+			case command.read:
+				fmt.Fprintf(&rendered, "read %s\n", command.key)
+			case command.value == nil:
+				fmt.Fprintf(&rendered, "set %s (expanded at start)\n", command.key)
+			default:
+				fmt.Fprintf(&rendered, "set %s %s\n", command.key, command.value)
+			}
+		}
+		return rendered.String()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "schema"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	table.DescribeTable("renders the settings the kubo release reads",
+		func(golden, image string, configure func(*clusterv1alpha1.Ipfs)) {
+			instance.Spec.IpfsImage = image
+			configure(instance)
+			cm, _ := scripts()
+			script := cm.Data["configure-ipfs.sh"]
+			reason, err := checkKuboScript(script, kuboRelease(image))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reason).To(BeEmpty())
+			expectGolden(golden, renderCommands(script))
+		},
+		table.Entry("the default spec", "kubo-config-default", "ipfs/go-ipfs:v0.12.2",
+			func(m *clusterv1alpha1.Ipfs) {}),
+		table.Entry("delegated routers", "kubo-config-routing", "ipfs/kubo:v0.18.1", customRouting),
+		table.Entry("tuned peers", "kubo-config-tuned", "ipfs/kubo:v0.35.0", func(m *clusterv1alpha1.Ipfs) {
+			workers := int32(16)
+			connections := int32(800)
+			m.Spec.Reprovider = &clusterv1alpha1.ReproviderConfig{Interval: &metav1.Duration{Duration: 12 * time.Hour}}
+			m.Spec.Provide = &clusterv1alpha1.ProvideConfig{Workers: &workers}
+			m.Spec.DNS = &clusterv1alpha1.KuboDNSConfig{
+				Resolvers: map[string]string{".": "https://doh.example/dns-query"},
+			}
+			m.Spec.Swarm = &clusterv1alpha1.SwarmConfig{
+				Transports: &clusterv1alpha1.SwarmTransports{
+					WebSocket: &clusterv1alpha1.WebSocketTransport{Enabled: true},
+				},
+				ResourceManager: &clusterv1alpha1.ResourceManagerConfig{MaxConnections: &connections},
+			}
+			m.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{
+				BadgerGCInterval: &metav1.Duration{Duration: time.Hour},
+			}
+		}),
+	)
+
+	It("splits the commands the way the shell does", func() {
+		commands := kuboConfigCommands("f() {\n\tipfs config --json Swarm.ConnMgr.LowWater 20\n}\n" +
+			"[ -f /data/ipfs/.connmgr ] || ipfs config Swarm.ConnMgr > /data/ipfs/.connmgr\n" +
+			"ipfs config --json Bootstrap \"$(tr -d ' \\n' < /data/ipfs/.bootstrap | sed 's|^\\[|[\"/dns4/a\",|')\"\n" +
+			"ipfs config --json Addresses.AppendAnnounce " +
+			"'[\"/dns4/'\"$(cat /proc/sys/kernel/hostname)\"'.example.com/tcp/443/wss\"]'\n" +
+			"f\nipfs config show\nipfs config Reprovider.Strategy roots\n")
+		Expect(commands).To(Equal([]kuboConfigCommand{
+			{key: "Swarm.ConnMgr", read: true},
+			{key: "Bootstrap"},
+			{key: "Addresses.AppendAnnounce", value: []byte(`["/dns4/x.example.com/tcp/443/wss"]`)},
+			{key: "Swarm.ConnMgr.LowWater", value: []byte("20")},
+			{key: "Reprovider.Strategy", value: []byte(`"roots"`)},
+		}))
+	})
+
+	It("holds the scripts back when the kubo release does not read a setting", func() {
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.17.0"
+		customRouting(instance)
+		cm, mut := scripts()
+		phases := []reconcilePhase{{
+			reason:  clusterv1alpha1.ReconciledReasonConfigNotReady,
+			objects: map[client.Object]controllerutil.MutateFn{cm: mut},
+		}}
+		guardConfig(instance, instance, phases)
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionConfigInvalid)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.ConfigInvalidReasonKubo))
+		Expect(cond.Message).To(Equal("configure-ipfs.sh of the ConfigMap ipfs-cluster-scripts-schema writes an " +
+			"invalid setting: Routing.Methods: unknown to kubo 0.17, read since kubo 0.18"))
+		Expect(phases[0].objects[cm]()).To(MatchError(cond.Message))
+
+		By("clearing the condition once the release reads it")
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.18.1"
+		cm, mut = scripts()
+		phases[0].objects = map[client.Object]controllerutil.MutateFn{cm: mut}
+		guardConfig(instance, instance, phases)
+		Expect(meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionConfigInvalid)).To(BeNil())
+	})
+
+	It("checks the environment overriding the service.json of ipfs-cluster", func() {
+		pins := int32(32)
+		instance.Spec.Cluster = &clusterv1alpha1.ClusterConfig{
+			MonitorPingInterval: &metav1.Duration{Duration: 5 * time.Second},
+			PinTracker:          &clusterv1alpha1.PinTrackerConfig{ConcurrentPins: &pins},
+		}
+		spec := corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "ipfs-cluster",
+			Env: append(clusterConfigEnv(instance),
+				corev1.EnvVar{Name: apiListenEnv, Value: "/ip4/0.0.0.0/tcp/9094"},
+				corev1.EnvVar{Name: "CLUSTER_SECRET", ValueFrom: &corev1.EnvVarSource{}},
+			),
+		}}}
+		Expect(checkClusterEnv(&spec)).To(Succeed())
+
+		spec.Containers[0].Env = append(spec.Containers[0].Env, corev1.EnvVar{Name: "CLUSTER_STATELESS_CONCURRENTPINS",
+			Value: "0"})
+		Expect(checkClusterEnv(&spec)).To(MatchError("pin_tracker.stateless.concurrent_pins: " +
+			"set by CLUSTER_STATELESS_CONCURRENTPINS: 0 is below 1"))
+		spec.Containers[0].Env[len(spec.Containers[0].Env)-1] = corev1.EnvVar{
+			Name: "CLUSTER_MONITOR_PING_INTERVAL", Value: "3m"}
+		Expect(checkClusterEnv(&spec)).To(MatchError("CLUSTER_MONITOR_PING_INTERVAL: " +
+			"no field of the ipfs-cluster configuration is read from it"))
+	})
+})
@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+// cordonPollInterval Is how often a cordon in progress is checked.
+const cordonPollInterval = 15 * time.Second
+
+// cordonedOrdinal Returns the ordinal of the peer the cordon-peer annotation
+// names, and whether it names one which can be cordoned. The first peer
+// cannot, as the others bootstrap from it.
+func cordonedOrdinal(m *clusterv1alpha1.Ipfs) (int32, bool) {
+	value, ok := m.Annotations[clusterv1alpha1.AnnotationCordonPeer]
+	if !ok {
+		return -1, false
+	}
+	ordinal, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || ordinal < 1 || ordinal >= int64(peerCount(m)) {
+		return -1, false
+	}
+	return int32(ordinal), true
+}
+
+// cordonedPeers Returns the names of the peers whose pods are held off.
+func cordonedPeers(m *clusterv1alpha1.Ipfs) []string {
+	var names []string
+	for _, p := range m.Status.Peers {
+		if p.Cordon == clusterv1alpha1.CordonCordoned && peerOrdinal(p.Name) < peerCount(m) {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// runningPeers Returns the number of peers expected to run: every replica
+// but the cordoned peers, whose pods are missing on purpose.
+func runningPeers(m *clusterv1alpha1.Ipfs) int32 {
+	return peerCount(m) - int32(len(cordonedPeers(m)))
+}
+
+// reconcileCordon Takes the peer named by the cordon-peer annotation out of
+// the cluster without scaling the others down. The peer is removed from the
+// peerset so that ipfs-cluster re-allocates its pins to the other peers, and
+// its pod is deleted once every pin it held is pinned elsewhere. The peer pod
+// webhook refuses the pods the StatefulSet creates for it meanwhile, while
+// its volumes and identity are kept. The cordon is refused when the operator
+// does not serve its webhooks, as the StatefulSet would recreate the pod
+// right away. Once the annotation is removed, or names another peer, the
+// peer comes back and its pins are recovered so that it catches up. It
+// returns how long to wait before checking on the cordons again.
+func (r *IpfsReconciler) reconcileCordon(ctx context.Context, instance *clusterv1alpha1.Ipfs) (time.Duration, error) {
+	value, requested := instance.Annotations[clusterv1alpha1.AnnotationCordonPeer]
+	target, valid := cordonedOrdinal(instance)
+	refused := valid && !r.Webhooks
+	valid = valid && !refused
+
+	var requeue time.Duration
+	var messages []string
+	reason := clusterv1alpha1.PeerCordonedReasonRecovering
+	for i := range instance.Status.Peers {
+		peer := &instance.Status.Peers[i]
+		if peer.Cordon == "" || (valid && peerOrdinal(peer.Name) == target) {
+			continue
+		}
+		message, err := r.uncordonPeer(ctx, instance, peer)
+		if err != nil {
+			return 0, err
+		}
+		if peer.Cordon != "" {
+			requeue = cordonPollInterval
+			messages = append(messages, message)
+		}
+	}
+	if valid {
+		peer := peerStatus(instance, peerPodName(instance, target))
+		message, err := r.cordonPeer(ctx, instance, peer)
+		if err != nil {
+			return 0, err
+		}
+		if peer.Cordon != clusterv1alpha1.CordonCordoned {
+			requeue = cordonPollInterval
+		}
+		reason = clusterv1alpha1.PeerCordonedReasonDraining
+		if peer.Cordon == clusterv1alpha1.CordonCordoned {
+			reason = clusterv1alpha1.PeerCordonedReasonCordoned
+		}
+		messages = append([]string{message}, messages...)
+	}
+
+	switch {
+	case refused:
+		r.refuseCordon(instance, peerPodName(instance, target))
+	case requested && !valid:
+		setPeerCordonedCondition(instance, metav1.ConditionFalse, clusterv1alpha1.PeerCordonedReasonInvalidOrdinal,
+			fmt.Sprintf("%q is not the ordinal of a peer of this cluster other than the first, "+
+				"which the others bootstrap from", value))
+	case len(messages) > 0:
+		setPeerCordonedCondition(instance, metav1.ConditionTrue, reason, strings.Join(messages, "; "))
+	default:
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionPeerCordoned)
+	}
+	return requeue, nil
+}
+
+// cordonPeer Moves the cordon of the peer one step further, and returns a
+// message describing where it stands.
+func (r *IpfsReconciler) cordonPeer(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	peer *clusterv1alpha1.PeerStatus,
+) (string, error) {
+	log := ctrllog.FromContext(ctx)
+	if peer.Cordon == clusterv1alpha1.CordonCordoned {
+		return r.holdOffPeer(ctx, instance, peer)
+	}
+	if peer.ID == "" {
+		return fmt.Sprintf("waiting for the identity of %s to be known", peer.Name), nil
+	}
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	if peer.Cordon != clusterv1alpha1.CordonDraining {
+		if err = api.RemovePeer(ctx, peer.ID); err != nil && !clusterapi.IsNotFound(err) {
+			return "", externalAPI(fmt.Errorf("cannot re-allocate the pins of %s: %w", peer.Name, err))
+		}
+		peer.Cordon = clusterv1alpha1.CordonDraining
+		r.eventf(instance, corev1.EventTypeNormal, "PeerDraining",
+			"re-allocating the pins of %s before cordoning it", peer.Name)
+		return fmt.Sprintf("re-allocating the pins of %s", peer.Name), nil
+	}
+
+	infos, err := api.StatusAll(ctx)
+	if err != nil {
+		log.Info("cannot get the status of the pinset, postponing the cordon", "error", err.Error())
+		return fmt.Sprintf("waiting for the cluster API to check the pins of %s: %v", peer.Name, err), nil
+	}
+	allocated, unreplicated := drainProgress(infos, peer.ID)
+	if allocated > 0 {
+		// Pins added since the peer was removed may have been allocated to
+		// it again, as it keeps running until it is drained.
+		if err = api.RemovePeer(ctx, peer.ID); err != nil && !clusterapi.IsNotFound(err) {
+			return "", externalAPI(fmt.Errorf("cannot re-allocate the pins of %s: %w", peer.Name, err))
+		}
+		return fmt.Sprintf("%d pins are still allocated to %s", allocated, peer.Name), nil
+	}
+	if unreplicated > 0 {
+		return fmt.Sprintf("%d pins of %s are not pinned on another peer yet", unreplicated, peer.Name), nil
+	}
+	peer.Cordon = clusterv1alpha1.CordonCordoned
+	r.eventf(instance, corev1.EventTypeNormal, "PeerCordoned",
+		"the pins of %s are replicated on the other peers, stopping it", peer.Name)
+	return r.holdOffPeer(ctx, instance, peer)
+}
+
+// refuseCordon Sets the PeerCordoned condition refusing the cordon of the
+// named peer, and warns about it once.
+func (r *IpfsReconciler) refuseCordon(instance *clusterv1alpha1.Ipfs, name string) {
+	current := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionPeerCordoned)
+	message := fmt.Sprintf("the cordon of %s is refused: only the webhooks of the operator keep the "+
+		"StatefulSet from recreating its pod, and they are not served; deploy the operator with "+
+		"ENABLE_WEBHOOKS=true and its webhooks", name)
+	if current == nil || current.Message != message {
+		r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.PeerCordonedReasonWebhooksDisabled, "%s", message)
+	}
+	setPeerCordonedCondition(instance, metav1.ConditionFalse, clusterv1alpha1.PeerCordonedReasonWebhooksDisabled,
+		message)
+}
+
+// holdOffPeer Deletes the pod of the cordoned peer whenever it exists. The
+// peer pod webhook refuses the pods the StatefulSet creates for the peer; a
+// pod it let through, as it ignores its own failures, is deleted again.
+func (r *IpfsReconciler) holdOffPeer(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	peer *clusterv1alpha1.PeerStatus,
+) (string, error) {
+	pod := corev1.Pod{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: peer.Name}, &pod)
+	switch {
+	case errors.IsNotFound(err):
+		return fmt.Sprintf("%s is cordoned", peer.Name), nil
+	case err != nil:
+		return "", fmt.Errorf("cannot get pod %s: %w", peer.Name, err)
+	case pod.DeletionTimestamp != nil:
+		return fmt.Sprintf("%s is cordoned, its pod is stopping", peer.Name), nil
+	}
+	if err = r.Delete(ctx, &pod); client.IgnoreNotFound(err) != nil {
+		return "", fmt.Errorf("cannot stop cordoned peer %s: %w", peer.Name, err)
+	}
+	return fmt.Sprintf("%s is cordoned, its pod is stopping", peer.Name), nil
+}
+
+// uncordonPeer Brings back a peer which is no longer cordoned: once its pod
+// is ready again, the pins it fell behind on are recovered. It returns a
+// message describing where it stands.
+func (r *IpfsReconciler) uncordonPeer(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	peer *clusterv1alpha1.PeerStatus,
+) (string, error) {
+	log := ctrllog.FromContext(ctx)
+	if peer.Cordon != clusterv1alpha1.CordonRecovering {
+		peer.Cordon = clusterv1alpha1.CordonRecovering
+		r.eventf(instance, corev1.EventTypeNormal, "PeerUncordoned", "%s is coming back", peer.Name)
+	}
+	if peerOrdinal(peer.Name) >= peerCount(instance) {
+		peer.Cordon = ""
+		return "", nil
+	}
+	pod := corev1.Pod{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: peer.Name}, &pod)
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("cannot get pod %s: %w", peer.Name, err)
+	}
+	if err != nil || pod.DeletionTimestamp != nil || !podConditionIsTrue(&pod, corev1.PodReady) {
+		return fmt.Sprintf("waiting for %s to be ready again", peer.Name), nil
+	}
+
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	infos, err := api.StatusAll(ctx)
+	if err != nil {
+		log.Info("cannot get the status of the pinset, postponing the recovery", "error", err.Error())
+		return fmt.Sprintf("waiting for the cluster API to recover the pins of %s: %v", peer.Name, err), nil
+	}
+	var recovered int
+	for i := range infos {
+		if !containsString(divergedPeers(&infos[i]), peer.Name) {
+			continue
+		}
+		if _, err = api.Recover(ctx, string(infos[i].Cid)); err != nil {
+			log.Info("cannot recover a pin of an uncordoned peer", "cid", infos[i].Cid, "error", err.Error())
+			return fmt.Sprintf("recovering the pins of %s: %v", peer.Name, err), nil
+		}
+		recovered++
+	}
+	peer.Cordon = ""
+	r.eventf(instance, corev1.EventTypeNormal, "PeerRecovered",
+		"%s is back, %d of its pins were recovered", peer.Name, recovered)
+	return "", nil
+}
+
+// drainProgress Returns how many pins are still allocated to the peer with
+// the given ID, and how many of those it pins or is allocated are not pinned
+// on any other peer yet. A pin without allocations is allocated to every
+// peer, so that it only waits for another peer to pin it.
+func drainProgress(infos []clusterapi.GlobalPinInfo, id string) (allocated, unreplicated int) {
+	for i := range infos {
+		info := &infos[i]
+		held := info.PeerMap[id].Status == clusterapi.TrackerStatusPinned
+		if containsString(info.Allocations, id) {
+			allocated++
+			held = true
+		}
+		if !held {
+			continue
+		}
+		replicated := false
+		for other, state := range info.PeerMap {
+			replicated = replicated || (other != id && state.Status == clusterapi.TrackerStatusPinned)
+		}
+		if !replicated {
+			unreplicated++
+		}
+	}
+	return allocated, unreplicated
+}
+
+// containsString Returns whether the list holds the value.
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func setPeerCordonedCondition(
+	instance *clusterv1alpha1.Ipfs,
+	status metav1.ConditionStatus,
+	reason, message string,
+) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionPeerCordoned,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
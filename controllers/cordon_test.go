@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+)
+
+var _ = Describe("Ipfs peer cordon", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+		peers      []clusterapi.Peer
+	)
+
+	cordon := func() time.Duration {
+		requeue, err := reconciler.reconcileCordon(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		return requeue
+	}
+
+	peerPod := func(ordinal int32) *corev1.Pod {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		pod.Labels = ipfsLabels(instance, componentPeer)
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		return pod
+	}
+
+	podExists := func(ordinal int32) bool {
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: peerPodName(instance, ordinal)}, &corev1.Pod{})
+		return !errors.IsNotFound(err)
+	}
+
+	admitted := func(ordinal int32) bool {
+		raw, err := json.Marshal(peerPod(ordinal))
+		Expect(err).NotTo(HaveOccurred())
+		return PeerPodOverrides{Reader: fakeClient}.Handle(ctx, admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: instance.Namespace,
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		}).Allowed
+	}
+
+	condition := func() *metav1.Condition {
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionPeerCordoned)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "cordon"
+		instance.Namespace = "default"
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationCordonPeer: "1"}
+		instance.Spec.Replicas = 3
+		peers = nil
+		objs := []client.Object{instance}
+		for i, id := range []string{"12D3KooWA", "12D3KooWB", "12D3KooWC"} {
+			peers = append(peers, clusterapi.Peer{ID: id, Peername: peerPodName(instance, int32(i))})
+			instance.Status.Peers = append(instance.Status.Peers,
+				clusterv1alpha1.PeerStatus{Name: peerPodName(instance, int32(i)), ID: id})
+			objs = append(objs, peerPod(int32(i)))
+		}
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{apiCredentialsEnv: []byte("admin:4d1c8e0b7f2a9365")}
+		objs = append(objs, credentials)
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+
+		cluster = clusterfake.NewCluster(peers...)
+		reconciler = &IpfsReconciler{
+			Client:     fakeClient,
+			Scheme:     scheme,
+			Recorder:   record.NewFakeRecorder(10),
+			ClusterAPI: []clusterapi.Option{clusterapi.WithBaseURL(cluster.URL())},
+			Webhooks:   true,
+		}
+		api, err := reconciler.clusterAPI(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = api.Pin(ctx, "bafycordon", clusterapi.PinOptions{ReplicationMin: 2, ReplicationMax: 3})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("holds the peer off once its pins are replicated elsewhere, then brings it back", func() {
+		Expect(cordon()).To(Equal(cordonPollInterval))
+		Expect(peerStatus(instance, "ipfs-cluster-cordon-1").Cordon).To(Equal(clusterv1alpha1.CordonDraining))
+		Expect(condition().Reason).To(Equal(clusterv1alpha1.PeerCordonedReasonDraining))
+		Expect(cluster.Calls(clusterfake.RouteRemovePeer)).To(Equal(1))
+		Expect(podExists(1)).To(BeTrue())
+
+		By("deleting the pod once no pin is allocated to the peer")
+		Expect(cordon()).To(BeZero())
+		Expect(peerStatus(instance, "ipfs-cluster-cordon-1").Cordon).To(Equal(clusterv1alpha1.CordonCordoned))
+		Expect(condition().Reason).To(Equal(clusterv1alpha1.PeerCordonedReasonCordoned))
+		Expect(podExists(1)).To(BeFalse())
+		Expect(podExists(2)).To(BeTrue())
+		Expect(admitted(1)).To(BeFalse())
+		Expect(admitted(2)).To(BeTrue())
+
+		By("counting the cluster ready without the cordoned peer")
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-cordon"
+		sts.Namespace = instance.Namespace
+		Expect(fakeClient.Create(ctx, sts)).To(Succeed())
+		sts.Status = appsv1.StatefulSetStatus{Replicas: 2, ReadyReplicas: 2, UpdatedReplicas: 2}
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+		Expect(reconciler.syncReadiness(ctx, instance)).To(Succeed())
+		ready := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionTrue))
+		Expect(ready.Message).To(Equal("2/2 peers ready, 2/2 updated, ipfs-cluster-cordon-1 cordoned"))
+
+		By("recovering the pins of the peer once it is back")
+		stored := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), stored)).To(Succeed())
+		patch := client.MergeFrom(stored.DeepCopy())
+		delete(stored.Annotations, clusterv1alpha1.AnnotationCordonPeer)
+		Expect(fakeClient.Patch(ctx, stored, patch)).To(Succeed())
+		delete(instance.Annotations, clusterv1alpha1.AnnotationCordonPeer)
+		Expect(admitted(1)).To(BeTrue())
+		Expect(cordon()).To(Equal(cordonPollInterval))
+		Expect(peerStatus(instance, "ipfs-cluster-cordon-1").Cordon).To(Equal(clusterv1alpha1.CordonRecovering))
+		Expect(condition().Reason).To(Equal(clusterv1alpha1.PeerCordonedReasonRecovering))
+		Expect(condition().Message).To(Equal("waiting for ipfs-cluster-cordon-1 to be ready again"))
+
+		Expect(fakeClient.Create(ctx, peerPod(1))).To(Succeed())
+		cluster.AddPeer(peers[1])
+		cluster.Diverge("bafycordon", peers[1].ID, clusterapi.TrackerStatusPinError, 1)
+		Expect(cordon()).To(BeZero())
+		Expect(peerStatus(instance, "ipfs-cluster-cordon-1").Cordon).To(BeEmpty())
+		Expect(condition()).To(BeNil())
+		Expect(cluster.Calls(clusterfake.RouteRecover)).To(Equal(1))
+	})
+
+	It("refuses to cordon the first peer", func() {
+		instance.Annotations[clusterv1alpha1.AnnotationCordonPeer] = "0"
+		Expect(cordon()).To(BeZero())
+		Expect(condition().Reason).To(Equal(clusterv1alpha1.PeerCordonedReasonInvalidOrdinal))
+		Expect(cluster.Calls(clusterfake.RouteRemovePeer)).To(BeZero())
+	})
+
+	It("refuses the cordon when the webhooks are not served", func() {
+		recorder := record.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		reconciler.Webhooks = false
+		Expect(cordon()).To(BeZero())
+		Expect(condition().Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition().Reason).To(Equal(clusterv1alpha1.PeerCordonedReasonWebhooksDisabled))
+		Expect(condition().Message).To(ContainSubstring("ipfs-cluster-cordon-1"))
+		Expect(peerStatus(instance, "ipfs-cluster-cordon-1").Cordon).To(BeEmpty())
+		Expect(cluster.Calls(clusterfake.RouteRemovePeer)).To(BeZero())
+		Expect(podExists(1)).To(BeTrue())
+
+		By("warning about it once")
+		Expect(cordon()).To(BeZero())
+		Expect(recorder.Events).To(HaveLen(1))
+	})
+
+	It("waits for the pins the peer holds to be pinned on another peer", func() {
+		infos := []clusterapi.GlobalPinInfo{
+			{Cid: "bafyallocated", Allocations: []string{"12D3KooWA", "12D3KooWB"}},
+			{Cid: "bafyalone", PeerMap: map[string]clusterapi.PinInfo{
+				"12D3KooWB": {Status: clusterapi.TrackerStatusPinned},
+				"12D3KooWC": {Status: clusterapi.TrackerStatusPinning},
+			}},
+			{Cid: "bafyreplicated", PeerMap: map[string]clusterapi.PinInfo{
+				"12D3KooWB": {Status: clusterapi.TrackerStatusPinned},
+				"12D3KooWC": {Status: clusterapi.TrackerStatusPinned},
+			}},
+		}
+		allocated, unreplicated := drainProgress(infos, "12D3KooWB")
+		Expect(allocated).To(Equal(1))
+		Expect(unreplicated).To(Equal(2))
+	})
+})
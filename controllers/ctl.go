@@ -0,0 +1,269 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+const (
+	// ctlHostKey Holds the address of the REST API in the credentials bundle.
+	ctlHostKey = "host"
+	// ctlURLKey Holds the URL of the REST API in the credentials bundle.
+	ctlURLKey = "url"
+	// ctlBasicAuthKey Holds the credentials in the format of the
+	// --basic-auth flag of ipfs-cluster-ctl.
+	ctlBasicAuthKey = "basic-auth"
+	// ctlMountPath Is where ctl Jobs mount the credentials bundle.
+	ctlMountPath = "/etc/ipfs-cluster-ctl"
+	// ctlArgsAnnotation Records the arguments a ctl Job runs with.
+	ctlArgsAnnotation = "ipfs.cluster.io/ctl-args"
+	// ctlDeadline Is how long a ctl Job may run.
+	ctlDeadline = 5 * time.Minute
+	// ctlPollInterval Is how often a ctl Job in progress is checked.
+	ctlPollInterval = 10 * time.Second
+)
+
+// runCtl Runs ipfs-cluster-ctl against CTL_HOST with the arguments of
+// CTL_ARGS, split on whitespace without further expansion, and keeps the last
+// lines of its output as the termination message of the container.
+const runCtl = `
+set -f
+https=""
+if [ -f ` + ctlMountPath + `/ca.crt ]; then
+	export SSL_CERT_FILE=` + ctlMountPath + `/ca.crt
+	https="--https"
+fi
+ipfs-cluster-ctl --host "${CTL_HOST}" ${https} \
+	--basic-auth "$(cat ` + ctlMountPath + `/username):$(cat ` + ctlMountPath + `/password)" \
+	${CTL_ARGS} > /tmp/output 2>&1
+code=$?
+cat /tmp/output
+tail -n 20 /tmp/output | tail -c 4096 > /dev/termination-log
+exit ${code}
+`
+
+// ctlCredentialsName Returns the name of the Secret bundling what
+// ipfs-cluster-ctl needs to reach the REST API of the cluster.
+func ctlCredentialsName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-ctl-credentials"
+}
+
+// ctlJobName Returns the name of the Job running ipfs-cluster-ctl.
+func ctlJobName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-ctl"
+}
+
+// ctlScope Returns the scope of the credentials bundled for
+// ipfs-cluster-ctl, read-only unless the spec asks for the admin ones. The
+// admin credentials are the only ones the REST API accepts without the proxy
+// scoping them.
+func (r *IpfsReconciler) ctlScope(m *clusterv1alpha1.Ipfs) string {
+	if !r.apiScoped(m) || (m.Spec.ClusterAPI != nil && m.Spec.ClusterAPI.CtlScope == clusterv1alpha1.APIScopeAdmin) {
+		return clusterv1alpha1.APIScopeAdmin
+	}
+	return clusterv1alpha1.APIScopeReadOnly
+}
+
+// syncCtlCredentials Keeps the credentials bundle of ipfs-cluster-ctl in sync
+// with the credentials of the REST API of its scope.
+func (r *IpfsReconciler) syncCtlCredentials(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	api := corev1.Secret{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: apiCredentialsName(m)}
+	if err := r.Get(ctx, key, &api); err != nil {
+		return fmt.Errorf("cannot get cluster API credentials: %w", err)
+	}
+	sec := corev1.Secret{}
+	sec.Name = ctlCredentialsName(m)
+	sec.Namespace = m.Namespace
+	_, err := controllerutil.CreateOrPatch(ctx, r.Client, &sec, func() error {
+		sec.Data = ctlCredentials(m, &api, r.ctlScope(m))
+		mergeLabels(&sec, ipfsLabels(m, componentCtl))
+		return ctrl.SetControllerReference(m, &sec, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot sync ipfs-cluster-ctl credentials: %w", err)
+	}
+	return nil
+}
+
+// ctlCredentials Returns the contents of the credentials bundle, holding the
+// credentials of the given scope. The REST API is only reachable from within
+// the cluster, so the bundle holds the address of its Service, served over
+// TLS when the API credentials hold a CA.
+func ctlCredentials(m *clusterv1alpha1.Ipfs, api *corev1.Secret, scope string) map[string][]byte {
+	service := fmt.Sprintf("%s.%s.svc", apiServiceName(m), m.Namespace)
+	scheme := "http"
+	username, password := api.Data[apiUsernameKey(scope)], api.Data[apiPasswordKey(scope)]
+	data := map[string][]byte{
+		clusterapi.UsernameKey: username,
+		clusterapi.PasswordKey: password,
+		ctlBasicAuthKey:        []byte(fmt.Sprintf("%s:%s", username, password)),
+		ctlHostKey:             []byte(ctlHost(m)),
+	}
+	if ca := api.Data[clusterapi.CACertKey]; len(ca) > 0 {
+		data[clusterapi.CACertKey] = ca
+		scheme = "https"
+	}
+	data[ctlURLKey] = []byte(fmt.Sprintf("%s://%s:%d", scheme, service, portAPIHTTP))
+	return data
+}
+
+// ctlHost Returns the multiaddress of the REST API of the cluster.
+func ctlHost(m *clusterv1alpha1.Ipfs) string {
+	return fmt.Sprintf("/dns4/%s.%s.svc/tcp/%d", apiServiceName(m), m.Namespace, portAPIHTTP)
+}
+
+// reconcileCtlJob Runs the ipfs-cluster-ctl command requested through the
+// run-ctl annotation in a Job, one at a time. Once the Job completes, its exit
+// code and the last lines of its output are reported in an event, the Job is
+// deleted and the annotation is removed unless it changed meanwhile. It
+// returns how long to wait before checking on a Job in progress, or zero
+// when there is nothing to do. Jobs run the image of the resolved spec.
+func (r *IpfsReconciler) reconcileCtlJob(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) (time.Duration, error) {
+	args, requested := m.Annotations[clusterv1alpha1.AnnotationRunCtl]
+	job := batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: ctlJobName(m)}, &job)
+	switch {
+	case errors.IsNotFound(err) && !requested:
+		return 0, nil
+	case errors.IsNotFound(err):
+		newJob, err := r.ctlJob(resolved, args)
+		if err != nil {
+			return 0, err
+		}
+		if err = r.Create(ctx, newJob); err != nil {
+			return 0, fmt.Errorf("cannot create ipfs-cluster-ctl job: %w", err)
+		}
+		return ctlPollInterval, nil
+	case err != nil:
+		return 0, fmt.Errorf("cannot get ipfs-cluster-ctl job: %w", err)
+	case job.Status.Succeeded == 0 && job.Status.Failed == 0:
+		return ctlPollInterval, nil
+	}
+
+	if err = r.reportCtlJob(ctx, m, &job); err != nil {
+		return 0, err
+	}
+	if err = r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+		!errors.IsNotFound(err) {
+		return 0, fmt.Errorf("cannot delete ipfs-cluster-ctl job: %w", err)
+	}
+	if !requested || args != job.Annotations[ctlArgsAnnotation] {
+		// Run the new arguments on the next pass.
+		return ctlPollInterval, nil
+	}
+	updated := m.DeepCopy()
+	delete(updated.Annotations, clusterv1alpha1.AnnotationRunCtl)
+	if err = r.Patch(ctx, updated, client.MergeFrom(m)); err != nil {
+		return 0, fmt.Errorf("cannot remove %s annotation: %w", clusterv1alpha1.AnnotationRunCtl, err)
+	}
+	m.Annotations = updated.Annotations
+	m.ResourceVersion = updated.ResourceVersion
+	return 0, nil
+}
+
+// reportCtlJob Emits an event with the exit code and the last lines of the
+// output of the completed Job.
+func (r *IpfsReconciler) reportCtlJob(ctx context.Context, m *clusterv1alpha1.Ipfs, job *batchv1.Job) error {
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(m.Namespace),
+		client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return fmt.Errorf("cannot list ipfs-cluster-ctl pods: %w", err)
+	}
+	args := job.Annotations[ctlArgsAnnotation]
+	for i := range pods.Items {
+		for _, cs := range pods.Items[i].Status.ContainerStatuses {
+			if cs.State.Terminated == nil {
+				continue
+			}
+			eventType, reason := corev1.EventTypeNormal, "CtlSucceeded"
+			if cs.State.Terminated.ExitCode != 0 {
+				eventType, reason = corev1.EventTypeWarning, "CtlFailed"
+			}
+			r.eventf(m, eventType, reason, "ipfs-cluster-ctl %s exited with code %d:\n%s",
+				args, cs.State.Terminated.ExitCode, cs.State.Terminated.Message)
+			return nil
+		}
+	}
+	r.eventf(m, corev1.EventTypeWarning, "CtlFailed", "ipfs-cluster-ctl %s did not complete within %s",
+		args, ctlDeadline)
+	return nil
+}
+
+// ctlJob Returns the Job running ipfs-cluster-ctl with the given arguments.
+// Whoever may annotate the cluster may change it, so the Job mounts the admin
+// credentials from the API Secret rather than the bundle, which may be
+// read-only.
+func (r *IpfsReconciler) ctlJob(m *clusterv1alpha1.Ipfs, args string) (*batchv1.Job, error) {
+	backoffLimit := int32(0)
+	deadline := int64(ctlDeadline.Seconds())
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        ctlJobName(m),
+			Namespace:   m.Namespace,
+			Annotations: map[string]string{ctlArgsAnnotation: args},
+			Labels:      ipfsLabels(m, componentCtl),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ipfsLabels(m, componentCtl),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:            "ipfs-cluster-ctl",
+							Image:           m.Spec.ClusterImage,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         []string{"sh", "-c", runCtl},
+							Env: []corev1.EnvVar{
+								{Name: "CTL_HOST", Value: ctlHost(m)},
+								{Name: "CTL_ARGS", Value: args},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "ctl-credentials",
+									MountPath: ctlMountPath,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "ctl-credentials",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: apiCredentialsName(m),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(m, job, r.Scheme); err != nil {
+		return nil, fmt.Errorf("cannot set controller reference for ipfs-cluster-ctl job: %w", err)
+	}
+	return job, nil
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+var _ = Describe("Ipfs cluster ctl access", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+		api        *corev1.Secret
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "ctl"
+		instance.Namespace = "default"
+		instance.Spec.ClusterImage = ipfsClusterImage
+		api = &corev1.Secret{}
+		api.Name = "ipfs-cluster-ctl-api"
+		api.Namespace = "default"
+		api.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("hunter2"),
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, api).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Recorder: recorder}
+	})
+
+	It("bundles the credentials of the REST API", func() {
+		Expect(reconciler.syncCtlCredentials(ctx, instance)).To(Succeed())
+		bundle := &corev1.Secret{}
+		key := types.NamespacedName{Namespace: "default", Name: "ctl-ctl-credentials"}
+		Expect(fakeClient.Get(ctx, key, bundle)).To(Succeed())
+		Expect(bundle.Data).To(Equal(map[string][]byte{
+			"username":   []byte("admin"),
+			"password":   []byte("hunter2"),
+			"basic-auth": []byte("admin:hunter2"),
+			"host":       []byte("/dns4/ipfs-cluster-ctl-api.default.svc/tcp/9094"),
+			"url":        []byte("http://ipfs-cluster-ctl-api.default.svc:9094"),
+		}))
+
+		By("following the API credentials when they change")
+		api.Data[clusterapi.PasswordKey] = []byte("correct-horse")
+		api.Data[clusterapi.CACertKey] = []byte("-----BEGIN CERTIFICATE-----")
+		Expect(fakeClient.Update(ctx, api)).To(Succeed())
+		Expect(reconciler.syncCtlCredentials(ctx, instance)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, bundle)).To(Succeed())
+		Expect(string(bundle.Data["basic-auth"])).To(Equal("admin:correct-horse"))
+		Expect(string(bundle.Data["url"])).To(Equal("https://ipfs-cluster-ctl-api.default.svc:9094"))
+		Expect(bundle.Data).To(HaveKey("ca.crt"))
+	})
+
+	It("runs the requested command in a job and reports its outcome", func() {
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationRunCtl: "pin ls"}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		requeue, err := reconciler.reconcileCtlJob(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(ctlPollInterval))
+
+		job := &batchv1.Job{}
+		key := types.NamespacedName{Namespace: "default", Name: "ipfs-cluster-ctl-ctl"}
+		Expect(fakeClient.Get(ctx, key, job)).To(Succeed())
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Image).To(Equal(ipfsClusterImage))
+		Expect(container.Env).To(ConsistOf(
+			corev1.EnvVar{Name: "CTL_HOST", Value: "/dns4/ipfs-cluster-ctl-api.default.svc/tcp/9094"},
+			corev1.EnvVar{Name: "CTL_ARGS", Value: "pin ls"},
+		))
+		By("mounting the admin credentials rather than the bundle")
+		Expect(job.Spec.Template.Spec.Volumes[0].Secret.SecretName).To(Equal("ipfs-cluster-ctl-api"))
+
+		By("waiting for the job to complete")
+		requeue, err = reconciler.reconcileCtlJob(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(ctlPollInterval))
+		Expect(recorder.Events).NotTo(Receive())
+
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-ctl-ctl-x7k2p"
+		pod.Namespace = "default"
+		pod.Labels = map[string]string{"job-name": job.Name}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name: "ipfs-cluster-ctl",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				ExitCode: 1,
+				Message:  "An error occurred:\n Code: 401",
+			}},
+		}}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+		job.Status.Failed = 1
+		Expect(fakeClient.Status().Update(ctx, job)).To(Succeed())
+
+		requeue, err = reconciler.reconcileCtlJob(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(recorder.Events).To(Receive(And(
+			ContainSubstring("CtlFailed"),
+			ContainSubstring("exited with code 1"),
+			ContainSubstring("Code: 401"),
+		)))
+		err = fakeClient.Get(ctx, key, job)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(instance.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRunCtl))
+
+		By("doing nothing until another command is requested")
+		requeue, err = reconciler.reconcileCtlJob(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+	})
+})
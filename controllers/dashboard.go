@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+const (
+	// DashboardPath Is the path the dashboard is served under.
+	DashboardPath = "/dashboard"
+	// DashboardTokenKey Is the key of the Secret holding the static bearer
+	// token of the dashboard.
+	DashboardTokenKey = "token"
+
+	// dashboardShutdownTimeout Is how long the dashboard server waits for the
+	// requests in flight when the manager stops.
+	dashboardShutdownTimeout = 5 * time.Second
+)
+
+// DashboardEntry Summarizes a cluster on the dashboard. It only holds status
+// data, never the secrets of the cluster.
+type DashboardEntry struct {
+	Namespace string                `json:"namespace"`
+	Name      string                `json:"name"`
+	Phase     clusterv1alpha1.Phase `json:"phase,omitempty"`
+	Ready     bool                  `json:"ready"`
+	// Message Is the message of the Ready condition.
+	Message string `json:"message,omitempty"`
+	// Peers Is the number of peers the cluster runs.
+	Peers int32 `json:"peers"`
+	// StorageUsed Is the size of the repos of all the peers together.
+	StorageUsed string `json:"storageUsed,omitempty"`
+	// StorageMax Is the largest size of the repos of all the peers together.
+	StorageMax string `json:"storageMax,omitempty"`
+	// Utilization Is the highest utilization of the repo of a peer, in percent.
+	Utilization *int32 `json:"utilization,omitempty"`
+	// Warnings Lists the conditions of the cluster signaling a problem.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// dashboardWarnings Are the conditions listed as warnings on the dashboard
+// while they are true.
+var dashboardWarnings = []string{
+	clusterv1alpha1.ConditionProvisioningFailed,
+	clusterv1alpha1.ConditionQuotaExceeded,
+	clusterv1alpha1.ConditionUnschedulable,
+	clusterv1alpha1.ConditionAPIUnreachable,
+	clusterv1alpha1.ConditionStoragePressure,
+	clusterv1alpha1.ConditionVersionSkew,
+	clusterv1alpha1.ConditionConfigSkew,
+	clusterv1alpha1.ConditionRejectedChange,
+	clusterv1alpha1.ConditionReprovideBehind,
+	clusterv1alpha1.ConditionUnverifiedVersionCombination,
+}
+
+// dashboardEntry Summarizes the status of the cluster.
+func dashboardEntry(instance *clusterv1alpha1.Ipfs) DashboardEntry {
+	entry := DashboardEntry{
+		Namespace: instance.Namespace,
+		Name:      instance.Name,
+		Phase:     instance.Status.Phase,
+		Peers:     peerCount(instance),
+	}
+	if cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReady); cond != nil {
+		entry.Ready = cond.Status == metav1.ConditionTrue
+		entry.Message = cond.Message
+	}
+	used, capacity := resource.Quantity{}, resource.Quantity{}
+	for i := range instance.Status.Peers {
+		repo := instance.Status.Peers[i].Repo
+		if repo == nil {
+			continue
+		}
+		used.Add(repo.Size)
+		capacity.Add(repo.StorageMax)
+		if entry.Utilization == nil || repo.Utilization > *entry.Utilization {
+			utilization := repo.Utilization
+			entry.Utilization = &utilization
+		}
+	}
+	if entry.Utilization != nil {
+		entry.StorageUsed = used.String()
+		entry.StorageMax = capacity.String()
+	}
+	for _, condType := range dashboardWarnings {
+		if meta.IsStatusConditionTrue(instance.Status.Conditions, condType) {
+			entry.Warnings = append(entry.Warnings, condType)
+		}
+	}
+	return entry
+}
+
+// DashboardAuthorizer Tells whether the bearer token of a request may read
+// the dashboard.
+type DashboardAuthorizer interface {
+	Authorize(ctx context.Context, token string) (bool, error)
+}
+
+// StaticTokenAuthorizer Lets the requests bearing the token held by a Secret
+// read the dashboard.
+type StaticTokenAuthorizer struct {
+	// Reader Reads the Secret, usually from the cache of the manager.
+	Reader client.Reader
+	// Secret Is the Secret holding the token under DashboardTokenKey.
+	Secret client.ObjectKey
+}
+
+// Authorize Compares the token with the one of the Secret.
+func (a StaticTokenAuthorizer) Authorize(ctx context.Context, token string) (bool, error) {
+	sec := corev1.Secret{}
+	if err := a.Reader.Get(ctx, a.Secret, &sec); err != nil {
+		return false, fmt.Errorf("cannot get dashboard token: %w", err)
+	}
+	expected := sec.Data[DashboardTokenKey]
+	if len(expected) == 0 {
+		return false, fmt.Errorf("secret %s has no %s", a.Secret, DashboardTokenKey)
+	}
+	return subtle.ConstantTimeCompare(expected, []byte(token)) == 1, nil
+}
+
+// TokenReviewAuthorizer Lets the Kubernetes users allowed to get the
+// dashboard path read the dashboard, as the metrics-reader ClusterRole does.
+type TokenReviewAuthorizer struct {
+	Client client.Client
+}
+
+// Authorize Authenticates the token with a TokenReview, then checks the user
+// may get DashboardPath with a SubjectAccessReview.
+func (a TokenReviewAuthorizer) Authorize(ctx context.Context, token string) (bool, error) {
+	review := authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := a.Client.Create(ctx, &review); err != nil {
+		return false, fmt.Errorf("cannot review token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return false, nil
+	}
+	user := review.Status.User
+	access := authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		Groups: user.Groups,
+		UID:    user.UID,
+		NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+			Path: DashboardPath,
+			Verb: "get",
+		},
+	}}
+	if len(user.Extra) > 0 {
+		access.Spec.Extra = map[string]authorizationv1.ExtraValue{}
+		for k, v := range user.Extra {
+			access.Spec.Extra[k] = authorizationv1.ExtraValue(v)
+		}
+	}
+	if err := a.Client.Create(ctx, &access); err != nil {
+		return false, fmt.Errorf("cannot review access: %w", err)
+	}
+	return access.Status.Allowed, nil
+}
+
+// DashboardHandler Serves a summary of every cluster, as JSON or as an HTML
+// table when the request accepts text/html. The clusters are read from the
+// cache of the manager, so that the dashboard adds no load to the API server.
+type DashboardHandler struct {
+	// Reader Reads the clusters, usually from the cache of the manager.
+	Reader client.Reader
+	// Authorizer Tells which requests may read the dashboard.
+	Authorizer DashboardAuthorizer
+}
+
+// ServeHTTP Writes the summary of the clusters to the authorized requests.
+func (h DashboardHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "the dashboard is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "a bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	allowed, err := h.Authorizer.Authorize(req.Context(), token)
+	if err != nil {
+		http.Error(w, "cannot authorize the request", http.StatusServiceUnavailable)
+		return
+	}
+	if !allowed {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	list := clusterv1alpha1.IpfsList{}
+	if err = h.Reader.List(req.Context(), &list); err != nil {
+		http.Error(w, "cannot list the clusters", http.StatusServiceUnavailable)
+		return
+	}
+	entries := make([]DashboardEntry, 0, len(list.Items))
+	for i := range list.Items {
+		entries = append(entries, dashboardEntry(&list.Items[i]))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Namespace != entries[j].Namespace {
+			return entries[i].Namespace < entries[j].Namespace
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if strings.Contains(req.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = dashboardTemplate.Execute(w, entries)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+// dashboardTemplate Renders the summary of the clusters as an HTML table.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head><title>IPFS clusters</title></head>
+<body>
+<table>
+<tr><th>Namespace</th><th>Name</th><th>Phase</th><th>Ready</th><th>Peers</th><th>Storage</th>` +
+	`<th>Utilization</th><th>Warnings</th></tr>
+{{- range . }}
+<tr><td>{{ .Namespace }}</td><td>{{ .Name }}</td><td>{{ .Phase }}</td>` +
+	`<td title="{{ .Message }}">{{ .Ready }}</td><td>{{ .Peers }}</td>` +
+	`<td>{{ if .StorageUsed }}{{ .StorageUsed }} / {{ .StorageMax }}{{ end }}</td>` +
+	`<td>{{ with .Utilization }}{{ . }}%{{ end }}</td>` +
+	`<td>{{ range $i, $w := .Warnings }}{{ if $i }}, {{ end }}{{ $w }}{{ end }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// DashboardServer Serves the dashboard on its own address, separately from
+// the metrics, for as long as the manager runs. The requests bear tokens, so
+// the dashboard is served over TLS, or over plain HTTP on a loopback address
+// only, behind a TLS-terminating proxy such as kube-rbac-proxy.
+type DashboardServer struct {
+	Addr    string
+	Handler http.Handler
+	// CertFile and KeyFile Hold the certificate and the key of the dashboard.
+	// When empty, the dashboard is served over plain HTTP.
+	CertFile string
+	KeyFile  string
+}
+
+var _ manager.Runnable = &DashboardServer{}
+
+// Start Serves the dashboard until the context is done.
+func (s *DashboardServer) Start(ctx context.Context) error {
+	if s.CertFile == "" && !loopbackAddr(s.Addr) {
+		return fmt.Errorf("cannot serve the dashboard over plain HTTP on %s: "+
+			"bind it to a loopback address or give it a certificate", s.Addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(DashboardPath, s.Handler)
+	srv := &http.Server{Addr: s.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() {
+		if s.CertFile != "" {
+			errs <- srv.ListenAndServeTLS(s.CertFile, s.KeyFile)
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return fmt.Errorf("cannot serve the dashboard: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), dashboardShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("cannot stop the dashboard: %w", err)
+	}
+	return nil
+}
+
+// loopbackAddr Returns whether the address only binds the loopback interface.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NeedLeaderElection Serves the dashboard from every replica of the operator,
+// as it only reads the cache.
+func (s *DashboardServer) NeedLeaderElection() bool {
+	return false
+}
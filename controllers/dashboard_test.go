@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// writeCertificate Writes a self-signed certificate for 127.0.0.1 and its
+// key to the given directory, and returns the pool trusting it.
+func writeCertificate(dir string) *x509.CertPool {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(os.WriteFile(filepath.Join(dir, "tls.crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "tls.key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
+
+// reviewingClient Answers the token and access reviews as the API server
+// would, authenticating the given tokens and allowing a single user to get
+// the dashboard.
+type reviewingClient struct {
+	client.Client
+	tokens  map[string]string
+	allowed string
+}
+
+func (c reviewingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		user, ok := c.tokens[review.Spec.Token]
+		review.Status.Authenticated = ok
+		review.Status.User.Username = user
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		attrs := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == c.allowed &&
+			attrs != nil && attrs.Path == DashboardPath && attrs.Verb == "get"
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("Ipfs dashboard", func() {
+	const token = "s3cr3t-dashboard-token"
+
+	var (
+		fakeClient client.Client
+		handler    DashboardHandler
+	)
+
+	cluster := func(namespace, name string, phase clusterv1alpha1.Phase,
+		conds ...metav1.Condition) *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Namespace = namespace
+		instance.Name = name
+		instance.Spec.Replicas = 2
+		instance.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+			BasicAuthSecretRef: &corev1.LocalObjectReference{Name: name + "-secret"},
+		}
+		instance.Status.Phase = phase
+		instance.Status.Conditions = conds
+		return instance
+	}
+
+	serve := func(bearer string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, DashboardPath, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		running := cluster("prod", "archive", clusterv1alpha1.PhaseRunning, metav1.Condition{
+			Type: clusterv1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: "PeersReady",
+			Message: "2/2 peers ready, 2/2 updated",
+		})
+		running.Status.Peers = []clusterv1alpha1.PeerStatus{
+			{Name: "ipfs-cluster-archive-0", Repo: &clusterv1alpha1.RepoUsage{
+				Size: resource.MustParse("30Gi"), StorageMax: resource.MustParse("100Gi"), Utilization: 30,
+			}},
+			{Name: "ipfs-cluster-archive-1", Repo: &clusterv1alpha1.RepoUsage{
+				Size: resource.MustParse("90Gi"), StorageMax: resource.MustParse("100Gi"), Utilization: 90,
+			}},
+		}
+		degraded := cluster("prod", "<cache>", clusterv1alpha1.PhaseDegraded,
+			metav1.Condition{
+				Type: clusterv1alpha1.ConditionReady, Status: metav1.ConditionFalse, Reason: "PeersNotReady",
+				Message: "1/2 peers ready, 2/2 updated",
+			},
+			metav1.Condition{
+				Type: clusterv1alpha1.ConditionUnschedulable, Status: metav1.ConditionTrue, Reason: "NoFit",
+			})
+		pending := cluster("dev", "scratch", clusterv1alpha1.PhasePending)
+		secret := &corev1.Secret{}
+		secret.Namespace = "ipfs-operator-system"
+		secret.Name = "dashboard"
+		secret.Data = map[string][]byte{DashboardTokenKey: []byte(token)}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(running, degraded, pending, secret).Build()
+		handler = DashboardHandler{
+			Reader:     fakeClient,
+			Authorizer: StaticTokenAuthorizer{Reader: fakeClient, Secret: client.ObjectKeyFromObject(secret)},
+		}
+	})
+
+	It("refuses the requests without the token", func() {
+		rec := serve("", "")
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(rec.Header().Get("WWW-Authenticate")).To(Equal("Bearer"))
+		Expect(serve("wrong", "").Code).To(Equal(http.StatusForbidden))
+
+		req := httptest.NewRequest(http.MethodPost, DashboardPath, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("sums up every cluster as JSON", func() {
+		rec := serve(token, "")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		entries := []DashboardEntry{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &entries)).To(Succeed())
+		Expect(entries).To(HaveLen(3))
+
+		Expect(entries[0].Namespace).To(Equal("dev"))
+		Expect(entries[0].Phase).To(Equal(clusterv1alpha1.PhasePending))
+		Expect(entries[0].Ready).To(BeFalse())
+		Expect(entries[0].Utilization).To(BeNil())
+
+		Expect(entries[1].Name).To(Equal("<cache>"))
+		Expect(entries[1].Phase).To(Equal(clusterv1alpha1.PhaseDegraded))
+		Expect(entries[1].Message).To(Equal("1/2 peers ready, 2/2 updated"))
+		Expect(entries[1].Warnings).To(Equal([]string{clusterv1alpha1.ConditionUnschedulable}))
+
+		Expect(entries[2].Name).To(Equal("archive"))
+		Expect(entries[2].Ready).To(BeTrue())
+		Expect(entries[2].Peers).To(Equal(int32(2)))
+		Expect(entries[2].StorageUsed).To(Equal("120Gi"))
+		Expect(entries[2].StorageMax).To(Equal("200Gi"))
+		Expect(*entries[2].Utilization).To(Equal(int32(90)))
+
+		Expect(rec.Body.String()).NotTo(ContainSubstring("secret"))
+		Expect(rec.Body.String()).NotTo(ContainSubstring(token))
+	})
+
+	It("renders an escaped HTML table for browsers", func() {
+		rec := serve(token, "text/html,application/xhtml+xml")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+		Expect(rec.Body.String()).To(ContainSubstring("<td>archive</td>"))
+		Expect(rec.Body.String()).To(ContainSubstring("<td>120Gi / 200Gi</td>"))
+		Expect(rec.Body.String()).To(ContainSubstring("&lt;cache&gt;"))
+		Expect(rec.Body.String()).NotTo(ContainSubstring("<cache>"))
+	})
+
+	It("answers unavailable when the token cannot be read", func() {
+		handler.Authorizer = StaticTokenAuthorizer{
+			Reader: fakeClient,
+			Secret: client.ObjectKey{Namespace: "ipfs-operator-system", Name: "missing"},
+		}
+		Expect(serve(token, "").Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("lets the Kubernetes users allowed to get the dashboard read it", func() {
+		handler.Authorizer = TokenReviewAuthorizer{Client: reviewingClient{
+			Client:  fakeClient,
+			tokens:  map[string]string{"reader-token": "reader", "other-token": "other"},
+			allowed: "reader",
+		}}
+		Expect(serve("reader-token", "").Code).To(Equal(http.StatusOK))
+		Expect(serve("other-token", "").Code).To(Equal(http.StatusForbidden))
+		Expect(serve("unknown-token", "").Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("stops serving with the manager", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		server := &DashboardServer{Addr: "127.0.0.1:0", Handler: handler}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Start(ctx)
+		}()
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(server.NeedLeaderElection()).To(BeFalse())
+	})
+
+	It("only serves plain HTTP on a loopback address", func() {
+		for _, addr := range []string{":8082", "0.0.0.0:8082", "10.0.0.1:8082"} {
+			server := &DashboardServer{Addr: addr, Handler: handler}
+			Expect(server.Start(context.Background())).To(MatchError(ContainSubstring("over plain HTTP")))
+		}
+		Expect(loopbackAddr("127.0.0.1:8082")).To(BeTrue())
+		Expect(loopbackAddr("[::1]:8082")).To(BeTrue())
+		Expect(loopbackAddr("localhost:8082")).To(BeTrue())
+	})
+
+	It("serves the dashboard over TLS on any address", func() {
+		dir, err := os.MkdirTemp("", "dashboard")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		pool := writeCertificate(dir)
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		addr := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		server := &DashboardServer{
+			Addr:     addr,
+			Handler:  handler,
+			CertFile: filepath.Join(dir, "tls.crt"),
+			KeyFile:  filepath.Join(dir, "tls.key"),
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Start(ctx)
+		}()
+		defer func() {
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		}()
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}}}
+		Eventually(func() (int, error) {
+			req, err := http.NewRequest(http.MethodGet, "https://"+addr+DashboardPath, nil)
+			if err != nil {
+				return 0, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return 0, err
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode, nil
+		}).Should(Equal(http.StatusOK))
+	})
+})
@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// awsAccessKeyEnv Is the key of the access key in the credentials Secret,
+	// and the environment variable go-ds-s3 reads it from.
+	awsAccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	// awsSecretKeyEnv Is the key of the secret key in the credentials Secret,
+	// and the environment variable go-ds-s3 reads it from.
+	awsSecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+)
+
+// ipfsInitCommand Returns the command initializing the repo of a peer. The
+// local backend keeps its blocks in badger unless flatfs is asked for, while
+// flatfs and the s3 backend start from the default datastore and replace it
+// once the repo exists.
+func ipfsInitCommand(m *clusterv1alpha1.Ipfs) string {
+	if clusterv1alpha1.DatastoreBackendOf(&m.Spec) == clusterv1alpha1.DatastoreBackendS3 ||
+		flatfsShardingDepth(m) != 0 {
+		return "ipfs init --profile=server"
+	}
+	return "ipfs init --profile=badgerds,server"
+}
+
+// flatfsShardingDepth Returns the sharding depth of the flatfs datastore of
+// the repos, or zero when they keep their blocks in badger or a bucket.
+func flatfsShardingDepth(m *clusterv1alpha1.Ipfs) int32 {
+	if clusterv1alpha1.DatastoreBackendOf(&m.Spec) != clusterv1alpha1.DatastoreBackendLocal {
+		return 0
+	}
+	return clusterv1alpha1.RepoLayoutOf(&m.Spec).FlatfsShardingDepth
+}
+
+// datastoreCommands Returns the commands configuring the datastore of a newly
+// initialized repo, or an empty string for the badger datastore.
+func datastoreCommands(m *clusterv1alpha1.Ipfs) (string, error) {
+	var spec, diskSpec []byte
+	var err error
+	switch {
+	case clusterv1alpha1.DatastoreBackendOf(&m.Spec) == clusterv1alpha1.DatastoreBackendS3 &&
+		m.Spec.Datastore.S3 != nil:
+		spec, diskSpec, err = s3DatastoreSpec(m.Spec.Datastore.S3)
+	case flatfsShardingDepth(m) != 0:
+		spec, diskSpec, err = flatfsDatastoreSpec(flatfsShardingDepth(m), !m.Spec.Datastore.NoSync)
+		if err != nil {
+			return "", err
+		}
+		// The datastore of the default spec has no block nor key yet, and
+		// flatfs records its sharding when it creates its directory.
+		return fmt.Sprintf("rm -rf /data/ipfs/blocks /data/ipfs/datastore\n"+
+			"ipfs config --json Datastore.Spec '%s'\necho '%s' > /data/ipfs/datastore_spec\n",
+			spec, diskSpec), nil
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("ipfs config --json Datastore.Spec '%s'\necho '%s' > /data/ipfs/datastore_spec\n",
+		spec, diskSpec), nil
+}
+
+// flatfsDatastoreSpec Renders the Datastore.Spec of the kubo config storing
+// the blocks in flatfs, sharded by the given number of characters before the
+// last one of their key, and everything else in leveldb, along with the
+// datastore_spec file kubo checks it against on startup.
+func flatfsDatastoreSpec(depth int32, sync bool) ([]byte, []byte, error) {
+	shardFunc := fmt.Sprintf("/repo/flatfs/shard/v1/next-to-last/%d", depth)
+	spec := map[string]interface{}{
+		"type": "mount",
+		"mounts": []map[string]interface{}{
+			{
+				"mountpoint": "/blocks",
+				"prefix":     "flatfs.datastore",
+				"type":       "measure",
+				"child": map[string]interface{}{
+					"type":      "flatfs",
+					"path":      "blocks",
+					"shardFunc": shardFunc,
+					"sync":      sync,
+				},
+			},
+			{
+				"mountpoint": "/",
+				"prefix":     "leveldb.datastore",
+				"type":       "measure",
+				"child": map[string]interface{}{
+					"type":        "levelds",
+					"path":        "datastore",
+					"compression": "none",
+				},
+			},
+		},
+	}
+	diskSpec := map[string]interface{}{
+		"type": "mount",
+		"mounts": []map[string]interface{}{
+			{
+				"mountpoint": "/blocks",
+				"type":       "flatfs",
+				"path":       "blocks",
+				"shardFunc":  shardFunc,
+			},
+			{
+				"mountpoint": "/",
+				"type":       "levelds",
+				"path":       "datastore",
+			},
+		},
+	}
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	diskSpecJSON, err := json.Marshal(diskSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return specJSON, diskSpecJSON, nil
+}
+
+// datastoreGCCommands Returns the commands setting the interval of the
+// garbage collection of the repos, for the tune function of the configure
+// script.
+func datastoreGCCommands(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.Datastore == nil || m.Spec.Datastore.BadgerGCInterval == nil {
+		return ""
+	}
+	return fmt.Sprintf("\tipfs config Datastore.GCPeriod %s\n", m.Spec.Datastore.BadgerGCInterval.Duration)
+}
+
+// ipfsArgs Returns the arguments of the ipfs daemons, or none to keep the
+// command of the image.
+func ipfsArgs(m *clusterv1alpha1.Ipfs) []string {
+	if m.Spec.Datastore == nil || m.Spec.Datastore.BadgerGCInterval == nil {
+		return nil
+	}
+	return []string{"daemon", "--migrate=true", "--agent-version-suffix=docker", "--enable-gc"}
+}
+
+// s3DatastoreSpec Renders the Datastore.Spec of the kubo config storing the
+// blocks in the bucket and everything else in leveldb, along with the
+// datastore_spec file kubo checks it against on startup. The credentials are
+// left empty so that go-ds-s3 reads them from the environment.
+func s3DatastoreSpec(s3 *clusterv1alpha1.S3Datastore) ([]byte, []byte, error) {
+	spec := map[string]interface{}{
+		"type": "mount",
+		"mounts": []map[string]interface{}{
+			{
+				"mountpoint": "/blocks",
+				"prefix":     "s3.datastore",
+				"type":       "measure",
+				"child": map[string]interface{}{
+					"type":           "s3ds",
+					"bucket":         s3.Bucket,
+					"region":         s3.Region,
+					"regionEndpoint": s3.Endpoint,
+					"rootDirectory":  s3.RootDirectory,
+					"accessKey":      "",
+					"secretKey":      "",
+				},
+			},
+			{
+				"mountpoint": "/",
+				"prefix":     "leveldb.datastore",
+				"type":       "measure",
+				"child": map[string]interface{}{
+					"type":        "levelds",
+					"path":        "datastore",
+					"compression": "none",
+				},
+			},
+		},
+	}
+	diskSpec := map[string]interface{}{
+		"type": "mount",
+		"mounts": []map[string]interface{}{
+			{
+				"mountpoint":    "/blocks",
+				"bucket":        s3.Bucket,
+				"region":        s3.Region,
+				"rootDirectory": s3.RootDirectory,
+			},
+			{
+				"mountpoint": "/",
+				"type":       "levelds",
+				"path":       "datastore",
+			},
+		},
+	}
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	diskSpecJSON, err := json.Marshal(diskSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return specJSON, diskSpecJSON, nil
+}
+
+// datastoreEnv Returns the environment of the ipfs container holding the
+// credentials of the bucket, if any.
+func datastoreEnv(m *clusterv1alpha1.Ipfs) []corev1.EnvVar {
+	if clusterv1alpha1.DatastoreBackendOf(&m.Spec) != clusterv1alpha1.DatastoreBackendS3 ||
+		m.Spec.Datastore.S3 == nil {
+		return nil
+	}
+	ref := m.Spec.Datastore.S3.CredentialsSecretRef
+	env := make([]corev1.EnvVar, 0, 2)
+	for _, key := range []string{awsAccessKeyEnv, awsSecretKeyEnv} {
+		env = append(env, corev1.EnvVar{
+			Name: key,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: ref,
+					Key:                  key,
+				},
+			},
+		})
+	}
+	return env
+}
+
+// syncRepoInitialized Records whether the repo of the peer was initialized,
+// which it is once the configure-ipfs container of its pod succeeded or the
+// ipfs container started.
+func syncRepoInitialized(pod *corev1.Pod, peer *clusterv1alpha1.PeerStatus) {
+	if peer.RepoInitialized {
+		return
+	}
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.Name == "configure-ipfs" && cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0 {
+			peer.RepoInitialized = true
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == "ipfs" && (cs.State.Running != nil || cs.RestartCount > 0) {
+			peer.RepoInitialized = true
+		}
+	}
+}
+
+// syncRepoLayout Records the layout of the repos in the status once the first
+// one is initialized, from then on the layout cannot change.
+func syncRepoLayout(instance, resolved *clusterv1alpha1.Ipfs) {
+	if instance.Status.RepoLayout != nil {
+		return
+	}
+	for _, peer := range instance.Status.Peers {
+		if peer.RepoInitialized {
+			layout := clusterv1alpha1.RepoLayout{FlatfsShardingDepth: flatfsShardingDepth(resolved)}
+			instance.Status.RepoLayout = &layout
+			return
+		}
+	}
+}
+
+// setNoSyncWritesCondition Warns through the NoSyncWrites condition while the
+// datastore does not sync its writes to disk.
+func (r *IpfsReconciler) setNoSyncWritesCondition(instance, resolved *clusterv1alpha1.Ipfs) {
+	if resolved.Spec.Datastore == nil || !resolved.Spec.Datastore.NoSync {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionNoSyncWrites)
+		return
+	}
+	if !meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionNoSyncWrites) {
+		r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.NoSyncWritesReasonNoSyncSet,
+			"the datastore of the new repos does not sync its writes: a crash of a node may corrupt its repo")
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:   clusterv1alpha1.ConditionNoSyncWrites,
+		Status: metav1.ConditionTrue,
+		Reason: clusterv1alpha1.NoSyncWritesReasonNoSyncSet,
+		Message: "spec.datastore.noSync is set: the flatfs datastore of the repos initialized since does not " +
+			"sync its writes to disk, so that a crash of a node may lose the latest blocks of its peer or " +
+			"corrupt its repo. Keep the pins replicated to other peers.",
+		ObservedGeneration: instance.Generation,
+	})
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs datastore backend", func() {
+	var instance *clusterv1alpha1.Ipfs
+
+	BeforeEach(func() {
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "blocks"
+		instance.Namespace = "default"
+	})
+
+	It("keeps the badger datastore of the local backend", func() {
+		Expect(ipfsInitCommand(instance)).To(Equal("ipfs init --profile=badgerds,server"))
+		Expect(datastoreCommands(instance)).To(BeEmpty())
+		Expect(datastoreEnv(instance)).To(BeEmpty())
+
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{Backend: clusterv1alpha1.DatastoreBackendLocal}
+		Expect(datastoreCommands(instance)).To(BeEmpty())
+	})
+
+	It("renders the flatfs datastore of the new repos", func() {
+		depth := int32(3)
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{FlatfsShardingDepth: &depth}
+		Expect(ipfsInitCommand(instance)).To(Equal("ipfs init --profile=server"))
+		rendered, err := datastoreCommands(instance)
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden("datastore-flatfs", rendered)
+
+		By("turning off the sync of the writes")
+		instance.Spec.Datastore.NoSync = true
+		rendered, err = datastoreCommands(instance)
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden("datastore-flatfs-nosync", rendered)
+	})
+
+	It("runs the garbage collection of the badger datastore", func() {
+		Expect(datastoreGCCommands(instance)).To(BeEmpty())
+		Expect(ipfsArgs(instance)).To(BeEmpty())
+
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{
+			BadgerGCInterval: &metav1.Duration{Duration: 30 * time.Minute},
+		}
+		Expect(datastoreGCCommands(instance)).To(Equal("\tipfs config Datastore.GCPeriod 30m0s\n"))
+		Expect(ipfsArgs(instance)).To(ContainElement("--enable-gc"))
+	})
+
+	It("records the layout of the repos once the first one is initialized", func() {
+		depth := int32(2)
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{FlatfsShardingDepth: &depth}
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-blocks-0"
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{{Name: pod.Name}}
+		peer := &instance.Status.Peers[0]
+
+		syncRepoInitialized(pod, peer)
+		syncRepoLayout(instance, instance)
+		Expect(peer.RepoInitialized).To(BeFalse())
+		Expect(instance.Status.RepoLayout).To(BeNil())
+
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "configure-ipfs",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+		}}
+		syncRepoInitialized(pod, peer)
+		syncRepoLayout(instance, instance)
+		Expect(peer.RepoInitialized).To(BeTrue())
+		Expect(instance.Status.RepoLayout).To(Equal(&clusterv1alpha1.RepoLayout{FlatfsShardingDepth: 2}))
+
+		By("reconciling the recorded sharding when the spec changes it")
+		reconciler := &IpfsReconciler{}
+		instance.Spec.Datastore.FlatfsShardingDepth = nil
+		resolved := instance.DeepCopy()
+		reconciler.fenceSpec(instance, resolved)
+		Expect(flatfsShardingDepth(resolved)).To(Equal(int32(2)))
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions,
+			clusterv1alpha1.ConditionRejectedChange)).To(BeTrue())
+	})
+
+	It("warns while the writes are not synced", func() {
+		depth := int32(2)
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{FlatfsShardingDepth: &depth, NoSync: true}
+		reconciler := &IpfsReconciler{}
+		reconciler.setNoSyncWritesCondition(instance, instance)
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions,
+			clusterv1alpha1.ConditionNoSyncWrites)).To(BeTrue())
+
+		instance.Spec.Datastore.NoSync = false
+		reconciler.setNoSyncWritesCondition(instance, instance)
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionNoSyncWrites)).To(BeNil())
+	})
+
+	It("renders the datastore of an AWS bucket", func() {
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{
+			Backend: clusterv1alpha1.DatastoreBackendS3,
+			S3: &clusterv1alpha1.S3Datastore{
+				Bucket:               "ipfs-blocks",
+				Region:               "us-east-1",
+				CredentialsSecretRef: corev1.LocalObjectReference{Name: "aws"},
+			},
+		}
+		Expect(ipfsInitCommand(instance)).To(Equal("ipfs init --profile=server"))
+		rendered, err := datastoreCommands(instance)
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden("datastore-s3", rendered)
+	})
+
+	It("renders the datastore of an S3-compatible bucket", func() {
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{
+			Backend: clusterv1alpha1.DatastoreBackendS3,
+			S3: &clusterv1alpha1.S3Datastore{
+				Bucket:               "ipfs-blocks",
+				Region:               "garage",
+				Endpoint:             "https://s3.example.com:3900",
+				RootDirectory:        "cluster-a",
+				CredentialsSecretRef: corev1.LocalObjectReference{Name: "garage"},
+			},
+		}
+		rendered, err := datastoreCommands(instance)
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden("datastore-s3-compatible", rendered)
+
+		By("passing the credentials through the environment")
+		Expect(datastoreEnv(instance)).To(ConsistOf(
+			corev1.EnvVar{Name: "AWS_ACCESS_KEY_ID", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "garage"},
+					Key:                  "AWS_ACCESS_KEY_ID",
+				},
+			}},
+			corev1.EnvVar{Name: "AWS_SECRET_ACCESS_KEY", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "garage"},
+					Key:                  "AWS_SECRET_ACCESS_KEY",
+				},
+			}},
+		))
+		Expect(referencedSecrets(instance)).To(ContainElement("garage"))
+	})
+})
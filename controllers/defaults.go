@@ -0,0 +1,348 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// OperatorDefaults Holds the values used wherever an Ipfs resource leaves
+// a field empty, so that platform teams can set them once for every cluster.
+type OperatorDefaults struct {
+	IpfsImage        string                          `json:"ipfsImage,omitempty"`
+	ClusterImage     string                          `json:"clusterImage,omitempty"`
+	AuthProxyImage   string                          `json:"authProxyImage,omitempty"`
+	StorageClassName string                          `json:"storageClassName,omitempty"`
+	Resources        clusterv1alpha1.ResourcesConfig `json:"resources,omitempty"`
+	// StoragePressureThreshold Is the share of StorageMax, in percent, a peer
+	// repo may fill before the StoragePressure condition is set.
+	StoragePressureThreshold int32 `json:"storagePressureThreshold,omitempty"`
+	// StatusSyncInterval Is how often the operator queries the peers to
+	// refresh the status of each cluster.
+	StatusSyncInterval metav1.Duration `json:"statusSyncInterval,omitempty"`
+	// MeshDegradedAfter Is how long connections between the peers may be
+	// missing before the MeshDegraded condition is set.
+	MeshDegradedAfter metav1.Duration `json:"meshDegradedAfter,omitempty"`
+	// ArgoCDIgnoreExtraneous Marks the objects the operator creates so that
+	// Argo CD does not report them as out of sync with the Git repository.
+	ArgoCDIgnoreExtraneous bool `json:"argoCDIgnoreExtraneous,omitempty"`
+	// Budget Bounds the resources of every cluster, unless its namespace
+	// sets its own budget.
+	Budget clusterv1alpha1.Budget `json:"budget,omitempty"`
+	// MaintenanceWindow Is the maintenance window of the clusters which do
+	// not set one.
+	MaintenanceWindow *clusterv1alpha1.MaintenanceWindow `json:"maintenanceWindow,omitempty"`
+}
+
+// DefaultsFlags Holds the defaults set on the command line of the operator.
+type DefaultsFlags struct {
+	IpfsImage      string
+	ClusterImage   string
+	AuthProxyImage string
+}
+
+// resolveDefaults Returns the defaults resolved from each of their sources,
+// in increasing precedence: the built-in defaults, the defaults file, the
+// IpfsOperatorConfig and the command-line flags. The file is given already
+// layered over the built-in defaults. The fields a source leaves empty keep
+// the value of the sources below it.
+func resolveDefaults(
+	file OperatorDefaults,
+	config *clusterv1alpha1.IpfsOperatorConfigSpec,
+	flags DefaultsFlags,
+) OperatorDefaults {
+	resolved := file
+	resolved.MaintenanceWindow = file.MaintenanceWindow.DeepCopy()
+	if config != nil {
+		if config.IpfsImage != "" {
+			resolved.IpfsImage = config.IpfsImage
+		}
+		if config.ClusterImage != "" {
+			resolved.ClusterImage = config.ClusterImage
+		}
+		if config.AuthProxyImage != "" {
+			resolved.AuthProxyImage = config.AuthProxyImage
+		}
+		if config.StorageClassName != "" {
+			resolved.StorageClassName = config.StorageClassName
+		}
+		if config.MaintenanceWindow != nil {
+			resolved.MaintenanceWindow = config.MaintenanceWindow.DeepCopy()
+		}
+		if config.Budget != nil {
+			resolved.Budget = *config.Budget.DeepCopy()
+		}
+	}
+	if flags.IpfsImage != "" {
+		resolved.IpfsImage = flags.IpfsImage
+	}
+	if flags.ClusterImage != "" {
+		resolved.ClusterImage = flags.ClusterImage
+	}
+	if flags.AuthProxyImage != "" {
+		resolved.AuthProxyImage = flags.AuthProxyImage
+	}
+	return resolved
+}
+
+// builtinDefaultsVersion Is the version of the built-in defaults. It must be
+// bumped along with any change to the values builtinDefaults returns, so
+// that the existing clusters keep the values they were created with.
+const builtinDefaultsVersion int32 = 1
+
+// defaultsReloadDelay Is how long the defaults file must stay unchanged
+// before it is reloaded, as writing a file truncates it before writing its
+// new contents, which would otherwise be read as empty defaults.
+const defaultsReloadDelay = 100 * time.Millisecond
+
+// builtinDefaults Returns the defaults used when the operator is not configured.
+func builtinDefaults() OperatorDefaults {
+	return OperatorDefaults{
+		IpfsImage:                ipfsImage,
+		ClusterImage:             ipfsClusterImage,
+		AuthProxyImage:           authProxyImage,
+		StoragePressureThreshold: 85,
+		StatusSyncInterval:       metav1.Duration{Duration: time.Minute},
+		MeshDegradedAfter:        metav1.Duration{Duration: 5 * time.Minute},
+	}
+}
+
+// apply Returns a copy of the given Ipfs resource with its empty fields set
+// from the defaults. The resource itself is left untouched.
+func (d OperatorDefaults) apply(m *clusterv1alpha1.Ipfs) *clusterv1alpha1.Ipfs {
+	resolved := m.DeepCopy()
+	spec := &resolved.Spec
+	if spec.IpfsImage == "" {
+		spec.IpfsImage = d.IpfsImage
+	}
+	if spec.ClusterImage == "" {
+		spec.ClusterImage = d.ClusterImage
+	}
+	if spec.Expose.Auth != nil && spec.Expose.Auth.Image == "" {
+		spec.Expose.Auth.Image = d.AuthProxyImage
+	}
+	if spec.StorageClassName == nil && d.StorageClassName != "" {
+		spec.StorageClassName = &d.StorageClassName
+	}
+	if spec.Resources.Limits == nil {
+		spec.Resources.Limits = d.Resources.Limits.DeepCopy()
+	}
+	if spec.Resources.Requests == nil {
+		spec.Resources.Requests = d.Resources.Requests.DeepCopy()
+	}
+	if spec.Resources.Cluster == nil {
+		spec.Resources.Cluster = d.Resources.Cluster.DeepCopy()
+	}
+	if spec.Resources.EphemeralStorage == nil {
+		spec.Resources.EphemeralStorage = d.Resources.EphemeralStorage.DeepCopy()
+	}
+	if spec.StoragePressureThreshold == nil {
+		threshold := d.StoragePressureThreshold
+		spec.StoragePressureThreshold = &threshold
+	}
+	if spec.MaintenanceWindow == nil {
+		spec.MaintenanceWindow = d.MaintenanceWindow.DeepCopy()
+	}
+	return resolved
+}
+
+// DefaultsStore Serves the operator defaults resolved from a file, the
+// IpfsOperatorConfig and the command-line flags, resolving them again
+// whenever the file or the IpfsOperatorConfig changes.
+type DefaultsStore struct {
+	path string
+	// builtin Holds the built-in defaults, at builtinVersion.
+	builtin        OperatorDefaults
+	builtinVersion int32
+	mu             sync.RWMutex
+	// fileData Holds the contents of the defaults file, which are layered
+	// over the built-in defaults recorded by the clusters as well.
+	fileData []byte
+	file     OperatorDefaults
+	config   *clusterv1alpha1.IpfsOperatorConfigSpec
+	flags    DefaultsFlags
+	current  OperatorDefaults
+	changes  chan event.GenericEvent
+}
+
+// NewDefaultsStore Returns a store holding the defaults read from the file at
+// path, layered over the built-in defaults. An empty path only serves the
+// built-in defaults.
+func NewDefaultsStore(path string) (*DefaultsStore, error) {
+	s := &DefaultsStore{
+		path:           path,
+		builtin:        builtinDefaults(),
+		builtinVersion: builtinDefaultsVersion,
+		file:           builtinDefaults(),
+		current:        builtinDefaults(),
+		changes:        make(chan event.GenericEvent, 1),
+	}
+	if path == "" {
+		return s, nil
+	}
+	if _, err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get Returns the current defaults.
+func (s *DefaultsStore) Get() OperatorDefaults {
+	if s == nil {
+		return builtinDefaults()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Changes Returns a channel receiving an event every time the defaults change.
+func (s *DefaultsStore) Changes() <-chan event.GenericEvent {
+	return s.changes
+}
+
+// SetFlags Sets the defaults of the command line, which override every
+// other source.
+func (s *DefaultsStore) SetFlags(flags DefaultsFlags) {
+	s.update(func() { s.flags = flags })
+}
+
+// SetConfig Sets the spec of the IpfsOperatorConfig, or removes it when nil,
+// and signals the change of the defaults, if any.
+func (s *DefaultsStore) SetConfig(config *clusterv1alpha1.IpfsOperatorConfigSpec) {
+	if s.update(func() { s.config = config.DeepCopy() }) {
+		s.signal()
+	}
+}
+
+// update Changes a source of the defaults and resolves them again, telling
+// whether they changed.
+func (s *DefaultsStore) update(change func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.current
+	change()
+	s.current = resolveDefaults(s.file, s.config, s.flags)
+	return !equalDefaults(previous, s.current)
+}
+
+// signal Makes every cluster reconcile against the current defaults.
+func (s *DefaultsStore) signal() {
+	// A pending event already makes every cluster reconcile.
+	select {
+	case s.changes <- event.GenericEvent{Object: &clusterv1alpha1.Ipfs{}}:
+	default:
+	}
+}
+
+// load Reads the defaults file and resolves the defaults again, telling
+// whether they changed.
+func (s *DefaultsStore) load() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, fmt.Errorf("cannot read operator defaults: %w", err)
+	}
+	defaults, err := layerFile(s.builtin, data)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse operator defaults %s: %w", s.path, err)
+	}
+	return s.update(func() {
+		s.fileData = data
+		s.file = defaults
+	}), nil
+}
+
+// Start Watches the defaults file until the context is done. Files mounted
+// from a ConfigMap are replaced through a symlink swap, so the directory is
+// watched rather than the file itself. The file is reloaded once its writes
+// settle, and the change is only signalled when the resolved defaults differ.
+func (s *DefaultsStore) Start(ctx context.Context) error {
+	if s.path == "" {
+		<-ctx.Done()
+		return nil
+	}
+	log := ctrllog.FromContext(ctx).WithName("defaults")
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err = watcher.Add(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+	reload := time.NewTimer(defaultsReloadDelay)
+	reload.Stop()
+	defer reload.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err = <-watcher.Errors:
+			log.Error(err, "cannot watch operator defaults")
+		case <-watcher.Events:
+			if !reload.Stop() {
+				select {
+				case <-reload.C:
+				default:
+				}
+			}
+			reload.Reset(defaultsReloadDelay)
+		case <-reload.C:
+			changed, err := s.load()
+			if err != nil {
+				log.Error(err, "keeping previous operator defaults")
+				continue
+			}
+			if !changed {
+				continue
+			}
+			log.Info("reloaded operator defaults", "defaults", s.Get())
+			s.signal()
+		}
+	}
+}
+
+// NeedLeaderElection Makes every replica of the operator reload the defaults.
+func (s *DefaultsStore) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP Writes the effective defaults as JSON.
+func (s *DefaultsStore) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.Get()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// layerFile Returns the defaults of the file layered over the given ones.
+func layerFile(base OperatorDefaults, data []byte) (OperatorDefaults, error) {
+	layered := base
+	layered.MaintenanceWindow = base.MaintenanceWindow.DeepCopy()
+	layered.Resources = *base.Resources.DeepCopy()
+	if err := yaml.UnmarshalStrict(data, &layered); err != nil {
+		return OperatorDefaults{}, err
+	}
+	return layered, nil
+}
+
+func equalDefaults(a, b OperatorDefaults) bool {
+	aj, errA := json.Marshal(a)
+	bj, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aj) == string(bj)
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Operator defaults", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	write := func(contents string) {
+		Expect(os.WriteFile(path, []byte(contents), 0o600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "defaults")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "defaults.yaml")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("serves the built-in defaults without a file", func() {
+		store, err := NewDefaultsStore("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.Get()).To(Equal(builtinDefaults()))
+
+		var missing *DefaultsStore
+		Expect(missing.Get()).To(Equal(builtinDefaults()))
+	})
+
+	It("layers the file over the built-in defaults", func() {
+		write("ipfsImage: ipfs/kubo:v0.17.0\nstorageClassName: fast\nstatusSyncInterval: 30s\n")
+		store, err := NewDefaultsStore(path)
+		Expect(err).NotTo(HaveOccurred())
+		defaults := store.Get()
+		Expect(defaults.IpfsImage).To(Equal("ipfs/kubo:v0.17.0"))
+		Expect(defaults.StorageClassName).To(Equal("fast"))
+		Expect(defaults.StatusSyncInterval.Duration).To(Equal(30 * time.Second))
+		Expect(defaults.ClusterImage).To(Equal(ipfsClusterImage))
+		Expect(defaults.StoragePressureThreshold).To(Equal(int32(85)))
+
+		resolved := defaults.apply(&clusterv1alpha1.Ipfs{})
+		Expect(resolved.Spec.IpfsImage).To(Equal("ipfs/kubo:v0.17.0"))
+		Expect(*resolved.Spec.StorageClassName).To(Equal("fast"))
+	})
+
+	It("resolves the file, the IpfsOperatorConfig and the flags in increasing precedence", func() {
+		write("ipfsImage: file/kubo\nclusterImage: file/cluster\nstorageClassName: file\n" +
+			"budget:\n  maxReplicas: 3\n")
+		store, err := NewDefaultsStore(path)
+		Expect(err).NotTo(HaveOccurred())
+		store.SetFlags(DefaultsFlags{IpfsImage: "flag/kubo"})
+		window := &clusterv1alpha1.MaintenanceWindow{Schedules: []string{"0 2 * * SAT"},
+			Duration: metav1.Duration{Duration: 4 * time.Hour}}
+		store.SetConfig(&clusterv1alpha1.IpfsOperatorConfigSpec{
+			IpfsImage:         "config/kubo",
+			ClusterImage:      "config/cluster",
+			MaintenanceWindow: window,
+			Budget:            &clusterv1alpha1.Budget{MaxReplicas: 5},
+		})
+		Eventually(store.Changes()).Should(Receive())
+
+		defaults := store.Get()
+		Expect(defaults.IpfsImage).To(Equal("flag/kubo"))
+		Expect(defaults.ClusterImage).To(Equal("config/cluster"))
+		Expect(defaults.StorageClassName).To(Equal("file"))
+		Expect(defaults.AuthProxyImage).To(Equal(authProxyImage))
+		Expect(defaults.Budget.MaxReplicas).To(Equal(int32(5)))
+		Expect(defaults.MaintenanceWindow).To(Equal(window))
+
+		resolved := defaults.apply(&clusterv1alpha1.Ipfs{})
+		Expect(resolved.Spec.MaintenanceWindow).To(Equal(window))
+
+		By("falling back to the file once the IpfsOperatorConfig is removed")
+		store.SetConfig(nil)
+		Eventually(store.Changes()).Should(Receive())
+		defaults = store.Get()
+		Expect(defaults.IpfsImage).To(Equal("flag/kubo"))
+		Expect(defaults.ClusterImage).To(Equal("file/cluster"))
+		Expect(defaults.Budget.MaxReplicas).To(Equal(int32(3)))
+		Expect(defaults.MaintenanceWindow).To(BeNil())
+
+		By("not signalling an IpfsOperatorConfig which changes nothing")
+		store.SetConfig(&clusterv1alpha1.IpfsOperatorConfigSpec{ClusterImage: "file/cluster"})
+		Consistently(store.Changes(), 200*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("refuses unknown fields and unreadable files", func() {
+		write("ipfsImages: ipfs/kubo:v0.17.0\n")
+		_, err := NewDefaultsStore(path)
+		Expect(err).To(MatchError(ContainSubstring("cannot parse operator defaults")))
+
+		_, err = NewDefaultsStore(filepath.Join(dir, "missing.yaml"))
+		Expect(err).To(MatchError(ContainSubstring("cannot read operator defaults")))
+	})
+
+	It("reloads the file as it changes and only signals actual changes", func() {
+		write("storageClassName: fast\n")
+		store, err := NewDefaultsStore(path)
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- store.Start(ctx) }()
+		defer func() {
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		}()
+
+		// The watch is set up asynchronously, so keep writing until it sees
+		// the change, slower than the writes settle.
+		Eventually(func() string {
+			write("storageClassName: slow\n")
+			return store.Get().StorageClassName
+		}, 5*time.Second, 4*defaultsReloadDelay).Should(Equal("slow"))
+		Eventually(store.Changes()).Should(Receive())
+
+		By("keeping the previous defaults when the file is invalid")
+		write("storageClassName: [\n")
+		Consistently(func() string { return store.Get().StorageClassName }, 4*defaultsReloadDelay).Should(Equal("slow"))
+
+		By("not signalling a rewrite with the same values")
+		write("storageClassName: slow\n")
+		Consistently(store.Changes(), 4*defaultsReloadDelay).ShouldNot(Receive())
+		Expect(equalDefaults(store.Get(), store.Get())).To(BeTrue())
+	})
+
+	It("serves the effective defaults on /configz", func() {
+		write("authProxyImage: quay.io/example/proxy:v1\n")
+		store, err := NewDefaultsStore(path)
+		Expect(err).NotTo(HaveOccurred())
+		server := httptest.NewServer(store)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/configz")
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+		served := OperatorDefaults{}
+		Expect(json.NewDecoder(resp.Body).Decode(&served)).To(Succeed())
+		Expect(equalDefaults(served, store.Get())).To(BeTrue())
+		Expect(served.AuthProxyImage).To(Equal("quay.io/example/proxy:v1"))
+	})
+})
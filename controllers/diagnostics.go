@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// diagnosis Is a failure found on the objects owned by the cluster.
+type diagnosis struct {
+	reason  string
+	message string
+}
+
+// diagnose Translates the failures of the persistent volume claims, pods and
+// StatefulSet of the cluster into the ProvisioningFailed, QuotaExceeded and
+// Unschedulable conditions. A quota rejecting the claims of a scale-up takes
+// precedence over the failures of the StatefulSet. Conditions are removed
+// once the failure is gone.
+func (r *IpfsReconciler) diagnose(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	claimsRejected *diagnosis,
+) error {
+	provisioning, err := r.diagnoseProvisioning(ctx, instance)
+	if err != nil {
+		return err
+	}
+	quota := claimsRejected
+	if quota == nil {
+		if quota, err = r.diagnoseQuota(ctx, instance); err != nil {
+			return err
+		}
+	}
+	scheduling, err := r.diagnoseScheduling(ctx, instance)
+	if err != nil {
+		return err
+	}
+	setDiagnosisCondition(instance, clusterv1alpha1.ConditionProvisioningFailed, provisioning)
+	setDiagnosisCondition(instance, clusterv1alpha1.ConditionQuotaExceeded, quota)
+	setDiagnosisCondition(instance, clusterv1alpha1.ConditionUnschedulable, scheduling)
+	return nil
+}
+
+// diagnoseProvisioning Looks for claims of the cluster stuck pending, either
+// because their storage class does not exist or because their provisioner
+// reports an error.
+func (r *IpfsReconciler) diagnoseProvisioning(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) (*diagnosis, error) {
+	claims := corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, &claims, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return nil, fmt.Errorf("cannot list peer volume claims: %w", err)
+	}
+	sort.Slice(claims.Items, func(i, j int) bool { return claims.Items[i].Name < claims.Items[j].Name })
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if claim.Status.Phase != corev1.ClaimPending {
+			continue
+		}
+		if name := claim.Spec.StorageClassName; name != nil && *name != "" {
+			sc := storagev1.StorageClass{}
+			err := r.Get(ctx, client.ObjectKey{Name: *name}, &sc)
+			if errors.IsNotFound(err) {
+				return &diagnosis{
+					reason:  clusterv1alpha1.ProvisioningFailedReasonStorageClassNotFound,
+					message: fmt.Sprintf("claim %s requests storage class %s, which does not exist", claim.Name, *name),
+				}, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot get storage class %s: %w", *name, err)
+			}
+		}
+		event, err := r.latestEvent(ctx, claim.Namespace, "PersistentVolumeClaim", claim.Name, "ProvisioningFailed")
+		if err != nil {
+			return nil, err
+		}
+		if event != nil {
+			return &diagnosis{
+				reason:  clusterv1alpha1.ProvisioningFailedReasonProvisionerError,
+				message: fmt.Sprintf("claim %s: %s", claim.Name, event.Message),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// diagnoseQuota Looks for a quota preventing the StatefulSet from creating
+// the pods or claims of missing peers.
+func (r *IpfsReconciler) diagnoseQuota(ctx context.Context, instance *clusterv1alpha1.Ipfs) (*diagnosis, error) {
+	sts := appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: "ipfs-cluster-" + instance.Name}
+	if err := r.Get(ctx, key, &sts); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot get statefulset: %w", err)
+	}
+	if sts.Status.Replicas >= peerCount(instance) {
+		return nil, nil
+	}
+	event, err := r.latestEvent(ctx, sts.Namespace, "StatefulSet", sts.Name, "FailedCreate")
+	if err != nil || event == nil || !strings.Contains(event.Message, "exceeded quota") {
+		return nil, err
+	}
+	return &diagnosis{
+		reason:  clusterv1alpha1.QuotaExceededReasonFailedCreate,
+		message: event.Message,
+	}, nil
+}
+
+// diagnoseScheduling Looks for peer pods the scheduler found no node for.
+func (r *IpfsReconciler) diagnoseScheduling(ctx context.Context, instance *clusterv1alpha1.Ipfs) (*diagnosis, error) {
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return nil, fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse &&
+				cond.Reason == corev1.PodReasonUnschedulable {
+				return &diagnosis{
+					reason:  clusterv1alpha1.UnschedulableReasonNoFit,
+					message: fmt.Sprintf("pod %s: %s", pod.Name, cond.Message),
+				}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// latestEvent Returns the most recent event with the given reason about the
+// named object, or nil when there is none. Events are read straight from the
+// API server rather than cached.
+func (r *IpfsReconciler) latestEvent(
+	ctx context.Context,
+	namespace, kind, name, reason string,
+) (*corev1.Event, error) {
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	events := corev1.EventList{}
+	if err := reader.List(ctx, &events, client.InNamespace(namespace),
+		client.MatchingFieldsSelector{Selector: fields.SelectorFromSet(fields.Set{
+			"involvedObject.kind": kind,
+			"involvedObject.name": name,
+			"reason":              reason,
+		})}); err != nil {
+		return nil, fmt.Errorf("cannot list events of %s %s: %w", kind, name, err)
+	}
+	var latest *corev1.Event
+	for i := range events.Items {
+		if latest == nil || eventTime(&events.Items[i]).After(eventTime(latest)) {
+			latest = &events.Items[i]
+		}
+	}
+	return latest, nil
+}
+
+// eventTime Returns when the event was last seen.
+func eventTime(event *corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if event.Series != nil {
+		return event.Series.LastObservedTime.Time
+	}
+	return event.EventTime.Time
+}
+
+// setDiagnosisCondition Sets the condition of the given type from the
+// diagnosis, or removes it when there is nothing to report.
+func setDiagnosisCondition(instance *clusterv1alpha1.Ipfs, condType string, d *diagnosis) {
+	if d == nil {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, condType)
+		return
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionTrue,
+		Reason:             d.reason,
+		Message:            d.message,
+		ObservedGeneration: instance.Generation,
+	})
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+const (
+	// diagnosticsClusterKey Is the key of the diagnostics bundle holding
+	// what the REST API of the cluster reports.
+	diagnosticsClusterKey = "cluster.yaml"
+	// diagnosticsMaxItems Bounds every list of the bundle, so that the
+	// bundle of a large cluster fits in a ConfigMap.
+	diagnosticsMaxItems = 50
+	// diagnosticsMaxBytes Bounds every value of the bundle.
+	diagnosticsMaxBytes = 64 << 10
+)
+
+// peerDiagnostics Is what the kubo node of a peer reports.
+type peerDiagnostics struct {
+	ID             string   `json:"id,omitempty"`
+	AgentVersion   string   `json:"agentVersion,omitempty"`
+	Addresses      []string `json:"addresses,omitempty"`
+	ConnectedPeers *int     `json:"connectedPeers,omitempty"`
+	RepoSize       *uint64  `json:"repoSize,omitempty"`
+	StorageMax     *uint64  `json:"storageMax,omitempty"`
+	NumObjects     *uint64  `json:"numObjects,omitempty"`
+	// Truncated Counts the items left out of each list.
+	Truncated map[string]int `json:"truncated,omitempty"`
+	Errors    []string       `json:"errors,omitempty"`
+}
+
+// clusterDiagnostics Is what the REST API of the cluster reports: its
+// peerset, and the pins in error on some peer, latest first.
+type clusterDiagnostics struct {
+	CollectedAt metav1.Time         `json:"collectedAt"`
+	Peers       []clusterapi.Peer   `json:"peers,omitempty"`
+	PinErrors   []pinDiagnostics    `json:"pinErrors,omitempty"`
+	Conditions  []metav1.Condition  `json:"conditions,omitempty"`
+	PeerStatus  []peerStatusSummary `json:"peerStatus,omitempty"`
+	// Truncated Counts the items left out of each list.
+	Truncated map[string]int `json:"truncated,omitempty"`
+	Errors    []string       `json:"errors,omitempty"`
+}
+
+// pinDiagnostics Is the error of a pin on a peer.
+type pinDiagnostics struct {
+	Cid       string    `json:"cid"`
+	Peer      string    `json:"peer"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// peerStatusSummary Is the status the operator recorded for a peer.
+type peerStatusSummary struct {
+	Name   string `json:"name"`
+	ID     string `json:"id,omitempty"`
+	KuboID string `json:"kuboID,omitempty"`
+}
+
+// diagnosticsName Returns the name of the ConfigMap of the diagnostics
+// bundle collected at the given time.
+func diagnosticsName(m *clusterv1alpha1.Ipfs, at time.Time) string {
+	return m.Name + "-diagnostics-" + at.UTC().Format("20060102-150405")
+}
+
+// reconcileDiagnostics Collects a diagnostics bundle when the
+// collect-diagnostics annotation is "true": what the kubo node of every peer
+// and the REST API of the cluster report, the values of the Secrets of the
+// cluster redacted, in a new ConfigMap owned by the cluster. The operator
+// then removes the annotation and emits an event naming the ConfigMap. Peers
+// and APIs which cannot be reached are recorded as errors of the bundle.
+func (r *IpfsReconciler) reconcileDiagnostics(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if instance.Annotations[clusterv1alpha1.AnnotationCollectDiagnostics] != "true" {
+		return nil
+	}
+	now := time.Now()
+	data := map[string]string{}
+	for ordinal := int32(0); ordinal < peerCount(instance); ordinal++ {
+		pod := peerPodName(instance, ordinal)
+		out, err := yaml.Marshal(r.collectPeerDiagnostics(ctx, instance, ordinal))
+		if err != nil {
+			return fmt.Errorf("cannot serialize diagnostics of %s: %w", pod, err)
+		}
+		data[pod+".yaml"] = string(out)
+	}
+	out, err := yaml.Marshal(r.collectClusterDiagnostics(ctx, instance, now))
+	if err != nil {
+		return fmt.Errorf("cannot serialize cluster diagnostics: %w", err)
+	}
+	data[diagnosticsClusterKey] = string(out)
+	secrets, err := r.secretValues(ctx, instance)
+	if err != nil {
+		return err
+	}
+	for key, value := range data {
+		data[key] = truncate(redact(value, secrets), diagnosticsMaxBytes)
+	}
+
+	cm := corev1.ConfigMap{}
+	cm.Name = diagnosticsName(instance, now)
+	cm.Namespace = instance.Namespace
+	cm.Labels = ipfsLabels(instance, componentDiagnostics)
+	cm.Data = data
+	if err = ctrl.SetControllerReference(instance, &cm, r.Scheme); err != nil {
+		return err
+	}
+	if err = r.Create(ctx, &cm); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("cannot write diagnostics bundle: %w", err)
+	}
+	updated := instance.DeepCopy()
+	delete(updated.Annotations, clusterv1alpha1.AnnotationCollectDiagnostics)
+	if err = r.Patch(ctx, updated, client.MergeFrom(instance)); err != nil {
+		return fmt.Errorf("cannot remove %s annotation: %w", clusterv1alpha1.AnnotationCollectDiagnostics, err)
+	}
+	instance.Annotations = updated.Annotations
+	instance.ResourceVersion = updated.ResourceVersion
+	r.eventf(instance, corev1.EventTypeNormal, "DiagnosticsCollected",
+		"wrote the diagnostics bundle to ConfigMap %s", cm.Name)
+	return nil
+}
+
+// collectPeerDiagnostics Returns what the kubo node of the peer with the
+// given ordinal reports. The commands which fail are recorded as errors.
+func (r *IpfsReconciler) collectPeerDiagnostics(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	ordinal int32,
+) peerDiagnostics {
+	diag := peerDiagnostics{Truncated: map[string]int{}}
+	api, err := r.kuboAPI(m, ordinal)
+	if err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+		return diag
+	}
+	if id, err := api.ID(ctx); err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+	} else {
+		diag.ID = id.ID
+		diag.AgentVersion = id.AgentVersion
+		diag.Addresses = id.Addresses
+	}
+	if peers, err := api.SwarmPeers(ctx); err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+	} else {
+		connected := len(peers)
+		diag.ConnectedPeers = &connected
+	}
+	if stat, err := api.RepoStat(ctx); err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+	} else {
+		diag.RepoSize = &stat.RepoSize
+		diag.StorageMax = &stat.StorageMax
+		diag.NumObjects = &stat.NumObjects
+	}
+	if len(diag.Addresses) > diagnosticsMaxItems {
+		diag.Truncated["addresses"] = len(diag.Addresses) - diagnosticsMaxItems
+		diag.Addresses = diag.Addresses[:diagnosticsMaxItems]
+	}
+	return diag
+}
+
+// collectClusterDiagnostics Returns what the REST API of the cluster reports,
+// along with the conditions and the peers recorded in the status. Long lists
+// are truncated, and the number of items left out is recorded.
+func (r *IpfsReconciler) collectClusterDiagnostics(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	now time.Time,
+) clusterDiagnostics {
+	diag := clusterDiagnostics{
+		CollectedAt: metav1.NewTime(now),
+		Conditions:  m.Status.Conditions,
+		Truncated:   map[string]int{},
+	}
+	for _, p := range m.Status.Peers {
+		diag.PeerStatus = append(diag.PeerStatus, peerStatusSummary{Name: p.Name, ID: p.ID, KuboID: p.KuboID})
+	}
+	api, err := r.clusterAPI(ctx, m)
+	if err == nil {
+		diag.Peers, err = api.Peers(ctx)
+	}
+	if err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+	}
+	if len(diag.Peers) > diagnosticsMaxItems {
+		diag.Truncated["peers"] = len(diag.Peers) - diagnosticsMaxItems
+		diag.Peers = diag.Peers[:diagnosticsMaxItems]
+	}
+	var pins []clusterapi.GlobalPinInfo
+	if api != nil {
+		if pins, err = api.StatusAll(ctx); err != nil {
+			diag.Errors = append(diag.Errors, err.Error())
+		}
+	}
+	for _, pin := range pins {
+		for peer, info := range pin.PeerMap {
+			switch info.Status {
+			case clusterapi.TrackerStatusPinError, clusterapi.TrackerStatusUnpinError,
+				clusterapi.TrackerStatusClusterErr:
+				diag.PinErrors = append(diag.PinErrors, pinDiagnostics{
+					Cid:       string(pin.Cid),
+					Peer:      peer,
+					Status:    string(info.Status),
+					Error:     info.Error,
+					Timestamp: info.Timestamp,
+				})
+			}
+		}
+	}
+	sort.Slice(diag.PinErrors, func(i, j int) bool {
+		return diag.PinErrors[i].Timestamp.After(diag.PinErrors[j].Timestamp)
+	})
+	if len(diag.PinErrors) > diagnosticsMaxItems {
+		diag.Truncated["pinErrors"] = len(diag.PinErrors) - diagnosticsMaxItems
+		diag.PinErrors = diag.PinErrors[:diagnosticsMaxItems]
+	}
+	return diag
+}
+
+// secretValues Returns the values held by the Secrets of the cluster and
+// those it references, to redact them from the bundle. Values too short to be
+// secrets, such as the usernames of the REST API, are kept.
+func (r *IpfsReconciler) secretValues(ctx context.Context, m *clusterv1alpha1.Ipfs) ([]string, error) {
+	names := append([]string{identitiesSecretName(m), clusterSecretName(m), legacyConfigSecretName(m),
+		apiCredentialsName(m), ctlCredentialsName(m)},
+		referencedSecrets(m)...)
+	var values []string
+	for _, name := range names {
+		sec := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: name}, &sec); err != nil {
+			if client.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("cannot get secret %s: %w", name, err)
+			}
+			continue
+		}
+		for _, value := range sec.Data {
+			if len(value) >= 12 {
+				values = append(values, string(value))
+			}
+		}
+	}
+	return values, nil
+}
+
+// redact Replaces the given secrets in the text.
+func redact(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// truncate Cuts the text down to the given number of bytes, noting how many
+// were left out.
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	return text[:max] + fmt.Sprintf("\n# %d more bytes left out\n", len(text)-max)
+}
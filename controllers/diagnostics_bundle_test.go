@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs diagnostics bundle", func() {
+	const clusterSecret = "9f2e7c4b1a3d5e6f7a8b9c0d1e2f3a4b"
+
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		cluster    *clusterfake.Cluster
+		node       *kubofake.Node
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "support"
+		instance.Namespace = "default"
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationCollectDiagnostics: "true"}
+		instance.Spec.Replicas = 2
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{{Name: "ipfs-cluster-support-0", ID: "12D3KooWC"}}
+		sec := &corev1.Secret{}
+		sec.Name = "ipfs-cluster-support"
+		sec.Namespace = instance.Namespace
+		sec.Data = map[string][]byte{"CLUSTER_SECRET": []byte(clusterSecret)}
+		api := &corev1.Secret{}
+		api.Name = apiCredentialsName(instance)
+		api.Namespace = instance.Namespace
+		api.Data = map[string][]byte{apiCredentialsEnv: []byte("admin:4d1c8e0b7f2a9365")}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, sec, api).Build()
+
+		cluster = clusterfake.NewCluster()
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWC", Peername: "ipfs-cluster-support-0"})
+		node = kubofake.NewNode("12D3KooWA")
+		for i := 0; i < diagnosticsMaxItems+10; i++ {
+			node.Identity.Addresses = append(node.Identity.Addresses, fmt.Sprintf("/ip4/10.0.0.%d/tcp/4001", i))
+		}
+		node.Errs["repo/stat"] = fmt.Errorf("cannot open repo with secret %s", clusterSecret)
+		dialer := kubofake.NewDialer()
+		dialer.Add(instance.Namespace, "ipfs-cluster-support-0", node)
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{
+			Client:   fakeClient,
+			Scheme:   scheme,
+			Recorder: recorder,
+			Kubo:     dialer,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	bundle := func() *corev1.ConfigMap {
+		list := &corev1.ConfigMapList{}
+		Expect(fakeClient.List(ctx, list, client.InNamespace(instance.Namespace),
+			client.MatchingLabels{labelComponent: componentDiagnostics})).To(Succeed())
+		Expect(list.Items).To(HaveLen(1))
+		return &list.Items[0]
+	}
+
+	It("writes what the peers and the cluster report into a new ConfigMap", func() {
+		Expect(clusterapi.New(cluster.URL()).Pin(ctx, "bafyok", clusterapi.PinOptions{})).NotTo(BeNil())
+		cluster.FailPinning("bafyfailing", 1)
+		_, err := clusterapi.New(cluster.URL()).Pin(ctx, "bafyfailing", clusterapi.PinOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(reconciler.reconcileDiagnostics(ctx, instance)).To(Succeed())
+		cm := bundle()
+		Expect(cm.Name).To(MatchRegexp(`^support-diagnostics-\d{8}-\d{6}$`))
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+		Expect(cm.Data).To(HaveKey("cluster.yaml"))
+		Expect(cm.Data).To(HaveKey("ipfs-cluster-support-0.yaml"))
+		Expect(cm.Data).To(HaveKey("ipfs-cluster-support-1.yaml"))
+
+		peer := peerDiagnostics{}
+		Expect(yaml.Unmarshal([]byte(cm.Data["ipfs-cluster-support-0.yaml"]), &peer)).To(Succeed())
+		Expect(peer.ID).To(Equal("12D3KooWA"))
+		Expect(peer.Addresses).To(HaveLen(diagnosticsMaxItems))
+		Expect(peer.Truncated).To(HaveKeyWithValue("addresses", 10))
+		Expect(*peer.ConnectedPeers).To(Equal(0))
+		Expect(peer.RepoSize).To(BeNil())
+		Expect(peer.Errors).To(ConsistOf("cannot open repo with secret <redacted>"))
+
+		By("recording the peers which cannot be reached")
+		missing := peerDiagnostics{}
+		Expect(yaml.Unmarshal([]byte(cm.Data["ipfs-cluster-support-1.yaml"]), &missing)).To(Succeed())
+		Expect(missing.Errors).To(ConsistOf(ContainSubstring("no kubo node")))
+
+		diag := clusterDiagnostics{}
+		Expect(yaml.Unmarshal([]byte(cm.Data["cluster.yaml"]), &diag)).To(Succeed())
+		Expect(diag.Peers).To(ConsistOf(HaveField("ID", "12D3KooWC")))
+		Expect(diag.PinErrors).To(ConsistOf(pinDiagnostics{
+			Cid:       "bafyfailing",
+			Peer:      "12D3KooWC",
+			Status:    string(clusterapi.TrackerStatusPinError),
+			Error:     "context deadline exceeded",
+			Timestamp: diag.PinErrors[0].Timestamp,
+		}))
+		Expect(diag.PeerStatus).To(ConsistOf(peerStatusSummary{Name: "ipfs-cluster-support-0", ID: "12D3KooWC"}))
+		for _, value := range cm.Data {
+			Expect(value).NotTo(ContainSubstring(clusterSecret))
+			Expect(value).NotTo(ContainSubstring("4d1c8e0b7f2a9365"))
+		}
+
+		By("clearing the annotation and naming the bundle in an event")
+		latest := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), latest)).To(Succeed())
+		Expect(latest.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationCollectDiagnostics))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring("DiagnosticsCollected"), ContainSubstring(cm.Name))))
+
+		By("collecting nothing more until asked again")
+		Expect(reconciler.reconcileDiagnostics(ctx, latest)).To(Succeed())
+		bundle()
+	})
+
+	It("bounds the size of the bundle", func() {
+		text := strings.Repeat("x", diagnosticsMaxBytes+100)
+		truncated := truncate(text, diagnosticsMaxBytes)
+		Expect(len(truncated)).To(BeNumerically("<", diagnosticsMaxBytes+64))
+		Expect(truncated).To(HaveSuffix("# 100 more bytes left out\n"))
+		Expect(truncate("short", diagnosticsMaxBytes)).To(Equal("short"))
+	})
+})
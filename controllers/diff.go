@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// redacted Replaces the values of Secrets in the logged diffs.
+const redacted = "<redacted>"
+
+// diffLogger Returns the logger of the changes the operator makes to the
+// objects of the cluster, and whether they are logged at all. They are logged
+// at the diff log level of the operator, or always for the clusters with the
+// debug-diff annotation.
+func (r *IpfsReconciler) diffLogger(ctx context.Context, instance *clusterv1alpha1.Ipfs) (logr.Logger, bool) {
+	log := ctrllog.FromContext(ctx).WithName("diff")
+	if instance.Annotations[clusterv1alpha1.AnnotationDebugDiff] == "true" {
+		return log, true
+	}
+	if r.DiffLogLevel != nil {
+		return log.V(*r.DiffLogLevel), true
+	}
+	return log, false
+}
+
+// loggingDiff Returns a mutate function logging the changes the given mutate
+// function makes to the object. The changes of an existing object are logged
+// as the JSON merge patch controllerutil.CreateOrPatch sends, so that an
+// object is logged as changed exactly when it is patched. A new object is
+// logged whole. The values held by Secrets are redacted.
+func loggingDiff(log logr.Logger, kind string, obj client.Object, mut controllerutil.MutateFn) controllerutil.MutateFn {
+	if mut == nil {
+		return nil
+	}
+	return func() error {
+		before, ok := obj.DeepCopyObject().(client.Object)
+		if !ok {
+			return mut()
+		}
+		if err := mut(); err != nil {
+			return err
+		}
+		operation := "patch"
+		var diff []byte
+		var err error
+		if before.GetResourceVersion() == "" {
+			operation = "create"
+			diff, err = json.Marshal(obj)
+		} else {
+			diff, err = client.MergeFrom(before).Data(obj)
+		}
+		if err != nil {
+			log.Error(err, "cannot compute the changes of the object", "objName", obj.GetName(), "objKind", kind)
+			return nil
+		}
+		if string(diff) == "{}" {
+			return nil
+		}
+		if _, ok := obj.(*corev1.Secret); ok {
+			diff = redactSecret(diff)
+		}
+		log.Info("object changes", "objName", obj.GetName(), "objKind", kind,
+			"operation", operation, "diff", string(diff))
+		return nil
+	}
+}
+
+// redactSecret Replaces the values of the data and stringData of the
+// serialized Secret, keeping their keys and the removed keys visible.
+func redactSecret(diff []byte) []byte {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(diff, &fields); err != nil {
+		return []byte(redacted)
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := fields[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k, v := range values {
+			if v != nil {
+				values[k] = redacted
+			}
+		}
+	}
+	// The marker is written as is rather than HTML-escaped.
+	var redactedDiff bytes.Buffer
+	enc := json.NewEncoder(&redactedDiff)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return []byte(redacted)
+	}
+	return bytes.TrimSuffix(redactedDiff.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs diff logging", func() {
+	var (
+		lines []string
+		log   logr.Logger
+	)
+
+	BeforeEach(func() {
+		lines = nil
+		log = funcr.New(func(_, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{Verbosity: 1})
+	})
+
+	It("logs the merge patch sent for an existing object", func() {
+		svc := &corev1.Service{}
+		svc.Name = "ipfs-cluster-diff"
+		svc.ResourceVersion = "7"
+		svc.Spec.Ports = []corev1.ServicePort{{Name: "swarm", Port: 4001}}
+		svc.Spec.ClusterIP = "10.96.0.12"
+		mut := loggingDiff(log, "Service", svc, func() error {
+			svc.Spec.Ports[0].Port = 4002
+			return nil
+		})
+		Expect(mut()).To(Succeed())
+		Expect(svc.Spec.Ports[0].Port).To(Equal(int32(4002)))
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(ContainSubstring(`"operation"="patch"`))
+		Expect(lines[0]).To(ContainSubstring(`4002`))
+		Expect(lines[0]).NotTo(ContainSubstring("10.96.0.12"))
+
+		By("staying quiet when nothing changes")
+		Expect(loggingDiff(log, "Service", svc, func() error { return nil })()).To(Succeed())
+		Expect(lines).To(HaveLen(1))
+	})
+
+	It("redacts the values of Secrets", func() {
+		sec := &corev1.Secret{}
+		sec.Name = "ipfs-cluster-diff"
+		sec.Data = map[string][]byte{"CLUSTER_SECRET": []byte("0123456789abcdef")}
+		Expect(loggingDiff(log, "Secret", sec, func() error { return nil })()).To(Succeed())
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(ContainSubstring(`"operation"="create"`))
+		Expect(lines[0]).To(ContainSubstring("CLUSTER_SECRET"))
+		Expect(lines[0]).To(ContainSubstring(redacted))
+		Expect(lines[0]).NotTo(ContainSubstring("MDEyMzQ1Njc4OWFiY2RlZg"))
+
+		sec.ResourceVersion = "3"
+		Expect(loggingDiff(log, "Secret", sec, func() error {
+			sec.Data["CLUSTER_SECRET"] = []byte("fedcba9876543210")
+			delete(sec.Data, "OLD_KEY")
+			return nil
+		})()).To(Succeed())
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[1]).NotTo(ContainSubstring("ZmVkY2JhOTg3NjU0MzIxMA"))
+	})
+
+	It("logs the clusters with the debug-diff annotation", func() {
+		reconciler := &IpfsReconciler{}
+		ctx := ctrllog.IntoContext(context.Background(), log)
+		instance := &clusterv1alpha1.Ipfs{}
+		_, enabled := reconciler.diffLogger(ctx, instance)
+		Expect(enabled).To(BeFalse())
+
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationDebugDiff: "true"}
+		_, enabled = reconciler.diffLogger(ctx, instance)
+		Expect(enabled).To(BeTrue())
+
+		level := 2
+		reconciler.DiffLogLevel = &level
+		instance.Annotations = nil
+		diffLog, enabled := reconciler.diffLogger(ctx, instance)
+		Expect(enabled).To(BeTrue())
+		Expect(diffLog.V(0).Enabled()).To(BeFalse())
+	})
+})
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Annotations understood by external-dns.
+const (
+	annotationExternalDNSHostname = "external-dns.alpha.kubernetes.io/hostname"
+	annotationExternalDNSTTL      = "external-dns.alpha.kubernetes.io/ttl"
+)
+
+// dnsLookupTimeout Bounds how long a single verification lookup may take.
+const dnsLookupTimeout = 5 * time.Second
+
+// lookupHost Resolves a hostname. It is a variable so that it can be replaced in tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// applyDNSAnnotations Sets the external-dns annotations requested in the spec
+// on the given object, removing them when the hostname is no longer requested
+// or when the object is not the one exposing the gateway, so that
+// external-dns cleans up the record.
+func applyDNSAnnotations(m *clusterv1alpha1.Ipfs, obj client.Object, exposing bool) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	delete(annotations, annotationExternalDNSHostname)
+	delete(annotations, annotationExternalDNSTTL)
+	if dns := m.Spec.Expose.DNS; exposing && dns != nil && dns.Hostname != "" {
+		annotations[annotationExternalDNSHostname] = dns.Hostname
+		if dns.TTL != nil {
+			annotations[annotationExternalDNSTTL] = strconv.FormatInt(*dns.TTL, 10)
+		}
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// verifyDNS Resolves the requested hostname and records whether it points at
+// the address of the exposed Service, or of the Ingress when the cluster is
+// not public, in the DNSReady condition. It returns true once the record is
+// in place, or when no verification was requested.
+func (r *IpfsReconciler) verifyDNS(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) (bool, error) {
+	dns := instance.Spec.Expose.DNS
+	if !dnsExposed(instance) || dns == nil || dns.Hostname == "" || !dns.Verify {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionDNSReady)
+		return true, nil
+	}
+	name := gatewayServiceName(instance)
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: name}
+	var lb []corev1.LoadBalancerIngress
+	if instance.Spec.Public {
+		svc := corev1.Service{}
+		if err := r.Get(ctx, key, &svc); err != nil {
+			return false, fmt.Errorf("cannot get exposed service %s: %w", name, err)
+		}
+		lb = svc.Status.LoadBalancer.Ingress
+	} else {
+		ing := networkingv1.Ingress{}
+		if err := r.Get(ctx, key, &ing); err != nil {
+			return false, fmt.Errorf("cannot get exposed ingress %s: %w", name, err)
+		}
+		lb = ing.Status.LoadBalancer.Ingress
+	}
+
+	ready, message := dnsPointsAt(ctx, dns.Hostname, name, lb)
+	condition := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionDNSReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.DNSReadyReasonPending,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	}
+	if ready {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = clusterv1alpha1.DNSReadyReasonResolved
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+	return ready, nil
+}
+
+// dnsExposed Returns whether the gateway is exposed through an object
+// external-dns registers records for: the LoadBalancer Service of a public
+// cluster, or else the Ingress of spec.expose.host.
+func dnsExposed(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Public || m.Spec.Expose.Host != ""
+}
+
+// dnsPointsAt Reports whether hostname resolves to one of the load balancer
+// addresses of the named exposed object, along with a message explaining the result.
+func dnsPointsAt(ctx context.Context, hostname, name string, lb []corev1.LoadBalancerIngress) (bool, string) {
+	expected := make(map[string]bool)
+	for _, ingress := range lb {
+		if ingress.IP != "" {
+			expected[ingress.IP] = true
+		}
+		if ingress.Hostname != "" {
+			// Load balancers that only publish a hostname are matched
+			// through the addresses that hostname resolves to.
+			addrs, err := lookupWithTimeout(ctx, ingress.Hostname)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				expected[addr] = true
+			}
+		}
+	}
+	if len(expected) == 0 {
+		return false, "waiting for the load balancer address of " + name
+	}
+
+	addrs, err := lookupWithTimeout(ctx, hostname)
+	if err != nil {
+		return false, fmt.Sprintf("cannot resolve %s: %v", hostname, err)
+	}
+	for _, addr := range addrs {
+		if expected[addr] {
+			return true, fmt.Sprintf("%s resolves to %s", hostname, addr)
+		}
+	}
+	return false, fmt.Sprintf("%s resolves to %v which does not match the load balancer", hostname, addrs)
+}
+
+func lookupWithTimeout(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+	defer cancel()
+	return lookupHost(ctx, host)
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs DNS records", func() {
+	var (
+		ctx      context.Context
+		instance *clusterv1alpha1.Ipfs
+		scheme   *runtime.Scheme
+		records  map[string][]string
+		restore  func(context.Context, string) ([]string, error)
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "dns"
+		instance.Namespace = "default"
+		ttl := int64(300)
+		instance.Spec.Expose.DNS = &clusterv1alpha1.DNSConfig{Hostname: "gateway.example.com", TTL: &ttl, Verify: true}
+
+		records = map[string][]string{}
+		restore = lookupHost
+		lookupHost = func(_ context.Context, host string) ([]string, error) {
+			addrs, ok := records[host]
+			if !ok {
+				return nil, fmt.Errorf("no such host %s", host)
+			}
+			return addrs, nil
+		}
+	})
+
+	AfterEach(func() {
+		lookupHost = restore
+	})
+
+	It("merges the external-dns annotations with the others and removes them", func() {
+		svc := &corev1.Service{}
+		svc.Annotations = map[string]string{"example.com/owner": "team"}
+		applyDNSAnnotations(instance, svc, true)
+		Expect(svc.Annotations).To(Equal(map[string]string{
+			"example.com/owner":                         "team",
+			"external-dns.alpha.kubernetes.io/hostname": "gateway.example.com",
+			"external-dns.alpha.kubernetes.io/ttl":      "300",
+		}))
+
+		By("leaving them off the object not exposing the gateway")
+		applyDNSAnnotations(instance, svc, false)
+		Expect(svc.Annotations).To(Equal(map[string]string{"example.com/owner": "team"}))
+
+		By("removing them once the hostname is no longer requested")
+		applyDNSAnnotations(instance, svc, true)
+		instance.Spec.Expose.DNS = nil
+		applyDNSAnnotations(instance, svc, true)
+		Expect(svc.Annotations).To(Equal(map[string]string{"example.com/owner": "team"}))
+	})
+
+	It("annotates the ingress unless the cluster is public", func() {
+		instance.Spec.Expose.Host = "gateway.example.com"
+		reconciler := &IpfsReconciler{Scheme: scheme}
+		ing := &networkingv1.Ingress{}
+		mut, _ := reconciler.ingressGateway(instance, ing, false)
+		Expect(mut()).To(Succeed())
+		Expect(ing.Annotations).To(HaveKeyWithValue(annotationExternalDNSHostname, "gateway.example.com"))
+
+		instance.Spec.Public = true
+		Expect(mut()).To(Succeed())
+		Expect(ing.Annotations).NotTo(HaveKey(annotationExternalDNSHostname))
+	})
+
+	It("verifies the record of the load balancer service", func() {
+		instance.Spec.Public = true
+		svc := &corev1.Service{}
+		svc.Name = gatewayServiceName(instance)
+		svc.Namespace = instance.Namespace
+		reconciler := &IpfsReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(svc).Build(),
+			Scheme: scheme,
+		}
+
+		ready, err := reconciler.verifyDNS(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ready).To(BeFalse())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDNSReady)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.DNSReadyReasonPending))
+		Expect(cond.Message).To(ContainSubstring("waiting for the load balancer address"))
+
+		By("resolving the hostname to the load balancer")
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{Hostname: "lb.example.net"}}
+		Expect(reconciler.Status().Update(ctx, svc)).To(Succeed())
+		records["lb.example.net"] = []string{"192.0.2.10"}
+		records["gateway.example.com"] = []string{"192.0.2.99"}
+		ready, err = reconciler.verifyDNS(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ready).To(BeFalse())
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionDNSReady).Message).To(ContainSubstring("does not match"))
+
+		records["gateway.example.com"] = []string{"192.0.2.10"}
+		ready, err = reconciler.verifyDNS(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ready).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionDNSReady)).To(BeTrue())
+	})
+
+	It("verifies the record of the ingress of a cluster which is not public", func() {
+		instance.Spec.Expose.Host = "gateway.example.com"
+		ing := &networkingv1.Ingress{}
+		ing.Name = gatewayServiceName(instance)
+		ing.Namespace = instance.Namespace
+		ing.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.0.2.20"}}
+		reconciler := &IpfsReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(ing).Build(),
+			Scheme: scheme,
+		}
+		records["gateway.example.com"] = []string{"192.0.2.20"}
+
+		ready, err := reconciler.verifyDNS(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ready).To(BeTrue())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDNSReady)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.DNSReadyReasonResolved))
+
+		By("dropping the condition once verification is no longer requested")
+		instance.Spec.Expose.DNS.Verify = false
+		ready, err = reconciler.verifyDNS(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ready).To(BeTrue())
+		Expect(meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDNSReady)).To(BeNil())
+	})
+})
@@ -0,0 +1,399 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+// These tests run the controllers started by the suite against the API
+// server of testEnv, which validates the objects they write but runs no
+// other controller: no pod is ever scheduled and nothing is garbage collected.
+var _ = Describe("Ipfs reconcile end to end", func() {
+	const (
+		timeout  = 30 * time.Second
+		interval = 250 * time.Millisecond
+	)
+	var (
+		ctx       context.Context
+		namespace string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "e2e-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		namespace = ns.Name
+	})
+
+	// apply Creates the Ipfs resource of the given file under testdata/e2e,
+	// under another name when one is given.
+	apply := func(file, name string) *clusterv1alpha1.Ipfs {
+		data, err := os.ReadFile(filepath.Join("testdata", "e2e", file))
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(yaml.Unmarshal(data, instance)).To(Succeed())
+		instance.Namespace = namespace
+		if name != "" {
+			instance.Name = name
+		}
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		return instance
+	}
+
+	get := func(name string, obj client.Object) {
+		key := types.NamespacedName{Namespace: namespace, Name: name}
+		Eventually(func() error { return k8sClient.Get(ctx, key, obj) }, timeout, interval).Should(Succeed())
+	}
+
+	// reconciled Waits for the cluster to be reconciled and returns it.
+	reconciled := func(instance *clusterv1alpha1.Ipfs) *clusterv1alpha1.Ipfs {
+		current := &clusterv1alpha1.Ipfs{}
+		Eventually(func() metav1.ConditionStatus {
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), current); err != nil {
+				return ""
+			}
+			cond := meta.FindStatusCondition(current.Status.Conditions, clusterv1alpha1.ConditionReconciled)
+			if cond == nil || current.Status.ObservedGeneration != current.Generation {
+				return ""
+			}
+			return cond.Status
+		}, timeout, interval).Should(Equal(metav1.ConditionTrue))
+		return current
+	}
+
+	// scripts Returns the scripts of the peers, sorted by name, for comparison
+	// with a golden file.
+	scripts := func(instance *clusterv1alpha1.Ipfs) string {
+		cm := &corev1.ConfigMap{}
+		get("ipfs-cluster-scripts-"+instance.Name, cm)
+		keys := make([]string, 0, len(cm.Data))
+		for k := range cm.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		for _, k := range keys {
+			b.WriteString("# " + k + "\n" + cm.Data[k] + "\n")
+		}
+		return b.String()
+	}
+
+	portNames := func(svc *corev1.Service) []string {
+		names := make([]string, 0, len(svc.Spec.Ports))
+		for _, p := range svc.Spec.Ports {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	containerNames := func(containers []corev1.Container) []string {
+		names := make([]string, 0, len(containers))
+		for _, c := range containers {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	It("creates the objects of a minimal cluster", func() {
+		instance := reconciled(apply("minimal.yaml", ""))
+		name := "ipfs-cluster-" + instance.Name
+		Expect(instance.Finalizers).To(ContainElement(finalizer))
+
+		sts := &appsv1.StatefulSet{}
+		get(name, sts)
+		Expect(metav1.IsControlledBy(sts, instance)).To(BeTrue())
+		Expect(*sts.Spec.Replicas).To(Equal(int32(2)))
+		Expect(sts.Spec.ServiceName).To(Equal(swarmServiceName(instance)))
+		Expect(sts.Spec.Template.Spec.ServiceAccountName).To(Equal(name))
+		Expect(containerNames(sts.Spec.Template.Spec.Containers)).To(Equal([]string{"ipfs", "ipfs-cluster"}))
+		Expect(sts.Spec.VolumeClaimTemplates).To(HaveLen(2))
+		for _, claim := range sts.Spec.VolumeClaimTemplates {
+			size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+			switch claim.Name {
+			case "ipfs-storage":
+				Expect(size.String()).To(Equal("2Gi"))
+			case "cluster-storage":
+				Expect(size.String()).To(Equal("1Gi"))
+			default:
+				Fail("unexpected volume claim " + claim.Name)
+			}
+		}
+
+		svc := &corev1.Service{}
+		get(name, svc)
+		Expect(portNames(svc)).To(Equal([]string{
+			"swarm", "swarm-udp", "ws", "http", "api-http", "proxy-http", "cluster-swarm",
+		}))
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: gatewayServiceName(instance)},
+			&corev1.Service{})).To(Satisfy(errors.IsNotFound))
+
+		config := &corev1.Secret{}
+		get(name, config)
+		Expect(config.Data).To(HaveKey("CLUSTER_SECRET"))
+		Expect(config.Data).To(HaveKey("BOOTSTRAP_PEER_PRIV_KEY"))
+		api := &corev1.Secret{}
+		get(apiCredentialsName(instance), api)
+		Expect(api.Data).To(HaveKey("username"))
+		Expect(api.Data).To(HaveKey("password"))
+		Expect(api.Data).To(HaveKey(apiCredentialsEnv))
+
+		peerID := &corev1.ConfigMap{}
+		get(name, peerID)
+		Expect(peerID.Data["BOOTSTRAP_PEER_ID"]).NotTo(BeEmpty())
+		get(name, &corev1.ServiceAccount{})
+		expectGolden("e2e-minimal-scripts", scripts(instance))
+	})
+
+	It("exposes and tunes the peers of a full-featured cluster", func() {
+		instance := reconciled(apply("full.yaml", ""))
+		name := "ipfs-cluster-" + instance.Name
+		Expect(instance.Status.Cluster).NotTo(BeNil())
+		Expect(instance.Status.Cluster.MonitorPingInterval.Duration).To(Equal(30 * time.Second))
+		Expect(instance.Status.Cluster.ConcurrentPins).To(Equal(int32(20)))
+
+		sts := &appsv1.StatefulSet{}
+		get(name, sts)
+		Expect(sts.Spec.PodManagementPolicy).To(Equal(appsv1.ParallelPodManagement))
+		Expect(*sts.Spec.UpdateStrategy.RollingUpdate.Partition).To(Equal(int32(1)))
+		Expect(containerNames(sts.Spec.Template.Spec.Containers)).To(Equal([]string{
+			"ipfs", "ipfs-cluster", "log-rotate",
+		}))
+		ipfs := sts.Spec.Template.Spec.Containers[0]
+		Expect(ipfs.Env).To(ContainElement(corev1.EnvVar{Name: "IPFS_FD_MAX", Value: "8192"}))
+		Expect(ipfs.Resources.Requests.Cpu().String()).To(Equal("500m"))
+		Expect(sts.Spec.Template.Spec.Containers[1].Env).To(ContainElement(corev1.EnvVar{
+			Name: "CLUSTER_MONITORPINGINTERVAL", Value: "30s",
+		}))
+
+		gateway := &corev1.Service{}
+		get(gatewayServiceName(instance), gateway)
+		Expect(gateway.Spec.Type).To(Equal(corev1.ServiceTypeLoadBalancer))
+		ing := &networkingv1.Ingress{}
+		get(gatewayServiceName(instance), ing)
+		Expect(ing.Spec.Rules).To(HaveLen(1))
+		Expect(ing.Spec.Rules[0].Host).To(Equal("ipfs.example.com"))
+		expectGolden("e2e-full-scripts", scripts(instance))
+	})
+
+	It("serves the WebSockets of each peer to browsers", func() {
+		instance := reconciled(apply("browser.yaml", ""))
+		sts := &appsv1.StatefulSet{}
+		get("ipfs-cluster-"+instance.Name, sts)
+		Expect(sts.Spec.Template.Spec.Containers[0].Ports).To(ContainElements(
+			corev1.ContainerPort{Name: "ws", ContainerPort: 8081, Protocol: corev1.ProtocolTCP},
+			corev1.ContainerPort{Name: "swarm-udp", ContainerPort: 4002, Protocol: corev1.ProtocolUDP},
+		))
+		Expect(scripts(instance)).To(ContainSubstring(
+			`"/ip4/0.0.0.0/tcp/8081/ws","/ip4/0.0.0.0/udp/4002/quic-v1/webtransport"]'`))
+
+		ing := &networkingv1.Ingress{}
+		get(webSocketIngressName(instance), ing)
+		Expect(ing.Spec.Rules).To(HaveLen(2))
+		for ordinal, rule := range ing.Spec.Rules {
+			Expect(rule.Host).To(Equal(peerWebSocketHost(instance, int32(ordinal))))
+			svc := &corev1.Service{}
+			get(rule.HTTP.Paths[0].Backend.Service.Name, svc)
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue(appsv1.StatefulSetPodNameLabel,
+				peerPodName(instance, int32(ordinal))))
+		}
+	})
+
+	It("keeps the objects of clusters sharing a namespace apart", func() {
+		first := reconciled(apply("minimal.yaml", "first"))
+		second := reconciled(apply("minimal.yaml", "second"))
+		for _, instance := range []*clusterv1alpha1.Ipfs{first, second} {
+			sts := &appsv1.StatefulSet{}
+			get("ipfs-cluster-"+instance.Name, sts)
+			Expect(metav1.IsControlledBy(sts, instance)).To(BeTrue())
+			Expect(sts.Spec.Selector.MatchLabels).To(HaveKeyWithValue(labelName, "ipfs-cluster-"+instance.Name))
+		}
+		list := &appsv1.StatefulSetList{}
+		Expect(k8sClient.List(ctx, list, client.InNamespace(namespace))).To(Succeed())
+		Expect(list.Items).To(HaveLen(2))
+	})
+
+	It("syncs the status of the peers from their kubo nodes", func() {
+		instance := reconciled(apply("minimal.yaml", ""))
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, 0)
+		pod.Namespace = namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+		pod.Spec.Containers = []corev1.Container{
+			{Name: "ipfs", Image: ipfsImage},
+			{Name: "ipfs-cluster", Image: ipfsClusterImage},
+		}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+		node := kubofake.NewNode("12D3KooWE2E")
+		node.Repo = kuboapi.RepoStat{RepoSize: 1 << 30, StorageMax: 2 << 30, NumObjects: 12}
+		kuboNodes.Add(namespace, pod.Name, node)
+
+		By("reconciling the cluster again")
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), instance)).To(Succeed())
+		instance.Annotations = map[string]string{"e2e.ipfs.io/touched": "true"}
+		Expect(k8sClient.Update(ctx, instance)).To(Succeed())
+		Eventually(func() int32 {
+			current := &clusterv1alpha1.Ipfs{}
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), current); err != nil {
+				return -1
+			}
+			for _, p := range current.Status.Peers {
+				if p.Name == pod.Name && p.Repo != nil {
+					return p.Repo.Utilization
+				}
+			}
+			return -1
+		}, timeout, interval).Should(Equal(int32(50)))
+	})
+
+	It("holds a scale-up a tight quota cannot fit", func() {
+		// The quota admission plugin of the API server only counts the claims
+		// once the quota has a status, which no controller sets here.
+		quota := &corev1.ResourceQuota{}
+		quota.Name = "storage"
+		quota.Namespace = namespace
+		quota.Spec.Hard = corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("3")}
+		Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+		quota.Status.Hard = quota.Spec.Hard.DeepCopy()
+		quota.Status.Used = corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("0")}
+		Expect(k8sClient.Status().Update(ctx, quota)).To(Succeed())
+
+		instance := reconciled(apply("minimal.yaml", ""))
+		Eventually(func() *metav1.Condition {
+			current := &clusterv1alpha1.Ipfs{}
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), current); err != nil {
+				return nil
+			}
+			return meta.FindStatusCondition(current.Status.Conditions, clusterv1alpha1.ConditionQuotaExceeded)
+		}, timeout, interval).Should(And(
+			Not(BeNil()),
+			HaveField("Reason", clusterv1alpha1.QuotaExceededReasonClaimsRejected),
+			HaveField("Message", ContainSubstring("keeping 0 of 2 peers")),
+		))
+		sts := &appsv1.StatefulSet{}
+		get("ipfs-cluster-"+instance.Name, sts)
+		Expect(*sts.Spec.Replicas).To(BeZero())
+
+		By("rolling back the claims of the rejected scale-up")
+		claims := &corev1.PersistentVolumeClaimList{}
+		Expect(k8sClient.List(ctx, claims, client.InNamespace(namespace))).To(Succeed())
+		for i := range claims.Items {
+			Expect(claims.Items[i].DeletionTimestamp).NotTo(BeNil(), claims.Items[i].Name)
+		}
+	})
+
+	It("recreates the statefulset without its pods and claims to change its pod management policy", func() {
+		instance := reconciled(apply("minimal.yaml", ""))
+		name := "ipfs-cluster-" + instance.Name
+		sts := &appsv1.StatefulSet{}
+		get(name, sts)
+		Expect(sts.Spec.PodManagementPolicy).To(Equal(appsv1.OrderedReadyPodManagement))
+
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, 0)
+		pod.Namespace = namespace
+		pod.Labels = map[string]string{labelName: name}
+		pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(sts,
+			appsv1.SchemeGroupVersion.WithKind("StatefulSet"))}
+		pod.Spec.Containers = []corev1.Container{{Name: "ipfs", Image: ipfsImage}}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+		claim := &corev1.PersistentVolumeClaim{}
+		claim.Name = "ipfs-storage-" + pod.Name
+		claim.Namespace = namespace
+		claim.Labels = map[string]string{labelName: name}
+		claim.Spec.AccessModes = []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}
+		claim.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")}
+		Expect(k8sClient.Create(ctx, claim)).To(Succeed())
+
+		By("switching to parallel pod management")
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), instance)).To(Succeed())
+		instance.Spec.PodManagementPolicy = string(appsv1.ParallelPodManagement)
+		Expect(k8sClient.Update(ctx, instance)).To(Succeed())
+
+		By("orphaning the pods of the deleted statefulset, as the garbage collector does")
+		Eventually(func() []string {
+			current := &appsv1.StatefulSet{}
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(sts), current); err != nil ||
+				current.UID != sts.UID || current.DeletionTimestamp == nil {
+				return nil
+			}
+			return current.Finalizers
+		}, timeout, interval).Should(ContainElement(metav1.FinalizerOrphanDependents))
+		Eventually(func() error {
+			current := &appsv1.StatefulSet{}
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(sts), current); err != nil {
+				return err
+			}
+			current.Finalizers = nil
+			return k8sClient.Update(ctx, current)
+		}, timeout, interval).Should(Succeed())
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+		pod.OwnerReferences = nil
+		Expect(k8sClient.Update(ctx, pod)).To(Succeed())
+
+		recreated := &appsv1.StatefulSet{}
+		Eventually(func() appsv1.PodManagementPolicyType {
+			if err := k8sClient.Get(ctx, client.ObjectKeyFromObject(sts), recreated); err != nil ||
+				recreated.UID == sts.UID {
+				return ""
+			}
+			return recreated.Spec.PodManagementPolicy
+		}, timeout, interval).Should(Equal(appsv1.ParallelPodManagement))
+		Expect(metav1.IsControlledBy(recreated, instance)).To(BeTrue())
+		Expect(recreated.Spec.Selector.MatchLabels).To(HaveKeyWithValue(labelName, name))
+
+		By("keeping the pods and their claims for the new statefulset to adopt")
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+		Expect(pod.DeletionTimestamp).To(BeNil())
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(claim), claim)).To(Succeed())
+		Expect(claim.DeletionTimestamp).To(BeNil())
+	})
+
+	It("writes nothing once the cluster is reconciled", func() {
+		instance := reconciled(apply("minimal.yaml", ""))
+		counting := &writeCountingClient{Client: k8sClient}
+		reconciler := &IpfsReconciler{Client: counting, Scheme: scheme.Scheme, APIReader: k8sClient, Kubo: kuboNodes}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}
+		for i := 0; i < 2; i++ {
+			_, err := reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(counting.writes).To(BeZero())
+	})
+
+	It("runs the finalizer of a deleted cluster", func() {
+		instance := reconciled(apply("minimal.yaml", ""))
+		Expect(k8sClient.Delete(ctx, instance)).To(Succeed())
+		Eventually(func() error {
+			return k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), &clusterv1alpha1.Ipfs{})
+		}, timeout, interval).Should(Satisfy(errors.IsNotFound))
+
+		By("retaining the objects for the garbage collector")
+		get("ipfs-cluster-"+instance.Name, &appsv1.StatefulSet{})
+	})
+})
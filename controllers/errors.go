@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+// errorClass Is how a reconcile which stopped at an error is retried.
+type errorClass int
+
+const (
+	// errorTransient Is an error of the API server or of the network which
+	// may be gone on the next attempt. The reconcile is retried with
+	// backoff.
+	errorTransient errorClass = iota
+	// errorExternalAPI Is a failed call to the REST API of the cluster or to
+	// the RPC API of a kubo node. The reconcile is retried after a delay.
+	errorExternalAPI
+	// errorDependencyMissing Is an object the spec refers to which does not
+	// exist. The reconcile is retried after a delay.
+	errorDependencyMissing
+	// errorInvalidSpec Is an error which stays until the spec changes. The
+	// reconcile is not retried.
+	errorInvalidSpec
+)
+
+// errorRequeueInterval Is how long a reconcile which stopped at an external
+// API or at a missing dependency waits before it is retried.
+const errorRequeueInterval = time.Minute
+
+// reason Returns the reason of the Reconciled condition reporting an error
+// of the class.
+func (c errorClass) reason() string {
+	switch c {
+	case errorExternalAPI:
+		return clusterv1alpha1.ReconciledReasonExternalAPIError
+	case errorDependencyMissing:
+		return clusterv1alpha1.ReconciledReasonDependencyMissing
+	case errorInvalidSpec:
+		return clusterv1alpha1.ReconciledReasonInvalidSpec
+	}
+	return clusterv1alpha1.ReconciledReasonError
+}
+
+// invalidSpecError Is an error the spec causes, which stays until it changes.
+type invalidSpecError struct {
+	err error
+}
+
+func (e *invalidSpecError) Error() string { return e.err.Error() }
+func (e *invalidSpecError) Unwrap() error { return e.err }
+
+// invalidSpec Marks the error as caused by the spec.
+func invalidSpec(err error) error {
+	return &invalidSpecError{err: err}
+}
+
+// dependencyMissingError Is an object the spec refers to which does not exist.
+type dependencyMissingError struct {
+	kind string
+	name string
+	err  error
+}
+
+func (e *dependencyMissingError) Error() string {
+	return fmt.Sprintf("%s %s does not exist: %v", e.kind, e.name, e.err)
+}
+func (e *dependencyMissingError) Unwrap() error { return e.err }
+
+// dependencyMissing Marks the error as the object of the given kind and name
+// missing.
+func dependencyMissing(kind, name string, err error) error {
+	return &dependencyMissingError{kind: kind, name: name, err: err}
+}
+
+// externalAPIError Is a failed call to the REST API of the cluster or to the
+// RPC API of a kubo node.
+type externalAPIError struct {
+	err error
+}
+
+func (e *externalAPIError) Error() string { return e.err.Error() }
+func (e *externalAPIError) Unwrap() error { return e.err }
+
+// externalAPI Marks the error as a failed call to the API of the cluster or
+// of its peers, such as one which could not reach it.
+func externalAPI(err error) error {
+	return &externalAPIError{err: err}
+}
+
+// classifyError Returns the class of the error. The marked errors keep the
+// class they were marked with. The API server refusing an object as invalid
+// is caused by the spec it was rendered from. The errors answered by the
+// REST API of the cluster and by the RPC API of the kubo nodes, and the calls
+// their circuit breakers refused, are external. Any other error is
+// transient.
+func classifyError(err error) errorClass {
+	var invalid *invalidSpecError
+	var missing *dependencyMissingError
+	var external *externalAPIError
+	var clusterErr *clusterapi.Error
+	var kuboErr *kuboapi.Error
+	switch {
+	case errors.As(err, &invalid), apierrors.IsInvalid(err):
+		return errorInvalidSpec
+	case errors.As(err, &missing):
+		return errorDependencyMissing
+	case errors.As(err, &external), errors.As(err, &clusterErr), errors.As(err, &kuboErr),
+		errors.Is(err, breaker.ErrOpen):
+		return errorExternalAPI
+	}
+	return errorTransient
+}
+
+// handleError Decides how the reconcile of the cluster which stopped at the
+// error is retried. Transient errors are returned, for the reconcile to be
+// retried with backoff. The others are reported through the Reconciled
+// condition, with the reason of their class, and a warning event. They are
+// retried after errorRequeueInterval, except for the errors of the spec,
+// which wait for the spec to change.
+func (r *IpfsReconciler) handleError(ctx context.Context, key types.NamespacedName, err error) (ctrl.Result, error) {
+	class := classifyError(err)
+	reconcileErrors.WithLabelValues(key.Namespace, key.Name, class.reason()).Inc()
+	if class == errorTransient {
+		// The error is logged as the reconcile is retried.
+		return ctrl.Result{}, err
+	}
+	instance := &clusterv1alpha1.Ipfs{}
+	if getErr := r.Get(ctx, key, instance); getErr != nil {
+		if apierrors.IsNotFound(getErr) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	reason := class.reason()
+	setReconciledCondition(instance, metav1.ConditionFalse, reason, err.Error())
+	if syncErr := r.syncReadiness(ctx, instance); syncErr != nil {
+		return ctrl.Result{}, syncErr
+	}
+	if updateErr := r.Status().Update(ctx, instance); updateErr != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(updateErr)
+	}
+	r.eventf(instance, corev1.EventTypeWarning, reason, "%s", err.Error())
+	if class == errorInvalidSpec {
+		ctrllog.FromContext(ctx).Info("the spec cannot be reconciled, waiting for it to change",
+			"reason", reason, "error", err.Error())
+		return ctrl.Result{}, nil
+	}
+	ctrllog.FromContext(ctx).Error(err, "cannot reconcile the cluster, will retry", "reason", reason)
+	return ctrl.Result{RequeueAfter: errorRequeueInterval}, nil
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+// missingSecret Returns the error of the fake client getting a Secret which
+// does not exist.
+func missingSecret() error {
+	return fake.NewClientBuilder().Build().Get(context.Background(),
+		client.ObjectKey{Namespace: "default", Name: "gateway-users"}, &corev1.Secret{})
+}
+
+// failedRemoval Returns the error of the fake cluster failing to remove a peer.
+func failedRemoval(code int) error {
+	cluster := clusterfake.NewCluster(clusterapi.Peer{ID: "12D3KooWA"})
+	defer cluster.Close()
+	cluster.FailNext(clusterfake.RouteRemovePeer, code)
+	api := clusterapi.New(cluster.URL(), clusterapi.WithBackoff(wait.Backoff{Steps: 1}))
+	return api.RemovePeer(context.Background(), "12D3KooWA")
+}
+
+// unreachableCluster Returns the error of a call to a cluster which is gone.
+func unreachableCluster() error {
+	cluster := clusterfake.NewCluster()
+	cluster.Close()
+	api := clusterapi.New(cluster.URL(), clusterapi.WithBackoff(wait.Backoff{Steps: 1}))
+	_, err := api.Peers(context.Background())
+	return err
+}
+
+var _ = Describe("Ipfs reconcile errors", func() {
+	table.DescribeTable("classifies the errors to decide how the reconcile is retried",
+		func(err func() error, expected errorClass) {
+			Expect(classifyError(err())).To(Equal(expected))
+		},
+		table.Entry("a missing object the reconcile reads", missingSecret, errorTransient),
+		table.Entry("a missing object the spec refers to", func() error {
+			return fmt.Errorf("cannot hash: %w", dependencyMissing("Secret", "gateway-users", missingSecret()))
+		}, errorDependencyMissing),
+		table.Entry("a conflicting update", func() error {
+			return fmt.Errorf("cannot update statefulset: %w", apierrors.NewConflict(
+				schema.GroupResource{Group: "apps", Resource: "statefulsets"}, "ipfs-cluster-x", fmt.Errorf("stale")))
+		}, errorTransient),
+		table.Entry("a throttled request", func() error {
+			return apierrors.NewTooManyRequests("slow down", 1)
+		}, errorTransient),
+		table.Entry("an object the API server refuses", func() error {
+			return fmt.Errorf("cannot apply statefulset: %w", apierrors.NewInvalid(
+				schema.GroupKind{Group: "apps", Kind: "StatefulSet"}, "ipfs-cluster-x",
+				field.ErrorList{field.Invalid(field.NewPath("spec", "replicas"), -1, "must be positive")}))
+		}, errorInvalidSpec),
+		table.Entry("a field of the spec which cannot be parsed", func() error {
+			_, err := ipfsStorageMax(&clusterv1alpha1.Ipfs{Spec: clusterv1alpha1.IpfsSpec{IpfsStorage: "lots"}})
+			return fmt.Errorf("cannot render the scripts: %w", err)
+		}, errorInvalidSpec),
+		table.Entry("an error answered by the cluster API", func() error {
+			return failedRemoval(http.StatusInternalServerError)
+		}, errorExternalAPI),
+		table.Entry("a peer the cluster API does not know", func() error {
+			return failedRemoval(http.StatusNotFound)
+		}, errorExternalAPI),
+		table.Entry("a cluster API which cannot be reached", unreachableCluster, errorTransient),
+		table.Entry("a marked call to a cluster API which cannot be reached", func() error {
+			return externalAPI(fmt.Errorf("cannot re-allocate the pins: %w", unreachableCluster()))
+		}, errorExternalAPI),
+		table.Entry("an error answered by a kubo node", func() error {
+			return fmt.Errorf("cannot stat repo: %w", &kuboapi.Error{StatusCode: 500, Message: "unavailable"})
+		}, errorExternalAPI),
+		table.Entry("an open circuit breaker", func() error {
+			return fmt.Errorf("cannot list peers: %w", breaker.ErrOpen)
+		}, errorExternalAPI),
+		table.Entry("a cancelled reconcile", func() error {
+			return fmt.Errorf("cannot list pods: %w", context.Canceled)
+		}, errorTransient),
+	)
+
+	It("requeues after the shortest delay the steps return", func() {
+		Expect(minRequeue()).To(BeZero())
+		Expect(minRequeue(0, 0)).To(BeZero())
+		Expect(minRequeue(time.Minute, 0, 5*time.Second, time.Hour)).To(Equal(5 * time.Second))
+	})
+
+	Describe("handling", func() {
+		var (
+			ctx        context.Context
+			fakeClient client.Client
+			reconciler *IpfsReconciler
+			recorder   *record.FakeRecorder
+			key        types.NamespacedName
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			key = types.NamespacedName{Namespace: "default", Name: "failing"}
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+			instance := &clusterv1alpha1.Ipfs{}
+			instance.Name = key.Name
+			instance.Namespace = key.Namespace
+			instance.Spec.Replicas = 1
+			instance.Spec.IpfsStorage = "1Gi"
+			instance.Spec.ClusterStorage = "1Gi"
+			instance.Spec.Expose.Host = "gateway.example.com"
+			instance.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+				BasicAuthSecretRef: &corev1.LocalObjectReference{Name: "gateway-users"},
+			}
+			fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+			recorder = record.NewFakeRecorder(10)
+			reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Recorder: recorder}
+		})
+
+		reconciled := func() *clusterv1alpha1.Ipfs {
+			instance := &clusterv1alpha1.Ipfs{}
+			Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+			return instance
+		}
+
+		It("waits for the objects the spec refers to", func() {
+			var result ctrl.Result
+			var err error
+			for i := 0; i < 2; i++ {
+				result, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.RequeueAfter).To(Equal(errorRequeueInterval))
+			instance := reconciled()
+			cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReconciled)
+			Expect(cond.Reason).To(Equal(clusterv1alpha1.ReconciledReasonDependencyMissing))
+			Expect(cond.Message).To(ContainSubstring("Secret gateway-users does not exist"))
+			Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhasePending))
+			Expect(recorder.Events).To(Receive(ContainSubstring(clusterv1alpha1.ReconciledReasonDependencyMissing)))
+		})
+
+		It("stops at the errors of the spec and retries the transient ones", func() {
+			result, err := reconciler.handleError(ctx, key, invalidSpec(fmt.Errorf("cannot parse ipfsStorage")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(ctrl.Result{}))
+			Expect(meta.FindStatusCondition(reconciled().Status.Conditions,
+				clusterv1alpha1.ConditionReconciled).Reason).To(Equal(clusterv1alpha1.ReconciledReasonInvalidSpec))
+
+			transient := apierrors.NewServiceUnavailable("etcd is restarting")
+			_, err = reconciler.handleError(ctx, key, transient)
+			Expect(err).To(Equal(transient))
+
+			By("forgetting the errors of a deleted cluster")
+			Expect(fakeClient.Delete(ctx, reconciled())).To(Succeed())
+			result, err = reconciler.handleError(ctx, key, externalAPI(fmt.Errorf("cannot list peers")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(ctrl.Result{}))
+			_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("retries the external APIs after a delay", func() {
+			result, err := reconciler.handleError(ctx, key, externalAPI(fmt.Errorf("cannot list peers")))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(time.Minute))
+		})
+	})
+})
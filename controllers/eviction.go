@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// safeToEvictAnnotation Tells the cluster autoscaler whether it may evict
+	// a pod to remove its node.
+	safeToEvictAnnotation = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+	// defaultClassAnnotation Marks the default storage class.
+	defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// betaDefaultClassAnnotation Marks the default storage class on older clusters.
+	betaDefaultClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+// nodeLocalProvisioners Lists the provisioners of volumes living on the disks
+// of the node they are created on.
+var nodeLocalProvisioners = map[string]bool{
+	"kubernetes.io/no-provisioner":     true,
+	"rancher.io/local-path":            true,
+	"topolvm.io":                       true,
+	"topolvm.cybozu.com":               true,
+	"openebs.io/local":                 true,
+	"local.csi.openebs.io":             true,
+	"lvm.csi.metal-stack.io":           true,
+	"hostpath.csi.k8s.io":              true,
+	"kubevirt.io.hostpath-provisioner": true,
+	"k8s.io/minikube-hostpath":         true,
+	"microk8s.io/hostpath":             true,
+}
+
+// nodeLocal Returns whether the storage class provisions volumes bound to a
+// single node: those of the known node-local provisioners, those restricted
+// to a host by their allowed topologies, and those of the provisioners named
+// after local or host path volumes which wait for the pod to be scheduled.
+// Zonal volumes, such as those of EBS, can follow the pod to another node.
+func nodeLocal(sc *storagev1.StorageClass) bool {
+	if nodeLocalProvisioners[sc.Provisioner] {
+		return true
+	}
+	for _, topology := range sc.AllowedTopologies {
+		for _, requirement := range topology.MatchLabelExpressions {
+			if requirement.Key == corev1.LabelHostname {
+				return true
+			}
+		}
+	}
+	if sc.VolumeBindingMode == nil || *sc.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+		return false
+	}
+	provisioner := strings.ToLower(sc.Provisioner)
+	return strings.Contains(provisioner, "local") || strings.Contains(provisioner, "hostpath")
+}
+
+// storageClassNames Returns the storage classes of the volumes of the peers,
+// the default storage class being named by an empty string.
+func storageClassNames(m *clusterv1alpha1.Ipfs) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(name *string) {
+		key := ""
+		if name != nil {
+			key = *name
+			if key == "" {
+				// The volumes are bound to the persistent volumes created
+				// beforehand, whatever their storage.
+				return
+			}
+		}
+		if !seen[key] {
+			seen[key] = true
+			names = append(names, key)
+		}
+	}
+	add(m.Spec.StorageClassName)
+	for i := range m.Spec.Overrides {
+		if name := m.Spec.Overrides[i].StorageClassName; name != nil {
+			add(name)
+		}
+	}
+	return names
+}
+
+// defaultStorageClass Returns the default storage class, or nil when the
+// cluster has none.
+func (r *IpfsReconciler) defaultStorageClass(ctx context.Context) (*storagev1.StorageClass, error) {
+	classes := storagev1.StorageClassList{}
+	if err := r.List(ctx, &classes); err != nil {
+		return nil, fmt.Errorf("cannot list storage classes: %w", err)
+	}
+	for i := range classes.Items {
+		sc := &classes.Items[i]
+		if sc.Annotations[defaultClassAnnotation] == "true" || sc.Annotations[betaDefaultClassAnnotation] == "true" {
+			return sc, nil
+		}
+	}
+	return nil, nil
+}
+
+// resolveSafeToEvict Sets safeToEvict to false when it is unset and a storage
+// class of the peers provisions node-local volumes: the cluster autoscaler
+// would otherwise evict the peers to remove their nodes, leaving them unable
+// to start anywhere else. The storage classes which do not exist yet are
+// left to the diagnostics.
+func (r *IpfsReconciler) resolveSafeToEvict(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	if m.Spec.SafeToEvict != nil {
+		return nil
+	}
+	for _, name := range storageClassNames(m) {
+		var sc *storagev1.StorageClass
+		if name == "" {
+			var err error
+			if sc, err = r.defaultStorageClass(ctx); err != nil {
+				return err
+			}
+		} else {
+			sc = &storagev1.StorageClass{}
+			err := r.Get(ctx, client.ObjectKey{Name: name}, sc)
+			if errors.IsNotFound(err) {
+				sc = nil
+			} else if err != nil {
+				return fmt.Errorf("cannot get storage class %s: %w", name, err)
+			}
+		}
+		if sc != nil && nodeLocal(sc) {
+			safe := false
+			m.Spec.SafeToEvict = &safe
+			return nil
+		}
+	}
+	return nil
+}
+
+// evictionAnnotations Returns the annotations telling the cluster autoscaler
+// whether it may evict the pods of the peers.
+func evictionAnnotations(m *clusterv1alpha1.Ipfs) map[string]string {
+	if m.Spec.SafeToEvict == nil {
+		return nil
+	}
+	return map[string]string{safeToEvictAnnotation: strconv.FormatBool(*m.Spec.SafeToEvict)}
+}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs eviction by the cluster autoscaler", func() {
+	var (
+		ctx      context.Context
+		scheme   *runtime.Scheme
+		instance *clusterv1alpha1.Ipfs
+	)
+
+	class := func(name, provisioner string, mode storagev1.VolumeBindingMode) *storagev1.StorageClass {
+		sc := &storagev1.StorageClass{Provisioner: provisioner, VolumeBindingMode: &mode}
+		sc.Name = name
+		return sc
+	}
+
+	reconciler := func(objects ...client.Object) *IpfsReconciler {
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		return &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "eviction"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+	})
+
+	It("tells the node-local provisioners from the others", func() {
+		wait := storagev1.VolumeBindingWaitForFirstConsumer
+		immediate := storagev1.VolumeBindingImmediate
+		Expect(nodeLocal(class("local-path", "rancher.io/local-path", wait))).To(BeTrue())
+		Expect(nodeLocal(class("topolvm", "topolvm.io", wait))).To(BeTrue())
+		Expect(nodeLocal(class("topolvm-legacy", "topolvm.cybozu.com", wait))).To(BeTrue())
+		Expect(nodeLocal(class("local-storage", "kubernetes.io/no-provisioner", wait))).To(BeTrue())
+		Expect(nodeLocal(class("gp3", "ebs.csi.aws.com", wait))).To(BeFalse())
+		Expect(nodeLocal(class("gp2", "kubernetes.io/aws-ebs", immediate))).To(BeFalse())
+		Expect(nodeLocal(class("standard", "pd.csi.storage.gke.io", wait))).To(BeFalse())
+		Expect(nodeLocal(class("ceph", "rbd.csi.ceph.com", immediate))).To(BeFalse())
+
+		By("guessing from the name of the provisioners waiting for their pods")
+		Expect(nodeLocal(class("custom", "example.com/local-disks", wait))).To(BeTrue())
+		Expect(nodeLocal(class("custom", "example.com/local-disks", immediate))).To(BeFalse())
+
+		By("following the allowed topologies restricted to a host")
+		pinned := class("pinned", "ebs.csi.aws.com", wait)
+		pinned.AllowedTopologies = []corev1.TopologySelectorTerm{{
+			MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{{
+				Key: corev1.LabelHostname, Values: []string{"node-a"},
+			}},
+		}}
+		Expect(nodeLocal(pinned)).To(BeTrue())
+	})
+
+	It("keeps the peers on node-local volumes from being evicted", func() {
+		local := "local-path"
+		instance.Spec.StorageClassName = &local
+		r := reconciler(class(local, "rancher.io/local-path", storagev1.VolumeBindingWaitForFirstConsumer))
+		Expect(r.resolveSafeToEvict(ctx, instance)).To(Succeed())
+		Expect(instance.Spec.SafeToEvict).NotTo(BeNil())
+		Expect(*instance.Spec.SafeToEvict).To(BeFalse())
+
+		instance.Spec.PriorityClassName = "ipfs-peers"
+		sts := &appsv1.StatefulSet{}
+		Expect(r.statefulSet(instance, sts, "ipfs-cluster-eviction", "ipfs-cluster-eviction",
+			"ipfs-cluster-eviction", "ipfs-cluster-eviction", "hash")()).To(Succeed())
+		Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(safeToEvictAnnotation, "false"))
+		Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(clusterv1alpha1.AnnotationConfigHash, "hash"))
+		Expect(sts.Spec.Template.Spec.PriorityClassName).To(Equal("ipfs-peers"))
+	})
+
+	It("follows the default storage class and the overrides", func() {
+		ebs := class("gp3", "ebs.csi.aws.com", storagev1.VolumeBindingWaitForFirstConsumer)
+		ebs.Annotations = map[string]string{defaultClassAnnotation: "true"}
+		topolvm := class("topolvm", "topolvm.io", storagev1.VolumeBindingWaitForFirstConsumer)
+		r := reconciler(ebs, topolvm)
+		Expect(r.resolveSafeToEvict(ctx, instance)).To(Succeed())
+		Expect(instance.Spec.SafeToEvict).To(BeNil())
+		Expect(evictionAnnotations(instance)).To(BeEmpty())
+
+		instance.Spec.Overrides = []clusterv1alpha1.PeerOverride{{Ordinals: []string{"1"}, StorageClassName: &topolvm.Name}}
+		Expect(r.resolveSafeToEvict(ctx, instance)).To(Succeed())
+		Expect(*instance.Spec.SafeToEvict).To(BeFalse())
+	})
+
+	It("lets the spec override the detection", func() {
+		local := "local-path"
+		safe := true
+		instance.Spec.StorageClassName = &local
+		instance.Spec.SafeToEvict = &safe
+		r := reconciler(class(local, "rancher.io/local-path", storagev1.VolumeBindingWaitForFirstConsumer))
+		Expect(r.resolveSafeToEvict(ctx, instance)).To(Succeed())
+		Expect(evictionAnnotations(instance)).To(HaveKeyWithValue(safeToEvictAnnotation, "true"))
+	})
+})
@@ -0,0 +1,337 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Keys of the ConfigMap exporting the resolved configuration of a cluster.
+const (
+	resolvedSpecKey     = "spec.yaml"
+	resolvedKuboKey     = "configure-ipfs.sh"
+	resolvedClusterKey  = "ipfs-cluster.yaml"
+	resolvedOperatorKey = "operator.yaml"
+)
+
+// missingValue Stands for the value of a key a referenced object lacks.
+const missingValue = "<missing>"
+
+// sensitiveEnvNames Are the substrings of the names of the environment
+// variables whose literal values are exported as fingerprints.
+var sensitiveEnvNames = []string{"SECRET", "PRIV", "PASSWORD", "TOKEN", "KEY", "CREDENTIAL"}
+
+// BuildInfo Identifies the build of the operator.
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// resolvedCluster Is the configuration the ipfs-cluster daemons of a cluster
+// run with.
+type resolvedCluster struct {
+	// Config Is the service.json configuration the environment overrides.
+	Config *clusterv1alpha1.ClusterConfigStatus `json:"config"`
+	// Env Is the environment of the ipfs-cluster container. The values held
+	// by Secrets, and the literal values of sensitive names, are fingerprints.
+	Env map[string]string `json:"env"`
+	// Identities Are the ipfs-cluster identities of the peers by ordinal,
+	// with their private keys replaced by fingerprints.
+	Identities map[string]resolvedIdentity `json:"identities"`
+}
+
+// resolvedIdentity Is the ipfs-cluster identity of a peer.
+type resolvedIdentity struct {
+	ID         string `json:"id,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+}
+
+// resolvedConfigMapName Returns the name of the ConfigMap exporting the
+// resolved configuration of the cluster.
+func resolvedConfigMapName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-resolved"
+}
+
+// exportingResolvedConfig Returns whether the resolved configuration of the
+// cluster is exported.
+func exportingResolvedConfig(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Debug != nil && m.Spec.Debug.ExportResolvedConfig
+}
+
+// fingerprint Returns a digest identifying a secret value without revealing
+// it, so that two exports can tell whether it changed.
+func fingerprint(value []byte) string {
+	sum := sha256.Sum256(value)
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// sensitiveEnvName Returns whether the environment variable likely holds
+// secret material.
+func sensitiveEnvName(name string) bool {
+	name = strings.ToUpper(name)
+	for _, s := range sensitiveEnvNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactEnv Returns the environment with the literal values of sensitive
+// names replaced by fingerprints.
+func redactEnv(env []corev1.EnvVar) []corev1.EnvVar {
+	redacted := make([]corev1.EnvVar, 0, len(env))
+	for _, e := range env {
+		if e.Value != "" && sensitiveEnvName(e.Name) {
+			e.Value = fingerprint([]byte(e.Value))
+		}
+		redacted = append(redacted, e)
+	}
+	return redacted
+}
+
+// scrubSecrets Replaces every occurrence of the secret values in the data by
+// their fingerprints, so that secret material never leaks into the export,
+// whichever way it reached the configuration.
+func scrubSecrets(data map[string]string, secrets map[string]struct{}) {
+	values := make([]string, 0, len(secrets))
+	for value := range secrets {
+		values = append(values, value)
+	}
+	// Longer values first, so that a value containing another one is
+	// replaced whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for key, content := range data {
+		for _, value := range values {
+			content = strings.ReplaceAll(content, value, fingerprint([]byte(value)))
+		}
+		data[key] = content
+	}
+}
+
+// syncResolvedConfig Writes the resolved configuration of the cluster to its
+// export ConfigMap while the export is enabled. The ConfigMap is rendered
+// from the spec resolved against the operator defaults and from the objects
+// of the cluster, so it follows every change of them. It is deleted once
+// the export is disabled.
+func (r *IpfsReconciler) syncResolvedConfig(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) error {
+	cm := corev1.ConfigMap{}
+	cm.Name = resolvedConfigMapName(instance)
+	cm.Namespace = instance.Namespace
+	if !exportingResolvedConfig(instance) {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(&cm), &cm); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if err := r.Delete(ctx, &cm); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete resolved configuration: %w", err)
+		}
+		return nil
+	}
+
+	data, err := r.renderResolvedConfig(ctx, resolved)
+	if err != nil {
+		return err
+	}
+	_, err = controllerutil.CreateOrPatch(ctx, r.Client, &cm, func() error {
+		cm.Labels = ipfsLabels(instance, componentDebug)
+		cm.Data = data
+		return ctrl.SetControllerReference(instance, &cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write resolved configuration: %w", err)
+	}
+	return nil
+}
+
+// renderResolvedConfig Returns the data of the export ConfigMap.
+func (r *IpfsReconciler) renderResolvedConfig(
+	ctx context.Context,
+	resolved *clusterv1alpha1.Ipfs,
+) (map[string]string, error) {
+	secrets := map[string]struct{}{}
+	spec := resolved.Spec.DeepCopy()
+	spec.Env = redactEnv(spec.Env)
+	specYAML, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize resolved spec: %w", err)
+	}
+
+	scripts := corev1.ConfigMap{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: resolved.Namespace, Name: "ipfs-cluster-scripts-" + resolved.Name},
+		&scripts)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("cannot get scripts: %w", err)
+	}
+	kubo, ok := scripts.Data["configure-ipfs.sh"]
+	if !ok {
+		kubo = missingValue
+	}
+
+	cluster, err := r.resolveCluster(ctx, resolved, secrets)
+	if err != nil {
+		return nil, err
+	}
+	clusterYAML, err := yaml.Marshal(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize resolved ipfs-cluster config: %w", err)
+	}
+	operatorYAML, err := yaml.Marshal(r.Build)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize operator build: %w", err)
+	}
+
+	data := map[string]string{
+		resolvedSpecKey:     string(specYAML),
+		resolvedKuboKey:     kubo,
+		resolvedClusterKey:  string(clusterYAML),
+		resolvedOperatorKey: string(operatorYAML),
+	}
+	scrubSecrets(data, secrets)
+	return data, nil
+}
+
+// resolveCluster Returns the configuration of the ipfs-cluster daemons of
+// the cluster. The values of the Secrets it reads are added to secrets.
+func (r *IpfsReconciler) resolveCluster(
+	ctx context.Context,
+	resolved *clusterv1alpha1.Ipfs,
+	secrets map[string]struct{},
+) (*resolvedCluster, error) {
+	cluster := &resolvedCluster{
+		Config:     clusterConfigOf(resolved),
+		Env:        map[string]string{},
+		Identities: map[string]resolvedIdentity{},
+	}
+
+	sec, err := r.configSecret(ctx, resolved.Namespace, resolved.Name)
+	if err != nil {
+		return nil, err
+	}
+	if sec == nil {
+		sec = &corev1.Secret{}
+	}
+	for key, value := range sec.Data {
+		// The peer IDs are public, and exported as they are.
+		if !strings.HasPrefix(key, "PEER_ID_") {
+			addSecret(secrets, value)
+		}
+	}
+	for ordinal := int32(0); ordinal < peerCount(resolved); ordinal++ {
+		identity := resolvedIdentity{}
+		privKey := bootstrapPrivateKeyKey
+		if ordinal == 0 {
+			if id, err := peerIDOf(sec.Data[bootstrapPrivateKeyKey]); err == nil {
+				identity.ID = id.String()
+			}
+		} else {
+			var idKey string
+			idKey, privKey = peerIdentityKeys(ordinal)
+			identity.ID = string(sec.Data[idKey])
+		}
+		if priv, ok := sec.Data[privKey]; ok {
+			identity.PrivateKey = fingerprint(priv)
+		}
+		cluster.Identities[strconv.Itoa(int(ordinal))] = identity
+	}
+
+	sts := appsv1.StatefulSet{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: resolved.Namespace, Name: "ipfs-cluster-" + resolved.Name}, &sts)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("cannot get statefulset: %w", err)
+	}
+	for _, container := range sts.Spec.Template.Spec.Containers {
+		if container.Name != "ipfs-cluster" {
+			continue
+		}
+		for _, env := range container.Env {
+			value, err := r.resolveEnv(ctx, resolved.Namespace, env, secrets)
+			if err != nil {
+				return nil, err
+			}
+			cluster.Env[env.Name] = value
+		}
+	}
+	return cluster, nil
+}
+
+// resolveEnv Returns the exported value of an environment variable. The
+// values of Secrets are fingerprinted, and added to secrets.
+func (r *IpfsReconciler) resolveEnv(
+	ctx context.Context,
+	namespace string,
+	env corev1.EnvVar,
+	secrets map[string]struct{},
+) (string, error) {
+	from := env.ValueFrom
+	switch {
+	case from == nil && sensitiveEnvName(env.Name):
+		addSecret(secrets, []byte(env.Value))
+		return fingerprint([]byte(env.Value)), nil
+	case from == nil:
+		return env.Value, nil
+	case from.SecretKeyRef != nil:
+		sec := corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: from.SecretKeyRef.Name}, &sec)
+		if errors.IsNotFound(err) {
+			return missingValue, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot get secret %s: %w", from.SecretKeyRef.Name, err)
+		}
+		value, ok := sec.Data[from.SecretKeyRef.Key]
+		if !ok {
+			return missingValue, nil
+		}
+		addSecret(secrets, value)
+		return fingerprint(value), nil
+	case from.ConfigMapKeyRef != nil:
+		cm := corev1.ConfigMap{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: from.ConfigMapKeyRef.Name}, &cm)
+		if errors.IsNotFound(err) {
+			return missingValue, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot get configmap %s: %w", from.ConfigMapKeyRef.Name, err)
+		}
+		value, ok := cm.Data[from.ConfigMapKeyRef.Key]
+		if !ok {
+			return missingValue, nil
+		}
+		return value, nil
+	case from.FieldRef != nil:
+		return "fieldRef:" + from.FieldRef.FieldPath, nil
+	case from.ResourceFieldRef != nil:
+		return "resourceFieldRef:" + from.ResourceFieldRef.Resource, nil
+	}
+	return "", nil
+}
+
+// addSecret Adds a secret value to scrub from the export. Values too short
+// to be secret material are left, as scrubbing them would garble the export.
+func addSecret(secrets map[string]struct{}, value []byte) {
+	if len(value) >= minScrubbedLength {
+		secrets[string(value)] = struct{}{}
+	}
+}
+
+// minScrubbedLength Is the length from which secret values are scrubbed from
+// the export wherever they appear.
+const minScrubbedLength = 8
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs resolved configuration", func() {
+	var (
+		ctx         context.Context
+		fakeClient  client.Client
+		reconciler  *IpfsReconciler
+		key         types.NamespacedName
+		resolvedKey types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	exported := func() *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, resolvedKey, cm)).To(Succeed())
+		return cm
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "exported"}
+		resolvedKey = types.NamespacedName{Namespace: key.Namespace, Name: "exported-resolved"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Env = []corev1.EnvVar{
+			{Name: "S3_PASSWORD", Value: "hunter2-hunter2"},
+			{Name: "GOLOG_LOG_LEVEL", Value: "debug"},
+		}
+		instance.Spec.Debug = &clusterv1alpha1.DebugConfig{ExportResolvedConfig: true}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			Build:  BuildInfo{Version: "1.2.3", Commit: "abc1234"},
+		}
+	})
+
+	It("exports the configuration without the secret material", func() {
+		reconcile()
+		reconcile()
+		cm := exported()
+		Expect(cm.Data).To(HaveKey(resolvedSpecKey))
+		Expect(cm.Data).To(HaveKey(resolvedKuboKey))
+		Expect(cm.Data[resolvedOperatorKey]).To(ContainSubstring("1.2.3"))
+		Expect(cm.Data[resolvedOperatorKey]).To(ContainSubstring("abc1234"))
+		Expect(cm.Data[resolvedSpecKey]).To(ContainSubstring("GOLOG_LOG_LEVEL"))
+		Expect(cm.Data[resolvedSpecKey]).To(ContainSubstring(fingerprint([]byte("hunter2-hunter2"))))
+
+		sec, err := reconciler.configSecret(ctx, key.Namespace, key.Name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sec.Data).To(HaveKey(clusterSecretKey))
+		Expect(sec.Data).To(HaveKey(bootstrapPrivateKeyKey))
+		Expect(sec.Data).To(HaveKey("PEER_PRIV_KEY_1"))
+		for name, content := range cm.Data {
+			Expect(content).NotTo(ContainSubstring("hunter2-hunter2"), name)
+			for secretKey, value := range sec.Data {
+				if strings.HasPrefix(secretKey, "PEER_ID_") {
+					continue
+				}
+				Expect(content).NotTo(ContainSubstring(string(value)), name+" leaks "+secretKey)
+			}
+		}
+
+		cluster := resolvedCluster{}
+		Expect(yaml.Unmarshal([]byte(cm.Data[resolvedClusterKey]), &cluster)).To(Succeed())
+		Expect(cluster.Identities).To(HaveLen(2))
+		Expect(cluster.Identities["1"].ID).To(Equal(string(sec.Data["PEER_ID_1"])))
+		Expect(cluster.Identities["1"].PrivateKey).To(Equal(fingerprint(sec.Data["PEER_PRIV_KEY_1"])))
+		Expect(cluster.Env["CLUSTER_SECRET"]).To(Equal(fingerprint(sec.Data["CLUSTER_SECRET"])))
+	})
+
+	It("scrubs secret values wherever they appear", func() {
+		data := map[string]string{
+			"a": "key=0123456789abcdef, again 0123456789abcdef",
+			"b": "short",
+		}
+		scrubSecrets(data, map[string]struct{}{"0123456789abcdef": {}})
+		Expect(data["a"]).NotTo(ContainSubstring("0123456789abcdef"))
+		Expect(strings.Count(data["a"], fingerprint([]byte("0123456789abcdef")))).To(Equal(2))
+		Expect(data["b"]).To(Equal("short"))
+	})
+
+	It("follows the spec and is deleted once the export is disabled", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(exported().Data[resolvedSpecKey]).To(ContainSubstring("value: debug"))
+
+		By("regenerating the export when the spec changes")
+		instance.Spec.Env[1].Value = "info"
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(exported().Data[resolvedSpecKey]).To(ContainSubstring("value: info"))
+
+		By("deleting the export")
+		instance.Spec.Debug = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		reconcile()
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, resolvedKey, &corev1.ConfigMap{}))).To(BeTrue())
+	})
+})
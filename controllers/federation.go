@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/federation"
+)
+
+// defaultBundleValidity Is how long an exported peer bundle is valid when
+// the spec does not tell.
+const defaultBundleValidity = 24 * time.Hour
+
+// importedBundle Is the outcome of importing the bundle of a Secret.
+type importedBundle struct {
+	secret string
+	bundle *federation.Bundle
+	err    error
+}
+
+// federationSecretName Returns the name of the Secret holding the bundle the
+// cluster exports.
+func federationSecretName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-federation"
+}
+
+// bundleValidity Returns how long the exported bundles of the cluster are valid.
+func bundleValidity(m *clusterv1alpha1.Ipfs) time.Duration {
+	if f := m.Spec.Federation; f != nil && f.BundleValidity != nil && f.BundleValidity.Duration > 0 {
+		return f.BundleValidity.Duration
+	}
+	return defaultBundleValidity
+}
+
+// exportingBundle Returns whether the cluster exports a peer bundle.
+func exportingBundle(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Federation != nil && m.Spec.Federation.Export
+}
+
+// referencedFederationSecrets Returns the names of the Secrets holding the
+// bundles the cluster imports.
+func referencedFederationSecrets(m *clusterv1alpha1.Ipfs) []string {
+	if m.Spec.Federation == nil {
+		return nil
+	}
+	names := make([]string, 0, len(m.Spec.Federation.ImportSecretRefs))
+	for _, ref := range m.Spec.Federation.ImportSecretRefs {
+		names = append(names, ref.Name)
+	}
+	return names
+}
+
+// relayAddrInfos Returns the addresses of the circuit relays of the cluster
+// which announced them. The relays which are not ready yet are left out.
+func (r *IpfsReconciler) relayAddrInfos(ctx context.Context, m *clusterv1alpha1.Ipfs) ([]*peer.AddrInfo, error) {
+	infos := []*peer.AddrInfo{}
+	for _, name := range m.Status.CircuitRelays {
+		relay := clusterv1alpha1.CircuitRelay{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: name}, &relay)
+		if client.IgnoreNotFound(err) != nil {
+			return nil, fmt.Errorf("cannot get circuit relay %s: %w", name, err)
+		}
+		if err != nil || relay.Status.AddrInfo.Parse() != nil {
+			continue
+		}
+		infos = append(infos, relay.Status.AddrInfo.AddrInfo())
+	}
+	return infos, nil
+}
+
+// exportedPeers Returns the kubo nodes of the cluster, reachable from other
+// Kubernetes clusters through its circuit relays, and the relays themselves.
+// The peers whose kubo identity is not known yet are left out.
+func (r *IpfsReconciler) exportedPeers(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) ([]federation.Peer, []federation.Peer, error) {
+	infos, err := r.relayAddrInfos(ctx, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	relays := []federation.Peer{}
+	circuits := []string{}
+	for _, info := range infos {
+		relay := federation.Peer{ID: info.ID.String()}
+		for _, addr := range info.Addrs {
+			relay.Addrs = append(relay.Addrs, addr.String())
+			circuits = append(circuits, fmt.Sprintf("%s/p2p/%s/p2p-circuit", addr, info.ID))
+		}
+		relays = append(relays, relay)
+	}
+	peers := []federation.Peer{}
+	if len(circuits) == 0 {
+		return peers, relays, nil
+	}
+	for _, status := range m.Status.Peers {
+		if status.KuboID != "" {
+			peers = append(peers, federation.Peer{ID: status.KuboID, Addrs: circuits})
+		}
+	}
+	return peers, relays, nil
+}
+
+// bundleSigningKey Returns the key the bundles of the cluster are signed
+// with: the identity of its bootstrap peer. It returns nil until the
+// identity is generated.
+func (r *IpfsReconciler) bundleSigningKey(ctx context.Context, m *clusterv1alpha1.Ipfs) (ci.PrivKey, error) {
+	sec := corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}, &sec)
+	if err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	encoded, ok := sec.Data[bootstrapPrivateKeyKey]
+	if !ok {
+		return nil, nil
+	}
+	return privateKeyOf(encoded)
+}
+
+// syncFederation Exports the peer bundle of the cluster, and records the
+// bundles it imports in its status. The peers of the imported bundles are
+// added to the scripts of the peers when the tracked objects are applied.
+func (r *IpfsReconciler) syncFederation(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if instance.Spec.Federation == nil {
+		instance.Status.Federation = nil
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionFederationDegraded)
+		return r.deleteFederationExport(ctx, instance)
+	}
+	if instance.Status.Federation == nil {
+		instance.Status.Federation = &clusterv1alpha1.FederationStatus{}
+	}
+	if err := r.syncFederationExport(ctx, instance); err != nil {
+		return err
+	}
+	r.syncFederationImports(ctx, instance)
+	return nil
+}
+
+// deleteFederationExport Deletes the exported bundle of the cluster.
+func (r *IpfsReconciler) deleteFederationExport(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	sec := corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: federationSecretName(m)}, &sec); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.Delete(ctx, &sec); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete exported bundle: %w", err)
+	}
+	return nil
+}
+
+// syncFederationExport Signs the peer bundle of the cluster again when its
+// peers or their addresses changed, or half of its validity passed, so that
+// the federated clusters importing it never see it expire while the cluster
+// runs.
+func (r *IpfsReconciler) syncFederationExport(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	status := instance.Status.Federation
+	if !exportingBundle(instance) {
+		status.ExportedAt = nil
+		status.ExportExpiresAt = nil
+		return r.deleteFederationExport(ctx, instance)
+	}
+	key, err := r.bundleSigningKey(ctx, instance)
+	if err != nil {
+		return fmt.Errorf("cannot read bundle signing key: %w", err)
+	}
+	if key == nil {
+		return nil
+	}
+	peers, relays, err := r.exportedPeers(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	validity := bundleValidity(instance)
+	sec := corev1.Secret{}
+	sec.Name = federationSecretName(instance)
+	sec.Namespace = instance.Namespace
+	err = r.Get(ctx, client.ObjectKeyFromObject(&sec), &sec)
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot get exported bundle: %w", err)
+	}
+	bundle := federation.Bundle{
+		Cluster:   instance.Namespace + "/" + instance.Name,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(validity),
+		Peers:     peers,
+		Relays:    relays,
+	}
+	current, err := federation.Open(sec.Data[federation.BundleKey], now)
+	if err == nil && current.ExpiresAt.Sub(now) > validity/2 &&
+		current.Cluster == bundle.Cluster &&
+		equality.Semantic.DeepEqual(current.Peers, bundle.Peers) &&
+		equality.Semantic.DeepEqual(current.Relays, bundle.Relays) {
+		bundle = *current
+	} else {
+		data, err := federation.Sign(bundle, key)
+		if err != nil {
+			return err
+		}
+		_, err = controllerutil.CreateOrPatch(ctx, r.Client, &sec, func() error {
+			sec.Labels = ipfsLabels(instance, componentFederation)
+			sec.Data = map[string][]byte{federation.BundleKey: data}
+			return ctrl.SetControllerReference(instance, &sec, r.Scheme)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot write exported bundle: %w", err)
+		}
+	}
+	exportedAt := metav1.NewTime(bundle.IssuedAt)
+	expiresAt := metav1.NewTime(bundle.ExpiresAt)
+	status.ExportedAt = &exportedAt
+	status.ExportExpiresAt = &expiresAt
+	return nil
+}
+
+// importBundles Opens the bundles the cluster imports. A bundle which is
+// missing, malformed, expired or not signed by a trusted signer is reported
+// with its error.
+func (r *IpfsReconciler) importBundles(ctx context.Context, m *clusterv1alpha1.Ipfs) []importedBundle {
+	log := ctrllog.FromContext(ctx)
+	now := time.Now()
+	imports := []importedBundle{}
+	for _, name := range referencedFederationSecrets(m) {
+		imported := importedBundle{secret: name}
+		sec := corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: name}, &sec)
+		switch {
+		case err != nil:
+			log.Info("cannot get federation bundle", "secret", name, "error", err.Error())
+			imported.err = fmt.Errorf("cannot get secret: %w", err)
+		case sec.Data[federation.BundleKey] == nil:
+			imported.err = fmt.Errorf("secret has no %s key", federation.BundleKey)
+		default:
+			imported.bundle, imported.err = federation.Open(sec.Data[federation.BundleKey], now)
+		}
+		if imported.err == nil && !trustedSigner(m, imported.bundle.Signer) {
+			imported.err = fmt.Errorf("signer %s is not trusted", imported.bundle.Signer)
+			imported.bundle = nil
+		}
+		imports = append(imports, imported)
+	}
+	return imports
+}
+
+// trustedSigner Returns whether the cluster imports the bundles signed by
+// the given peer ID.
+func trustedSigner(m *clusterv1alpha1.Ipfs, signer string) bool {
+	trusted := m.Spec.Federation.TrustedSigners
+	if len(trusted) == 0 {
+		return true
+	}
+	for _, id := range trusted {
+		if id == signer {
+			return true
+		}
+	}
+	return false
+}
+
+// importedAddrInfos Returns the peers of the valid bundles the cluster
+// imports, to add to the peering and bootstrap configuration of its kubo
+// nodes.
+func (r *IpfsReconciler) importedAddrInfos(ctx context.Context, m *clusterv1alpha1.Ipfs) []*peer.AddrInfo {
+	infos := []*peer.AddrInfo{}
+	for _, imported := range r.importBundles(ctx, m) {
+		if imported.bundle != nil {
+			infos = append(infos, imported.bundle.AddrInfos()...)
+		}
+	}
+	return infos
+}
+
+// syncFederationImports Records the imported bundles in the status, and sets
+// the FederationDegraded condition when some of them cannot be used.
+func (r *IpfsReconciler) syncFederationImports(ctx context.Context, instance *clusterv1alpha1.Ipfs) {
+	imports := r.importBundles(ctx, instance)
+	status := instance.Status.Federation
+	status.Imports = nil
+	if len(imports) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionFederationDegraded)
+		return
+	}
+	var failed []string
+	expired := true
+	for _, imported := range imports {
+		entry := clusterv1alpha1.ImportedBundle{SecretName: imported.secret}
+		if imported.err != nil {
+			entry.Error = imported.err.Error()
+			failed = append(failed, imported.secret+": "+entry.Error)
+			expired = expired && errors.Is(imported.err, federation.ErrExpired)
+		} else {
+			expiresAt := metav1.NewTime(imported.bundle.ExpiresAt)
+			entry.Cluster = imported.bundle.Cluster
+			entry.Peers = int32(len(imported.bundle.Peers))
+			entry.ExpiresAt = &expiresAt
+		}
+		status.Imports = append(status.Imports, entry)
+	}
+	condition := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionFederationDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.FederationDegradedReasonBundlesImported,
+		Message:            "every peer bundle is imported",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(failed) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = clusterv1alpha1.FederationDegradedReasonBundleInvalid
+		if expired {
+			condition.Reason = clusterv1alpha1.FederationDegradedReasonBundleExpired
+		}
+		condition.Message = "peer bundles not imported: " + strings.Join(failed, "; ")
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/federation"
+)
+
+// newID Returns the ID of a new peer identity.
+func newID() string {
+	id, _, err := generateIdentity()
+	Expect(err).NotTo(HaveOccurred())
+	return id.String()
+}
+
+var _ = Describe("Ipfs federation", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		east       *clusterv1alpha1.Ipfs
+		west       *clusterv1alpha1.Ipfs
+		kuboID     string
+	)
+
+	// copyBundle Copies the bundle exported by east to a Secret west imports.
+	copyBundle := func(name string) {
+		exported := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: east.Namespace, Name: "east-federation"},
+			exported)).To(Succeed())
+		imported := &corev1.Secret{}
+		imported.Name = name
+		imported.Namespace = west.Namespace
+		imported.Data = exported.Data
+		Expect(fakeClient.Create(ctx, imported)).To(Succeed())
+	}
+
+	degraded := func() *metav1.Condition {
+		Expect(reconciler.syncFederation(ctx, west)).To(Succeed())
+		return meta.FindStatusCondition(west.Status.Conditions, clusterv1alpha1.ConditionFederationDegraded)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		_, priv, err := generateIdentity()
+		Expect(err).NotTo(HaveOccurred())
+		secret := &corev1.Secret{}
+		secret.Name = "ipfs-cluster-east"
+		secret.Namespace = "default"
+		secret.Data = map[string][]byte{bootstrapPrivateKeyKey: []byte(priv)}
+		relay := &clusterv1alpha1.CircuitRelay{}
+		relay.Name = "east-0"
+		relay.Namespace = "default"
+		relay.Status.AddrInfo = clusterv1alpha1.AddrInfoBasicType{
+			ID:    newID(),
+			Addrs: []string{"/ip4/203.0.113.7/tcp/4001"},
+		}
+
+		kuboID = newID()
+		east = &clusterv1alpha1.Ipfs{}
+		east.Name = "east"
+		east.Namespace = "default"
+		east.Spec.Federation = &clusterv1alpha1.FederationConfig{Export: true}
+		east.Status.CircuitRelays = []string{relay.Name}
+		east.Status.Peers = []clusterv1alpha1.PeerStatus{{Name: "ipfs-cluster-east-0", KuboID: kuboID}}
+		west = &clusterv1alpha1.Ipfs{}
+		west.Name = "west"
+		west.Namespace = "default"
+		west.Spec.IpfsStorage = "1Gi"
+		west.Spec.ClusterStorage = "1Gi"
+		west.Spec.Federation = &clusterv1alpha1.FederationConfig{
+			ImportSecretRefs: []corev1.LocalObjectReference{{Name: "east-bundle"}},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(east, west, secret, relay).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("imports the peers exported by a federated cluster", func() {
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		Expect(east.Status.Federation.ExportExpiresAt).NotTo(BeNil())
+		Expect(east.Status.Federation.ExportExpiresAt.Sub(east.Status.Federation.ExportedAt.Time)).
+			To(Equal(defaultBundleValidity))
+		copyBundle("east-bundle")
+
+		cond := degraded()
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.FederationDegradedReasonBundlesImported))
+		Expect(west.Status.Federation.Imports).To(HaveLen(1))
+		Expect(west.Status.Federation.Imports[0].Cluster).To(Equal("default/east"))
+		Expect(west.Status.Federation.Imports[0].Peers).To(Equal(int32(1)))
+
+		By("peering with the imported peers through the relays of their cluster")
+		cm := &corev1.ConfigMap{}
+		mut, _ := reconciler.configMapScripts(ctx, west, cm)
+		Expect(mut()).To(Succeed())
+		Expect(cm.Data["configure-ipfs.sh"]).To(ContainSubstring(kuboID))
+		Expect(cm.Data["configure-ipfs.sh"]).To(ContainSubstring("/p2p-circuit"))
+		Expect(cm.Data["configure-ipfs.sh"]).To(ContainSubstring("ipfs bootstrap add /ip4/203.0.113.7/tcp/4001/p2p/"))
+	})
+
+	It("signs the bundle again once the peers change", func() {
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		exported := &corev1.Secret{}
+		key := types.NamespacedName{Namespace: east.Namespace, Name: "east-federation"}
+		Expect(fakeClient.Get(ctx, key, exported)).To(Succeed())
+		first := exported.Data[federation.BundleKey]
+
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, exported)).To(Succeed())
+		Expect(exported.Data[federation.BundleKey]).To(Equal(first))
+
+		east.Status.Peers[0].KuboID = newID()
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, exported)).To(Succeed())
+		bundle, err := federation.Open(exported.Data[federation.BundleKey], time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bundle.Peers[0].ID).To(Equal(east.Status.Peers[0].KuboID))
+
+		By("deleting the export once it is disabled")
+		east.Spec.Federation = nil
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, key, exported))).To(BeTrue())
+		Expect(east.Status.Federation).To(BeNil())
+	})
+
+	It("reports the bundles which cannot be imported", func() {
+		cond := degraded()
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.FederationDegradedReasonBundleInvalid))
+		Expect(cond.Message).To(ContainSubstring("east-bundle"))
+
+		By("reporting an expired bundle")
+		east.Spec.Federation.BundleValidity = &metav1.Duration{Duration: time.Second}
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		copyBundle("east-bundle")
+		Eventually(func() string {
+			return degraded().Reason
+		}, 3*time.Second, 200*time.Millisecond).Should(Equal(clusterv1alpha1.FederationDegradedReasonBundleExpired))
+		Expect(west.Status.Federation.Imports[0].Error).To(ContainSubstring("expired"))
+		Expect(reconciler.importedAddrInfos(ctx, west)).To(BeEmpty())
+
+		By("refusing a bundle of a signer which is not trusted")
+		east.Spec.Federation.BundleValidity = nil
+		Expect(reconciler.syncFederation(ctx, east)).To(Succeed())
+		Expect(fakeClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace: west.Namespace, Name: "east-bundle"}})).To(Succeed())
+		copyBundle("east-bundle")
+		west.Spec.Federation.TrustedSigners = []string{newID()}
+		cond = degraded()
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.FederationDegradedReasonBundleInvalid))
+		Expect(cond.Message).To(ContainSubstring("not trusted"))
+	})
+})
@@ -0,0 +1,319 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// DefaultFleetHealthInterval Is how often the health of the fleet is
+	// summarized.
+	DefaultFleetHealthInterval = 30 * time.Second
+	// FleetHealthPath Is the path of the metrics server serving the health
+	// of the fleet, next to the readiness of each cluster.
+	FleetHealthPath = "/readyz/ipfs"
+	// OperatorConditionNameEnv Is the variable OLM sets on the operator to
+	// the name of its OperatorCondition.
+	OperatorConditionNameEnv = "OPERATOR_CONDITION_NAME"
+	// fleetHealthConditionType Is the condition of the OperatorCondition
+	// reporting the health of the fleet.
+	fleetHealthConditionType = "IpfsFleetReady"
+	// fleetHealthMaxNames Bounds the number of clusters named by the summary.
+	fleetHealthMaxNames = 10
+)
+
+// operatorConditionGVK Is the OperatorCondition kind of OLM. The operator
+// does not depend on the OLM API and writes it as an unstructured object.
+// OLM grants the operator access to its own OperatorCondition, so that the
+// roles of the operator do not list it.
+var operatorConditionGVK = schema.GroupVersionKind{Group: "operators.coreos.com", Version: "v2",
+	Kind: "OperatorCondition"}
+
+// fleetHealthClusters Is the number of clusters in each state, as of the
+// last summary of the health of the fleet.
+var fleetHealthClusters = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ipfs_operator_fleet_health_clusters",
+		Help: "Number of clusters, of ready clusters, of clusters with version skew and of clusters " +
+			"missing peers or running extra ones, as of the last summary of the health of the fleet.",
+	},
+	[]string{"state"},
+)
+
+// FleetHealth Summarizes the health of the clusters the operator manages.
+type FleetHealth struct {
+	// Total Is the number of clusters, those being deleted aside.
+	Total int
+	// Ready Is the number of clusters whose Ready condition is true.
+	Ready int
+	// VersionSkewed Is the number of clusters whose peers run different images.
+	VersionSkewed int
+	// PeerCountDrift Is the number of clusters whose recorded peers differ
+	// in number from the replicas they should run.
+	PeerCountDrift int
+	// NotReady Are the clusters which are not ready, as namespace/name.
+	NotReady []string
+}
+
+// summarizeFleetHealth Returns the health of the clusters, from their status.
+func summarizeFleetHealth(items []clusterv1alpha1.Ipfs) FleetHealth {
+	var health FleetHealth
+	for i := range items {
+		m := &items[i]
+		if m.DeletionTimestamp != nil {
+			continue
+		}
+		health.Total++
+		if meta.IsStatusConditionTrue(m.Status.Conditions, clusterv1alpha1.ConditionReady) {
+			health.Ready++
+		} else {
+			health.NotReady = append(health.NotReady, m.Namespace+"/"+m.Name)
+		}
+		if meta.IsStatusConditionTrue(m.Status.Conditions, clusterv1alpha1.ConditionVersionSkew) {
+			health.VersionSkewed++
+		}
+		if int32(len(m.Status.Peers)) != peerCount(m) {
+			health.PeerCountDrift++
+		}
+	}
+	sort.Strings(health.NotReady)
+	return health
+}
+
+// Healthy Returns whether every cluster is ready.
+func (h FleetHealth) Healthy() bool {
+	return h.Ready == h.Total
+}
+
+// String Describes the health, naming the first clusters which are not ready.
+func (h FleetHealth) String() string {
+	summary := fmt.Sprintf("%d of %d Ipfs resources ready", h.Ready, h.Total)
+	if h.VersionSkewed > 0 {
+		summary += fmt.Sprintf(", %d with version skew", h.VersionSkewed)
+	}
+	if h.PeerCountDrift > 0 {
+		summary += fmt.Sprintf(", %d with peer count drift", h.PeerCountDrift)
+	}
+	if len(h.NotReady) == 0 {
+		return summary
+	}
+	names := h.NotReady
+	if len(names) > fleetHealthMaxNames {
+		names = names[:fleetHealthMaxNames]
+	}
+	summary += "; not ready: " + strings.Join(names, ", ")
+	if more := len(h.NotReady) - len(names); more > 0 {
+		summary += fmt.Sprintf(" and %d more", more)
+	}
+	return summary
+}
+
+// OperatorConditionFromEnv Returns the OperatorCondition of the operator,
+// and whether it runs under OLM, which names it in OperatorConditionNameEnv.
+// The OperatorCondition lives in the namespace of the operator.
+func OperatorConditionFromEnv() (types.NamespacedName, bool) {
+	name, namespace := os.Getenv(OperatorConditionNameEnv), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
+
+// FleetHealthReporter Summarizes the health of the clusters on every
+// interval, from the statuses in the cache only. The summary is served by
+// FleetHealthPath and exported as the ipfs_operator_fleet_health_clusters
+// metric, and, under OLM, set as a condition of the OperatorCondition of the
+// operator, for the console to show degraded fleets. It runs on every
+// replica, so that each serves the summary, but only the leader writes the
+// OperatorCondition.
+type FleetHealthReporter struct {
+	// Reader Reads the Ipfs resources. It is the cache of the manager.
+	Reader client.Reader
+	// Writer Writes the OperatorCondition. The summary is not written when
+	// nil, such as when the operator does not run under OLM.
+	Writer client.Client
+	// OperatorCondition Is the OperatorCondition of the operator.
+	OperatorCondition types.NamespacedName
+	// Elected Is closed once the replica leads, usually the Elected channel
+	// of the manager. The replica always leads when nil.
+	Elected <-chan struct{}
+	// Interval Is how often the health is summarized, which bounds the rate
+	// of the writes of the OperatorCondition.
+	Interval time.Duration
+
+	mu      sync.RWMutex
+	current *FleetHealth
+	written string
+}
+
+var _ manager.Runnable = &FleetHealthReporter{}
+
+// Start Summarizes the health on every interval until the context is done.
+func (f *FleetHealthReporter) Start(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("fleet-health")
+	interval := f.Interval
+	if interval <= 0 {
+		interval = DefaultFleetHealthInterval
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := f.sync(ctx); err != nil {
+			log.Error(err, "cannot summarize the health of the fleet")
+		}
+	}, interval)
+	return nil
+}
+
+// NeedLeaderElection Runs the reporter on every replica.
+func (f *FleetHealthReporter) NeedLeaderElection() bool {
+	return false
+}
+
+// sync Summarizes the health, exports it and writes it to the
+// OperatorCondition when it changed.
+func (f *FleetHealthReporter) sync(ctx context.Context) error {
+	ipfsList := clusterv1alpha1.IpfsList{}
+	if err := f.Reader.List(ctx, &ipfsList); err != nil {
+		return fmt.Errorf("cannot list Ipfs resources: %w", err)
+	}
+	health := summarizeFleetHealth(ipfsList.Items)
+	fleetHealthClusters.WithLabelValues("total").Set(float64(health.Total))
+	fleetHealthClusters.WithLabelValues("ready").Set(float64(health.Ready))
+	fleetHealthClusters.WithLabelValues("version_skew").Set(float64(health.VersionSkewed))
+	fleetHealthClusters.WithLabelValues("peer_count_drift").Set(float64(health.PeerCountDrift))
+	f.mu.Lock()
+	f.current = &health
+	written := f.written
+	f.mu.Unlock()
+
+	if f.Writer == nil || !f.leading() || written == health.String() {
+		return nil
+	}
+	if err := f.writeOperatorCondition(ctx, health); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.written = health.String()
+	f.mu.Unlock()
+	return nil
+}
+
+// leading Returns whether the replica leads.
+func (f *FleetHealthReporter) leading() bool {
+	if f.Elected == nil {
+		return true
+	}
+	select {
+	case <-f.Elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeOperatorCondition Sets the condition of the OperatorCondition
+// reporting the health, keeping the conditions the operator sets otherwise.
+// A cluster serving no OperatorCondition kind is left alone.
+func (f *FleetHealthReporter) writeOperatorCondition(ctx context.Context, health FleetHealth) error {
+	oc := &unstructured.Unstructured{}
+	oc.SetGroupVersionKind(operatorConditionGVK)
+	if err := f.Writer.Get(ctx, f.OperatorCondition, oc); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot get OperatorCondition %s: %w", f.OperatorCondition, err)
+	}
+	raw, _, err := unstructured.NestedSlice(oc.Object, "spec", "conditions")
+	if err != nil {
+		return fmt.Errorf("cannot read the conditions of OperatorCondition %s: %w", f.OperatorCondition, err)
+	}
+	var conditions []metav1.Condition
+	for _, item := range raw {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cond := metav1.Condition{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &cond); err != nil {
+			return fmt.Errorf("cannot read the conditions of OperatorCondition %s: %w", f.OperatorCondition, err)
+		}
+		conditions = append(conditions, cond)
+	}
+	cond := metav1.Condition{
+		Type:    fleetHealthConditionType,
+		Status:  metav1.ConditionTrue,
+		Reason:  "AllClustersReady",
+		Message: health.String(),
+	}
+	if !health.Healthy() {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = "ClustersNotReady"
+	}
+	meta.SetStatusCondition(&conditions, cond)
+	raw = make([]interface{}, 0, len(conditions))
+	for i := range conditions {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&conditions[i])
+		if err != nil {
+			return fmt.Errorf("cannot write the conditions of OperatorCondition %s: %w", f.OperatorCondition, err)
+		}
+		raw = append(raw, obj)
+	}
+	if err = unstructured.SetNestedSlice(oc.Object, raw, "spec", "conditions"); err != nil {
+		return fmt.Errorf("cannot write the conditions of OperatorCondition %s: %w", f.OperatorCondition, err)
+	}
+	if err = f.Writer.Update(ctx, oc); err != nil {
+		return fmt.Errorf("cannot update OperatorCondition %s: %w", f.OperatorCondition, err)
+	}
+	return nil
+}
+
+// Health Returns the last summary, and whether the health was summarized yet.
+func (f *FleetHealthReporter) Health() (FleetHealth, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.current == nil {
+		return FleetHealth{}, false
+	}
+	return *f.current, true
+}
+
+// Check Is the readyz check of the reporter. It only fails until the health
+// was first summarized, as clusters which are not ready do not keep the
+// operator from working.
+func (f *FleetHealthReporter) Check(_ *http.Request) error {
+	if _, ok := f.Health(); !ok {
+		return fmt.Errorf("the health of the fleet was not summarized yet")
+	}
+	return nil
+}
+
+// ServeHTTP Writes the last summary. It answers 503 until the health was
+// first summarized, and 200 afterwards, the clusters which are not ready
+// included.
+func (f *FleetHealthReporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	health, ok := f.Health()
+	if !ok {
+		http.Error(w, "the health of the fleet was not summarized yet", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, health.String())
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Fleet health", func() {
+	var ctx context.Context
+
+	// cluster Returns a cluster of the given replicas, recording the given
+	// number of peers, with the given conditions true.
+	cluster := func(name string, replicas, peers int32, conditions ...string) clusterv1alpha1.Ipfs {
+		m := clusterv1alpha1.Ipfs{}
+		m.Name = name
+		m.Namespace = "default"
+		m.Spec.Replicas = replicas
+		for i := int32(0); i < peers; i++ {
+			m.Status.Peers = append(m.Status.Peers, clusterv1alpha1.PeerStatus{Name: peerPodName(&m, i)})
+		}
+		for _, condition := range conditions {
+			m.Status.Conditions = append(m.Status.Conditions, metav1.Condition{
+				Type: condition, Status: metav1.ConditionTrue, Reason: "Test",
+			})
+		}
+		return m
+	}
+
+	// fleet Returns the clusters of a fleet of three clusters, one of which
+	// is ready.
+	fleet := func() []clusterv1alpha1.Ipfs {
+		return []clusterv1alpha1.Ipfs{
+			cluster("blue", 3, 3, clusterv1alpha1.ConditionReady),
+			cluster("green", 3, 3, clusterv1alpha1.ConditionVersionSkew),
+			cluster("red", 3, 1),
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("sums up the clusters which are ready and those which drift", func() {
+		clusters := fleet()
+		deleted := cluster("gone", 1, 0)
+		deleted.DeletionTimestamp = &metav1.Time{}
+		clusters = append(clusters, deleted)
+
+		health := summarizeFleetHealth(clusters)
+		Expect(health).To(Equal(FleetHealth{
+			Total:          3,
+			Ready:          1,
+			VersionSkewed:  1,
+			PeerCountDrift: 1,
+			NotReady:       []string{"default/green", "default/red"},
+		}))
+		Expect(health.Healthy()).To(BeFalse())
+		Expect(health.String()).To(Equal("1 of 3 Ipfs resources ready, 1 with version skew, " +
+			"1 with peer count drift; not ready: default/green, default/red"))
+
+		Expect(summarizeFleetHealth(nil).Healthy()).To(BeTrue())
+		Expect(summarizeFleetHealth(nil).String()).To(Equal("0 of 0 Ipfs resources ready"))
+	})
+
+	It("only names the first clusters which are not ready", func() {
+		var clusters []clusterv1alpha1.Ipfs
+		for i := 0; i < fleetHealthMaxNames+2; i++ {
+			clusters = append(clusters, cluster(fmt.Sprintf("cluster-%02d", i), 1, 1))
+		}
+		Expect(summarizeFleetHealth(clusters).String()).To(HaveSuffix("default/cluster-09 and 2 more"))
+	})
+
+	It("serves the health without writing an OperatorCondition outside of OLM", func() {
+		previous, set := os.LookupEnv(OperatorConditionNameEnv)
+		Expect(os.Unsetenv(OperatorConditionNameEnv)).To(Succeed())
+		defer func() {
+			if set {
+				os.Setenv(OperatorConditionNameEnv, previous)
+			}
+		}()
+		_, underOLM := OperatorConditionFromEnv()
+		Expect(underOLM).To(BeFalse())
+
+		var objs []client.Object
+		for _, m := range fleet() {
+			m := m
+			objs = append(objs, &m)
+		}
+		reporter := &FleetHealthReporter{Reader: fake.NewClientBuilder().WithObjects(objs...).Build()}
+
+		Expect(reporter.Check(nil)).NotTo(Succeed())
+		rec := httptest.NewRecorder()
+		reporter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, FleetHealthPath, nil))
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+
+		Expect(reporter.sync(ctx)).To(Succeed())
+		Expect(reporter.Check(nil)).To(Succeed())
+		rec = httptest.NewRecorder()
+		reporter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, FleetHealthPath, nil))
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(HavePrefix("1 of 3 Ipfs resources ready"))
+		Expect(testutil.ToFloat64(fleetHealthClusters.WithLabelValues("total"))).To(Equal(3.0))
+		Expect(testutil.ToFloat64(fleetHealthClusters.WithLabelValues("ready"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(fleetHealthClusters.WithLabelValues("peer_count_drift"))).To(Equal(1.0))
+	})
+
+	It("sets the health on the OperatorCondition once leading", func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		scheme.AddKnownTypeWithName(operatorConditionGVK, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(operatorConditionGVK.GroupVersion().WithKind(operatorConditionGVK.Kind+"List"),
+			&unstructured.UnstructuredList{})
+
+		key := types.NamespacedName{Namespace: "ipfs-operator-system", Name: "ipfs-operator.v0.0.1"}
+		oc := &unstructured.Unstructured{}
+		oc.SetGroupVersionKind(operatorConditionGVK)
+		oc.SetNamespace(key.Namespace)
+		oc.SetName(key.Name)
+		Expect(unstructured.SetNestedSlice(oc.Object, []interface{}{map[string]interface{}{
+			"type": "Upgradeable", "status": "False", "reason": "Migrating", "message": "wait",
+			"lastTransitionTime": "2022-01-01T00:00:00Z",
+		}}, "spec", "conditions")).To(Succeed())
+		var objs []client.Object
+		for _, m := range fleet() {
+			m := m
+			objs = append(objs, &m)
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, oc)...).Build()
+
+		elected := make(chan struct{})
+		reporter := &FleetHealthReporter{Reader: c, Writer: c, OperatorCondition: key, Elected: elected}
+		conditions := func() []interface{} {
+			stored := &unstructured.Unstructured{}
+			stored.SetGroupVersionKind(operatorConditionGVK)
+			Expect(c.Get(ctx, key, stored)).To(Succeed())
+			conditions, _, err := unstructured.NestedSlice(stored.Object, "spec", "conditions")
+			Expect(err).NotTo(HaveOccurred())
+			return conditions
+		}
+
+		Expect(reporter.sync(ctx)).To(Succeed())
+		Expect(conditions()).To(HaveLen(1))
+
+		close(elected)
+		Expect(reporter.sync(ctx)).To(Succeed())
+		written := conditions()
+		Expect(written).To(HaveLen(2))
+		Expect(written[0]).To(HaveKeyWithValue("type", "Upgradeable"))
+		Expect(written[1]).To(HaveKeyWithValue("type", fleetHealthConditionType))
+		Expect(written[1]).To(HaveKeyWithValue("status", "False"))
+		Expect(written[1]).To(HaveKeyWithValue("reason", "ClustersNotReady"))
+		Expect(written[1]).To(HaveKeyWithValue("message", ContainSubstring("1 of 3 Ipfs resources ready")))
+	})
+})
@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// DefaultFleetReportInterval Is how often the fleet report is built.
+	DefaultFleetReportInterval = 10 * time.Minute
+	// DefaultFleetRelayThreshold Is how many clusters may depend on the
+	// same circuit relay before the relay is flagged.
+	DefaultFleetRelayThreshold = 3
+)
+
+var (
+	// fleetClustersPerNode Is the number of clusters running peers on each node.
+	fleetClustersPerNode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_fleet_clusters_per_node",
+			Help: "Number of clusters running peers on each node, as of the last fleet report.",
+		},
+		[]string{"node"},
+	)
+	// fleetClustersPerStorageClass Is the number of clusters storing their
+	// volumes in each storage class.
+	fleetClustersPerStorageClass = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_fleet_clusters_per_storage_class",
+			Help: "Number of clusters storing their volumes in each storage class, as of the last fleet report.",
+		},
+		[]string{"storage_class"},
+	)
+	// fleetClustersPerRelay Is the number of clusters depending on each
+	// circuit relay.
+	fleetClustersPerRelay = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_fleet_clusters_per_relay",
+			Help: "Number of clusters depending on each circuit relay, as of the last fleet report.",
+		},
+		[]string{"relay"},
+	)
+	// fleetSharedResources Is the number of clusters sharing each resource
+	// flagged by the fleet report.
+	fleetSharedResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_fleet_shared_resource_clusters",
+			Help: "Number of clusters sharing each node or relay flagged by the last fleet report.",
+		},
+		[]string{"kind", "name"},
+	)
+)
+
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfsfleetreports,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfsfleetreports/status,verbs=get;update;patch
+
+// FleetReporter Periodically maps every Ipfs resource to the nodes running
+// its peers, the storage class of its volumes and its circuit relays, flags
+// the nodes running most of the peers of several clusters and the relays
+// many clusters depend on, and exports the report as metrics and,
+// optionally, as the IpfsFleetReport named fleet. It only runs on the leader.
+type FleetReporter struct {
+	// Reader Reads the Ipfs resources, their pods and their circuit relays.
+	// It is the cache of the manager, so that the report does not load the
+	// API server.
+	Reader client.Reader
+	// Writer Maintains the IpfsFleetReport. The report is only exported as
+	// metrics when nil.
+	Writer   client.Client
+	Defaults *DefaultsStore
+	// Interval Is how often the report is built.
+	Interval time.Duration
+	// RelayThreshold Is how many clusters may depend on the same relay
+	// before it is flagged.
+	RelayThreshold int
+}
+
+var _ manager.Runnable = &FleetReporter{}
+
+// Start Builds the report on every interval until the context is done. A
+// failed report is logged and built again on the next interval.
+func (f *FleetReporter) Start(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("fleet-report")
+	interval := f.Interval
+	if interval <= 0 {
+		interval = DefaultFleetReportInterval
+	}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := f.sync(ctx); err != nil {
+			log.Error(err, "cannot build the fleet report")
+		}
+	}, interval)
+	return nil
+}
+
+// NeedLeaderElection Builds the report on the leader only, as a single
+// replica writes the IpfsFleetReport.
+func (f *FleetReporter) NeedLeaderElection() bool {
+	return true
+}
+
+// sync Builds the report, exports it as metrics and writes the
+// IpfsFleetReport.
+func (f *FleetReporter) sync(ctx context.Context) error {
+	report, err := f.Report(ctx)
+	if err != nil {
+		return err
+	}
+	exportFleetReport(report)
+	if f.Writer == nil {
+		return nil
+	}
+	return f.writeReport(ctx, report)
+}
+
+// Report Builds the report from the cache.
+func (f *FleetReporter) Report(ctx context.Context) (*clusterv1alpha1.IpfsFleetReportStatus, error) {
+	ipfsList := clusterv1alpha1.IpfsList{}
+	if err := f.Reader.List(ctx, &ipfsList); err != nil {
+		return nil, fmt.Errorf("cannot list Ipfs resources: %w", err)
+	}
+	relays := clusterv1alpha1.CircuitRelayList{}
+	if err := f.Reader.List(ctx, &relays); err != nil {
+		return nil, fmt.Errorf("cannot list circuit relays: %w", err)
+	}
+	relayIDs := fleetRelayIDs(relays.Items)
+
+	report := &clusterv1alpha1.IpfsFleetReportStatus{GeneratedAt: metav1.Now()}
+	for i := range ipfsList.Items {
+		m := &ipfsList.Items[i]
+		cluster, err := f.fleetCluster(ctx, m, relayIDs)
+		if err != nil {
+			return nil, err
+		}
+		report.Clusters = append(report.Clusters, cluster)
+	}
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		if report.Clusters[i].Namespace != report.Clusters[j].Namespace {
+			return report.Clusters[i].Namespace < report.Clusters[j].Namespace
+		}
+		return report.Clusters[i].Name < report.Clusters[j].Name
+	})
+	threshold := f.RelayThreshold
+	if threshold <= 0 {
+		threshold = DefaultFleetRelayThreshold
+	}
+	report.SharedResources = sharedResources(report.Clusters, threshold)
+	report.SharedResourceCount = int32(len(report.SharedResources))
+	return report, nil
+}
+
+// fleetRelayIDs Returns the peer IDs of the circuit relays by namespace and
+// name.
+func fleetRelayIDs(relays []clusterv1alpha1.CircuitRelay) map[string]string {
+	relayIDs := make(map[string]string, len(relays))
+	for i := range relays {
+		relay := &relays[i]
+		id := relay.Status.AddrInfo.ID
+		if id == "" {
+			// The relay has not started yet: it is only known by its name.
+			id = relay.Namespace + "/" + relay.Name
+		}
+		relayIDs[relay.Namespace+"/"+relay.Name] = id
+	}
+	return relayIDs
+}
+
+// fleetCluster Returns the entry of the cluster in the report: its storage
+// class, the nodes running its peers and the relays it depends on.
+func (f *FleetReporter) fleetCluster(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	relayIDs map[string]string,
+) (clusterv1alpha1.FleetCluster, error) {
+	cluster := clusterv1alpha1.FleetCluster{Namespace: m.Namespace, Name: m.Name}
+	if class := f.Defaults.For(m).apply(m).Spec.StorageClassName; class != nil {
+		cluster.StorageClass = *class
+	}
+	pods := corev1.PodList{}
+	if err := f.Reader.List(ctx, &pods, client.InNamespace(m.Namespace),
+		client.MatchingLabels(peerSelector(m))); err != nil {
+		return cluster, fmt.Errorf("cannot list the peer pods of %s/%s: %w", m.Namespace, m.Name, err)
+	}
+	peers := map[string]int32{}
+	for j := range pods.Items {
+		if node := pods.Items[j].Spec.NodeName; node != "" {
+			peers[node]++
+		}
+	}
+	for node, count := range peers {
+		cluster.Nodes = append(cluster.Nodes, clusterv1alpha1.FleetNode{Name: node, Peers: count})
+	}
+	sort.Slice(cluster.Nodes, func(a, b int) bool { return cluster.Nodes[a].Name < cluster.Nodes[b].Name })
+	for _, name := range m.Status.CircuitRelays {
+		if id, ok := relayIDs[m.Namespace+"/"+name]; ok {
+			cluster.Relays = append(cluster.Relays, id)
+		}
+	}
+	sort.Strings(cluster.Relays)
+	return cluster, nil
+}
+
+// sharedResources Flags the nodes running most of the peers of at least two
+// clusters, and the relays at least threshold clusters depend on, sorted by
+// kind and name.
+func sharedResources(clusters []clusterv1alpha1.FleetCluster, threshold int) []clusterv1alpha1.SharedResource {
+	majorities := map[string][]string{}
+	relays := map[string][]string{}
+	for _, cluster := range clusters {
+		name := cluster.Namespace + "/" + cluster.Name
+		var total int32
+		for _, node := range cluster.Nodes {
+			total += node.Peers
+		}
+		for _, node := range cluster.Nodes {
+			if 2*node.Peers > total {
+				majorities[node.Name] = append(majorities[node.Name], name)
+			}
+		}
+		for _, relay := range uniqueStrings(cluster.Relays) {
+			relays[relay] = append(relays[relay], name)
+		}
+	}
+	var shared []clusterv1alpha1.SharedResource
+	for node, names := range majorities {
+		if len(names) < 2 {
+			continue
+		}
+		shared = append(shared, clusterv1alpha1.SharedResource{
+			Kind:     clusterv1alpha1.SharedResourceNode,
+			Name:     node,
+			Clusters: names,
+			Message: fmt.Sprintf("the node runs most of the peers of %d clusters, which its failure would all take down",
+				len(names)),
+		})
+	}
+	for relay, names := range relays {
+		if len(names) < threshold {
+			continue
+		}
+		shared = append(shared, clusterv1alpha1.SharedResource{
+			Kind:     clusterv1alpha1.SharedResourceRelay,
+			Name:     relay,
+			Clusters: names,
+			Message:  fmt.Sprintf("%d clusters depend on the relay, reaching the threshold of %d", len(names), threshold),
+		})
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Kind != shared[j].Kind {
+			return shared[i].Kind < shared[j].Kind
+		}
+		return shared[i].Name < shared[j].Name
+	})
+	return shared
+}
+
+// uniqueStrings Returns the sorted values without duplicates.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// exportFleetReport Sets the fleet metrics from the report, dropping the
+// series of the resources no cluster uses anymore.
+func exportFleetReport(report *clusterv1alpha1.IpfsFleetReportStatus) {
+	fleetClustersPerNode.Reset()
+	fleetClustersPerStorageClass.Reset()
+	fleetClustersPerRelay.Reset()
+	fleetSharedResources.Reset()
+	for _, cluster := range report.Clusters {
+		for _, node := range cluster.Nodes {
+			fleetClustersPerNode.WithLabelValues(node.Name).Inc()
+		}
+		fleetClustersPerStorageClass.WithLabelValues(cluster.StorageClass).Inc()
+		for _, relay := range uniqueStrings(cluster.Relays) {
+			fleetClustersPerRelay.WithLabelValues(relay).Inc()
+		}
+	}
+	for _, shared := range report.SharedResources {
+		fleetSharedResources.WithLabelValues(shared.Kind, shared.Name).Set(float64(len(shared.Clusters)))
+	}
+}
+
+// writeReport Writes the report to the IpfsFleetReport, creating it first
+// when it does not exist.
+func (f *FleetReporter) writeReport(ctx context.Context, report *clusterv1alpha1.IpfsFleetReportStatus) error {
+	obj := &clusterv1alpha1.IpfsFleetReport{}
+	err := f.Writer.Get(ctx, client.ObjectKey{Name: clusterv1alpha1.FleetReportName}, obj)
+	switch {
+	case errors.IsNotFound(err):
+		obj.Name = clusterv1alpha1.FleetReportName
+		if err = f.Writer.Create(ctx, obj); err != nil {
+			return fmt.Errorf("cannot create IpfsFleetReport: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("cannot get IpfsFleetReport: %w", err)
+	}
+	obj.Status = *report
+	if err = f.Writer.Status().Update(ctx, obj); err != nil {
+		return fmt.Errorf("cannot update IpfsFleetReport status: %w", err)
+	}
+	return nil
+}
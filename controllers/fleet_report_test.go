@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Fleet report", func() {
+	var (
+		ctx     context.Context
+		objects []client.Object
+	)
+
+	// addCluster Adds an Ipfs resource with a peer pod on each of the nodes,
+	// depending on the given circuit relays.
+	addCluster := func(namespace, name string, nodes []string, relays ...string) {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = name
+		instance.Namespace = namespace
+		instance.Status.CircuitRelays = relays
+		objects = append(objects, instance)
+		for i, node := range nodes {
+			pod := &corev1.Pod{}
+			pod.Name = peerPodName(instance, int32(i))
+			pod.Namespace = namespace
+			pod.Labels = peerSelector(instance)
+			pod.Spec.NodeName = node
+			objects = append(objects, pod)
+		}
+	}
+
+	// addRelay Adds a circuit relay with the given peer ID.
+	addRelay := func(namespace, name, id string) {
+		relay := &clusterv1alpha1.CircuitRelay{}
+		relay.Name = name
+		relay.Namespace = namespace
+		relay.Status.AddrInfo.ID = id
+		objects = append(objects, relay)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		objects = nil
+	})
+
+	It("flags the nodes and relays shared by the clusters", func() {
+		addCluster("team-a", "blue", []string{"node-1", "node-1", "node-2"}, "blue-relay-0")
+		addCluster("team-b", "blue", []string{"node-1", "node-1", "node-3"}, "blue-relay-0")
+		addCluster("team-c", "green", []string{"node-1", "node-2", "node-3"}, "green-relay-0")
+		addCluster("team-c", "red", []string{"node-2"})
+		addRelay("team-a", "blue-relay-0", "12D3KooWShared")
+		addRelay("team-b", "blue-relay-0", "12D3KooWShared")
+		addRelay("team-c", "green-relay-0", "12D3KooWShared")
+		storageClass := "fast"
+		objects[0].(*clusterv1alpha1.Ipfs).Spec.StorageClassName = &storageClass
+		c := fake.NewClientBuilder().WithObjects(objects...).Build()
+		reporter := &FleetReporter{Reader: c, Writer: c}
+
+		report, err := reporter.Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Clusters).To(HaveLen(4))
+		Expect(report.Clusters[0]).To(Equal(clusterv1alpha1.FleetCluster{
+			Namespace:    "team-a",
+			Name:         "blue",
+			Nodes:        []clusterv1alpha1.FleetNode{{Name: "node-1", Peers: 2}, {Name: "node-2", Peers: 1}},
+			StorageClass: "fast",
+			Relays:       []string{"12D3KooWShared"},
+		}))
+		Expect(report.Clusters[3].Relays).To(BeEmpty())
+		Expect(report.SharedResources).To(Equal([]clusterv1alpha1.SharedResource{
+			{
+				Kind:     clusterv1alpha1.SharedResourceNode,
+				Name:     "node-1",
+				Clusters: []string{"team-a/blue", "team-b/blue"},
+				Message:  "the node runs most of the peers of 2 clusters, which its failure would all take down",
+			},
+			{
+				Kind:     clusterv1alpha1.SharedResourceRelay,
+				Name:     "12D3KooWShared",
+				Clusters: []string{"team-a/blue", "team-b/blue", "team-c/green"},
+				Message:  "3 clusters depend on the relay, reaching the threshold of 3",
+			},
+		}))
+		Expect(report.SharedResourceCount).To(Equal(int32(2)))
+
+		By("writing the report to the IpfsFleetReport")
+		Expect(reporter.sync(ctx)).To(Succeed())
+		obj := &clusterv1alpha1.IpfsFleetReport{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: clusterv1alpha1.FleetReportName}, obj)).To(Succeed())
+		Expect(obj.Status.SharedResourceCount).To(Equal(int32(2)))
+		Expect(obj.Status.Clusters).To(HaveLen(4))
+	})
+
+	It("does not flag the relays below the threshold", func() {
+		addCluster("team-a", "blue", []string{"node-1"}, "relay-0")
+		addCluster("team-b", "blue", []string{"node-2"}, "relay-0")
+		addRelay("team-a", "relay-0", "12D3KooWShared")
+		addRelay("team-b", "relay-0", "12D3KooWShared")
+		c := fake.NewClientBuilder().WithObjects(objects...).Build()
+
+		report, err := (&FleetReporter{Reader: c}).Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.SharedResources).To(BeEmpty())
+
+		report, err = (&FleetReporter{Reader: c, RelayThreshold: 2}).Report(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.SharedResources).To(HaveLen(1))
+		Expect(report.SharedResources[0].Kind).To(Equal(clusterv1alpha1.SharedResourceRelay))
+	})
+})
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	encjson "encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// gatewayNodeScriptKey Is the key of the ConfigMap of the gateway nodes
+// holding the script starting them.
+const gatewayNodeScriptKey = "gateway.sh"
+
+// gatewayNodeScript Configures the repo of a gateway node at every start,
+// then runs its daemon. The repo is a cache: the node neither announces nor
+// keeps the content it serves, which it fetches from the peers it is peered
+// with.
+const gatewayNodeScript = `#!/bin/sh
+set -e
+if [ ! -f /data/ipfs/config ]; then
+	ipfs init --profile server,lowpower --empty-repo
+fi
+rm -f /data/ipfs/repo.lock
+ipfs config Addresses.API /ip4/0.0.0.0/tcp/%[1]d
+ipfs config Addresses.Gateway /ip4/0.0.0.0/tcp/%[2]d
+ipfs config Routing.Type dhtclient
+ipfs config --json Reprovider.Interval '"0"'
+ipfs config --json Swarm.ConnMgr.LowWater 20
+ipfs config --json Swarm.ConnMgr.HighWater 100
+ipfs config Datastore.StorageMax 1GB
+ipfs config --json Peering.Peers '%[3]s'
+ipfs config --json Gateway.HTTPHeaders '%[4]s'
+exec ipfs daemon --migrate=true --enable-gc
+`
+
+// gatewayNodesEnabled Returns whether a gateway node runs on every selected node.
+func gatewayNodesEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Gateway != nil && m.Spec.Gateway.Mode == clusterv1alpha1.GatewayModeDaemonSet
+}
+
+// gatewayNodesName Returns the name of the DaemonSet and ConfigMap of the
+// gateway nodes.
+func gatewayNodesName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-gateway-node-" + m.Name
+}
+
+// localGatewayServiceName Returns the name of the Service routing the
+// clients to the gateway node of their own node.
+func localGatewayServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-gateway-local-" + m.Name
+}
+
+// gatewayNodeSelector Returns the labels selecting the gateway nodes, which
+// the peer selector does not match.
+func gatewayNodeSelector(m *clusterv1alpha1.Ipfs) map[string]string {
+	return map[string]string{labelName: gatewayNodesName(m)}
+}
+
+// gatewayNodeLabels Returns the labels of the objects of the gateway nodes.
+func gatewayNodeLabels(m *clusterv1alpha1.Ipfs) map[string]string {
+	return recommendedLabels(gatewayNodesName(m), m.Name, componentGateway, m.Spec.IpfsImage, string(m.UID))
+}
+
+// gatewayNodeResources Returns the resources of each gateway node, small by
+// default as each node runs one.
+func gatewayNodeResources(m *clusterv1alpha1.Ipfs) corev1.ResourceRequirements {
+	if m.Spec.Gateway.Resources != nil {
+		return *m.Spec.Gateway.Resources
+	}
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("50m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	}
+}
+
+// renderGatewayNodeScript Returns the script starting the gateway nodes,
+// peered with the kubo nodes of the peers.
+func renderGatewayNodeScript(m *clusterv1alpha1.Ipfs) (string, error) {
+	peering, err := encjson.Marshal(kuboPeering(m))
+	if err != nil {
+		return "", fmt.Errorf("cannot render the peering of the gateway nodes: %w", err)
+	}
+	headers, err := encjson.Marshal(gatewayHTTPHeaders(m))
+	if err != nil {
+		return "", fmt.Errorf("cannot render the headers of the gateway nodes: %w", err)
+	}
+	return fmt.Sprintf(gatewayNodeScript, portAPI, portHTTP, peering, singleQuoted(headers)), nil
+}
+
+// configMapGatewayNodes Returns a mutate function for the ConfigMap holding
+// the script of the gateway nodes, and the hash of the script, which rolls
+// the gateway nodes when the peers change.
+func (r *IpfsReconciler) configMapGatewayNodes(
+	m *clusterv1alpha1.Ipfs,
+	cm *corev1.ConfigMap,
+) (controllerutil.MutateFn, string) {
+	script, err := renderGatewayNodeScript(m)
+	if err != nil {
+		return nil, ""
+	}
+	sum := sha256.Sum256([]byte(script))
+	cm.Name = gatewayNodesName(m)
+	cm.Namespace = m.Namespace
+	if err = ctrl.SetControllerReference(m, cm, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		cm.Data = map[string]string{gatewayNodeScriptKey: script}
+		return nil
+	}, hex.EncodeToString(sum[:16])
+}
+
+// serviceGatewayNodes Returns a mutate function for the Service of the
+// gateway nodes. Its internal traffic policy keeps the requests on the node
+// of the client, which the Service refuses when no gateway node runs there.
+func (r *IpfsReconciler) serviceGatewayNodes(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) controllerutil.MutateFn {
+	local := corev1.ServiceInternalTrafficPolicyLocal
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      localGatewayServiceName(m),
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portHTTP,
+					TargetPort: intstr.FromString("http"),
+				},
+			},
+			Selector:              gatewayNodeSelector(m),
+			InternalTrafficPolicy: &local,
+		},
+	}
+	expected.DeepCopyInto(svc)
+	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
+		return func() error { return err }
+	}
+	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		svc.Spec.Ports = expected.Spec.Ports
+		svc.Spec.Selector = expected.Spec.Selector
+		svc.Spec.InternalTrafficPolicy = expected.Spec.InternalTrafficPolicy
+		return nil
+	}
+}
+
+// daemonSetGatewayNodes Returns a mutate function for the DaemonSet running a
+// gateway node on every selected node. The DaemonSet follows the nodes
+// joining and leaving the selection; the script only depends on the peers.
+func (r *IpfsReconciler) daemonSetGatewayNodes(
+	m *clusterv1alpha1.Ipfs,
+	ds *appsv1.DaemonSet,
+	scriptHash string,
+) controllerutil.MutateFn {
+	expected := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gatewayNodesName(m),
+			Namespace: m.Namespace,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: gatewayNodeSelector(m),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: gatewayNodeLabels(m),
+					Annotations: map[string]string{
+						clusterv1alpha1.AnnotationConfigHash: scriptHash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector:      m.Spec.Gateway.NodeSelector,
+					Tolerations:       m.Spec.Gateway.Tolerations,
+					PriorityClassName: m.Spec.PriorityClassName,
+					Containers:        []corev1.Container{gatewayNodeContainer(m)},
+					Volumes:           gatewayNodeVolumes(m),
+				},
+			},
+		},
+	}
+	applySecurityProfile(m, &expected.Spec.Template.Spec)
+	expected.DeepCopyInto(ds)
+	if err := ctrl.SetControllerReference(m, ds, r.Scheme); err != nil {
+		return func() error { return err }
+	}
+	return func() error {
+		spec := expected.Spec
+		if ds.ResourceVersion != "" && ds.Spec.Selector != nil {
+			spec.Selector = ds.Spec.Selector
+		}
+		unchanged, err := specUnchanged(ds, spec, ds.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		ds.Spec.Template = spec.Template
+		return nil
+	}
+}
+
+// gatewayNodeContainer Returns the container of a gateway node, running the
+// gateway script against a store of its own.
+func gatewayNodeContainer(m *clusterv1alpha1.Ipfs) corev1.Container {
+	return corev1.Container{
+		Name:            "ipfs",
+		Image:           m.Spec.IpfsImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh", "/custom/" + gatewayNodeScriptKey},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "IPFS_FD_MAX",
+				Value: "4096",
+			},
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "swarm",
+				ContainerPort: portSwarm,
+				Protocol:      corev1.ProtocolTCP,
+			},
+			{
+				Name:          "api",
+				ContainerPort: portAPI,
+				Protocol:      corev1.ProtocolTCP,
+			},
+			{
+				Name:          "http",
+				ContainerPort: portHTTP,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{
+					Port: intstr.FromString("http"),
+				},
+			},
+			TimeoutSeconds: tenSeconds,
+		},
+		LivenessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{
+					Port: intstr.FromString("swarm"),
+				},
+			},
+			InitialDelaySeconds: thirtySeconds,
+			TimeoutSeconds:      tenSeconds,
+			PeriodSeconds:       secondsPerMinute,
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "ipfs-storage",
+				MountPath: ipfsMountPath,
+			},
+			{
+				Name:      "gateway-script",
+				MountPath: "/custom",
+			},
+		},
+		Resources: gatewayNodeResources(m),
+	}
+}
+
+// gatewayNodeVolumes Returns the volumes of a gateway node: an empty store
+// and the gateway script.
+func gatewayNodeVolumes(m *clusterv1alpha1.Ipfs) []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: "ipfs-storage",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name: "gateway-script",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: gatewayNodesName(m),
+					},
+				},
+			},
+		},
+	}
+}
+
+// syncGatewayCoverage Records how many of the selected nodes run a ready
+// gateway node, as counted by the DaemonSet, which only schedules on the
+// nodes its selector and tolerations admit.
+func (r *IpfsReconciler) syncGatewayCoverage(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if !gatewayNodesEnabled(instance) {
+		instance.Status.GatewayCoverage = nil
+		return nil
+	}
+	ds := appsv1.DaemonSet{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: gatewayNodesName(instance)}, &ds)
+	if errors.IsNotFound(err) {
+		instance.Status.GatewayCoverage = &clusterv1alpha1.GatewayCoverage{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get the DaemonSet of the gateway nodes: %w", err)
+	}
+	instance.Status.GatewayCoverage = &clusterv1alpha1.GatewayCoverage{
+		Ready:    ds.Status.NumberReady,
+		Selected: ds.Status.DesiredNumberScheduled,
+	}
+	return nil
+}
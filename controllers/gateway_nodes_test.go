@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs gateway nodes", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "edge"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.17.0"
+		instance.Spec.Gateway = &clusterv1alpha1.GatewayConfig{
+			Mode:         clusterv1alpha1.GatewayModeDaemonSet,
+			NodeSelector: map[string]string{"node-role.kubernetes.io/edge": ""},
+			Tolerations:  []corev1.Toleration{{Key: "edge", Operator: corev1.TolerationOpExists}},
+		}
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{
+			{Name: peerPodName(instance, 0), KuboID: "12D3KooWPeerA"},
+			{Name: peerPodName(instance, 1), KuboID: "12D3KooWPeerB"},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("runs a gateway peered with the peers on every selected node", func() {
+		cm := &corev1.ConfigMap{}
+		mutCm, scriptHash := reconciler.configMapGatewayNodes(instance, cm)
+		Expect(mutCm()).To(Succeed())
+		script := cm.Data[gatewayNodeScriptKey]
+		Expect(script).To(ContainSubstring(`"ID":"12D3KooWPeerA"`))
+		Expect(script).To(ContainSubstring(`"ID":"12D3KooWPeerB"`))
+		Expect(script).To(ContainSubstring("ipfs config Addresses.Gateway /ip4/0.0.0.0/tcp/8080\n"))
+
+		ds := &appsv1.DaemonSet{}
+		Expect(reconciler.daemonSetGatewayNodes(instance, ds, scriptHash)()).To(Succeed())
+		pod := ds.Spec.Template
+		Expect(pod.Spec.NodeSelector).To(Equal(instance.Spec.Gateway.NodeSelector))
+		Expect(pod.Spec.Tolerations).To(Equal(instance.Spec.Gateway.Tolerations))
+		Expect(pod.Labels).NotTo(HaveKeyWithValue(labelName, peerSelector(instance)[labelName]))
+		Expect(pod.Annotations).To(HaveKeyWithValue(clusterv1alpha1.AnnotationConfigHash, scriptHash))
+		Expect(pod.Spec.Containers[0].Resources.Requests.Memory().String()).To(Equal("128Mi"))
+
+		svc := &corev1.Service{}
+		Expect(reconciler.serviceGatewayNodes(instance, svc)()).To(Succeed())
+		Expect(*svc.Spec.InternalTrafficPolicy).To(Equal(corev1.ServiceInternalTrafficPolicyLocal))
+		Expect(svc.Spec.Selector).To(Equal(ds.Spec.Selector.MatchLabels))
+
+		By("rolling the gateway nodes once the peers change")
+		instance.Status.Peers[1].KuboID = "12D3KooWPeerC"
+		_, changed := reconciler.configMapGatewayNodes(instance, &corev1.ConfigMap{})
+		Expect(changed).NotTo(Equal(scriptHash))
+	})
+
+	It("reports the nodes covered by a ready gateway", func() {
+		Expect(reconciler.syncGatewayCoverage(ctx, instance)).To(Succeed())
+		Expect(*instance.Status.GatewayCoverage).To(Equal(clusterv1alpha1.GatewayCoverage{}))
+
+		ds := &appsv1.DaemonSet{}
+		ds.Name = gatewayNodesName(instance)
+		ds.Namespace = instance.Namespace
+		ds.Status.DesiredNumberScheduled = 3
+		ds.Status.NumberReady = 2
+		Expect(fakeClient.Create(ctx, ds)).To(Succeed())
+		Expect(reconciler.syncGatewayCoverage(ctx, instance)).To(Succeed())
+		Expect(instance.Status.GatewayCoverage.Ready).To(Equal(int32(2)))
+		Expect(instance.Status.GatewayCoverage.Selected).To(Equal(int32(3)))
+
+		By("clearing the coverage once back in Deployment mode")
+		instance.Spec.Gateway.Mode = clusterv1alpha1.GatewayModeDeployment
+		Expect(reconciler.syncGatewayCoverage(ctx, instance)).To(Succeed())
+		Expect(instance.Status.GatewayCoverage).To(BeNil())
+	})
+})
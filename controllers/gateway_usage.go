@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// gatewayUsageWindow Is how far back the gateway counters of the status reach.
+	gatewayUsageWindow = time.Hour
+	// maxGatewaySamples Bounds the samples kept for each cluster, whatever
+	// the status-sync interval.
+	maxGatewaySamples = 120
+)
+
+// gatewaySample Holds the requests served by the gateways of a cluster
+// between two samplings.
+type gatewaySample struct {
+	at       time.Time
+	requests float64
+	bytes    float64
+}
+
+// gatewayHistory Holds the counters last read from each peer of a cluster,
+// and the samples of the last hour.
+type gatewayHistory struct {
+	peers   map[string]kuboapi.GatewayStats
+	samples []gatewaySample
+}
+
+// gatewayUsage Keeps the gateway usage of each cluster in memory. Kubo
+// counts the requests since its start, so only the increments between two
+// readings of the same peer are summed, and a peer seen for the first time,
+// or again after it could not be read, only sets the baseline of the next
+// increment. The usage is lost when the operator restarts.
+type gatewayUsage struct {
+	mu       sync.Mutex
+	clusters map[types.NamespacedName]*gatewayHistory
+}
+
+// record Records the counters read from the peers of the cluster. It returns
+// the increment since the previous sampling and the sum of the last hour.
+func (g *gatewayUsage) record(
+	key types.NamespacedName,
+	now time.Time,
+	peers map[string]kuboapi.GatewayStats,
+) (gatewaySample, gatewaySample) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.clusters == nil {
+		g.clusters = map[types.NamespacedName]*gatewayHistory{}
+	}
+	history, ok := g.clusters[key]
+	if !ok {
+		history = &gatewayHistory{}
+		g.clusters[key] = history
+	}
+
+	increment := gatewaySample{at: now}
+	for pod, stats := range peers {
+		last, ok := history.peers[pod]
+		if !ok {
+			continue
+		}
+		increment.requests += counterIncrement(last.Requests, stats.Requests)
+		increment.bytes += counterIncrement(last.ResponseBytes, stats.ResponseBytes)
+	}
+	history.peers = peers
+	history.samples = append(history.samples, increment)
+	first := 0
+	for first < len(history.samples) && now.Sub(history.samples[first].at) >= gatewayUsageWindow {
+		first++
+	}
+	if len(history.samples)-first > maxGatewaySamples {
+		first = len(history.samples) - maxGatewaySamples
+	}
+	history.samples = append([]gatewaySample(nil), history.samples[first:]...)
+
+	total := gatewaySample{at: now}
+	for _, s := range history.samples {
+		total.requests += s.requests
+		total.bytes += s.bytes
+	}
+	return increment, total
+}
+
+// forget Drops the usage of the cluster.
+func (g *gatewayUsage) forget(key types.NamespacedName) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clusters, key)
+}
+
+// counterIncrement Returns the increment of a counter between two readings.
+// A counter lower than before was reset by a restart of the peer, and counts
+// from zero.
+func counterIncrement(last, current float64) float64 {
+	if current < last {
+		return current
+	}
+	return current - last
+}
+
+// syncGatewayUsage Reads, once per status-sync interval, the gateway counters
+// of every ready peer of a monitored cluster. The requests and bytes served
+// are added to the counters of the operator, and their sums over the last
+// hour are recorded in the status. The usage is cleared once monitoring is
+// disabled.
+func (r *IpfsReconciler) syncGatewayUsage(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	log := ctrllog.FromContext(ctx)
+	key := client.ObjectKeyFromObject(instance)
+	if !gatewayMonitored(instance) {
+		instance.Status.Gateway = nil
+		r.gatewayUsage.forget(key)
+		gatewayRequests.DeleteLabelValues(instance.Namespace, instance.Name)
+		gatewayResponseBytes.DeleteLabelValues(instance.Namespace, instance.Name)
+		return nil
+	}
+	now := metav1.Now()
+	gateway := instance.Status.Gateway
+	if gateway != nil && now.Sub(gateway.SampledAt.Time) < r.statusSyncInterval() {
+		return nil
+	}
+
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	peers := map[string]kuboapi.GatewayStats{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := peerOrdinal(pod.Name)
+		if pod.DeletionTimestamp != nil || ordinal < 0 || ordinal >= peerCount(instance) || !podIsReady(pod) {
+			continue
+		}
+		var stats *kuboapi.GatewayStats
+		api, err := r.kuboAPI(instance, ordinal)
+		if err == nil {
+			stats, err = api.GatewayStats(ctx)
+		}
+		if err != nil {
+			log.Info("cannot get gateway usage", "peer", pod.Name, "error", err.Error())
+			continue
+		}
+		peers[pod.Name] = *stats
+	}
+
+	increment, total := r.gatewayUsage.record(key, now.Time, peers)
+	gatewayRequests.WithLabelValues(instance.Namespace, instance.Name).Add(increment.requests)
+	gatewayResponseBytes.WithLabelValues(instance.Namespace, instance.Name).Add(increment.bytes)
+	instance.Status.Gateway = &clusterv1alpha1.GatewayStatus{
+		SampledAt:             now,
+		RequestsLastHour:      int64(total.requests),
+		ResponseBytesLastHour: int64(total.bytes),
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs gateway usage", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		node       *kubofake.Node
+		key        types.NamespacedName
+		metricsKey types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	serviceMonitor := func() *unstructured.Unstructured {
+		sm := &unstructured.Unstructured{}
+		sm.SetGroupVersionKind(serviceMonitorGVK)
+		return sm
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "usage"}
+		metricsKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-metrics-" + key.Name}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Public = true
+		instance.Spec.Monitoring = &clusterv1alpha1.MonitoringConfig{
+			Interval: &metav1.Duration{Duration: 30 * time.Second},
+		}
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-usage-0"
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, pod).Build()
+
+		node = kubofake.NewNode("12D3KooWA")
+		node.Gateway = kuboapi.GatewayStats{Requests: 100, ResponseBytes: 4096}
+		dialer := kubofake.NewDialer()
+		dialer.Add(key.Namespace, pod.Name, node)
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer}
+	})
+
+	It("sums the increments of the peers over the last hour", func() {
+		usage := gatewayUsage{}
+		start := time.Now()
+		_, total := usage.record(key, start, map[string]kuboapi.GatewayStats{
+			"a": {Requests: 100, ResponseBytes: 1000},
+		})
+		Expect(total.requests).To(BeZero())
+
+		increment, total := usage.record(key, start.Add(time.Minute), map[string]kuboapi.GatewayStats{
+			"a": {Requests: 110, ResponseBytes: 1500},
+			"b": {Requests: 50, ResponseBytes: 500},
+		})
+		Expect(increment.requests).To(Equal(10.0))
+		Expect(total.bytes).To(Equal(500.0))
+
+		By("counting a restarted peer from zero")
+		_, total = usage.record(key, start.Add(2*time.Minute), map[string]kuboapi.GatewayStats{
+			"a": {Requests: 5, ResponseBytes: 100},
+			"b": {Requests: 60, ResponseBytes: 700},
+		})
+		Expect(total.requests).To(Equal(25.0))
+		Expect(total.bytes).To(Equal(800.0))
+
+		By("dropping the samples older than an hour")
+		_, total = usage.record(key, start.Add(61*time.Minute), map[string]kuboapi.GatewayStats{
+			"a": {Requests: 5, ResponseBytes: 100},
+		})
+		Expect(total.requests).To(Equal(15.0))
+		Expect(usage.clusters[key].samples).To(HaveLen(2))
+	})
+
+	It("scrapes the gateway while it is exposed and monitored", func() {
+		reconcile()
+		instance := reconcile()
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, metricsKey, svc)).To(Succeed())
+		Expect(svc.Spec.Ports).To(HaveLen(1))
+		Expect(svc.Spec.Ports[0].Port).To(Equal(int32(portAPI)))
+		sm := serviceMonitor()
+		Expect(fakeClient.Get(ctx, metricsKey, sm)).To(Succeed())
+		endpoints, _, err := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(endpoints).To(HaveLen(1))
+		Expect(endpoints[0]).To(HaveKeyWithValue("path", kuboapi.MetricsPath))
+		Expect(endpoints[0]).To(HaveKeyWithValue("interval", "30s"))
+		Expect(instance.Status.Gateway).NotTo(BeNil())
+
+		By("summing the requests served since the last sampling")
+		node.Gateway = kuboapi.GatewayStats{Requests: 130, ResponseBytes: 8192}
+		instance.Status.Gateway.SampledAt = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		Expect(reconciler.syncGatewayUsage(ctx, instance)).To(Succeed())
+		Expect(instance.Status.Gateway.RequestsLastHour).To(Equal(int64(30)))
+		Expect(instance.Status.Gateway.ResponseBytesLastHour).To(Equal(int64(4096)))
+
+		By("removing the scrape objects once monitoring is disabled")
+		instance.Spec.Monitoring = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, metricsKey, &corev1.Service{}))).To(BeTrue())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, metricsKey, serviceMonitor()))).To(BeTrue())
+		Expect(instance.Status.Gateway).To(BeNil())
+	})
+})
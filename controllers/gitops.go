@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// specHashAnnotation Records the hash of the spec the operator last
+	// wrote to an object.
+	specHashAnnotation = "ipfs.cluster.io/spec-hash"
+	// templateHashAnnotation Records the hash of the pod template the
+	// operator last wrote to the StatefulSet of the peers, which the history
+	// compares rather than the live template the API server defaults.
+	templateHashAnnotation = "ipfs.cluster.io/template-hash"
+	// argoCDCompareOptions Is the annotation Argo CD reads the compare
+	// options of an object from.
+	argoCDCompareOptions = "argocd.argoproj.io/compare-options"
+	// argoCDIgnoreExtraneous Keeps Argo CD from reporting an object it does
+	// not manage as out of sync.
+	argoCDIgnoreExtraneous = "IgnoreExtraneous"
+)
+
+// specUnchanged Returns whether the live spec of the object is the expected
+// one. The API server fills in defaults for the fields the operator leaves
+// empty, so the live spec is compared with the hash of the spec the operator
+// last wrote and is only required to still hold every field set in the
+// expected spec. When the spec changed, the hash of the expected spec is set
+// on the object, to be written along with the spec.
+func specUnchanged(obj metav1.Object, expected, live interface{}) (bool, error) {
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return false, fmt.Errorf("cannot hash the spec of %s: %w", obj.GetName(), err)
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:16])
+	annotations := obj.GetAnnotations()
+	if annotations[specHashAnnotation] == hash && equality.Semantic.DeepDerivative(expected, live) {
+		return true, nil
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[specHashAnnotation] = hash
+	obj.SetAnnotations(annotations)
+	return false, nil
+}
+
+// ignoredByArgoCD Returns a mutate function marking the object as one Argo CD
+// does not compare, once the given mutate function ran. The objects the
+// operator creates are not part of the Git repository the Ipfs resource is
+// synced from, and would otherwise be reported as extraneous.
+func ignoredByArgoCD(obj metav1.Object, mut controllerutil.MutateFn) controllerutil.MutateFn {
+	if mut == nil {
+		return nil
+	}
+	return func() error {
+		if err := mut(); err != nil {
+			return err
+		}
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[argoCDCompareOptions] = argoCDIgnoreExtraneous
+		obj.SetAnnotations(annotations)
+		return nil
+	}
+}
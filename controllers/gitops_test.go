@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// writeCountingClient Counts the writes made through it, status included,
+// including those made concurrently.
+type writeCountingClient struct {
+	client.Client
+	writes int32
+}
+
+func (c *writeCountingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	atomic.AddInt32(&c.writes, 1)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *writeCountingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	atomic.AddInt32(&c.writes, 1)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *writeCountingClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	atomic.AddInt32(&c.writes, 1)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *writeCountingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	atomic.AddInt32(&c.writes, 1)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *writeCountingClient) Status() client.StatusWriter {
+	return &writeCountingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type writeCountingStatusWriter struct {
+	client.StatusWriter
+	client *writeCountingClient
+}
+
+func (w *writeCountingStatusWriter) Update(
+	ctx context.Context, obj client.Object, opts ...client.UpdateOption,
+) error {
+	atomic.AddInt32(&w.client.writes, 1)
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *writeCountingStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	atomic.AddInt32(&w.client.writes, 1)
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+var _ = Describe("Ipfs GitOps friendliness", func() {
+	var (
+		ctx        context.Context
+		fakeClient *writeCountingClient
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		stsKey     types.NamespacedName
+	)
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "gitops"}
+		stsKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = &writeCountingClient{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build(),
+		}
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+		for i := 0; i < 3; i++ {
+			reconcile()
+		}
+	})
+
+	It("does not write anything once the cluster is at rest", func() {
+		By("ignoring the fields defaulted by the API server")
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		revisions := int32(10)
+		sts.Spec.RevisionHistoryLimit = &revisions
+		sts.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+		sts.Spec.Template.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+		Expect(fakeClient.Client.Update(ctx, sts)).To(Succeed())
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, stsKey, svc)).To(Succeed())
+		svc.Spec.ClusterIP = "10.96.0.12"
+		svc.Spec.SessionAffinity = corev1.ServiceAffinityNone
+		Expect(fakeClient.Client.Update(ctx, svc)).To(Succeed())
+
+		fakeClient.writes = 0
+		reconcile()
+		reconcile()
+		Expect(fakeClient.writes).To(BeZero())
+	})
+
+	It("rewrites the peers when their spec changes", func() {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		sts.Spec.Template.Spec.Containers[0].Env = nil
+		Expect(fakeClient.Client.Update(ctx, sts)).To(Succeed())
+
+		fakeClient.writes = 0
+		reconcile()
+		Expect(fakeClient.writes).NotTo(BeZero())
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		Expect(sts.Spec.Template.Spec.Containers[0].Env).NotTo(BeEmpty())
+	})
+
+	It("marks the objects Argo CD should not compare when configured to", func() {
+		defaults := builtinDefaults()
+		defaults.ArgoCDIgnoreExtraneous = true
+		reconciler.Defaults = &DefaultsStore{current: defaults}
+		reconcile()
+
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		Expect(sts.Annotations).To(HaveKeyWithValue("argocd.argoproj.io/compare-options", "IgnoreExtraneous"))
+	})
+})
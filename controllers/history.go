@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// historyLimit Bounds the number of changes kept in the status, so that
+	// the history does not grow the object without end.
+	historyLimit = 10
+	// historyOperatorField Is the field recorded for a change of the pod
+	// template no section of the spec explains, such as one brought by an
+	// upgrade of the operator.
+	historyOperatorField = "operator"
+)
+
+// shortHash Returns a short hash of the JSON encoding of the value, which
+// keeps the entries of the history compact.
+func shortHash(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6]), nil
+}
+
+// specSections Returns the sections of the spec affecting the pod template
+// of the peers, keyed by the name recorded in the history. The referenced
+// configuration is a section of its own. Everything else in the spec falls
+// in the other section, except for the number of peers.
+func specSections(m *clusterv1alpha1.Ipfs, configHash string) map[string]interface{} {
+	spec := m.Spec
+	other := spec.DeepCopy()
+	other.IpfsImage, other.ClusterImage = "", ""
+	other.IpfsStorage, other.ClusterStorage, other.StorageClassName, other.Datastore = "", "", nil, nil
+	other.Resources = clusterv1alpha1.ResourcesConfig{}
+	other.Env = nil
+	other.Replicas = 0
+	return map[string]interface{}{
+		"image":     []string{spec.IpfsImage, spec.ClusterImage},
+		"storage":   []interface{}{spec.IpfsStorage, spec.ClusterStorage, spec.StorageClassName, spec.Datastore},
+		"resources": spec.Resources,
+		"env":       spec.Env,
+		"config":    configHash,
+		"other":     other,
+	}
+}
+
+// sectionHashes Returns the hash of each section of the spec.
+func sectionHashes(m *clusterv1alpha1.Ipfs, configHash string) (map[string]string, error) {
+	hashes := map[string]string{}
+	for name, section := range specSections(m, configHash) {
+		hash, err := shortHash(section)
+		if err != nil {
+			return nil, fmt.Errorf("cannot hash the %s section of the spec: %w", name, err)
+		}
+		hashes[name] = hash
+	}
+	return hashes, nil
+}
+
+// changedSections Returns the sorted names of the sections whose hash
+// differs, or the operator field when none does.
+func changedSections(before, after map[string]string) []string {
+	fields := []string{}
+	for name, hash := range after {
+		if before[name] != hash {
+			fields = append(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return []string{historyOperatorField}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// recordChange Appends the change to the history, marking the rollout still
+// in progress as superseded, and drops the oldest entries beyond the limit.
+func recordChange(instance *clusterv1alpha1.Ipfs, change clusterv1alpha1.ChangeRecord) {
+	history := instance.Status.History
+	if n := len(history); n > 0 && history[n-1].Outcome == clusterv1alpha1.ChangeOutcomeProgressing {
+		history[n-1].Outcome = clusterv1alpha1.ChangeOutcomeSuperseded
+	}
+	history = append(history, change)
+	if len(history) > historyLimit {
+		history = history[len(history)-historyLimit:]
+	}
+	instance.Status.History = history
+}
+
+// changeOutcome Returns how the rollout of the StatefulSet carrying the
+// change of the given generation stands.
+func changeOutcome(
+	instance *clusterv1alpha1.Ipfs,
+	sts *appsv1.StatefulSet,
+	generation int64,
+) clusterv1alpha1.ChangeOutcome {
+	if sts.Status.ObservedGeneration < generation {
+		return clusterv1alpha1.ChangeOutcomeProgressing
+	}
+	if failedRolloutHook(instance, sts.Status.UpdateRevision) != nil {
+		return clusterv1alpha1.ChangeOutcomeFailed
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision || sts.Status.UpdatedReplicas < replicas {
+		return clusterv1alpha1.ChangeOutcomeProgressing
+	}
+	return clusterv1alpha1.ChangeOutcomeSucceeded
+}
+
+// syncHistory Appends an entry to the history of the cluster when the pod
+// template of the peers applied differs from the one last recorded, and
+// records the outcome of the rollout of the last entry once it is known. The
+// template is the one the operator last wrote, so that the fields the API
+// server defaults are no change, or the live one for the StatefulSets written
+// before it was recorded. The first template seen is recorded without an
+// entry, as it is no change.
+// Since it only compares with the status, the history carries over restarts
+// of the operator.
+func (r *IpfsReconciler) syncHistory(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+	configHash string,
+) error {
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: "ipfs-cluster-" + instance.Name},
+		sts); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot get statefulset: %w", err)
+	}
+	template := sts.Annotations[templateHashAnnotation]
+	if template == "" {
+		var err error
+		if template, err = shortHash(sts.Spec.Template); err != nil {
+			return fmt.Errorf("cannot hash the pod template of the peers: %w", err)
+		}
+	}
+	sections, err := sectionHashes(resolved, configHash)
+	if err != nil {
+		return err
+	}
+
+	applied := instance.Status.AppliedHashes
+	switch {
+	case applied == nil:
+		instance.Status.AppliedHashes = &clusterv1alpha1.AppliedHashes{Template: template, Sections: sections}
+	case applied.Template != template:
+		recordChange(instance, clusterv1alpha1.ChangeRecord{
+			Time:       metav1.Now(),
+			Fields:     changedSections(applied.Sections, sections),
+			HashBefore: applied.Template,
+			HashAfter:  template,
+			Generation: sts.Generation,
+			Outcome:    clusterv1alpha1.ChangeOutcomeProgressing,
+		})
+		instance.Status.AppliedHashes = &clusterv1alpha1.AppliedHashes{Template: template, Sections: sections}
+	default:
+		// The sections which changed without changing the template did
+		// not cause the next change.
+		applied.Sections = sections
+	}
+
+	if n := len(instance.Status.History); n > 0 {
+		last := &instance.Status.History[n-1]
+		if last.Outcome == clusterv1alpha1.ChangeOutcomeProgressing {
+			last.Outcome = changeOutcome(instance, sts, last.Generation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs change history", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	// apply Sets the image of the pod template of the StatefulSet, as
+	// applying the spec would, and records the history.
+	apply := func(image, configHash string) {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: "ipfs-cluster-" + instance.Name}, sts)).To(Succeed())
+		instance.Spec.IpfsImage = image
+		sts.Spec.Template.Spec.Containers = []corev1.Container{{Name: "ipfs", Image: image}}
+		sts.Spec.Template.Annotations = map[string]string{clusterv1alpha1.AnnotationConfigHash: configHash}
+		Expect(fakeClient.Update(ctx, sts)).To(Succeed())
+		Expect(reconciler.syncHistory(ctx, instance, instance, configHash)).To(Succeed())
+	}
+
+	// rollOut Completes the rollout of the StatefulSet.
+	rollOut := func() {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: "ipfs-cluster-" + instance.Name}, sts)).To(Succeed())
+		sts.Status.ObservedGeneration = sts.Generation
+		sts.Status.CurrentRevision = sts.Status.UpdateRevision
+		sts.Status.UpdatedReplicas = *sts.Spec.Replicas
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "audited"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		replicas := int32(2)
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-" + instance.Name
+		sts.Namespace = instance.Namespace
+		sts.Spec.Replicas = &replicas
+		sts.Status = appsv1.StatefulSetStatus{
+			UpdatedReplicas: 2,
+			CurrentRevision: "rev-1",
+			UpdateRevision:  "rev-1",
+		}
+		fakeClient = fake.NewClientBuilder().WithObjects(instance, sts).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient}
+	})
+
+	It("records the changes of the pod template and their outcome", func() {
+		apply("ipfs/kubo:v0.15.0", "")
+		Expect(instance.Status.AppliedHashes).NotTo(BeNil())
+		Expect(instance.Status.History).To(BeEmpty())
+
+		By("appending nothing while the template is unchanged")
+		instance.Spec.Replicas = 3
+		apply("ipfs/kubo:v0.15.0", "")
+		Expect(instance.Status.History).To(BeEmpty())
+
+		By("appending the sections which changed")
+		before := instance.Status.AppliedHashes.Template
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: "ipfs-cluster-" + instance.Name}, sts)).To(Succeed())
+		sts.Status.UpdateRevision = "rev-2"
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+		apply("ipfs/kubo:v0.16.0", "abc")
+		Expect(instance.Status.History).To(HaveLen(1))
+		change := instance.Status.History[0]
+		Expect(change.Fields).To(Equal([]string{"config", "image"}))
+		Expect(change.HashBefore).To(Equal(before))
+		Expect(change.HashAfter).To(Equal(instance.Status.AppliedHashes.Template))
+		Expect(change.HashAfter).NotTo(Equal(before))
+		Expect(change.Outcome).To(Equal(clusterv1alpha1.ChangeOutcomeProgressing))
+
+		By("recording the outcome once the peers rolled")
+		rollOut()
+		Expect(reconciler.syncHistory(ctx, instance, instance, "abc")).To(Succeed())
+		Expect(instance.Status.History).To(HaveLen(1))
+		Expect(instance.Status.History[0].Outcome).To(Equal(clusterv1alpha1.ChangeOutcomeSucceeded))
+	})
+
+	It("keeps the last changes only", func() {
+		apply("ipfs/kubo:v0.15.0", "")
+		for i := 0; i < historyLimit+3; i++ {
+			sts := &appsv1.StatefulSet{}
+			Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+				Name: "ipfs-cluster-" + instance.Name}, sts)).To(Succeed())
+			sts.Status.UpdateRevision = fmt.Sprintf("rev-%d", i+2)
+			Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+			apply(fmt.Sprintf("ipfs/kubo:v0.%d.0", 16+i), "")
+		}
+		history := instance.Status.History
+		Expect(history).To(HaveLen(historyLimit))
+		Expect(history[historyLimit-1].HashAfter).To(Equal(instance.Status.AppliedHashes.Template))
+		for _, change := range history[:historyLimit-1] {
+			Expect(change.Outcome).To(Equal(clusterv1alpha1.ChangeOutcomeSuperseded))
+			Expect(change.Fields).To(Equal([]string{"image"}))
+		}
+		Expect(history[historyLimit-1].Outcome).To(Equal(clusterv1alpha1.ChangeOutcomeProgressing))
+	})
+})
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// gatewayHTTPHeaders Returns the headers the gateway adds to its responses.
+// Without any, browsers only let the pages of the origin of the gateway read
+// them. allowAllOrigins sets the headers kubo used to send by default, which
+// the headers of the spec override.
+func gatewayHTTPHeaders(m *clusterv1alpha1.Ipfs) map[string][]string {
+	headers := map[string][]string{}
+	gateway := m.Spec.Gateway
+	if gateway == nil {
+		return headers
+	}
+	if gateway.AllowAllOrigins {
+		headers[clusterv1alpha1.HeaderAllowOrigin] = []string{"*"}
+		headers["Access-Control-Allow-Methods"] = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+		headers["Access-Control-Allow-Headers"] = []string{"Content-Type", "Range", "User-Agent", "X-Requested-With"}
+	}
+	for name, values := range gateway.HTTPHeaders {
+		headers[http.CanonicalHeaderKey(name)] = values
+	}
+	return headers
+}
+
+// apiHTTPHeaders Returns the headers of the responses of the RPC API, which
+// kubo also reads to tell the origins allowed to call it. Without any, it only
+// answers the pages of its own origin.
+func apiHTTPHeaders(m *clusterv1alpha1.Ipfs) map[string][]string {
+	headers := map[string][]string{}
+	if m.Spec.API == nil || m.Spec.API.CORS == nil {
+		return headers
+	}
+	cors := m.Spec.API.CORS
+	headers[clusterv1alpha1.HeaderAllowOrigin] = cors.AllowedOrigins
+	headers["Access-Control-Allow-Methods"] = []string{http.MethodPost}
+	if len(cors.AllowedMethods) > 0 {
+		headers["Access-Control-Allow-Methods"] = cors.AllowedMethods
+	}
+	return headers
+}
+
+// httpHeadersCommands Returns the commands of the configure script applying
+// the headers of the gateway and of the RPC API, at every start of the peers.
+// Both are always written, so that unsetting them in the spec drops the
+// headers kubo init wrote, which let every origin read the gateway.
+func httpHeadersCommands(m *clusterv1alpha1.Ipfs) (string, error) {
+	gateway, err := json.Marshal(gatewayHTTPHeaders(m))
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize the headers of the gateway: %w", err)
+	}
+	api, err := json.Marshal(apiHTTPHeaders(m))
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize the headers of the RPC API: %w", err)
+	}
+	return fmt.Sprintf("\tipfs config --json Gateway.HTTPHeaders '%s'\n\tipfs config --json API.HTTPHeaders '%s'\n",
+		singleQuoted(gateway), singleQuoted(api)), nil
+}
+
+// singleQuoted Escapes the single quotes of a value written between single
+// quotes in a script.
+func singleQuoted(value []byte) string {
+	return strings.ReplaceAll(string(value), "'", `'\''`)
+}
+
+// httpHeadersSettings Returns the headers of the gateway and of the RPC API,
+// for the config hash rolling the peers when they change.
+func httpHeadersSettings(m *clusterv1alpha1.Ipfs) []string {
+	var settings []string
+	for prefix, headers := range map[string]map[string][]string{
+		"gateway": gatewayHTTPHeaders(m),
+		"api":     apiHTTPHeaders(m),
+	} {
+		for name, values := range headers {
+			settings = append(settings, fmt.Sprintf("%s/header/%s=%q", prefix, name, values))
+		}
+	}
+	sort.Strings(settings)
+	return settings
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs HTTP headers", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	// rendered Returns the headers the configure script writes under the key.
+	rendered := func(key string) map[string][]string {
+		commands, err := httpHeadersCommands(instance)
+		Expect(err).NotTo(HaveOccurred())
+		for _, command := range kuboConfigCommands(commands) {
+			if command.key == key {
+				headers := map[string][]string{}
+				Expect(json.Unmarshal(command.value, &headers)).To(Succeed())
+				return headers
+			}
+		}
+		Fail("the script does not write " + key)
+		return nil
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "headers"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		reconciler = &IpfsReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme}
+	})
+
+	It("only lets the pages of the same origin in by default", func() {
+		Expect(rendered("Gateway.HTTPHeaders")).To(BeEmpty())
+		Expect(rendered("API.HTTPHeaders")).To(BeEmpty())
+		Expect(httpHeadersSettings(instance)).To(BeEmpty())
+	})
+
+	It("renders the headers of the gateway over those allowing every origin", func() {
+		instance.Spec.Gateway = &clusterv1alpha1.GatewayConfig{
+			AllowAllOrigins: true,
+			HTTPHeaders: map[string][]string{
+				"cache-control":                {"public, max-age=29030400, immutable"},
+				"Access-Control-Allow-Methods": {"GET"},
+				"X-Note":                       {"it's cached"},
+			},
+		}
+		Expect(rendered("Gateway.HTTPHeaders")).To(Equal(map[string][]string{
+			"Access-Control-Allow-Origin":  {"*"},
+			"Access-Control-Allow-Methods": {"GET"},
+			"Access-Control-Allow-Headers": {"Content-Type", "Range", "User-Agent", "X-Requested-With"},
+			"Cache-Control":                {"public, max-age=29030400, immutable"},
+			"X-Note":                       {"it's cached"},
+		}))
+		Expect(rendered("API.HTTPHeaders")).To(BeEmpty())
+		script, err := renderGatewayNodeScript(instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(script).To(ContainSubstring(`"X-Note":["it'\''s cached"]`))
+	})
+
+	It("lets the allowed origins call the RPC API", func() {
+		instance.Spec.API = &clusterv1alpha1.APIConfig{CORS: &clusterv1alpha1.CORSConfig{
+			AllowedOrigins: []string{"https://app.example.com"},
+		}}
+		Expect(rendered("API.HTTPHeaders")).To(Equal(map[string][]string{
+			"Access-Control-Allow-Origin":  {"https://app.example.com"},
+			"Access-Control-Allow-Methods": {"POST"},
+		}))
+		instance.Spec.API.CORS.AllowedMethods = []string{"POST", "OPTIONS"}
+		Expect(rendered("API.HTTPHeaders")).To(HaveKeyWithValue("Access-Control-Allow-Methods",
+			[]string{"POST", "OPTIONS"}))
+
+		By("letting the preflight requests through the authenticating proxy")
+		instance.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+			OIDC: &clusterv1alpha1.OIDCConfig{IssuerURL: "https://accounts.example.com"},
+		}
+		container, _ := authProxyContainer(instance)
+		Expect(container.Args).To(ContainElement("--skip-auth-preflight"))
+		instance.Spec.API.CORS = nil
+		container, _ = authProxyContainer(instance)
+		Expect(container.Args).NotTo(ContainElement("--skip-auth-preflight"))
+	})
+
+	It("rolls the peers when the headers change", func() {
+		before, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+
+		instance.Spec.Gateway = &clusterv1alpha1.GatewayConfig{
+			HTTPHeaders: map[string][]string{"Cache-Control": {"max-age=60"}},
+		}
+		after, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).NotTo(Equal(before))
+
+		instance.Spec.Gateway.HTTPHeaders["Cache-Control"] = []string{"max-age=3600"}
+		changed, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).NotTo(Equal(after))
+
+		instance.Spec.Gateway.AllowAllOrigins = true
+		allowed, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(allowed).NotTo(Equal(changed))
+	})
+})
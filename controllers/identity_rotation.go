@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// identityHistoryLimit Is the number of identity rotations kept per peer.
+const identityHistoryLimit = 5
+
+// peerIdentityKeys Returns the keys of the cluster Secret holding the identity
+// of the peer with the given ordinal. The first peer uses the bootstrap identity.
+func peerIdentityKeys(ordinal int32) (idKey, privKey string) {
+	return fmt.Sprintf("PEER_ID_%d", ordinal), fmt.Sprintf("PEER_PRIV_KEY_%d", ordinal)
+}
+
+// peerPodName Returns the name of the pod running the peer with the given ordinal.
+func peerPodName(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	return fmt.Sprintf("ipfs-cluster-%s-%d", m.Name, ordinal)
+}
+
+// reconcileIdentityRotation Replaces the identity of the single peer named by
+// the rotate-identity annotation without restarting any other peer. A rotation
+// only starts within the maintenance window. It returns how long to wait
+// before checking on a rotation in progress.
+func (r *IpfsReconciler) reconcileIdentityRotation(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	gate *maintenanceGate,
+) (time.Duration, error) {
+	value, requested := instance.Annotations[clusterv1alpha1.AnnotationRotateIdentity]
+	rotation := instance.Status.IdentityRotation
+	cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionIdentityRotating)
+	halted := cond != nil && cond.Status == metav1.ConditionFalse &&
+		cond.Reason != clusterv1alpha1.RotatingReasonComplete
+
+	if !requested {
+		if halted {
+			instance.Status.IdentityRotation = nil
+			meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionIdentityRotating)
+		}
+		if rotation == nil {
+			return 0, nil
+		}
+	}
+	if halted && requested {
+		return 0, nil
+	}
+
+	if rotation == nil {
+		ordinal, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || ordinal < 0 || ordinal >= int64(peerCount(instance)) {
+			setIdentityRotatingCondition(instance, metav1.ConditionFalse,
+				clusterv1alpha1.IdentityRotatingReasonInvalidOrdinal,
+				fmt.Sprintf("%q is not the ordinal of a peer of this cluster", value))
+			return 0, nil
+		}
+		if !gate.allow(operationIdentityRotation) {
+			return 0, nil
+		}
+		return rotationPollInterval, r.startIdentityRotation(ctx, instance, int32(ordinal))
+	}
+
+	if time.Since(rotation.StartedAt.Time) > rotationTimeout {
+		setIdentityRotatingCondition(instance, metav1.ConditionFalse, clusterv1alpha1.RotatingReasonFailed,
+			fmt.Sprintf("peer %s did not rejoin the cluster within %s; remove the %s annotation to acknowledge",
+				rotation.NewID, rotationTimeout, clusterv1alpha1.AnnotationRotateIdentity))
+		return 0, nil
+	}
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return 0, err
+	}
+	peers, err := api.Peers(ctx)
+	if err != nil {
+		setIdentityRotatingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.RotatingReasonInProgress,
+			fmt.Sprintf("waiting for the cluster API: %v", err))
+		return rotationPollInterval, nil
+	}
+	for _, p := range peers {
+		if p.ID == rotation.NewID && p.Error == "" {
+			return 0, r.finishIdentityRotation(ctx, instance)
+		}
+	}
+	setIdentityRotatingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.RotatingReasonInProgress,
+		fmt.Sprintf("waiting for peer %s to join the cluster", rotation.NewID))
+	return rotationPollInterval, nil
+}
+
+// startIdentityRotation Stores a fresh identity for the peer, removes its old
+// identity from the cluster and restarts its pod.
+func (r *IpfsReconciler) startIdentityRotation(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	ordinal int32,
+) error {
+	log := ctrllog.FromContext(ctx)
+	podName := peerPodName(instance, ordinal)
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return err
+	}
+
+	var oldID string
+	peers, err := api.Peers(ctx)
+	if err != nil {
+		return externalAPI(fmt.Errorf("cannot look up the current identity of %s: %w", podName, err))
+	}
+	for _, p := range peers {
+		if p.Peername == podName {
+			oldID = p.ID
+		}
+	}
+
+	peerid, privStr, err := generateIdentity()
+	if err != nil {
+		return err
+	}
+	if err = r.storePeerIdentity(ctx, instance, ordinal, peerid.String(), privStr); err != nil {
+		return err
+	}
+
+	instance.Status.IdentityRotation = &clusterv1alpha1.IdentityRotationStatus{
+		Ordinal:   ordinal,
+		OldID:     oldID,
+		NewID:     peerid.String(),
+		StartedAt: metav1.Now(),
+	}
+	setIdentityRotatingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.RotatingReasonInProgress,
+		fmt.Sprintf("replacing identity %s of %s", oldID, podName))
+	if err = r.Status().Update(ctx, instance); err != nil {
+		return err
+	}
+
+	if oldID != "" {
+		if err = api.RemovePeer(ctx, oldID); err != nil {
+			return externalAPI(fmt.Errorf("cannot remove identity %s of %s: %w", oldID, podName, err))
+		}
+	}
+	pod := corev1.Pod{}
+	pod.Name = podName
+	pod.Namespace = instance.Namespace
+	if err = r.Delete(ctx, &pod); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot restart peer %s: %w", podName, err)
+	}
+	log.Info("rotated peer identity", "pod", podName, "oldID", oldID, "newID", peerid.String())
+	return nil
+}
+
+// storePeerIdentity Writes the identity of a single peer into the identities
+// Secret. The first peer is the bootstrap peer, whose ID is also published
+// in the ConfigMap so that the other peers can find it.
+func (r *IpfsReconciler) storePeerIdentity(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	ordinal int32,
+	id, privKey string,
+) error {
+	sec := corev1.Secret{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: "ipfs-cluster-" + instance.Name}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: identitiesSecretName(instance)},
+		&sec); err != nil {
+		return fmt.Errorf("cannot get identities secret: %w", err)
+	}
+	if sec.Data == nil {
+		sec.Data = make(map[string][]byte)
+	}
+	if ordinal == 0 {
+		cm := corev1.ConfigMap{}
+		if err := r.Get(ctx, key, &cm); err != nil {
+			return fmt.Errorf("cannot get cluster configmap: %w", err)
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data["BOOTSTRAP_PEER_ID"] = id
+		if err := r.Update(ctx, &cm); err != nil {
+			return fmt.Errorf("cannot update bootstrap peer id: %w", err)
+		}
+		sec.Data[bootstrapPrivateKeyKey] = []byte(privKey)
+	} else {
+		idKey, privKeyKey := peerIdentityKeys(ordinal)
+		sec.Data[idKey] = []byte(id)
+		sec.Data[privKeyKey] = []byte(privKey)
+	}
+	if err := r.Update(ctx, &sec); err != nil {
+		return fmt.Errorf("cannot store peer identity: %w", err)
+	}
+	return nil
+}
+
+// finishIdentityRotation Records the rotation in the history of the peer and
+// removes the annotation which requested it.
+func (r *IpfsReconciler) finishIdentityRotation(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	rotation := instance.Status.IdentityRotation
+	peer := peerStatus(instance, peerPodName(instance, rotation.Ordinal))
+	peer.ID = rotation.NewID
+	peer.History = append(peer.History, clusterv1alpha1.IdentityRotation{
+		OldID: rotation.OldID,
+		NewID: rotation.NewID,
+		Time:  metav1.Now(),
+	})
+	if len(peer.History) > identityHistoryLimit {
+		peer.History = peer.History[len(peer.History)-identityHistoryLimit:]
+	}
+	instance.Status.IdentityRotation = nil
+	setIdentityRotatingCondition(instance, metav1.ConditionFalse, clusterv1alpha1.RotatingReasonComplete,
+		fmt.Sprintf("peer %s joined the cluster", rotation.NewID))
+	if err := r.Status().Update(ctx, instance); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	delete(instance.Annotations, clusterv1alpha1.AnnotationRotateIdentity)
+	return r.Patch(ctx, instance, patch)
+}
+
+func setIdentityRotatingCondition(
+	instance *clusterv1alpha1.Ipfs,
+	status metav1.ConditionStatus,
+	reason, message string,
+) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionIdentityRotating,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+)
+
+var _ = Describe("Ipfs peer identity rotation", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+		open       *maintenanceGate
+	)
+
+	get := func() *clusterv1alpha1.Ipfs {
+		current := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), current)).To(Succeed())
+		return current
+	}
+
+	// request Sets the rotate-identity annotation and reloads the instance.
+	request := func(value string) {
+		current := get()
+		current.Annotations = map[string]string{clusterv1alpha1.AnnotationRotateIdentity: value}
+		Expect(fakeClient.Update(ctx, current)).To(Succeed())
+		instance = get()
+	}
+
+	identities := func() map[string][]byte {
+		sec := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: identitiesSecretName(instance)}, sec)).To(Succeed())
+		return sec.Data
+	}
+
+	podExists := func(ordinal int32) bool {
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: peerPodName(instance, ordinal)}, &corev1.Pod{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	rotating := func() *metav1.Condition {
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionIdentityRotating)
+	}
+
+	clusterIDs := func() []string {
+		var ids []string
+		for _, p := range cluster.Peers() {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "identity"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 3
+		objects := []client.Object{instance}
+		sec := &corev1.Secret{}
+		sec.Name = identitiesSecretName(instance)
+		sec.Namespace = instance.Namespace
+		sec.Data = map[string][]byte{bootstrapPrivateKeyKey: []byte("priv-a")}
+		cm := &corev1.ConfigMap{}
+		cm.Name = "ipfs-cluster-" + instance.Name
+		cm.Namespace = instance.Namespace
+		cm.Data = map[string]string{"BOOTSTRAP_PEER_ID": "12D3KooWA"}
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		objects = append(objects, sec, cm, credentials)
+		cluster = clusterfake.NewCluster()
+		for ordinal, id := range []string{"12D3KooWA", "12D3KooWB", "12D3KooWC"} {
+			pod := &corev1.Pod{}
+			pod.Name = peerPodName(instance, int32(ordinal))
+			pod.Namespace = instance.Namespace
+			objects = append(objects, pod)
+			cluster.AddPeer(clusterapi.Peer{ID: id, Peername: pod.Name})
+			if ordinal > 0 {
+				idKey, privKey := peerIdentityKeys(int32(ordinal))
+				sec.Data[idKey] = []byte(id)
+				sec.Data[privKey] = []byte("priv")
+			}
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		reconciler = &IpfsReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+		open = &maintenanceGate{open: true}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("refuses ordinals which are not those of a peer until the annotation is removed", func() {
+		for _, value := range []string{"peer", "-1", "3"} {
+			request(value)
+			requeue, err := reconciler.reconcileIdentityRotation(ctx, instance, open)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requeue).To(BeZero())
+			Expect(rotating().Status).To(Equal(metav1.ConditionFalse))
+			Expect(rotating().Reason).To(Equal(clusterv1alpha1.IdentityRotatingReasonInvalidOrdinal))
+			Expect(rotating().Message).To(Equal(fmt.Sprintf("%q is not the ordinal of a peer of this cluster", value)))
+
+			By("halting while the annotation is set")
+			instance.Annotations[clusterv1alpha1.AnnotationRotateIdentity] = "1"
+			requeue, err = reconciler.reconcileIdentityRotation(ctx, instance, open)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requeue).To(BeZero())
+			Expect(instance.Status.IdentityRotation).To(BeNil())
+			Expect(clusterIDs()).To(ConsistOf("12D3KooWA", "12D3KooWB", "12D3KooWC"))
+
+			By("resetting once the annotation is removed")
+			delete(instance.Annotations, clusterv1alpha1.AnnotationRotateIdentity)
+			_, err = reconciler.reconcileIdentityRotation(ctx, instance, open)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rotating()).To(BeNil())
+		}
+	})
+
+	It("replaces the identity of a single peer and records it in its history", func() {
+		history := make([]clusterv1alpha1.IdentityRotation, identityHistoryLimit)
+		for i := range history {
+			history[i] = clusterv1alpha1.IdentityRotation{
+				OldID: fmt.Sprintf("12D3KooWOld%d", i),
+				NewID: fmt.Sprintf("12D3KooWOld%d", i+1),
+				Time:  metav1.NewTime(time.Now().Add(-time.Duration(identityHistoryLimit-i) * time.Hour)),
+			}
+		}
+		current := get()
+		current.Status.Peers = []clusterv1alpha1.PeerStatus{{
+			Name:    peerPodName(instance, 1),
+			ID:      "12D3KooWB",
+			History: history,
+		}}
+		Expect(fakeClient.Status().Update(ctx, current)).To(Succeed())
+		request("1")
+
+		requeue, err := reconciler.reconcileIdentityRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(rotationPollInterval))
+		rotation := instance.Status.IdentityRotation
+		Expect(rotation).NotTo(BeNil())
+		Expect(rotation.Ordinal).To(Equal(int32(1)))
+		Expect(rotation.OldID).To(Equal("12D3KooWB"))
+		idKey, privKey := peerIdentityKeys(1)
+		Expect(string(identities()[idKey])).To(Equal(rotation.NewID))
+		Expect(string(identities()[privKey])).NotTo(Equal("priv"))
+		Expect(clusterIDs()).To(ConsistOf("12D3KooWA", "12D3KooWC"))
+		Expect(podExists(0)).To(BeTrue())
+		Expect(podExists(1)).To(BeFalse())
+		Expect(podExists(2)).To(BeTrue())
+
+		By("waiting for the peer to join with its new identity")
+		requeue, err = reconciler.reconcileIdentityRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(rotationPollInterval))
+		Expect(rotating().Reason).To(Equal(clusterv1alpha1.RotatingReasonInProgress))
+		Expect(rotating().Message).To(Equal("waiting for peer " + rotation.NewID + " to join the cluster"))
+
+		cluster.AddPeer(clusterapi.Peer{ID: rotation.NewID, Peername: peerPodName(instance, 1)})
+		requeue, err = reconciler.reconcileIdentityRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		current = get()
+		Expect(current.Status.IdentityRotation).To(BeNil())
+		Expect(current.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRotateIdentity))
+		cond := meta.FindStatusCondition(current.Status.Conditions, clusterv1alpha1.ConditionIdentityRotating)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.RotatingReasonComplete))
+		peer := peerStatus(current, peerPodName(instance, 1))
+		Expect(peer.ID).To(Equal(rotation.NewID))
+		Expect(peer.History).To(HaveLen(identityHistoryLimit))
+		Expect(peer.History[0].OldID).To(Equal("12D3KooWOld1"))
+		Expect(peer.History[identityHistoryLimit-1].OldID).To(Equal("12D3KooWB"))
+		Expect(peer.History[identityHistoryLimit-1].NewID).To(Equal(rotation.NewID))
+	})
+
+	It("publishes the new identity of the bootstrap peer", func() {
+		request("0")
+		_, err := reconciler.reconcileIdentityRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		rotation := instance.Status.IdentityRotation
+		Expect(rotation.OldID).To(Equal("12D3KooWA"))
+		Expect(string(identities()[bootstrapPrivateKeyKey])).NotTo(Equal("priv-a"))
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: "ipfs-cluster-" + instance.Name}, cm)).To(Succeed())
+		Expect(cm.Data).To(HaveKeyWithValue("BOOTSTRAP_PEER_ID", rotation.NewID))
+		Expect(clusterIDs()).To(ConsistOf("12D3KooWB", "12D3KooWC"))
+		Expect(podExists(0)).To(BeFalse())
+		Expect(podExists(1)).To(BeTrue())
+	})
+
+	It("waits for the maintenance window", func() {
+		request("1")
+		closed := &maintenanceGate{configured: true, at: time.Now().Add(time.Hour)}
+		requeue, err := reconciler.reconcileIdentityRotation(ctx, instance, closed)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(closed.waiting).To(ConsistOf(operationIdentityRotation))
+		Expect(instance.Status.IdentityRotation).To(BeNil())
+		Expect(podExists(1)).To(BeTrue())
+	})
+})
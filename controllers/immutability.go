@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// fenceSpec Keeps reconciling the frozen fields recorded in the status when
+// the spec changes them, and ignores spec.replicas when autoscaling is set,
+// which happens when the validating webhook is not deployed. The rejected
+// changes are reported through the RejectedChange condition.
+func (r *IpfsReconciler) fenceSpec(instance, resolved *clusterv1alpha1.Ipfs) {
+	reason := clusterv1alpha1.RejectedChangeReasonFrozenField
+	var errs field.ErrorList
+	if instance.Status.Frozen != nil {
+		errs = clusterv1alpha1.ValidateFrozenFields(*instance.Status.Frozen, &instance.Spec)
+		instance.Status.Frozen.ApplyTo(&resolved.Spec)
+	}
+	if instance.Status.RepoLayout != nil {
+		errs = append(errs, clusterv1alpha1.ValidateRepoLayout(*instance.Status.RepoLayout, &instance.Spec)...)
+		instance.Status.RepoLayout.ApplyTo(&resolved.Spec)
+	}
+	if invalid := clusterv1alpha1.ValidateSpec(&instance.Spec); len(invalid) > 0 {
+		if len(errs) == 0 {
+			reason = clusterv1alpha1.RejectedChangeReasonInvalidSpec
+		}
+		errs = append(errs, invalid...)
+	}
+	if len(errs) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionRejectedChange)
+		return
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionRejectedChange,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            errs.ToAggregate().Error(),
+		ObservedGeneration: instance.Generation,
+	})
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs frozen fields", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "frozen"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("keeps reconciling the recorded values when the spec changes them", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(instance.Status.Frozen).NotTo(BeNil())
+		Expect(instance.Status.Frozen.IpfsStorage).To(Equal("1Gi"))
+
+		By("growing the ipfs volumes")
+		instance.Spec.IpfsStorage = "2Gi"
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionRejectedChange)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.RejectedChangeReasonFrozenField))
+		Expect(cond.Message).To(ContainSubstring("spec.ipfsStorage"))
+		Expect(instance.Status.Frozen.IpfsStorage).To(Equal("1Gi"))
+
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: key.Namespace,
+			Name:      "ipfs-cluster-" + key.Name,
+		}, sts)).To(Succeed())
+		for _, vct := range sts.Spec.VolumeClaimTemplates {
+			Expect(vct.Spec.Resources.Requests.Storage().Cmp(resource.MustParse("1Gi"))).To(BeZero())
+		}
+
+		By("reverting the change")
+		instance.Spec.IpfsStorage = "1Gi"
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionRejectedChange)).To(BeNil())
+	})
+})
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// certificateGVK Is the cert-manager Certificate kind. The operator does not
+// depend on the cert-manager API and manages certificates as unstructured objects.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// ingressGateway Returns a mutate function for the Ingress serving the
+// gateway at spec.expose.host, through the authenticating proxy when auth is
+// requested. The tls stanza is only set once the
+// certificate Secret is ready, so that the ingress controller never serves a
+// missing certificate. Unless the LoadBalancer Service of a public cluster
+// already does, the Ingress carries the external-dns annotations.
+func (r *IpfsReconciler) ingressGateway(
+	m *clusterv1alpha1.Ipfs,
+	ing *networkingv1.Ingress,
+	tlsReady bool,
+) (controllerutil.MutateFn, string) {
+	ingName := gatewayServiceName(m)
+	pathType := networkingv1.PathTypePrefix
+	expected := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingName,
+			Namespace: m.Namespace,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: m.Spec.Expose.IngressClassName,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: m.Spec.Expose.Host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: gwServiceName(m),
+											Port: networkingv1.ServiceBackendPort{Name: "http"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if m.Spec.Expose.TLS != nil && tlsReady {
+		expected.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      []string{m.Spec.Expose.Host},
+				SecretName: tlsSecretName(m),
+			},
+		}
+	}
+	expected.DeepCopyInto(ing)
+	if err := ctrl.SetControllerReference(m, ing, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		unchanged, err := specUnchanged(ing, expected.Spec, ing.Spec)
+		if err != nil {
+			return err
+		}
+		if !unchanged {
+			ing.Spec = expected.Spec
+		}
+		applyDNSAnnotations(m, ing, !m.Spec.Public)
+		return nil
+	}, ingName
+}
+
+// certificateGateway Returns a mutate function for the cert-manager
+// Certificate of the gateway host, issued by spec.expose.tls.issuerRef.
+func (r *IpfsReconciler) certificateGateway(
+	m *clusterv1alpha1.Ipfs,
+	cert *unstructured.Unstructured,
+) (controllerutil.MutateFn, string) {
+	certName := tlsSecretName(m)
+	issuer := m.Spec.Expose.TLS.IssuerRef
+	cert.SetGroupVersionKind(certificateGVK)
+	cert.SetName(certName)
+	cert.SetNamespace(m.Namespace)
+	if err := ctrl.SetControllerReference(m, cert, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	dnsNames := []interface{}{m.Spec.Expose.Host}
+	if webSocketPublic(m) {
+		for ordinal := int32(0); ordinal < m.Spec.Replicas; ordinal++ {
+			dnsNames = append(dnsNames, peerWebSocketHost(m, ordinal))
+		}
+	}
+	spec := map[string]interface{}{
+		"secretName": certName,
+		"dnsNames":   dnsNames,
+		"issuerRef": map[string]interface{}{
+			"name":  issuer.Name,
+			"kind":  issuer.Kind,
+			"group": issuer.Group,
+		},
+	}
+	return func() error {
+		return unstructured.SetNestedMap(cert.Object, spec, "spec")
+	}, certName
+}
+
+// deleteGatewayExposure Deletes the Ingress of the gateway once
+// spec.expose.host is cleared, and the Certificates the operator requested
+// for it which the spec no longer asks for.
+func (r *IpfsReconciler) deleteGatewayExposure(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	if m.Spec.Expose.Host == "" {
+		ing := networkingv1.Ingress{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: gatewayServiceName(m)}, &ing)
+		switch {
+		case errors.IsNotFound(err):
+		case err != nil:
+			return fmt.Errorf("cannot get gateway ingress: %w", err)
+		case metav1.IsControlledBy(&ing, m):
+			if err = r.Delete(ctx, &ing); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("cannot delete gateway ingress: %w", err)
+			}
+		}
+	}
+
+	wanted := ""
+	if tls := m.Spec.Expose.TLS; m.Spec.Expose.Host != "" && tls != nil && tls.IssuerRef != nil {
+		wanted = tlsSecretName(m)
+	}
+	return r.deleteGatewayCertificates(ctx, m, wanted)
+}
+
+// deleteGatewayCertificates Deletes the Certificates the operator requested
+// for the gateway, but the wanted one. Nothing is deleted when cert-manager
+// is not installed.
+func (r *IpfsReconciler) deleteGatewayCertificates(ctx context.Context, m *clusterv1alpha1.Ipfs, wanted string) error {
+	certs := unstructured.UnstructuredList{}
+	certs.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind + "List"))
+	if err := r.List(ctx, &certs, client.InNamespace(m.Namespace),
+		client.MatchingLabels{labelInstance: m.Name, labelComponent: componentGateway}); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot list gateway certificates: %w", err)
+	}
+	for i := range certs.Items {
+		cert := &certs.Items[i]
+		if cert.GetName() == wanted || !metav1.IsControlledBy(cert, m) {
+			continue
+		}
+		if err := r.Delete(ctx, cert); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete gateway certificate %s: %w", cert.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// tlsSecretName Returns the name of the Secret holding the certificate of
+// the gateway: the one referenced by the spec, or the one cert-manager issues.
+func tlsSecretName(m *clusterv1alpha1.Ipfs) string {
+	if tls := m.Spec.Expose.TLS; tls != nil && tls.SecretName != "" {
+		return tls.SecretName
+	}
+	return gatewayServiceName(m) + "-tls"
+}
+
+// syncTLS Reports whether the certificate Secret of the gateway holds a
+// certificate, and records it in the TLSPending condition. The Secret is
+// only referenced by name, so renewing or replacing it needs no change to
+// the spec.
+func (r *IpfsReconciler) syncTLS(ctx context.Context, instance *clusterv1alpha1.Ipfs) (bool, error) {
+	if instance.Spec.Expose.TLS == nil || instance.Spec.Expose.Host == "" {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionTLSPending)
+		return false, nil
+	}
+	secretName := tlsSecretName(instance)
+	sec := corev1.Secret{}
+	var pending string
+	err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: secretName}, &sec)
+	switch {
+	case errors.IsNotFound(err):
+		pending = "waiting for secret " + secretName
+	case err != nil:
+		return false, fmt.Errorf("cannot get tls secret %s: %w", secretName, err)
+	case len(sec.Data[corev1.TLSCertKey]) == 0 || len(sec.Data[corev1.TLSPrivateKeyKey]) == 0:
+		pending = fmt.Sprintf("secret %s holds no %s and %s yet", secretName, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+
+	condition := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionTLSPending,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.TLSPendingReasonSecretReady,
+		Message:            "the gateway is served with the certificate of secret " + secretName,
+		ObservedGeneration: instance.Generation,
+	}
+	if pending != "" {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = clusterv1alpha1.TLSPendingReasonSecretNotFound
+		condition.Message = pending + "; the gateway is served without TLS"
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+	return pending == "", nil
+}
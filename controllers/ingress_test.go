@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs gateway ingress", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() (*clusterv1alpha1.Ipfs, *networkingv1.Ingress) {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		ing := &networkingv1.Ingress{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: key.Namespace,
+			Name:      "ipfs-gateway-" + key.Name,
+		}, ing)).To(Succeed())
+		return instance, ing
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "gateway"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		scheme.AddKnownTypeWithName(certificateGVK, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind+"List"),
+			&unstructured.UnstructuredList{})
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Expose.Host = "gateway.example.com"
+		instance.Spec.Expose.TLS = &clusterv1alpha1.TLSConfig{SecretName: "gateway-cert"}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("serves the gateway without TLS until the secret exists", func() {
+		// The first reconcile only adds the finalizer.
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance, ing := reconcile()
+		Expect(ing.Spec.Rules).To(HaveLen(1))
+		Expect(ing.Spec.Rules[0].Host).To(Equal("gateway.example.com"))
+		Expect(ing.Spec.TLS).To(BeEmpty())
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions,
+			clusterv1alpha1.ConditionTLSPending)).To(BeTrue())
+
+		By("creating the secret")
+		sec := &corev1.Secret{}
+		sec.Name = "gateway-cert"
+		sec.Namespace = key.Namespace
+		sec.Type = corev1.SecretTypeTLS
+		sec.Data = map[string][]byte{
+			corev1.TLSCertKey:       []byte("cert"),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+		}
+		Expect(fakeClient.Create(ctx, sec)).To(Succeed())
+		instance, ing = reconcile()
+		Expect(ing.Spec.TLS).To(ConsistOf(networkingv1.IngressTLS{
+			Hosts:      []string{"gateway.example.com"},
+			SecretName: "gateway-cert",
+		}))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionTLSPending)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(BeEquivalentTo(corev1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.TLSPendingReasonSecretReady))
+	})
+
+	It("requests a certificate from the cert-manager issuer", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		instance.Spec.Expose.TLS = &clusterv1alpha1.TLSConfig{
+			IssuerRef: &clusterv1alpha1.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"},
+		}
+		cert := &unstructured.Unstructured{}
+		mut, name := reconciler.certificateGateway(instance, cert)
+		Expect(mut()).To(Succeed())
+		Expect(name).To(Equal("ipfs-gateway-gateway-tls"))
+		Expect(cert.GroupVersionKind()).To(Equal(certificateGVK))
+		dnsNames, _, err := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dnsNames).To(ConsistOf("gateway.example.com"))
+		kind, _, err := unstructured.NestedString(cert.Object, "spec", "issuerRef", "kind")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kind).To(Equal("ClusterIssuer"))
+		Expect(tlsSecretName(instance)).To(Equal(name))
+	})
+
+	It("deletes the ingress and the certificate once the exposure is switched off", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		instance.Spec.Expose.TLS = &clusterv1alpha1.TLSConfig{
+			IssuerRef: &clusterv1alpha1.IssuerReference{Name: "letsencrypt", Kind: "ClusterIssuer"},
+		}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance, _ = reconcile()
+		certKey := types.NamespacedName{Namespace: key.Namespace, Name: tlsSecretName(instance)}
+		certificate := func() error {
+			cert := &unstructured.Unstructured{}
+			cert.SetGroupVersionKind(certificateGVK)
+			return fakeClient.Get(ctx, certKey, cert)
+		}
+		Expect(certificate()).To(Succeed())
+
+		By("dropping the issuer")
+		instance.Spec.Expose.TLS = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance, _ = reconcile()
+		Expect(certificate()).To(Satisfy(errors.IsNotFound))
+
+		By("clearing the host")
+		instance.Spec.Expose.Host = ""
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: gatewayServiceName(instance)},
+			&networkingv1.Ingress{})).To(Satisfy(errors.IsNotFound))
+	})
+})
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// inventoryOf Returns the inventory of the tracked objects of the phases,
+// sorted by kind and name.
+func (r *IpfsReconciler) inventoryOf(phases []reconcilePhase) []clusterv1alpha1.InventoryEntry {
+	var inventory []clusterv1alpha1.InventoryEntry
+	for _, phase := range phases {
+		for obj := range phase.objects {
+			gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+			if err != nil {
+				continue
+			}
+			apiVersion, kind := gvk.ToAPIVersionAndKind()
+			inventory = append(inventory, clusterv1alpha1.InventoryEntry{
+				APIVersion: apiVersion,
+				Kind:       kind,
+				Name:       obj.GetName(),
+			})
+		}
+	}
+	sort.Slice(inventory, func(i, j int) bool {
+		if inventory[i].Kind != inventory[j].Kind {
+			return inventory[i].Kind < inventory[j].Kind
+		}
+		return inventory[i].Name < inventory[j].Name
+	})
+	return inventory
+}
+
+// prunable Returns whether the object of the inventory may be pruned. The
+// volume claims and the Secrets holding the identities of the peers are
+// never pruned, as they cannot be recreated. Neither is the ServiceAccount,
+// which cleanupServiceAccount releases rather than deletes while the peers
+// still run as it.
+func prunable(m *clusterv1alpha1.Ipfs, entry clusterv1alpha1.InventoryEntry) bool {
+	switch entry.Kind {
+	case "PersistentVolumeClaim", "ServiceAccount":
+		return false
+	case "Secret":
+		switch entry.Name {
+		case identitiesSecretName(m), clusterSecretName(m), apiCredentialsName(m):
+			return false
+		}
+	}
+	return true
+}
+
+// pruneInventory Deletes the objects the previous complete reconcile applied
+// which the phases no longer produce, such as those of a feature switched
+// off, and records the inventory of the phases in the status. Only objects
+// carrying the instance label and controlled by the cluster are deleted. It
+// must only be called once every phase was applied.
+func (r *IpfsReconciler) pruneInventory(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	phases []reconcilePhase,
+) error {
+	log := ctrllog.FromContext(ctx)
+	inventory := r.inventoryOf(phases)
+	applied := make(map[clusterv1alpha1.InventoryEntry]bool, len(inventory))
+	for _, entry := range inventory {
+		applied[entry] = true
+	}
+	for _, entry := range instance.Status.Inventory {
+		if applied[entry] || !prunable(instance, entry) {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(entry.APIVersion)
+		obj.SetKind(entry.Kind)
+		err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: entry.Name}, obj)
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot get %s %s: %w", entry.Kind, entry.Name, err)
+		}
+		if obj.GetLabels()[labelInstance] != instance.Name || !metav1.IsControlledBy(obj, instance) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot prune %s %s: %w", entry.Kind, entry.Name, err)
+		}
+		log.Info("object pruned", "objName", entry.Name, "objKind", entry.Kind)
+	}
+	instance.Status.Inventory = inventory
+	return nil
+}
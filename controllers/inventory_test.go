@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs object inventory", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	// objects Counts the objects of the cluster, by kind.
+	objects := func() map[string]int {
+		counts := map[string]int{}
+		for kind, list := range map[string]client.ObjectList{
+			"Service":   &corev1.ServiceList{},
+			"Secret":    &corev1.SecretList{},
+			"ConfigMap": &corev1.ConfigMapList{},
+		} {
+			Expect(fakeClient.List(ctx, list, client.InNamespace(key.Namespace),
+				client.MatchingLabels{labelInstance: key.Name})).To(Succeed())
+			items, err := meta.ExtractList(list)
+			Expect(err).NotTo(HaveOccurred())
+			counts[kind] = len(items)
+		}
+		return counts
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "inventory"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("prunes the objects of a feature once it is switched off", func() {
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		instance := reconcile()
+		Expect(instance.Status.Inventory).To(ContainElement(clusterv1alpha1.InventoryEntry{
+			APIVersion: "apps/v1", Kind: "StatefulSet", Name: "ipfs-cluster-" + key.Name,
+		}))
+		baseline := objects()
+
+		By("switching the public gateway on")
+		instance.Spec.Public = true
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(instance.Status.Inventory).To(ContainElement(clusterv1alpha1.InventoryEntry{
+			APIVersion: "v1", Kind: "Service", Name: gatewayServiceName(instance),
+		}))
+		Expect(objects()["Service"]).To(Equal(baseline["Service"] + 1))
+
+		By("switching it off again")
+		instance.Spec.Public = false
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(objects()).To(Equal(baseline))
+		Expect(instance.Status.Inventory).NotTo(ContainElement(clusterv1alpha1.InventoryEntry{
+			APIVersion: "v1", Kind: "Service", Name: gatewayServiceName(instance),
+		}))
+	})
+
+	It("never prunes the volume claims and the identities of the peers", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		for _, entry := range []clusterv1alpha1.InventoryEntry{
+			{APIVersion: "v1", Kind: "PersistentVolumeClaim", Name: "ipfs-storage-ipfs-cluster-inventory-0"},
+			{APIVersion: "v1", Kind: "Secret", Name: identitiesSecretName(instance)},
+			{APIVersion: "v1", Kind: "Secret", Name: clusterSecretName(instance)},
+			{APIVersion: "v1", Kind: "Secret", Name: apiCredentialsName(instance)},
+		} {
+			Expect(prunable(instance, entry)).To(BeFalse(), entry.Name)
+		}
+		Expect(prunable(instance, clusterv1alpha1.InventoryEntry{
+			APIVersion: "v1", Kind: "Secret", Name: authProxyName(instance),
+		})).To(BeTrue())
+	})
+})
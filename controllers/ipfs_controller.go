@@ -22,144 +22,814 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
-	"github.com/redhat-et/ipfs-operator/controllers/utils"
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	"github.com/redhat-et/ipfs-operator/pkg/connpool"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
 )
 
 const (
-	finalizer = "openshift.ifps.cluster"
+	finalizer = "ipfs.cluster.io/finalizer"
+	// legacyFinalizer Is the misspelled finalizer older operators set, which
+	// is replaced with the current one.
+	legacyFinalizer = "openshift.ifps.cluster"
+	// statusSyncJitter Is the share of the status sync interval taken off at
+	// random from each requeue, so that the clusters do not sync in lockstep
+	// and still sync at least once per interval.
+	statusSyncJitter = 0.1
 )
 
 // IpfsReconciler reconciles a Ipfs object.
 type IpfsReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Defaults *DefaultsStore
+	// APIReader reads objects which are not worth caching, such as events.
+	APIReader client.Reader
+	// Recorder emits events about the Ipfs resources.
+	Recorder record.EventRecorder
+	// Kubo dials the RPC API of the kubo node of each peer. It defaults to
+	// the DNS name of the peer pod.
+	Kubo kuboapi.Dialer
+	// ClusterAPI configures the clients of the REST API of the clusters,
+	// for example to point them at a fake cluster in tests.
+	ClusterAPI []clusterapi.Option
+	// DiffLogLevel is the verbosity the changes made to the objects of every
+	// cluster are logged at. When nil, they are only logged for the clusters
+	// with the debug-diff annotation.
+	DiffLogLevel *int
+	// Queue tunes how the reconciles of the clusters are queued.
+	Queue QueueOptions
+	// OperatorImage is the image of the operator, which runs the preflight
+	// checks of the peers. The checks are skipped when it is empty.
+	OperatorImage string
+	// VerifyOnly audits every cluster instead of reconciling it, as the
+	// verify-only annotation does for a single cluster.
+	VerifyOnly bool
+	// Webhooks tells that the operator serves its webhooks. The overrides of
+	// the peer pods and the cordons of peers are refused without them, as
+	// only the mutating webhook applies the former and holds off the pods of
+	// the latter.
+	Webhooks bool
+	// APIBreaker tunes the circuit breakers guarding the calls to the APIs
+	// of each cluster.
+	APIBreaker breaker.Settings
+	// Pool bounds the calls to the APIs of the peers across the clusters,
+	// and stretches the status sync interval while it is saturated. The
+	// calls are not bounded when it is nil.
+	Pool *connpool.Pool
+	// Build identifies the build of the operator in the exported
+	// configurations of the clusters.
+	Build BuildInfo
+	// OperatorNamespace is the namespace the operator runs in, which the
+	// NetworkPolicies of the pprof endpoints of the peers let in.
+	OperatorNamespace string
+	// Profiles fetches the pprof profiles of the daemons of the peers. It
+	// defaults to an HTTP GET of the endpoint of the peer pod.
+	Profiles ProfileFetcher
+
+	gatewayUsage gatewayUsage
+	breakers     apiBreakers
+	warmup       warmup
+	admission    admissionProbes
 }
 
-//+kubebuilder:rbac:groups=*,resources=*,verbs=get;list
+//+kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfs/finalizers,verbs=update
 //+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=get;list;create;patch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
 //+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumes,verbs=get;list;watch;update;patch
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
 
+// Reconcile Reconciles the cluster, then decides how the reconcile is retried
+// from the class of the error it stopped at, if any.
 func (r *IpfsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := ctrllog.FromContext(ctx)
+	result, err := r.reconcile(ctx, req)
+	if err == nil {
+		return result, nil
+	}
+	return r.handleError(ctx, req.NamespacedName, err)
+}
+
+// reconcileState Is the state the steps of the reconcile of a cluster share.
+type reconcileState struct {
+	// instance Is the cluster as stored, whose status the steps fill in.
+	instance *clusterv1alpha1.Ipfs
+	// observed Is the status as read, so that the status is only written
+	// when it changed and a cluster at rest is not rewritten on every sync.
+	observed *clusterv1alpha1.IpfsStatus
+	// resolved Is the cluster with its spec resolved against the operator
+	// defaults, which its objects are rendered from.
+	resolved   *clusterv1alpha1.Ipfs
+	configHash string
+	gate       *maintenanceGate
+	// partition Is the partition the rollout of the peers is paced with.
+	partition *int32
+	// rolloutRequeue Is when the paced rollout is checked again.
+	rolloutRequeue time.Duration
+	// claimsRejected Tells why the claims of new peers were rejected.
+	claimsRejected *diagnosis
+	tlsReady       bool
+	dnsReady       bool
+	// joining Tells whether peers are still joining the cluster.
+	joining bool
+}
+
+// reconcileStep Is a step of the reconcile of a cluster. It returns how soon
+// the cluster is to be reconciled again, or zero.
+type reconcileStep func(context.Context, *reconcileState) (time.Duration, error)
+
+// reconcile Runs the steps of the reconcile of the cluster. The steps before
+// the objects are applied stop the reconcile while they wait, and the steps
+// after them requeue it after the shortest delay they return. The errors are
+// returned as they are, for Reconcile to classify and log them.
+func (r *IpfsReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	admitted, deferred, done, err := r.admitWarmup(ctx, req.NamespacedName)
+	if !admitted {
+		return deferred, err
+	}
+	defer done()
+	reconciles.WithLabelValues(req.Namespace, req.Name).Inc()
 	// Fetch the Ipfs instance
 	instance, err := r.ensureIPFSCluster(ctx, req)
-	if err != nil {
+	if err != nil || instance == nil {
 		return ctrl.Result{}, err
 	}
+	if r.verifying(instance) {
+		return r.verify(ctx, instance)
+	}
+	if result, stop, err := r.reconcileLifecycle(ctx, instance); stop || err != nil {
+		return result, err
+	}
+
+	s := &reconcileState{instance: instance}
+	if wait, err := r.awaitRelays(ctx, s); wait > 0 || err != nil {
+		return ctrl.Result{RequeueAfter: wait}, err
+	}
+	s.observed = instance.Status.DeepCopy()
+	for _, step := range []reconcileStep{r.awaitClone, r.resolveSpec, r.provisionClaims, r.paceRollout} {
+		if wait, err := step(ctx, s); wait > 0 || err != nil {
+			return ctrl.Result{RequeueAfter: wait}, err
+		}
+	}
+	if incomplete, err := r.renderObjects(ctx, s); incomplete || err != nil {
+		return ctrl.Result{Requeue: incomplete}, err
+	}
 
-	// Add finalizer for this CR
-	if !controllerutil.ContainsFinalizer(instance, finalizer) {
-		controllerutil.AddFinalizer(instance, finalizer)
-		err = r.Update(ctx, instance)
+	requeue := minRequeue(s.rolloutRequeue, s.gate.requeue(time.Now()))
+	for _, step := range []reconcileStep{
+		r.cleanupObjects, r.syncCredentials, r.runOperations, r.syncStatus, r.checkPeers, r.writeStatus,
+	} {
+		after, err := step(ctx, s)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		return ctrl.Result{Requeue: true}, nil
+		requeue = minRequeue(requeue, after)
 	}
-
-	if instance.DeletionTimestamp != nil {
-		controllerutil.RemoveFinalizer(instance, finalizer)
-		return ctrl.Result{}, r.Update(ctx, instance)
+	if requeue > 0 {
+		return ctrl.Result{RequeueAfter: requeue}, nil
 	}
+	if !s.dnsReady {
+		ctrllog.FromContext(ctx).Info("dns record does not point at the gateway yet. Will continue waiting.")
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	syncInterval := r.statusSyncInterval()
+	if s.joining && membershipCheckInterval < syncInterval {
+		return ctrl.Result{RequeueAfter: membershipCheckInterval}, nil
+	}
+	// Keep the status of the peers fresh, spreading the syncs of the
+	// clusters over time.
+	jitter := wait.Jitter(syncInterval, statusSyncJitter) - syncInterval
+	return ctrl.Result{RequeueAfter: syncInterval - jitter}, nil
+}
 
-	// generate a new ID
-	var peerid peer.ID
-	var privStr string
-	if peerid, privStr, err = generateIdentity(); err != nil {
-		log.Error(err, "failed to generate identity")
-		return ctrl.Result{}, err
+// minRequeue Returns the shortest of the positive delays, or zero when none is.
+func minRequeue(delays ...time.Duration) time.Duration {
+	var shortest time.Duration
+	for _, delay := range delays {
+		if delay > 0 && (shortest == 0 || delay < shortest) {
+			shortest = delay
+		}
 	}
+	return shortest
+}
 
-	clusSec, err := newClusterSecret()
+// reconcileLifecycle Sets the finalizer of the cluster, runs it once the
+// cluster is deleted, and pauses the cluster while it exceeds its budget. The
+// reconcile stops at the result when it returns true.
+func (r *IpfsReconciler) reconcileLifecycle(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) (ctrl.Result, bool, error) {
+	if migrated, err := r.migrateLegacyFinalizer(ctx, instance); err != nil || migrated {
+		return ctrl.Result{Requeue: migrated}, true, err
+	}
+	if !hasFinalizer(instance) {
+		if err := r.setFinalizer(ctx, instance, true); err != nil {
+			return ctrl.Result{}, true, err
+		}
+		return ctrl.Result{Requeue: true}, true, nil
+	}
+	if instance.DeletionTimestamp != nil {
+		return ctrl.Result{}, true, r.finalize(ctx, instance)
+	}
+	paused, err := r.enforceBudget(ctx, instance)
 	if err != nil {
-		log.Error(err, "cannot generate new cluster secret")
-		return ctrl.Result{}, err
+		return ctrl.Result{}, true, fmt.Errorf("cannot check the budget of the cluster: %w", err)
 	}
-
-	if err = r.createCircuitRelays(ctx, instance); err != nil {
-		log.Error(err, "cannot create circuit relays")
-		return ctrl.Result{}, err
+	if paused {
+		// Namespaces are not watched, so their budget is checked again later.
+		ctrllog.FromContext(ctx).Info("cluster exceeds its budget. Will continue waiting.")
+		return ctrl.Result{RequeueAfter: r.Defaults.Get().StatusSyncInterval.Duration}, true, nil
 	}
+	return ctrl.Result{}, false, nil
+}
 
-	// Check the status of circuit relays.
-	// wait for them to complte so we can determine announce addresses.
+// awaitRelays Creates the circuit relays of the cluster and waits for them to
+// announce their addresses, which the peers are configured with.
+func (r *IpfsReconciler) awaitRelays(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance := s.instance
+	if err := r.createCircuitRelays(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot create circuit relays: %w", err)
+	}
 	for _, relayName := range instance.Status.CircuitRelays {
 		relay := clusterv1alpha1.CircuitRelay{}
 		relay.Name = relayName
 		relay.Namespace = instance.Namespace
-		if err = r.Client.Get(ctx, client.ObjectKeyFromObject(&relay), &relay); err != nil {
-			log.Error(err, "could not lookup circuitRelay", "relay", relayName)
-			return ctrl.Result{Requeue: true}, err
+		if err := r.Client.Get(ctx, client.ObjectKeyFromObject(&relay), &relay); err != nil {
+			return 0, fmt.Errorf("cannot get circuit relay %s: %w", relayName, err)
+		}
+		if relay.Status.AddrInfo.ID != "" {
+			continue
 		}
-		if relay.Status.AddrInfo.ID == "" {
-			log.Info("relay is not ready yet. Will continue waiting.", "relay", relayName)
-			return ctrl.Result{RequeueAfter: time.Minute}, nil
+		ctrllog.FromContext(ctx).Info("relay is not ready yet. Will continue waiting.", "relay", relayName)
+		if instance.Status.Phase != clusterv1alpha1.PhasePending {
+			instance.Status.Phase = clusterv1alpha1.PhasePending
+			if err := r.Status().Update(ctx, instance); err != nil {
+				return 0, err
+			}
 		}
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+// awaitClone Splits the legacy cluster secret, then waits for the volumes of
+// the source of a clone to be copied.
+func (r *IpfsReconciler) awaitClone(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance := s.instance
+	if err := r.splitConfigSecret(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot split the cluster secret: %w", err)
 	}
+	cloning, err := r.cloneCluster(ctx, instance)
+	if err != nil {
+		return 0, fmt.Errorf("cannot clone cluster from %s: %w", instance.Spec.CloneFrom, err)
+	}
+	if !cloning {
+		return 0, nil
+	}
+	ctrllog.FromContext(ctx).Info("cluster is being cloned. Will continue waiting.", "source", instance.Spec.CloneFrom)
+	instance.Status.Phase = clusterv1alpha1.PhasePending
 	if err = r.Status().Update(ctx, instance); err != nil {
-		return ctrl.Result{}, err
+		return 0, err
+	}
+	return cloneRequeueInterval, nil
+}
+
+// resolveSpec Resolves the spec against the operator defaults, as recorded by
+// the cluster so that upgrading the operator does not roll it, then waits for
+// the StatefulSet to be recreated when a change requires it.
+func (r *IpfsReconciler) resolveSpec(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance := s.instance
+	configHash, err := r.referencedConfigHash(ctx, instance)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read referenced configuration: %w", err)
+	}
+	s.configHash = configHash
+	r.Defaults.recordDefaults(instance)
+	resolved := r.Defaults.For(instance).apply(instance)
+	s.resolved = resolved
+	r.fenceSpec(instance, resolved)
+	if err = r.resolveSafeToEvict(ctx, resolved); err != nil {
+		return 0, fmt.Errorf("cannot tell whether the volumes of the peers are bound to their nodes: %w", err)
+	}
+	r.reconcileAutoscaling(ctx, instance)
+	resolved.Spec.Replicas = peerCount(instance)
+	recreating, err := r.recreateStatefulSetOnPolicyChange(ctx, resolved)
+	if err == nil && !recreating {
+		recreating, err = r.recreateStatefulSetOnSelectorChange(ctx, instance, resolved)
+	}
+	if err == nil && !recreating {
+		recreating, err = r.recreateStatefulSetOnServiceChange(ctx, instance, resolved)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot recreate statefulset: %w", err)
+	}
+	if recreating {
+		ctrllog.FromContext(ctx).Info("statefulset is being recreated. Will continue waiting.")
+		return 5 * time.Second, nil
+	}
+	return 0, nil
+}
+
+// provisionClaims Creates the volume claims of the peers ahead of the
+// StatefulSet: those seeded, those of the peers scaled up and those
+// overridden.
+func (r *IpfsReconciler) provisionClaims(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	if err := r.seedClaims(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot seed volume claims: %w", err)
+	}
+	replicas, claimsRejected, err := r.scaleUpClaims(ctx, s.resolved)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create the volume claims of the new peers: %w", err)
+	}
+	s.resolved.Spec.Replicas = replicas
+	s.claimsRejected = claimsRejected
+	if err = r.overrideClaims(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot create the overridden volume claims: %w", err)
+	}
+	return 0, nil
+}
+
+// paceRollout Checks the TLS secret of the gateway, and paces the rollout of
+// the peers, holding it outside of the maintenance window.
+func (r *IpfsReconciler) paceRollout(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	tlsReady, err := r.syncTLS(ctx, s.instance)
+	if err != nil {
+		return 0, fmt.Errorf("cannot check tls secret: %w", err)
+	}
+	s.tlsReady = tlsReady
+	partition, rolloutRequeue, err := r.rolloutPartition(ctx, s.instance, s.resolved)
+	if err != nil {
+		return 0, fmt.Errorf("cannot pace the rollout of the peers: %w", err)
+	}
+	s.rolloutRequeue = rolloutRequeue
+	s.gate = maintenanceGateOf(s.resolved, time.Now())
+	if s.partition, err = r.maintenancePartition(ctx, s.resolved, s.gate, partition); err != nil {
+		return 0, fmt.Errorf("cannot hold the rollout of the peers for the maintenance window: %w", err)
+	}
+	return 0, nil
+}
+
+// renderObjects Renders and applies the tracked objects of the cluster, phase
+// by phase. It returns true while a phase is incomplete, having reported it
+// in the status.
+func (r *IpfsReconciler) renderObjects(ctx context.Context, s *reconcileState) (bool, error) {
+	instance := s.instance
+	if err := r.reconcileDiagnostics(ctx, instance); err != nil {
+		return false, fmt.Errorf("cannot collect diagnostics: %w", err)
+	}
+	if err := r.reconcileProfileCapture(ctx, instance); err != nil {
+		return false, fmt.Errorf("cannot capture profiles: %w", err)
+	}
+	// generate a new ID
+	peerid, privStr, err := generateIdentity()
+	if err != nil {
+		return false, fmt.Errorf("failed to generate identity: %w", err)
+	}
+	clusSec, err := newClusterSecret()
+	if err != nil {
+		return false, fmt.Errorf("cannot generate new cluster secret: %w", err)
+	}
+	phases := r.createTrackedObjects(ctx, withPartition(s.resolved, s.partition), peerid, clusSec, privStr,
+		s.configHash, s.tlsReady)
+	guardConfig(instance, s.resolved, phases)
+	if err = r.guardAdmission(ctx, instance, phases); err != nil {
+		return false, fmt.Errorf("cannot probe the admission of the pods of the peers: %w", err)
+	}
+	incomplete := r.applyPhases(ctx, instance, phases)
+	if incomplete == nil {
+		if err = r.pruneInventory(ctx, instance, phases); err != nil {
+			return false, fmt.Errorf("cannot prune the objects the spec no longer produces: %w", err)
+		}
+		return false, nil
+	}
+	ctrllog.FromContext(ctx).Info("cluster objects are incomplete. Will retry.", "reason", incomplete.reason,
+		"message", incomplete.message)
+	setReconciledCondition(instance, metav1.ConditionFalse, incomplete.reason, incomplete.message)
+	syncMaintenanceWindow(instance, s.gate)
+	if err = r.syncReadiness(ctx, instance); err != nil {
+		return false, err
+	}
+	if err = r.Status().Update(ctx, instance); err != nil {
+		return false, err
+	}
+	if err = r.consumeMaintenanceSkip(ctx, instance, s.gate); err != nil {
+		return false, err
+	}
+	return true, r.syncReadyConfigMap(ctx, instance)
+}
+
+// cleanupObjects Deletes the objects of the features the spec turned off.
+func (r *IpfsReconciler) cleanupObjects(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	if err := r.deleteAuthProxyConfig(ctx, s.instance); err != nil {
+		return 0, fmt.Errorf("cannot clean up auth proxy: %w", err)
+	}
+	if err := r.deleteMonitoring(ctx, s.instance); err != nil {
+		return 0, fmt.Errorf("cannot clean up monitoring: %w", err)
+	}
+	if err := r.deleteGatewayExposure(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot clean up gateway exposure: %w", err)
+	}
+	if err := r.deleteWebSockets(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot clean up websocket exposure: %w", err)
+	}
+	if err := r.deleteBootstrapServices(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot clean up bootstrap services: %w", err)
+	}
+	if err := r.cleanupServiceAccount(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot clean up service account: %w", err)
 	}
+	return 0, nil
+}
+
+// syncCredentials Exports the resolved configuration and rotates the
+// credentials of the cluster API.
+func (r *IpfsReconciler) syncCredentials(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	if err := r.syncResolvedConfig(ctx, s.instance, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot export resolved configuration: %w", err)
+	}
+	if err := r.reconcileAPICredentialRotation(ctx, s.instance); err != nil {
+		return 0, fmt.Errorf("cannot rotate cluster API credentials: %w", err)
+	}
+	if err := r.syncCtlCredentials(ctx, s.resolved); err != nil {
+		return 0, fmt.Errorf("cannot sync ipfs-cluster-ctl credentials: %w", err)
+	}
+	return 0, nil
+}
+
+// runOperations Runs the operations on the peers which go on over several
+// reconciles, and returns when the first of them is to be checked again.
+func (r *IpfsReconciler) runOperations(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance, resolved, gate := s.instance, s.resolved, s.gate
+	secretRequeue, err := r.reconcileSecretRotation(ctx, instance, gate)
+	if err != nil {
+		return 0, fmt.Errorf("cannot rotate cluster secret: %w", err)
+	}
+	identityRequeue, err := r.reconcileIdentityRotation(ctx, instance, gate)
+	if err != nil {
+		return 0, fmt.Errorf("cannot rotate peer identity: %w", err)
+	}
+	ctlRequeue, err := r.reconcileCtlJob(ctx, instance, resolved)
+	if err != nil {
+		return 0, fmt.Errorf("cannot run ipfs-cluster-ctl job: %w", err)
+	}
+	compactionRequeue, err := r.reconcileCompaction(ctx, instance, resolved, gate)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compact the state of the peers: %w", err)
+	}
+	cordonRequeue, err := r.reconcileCordon(ctx, instance)
+	if err != nil {
+		return 0, fmt.Errorf("cannot cordon the peer: %w", err)
+	}
+	gcRequeue, err := r.reconcileGC(ctx, instance, resolved)
+	if err != nil {
+		return 0, fmt.Errorf("cannot collect the repos of the peers: %w", err)
+	}
+	return minRequeue(secretRequeue, identityRequeue, ctlRequeue, compactionRequeue, cordonRequeue, gcRequeue), nil
+}
+
+// syncStatus Records the spec the cluster was reconciled from in its status,
+// along with the status of its peers.
+func (r *IpfsReconciler) syncStatus(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance, resolved := s.instance, s.resolved
+	dnsReady, err := r.verifyDNS(ctx, instance)
+	if err != nil {
+		return 0, fmt.Errorf("cannot verify dns record: %w", err)
+	}
+	s.dnsReady = dnsReady
+	setReconciledCondition(instance, metav1.ConditionTrue, clusterv1alpha1.ReconciledReasonComplete, "")
+	meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionDrifted)
+	instance.Status.ObservedGeneration = instance.Generation
+	instance.Status.APIMode = apiMode(resolved)
+	instance.Status.Routing = routingType(resolved)
+	instance.Status.Cluster = clusterConfigOf(resolved)
+	instance.Status.Profiling = profilingEndpoints(resolved)
+	r.setNoSyncWritesCondition(instance, resolved)
+	if instance.Status.Frozen == nil {
+		frozen := clusterv1alpha1.FrozenSpecOf(&resolved.Spec)
+		instance.Status.Frozen = &frozen
+	}
+	if err = r.syncPeers(ctx, instance, resolved, s.configHash); err != nil {
+		return 0, fmt.Errorf("cannot sync peer status: %w", err)
+	}
+	if err = r.syncGatewayCoverage(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync gateway coverage: %w", err)
+	}
+	if err = r.syncHistory(ctx, instance, resolved, s.configHash); err != nil {
+		return 0, fmt.Errorf("cannot record the change history: %w", err)
+	}
+	if err = r.syncClaimLabels(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot label volume claims: %w", err)
+	}
+	syncDeletionWarnings(instance)
+	if err = r.deleteLegacyConfigSecret(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot delete the combined cluster secret: %w", err)
+	}
+	if err = r.syncOverrides(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot check the overrides of the peers: %w", err)
+	}
+	if s.joining, err = r.syncMembership(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync cluster membership of the peers: %w", err)
+	}
+	return 0, nil
+}
+
+// checkPeers Checks the repos, the mesh, the announced addresses and the
+// content path of the peers, and hands the pinset to the provider nodes.
+func (r *IpfsReconciler) checkPeers(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance, resolved := s.instance, s.resolved
+	if err := r.syncRepoUsage(ctx, instance, resolved); err != nil {
+		return 0, fmt.Errorf("cannot sync repo usage: %w", err)
+	}
+	if err := r.syncMesh(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot check the mesh of the peers: %w", err)
+	}
+	r.syncPostPartitionAudit(ctx, instance)
+	if err := r.syncAnnounceAudit(ctx, instance, resolved); err != nil {
+		return 0, fmt.Errorf("cannot audit the addresses announced by the peers: %w", err)
+	}
+	smokeTestRequeue, err := r.reconcileSmokeTest(ctx, instance)
+	if err != nil {
+		return 0, fmt.Errorf("cannot run the smoke test: %w", err)
+	}
+	providersRequeue, err := r.syncProviders(ctx, instance, resolved)
+	if err != nil {
+		return 0, fmt.Errorf("cannot hand the pinset to the provider nodes: %w", err)
+	}
+	if err = r.syncFederation(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync federation bundles: %w", err)
+	}
+	if err = r.syncGatewayUsage(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync gateway usage: %w", err)
+	}
+	if err = r.syncPreflight(ctx, instance, resolved); err != nil {
+		return 0, fmt.Errorf("cannot sync preflight checks: %w", err)
+	}
+	return minRequeue(smokeTestRequeue, providersRequeue), nil
+}
+
+// writeStatus Diagnoses the cluster, writes its status when it changed, and
+// the ConfigMaps derived from it.
+func (r *IpfsReconciler) writeStatus(ctx context.Context, s *reconcileState) (time.Duration, error) {
+	instance := s.instance
+	if err := r.diagnose(ctx, instance, s.claimsRejected); err != nil {
+		return 0, fmt.Errorf("cannot diagnose cluster objects: %w", err)
+	}
+	r.syncAPIUnreachable(instance)
+	syncVersionCombination(instance, s.resolved)
+	syncMaintenanceWindow(instance, s.gate)
+	if err := r.syncReadiness(ctx, instance); err != nil {
+		return 0, err
+	}
+	if !equality.Semantic.DeepEqual(s.observed, &instance.Status) {
+		if err := r.Status().Update(ctx, instance); err != nil {
+			return 0, err
+		}
+	}
+	if err := r.consumeMaintenanceSkip(ctx, instance, s.gate); err != nil {
+		return 0, fmt.Errorf("cannot remove the skip-maintenance-window annotation: %w", err)
+	}
+	if err := r.syncReadyConfigMap(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync the ready configmap: %w", err)
+	}
+	if err := r.syncBootstrapConfigMap(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync the bootstrap configmap: %w", err)
+	}
+	if err := r.syncPeerDirectory(ctx, instance); err != nil {
+		return 0, fmt.Errorf("cannot sync the peer directory configmap: %w", err)
+	}
+	return 0, nil
+}
 
-	// Reconcile the tracked objects
-	trackedObjects := r.createTrackedObjects(ctx, instance, peerid, privStr, clusSec)
-	shouldRequeue := utils.CreateOrPatchTrackedObjects(ctx, trackedObjects, r.Client, log)
-	return ctrl.Result{Requeue: shouldRequeue}, nil
+// trackedObjects Are the tracked objects of each phase, mapped to their
+// mutating functions.
+type trackedObjects struct {
+	identity map[client.Object]controllerutil.MutateFn
+	config   map[client.Object]controllerutil.MutateFn
+	services map[client.Object]controllerutil.MutateFn
+	workload map[client.Object]controllerutil.MutateFn
 }
 
-// createTrackedObjects Creates a mapping from client objects to their mutating functions.
+// createTrackedObjects Creates the phases of the tracked objects, mapping
+// each object to its mutating function. The identities and credentials come
+// first, then the configuration of the peers, their Services and finally the
+// StatefulSet which mounts all of them.
 func (r *IpfsReconciler) createTrackedObjects(
 	ctx context.Context,
 	instance *clusterv1alpha1.Ipfs,
 	peerID peer.ID,
 	clusterSecret string,
 	privateString string,
-) map[client.Object]controllerutil.MutateFn {
+	configHash string,
+	tlsReady bool,
+) []reconcilePhase {
 	sa := corev1.ServiceAccount{}
-	svc := corev1.Service{}
+	svcSwarm := corev1.Service{}
+	svcAPI := corev1.Service{}
+	svcGw := corev1.Service{}
 	cmScripts := corev1.ConfigMap{}
 	cmConfig := corev1.ConfigMap{}
-	secConfig := corev1.Secret{}
+	secIdentities := corev1.Secret{}
+	secCluster := corev1.Secret{}
+	secAPI := corev1.Secret{}
 	sts := appsv1.StatefulSet{}
 
 	mutsa := r.serviceAccount(instance, &sa)
-	mutsvc, svcName := r.serviceCluster(instance, &svc)
+	mutSvcSwarm, _ := r.serviceSwarm(instance, &svcSwarm)
+	mutSvcAPI, _ := r.serviceAPI(instance, &svcAPI)
+	mutSvcGw, _ := r.serviceGatewayCluster(instance, &svcGw)
 	mutCmScripts, cmScriptName := r.configMapScripts(ctx, instance, &cmScripts)
 	mutCmConfig, cmConfigName := r.configMapConfig(instance, &cmConfig, peerID.String())
-	mutSecConfig, secConfigName := r.secretConfig(instance, &secConfig, []byte(clusterSecret), []byte(privateString))
-	mutSts := r.statefulSet(instance, &sts, svcName, secConfigName, cmConfigName, cmScriptName)
+	mutSecIdentities, secIdentitiesName := r.secretIdentities(instance, &secIdentities, []byte(privateString))
+	mutSecCluster, _ := r.secretClusterSecret(instance, &secCluster, []byte(clusterSecret))
+	mutSecAPI, _ := r.apiCredentials(instance, &secAPI)
+	mutSts := r.statefulSet(instance, &sts, peerServiceName(instance), secIdentitiesName, cmConfigName,
+		cmScriptName, configHash)
+
+	peerLabels := ipfsLabels(instance, componentPeer)
+	gatewayLabels := ipfsLabels(instance, componentGateway)
+	objects := trackedObjects{
+		identity: map[client.Object]controllerutil.MutateFn{
+			&secIdentities: labeled(&secIdentities, peerLabels, mutSecIdentities),
+			&secCluster:    labeled(&secCluster, peerLabels, mutSecCluster),
+			&secAPI:        labeled(&secAPI, peerLabels, mutSecAPI),
+		},
+		config: map[client.Object]controllerutil.MutateFn{
+			&cmScripts: labeled(&cmScripts, peerLabels, mutCmScripts),
+			&cmConfig:  labeled(&cmConfig, peerLabels, mutCmConfig),
+		},
+		services: map[client.Object]controllerutil.MutateFn{
+			&svcSwarm: labeled(&svcSwarm, peerLabels, mutSvcSwarm),
+			&svcAPI:   labeled(&svcAPI, peerLabels, mutSvcAPI),
+			&svcGw:    labeled(&svcGw, gatewayLabels, mutSvcGw),
+		},
+		workload: map[client.Object]controllerutil.MutateFn{
+			&sts: labeled(&sts, peerLabels, mutSts),
+		},
+	}
+	if serviceAccountCreated(instance) {
+		objects.identity[&sa] = labeled(&sa, peerLabels, mutsa)
+	}
+	if peerRBACEnabled(instance) {
+		role := rbacv1.Role{}
+		rb := rbacv1.RoleBinding{}
+		objects.identity[&role] = labeled(&role, peerLabels, r.peerRole(instance, &role))
+		objects.identity[&rb] = labeled(&rb, peerLabels, r.peerRoleBinding(instance, &rb))
+	}
+	if legacyServiceKept(instance) {
+		svc := corev1.Service{}
+		mutsvc, _ := r.serviceLegacy(instance, &svc)
+		objects.services[&svc] = labeled(&svc, peerLabels, mutsvc)
+	}
+	r.addExposureObjects(instance, tlsReady, &objects)
+	r.addComponentObjects(ctx, instance, sts.Spec.Template.DeepCopy(), &objects)
+	return []reconcilePhase{
+		{reason: clusterv1alpha1.ReconciledReasonIdentityNotReady, objects: objects.identity},
+		{reason: clusterv1alpha1.ReconciledReasonConfigNotReady, objects: objects.config},
+		{reason: clusterv1alpha1.ReconciledReasonServiceNotReady, objects: objects.services},
+		{reason: clusterv1alpha1.ReconciledReasonWorkloadNotReady, objects: objects.workload},
+	}
+}
 
-	trackedObjects := map[client.Object]controllerutil.MutateFn{
-		&sa:        mutsa,
-		&svc:       mutsvc,
-		&cmScripts: mutCmScripts,
-		&cmConfig:  mutCmConfig,
-		&secConfig: mutSecConfig,
-		&sts:       mutSts,
+// addExposureObjects Adds the objects exposing the gateway and the swarm of
+// the peers: the authenticating proxy, the public Service, the Ingress and its
+// certificate, the websocket Services and the bootstrap Services.
+func (r *IpfsReconciler) addExposureObjects(
+	instance *clusterv1alpha1.Ipfs,
+	tlsReady bool,
+	objects *trackedObjects,
+) {
+	gatewayLabels := ipfsLabels(instance, componentGateway)
+	if instance.Spec.Expose.Auth != nil {
+		secAuthProxy := corev1.Secret{}
+		mutSecAuthProxy, _ := r.authProxyConfig(instance, &secAuthProxy)
+		objects.identity[&secAuthProxy] = labeled(&secAuthProxy, gatewayLabels, mutSecAuthProxy)
+	}
+	if instance.Spec.Public {
+		gwSvc := corev1.Service{}
+		mutGwSvc, _ := r.serviceGateway(instance, &gwSvc)
+		objects.services[&gwSvc] = labeled(&gwSvc, gatewayLabels, mutGwSvc)
+	}
+	if instance.Spec.Expose.Host != "" {
+		ing := networkingv1.Ingress{}
+		mutIng, _ := r.ingressGateway(instance, &ing, tlsReady)
+		objects.services[&ing] = labeled(&ing, gatewayLabels, mutIng)
+		if tls := instance.Spec.Expose.TLS; tls != nil && tls.IssuerRef != nil {
+			cert := unstructured.Unstructured{}
+			mutCert, _ := r.certificateGateway(instance, &cert)
+			objects.identity[&cert] = labeled(&cert, gatewayLabels, mutCert)
+		}
+	}
+	if webSocketPublic(instance) {
+		swarmLabels := ipfsLabels(instance, componentSwarm)
+		for ordinal := int32(0); ordinal < instance.Spec.Replicas; ordinal++ {
+			wsSvc := corev1.Service{}
+			mutWsSvc, _ := r.serviceWebSocket(instance, &wsSvc, ordinal)
+			objects.services[&wsSvc] = labeled(&wsSvc, swarmLabels, mutWsSvc)
+		}
+		wsIng := networkingv1.Ingress{}
+		mutWsIng, _ := r.ingressWebSocket(instance, &wsIng, tlsReady)
+		objects.services[&wsIng] = labeled(&wsIng, swarmLabels, mutWsIng)
+	}
+	bootstrapLabels := ipfsLabels(instance, componentBootstrap)
+	for ordinal := int32(0); ordinal < bootstrapPeers(instance); ordinal++ {
+		bootstrapSvc := corev1.Service{}
+		mutBootstrapSvc, _ := r.serviceBootstrap(instance, &bootstrapSvc, ordinal)
+		objects.services[&bootstrapSvc] = labeled(&bootstrapSvc, bootstrapLabels, mutBootstrapSvc)
 	}
-	return trackedObjects
 }
 
-// ensureIPFSCluster Attempts to obtain an IPFS Cluster resource, and error if not found.
+// addComponentObjects Adds the objects of the components running next to the
+// peers: the provider nodes, the gateway nodes, the NetworkPolicy of the
+// pprof endpoints, which lets in the pods of the peer template, and the
+// monitoring of the gateway.
+func (r *IpfsReconciler) addComponentObjects(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	peerTemplate *corev1.PodTemplateSpec,
+	objects *trackedObjects,
+) {
+	if providersEnabled(instance) {
+		labels := providerLabels(instance)
+		cmProviders := corev1.ConfigMap{}
+		svcProviders := corev1.Service{}
+		stsProviders := appsv1.StatefulSet{}
+		mutCmProviders, scriptHash := r.configMapProviders(instance, &cmProviders)
+		objects.config[&cmProviders] = labeled(&cmProviders, labels, mutCmProviders)
+		objects.services[&svcProviders] = labeled(&svcProviders, labels, r.serviceProviders(instance, &svcProviders))
+		objects.workload[&stsProviders] = labeled(&stsProviders, labels, r.statefulSetProviders(instance,
+			&stsProviders, scriptHash))
+	}
+	if gatewayNodesEnabled(instance) {
+		labels := gatewayNodeLabels(instance)
+		cmGatewayNodes := corev1.ConfigMap{}
+		svcGatewayNodes := corev1.Service{}
+		dsGatewayNodes := appsv1.DaemonSet{}
+		mutCmGatewayNodes, scriptHash := r.configMapGatewayNodes(instance, &cmGatewayNodes)
+		objects.config[&cmGatewayNodes] = labeled(&cmGatewayNodes, labels, mutCmGatewayNodes)
+		objects.services[&svcGatewayNodes] = labeled(&svcGatewayNodes, labels, r.serviceGatewayNodes(instance,
+			&svcGatewayNodes))
+		objects.workload[&dsGatewayNodes] = labeled(&dsGatewayNodes, labels, r.daemonSetGatewayNodes(instance,
+			&dsGatewayNodes, scriptHash))
+	}
+	if profilingEnabled(instance) {
+		np := networkingv1.NetworkPolicy{}
+		objects.services[&np] = labeled(&np, ipfsLabels(instance, componentDebug),
+			r.networkPolicyProfiling(ctx, instance, &np, peerTemplate))
+	}
+	if gatewayMonitored(instance) {
+		monitoringLabels := ipfsLabels(instance, componentMonitoring)
+		metricsSvc := corev1.Service{}
+		mutMetricsSvc, _ := r.serviceMetrics(instance, &metricsSvc)
+		objects.services[&metricsSvc] = labeled(&metricsSvc, monitoringLabels, mutMetricsSvc)
+		sm := unstructured.Unstructured{}
+		mutSm, _ := r.serviceMonitorGateway(instance, &sm)
+		objects.services[&sm] = labeled(&sm, monitoringLabels, mutSm)
+	}
+}
+
+// ensureIPFSCluster Attempts to obtain an IPFS Cluster resource, or nil once
+// it was deleted.
 func (r *IpfsReconciler) ensureIPFSCluster(
 	ctx context.Context,
 	req ctrl.Request,
@@ -173,30 +843,41 @@ func (r *IpfsReconciler) ensureIPFSCluster(
 		// Request object not found, could have been deleted after reconcile request.
 		// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 		// Return and don't requeue
-		return nil, fmt.Errorf("IPFS Cluster resource not found, ignoring since object must be deleted: %w", err)
+		return nil, nil
 	}
 	// Error reading the object - requeue the request.
 	return nil, fmt.Errorf("failed to get Ipfs: %w", err)
 }
 
-// createCircuitRelays Creates the necessary amount of circuit relays if any are missing.
-// FIXME: if we change the number of CircuitRelays, we should update
-// the IPFS config file as well.
+// createCircuitRelays Creates the necessary amount of circuit relays if any
+// are missing, and deletes the extra ones. The relays are peered with, so
+// that changing them changes the config hash and rolls the peers.
 func (r *IpfsReconciler) createCircuitRelays(
 	ctx context.Context,
 	instance *clusterv1alpha1.Ipfs,
 ) error {
+	if len(instance.Status.CircuitRelays) > int(instance.Spec.Networking.CircuitRelays) {
+		return r.deleteCircuitRelays(ctx, instance)
+	}
 	// do nothing
-	if len(instance.Status.CircuitRelays) >= int(instance.Spec.Networking.CircuitRelays) {
-		// FIXME: handle scale-down of circuit relays
+	if len(instance.Status.CircuitRelays) == int(instance.Spec.Networking.CircuitRelays) {
 		return nil
 	}
+	existing := map[string]bool{}
+	for _, name := range instance.Status.CircuitRelays {
+		existing[name] = true
+	}
 	// create the CircuitRelays
 	for i := 0; int32(i) < instance.Spec.Networking.CircuitRelays; i++ {
 		name := fmt.Sprintf("%s-%d", instance.Name, i)
+		if existing[name] {
+			continue
+		}
 		relay := clusterv1alpha1.CircuitRelay{}
 		relay.Name = name
 		relay.Namespace = instance.Namespace
+		relay.Labels = recommendedLabels("libp2p-relay-daemon-"+name, instance.Name, componentRelay,
+			relayImage, string(instance.UID))
 		if err := ctrl.SetControllerReference(instance, &relay, r.Scheme); err != nil {
 			return fmt.Errorf(
 				"cannot set controller reference for new circuitRelay: %w, circuitRelay: %s",
@@ -214,17 +895,61 @@ func (r *IpfsReconciler) createCircuitRelays(
 	return nil
 }
 
+// deleteCircuitRelays Deletes the circuit relays beyond the number the spec
+// asks for, last first, and drops them from the status, which drops them
+// from the peering set of the peers.
+func (r *IpfsReconciler) deleteCircuitRelays(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) error {
+	keep := int(instance.Spec.Networking.CircuitRelays)
+	for _, name := range instance.Status.CircuitRelays[keep:] {
+		relay := clusterv1alpha1.CircuitRelay{}
+		relay.Name = name
+		relay.Namespace = instance.Namespace
+		if err := r.Delete(ctx, &relay); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete circuitRelay %s: %w", name, err)
+		}
+	}
+	instance.Status.CircuitRelays = instance.Status.CircuitRelays[:keep]
+	return r.Status().Update(ctx, instance)
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *IpfsReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&clusterv1alpha1.Ipfs{}).
-		Owns(&appsv1.StatefulSet{}, builder.OnlyMetadata).
-		Owns(&corev1.Service{}, builder.OnlyMetadata).
-		Owns(&corev1.ServiceAccount{}, builder.OnlyMetadata).
-		Owns(&corev1.Secret{}, builder.OnlyMetadata).
-		Owns(&corev1.ConfigMap{}, builder.OnlyMetadata).
-		Owns(&clusterv1alpha1.Ipfs{}, builder.OnlyMetadata).
-		WithOptions(controller.Options{
-			MaxConcurrentReconciles: 1,
-		}).Complete(r)
+	if err := indexReferences(context.Background(), mgr); err != nil {
+		return err
+	}
+	bldr := ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1alpha1.Ipfs{})
+	for _, obj := range []client.Object{
+		&appsv1.StatefulSet{},
+		&appsv1.DaemonSet{},
+		&corev1.Service{},
+		&corev1.ServiceAccount{},
+		&rbacv1.Role{},
+		&rbacv1.RoleBinding{},
+		&corev1.Secret{},
+		&corev1.ConfigMap{},
+		&networkingv1.Ingress{},
+		&networkingv1.NetworkPolicy{},
+		&batchv1.Job{},
+		&clusterv1alpha1.Ipfs{},
+	} {
+		bldr = r.owns(bldr, obj)
+	}
+	bldr = bldr.Watches(&source.Kind{Type: &corev1.Secret{}},
+		r.referencingIpfs(secretRefsIndex, tlsSecretIndex, federationSecretIndex),
+		builder.WithPredicates(userSecret))
+	if r.Queue.WarmupConcurrency > 0 {
+		bldr = bldr.Watches(&source.Channel{Source: r.warmup.warmupEvents()}, &handler.EnqueueRequestForObject{})
+	}
+	if r.Defaults != nil {
+		// Resolve every cluster again when the operator defaults change.
+		bldr = bldr.Watches(&source.Channel{Source: r.Defaults.Changes()}, r.allIpfs())
+	}
+	return bldr.WithOptions(controller.Options{
+		MaxConcurrentReconciles: r.Queue.maxConcurrentReconciles(),
+		RateLimiter:             r.Queue.rateLimiter(),
+	}).Complete(r)
 }
@@ -0,0 +1,464 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+// contentSourceIndex Maps a ConfigMap or a Secret back to the IpfsContent
+// resources adding one of its keys.
+const contentSourceIndex = ".spec.sourceRef"
+
+// contentRetryInterval Is how long an IpfsContent which could not be added
+// or pinned waits before it is tried again.
+const contentRetryInterval = time.Minute
+
+// ContentReconciler reconciles the IpfsContent object, adding the key of a
+// ConfigMap or a Secret to a cluster and pinning it.
+type ContentReconciler struct {
+	client.Client
+	// Clusters reaches the APIs of the clusters the content is added to,
+	// through the breakers and the connection pool of the Ipfs controller.
+	Clusters *IpfsReconciler
+}
+
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfscontents,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfscontents/status,verbs=get;update;patch
+
+func (r *ContentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	content := &clusterv1alpha1.IpfsContent{}
+	if err := r.Get(ctx, req.NamespacedName, content); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("cannot get IpfsContent: %w", err)
+	}
+	observed := content.Status.DeepCopy()
+
+	d, retry, err := r.syncContent(ctx, content)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	ready := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             d.reason,
+		Message:            d.message,
+		ObservedGeneration: content.Generation,
+	}
+	if d.reason == clusterv1alpha1.ContentReasonPinned || d.reason == clusterv1alpha1.ContentReasonAdded {
+		ready.Status = metav1.ConditionTrue
+		content.Status.ObservedGeneration = content.Generation
+	}
+	if d.reason != clusterv1alpha1.ContentReasonWaiting {
+		content.Status.Queue = nil
+	}
+	meta.SetStatusCondition(&content.Status.Conditions, ready)
+
+	result := ctrl.Result{}
+	switch {
+	case d.reason == clusterv1alpha1.ContentReasonWaiting:
+		result.RequeueAfter = pinHoldInterval
+	case d.reason == clusterv1alpha1.ContentReasonPinning || d.reason == clusterv1alpha1.ContentReasonStalled:
+		result.RequeueAfter = pinProgressInterval
+	case retry:
+		result.RequeueAfter = contentRetryInterval
+	}
+	if equality.Semantic.DeepEqual(observed, &content.Status) {
+		return result, nil
+	}
+	if err = r.Status().Update(ctx, content); err != nil {
+		return ctrl.Result{}, fmt.Errorf("cannot update IpfsContent status: %w", err)
+	}
+	return result, nil
+}
+
+// contentPinned Returns whether the content is to be pinned through the cluster.
+func contentPinned(c *clusterv1alpha1.IpfsContent) bool {
+	return c.Spec.Pin == nil || *c.Spec.Pin
+}
+
+// contentSourceKey Returns the key of the source index for the object of the
+// given kind and name.
+func contentSourceKey(kind clusterv1alpha1.ContentSourceKind, name string) string {
+	return string(kind) + "/" + name
+}
+
+// contentPinName Returns the name the content is pinned with in the cluster,
+// which tells the IpfsContent it comes from.
+func contentPinName(c *clusterv1alpha1.IpfsContent) string {
+	return contentClusterPin(c).pinName(c.Namespace)
+}
+
+// sourceData Returns the bytes of the key of the source of the content, as
+// stored: the data of a ConfigMap as its UTF-8 text, and its binary data and
+// the data of a Secret as they were before being base64-encoded in the API.
+// A missing source, or content larger than MaxContentSize, is diagnosed.
+func (r *ContentReconciler) sourceData(
+	ctx context.Context,
+	c *clusterv1alpha1.IpfsContent,
+) ([]byte, *diagnosis, error) {
+	ref := c.Spec.SourceRef
+	objKey := client.ObjectKey{Namespace: c.Namespace, Name: ref.Name}
+	var data []byte
+	var found bool
+	var err error
+	switch ref.Kind {
+	case clusterv1alpha1.ContentSourceSecret:
+		sec := corev1.Secret{}
+		if err = r.Get(ctx, objKey, &sec); err == nil {
+			data, found = sec.Data[ref.Key]
+		}
+	default:
+		cm := corev1.ConfigMap{}
+		if err = r.Get(ctx, objKey, &cm); err == nil {
+			var text string
+			if text, found = cm.Data[ref.Key]; found {
+				data = []byte(text)
+			} else {
+				data, found = cm.BinaryData[ref.Key]
+			}
+		}
+	}
+	switch {
+	case errors.IsNotFound(err):
+		return nil, &diagnosis{
+			reason:  clusterv1alpha1.ContentReasonSourceNotFound,
+			message: fmt.Sprintf("%s %s does not exist", ref.Kind, ref.Name),
+		}, nil
+	case err != nil:
+		return nil, nil, fmt.Errorf("cannot get %s %s: %w", ref.Kind, ref.Name, err)
+	case !found:
+		return nil, &diagnosis{
+			reason:  clusterv1alpha1.ContentReasonSourceNotFound,
+			message: fmt.Sprintf("%s %s has no key %s", ref.Kind, ref.Name, ref.Key),
+		}, nil
+	case len(data) > clusterv1alpha1.MaxContentSize:
+		return nil, &diagnosis{
+			reason: clusterv1alpha1.ContentReasonTooLarge,
+			message: fmt.Sprintf("the key %s of %s %s holds %d bytes, more than the %d bytes allowed",
+				ref.Key, ref.Kind, ref.Name, len(data), clusterv1alpha1.MaxContentSize),
+		}, nil
+	}
+	return data, nil, nil
+}
+
+// syncContent Adds the content again once its source changed, pins or
+// unpins it as the spec asks, and unpins the previous versions beyond those
+// to retain. A pin is held while the cluster is degraded or busy with pins of
+// a higher priority, unless forced. The status records each step as it
+// succeeds, so that a failed step is resumed by the next reconcile, and the
+// time of the last unpin, which the garbage collection of the cluster batches
+// the unpins by. It returns the diagnosis of the Ready condition, and whether
+// the content is to be tried again later.
+func (r *ContentReconciler) syncContent(
+	ctx context.Context,
+	c *clusterv1alpha1.IpfsContent,
+) (diagnosis, bool, error) {
+	data, d, err := r.sourceData(ctx, c)
+	if err != nil || d != nil {
+		return diagnosisOrEmpty(d), false, err
+	}
+	m := &clusterv1alpha1.Ipfs{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: c.Namespace, Name: c.Spec.ClusterRef.Name}, m)
+	if errors.IsNotFound(err) {
+		return diagnosis{
+			reason:  clusterv1alpha1.ContentReasonClusterNotFound,
+			message: fmt.Sprintf("Ipfs %s does not exist", c.Spec.ClusterRef.Name),
+		}, true, nil
+	}
+	if err != nil {
+		return diagnosis{}, false, fmt.Errorf("cannot get Ipfs %s: %w", c.Spec.ClusterRef.Name, err)
+	}
+	if d = r.addContent(ctx, c, m, data); d != nil {
+		return *d, true, nil
+	}
+
+	status := &c.Status
+	pin := contentPinned(c)
+	retain := -1
+	if c.Spec.RetainPrevious != nil {
+		retain = int(*c.Spec.RetainPrevious)
+	}
+	previous := status.Previous[:0:0]
+	for _, cid := range status.Previous {
+		// A version the source went back to is the current one again.
+		if cid != status.CID {
+			previous = append(previous, cid)
+		}
+	}
+	status.Previous = previous
+	// A pin which timed out is left alone, unless it is still to be unpinned.
+	if pinTimedOut(status.PinProgress, status.CID, c.Generation) && !(c.Spec.UnpinOnTimeout && status.Pinned) {
+		return diagnosis{
+			reason:  clusterv1alpha1.ContentReasonPinTimedOut,
+			message: pinTimedOutMessage(status.PinProgress),
+		}, false, nil
+	}
+	status.PinProgress = resumePinProgress(status.PinProgress, status.CID, c.Generation, status.Pinned)
+	tracked := pin && status.Pinned && pinTracked(status.PinProgress)
+	if pin == status.Pinned && (retain < 0 || len(status.Previous) <= retain) && !tracked {
+		if retain < 0 {
+			status.Previous = nil
+		}
+		return contentReady(c), false, nil
+	}
+
+	api, err := r.Clusters.clusterAPI(ctx, m)
+	if err != nil {
+		return diagnosis{reason: clusterv1alpha1.ContentReasonPinFailed, message: err.Error()}, true, nil
+	}
+	if d, err = r.pinContent(ctx, c, m, api, pin); err != nil || d != nil {
+		return diagnosisOrEmpty(d), d != nil, err
+	}
+	if d = unpinPrevious(ctx, c, api, retain); d != nil {
+		return *d, true, nil
+	}
+	if d = followContentPin(ctx, c, api); d != nil {
+		return *d, d.reason == clusterv1alpha1.ContentReasonPinFailed, nil
+	}
+	return contentReady(c), false, nil
+}
+
+// addContent Adds the data of the source to the cluster when the content was
+// never added or its source changed since, recording the CID, the hash of
+// the source and its size. A new CID moves the pinned one to the previous
+// versions when those are retained. It returns the diagnosis of a failed add.
+func (r *ContentReconciler) addContent(
+	ctx context.Context,
+	c *clusterv1alpha1.IpfsContent,
+	m *clusterv1alpha1.Ipfs,
+	data []byte,
+) *diagnosis {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	status := &c.Status
+	if status.CID != "" && status.SourceHash == hash {
+		return nil
+	}
+	kubo, err := r.Clusters.kuboAPI(m, 0)
+	if err != nil {
+		return &diagnosis{reason: clusterv1alpha1.ContentReasonAddFailed, message: err.Error()}
+	}
+	cid, err := kubo.Add(ctx, c.Spec.SourceRef.Key, data)
+	if err != nil {
+		return &diagnosis{reason: clusterv1alpha1.ContentReasonAddFailed, message: err.Error()}
+	}
+	if cid != status.CID {
+		if status.CID != "" && status.Pinned && c.Spec.RetainPrevious != nil {
+			status.Previous = append([]string{status.CID}, status.Previous...)
+		}
+		status.CID = cid
+		status.Pinned = false
+		status.PinProgress = nil
+	}
+	status.SourceHash = hash
+	status.Size = int64(len(data))
+	return nil
+}
+
+// pinContent Pins or unpins the current version as the spec asks. A pin is
+// held while the cluster is degraded or busy with pins of a higher priority,
+// unless forced. It returns the diagnosis of a held or failed pin.
+func (r *ContentReconciler) pinContent(
+	ctx context.Context,
+	c *clusterv1alpha1.IpfsContent,
+	m *clusterv1alpha1.Ipfs,
+	api *clusterapi.Client,
+	pin bool,
+) (*diagnosis, error) {
+	status := &c.Status
+	if pin && !status.Pinned && !c.Spec.Force {
+		// The previous versions stay pinned along with the held one.
+		why, position, err := holdPin(ctx, r.Client, api, m, contentClusterPin(c))
+		if err != nil {
+			return nil, err
+		}
+		if why != "" {
+			status.Queue = pinQueueStatus(status.Queue, position)
+			return &diagnosis{reason: clusterv1alpha1.ContentReasonWaiting, message: why}, nil
+		}
+	}
+	switch {
+	case pin && !status.Pinned:
+		if _, err := api.Pin(ctx, status.CID, clusterapi.PinOptions{Name: contentPinName(c)}); err != nil {
+			return &diagnosis{reason: clusterv1alpha1.ContentReasonPinFailed, message: err.Error()}, nil
+		}
+		status.Pinned = true
+		status.PinProgress = newPinProgress(status.CID, c.Generation)
+	case !pin && status.Pinned:
+		if err := api.Unpin(ctx, status.CID); err != nil && !clusterapi.IsNotFound(err) {
+			return &diagnosis{reason: clusterv1alpha1.ContentReasonPinFailed, message: err.Error()}, nil
+		}
+		status.Pinned = false
+		status.PinProgress = nil
+		unpinned := metav1.Now()
+		status.LastUnpinTime = &unpinned
+	}
+	return nil, nil
+}
+
+// followContentPin Tracks the pin of the current version until every peer it
+// is allocated to pinned it. A pin which timed out is unpinned when the spec
+// asks for it. It returns the diagnosis of a pin still pinning, stalled or
+// which timed out.
+func followContentPin(
+	ctx context.Context,
+	c *clusterv1alpha1.IpfsContent,
+	api *clusterapi.Client,
+) *diagnosis {
+	status := &c.Status
+	if !status.Pinned || !pinTracked(status.PinProgress) {
+		return nil
+	}
+	state, message := trackPin(ctx, api, status.PinProgress, newPinLimits(c.Spec.StallAfter, c.Spec.Timeout))
+	switch state {
+	case pinStatePinning:
+		return &diagnosis{reason: clusterv1alpha1.ContentReasonPinning, message: message}
+	case pinStateStalled:
+		return &diagnosis{reason: clusterv1alpha1.ContentReasonStalled, message: message}
+	case pinStateTimedOut:
+		if c.Spec.UnpinOnTimeout {
+			if err := api.Unpin(ctx, status.CID); err != nil && !clusterapi.IsNotFound(err) {
+				return &diagnosis{reason: clusterv1alpha1.ContentReasonPinFailed,
+					message: fmt.Sprintf("%s; cannot unpin it: %s", message, err.Error())}
+			}
+			status.Pinned = false
+			unpinned := metav1.Now()
+			status.LastUnpinTime = &unpinned
+		}
+		return &diagnosis{reason: clusterv1alpha1.ContentReasonPinTimedOut, message: message}
+	}
+	return nil
+}
+
+// unpinPrevious Unpins the oldest previous versions beyond those to retain,
+// recording the time of the last unpin, or forgets them when none are
+// retained. It returns the diagnosis of a failed unpin.
+func unpinPrevious(
+	ctx context.Context,
+	c *clusterv1alpha1.IpfsContent,
+	api *clusterapi.Client,
+	retain int,
+) *diagnosis {
+	status := &c.Status
+	if retain < 0 {
+		status.Previous = nil
+	}
+	for retain >= 0 && len(status.Previous) > retain {
+		oldest := status.Previous[len(status.Previous)-1]
+		if err := api.Unpin(ctx, oldest); err != nil && !clusterapi.IsNotFound(err) {
+			return &diagnosis{reason: clusterv1alpha1.ContentReasonPinFailed,
+				message: fmt.Sprintf("cannot unpin the previous version %s: %s", oldest, err.Error())}
+		}
+		status.Previous = status.Previous[:len(status.Previous)-1]
+		unpinned := metav1.Now()
+		status.LastUnpinTime = &unpinned
+	}
+	return nil
+}
+
+// diagnosisOrEmpty Returns the diagnosis, or an empty one when it is nil.
+func diagnosisOrEmpty(d *diagnosis) diagnosis {
+	if d == nil {
+		return diagnosis{}
+	}
+	return *d
+}
+
+// contentReady Returns the diagnosis of content added, and pinned if asked to.
+func contentReady(c *clusterv1alpha1.IpfsContent) diagnosis {
+	if c.Status.Pinned {
+		return diagnosis{
+			reason:  clusterv1alpha1.ContentReasonPinned,
+			message: fmt.Sprintf("%s is pinned by Ipfs %s", c.Status.CID, c.Spec.ClusterRef.Name),
+		}
+	}
+	return diagnosis{
+		reason:  clusterv1alpha1.ContentReasonAdded,
+		message: fmt.Sprintf("%s is added to Ipfs %s without being pinned", c.Status.CID, c.Spec.ClusterRef.Name),
+	}
+}
+
+// contentsOf Returns a handler which enqueues the IpfsContent resources of
+// the namespace of the changed object of the given kind adding one of its keys.
+func (r *ContentReconciler) contentsOf(kind clusterv1alpha1.ContentSourceKind) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		contents := clusterv1alpha1.IpfsContentList{}
+		if err := r.List(context.Background(), &contents,
+			client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{contentSourceIndex: contentSourceKey(kind, obj.GetName())},
+		); err != nil {
+			ctrllog.Log.Error(err, "cannot list IpfsContent resources referencing object",
+				"kind", kind, "name", obj.GetName(), "namespace", obj.GetNamespace())
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(contents.Items))
+		for _, c := range contents.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: c.Namespace, Name: c.Name},
+			})
+		}
+		return requests
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ContentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &clusterv1alpha1.IpfsContent{},
+		pinClusterIndex, func(obj client.Object) []string {
+			return []string{obj.(*clusterv1alpha1.IpfsContent).Spec.ClusterRef.Name}
+		}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &clusterv1alpha1.IpfsContent{},
+		contentSourceIndex, func(obj client.Object) []string {
+			ref := obj.(*clusterv1alpha1.IpfsContent).Spec.SourceRef
+			return []string{contentSourceKey(ref.Kind, ref.Name)}
+		}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1alpha1.IpfsContent{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, r.contentsOf(clusterv1alpha1.ContentSourceConfigMap)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, r.contentsOf(clusterv1alpha1.ContentSourceSecret)).
+		Watches(&source.Kind{Type: &clusterv1alpha1.Ipfs{}}, heldPinsOf(r.Client, heldPinContent)).
+		Complete(r)
+}
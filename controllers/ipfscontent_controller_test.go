@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("IpfsContent controller", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *ContentReconciler
+		cluster    *clusterfake.Cluster
+		node       *kubofake.Node
+		source     *corev1.ConfigMap
+		content    *clusterv1alpha1.IpfsContent
+	)
+
+	// reconcile Reconciles the content and returns it as stored.
+	reconcile := func() (*clusterv1alpha1.IpfsContent, ctrl.Result) {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(content)})
+		Expect(err).NotTo(HaveOccurred())
+		stored := &clusterv1alpha1.IpfsContent{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), stored)).To(Succeed())
+		return stored, result
+	}
+
+	// ready Returns the Ready condition of the content.
+	ready := func(c *clusterv1alpha1.IpfsContent) *metav1.Condition {
+		return meta.FindStatusCondition(c.Status.Conditions, clusterv1alpha1.ConditionReady)
+	}
+
+	// pinned Returns whether the cluster pins the CID.
+	pinned := func(cid string) bool {
+		_, err := clusterapi.New(cluster.URL()).Status(ctx, cid)
+		if clusterapi.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	// update Replaces the data of the source.
+	update := func(data string) {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(source), source)).To(Succeed())
+		source.Data["metadata.json"] = data
+		Expect(fakeClient.Update(ctx, source)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = "store"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		source = &corev1.ConfigMap{}
+		source.Name = "release"
+		source.Namespace = instance.Namespace
+		source.Data = map[string]string{"metadata.json": `{"version": "1.0.0"}`}
+		retain := int32(1)
+		content = &clusterv1alpha1.IpfsContent{}
+		content.Name = "release"
+		content.Namespace = instance.Namespace
+		content.Spec = clusterv1alpha1.IpfsContentSpec{
+			SourceRef: clusterv1alpha1.ContentSourceRef{
+				Kind: clusterv1alpha1.ContentSourceConfigMap,
+				Name: source.Name,
+				Key:  "metadata.json",
+			},
+			ClusterRef:     clusterv1alpha1.ContentClusterRef{Name: instance.Name},
+			RetainPrevious: &retain,
+		}
+
+		cluster = clusterfake.NewCluster(clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)})
+		dialer := kubofake.NewDialer()
+		node = kubofake.NewNode("12D3KooWA")
+		dialer.Add(instance.Namespace, peerPodName(instance, 0), node)
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(instance, credentials, source, content).Build()
+		reconciler = &ContentReconciler{
+			Client: fakeClient,
+			Clusters: &IpfsReconciler{
+				Client: fakeClient,
+				Scheme: scheme,
+				Kubo:   dialer,
+				ClusterAPI: []clusterapi.Option{
+					clusterapi.WithBaseURL(cluster.URL()),
+					clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("pins the content and its retained previous versions", func() {
+		first, result := reconcile()
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(first.Status.CID).NotTo(BeEmpty())
+		Expect(first.Status.Pinned).To(BeTrue())
+		Expect(first.Status.Size).To(Equal(int64(len(source.Data["metadata.json"]))))
+		Expect(ready(first).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(pinned(first.Status.CID)).To(BeTrue())
+
+		By("adding nothing again while the source is unchanged")
+		again, _ := reconcile()
+		Expect(again.Status.CID).To(Equal(first.Status.CID))
+		Expect(node.Calls["add"]).To(Equal(1))
+
+		By("re-adding and re-pinning the content once the source changes")
+		update(`{"version": "1.1.0"}`)
+		second, _ := reconcile()
+		Expect(second.Status.CID).NotTo(Equal(first.Status.CID))
+		Expect(second.Status.Previous).To(Equal([]string{first.Status.CID}))
+		Expect(pinned(second.Status.CID)).To(BeTrue())
+		Expect(pinned(first.Status.CID)).To(BeTrue())
+
+		By("unpinning the versions beyond those to retain")
+		update(`{"version": "1.2.0"}`)
+		third, _ := reconcile()
+		Expect(third.Status.Previous).To(Equal([]string{second.Status.CID}))
+		Expect(pinned(first.Status.CID)).To(BeFalse())
+		Expect(pinned(second.Status.CID)).To(BeTrue())
+
+		By("getting the same CID back once the source reverts")
+		update(`{"version": "1.1.0"}`)
+		reverted, _ := reconcile()
+		Expect(reverted.Status.CID).To(Equal(second.Status.CID))
+		Expect(reverted.Status.Previous).To(Equal([]string{third.Status.CID}))
+		Expect(pinned(second.Status.CID)).To(BeTrue())
+	})
+
+	It("adds binary data byte for byte", func() {
+		data := []byte{0xff, 0x00, 0xfe, '\n'}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(source), source)).To(Succeed())
+		source.Data = nil
+		source.BinaryData = map[string][]byte{"metadata.json": data}
+		Expect(fakeClient.Update(ctx, source)).To(Succeed())
+		fromConfigMap, _ := reconcile()
+		Expect(bytes.Equal(node.Blocks[fromConfigMap.Status.CID], data)).To(BeTrue())
+
+		By("adding the same bytes from a Secret under the same CID")
+		sec := &corev1.Secret{}
+		sec.Name = "release-key"
+		sec.Namespace = content.Namespace
+		sec.Data = map[string][]byte{"metadata.json": data}
+		Expect(fakeClient.Create(ctx, sec)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), content)).To(Succeed())
+		content.Spec.SourceRef = clusterv1alpha1.ContentSourceRef{
+			Kind: clusterv1alpha1.ContentSourceSecret,
+			Name: sec.Name,
+			Key:  "metadata.json",
+		}
+		Expect(fakeClient.Update(ctx, content)).To(Succeed())
+		fromSecret, _ := reconcile()
+		Expect(fromSecret.Status.CID).To(Equal(fromConfigMap.Status.CID))
+	})
+
+	It("tracks the pin until every peer pinned it, recovering it once when it stalls", func() {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), content)).To(Succeed())
+		content.Spec.Pin = new(bool)
+		Expect(fakeClient.Update(ctx, content)).To(Succeed())
+		added, _ := reconcile()
+		cid := added.Status.CID
+		cluster.FailPinning(cid, 2)
+		added.Spec.Pin = nil
+		Expect(fakeClient.Update(ctx, added)).To(Succeed())
+		pinning, result := reconcile()
+		Expect(ready(pinning).Reason).To(Equal(clusterv1alpha1.ContentReasonPinning))
+		Expect(ready(pinning).Message).To(ContainSubstring("1 failed"))
+		Expect(result.RequeueAfter).To(Equal(pinProgressInterval))
+		Expect(pinning.Status.PinProgress.CID).To(Equal(cid))
+		Expect(pinning.Status.PinProgress.Failed).To(Equal(int32(1)))
+
+		By("recovering the pin once it went without progress for too long")
+		// age Moves the last progress of the pin back past the stall limit.
+		age := func(c *clusterv1alpha1.IpfsContent) {
+			c.Status.PinProgress.LastProgressTime = metav1.NewTime(
+				c.Status.PinProgress.LastProgressTime.Add(-defaultPinStallAfter - time.Minute))
+			Expect(fakeClient.Status().Update(ctx, c)).To(Succeed())
+		}
+		age(pinning)
+		stalled, result := reconcile()
+		Expect(ready(stalled).Reason).To(Equal(clusterv1alpha1.ContentReasonStalled))
+		Expect(result.RequeueAfter).To(Equal(pinProgressInterval))
+		Expect(stalled.Status.PinProgress.RecoveredAt).NotTo(BeNil())
+		Expect(cluster.Calls(clusterfake.RouteRecover)).To(Equal(1))
+
+		By("recovering a stalled pin only once")
+		age(stalled)
+		still, _ := reconcile()
+		Expect(ready(still).Reason).To(Equal(clusterv1alpha1.ContentReasonStalled))
+		Expect(cluster.Calls(clusterfake.RouteRecover)).To(Equal(1))
+
+		By("completing the pin once every peer pinned it")
+		_, err := clusterapi.New(cluster.URL()).Recover(ctx, cid)
+		Expect(err).NotTo(HaveOccurred())
+		done, result := reconcile()
+		Expect(ready(done).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(done.Status.PinProgress.CompletedAt).NotTo(BeNil())
+		Expect(done.Status.PinProgress.Percent).To(Equal(int32(100)))
+	})
+
+	It("times the pin out, unpinning it when asked to, until the spec changes", func() {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), content)).To(Succeed())
+		content.Spec.Pin = new(bool)
+		content.Spec.Timeout = &metav1.Duration{Duration: time.Hour}
+		content.Spec.UnpinOnTimeout = true
+		Expect(fakeClient.Update(ctx, content)).To(Succeed())
+		added, _ := reconcile()
+		cid := added.Status.CID
+		cluster.FailPinning(cid, 1)
+		added.Spec.Pin = nil
+		Expect(fakeClient.Update(ctx, added)).To(Succeed())
+		pinning, _ := reconcile()
+		Expect(ready(pinning).Reason).To(Equal(clusterv1alpha1.ContentReasonPinning))
+		pinning.Status.PinProgress.StartedAt = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		Expect(fakeClient.Status().Update(ctx, pinning)).To(Succeed())
+		timedOut, result := reconcile()
+		Expect(ready(timedOut).Reason).To(Equal(clusterv1alpha1.ContentReasonPinTimedOut))
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(timedOut.Status.Pinned).To(BeFalse())
+		Expect(timedOut.Status.PinProgress.TimedOutAt).NotTo(BeNil())
+		Expect(pinned(cid)).To(BeFalse())
+
+		By("leaving the pin alone while the spec is unchanged")
+		pins := cluster.Calls(clusterfake.RoutePin)
+		again, _ := reconcile()
+		Expect(ready(again).Reason).To(Equal(clusterv1alpha1.ContentReasonPinTimedOut))
+		Expect(cluster.Calls(clusterfake.RoutePin)).To(Equal(pins))
+
+		By("submitting the pin again once the spec changes")
+		again.Spec.Timeout = nil
+		again.Generation++
+		Expect(fakeClient.Update(ctx, again)).To(Succeed())
+		resubmitted, _ := reconcile()
+		Expect(ready(resubmitted).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(cluster.Calls(clusterfake.RoutePin)).To(Equal(pins + 1))
+		Expect(pinned(cid)).To(BeTrue())
+	})
+
+	It("reports the content it cannot add", func() {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), content)).To(Succeed())
+		content.Spec.SourceRef.Key = "missing.json"
+		Expect(fakeClient.Update(ctx, content)).To(Succeed())
+		missing, result := reconcile()
+		Expect(ready(missing).Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready(missing).Reason).To(Equal(clusterv1alpha1.ContentReasonSourceNotFound))
+		Expect(result.RequeueAfter).To(BeZero())
+
+		By("refusing content larger than a ConfigMap holds")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(source), source)).To(Succeed())
+		source.BinaryData = map[string][]byte{"missing.json": make([]byte, clusterv1alpha1.MaxContentSize+1)}
+		Expect(fakeClient.Update(ctx, source)).To(Succeed())
+		tooLarge, _ := reconcile()
+		Expect(ready(tooLarge).Reason).To(Equal(clusterv1alpha1.ContentReasonTooLarge))
+		Expect(node.Calls["add"]).To(BeZero())
+
+		By("trying again later while the cluster does not exist")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), content)).To(Succeed())
+		content.Spec.SourceRef.Key = "metadata.json"
+		content.Spec.ClusterRef.Name = "absent"
+		Expect(fakeClient.Update(ctx, content)).To(Succeed())
+		absent, result := reconcile()
+		Expect(ready(absent).Reason).To(Equal(clusterv1alpha1.ContentReasonClusterNotFound))
+		Expect(result.RequeueAfter).To(Equal(contentRetryInterval))
+	})
+})
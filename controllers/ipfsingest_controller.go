@@ -0,0 +1,664 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// ingestMountPath Is where the directory to add is mounted in the Job.
+	ingestMountPath = "/ingest"
+	// ingestMFSRoot Is the MFS directory of the first peer holding the
+	// checkpoints of every IpfsIngest, under its UID.
+	ingestMFSRoot = "/ipfs-operator/ingest"
+	// ingestGenerationAnnotation Records on the Job the generation of the
+	// spec it adds.
+	ingestGenerationAnnotation = "ipfs.cluster.io/ingest-generation"
+	// ingestPollInterval Is how often the progress of a run is checked.
+	ingestPollInterval = 15 * time.Second
+	// ingestBackoffLimit Is how many times a failed pod of the Job is
+	// replaced, each resuming from the checkpoints of the previous one.
+	ingestBackoffLimit = int32(3)
+)
+
+// ingestDirectory Adds the top-level entries of the directory one at a time
+// and checkpoints each in the MFS of the node, under the directory of the
+// run, along with the fingerprint of the files it was added from. A pod
+// resuming the run skips the entries whose fingerprint is unchanged, and
+// entries unchanged since the last completed run are copied from it rather
+// than added again. The root is the MFS directory of the entries, which the
+// run replaces the last completed one with, and is reported, along with the
+// size and the number of the files, as the termination message.
+const ingestDirectory = `
+set -e
+ipfs() { command ipfs --api="$INGEST_API" "$@"; }
+run="$INGEST_BASE/runs/$INGEST_RUN"
+current="$INGEST_BASE/current"
+for dir in "$current/entries" "$current/fingerprints" "$run/entries" "$run/fingerprints"; do
+	ipfs files mkdir -p "$dir"
+done
+# The runs of previous generations of the spec are abandoned.
+ipfs files ls "$INGEST_BASE/runs" | while IFS= read -r old; do
+	[ "$old" = "$INGEST_RUN" ] || ipfs files rm -r "$INGEST_BASE/runs/$old"
+done
+cd ` + ingestMountPath + `
+ls -A > /tmp/manifest
+ipfs files write --create --truncate "$run/manifest" < /tmp/manifest
+# fingerprint Digests the options and the names, sizes and modification
+# times of the files of an entry.
+fingerprint() {
+	{ echo "$INGEST_OPTIONS"; find "./$1" -exec stat -c '%n %s %Y' {} +; } | sha256sum | cut -d' ' -f1
+}
+# checkpoint Prints the fingerprint an entry was added with by a run, if any.
+checkpoint() {
+	ipfs files read "$1/fingerprints/$2" 2>/dev/null || true
+}
+# The checkpoints of entries removed since are dropped.
+for dir in entries fingerprints; do
+	ipfs files ls "$run/$dir" | while IFS= read -r entry; do
+		grep -qxF -- "$entry" /tmp/manifest || ipfs files rm -r "$run/$dir/$entry"
+	done
+done
+while IFS= read -r entry; do
+	fp=$(fingerprint "$entry")
+	if [ "$(checkpoint "$run" "$entry")" = "$fp" ]; then
+		continue
+	fi
+	ipfs files rm -r "$run/entries/$entry" 2>/dev/null || true
+	if [ "$(checkpoint "$current" "$entry")" = "$fp" ]; then
+		ipfs files cp "$current/entries/$entry" "$run/entries/$entry"
+	else
+		cid=$(ipfs add -r -Q --pin=false $INGEST_OPTIONS -- "./$entry")
+		ipfs files cp "/ipfs/$cid" "$run/entries/$entry"
+	fi
+	printf %s "$fp" | ipfs files write --create --truncate "$run/fingerprints/$entry"
+	echo "added $entry"
+done < /tmp/manifest
+root=$(ipfs files stat --hash "$run/entries")
+if [ -n "$INGEST_WRAP" ]; then
+	ipfs files rm -r "$run/wrapped" 2>/dev/null || true
+	ipfs files mkdir "$run/wrapped"
+	ipfs files cp "/ipfs/$root" "$run/wrapped/$INGEST_WRAP"
+	root=$(ipfs files stat --hash "$run/wrapped")
+fi
+files=$(find . -type f | wc -l)
+bytes=$(find . -type f -exec stat -c %s {} + | awk '{ s += $1 } END { printf "%.0f", s }')
+ipfs files rm -r "$current"
+ipfs files mv "$run" "$current"
+echo "cid=$root bytes=$bytes files=$files" > /dev/termination-log
+`
+
+// IngestReconciler reconciles the IpfsIngest object, adding a directory of
+// a PersistentVolumeClaim to a cluster through a Job and pinning its root.
+type IngestReconciler struct {
+	client.Client
+	// Clusters reaches the APIs of the clusters the directories are added
+	// to, through the breakers and the connection pool of the Ipfs
+	// controller.
+	Clusters *IpfsReconciler
+}
+
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfsingests,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfsingests/status,verbs=get;update;patch
+
+func (r *IngestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ingest := &clusterv1alpha1.IpfsIngest{}
+	if err := r.Get(ctx, req.NamespacedName, ingest); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("cannot get IpfsIngest: %w", err)
+	}
+	observed := ingest.Status.DeepCopy()
+
+	d, requeue, err := r.syncIngest(ctx, ingest)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	ready := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             d.reason,
+		Message:            d.message,
+		ObservedGeneration: ingest.Generation,
+	}
+	if d.reason == clusterv1alpha1.IngestReasonPinned {
+		ready.Status = metav1.ConditionTrue
+	}
+	if d.reason != clusterv1alpha1.IngestReasonWaiting {
+		ingest.Status.Queue = nil
+	}
+	meta.SetStatusCondition(&ingest.Status.Conditions, ready)
+
+	result := ctrl.Result{RequeueAfter: requeue}
+	if equality.Semantic.DeepEqual(observed, &ingest.Status) {
+		return result, nil
+	}
+	if err = r.Status().Update(ctx, ingest); err != nil {
+		return ctrl.Result{}, fmt.Errorf("cannot update IpfsIngest status: %w", err)
+	}
+	return result, nil
+}
+
+// ingestJobName Returns the name of the Job adding the directory.
+func ingestJobName(ing *clusterv1alpha1.IpfsIngest) string {
+	return ing.Name + "-ingest"
+}
+
+// ingestPinName Returns the name the root is pinned with in the cluster,
+// which tells the IpfsIngest it comes from.
+func ingestPinName(ing *clusterv1alpha1.IpfsIngest) string {
+	return ingestClusterPin(ing).pinName(ing.Namespace)
+}
+
+// ingestBase Returns the MFS directory of the checkpoints of the IpfsIngest.
+func ingestBase(ing *clusterv1alpha1.IpfsIngest) string {
+	return ingestMFSRoot + "/" + string(ing.UID)
+}
+
+// ingestRunPath Returns the MFS directory of the run adding the given
+// generation of the spec.
+func ingestRunPath(ing *clusterv1alpha1.IpfsIngest, generation int64) string {
+	return ingestBase(ing) + "/runs/" + strconv.FormatInt(generation, 10)
+}
+
+// ingestOptions Returns the options of ipfs add the spec sets. The others
+// are left to the config of kubo.
+func ingestOptions(ing *clusterv1alpha1.IpfsIngest) string {
+	var options []string
+	if ing.Spec.Chunker != "" {
+		options = append(options, "--chunker="+ing.Spec.Chunker)
+	}
+	if ing.Spec.RawLeaves != nil {
+		options = append(options, "--raw-leaves="+strconv.FormatBool(*ing.Spec.RawLeaves))
+	}
+	return strings.Join(options, " ")
+}
+
+// ingestWrapName Returns the name the directory is wrapped under, or an
+// empty string when it is not wrapped.
+func ingestWrapName(ing *clusterv1alpha1.IpfsIngest) string {
+	if !ing.Spec.WrapWithDirectory {
+		return ""
+	}
+	if ing.Spec.Source.SubPath != "" {
+		return path.Base(ing.Spec.Source.SubPath)
+	}
+	return ing.Spec.Source.ClaimName
+}
+
+// ingestRequested Returns whether the run is to be started again.
+func ingestRequested(ing *clusterv1alpha1.IpfsIngest) bool {
+	return ing.Annotations[clusterv1alpha1.AnnotationReingest] == "true"
+}
+
+// ingestJob Returns the Job adding the directory of the claim to the kubo
+// node of the first peer of the cluster, through its RPC API. The claim is
+// mounted read-only, with the kubo image of the cluster.
+func (r *IngestReconciler) ingestJob(ing *clusterv1alpha1.IpfsIngest, m *clusterv1alpha1.Ipfs) (*batchv1.Job, error) {
+	backoffLimit := ingestBackoffLimit
+	apiAddr := fmt.Sprintf("/dns4/%s.%s.%s.svc/tcp/%d", peerPodName(m, 0), peerServiceName(m), m.Namespace, portAPI)
+	labels := ipfsLabels(m, componentIngest)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingestJobName(ing),
+			Namespace: ing.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				ingestGenerationAnnotation: strconv.FormatInt(ing.Generation, 10),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:            "ingest",
+							Image:           m.Spec.IpfsImage,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Command:         []string{"sh", "-c", ingestDirectory},
+							Env: []corev1.EnvVar{
+								{Name: "INGEST_API", Value: apiAddr},
+								{Name: "INGEST_BASE", Value: ingestBase(ing)},
+								{Name: "INGEST_RUN", Value: strconv.FormatInt(ing.Generation, 10)},
+								{Name: "INGEST_OPTIONS", Value: ingestOptions(ing)},
+								{Name: "INGEST_WRAP", Value: ingestWrapName(ing)},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "source",
+									MountPath: ingestMountPath,
+									SubPath:   ing.Spec.Source.SubPath,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "source",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+									ClaimName: ing.Spec.Source.ClaimName,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(ing, job, r.Clusters.Scheme); err != nil {
+		return nil, fmt.Errorf("cannot set controller reference for ingest job: %w", err)
+	}
+	return job, nil
+}
+
+// syncIngest Runs the Job adding the directory whenever the spec changed or
+// a run is requested, reports its progress while it runs, and pins the root
+// it reports once it succeeds, unpinning the previous one. A root unchanged
+// since the last run is left as pinned. It returns the diagnosis of the
+// Ready condition, and how long to wait before checking on the run again.
+func (r *IngestReconciler) syncIngest(
+	ctx context.Context,
+	ing *clusterv1alpha1.IpfsIngest,
+) (diagnosis, time.Duration, error) {
+	m := &clusterv1alpha1.Ipfs{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: ing.Namespace, Name: ing.Spec.ClusterRef.Name}, m)
+	if errors.IsNotFound(err) {
+		return diagnosis{
+			reason:  clusterv1alpha1.IngestReasonClusterNotFound,
+			message: fmt.Sprintf("Ipfs %s does not exist", ing.Spec.ClusterRef.Name),
+		}, contentRetryInterval, nil
+	}
+	if err != nil {
+		return diagnosis{}, 0, fmt.Errorf("cannot get Ipfs %s: %w", ing.Spec.ClusterRef.Name, err)
+	}
+
+	job := &batchv1.Job{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: ing.Namespace, Name: ingestJobName(ing)}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return diagnosis{}, 0, fmt.Errorf("cannot get ingest job: %w", err)
+	}
+	found := err == nil
+	if found && job.Annotations[ingestGenerationAnnotation] != strconv.FormatInt(ing.Generation, 10) {
+		// The spec changed since the Job started.
+		return ingestInProgress(ing), ingestPollInterval, r.deleteIngestJob(ctx, ing)
+	}
+
+	switch {
+	case !found:
+		if ing.Status.ObservedGeneration == ing.Generation && !ingestRequested(ing) {
+			if ing.Status.Pinned {
+				return r.followIngestPin(ctx, ing, m)
+			}
+			if pinTimedOut(ing.Status.PinProgress, ing.Status.CID, ing.Generation) {
+				// The root was unpinned once its pin timed out.
+				return diagnosis{
+					reason:  clusterv1alpha1.IngestReasonPinTimedOut,
+					message: pinTimedOutMessage(ing.Status.PinProgress),
+				}, 0, nil
+			}
+		}
+		return r.startIngest(ctx, ing, m)
+	case job.Status.Succeeded > 0:
+		return r.finishIngest(ctx, ing, m, job)
+	case ingestJobFailed(job) != nil:
+		if ingestRequested(ing) {
+			// The next Job resumes from the checkpoints of this one.
+			return ingestInProgress(ing), ingestPollInterval, r.deleteIngestJob(ctx, ing)
+		}
+		return diagnosis{
+			reason: clusterv1alpha1.IngestReasonFailed,
+			message: fmt.Sprintf("the ingest job failed: %s; set the %s annotation to resume it",
+				ingestJobFailed(job).Message, clusterv1alpha1.AnnotationReingest),
+		}, 0, nil
+	}
+	if ing.Status.Progress != nil {
+		r.refreshIngestProgress(ctx, ing, m, ingestRunPath(ing, ing.Status.Progress.Generation))
+	}
+	return ingestInProgress(ing), ingestPollInterval, nil
+}
+
+// startIngest Creates the Job adding the directory once the claim exists.
+func (r *IngestReconciler) startIngest(
+	ctx context.Context,
+	ing *clusterv1alpha1.IpfsIngest,
+	m *clusterv1alpha1.Ipfs,
+) (diagnosis, time.Duration, error) {
+	claim := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: ing.Namespace, Name: ing.Spec.Source.ClaimName}, claim)
+	if errors.IsNotFound(err) {
+		return diagnosis{
+			reason:  clusterv1alpha1.IngestReasonSourceNotFound,
+			message: fmt.Sprintf("PersistentVolumeClaim %s does not exist", ing.Spec.Source.ClaimName),
+		}, contentRetryInterval, nil
+	}
+	if err != nil {
+		return diagnosis{}, 0, fmt.Errorf("cannot get PersistentVolumeClaim %s: %w", ing.Spec.Source.ClaimName, err)
+	}
+	job, err := r.ingestJob(ing, m)
+	if err != nil {
+		return diagnosis{}, 0, err
+	}
+	if err = r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		return diagnosis{}, 0, fmt.Errorf("cannot create ingest job: %w", err)
+	}
+	// A Job resuming a run reports the checkpoints of the previous ones
+	// again.
+	ing.Status.Progress = &clusterv1alpha1.IngestProgress{Generation: ing.Generation, StartTime: metav1.Now()}
+	return ingestInProgress(ing), ingestPollInterval, nil
+}
+
+// finishIngest Pins the root the Job reported, unless it is already pinned,
+// records the directory and deletes the Job. The pin is held while the
+// cluster is degraded or busy with pins of a higher priority, unless forced.
+func (r *IngestReconciler) finishIngest(
+	ctx context.Context,
+	ing *clusterv1alpha1.IpfsIngest,
+	m *clusterv1alpha1.Ipfs,
+	job *batchv1.Job,
+) (diagnosis, time.Duration, error) {
+	var cid string
+	var bytes, files int64
+	message := ingestJobResult(ctx, r.Client, job)
+	if _, err := fmt.Sscanf(message, "cid=%s bytes=%d files=%d", &cid, &bytes, &files); err != nil {
+		return diagnosis{
+			reason:  clusterv1alpha1.IngestReasonFailed,
+			message: fmt.Sprintf("the ingest job reported %q rather than the root of the directory", message),
+		}, 0, nil
+	}
+	status := &ing.Status
+	if cid == status.CID && status.Pinned {
+		ctrllog.FromContext(ctx).Info("the root of the directory is unchanged since the previous run", "cid", cid)
+		if p := status.PinProgress; p != nil && p.TimedOutAt != nil {
+			// The pin is tracked again along with the run.
+			status.PinProgress = newPinProgress(cid, ing.Generation)
+		}
+	} else {
+		api, err := r.Clusters.clusterAPI(ctx, m)
+		if err != nil {
+			return diagnosis{reason: clusterv1alpha1.IngestReasonPinFailed, message: err.Error()}, contentRetryInterval, nil
+		}
+		if !ing.Spec.Force {
+			// The Job is kept until the root is pinned, as it reports the root.
+			why, position, err := holdPin(ctx, r.Client, api, m, ingestClusterPin(ing))
+			if err != nil {
+				return diagnosis{}, 0, err
+			}
+			if why != "" {
+				status.Queue = pinQueueStatus(status.Queue, position)
+				return diagnosis{reason: clusterv1alpha1.IngestReasonWaiting, message: why}, pinHoldInterval, nil
+			}
+		}
+		if d := pinIngestRoot(ctx, ing, api, cid); d != nil {
+			return *d, contentRetryInterval, nil
+		}
+	}
+	// The run replaced the last completed one.
+	r.refreshIngestProgress(ctx, ing, m, ingestBase(ing)+"/current")
+	status.TotalBytes = bytes
+	status.Files = files
+	completed := metav1.Now()
+	status.CompletionTime = &completed
+	status.ObservedGeneration = ing.Generation
+	if err := r.deleteIngestJob(ctx, ing); err != nil {
+		return diagnosis{}, 0, err
+	}
+	if ingestRequested(ing) {
+		updated := ing.DeepCopy()
+		delete(updated.Annotations, clusterv1alpha1.AnnotationReingest)
+		if err := r.Patch(ctx, updated, client.MergeFrom(ing)); err != nil {
+			return diagnosis{}, 0, fmt.Errorf("cannot remove %s annotation: %w", clusterv1alpha1.AnnotationReingest, err)
+		}
+		ing.Annotations = updated.Annotations
+		ing.ResourceVersion = updated.ResourceVersion
+	}
+	return r.followIngestPin(ctx, ing, m)
+}
+
+// pinIngestRoot Pins the root through the cluster, then unpins the previous
+// one. It returns the diagnosis of the pin or the unpin which failed, if any.
+func pinIngestRoot(
+	ctx context.Context,
+	ing *clusterv1alpha1.IpfsIngest,
+	api *clusterapi.Client,
+	cid string,
+) *diagnosis {
+	status := &ing.Status
+	if _, err := api.Pin(ctx, cid, clusterapi.PinOptions{Name: ingestPinName(ing)}); err != nil {
+		return &diagnosis{reason: clusterv1alpha1.IngestReasonPinFailed, message: err.Error()}
+	}
+	if previous := status.CID; previous != "" && previous != cid && status.Pinned {
+		if err := api.Unpin(ctx, previous); err != nil && !clusterapi.IsNotFound(err) {
+			return &diagnosis{
+				reason:  clusterv1alpha1.IngestReasonPinFailed,
+				message: fmt.Sprintf("cannot unpin the previous root %s: %s", previous, err.Error()),
+			}
+		}
+		unpinned := metav1.Now()
+		status.LastUnpinTime = &unpinned
+	}
+	status.CID = cid
+	status.Pinned = true
+	status.PinProgress = newPinProgress(cid, ing.Generation)
+	return nil
+}
+
+// followIngestPin Tracks the pin of the root until every peer it is
+// allocated to pinned it. A pin which timed out is unpinned when the spec
+// asks for it. It returns the diagnosis of the Ready condition, and how long
+// to wait before checking on the pin again.
+func (r *IngestReconciler) followIngestPin(
+	ctx context.Context,
+	ing *clusterv1alpha1.IpfsIngest,
+	m *clusterv1alpha1.Ipfs,
+) (diagnosis, time.Duration, error) {
+	status := &ing.Status
+	if !pinTracked(status.PinProgress) {
+		return ingestReady(ing), 0, nil
+	}
+	api, err := r.Clusters.clusterAPI(ctx, m)
+	if err != nil {
+		return diagnosis{reason: clusterv1alpha1.IngestReasonPinFailed, message: err.Error()}, contentRetryInterval, nil
+	}
+	state, message := trackPin(ctx, api, status.PinProgress, newPinLimits(ing.Spec.StallAfter, ing.Spec.Timeout))
+	switch state {
+	case pinStatePinning:
+		return diagnosis{reason: clusterv1alpha1.IngestReasonPinning, message: message}, pinProgressInterval, nil
+	case pinStateStalled:
+		return diagnosis{reason: clusterv1alpha1.IngestReasonStalled, message: message}, pinProgressInterval, nil
+	case pinStateTimedOut:
+		if ing.Spec.UnpinOnTimeout {
+			if err = api.Unpin(ctx, status.CID); err != nil && !clusterapi.IsNotFound(err) {
+				return diagnosis{reason: clusterv1alpha1.IngestReasonPinFailed,
+					message: fmt.Sprintf("%s; cannot unpin it: %s", message, err.Error())}, contentRetryInterval, nil
+			}
+			status.Pinned = false
+			unpinned := metav1.Now()
+			status.LastUnpinTime = &unpinned
+		}
+		return diagnosis{reason: clusterv1alpha1.IngestReasonPinTimedOut, message: message}, 0, nil
+	}
+	return ingestReady(ing), 0, nil
+}
+
+// refreshIngestProgress Records the top-level entries the run checkpointed
+// so far in the given MFS directory of the node the Job adds them to. The
+// progress is left as is when the node cannot be reached.
+func (r *IngestReconciler) refreshIngestProgress(
+	ctx context.Context,
+	ing *clusterv1alpha1.IpfsIngest,
+	m *clusterv1alpha1.Ipfs,
+	run string,
+) {
+	progress := ing.Status.Progress
+	if progress == nil {
+		return
+	}
+	kubo, err := r.Clusters.kuboAPI(m, 0)
+	if err != nil {
+		return
+	}
+	log := ctrllog.FromContext(ctx)
+	if progress.Entries == 0 {
+		if total, err := ingestManifestEntries(ctx, kubo, run); err == nil {
+			progress.Entries = total
+		} else {
+			log.V(1).Info("cannot read the manifest of the ingest", "error", err.Error())
+		}
+	}
+	entries, err := kubo.FilesLs(ctx, run+"/entries")
+	if err != nil {
+		log.V(1).Info("cannot list the checkpoints of the ingest", "error", err.Error())
+		return
+	}
+	progress.Completed = int32(len(entries))
+	progress.Checkpoints = nil
+	for _, e := range entries {
+		if len(progress.Checkpoints) == clusterv1alpha1.MaxIngestCheckpoints {
+			break
+		}
+		progress.Checkpoints = append(progress.Checkpoints, clusterv1alpha1.IngestCheckpoint{Name: e.Name, CID: e.Hash})
+	}
+}
+
+// ingestManifestEntries Returns the number of top-level entries the Job
+// listed in the manifest of the run, one per line.
+func ingestManifestEntries(ctx context.Context, kubo kuboapi.API, run string) (int32, error) {
+	files, err := kubo.FilesLs(ctx, run)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if f.Name != "manifest" {
+			continue
+		}
+		data, err := kubo.Cat(ctx, f.Hash)
+		if err != nil {
+			return 0, err
+		}
+		return int32(strings.Count(string(data), "\n")), nil
+	}
+	return 0, fmt.Errorf("%s has no manifest yet", run)
+}
+
+// ingestJobFailed Returns the Failed condition of the Job once it gave up
+// replacing its failed pods, or nil.
+func ingestJobFailed(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		cond := &job.Status.Conditions[i]
+		if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+			return cond
+		}
+	}
+	return nil
+}
+
+// ingestJobResult Returns the termination message of the pod of the Job
+// which succeeded, rather than of those which failed before it.
+func ingestJobResult(ctx context.Context, c client.Client, job *batchv1.Job) string {
+	pods := corev1.PodList{}
+	if err := c.List(ctx, &pods, client.InNamespace(job.Namespace),
+		client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return ""
+	}
+	for i := range pods.Items {
+		for _, cs := range pods.Items[i].Status.ContainerStatuses {
+			if cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0 {
+				return cs.State.Terminated.Message
+			}
+		}
+	}
+	return ""
+}
+
+// deleteIngestJob Deletes the Job adding the directory, if any, along with
+// its pods.
+func (r *IngestReconciler) deleteIngestJob(ctx context.Context, ing *clusterv1alpha1.IpfsIngest) error {
+	job := batchv1.Job{}
+	job.Name = ingestJobName(ing)
+	job.Namespace = ing.Namespace
+	if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+		!errors.IsNotFound(err) {
+		return fmt.Errorf("cannot delete ingest job: %w", err)
+	}
+	return nil
+}
+
+// ingestInProgress Returns the diagnosis of a run in progress.
+func ingestInProgress(ing *clusterv1alpha1.IpfsIngest) diagnosis {
+	message := fmt.Sprintf("adding %s", ing.Spec.Source.ClaimName)
+	if p := ing.Status.Progress; p != nil && p.Entries > 0 {
+		message = fmt.Sprintf("added %d of the %d top-level entries of %s", p.Completed, p.Entries,
+			ing.Spec.Source.ClaimName)
+	}
+	return diagnosis{reason: clusterv1alpha1.IngestReasonIngesting, message: message}
+}
+
+// ingestReady Returns the diagnosis of a directory added and pinned.
+func ingestReady(ing *clusterv1alpha1.IpfsIngest) diagnosis {
+	return diagnosis{
+		reason:  clusterv1alpha1.IngestReasonPinned,
+		message: fmt.Sprintf("%s is pinned by Ipfs %s", ing.Status.CID, ing.Spec.ClusterRef.Name),
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IngestReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &clusterv1alpha1.IpfsIngest{},
+		pinClusterIndex, func(obj client.Object) []string {
+			return []string{obj.(*clusterv1alpha1.IpfsIngest).Spec.ClusterRef.Name}
+		}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1alpha1.IpfsIngest{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &clusterv1alpha1.Ipfs{}}, heldPinsOf(r.Client, heldPinIngest)).
+		Complete(r)
+}
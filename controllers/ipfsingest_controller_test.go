@@ -0,0 +1,353 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("IpfsIngest controller", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IngestReconciler
+		cluster    *clusterfake.Cluster
+		node       *kubofake.Node
+		ingest     *clusterv1alpha1.IpfsIngest
+	)
+
+	// reconcile Reconciles the ingest and returns it as stored.
+	reconcile := func() (*clusterv1alpha1.IpfsIngest, ctrl.Result) {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(ingest)})
+		Expect(err).NotTo(HaveOccurred())
+		stored := &clusterv1alpha1.IpfsIngest{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ingest), stored)).To(Succeed())
+		return stored, result
+	}
+
+	// ready Returns the Ready condition of the ingest.
+	ready := func(ing *clusterv1alpha1.IpfsIngest) *metav1.Condition {
+		return meta.FindStatusCondition(ing.Status.Conditions, clusterv1alpha1.ConditionReady)
+	}
+
+	// job Returns the ingest Job, which must exist.
+	job := func() *batchv1.Job {
+		j := &batchv1.Job{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ingest.Namespace, Name: ingestJobName(ingest)},
+			j)).To(Succeed())
+		return j
+	}
+
+	// finishJob Completes the Job, as its pod would, with the given result.
+	// The pods of the previous Jobs are gone, as the garbage collector
+	// deletes them along with their Job.
+	finishJob := func(result string) {
+		j := job()
+		j.Status.Succeeded = 1
+		Expect(fakeClient.Status().Update(ctx, j)).To(Succeed())
+		Expect(fakeClient.DeleteAllOf(ctx, &corev1.Pod{}, client.InNamespace(j.Namespace),
+			client.MatchingLabels{"job-name": j.Name})).To(Succeed())
+		pod := &corev1.Pod{}
+		pod.Name = j.Name + "-pod"
+		pod.Namespace = j.Namespace
+		pod.Labels = map[string]string{"job-name": j.Name}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name: "ingest",
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Message: result},
+			},
+		}}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	// pinned Returns whether the cluster pins the CID.
+	pinned := func(cid string) bool {
+		_, err := clusterapi.New(cluster.URL()).Status(ctx, cid)
+		if clusterapi.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = "store"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.29.0"
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		claim := &corev1.PersistentVolumeClaim{}
+		claim.Name = "datasets"
+		claim.Namespace = instance.Namespace
+		rawLeaves := true
+		ingest = &clusterv1alpha1.IpfsIngest{}
+		ingest.Name = "images"
+		ingest.Namespace = instance.Namespace
+		ingest.UID = "0b5e3a4c"
+		ingest.Generation = 1
+		ingest.Spec = clusterv1alpha1.IpfsIngestSpec{
+			Source:            clusterv1alpha1.IngestSource{ClaimName: claim.Name, SubPath: "training/images"},
+			ClusterRef:        clusterv1alpha1.ContentClusterRef{Name: instance.Name},
+			WrapWithDirectory: true,
+			Chunker:           "size-1048576",
+			RawLeaves:         &rawLeaves,
+		}
+
+		cluster = clusterfake.NewCluster(clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)})
+		dialer := kubofake.NewDialer()
+		node = kubofake.NewNode("12D3KooWA")
+		node.Files = map[string][]kuboapi.FilesEntry{}
+		dialer.Add(instance.Namespace, peerPodName(instance, 0), node)
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(instance, credentials, claim, ingest).Build()
+		reconciler = &IngestReconciler{
+			Client: fakeClient,
+			Clusters: &IpfsReconciler{
+				Client: fakeClient,
+				Scheme: scheme,
+				Kubo:   dialer,
+				ClusterAPI: []clusterapi.Option{
+					clusterapi.WithBaseURL(cluster.URL()),
+					clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("adds the directory through a Job and pins its root", func() {
+		started, result := reconcile()
+		Expect(ready(started).Reason).To(Equal(clusterv1alpha1.IngestReasonIngesting))
+		Expect(result.RequeueAfter).To(Equal(ingestPollInterval))
+		Expect(started.Status.Progress.Generation).To(Equal(int64(1)))
+		pod := job().Spec.Template.Spec
+		Expect(pod.Containers[0].Image).To(Equal("ipfs/kubo:v0.29.0"))
+		Expect(pod.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("datasets"))
+		Expect(pod.Volumes[0].PersistentVolumeClaim.ReadOnly).To(BeTrue())
+		Expect(pod.Containers[0].VolumeMounts[0].SubPath).To(Equal("training/images"))
+		Expect(pod.Containers[0].VolumeMounts[0].ReadOnly).To(BeTrue())
+		Expect(pod.Containers[0].Env).To(ContainElements(
+			corev1.EnvVar{Name: "INGEST_API", Value: "/dns4/ipfs-cluster-store-0.ipfs-cluster-store.default.svc/tcp/5001"},
+			corev1.EnvVar{Name: "INGEST_OPTIONS", Value: "--chunker=size-1048576 --raw-leaves=true"},
+			corev1.EnvVar{Name: "INGEST_WRAP", Value: "images"},
+		))
+
+		By("reporting the top-level entries checkpointed so far")
+		run := ingestRunPath(ingest, 1)
+		manifest, err := node.Add(ctx, "manifest", []byte("cats\ndogs\nREADME.md\n"))
+		Expect(err).NotTo(HaveOccurred())
+		node.Files[run] = []kuboapi.FilesEntry{{Name: "manifest", Hash: manifest}}
+		node.Files[run+"/entries"] = []kuboapi.FilesEntry{{Name: "cats", Type: 1, Hash: "bafycats"}}
+		running, _ := reconcile()
+		Expect(running.Status.Progress.Entries).To(Equal(int32(3)))
+		Expect(running.Status.Progress.Completed).To(Equal(int32(1)))
+		Expect(running.Status.Progress.Checkpoints).To(Equal([]clusterv1alpha1.IngestCheckpoint{
+			{Name: "cats", CID: "bafycats"},
+		}))
+		Expect(ready(running).Message).To(ContainSubstring("added 1 of the 3 top-level entries"))
+
+		By("pinning the root the Job reports")
+		node.Files[ingestBase(ingest)+"/current/entries"] = []kuboapi.FilesEntry{
+			{Name: "README.md", Hash: "bafyreadme"}, {Name: "cats", Type: 1, Hash: "bafycats"},
+			{Name: "dogs", Type: 1, Hash: "bafydogs"},
+		}
+		finishJob("cid=bafyroot bytes=1048576 files=42")
+		done, result := reconcile()
+		Expect(ready(done).Status).To(Equal(metav1.ConditionTrue))
+		Expect(done.Status.CID).To(Equal("bafyroot"))
+		Expect(done.Status.TotalBytes).To(Equal(int64(1048576)))
+		Expect(done.Status.Files).To(Equal(int64(42)))
+		Expect(done.Status.Progress.Completed).To(Equal(int32(3)))
+		Expect(done.Status.ObservedGeneration).To(Equal(int64(1)))
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(pinned("bafyroot")).To(BeTrue())
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ingest.Namespace, Name: ingestJobName(ingest)},
+			&batchv1.Job{})).NotTo(Succeed())
+
+		By("leaving the directory alone until a run is requested")
+		again, _ := reconcile()
+		Expect(again.Status.CID).To(Equal("bafyroot"))
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ingest.Namespace, Name: ingestJobName(ingest)},
+			&batchv1.Job{})).NotTo(Succeed())
+	})
+
+	It("detects a run yielding the same root and replaces a changed one", func() {
+		reconcile()
+		finishJob("cid=bafyroot bytes=10 files=1")
+		reconcile()
+		pins := cluster.Calls(clusterfake.RoutePin)
+
+		By("pinning nothing again when the content is unchanged")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ingest), ingest)).To(Succeed())
+		ingest.Annotations = map[string]string{clusterv1alpha1.AnnotationReingest: "true"}
+		Expect(fakeClient.Update(ctx, ingest)).To(Succeed())
+		reconcile()
+		finishJob("cid=bafyroot bytes=10 files=1")
+		unchanged, _ := reconcile()
+		Expect(unchanged.Status.CID).To(Equal("bafyroot"))
+		Expect(unchanged.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationReingest))
+		Expect(cluster.Calls(clusterfake.RoutePin)).To(Equal(pins))
+
+		By("unpinning the previous root once the spec yields another")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ingest), ingest)).To(Succeed())
+		ingest.Spec.Chunker = "buzhash"
+		ingest.Generation = 2
+		Expect(fakeClient.Update(ctx, ingest)).To(Succeed())
+		restarted, _ := reconcile()
+		Expect(restarted.Status.Progress.Generation).To(Equal(int64(2)))
+		Expect(job().Annotations).To(HaveKeyWithValue(ingestGenerationAnnotation, "2"))
+		finishJob("cid=bafyrechunked bytes=10 files=1")
+		changed, _ := reconcile()
+		Expect(changed.Status.CID).To(Equal("bafyrechunked"))
+		Expect(changed.Status.LastUnpinTime).NotTo(BeNil())
+		Expect(pinned("bafyrechunked")).To(BeTrue())
+		Expect(pinned("bafyroot")).To(BeFalse())
+	})
+
+	It("tracks the pin of the root until it completes, stalls or times out", func() {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ingest), ingest)).To(Succeed())
+		ingest.Spec.Timeout = &metav1.Duration{Duration: time.Hour}
+		ingest.Spec.UnpinOnTimeout = true
+		Expect(fakeClient.Update(ctx, ingest)).To(Succeed())
+		cluster.FailPinning("bafyroot", 1)
+		reconcile()
+		finishJob("cid=bafyroot bytes=10 files=1")
+		pinning, result := reconcile()
+		Expect(ready(pinning).Reason).To(Equal(clusterv1alpha1.IngestReasonPinning))
+		Expect(ready(pinning).Message).To(ContainSubstring("1 failed"))
+		Expect(result.RequeueAfter).To(Equal(pinProgressInterval))
+		Expect(pinning.Status.PinProgress.CID).To(Equal("bafyroot"))
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ingest.Namespace, Name: ingestJobName(ingest)},
+			&batchv1.Job{})).NotTo(Succeed())
+
+		By("recovering the pin once it went without progress for too long")
+		pinning.Status.PinProgress.LastProgressTime = metav1.NewTime(time.Now().Add(-defaultPinStallAfter - time.Minute))
+		Expect(fakeClient.Status().Update(ctx, pinning)).To(Succeed())
+		stalled, result := reconcile()
+		Expect(ready(stalled).Reason).To(Equal(clusterv1alpha1.IngestReasonStalled))
+		Expect(result.RequeueAfter).To(Equal(pinProgressInterval))
+		Expect(cluster.Calls(clusterfake.RouteRecover)).To(Equal(1))
+		done, result := reconcile()
+		Expect(ready(done).Reason).To(Equal(clusterv1alpha1.IngestReasonPinned))
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(done.Status.PinProgress.CompletedAt).NotTo(BeNil())
+
+		By("unpinning the root once its pin timed out")
+		done.Spec.Chunker = "buzhash"
+		done.Generation = 2
+		Expect(fakeClient.Update(ctx, done)).To(Succeed())
+		cluster.FailPinning("bafyrechunked", 1)
+		reconcile()
+		finishJob("cid=bafyrechunked bytes=10 files=1")
+		rechunked, _ := reconcile()
+		Expect(ready(rechunked).Reason).To(Equal(clusterv1alpha1.IngestReasonPinning))
+		rechunked.Status.PinProgress.StartedAt = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		Expect(fakeClient.Status().Update(ctx, rechunked)).To(Succeed())
+		timedOut, result := reconcile()
+		Expect(ready(timedOut).Reason).To(Equal(clusterv1alpha1.IngestReasonPinTimedOut))
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(timedOut.Status.Pinned).To(BeFalse())
+		Expect(pinned("bafyrechunked")).To(BeFalse())
+
+		By("running the Job again only once the spec changes")
+		again, _ := reconcile()
+		Expect(ready(again).Reason).To(Equal(clusterv1alpha1.IngestReasonPinTimedOut))
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ingest.Namespace, Name: ingestJobName(ingest)},
+			&batchv1.Job{})).NotTo(Succeed())
+	})
+
+	It("reports the failures of the Job until it is resumed", func() {
+		reconcile()
+		j := job()
+		j.Status.Failed = ingestBackoffLimit + 1
+		j.Status.Conditions = []batchv1.JobCondition{{
+			Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "Job has reached the specified backoff limit",
+		}}
+		Expect(fakeClient.Status().Update(ctx, j)).To(Succeed())
+		failed, result := reconcile()
+		Expect(ready(failed).Reason).To(Equal(clusterv1alpha1.IngestReasonFailed))
+		Expect(ready(failed).Message).To(ContainSubstring("backoff limit"))
+		Expect(result.RequeueAfter).To(BeZero())
+
+		By("replacing the Job once the run is requested again")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ingest), ingest)).To(Succeed())
+		ingest.Annotations = map[string]string{clusterv1alpha1.AnnotationReingest: "true"}
+		Expect(fakeClient.Update(ctx, ingest)).To(Succeed())
+		reconcile()
+		resumed, _ := reconcile()
+		Expect(ready(resumed).Reason).To(Equal(clusterv1alpha1.IngestReasonIngesting))
+		Expect(job().Status.Failed).To(BeZero())
+
+		By("waiting for the claim to exist")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ingest), ingest)).To(Succeed())
+		ingest.Spec.Source.ClaimName = "absent"
+		ingest.Generation = 2
+		Expect(fakeClient.Update(ctx, ingest)).To(Succeed())
+		reconcile()
+		absent, result := reconcile()
+		Expect(ready(absent).Reason).To(Equal(clusterv1alpha1.IngestReasonSourceNotFound))
+		Expect(result.RequeueAfter).To(Equal(contentRetryInterval))
+	})
+
+	It("holds the pin of the root while the cluster is degraded", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: ingest.Namespace, Name: "store"}, instance)).To(Succeed())
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type: clusterv1alpha1.ConditionDegraded, Status: metav1.ConditionTrue,
+			Reason: clusterv1alpha1.DegradedReasonSmokeTestFailed, Message: "the Fetch stage failed",
+		})
+		Expect(fakeClient.Status().Update(ctx, instance)).To(Succeed())
+
+		reconcile()
+		finishJob("cid=bafyroot bytes=1024 files=2")
+		held, result := reconcile()
+		Expect(ready(held).Reason).To(Equal(clusterv1alpha1.IngestReasonWaiting))
+		Expect(ready(held).Message).To(ContainSubstring("the Fetch stage failed"))
+		Expect(result.RequeueAfter).To(Equal(pinHoldInterval))
+		Expect(pinned("bafyroot")).To(BeFalse())
+		Expect(job().Status.Succeeded).To(Equal(int32(1)))
+
+		By("pinning the root once the cluster recovered")
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type: clusterv1alpha1.ConditionDegraded, Status: metav1.ConditionFalse,
+			Reason: clusterv1alpha1.DegradedReasonSmokeTestPassed,
+		})
+		Expect(fakeClient.Status().Update(ctx, instance)).To(Succeed())
+		done, _ := reconcile()
+		Expect(ready(done).Reason).To(Equal(clusterv1alpha1.IngestReasonPinned))
+		Expect(pinned("bafyroot")).To(BeTrue())
+	})
+})
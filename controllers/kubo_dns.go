@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// dnsResolvers Returns the DNS over HTTPS resolvers of the kubo nodes, by
+// domain.
+func dnsResolvers(m *clusterv1alpha1.Ipfs) map[string]string {
+	if m.Spec.DNS == nil || len(m.Spec.DNS.Resolvers) == 0 {
+		return map[string]string{}
+	}
+	return m.Spec.DNS.Resolvers
+}
+
+// dnslinkDisabled Returns whether the gateway refuses DNSLink names. Clusters
+// without outbound connectivity cannot resolve public names, so DNSLink is
+// off for them unless the spec says otherwise.
+func dnslinkDisabled(m *clusterv1alpha1.Ipfs) bool {
+	if m.Spec.DNS != nil && m.Spec.DNS.DisableDNSLink != nil {
+		return *m.Spec.DNS.DisableDNSLink
+	}
+	return m.Spec.Preflight != nil && m.Spec.Preflight.SkipOutbound
+}
+
+// dnsCommands Returns the commands of the configure script applying the DNS
+// settings, at every start of the peers. Both settings are always written,
+// so that unsetting them in the spec restores the defaults of kubo.
+func dnsCommands(m *clusterv1alpha1.Ipfs) (string, error) {
+	resolvers, err := json.Marshal(dnsResolvers(m))
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize the DNS resolvers: %w", err)
+	}
+	return fmt.Sprintf("\tipfs config --json DNS.Resolvers '%s'\n\tipfs config --json Gateway.NoDNSLink %t\n",
+		resolvers, dnslinkDisabled(m)), nil
+}
+
+// dnsSettings Returns the DNS settings of the cluster which differ from the
+// defaults of kubo, for the config hash rolling the peers when they change.
+func dnsSettings(m *clusterv1alpha1.Ipfs) []string {
+	resolvers := dnsResolvers(m)
+	settings := make([]string, 0, len(resolvers)+1)
+	for domain, resolver := range resolvers {
+		settings = append(settings, fmt.Sprintf("dns/resolver/%s=%s", domain, resolver))
+	}
+	sort.Strings(settings)
+	if dnslinkDisabled(m) {
+		settings = append(settings, "dns/nodnslink=true")
+	}
+	return settings
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs kubo DNS", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "resolvers"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("renders the resolvers and the DNSLink setting", func() {
+		disabled := true
+		instance.Spec.DNS = &clusterv1alpha1.KuboDNSConfig{
+			Resolvers: map[string]string{
+				".":             "https://doh.internal.example/dns-query",
+				"corp.example.": "https://resolver.corp.example/dns-query",
+			},
+			DisableDNSLink: &disabled,
+		}
+		rendered, err := dnsCommands(instance)
+		Expect(err).NotTo(HaveOccurred())
+		expectGolden("kubo-dns", rendered)
+	})
+
+	It("turns DNSLink off for clusters without outbound connectivity", func() {
+		Expect(dnslinkDisabled(instance)).To(BeFalse())
+		instance.Spec.Preflight = &clusterv1alpha1.PreflightConfig{SkipOutbound: true}
+		Expect(dnslinkDisabled(instance)).To(BeTrue())
+		enabled := false
+		instance.Spec.DNS = &clusterv1alpha1.KuboDNSConfig{DisableDNSLink: &enabled}
+		Expect(dnslinkDisabled(instance)).To(BeFalse())
+	})
+
+	It("rolls the peers when the settings change", func() {
+		before, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(before).To(BeEmpty())
+
+		instance.Spec.DNS = &clusterv1alpha1.KuboDNSConfig{
+			Resolvers: map[string]string{".": "https://doh.internal.example/dns-query"},
+		}
+		after, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).NotTo(Equal(before))
+
+		instance.Spec.DNS.Resolvers["."] = "https://doh.other.example/dns-query"
+		changed, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).NotTo(Equal(after))
+	})
+
+	It("exports the resolved settings", func() {
+		key := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		instance.Spec.Preflight = &clusterv1alpha1.PreflightConfig{SkipOutbound: true}
+		instance.Spec.Debug = &clusterv1alpha1.DebugConfig{ExportResolvedConfig: true}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		for i := 0; i < 2; i++ {
+			_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "resolvers-resolved"},
+			cm)).To(Succeed())
+		Expect(cm.Data[resolvedKuboKey]).To(ContainSubstring("ipfs config --json Gateway.NoDNSLink true"))
+	})
+})
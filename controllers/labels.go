@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Recommended labels of the objects the operator manages.
+// See https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	labelName      = "app.kubernetes.io/name"
+	labelInstance  = "app.kubernetes.io/instance"
+	labelVersion   = "app.kubernetes.io/version"
+	labelComponent = "app.kubernetes.io/component"
+	labelPartOf    = "app.kubernetes.io/part-of"
+	labelManagedBy = "app.kubernetes.io/managed-by"
+)
+
+// Components of a cluster, as set in the component label.
+const (
+	componentPeer        = "peer"
+	componentGateway     = "gateway"
+	componentRelay       = "relay"
+	componentCtl         = "ctl"
+	componentAudit       = "audit"
+	componentMonitoring  = "monitoring"
+	componentDebug       = "debug"
+	componentFederation  = "federation"
+	componentReadiness   = "readiness"
+	componentSwarm       = "swarm"
+	componentDiagnostics = "diagnostics"
+	componentBootstrap   = "bootstrap"
+	componentDirectory   = "directory"
+	componentCompaction  = "compaction"
+	componentRolloutHook = "rollout-hook"
+	componentProvider    = "provider"
+	componentIngest      = "ingest"
+)
+
+const (
+	partOf    = "ipfs-cluster"
+	managedBy = "ipfs-operator"
+	// relayImage Is the image of the circuit relay daemons.
+	relayImage = "coryschwartz/libp2p-relay-daemon:latest"
+)
+
+// ipfsLabels Returns the labels of the objects of the given component of the
+// cluster. The name label keeps the value the selectors of the peers match
+// on. The version is the tag of the image running the component.
+func ipfsLabels(m *clusterv1alpha1.Ipfs, component string) map[string]string {
+	image := m.Spec.ClusterImage
+	if component == componentGateway {
+		image = m.Spec.IpfsImage
+	}
+	return recommendedLabels("ipfs-cluster-"+m.Name, m.Name, component, image, string(m.UID))
+}
+
+// peerSelector Returns the labels selecting the peer pods of the cluster,
+// which the selector of its StatefulSet matches on.
+func peerSelector(m *clusterv1alpha1.Ipfs) map[string]string {
+	return map[string]string{labelName: "ipfs-cluster-" + m.Name}
+}
+
+// peerPodSelector Returns the labels selecting the peer pod with the given ordinal.
+func peerPodSelector(m *clusterv1alpha1.Ipfs, ordinal int32) map[string]string {
+	selector := peerSelector(m)
+	selector[appsv1.StatefulSetPodNameLabel] = peerPodName(m, ordinal)
+	return selector
+}
+
+// relayLabels Returns the labels of the objects of a circuit relay, which
+// belong to the cluster owning the relay, if any.
+func relayLabels(m *clusterv1alpha1.CircuitRelay) map[string]string {
+	instance, owner := m.Name, string(m.UID)
+	if ref := metav1.GetControllerOf(m); ref != nil && ref.Kind == "Ipfs" {
+		instance, owner = ref.Name, string(ref.UID)
+	}
+	return recommendedLabels("libp2p-relay-daemon-"+m.Name, instance, componentRelay, relayImage, owner)
+}
+
+// recommendedLabels Returns the recommended labels along with the UID of the
+// Ipfs resource owning the object, which survives the owner references being
+// stripped, such as by backup tools restoring the objects.
+func recommendedLabels(name, instance, component, image, ownerUID string) map[string]string {
+	labels := map[string]string{
+		labelName:                     name,
+		labelInstance:                 instance,
+		labelComponent:                component,
+		labelPartOf:                   partOf,
+		labelManagedBy:                managedBy,
+		clusterv1alpha1.LabelOwnerUID: ownerUID,
+	}
+	if tag := imageTag(image); tag != "" {
+		labels[labelVersion] = tag
+	}
+	return labels
+}
+
+// imageTag Returns the tag of the image, or an empty string when the image
+// has no tag or its tag is not a valid label value.
+func imageTag(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	tag := image[i+1:]
+	if len(validation.IsValidLabelValue(tag)) > 0 {
+		return ""
+	}
+	return tag
+}
+
+// mergeLabels Sets the given labels on the object, keeping its other labels.
+func mergeLabels(obj metav1.Object, labels map[string]string) {
+	merged := obj.GetLabels()
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	obj.SetLabels(merged)
+}
+
+// labeled Returns a mutate function setting the given labels on the object
+// once the given mutate function ran.
+func labeled(obj metav1.Object, labels map[string]string, mut controllerutil.MutateFn) controllerutil.MutateFn {
+	if mut == nil {
+		return nil
+	}
+	return func() error {
+		if err := mut(); err != nil {
+			return err
+		}
+		mergeLabels(obj, labels)
+		return nil
+	}
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs object labels", func() {
+	var instance *clusterv1alpha1.Ipfs
+
+	BeforeEach(func() {
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "labels"
+		instance.Namespace = "default"
+		instance.UID = types.UID("6f1c3e0a-5b7d-4d2e-9a4f-0c8e2b1d7f35")
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = ipfsImage
+		instance.Spec.ClusterImage = ipfsClusterImage
+	})
+
+	It("reads the version from the image tag", func() {
+		Expect(imageTag("ipfs/ipfs-cluster:v1.0.1")).To(Equal("v1.0.1"))
+		Expect(imageTag("registry.example.com:5000/ipfs/kubo")).To(BeEmpty())
+		Expect(imageTag("registry.example.com:5000/ipfs/kubo:v0.14.0")).To(Equal("v0.14.0"))
+		Expect(imageTag("ipfs/kubo:v0.14.0@sha256:0123456789abcdef")).To(Equal("v0.14.0"))
+		Expect(imageTag("ipfs/kubo@sha256:0123456789abcdef")).To(BeEmpty())
+	})
+
+	It("labels the peers without changing the selector", func() {
+		sts := &appsv1.StatefulSet{}
+		scheme := runtime.NewScheme()
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler := &IpfsReconciler{Scheme: scheme}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-labels", "ipfs-cluster-labels",
+			"ipfs-cluster-labels", "ipfs-cluster-scripts-labels", "")()).To(Succeed())
+		Expect(sts.Spec.Selector.MatchLabels).To(Equal(map[string]string{
+			"app.kubernetes.io/name": "ipfs-cluster-labels",
+		}))
+		Expect(sts.Spec.Template.Labels).To(Equal(map[string]string{
+			"app.kubernetes.io/name":       "ipfs-cluster-labels",
+			"app.kubernetes.io/instance":   "labels",
+			"app.kubernetes.io/version":    "v1.0.1",
+			"app.kubernetes.io/component":  "peer",
+			"app.kubernetes.io/part-of":    "ipfs-cluster",
+			"app.kubernetes.io/managed-by": "ipfs-operator",
+			"ipfs.cluster.io/owner-uid":    "6f1c3e0a-5b7d-4d2e-9a4f-0c8e2b1d7f35",
+		}))
+		for _, claim := range sts.Spec.VolumeClaimTemplates {
+			Expect(claim.Labels).To(BeEmpty())
+		}
+		Expect(peerSelector(instance)).To(Equal(sts.Spec.Selector.MatchLabels))
+		Expect(peerPodSelector(instance, 0)).To(Equal(map[string]string{
+			"app.kubernetes.io/name":             "ipfs-cluster-labels",
+			"statefulset.kubernetes.io/pod-name": "ipfs-cluster-labels-0",
+		}))
+
+		By("keeping the labels set by others")
+		sts.Labels = map[string]string{"velero.io/backup-name": "nightly"}
+		Expect(labeled(sts, ipfsLabels(instance, componentPeer), func() error { return nil })()).To(Succeed())
+		Expect(sts.Labels).To(HaveKeyWithValue("velero.io/backup-name", "nightly"))
+		Expect(sts.Labels).To(HaveKeyWithValue("app.kubernetes.io/component", "peer"))
+	})
+
+	It("labels the gateway with the version of the ipfs image", func() {
+		labels := ipfsLabels(instance, componentGateway)
+		Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/component", "gateway"))
+		Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/version", imageTag(ipfsImage)))
+	})
+
+	It("labels the relays with the cluster owning them", func() {
+		relay := &clusterv1alpha1.CircuitRelay{}
+		relay.Name = "labels-0"
+		relay.UID = types.UID("relay-uid")
+		controller := true
+		relay.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: clusterv1alpha1.GroupVersion.String(),
+			Kind:       "Ipfs",
+			Name:       instance.Name,
+			UID:        instance.UID,
+			Controller: &controller,
+		}}
+		labels := relayLabels(relay)
+		Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/name", "libp2p-relay-daemon-labels-0"))
+		Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/instance", "labels"))
+		Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/component", "relay"))
+		Expect(labels).To(HaveKeyWithValue("app.kubernetes.io/version", "latest"))
+		Expect(labels).To(HaveKeyWithValue("ipfs.cluster.io/owner-uid", string(instance.UID)))
+	})
+})
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// logVolumeName Is the volume holding the logs of the daemons in file mode.
+	logVolumeName = "logs"
+	// logMountPath Is where the daemons write their logs in file mode.
+	logMountPath = "/var/log/ipfs"
+	// logRotateInterval Is how often, in seconds, the sidecar checks the size
+	// of the logs.
+	logRotateInterval = 60
+)
+
+// Defaults of the scratch space of the daemons, sized so that the temporary
+// files of a busy peer fit while a node low on disk can still evict it.
+var (
+	defaultEphemeralStorageRequest = resource.MustParse("256Mi")
+	defaultEphemeralStorageLimit   = resource.MustParse("2Gi")
+	defaultLogSizeLimit            = resource.MustParse("256Mi")
+)
+
+// rotateLogs Copies each log over its backup once it outgrows LOG_MAX_BYTES,
+// then truncates it. The daemons append to their log, so they keep writing to
+// it without being restarted.
+const rotateLogs = `
+while true; do
+	for log in ` + logMountPath + `/*.log; do
+		[ -f "${log}" ] || continue
+		if [ "$(wc -c < "${log}")" -gt "${LOG_MAX_BYTES}" ]; then
+			cp "${log}" "${log}.1"
+			: > "${log}"
+		fi
+	done
+	sleep ${LOG_ROTATE_INTERVAL}
+done
+`
+
+// loggingMode Returns the logging mode of the daemons, stdout by default.
+func loggingMode(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.Logging == nil || m.Spec.Logging.Mode == "" {
+		return clusterv1alpha1.LoggingModeStdout
+	}
+	return m.Spec.Logging.Mode
+}
+
+// logSizeLimit Returns the size of the volume holding the logs in file mode.
+func logSizeLimit(m *clusterv1alpha1.Ipfs) resource.Quantity {
+	if m.Spec.Logging == nil || m.Spec.Logging.SizeLimit == nil {
+		return defaultLogSizeLimit
+	}
+	return *m.Spec.Logging.SizeLimit
+}
+
+// logEnv Returns the environment making the daemon log to the container logs,
+// or to the given file of the log volume in file mode. Both kubo and
+// ipfs-cluster read where to log from the environment.
+func logEnv(m *clusterv1alpha1.Ipfs, file string) []corev1.EnvVar {
+	if loggingMode(m) != clusterv1alpha1.LoggingModeFile {
+		return []corev1.EnvVar{{Name: "GOLOG_OUTPUT", Value: "stdout"}}
+	}
+	return []corev1.EnvVar{
+		{Name: "GOLOG_OUTPUT", Value: "file"},
+		{Name: "GOLOG_FILE", Value: logMountPath + "/" + file},
+	}
+}
+
+// logVolume Returns the volume holding the logs of the daemons in file mode,
+// limited in size so that the logs cannot fill the disk of the node.
+func logVolume(m *clusterv1alpha1.Ipfs) corev1.Volume {
+	sizeLimit := logSizeLimit(m)
+	return corev1.Volume{
+		Name: logVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: &sizeLimit},
+		},
+	}
+}
+
+// logRotateContainer Returns the sidecar rotating the logs of the daemons in
+// file mode. Each daemon keeps a log and its backup, so that a log is rotated
+// once it fills a quarter of the volume, which keeps the volume from
+// overflowing and the pod from being evicted.
+func logRotateContainer(m *clusterv1alpha1.Ipfs) corev1.Container {
+	sizeLimit := logSizeLimit(m)
+	return corev1.Container{
+		Name:            "log-rotate",
+		Image:           m.Spec.IpfsImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh", "-c", rotateLogs},
+		Env: []corev1.EnvVar{
+			{Name: "LOG_MAX_BYTES", Value: fmt.Sprint(sizeLimit.Value() / 4)},
+			{Name: "LOG_ROTATE_INTERVAL", Value: fmt.Sprint(logRotateInterval)},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: logVolumeName, MountPath: logMountPath},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("16Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("32Mi"),
+			},
+		},
+	}
+}
+
+// withEphemeralStorage Returns a copy of the resources of a daemon container
+// with the ephemeral storage of the spec, unless the resources already set it.
+func withEphemeralStorage(m *clusterv1alpha1.Ipfs, resources corev1.ResourceRequirements) corev1.ResourceRequirements {
+	request, limit := defaultEphemeralStorageRequest, defaultEphemeralStorageLimit
+	if ephemeral := m.Spec.Resources.EphemeralStorage; ephemeral != nil {
+		if ephemeral.Request != nil {
+			request = *ephemeral.Request
+		}
+		if ephemeral.Limit != nil {
+			limit = *ephemeral.Limit
+		}
+	}
+	sized := *resources.DeepCopy()
+	if sized.Requests == nil {
+		sized.Requests = corev1.ResourceList{}
+	}
+	if sized.Limits == nil {
+		sized.Limits = corev1.ResourceList{}
+	}
+	if _, ok := sized.Requests[corev1.ResourceEphemeralStorage]; !ok {
+		sized.Requests[corev1.ResourceEphemeralStorage] = request
+	}
+	if _, ok := sized.Limits[corev1.ResourceEphemeralStorage]; !ok {
+		sized.Limits[corev1.ResourceEphemeralStorage] = limit
+	}
+	return sized
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs logging and scratch space", func() {
+	var instance *clusterv1alpha1.Ipfs
+
+	render := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		scheme := runtime.NewScheme()
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler := &IpfsReconciler{Scheme: scheme}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-logs", "ipfs-cluster-logs",
+			"ipfs-cluster-logs", "ipfs-cluster-scripts-logs", "")()).To(Succeed())
+		return sts
+	}
+
+	BeforeEach(func() {
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "logs"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = ipfsImage
+		instance.Spec.ClusterImage = ipfsClusterImage
+	})
+
+	It("logs to stdout with explicit ephemeral storage by default", func() {
+		sts := render()
+		containers := sts.Spec.Template.Spec.Containers
+		Expect(containers).To(HaveLen(2))
+		for _, c := range containers {
+			Expect(c.Env).To(ContainElement(corev1.EnvVar{Name: "GOLOG_OUTPUT", Value: "stdout"}))
+			Expect(c.Resources.Requests.StorageEphemeral().String()).To(Equal("256Mi"))
+			Expect(c.Resources.Limits.StorageEphemeral().String()).To(Equal("2Gi"))
+			for _, mount := range c.VolumeMounts {
+				Expect(mount.Name).NotTo(Equal(logVolumeName))
+			}
+		}
+		for _, v := range sts.Spec.Template.Spec.Volumes {
+			Expect(v.Name).NotTo(Equal(logVolumeName))
+		}
+	})
+
+	It("sizes the ephemeral storage from the spec", func() {
+		request, limit := resource.MustParse("1Gi"), resource.MustParse("8Gi")
+		instance.Spec.Resources.EphemeralStorage = &clusterv1alpha1.EphemeralStorageConfig{
+			Request: &request,
+			Limit:   &limit,
+		}
+		instance.Spec.Resources.Limits = corev1.ResourceList{
+			corev1.ResourceEphemeralStorage: resource.MustParse("16Gi"),
+		}
+		containers := render().Spec.Template.Spec.Containers
+		Expect(containers[0].Resources.Requests.StorageEphemeral().String()).To(Equal("1Gi"))
+		Expect(containers[0].Resources.Limits.StorageEphemeral().String()).To(Equal("16Gi"))
+		Expect(containers[1].Resources.Limits.StorageEphemeral().String()).To(Equal("8Gi"))
+		Expect(instance.Spec.Resources.Limits).To(HaveLen(1))
+	})
+
+	It("writes the logs to a size-limited volume rotated by a sidecar in file mode", func() {
+		sizeLimit := resource.MustParse("128Mi")
+		instance.Spec.Logging = &clusterv1alpha1.LoggingConfig{
+			Mode:      clusterv1alpha1.LoggingModeFile,
+			SizeLimit: &sizeLimit,
+		}
+		sts := render()
+		containers := sts.Spec.Template.Spec.Containers
+		Expect(containers).To(HaveLen(3))
+		logMount := corev1.VolumeMount{Name: logVolumeName, MountPath: "/var/log/ipfs"}
+		Expect(containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "GOLOG_FILE", Value: "/var/log/ipfs/ipfs.log"}))
+		Expect(containers[0].VolumeMounts).To(ContainElement(logMount))
+		Expect(containers[1].Env).To(ContainElement(
+			corev1.EnvVar{Name: "GOLOG_FILE", Value: "/var/log/ipfs/ipfs-cluster.log"}))
+		Expect(containers[1].VolumeMounts).To(ContainElement(logMount))
+
+		sidecar := containers[2]
+		Expect(sidecar.Name).To(Equal("log-rotate"))
+		Expect(sidecar.VolumeMounts).To(ConsistOf(logMount))
+		Expect(sidecar.Env).To(ContainElement(corev1.EnvVar{Name: "LOG_MAX_BYTES", Value: "33554432"}))
+
+		var volume *corev1.Volume
+		for i := range sts.Spec.Template.Spec.Volumes {
+			if sts.Spec.Template.Spec.Volumes[i].Name == logVolumeName {
+				volume = &sts.Spec.Template.Spec.Volumes[i]
+			}
+		}
+		Expect(volume).NotTo(BeNil())
+		Expect(volume.EmptyDir.SizeLimit.String()).To(Equal("128Mi"))
+	})
+})
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Disruptive operations gated by the maintenance window.
+const (
+	operationRollout          = "rollout of the peers"
+	operationSecretRotation   = "rotation of the cluster secret"
+	operationIdentityRotation = "rotation of a peer identity"
+	operationCompaction       = "compaction of the CRDT state"
+)
+
+// maintenanceGate Tells whether the disruptive operations of a cluster may
+// start during a reconcile, and records those which wait for the window.
+type maintenanceGate struct {
+	// configured Is whether the cluster has a maintenance window.
+	configured bool
+	// open Is whether the window is open, or the cluster has none.
+	open bool
+	// at Is when the window closes if it is open, or opens otherwise.
+	at time.Time
+	// skip Is whether the skip annotation lets the next operation start
+	// outside the window.
+	skip bool
+	// skipped Is whether an operation started thanks to the annotation.
+	skipped bool
+	// waiting Lists the operations waiting for the window.
+	waiting []string
+}
+
+// maintenanceGateOf Returns the gate of the cluster at the given time. A
+// window which cannot be evaluated, which the webhook refuses, keeps every
+// operation waiting.
+func maintenanceGateOf(instance *clusterv1alpha1.Ipfs, now time.Time) *maintenanceGate {
+	window := instance.Spec.MaintenanceWindow
+	if window == nil {
+		return &maintenanceGate{open: true}
+	}
+	gate := &maintenanceGate{
+		configured: true,
+		skip:       instance.Annotations[clusterv1alpha1.AnnotationSkipMaintenanceWindow] == "true",
+	}
+	gate.open, gate.at, _ = window.Open(now)
+	return gate
+}
+
+// allow Returns whether the operation may start, recording it as waiting
+// otherwise. Outside the window, the skip annotation lets a single
+// operation start.
+func (g *maintenanceGate) allow(operation string) bool {
+	if g.open {
+		return true
+	}
+	if g.skip && !g.skipped {
+		g.skipped = true
+		return true
+	}
+	g.waiting = append(g.waiting, operation)
+	return false
+}
+
+// requeue Returns how long to wait before starting the operations waiting
+// for the window, or zero when none waits or the window never opens.
+func (g *maintenanceGate) requeue(now time.Time) time.Duration {
+	if len(g.waiting) == 0 || g.at.IsZero() {
+		return 0
+	}
+	if wait := g.at.Sub(now); wait > time.Second {
+		return wait
+	}
+	return time.Second
+}
+
+// maintenancePartition Holds the partition of the StatefulSet above every
+// peer while the window is closed, so that a new revision waits for the
+// window instead of restarting peers. A rollout which already restarted
+// peers goes on, as do the rollouts of OnDelete StatefulSets, which only
+// restart the pods someone deletes.
+func (r *IpfsReconciler) maintenancePartition(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	gate *maintenanceGate,
+	partition *int32,
+) (*int32, error) {
+	strategy := m.Spec.UpdateStrategy
+	if gate.open || (strategy != nil && strategy.Type == string(appsv1.OnDeleteStatefulSetStrategyType)) {
+		return partition, nil
+	}
+	sts := appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}
+	if err := r.Get(ctx, key, &sts); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot get statefulset: %w", err)
+		}
+		return partition, nil
+	}
+	hold := peerCount(m)
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		released := sts.Status.UpdatedReplicas > 0
+		if rolling := sts.Spec.UpdateStrategy.RollingUpdate; rolling != nil && rolling.Partition != nil {
+			released = released || *rolling.Partition < hold
+		} else {
+			released = true
+		}
+		if released || gate.allow(operationRollout) {
+			return partition, nil
+		}
+	}
+	return &hold, nil
+}
+
+// syncMaintenanceWindow Sets the WaitingForMaintenanceWindow condition from
+// the operations which wait for the window, with when it opens next.
+func syncMaintenanceWindow(instance *clusterv1alpha1.Ipfs, gate *maintenanceGate) {
+	if !gate.configured {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionWaitingForMaintenanceWindow)
+		return
+	}
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionWaitingForMaintenanceWindow,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.WaitingForMaintenanceWindowReasonNothingWaiting,
+		ObservedGeneration: instance.Generation,
+	}
+	switch {
+	case len(gate.waiting) > 0:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.WaitingForMaintenanceWindowReasonOutsideWindow
+		cond.Message = fmt.Sprintf("%s %s for the maintenance window", strings.Join(gate.waiting, ", "),
+			waitVerb(len(gate.waiting)))
+		if gate.at.IsZero() {
+			cond.Message += ", which never opens again"
+		} else {
+			cond.Message += fmt.Sprintf(" opening at %s", gate.at.Format(time.RFC3339))
+		}
+	case gate.open:
+		cond.Message = fmt.Sprintf("the maintenance window is open until %s", gate.at.Format(time.RFC3339))
+	case gate.at.IsZero():
+		cond.Message = "the maintenance window never opens again"
+	default:
+		cond.Message = fmt.Sprintf("the next maintenance window opens at %s", gate.at.Format(time.RFC3339))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
+
+// waitVerb Returns the verb agreeing with the number of waiting operations.
+func waitVerb(n int) string {
+	if n == 1 {
+		return "waits"
+	}
+	return "wait"
+}
+
+// consumeMaintenanceSkip Removes the skip annotation once an operation
+// started thanks to it.
+func (r *IpfsReconciler) consumeMaintenanceSkip(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	gate *maintenanceGate,
+) error {
+	if !gate.skipped {
+		return nil
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	delete(instance.Annotations, clusterv1alpha1.AnnotationSkipMaintenanceWindow)
+	return r.Patch(ctx, instance, patch)
+}
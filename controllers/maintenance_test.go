@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs maintenance window", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		stsKey     types.NamespacedName
+		opens      time.Time
+	)
+
+	reconcile := func() ctrl.Result {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	get := func() *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	update := func(mutate func(*clusterv1alpha1.Ipfs)) {
+		instance := get()
+		mutate(instance)
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+	}
+
+	waiting := func() *metav1.Condition {
+		return meta.FindStatusCondition(get().Status.Conditions, clusterv1alpha1.ConditionWaitingForMaintenanceWindow)
+	}
+
+	statefulSet := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		return sts
+	}
+
+	// setRevisions Sets the revisions of the StatefulSet and how many peers run the update one.
+	setRevisions := func(current, update string, updated int32) {
+		sts := statefulSet()
+		sts.Status.Replicas = 3
+		sts.Status.UpdatedReplicas = updated
+		sts.Status.CurrentRevision = current
+		sts.Status.UpdateRevision = update
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+	}
+
+	partition := func() *int32 {
+		rolling := statefulSet().Spec.UpdateStrategy.RollingUpdate
+		if rolling == nil {
+			return nil
+		}
+		return rolling.Partition
+	}
+
+	clusterSecret := func() string {
+		sec := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-" + key.Name + "-cluster-secret"}, sec)).To(Succeed())
+		return string(sec.Data["CLUSTER_SECRET"])
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "window"}
+		stsKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		// The window opens for an hour every day, twelve hours from now.
+		opens = time.Now().UTC().Truncate(time.Hour).Add(12 * time.Hour)
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.MaintenanceWindow = &clusterv1alpha1.MaintenanceWindow{
+			Schedules: []string{fmt.Sprintf("0 %d * * *", opens.Hour())},
+			Duration:  metav1.Duration{Duration: time.Hour},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+	})
+
+	It("holds the rollouts requested outside the window", func() {
+		setRevisions("rev-1", "rev-1", 3)
+		reconcile()
+		cond := waiting()
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Message).To(Equal("the next maintenance window opens at " + opens.Format(time.RFC3339)))
+		Expect(*partition()).To(Equal(int32(3)))
+
+		setRevisions("rev-1", "rev-2", 0)
+		result := reconcile()
+		Expect(*partition()).To(Equal(int32(3)))
+		Expect(result.RequeueAfter).To(BeNumerically("~", time.Until(opens), time.Minute))
+		cond = waiting()
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.WaitingForMaintenanceWindowReasonOutsideWindow))
+		Expect(cond.Message).To(Equal("rollout of the peers waits for the maintenance window opening at " +
+			opens.Format(time.RFC3339)))
+
+		By("letting the rollout start once the window opens")
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Spec.MaintenanceWindow.Schedules = []string{"* * * * *"}
+		})
+		reconcile()
+		Expect(partition()).To(BeNil())
+		Expect(waiting().Status).To(Equal(metav1.ConditionFalse))
+		Expect(waiting().Message).To(HavePrefix("the maintenance window is open until"))
+
+		By("finishing the rollout once the window closes")
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Spec.MaintenanceWindow.Schedules = []string{fmt.Sprintf("0 %d * * *", opens.Hour())}
+		})
+		reconcile()
+		Expect(partition()).To(BeNil())
+		Expect(waiting().Status).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("holds the rotations requested outside the window", func() {
+		secret := clusterSecret()
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Annotations = map[string]string{clusterv1alpha1.AnnotationRotateSecret: "true"}
+		})
+		reconcile()
+		Expect(clusterSecret()).To(Equal(secret))
+		Expect(get().Status.SecretRotation.StartedAt).To(BeNil())
+		Expect(waiting().Message).To(HavePrefix("rotation of the cluster secret waits"))
+
+		By("starting a single operation outside the window when asked to")
+		setRevisions("rev-1", "rev-2", 0)
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Annotations[clusterv1alpha1.AnnotationSkipMaintenanceWindow] = "true"
+		})
+		reconcile()
+		Expect(partition()).To(BeNil())
+		Expect(clusterSecret()).To(Equal(secret))
+		Expect(waiting().Message).To(HavePrefix("rotation of the cluster secret waits"))
+		Expect(get().Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationSkipMaintenanceWindow))
+
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Annotations[clusterv1alpha1.AnnotationSkipMaintenanceWindow] = "true"
+		})
+		reconcile()
+		Expect(clusterSecret()).NotTo(Equal(secret))
+		Expect(get().Status.SecretRotation.StartedAt).NotTo(BeNil())
+		Expect(waiting().Status).To(Equal(metav1.ConditionFalse))
+		Expect(get().Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationSkipMaintenanceWindow))
+	})
+
+	It("lets every operation start without a window", func() {
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Spec.MaintenanceWindow = nil
+		})
+		reconcile()
+		setRevisions("rev-1", "rev-2", 0)
+		reconcile()
+		Expect(partition()).To(BeNil())
+		Expect(waiting()).To(BeNil())
+	})
+})
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// membershipCheckInterval Is how often the membership of the peers is
+	// checked while some of them did not join the cluster yet.
+	membershipCheckInterval = 10 * time.Second
+
+	memberReasonJoined        = "Joined"
+	memberReasonNotJoined     = "NotJoined"
+	memberReasonBootstrapping = "Bootstrapping"
+)
+
+// syncMembership Sets the cluster-member condition of every peer pod from the
+// peerset the cluster REST API reports, which the readiness gate of the pods
+// waits for. While no peer is ready, as on a cold start, the REST API cannot
+// be reached through its Service, so the pods whose containers are ready are
+// let in as bootstrapping rather than waiting on each other forever. Peers
+// keep their condition while the API cannot be reached otherwise. It returns
+// whether some peer has yet to join the cluster.
+func (r *IpfsReconciler) syncMembership(ctx context.Context, instance *clusterv1alpha1.Ipfs) (bool, error) {
+	log := ctrllog.FromContext(ctx)
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return false, fmt.Errorf("cannot list peer pods: %w", err)
+	}
+
+	var peers []*corev1.Pod
+	anyReady := false
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || peerOrdinal(pod.Name) >= peerCount(instance) {
+			continue
+		}
+		anyReady = anyReady || podIsReady(pod)
+		peers = append(peers, pod)
+	}
+	if len(peers) == 0 {
+		return false, nil
+	}
+
+	members, apiErr := r.clusterMembers(ctx, instance)
+	if apiErr != nil && anyReady {
+		log.Info("cannot get the cluster peerset, keeping the membership of the peers", "error", apiErr.Error())
+	}
+	pending := false
+	for _, pod := range peers {
+		cond := corev1.PodCondition{Type: clusterv1alpha1.PodConditionClusterMember}
+		switch {
+		case pod.Name == compactingPeer(instance):
+			cond.Status = corev1.ConditionFalse
+			cond.Reason = memberReasonCompacting
+			cond.Message = "the peer is out of the cluster while its state is compacted"
+		case apiErr == nil && runsKnownIdentity(instance, pod.Name, members[pod.Name]):
+			cond.Status = corev1.ConditionTrue
+			cond.Reason = memberReasonJoined
+			cond.Message = "the peer is part of the cluster peerset"
+		case apiErr == nil:
+			cond.Status = corev1.ConditionFalse
+			cond.Reason = memberReasonNotJoined
+			cond.Message = "the peer is not part of the cluster peerset yet"
+		case !anyReady && podConditionIsTrue(pod, corev1.ContainersReady):
+			cond.Status = corev1.ConditionTrue
+			cond.Reason = memberReasonBootstrapping
+			cond.Message = "no peer is ready, the peer is let in to bootstrap the cluster"
+		default:
+			pending = pending || !podConditionIsTrue(pod, clusterv1alpha1.PodConditionClusterMember)
+			continue
+		}
+		pending = pending || cond.Status != corev1.ConditionTrue
+		if err := r.setPodCondition(ctx, pod, cond); err != nil {
+			return false, err
+		}
+	}
+	return pending, nil
+}
+
+// clusterMembers Returns the ipfs-cluster peer IDs of the pods of the peers
+// the cluster REST API reports as part of the peerset, keyed by pod name.
+// Peers the API reports an error for are left out.
+func (r *IpfsReconciler) clusterMembers(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) (map[string]string, error) {
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := api.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]string, len(peers))
+	for _, peer := range peers {
+		if peer.Error == "" {
+			members[peer.Peername] = peer.ID
+		}
+	}
+	return members, nil
+}
+
+// runsKnownIdentity Returns whether the peer joined the peerset with the
+// ipfs-cluster identity recorded for it, or with the one it is being rotated
+// to. A peer whose identity is not recorded yet is taken at its word.
+func runsKnownIdentity(instance *clusterv1alpha1.Ipfs, name, id string) bool {
+	if id == "" {
+		return false
+	}
+	if rotation := instance.Status.IdentityRotation; rotation != nil &&
+		peerPodName(instance, rotation.Ordinal) == name && rotation.NewID == id {
+		return true
+	}
+	for _, p := range instance.Status.Peers {
+		if p.Name == name {
+			return p.ID == "" || p.ID == id
+		}
+	}
+	return true
+}
+
+// setPodCondition Patches the status of the pod with the given condition,
+// unless it already has the same status and reason.
+func (r *IpfsReconciler) setPodCondition(ctx context.Context, pod *corev1.Pod, cond corev1.PodCondition) error {
+	for i := range pod.Status.Conditions {
+		existing := &pod.Status.Conditions[i]
+		if existing.Type != cond.Type {
+			continue
+		}
+		if existing.Status == cond.Status && existing.Reason == cond.Reason {
+			return nil
+		}
+		before := pod.DeepCopy()
+		if existing.Status != cond.Status {
+			existing.LastTransitionTime = metav1.Now()
+		}
+		existing.Status = cond.Status
+		existing.Reason = cond.Reason
+		existing.Message = cond.Message
+		return r.patchPodStatus(ctx, pod, before)
+	}
+	before := pod.DeepCopy()
+	cond.LastTransitionTime = metav1.Now()
+	pod.Status.Conditions = append(pod.Status.Conditions, cond)
+	return r.patchPodStatus(ctx, pod, before)
+}
+
+func (r *IpfsReconciler) patchPodStatus(ctx context.Context, pod, before *corev1.Pod) error {
+	// A strategic merge patch only touches the conditions of the given type,
+	// leaving the ones the kubelet maintains alone.
+	if err := r.Status().Patch(ctx, pod, client.StrategicMergeFrom(before)); err != nil {
+		return fmt.Errorf("cannot set the membership condition of %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// podConditionIsTrue Returns whether the pod has the given condition set to
+// True.
+func podConditionIsTrue(pod *corev1.Pod, conditionType corev1.PodConditionType) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == conditionType {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
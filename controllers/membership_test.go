@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+)
+
+var _ = Describe("Ipfs cluster membership", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "membership"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		cluster = clusterfake.NewCluster()
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		reconciler = &IpfsReconciler{
+			Client: fake.NewClientBuilder().WithObjects(credentials).Build(),
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	// peer Creates the pod of the peer with the given ordinal.
+	peer := func(ordinal int32, conditions ...corev1.PodConditionType) {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+		for _, c := range conditions {
+			pod.Status.Conditions = append(pod.Status.Conditions,
+				corev1.PodCondition{Type: c, Status: corev1.ConditionTrue})
+		}
+		Expect(reconciler.Create(ctx, pod)).To(Succeed())
+	}
+
+	// member Returns the cluster-member condition of the peer with the given ordinal.
+	member := func(ordinal int32) *corev1.PodCondition {
+		pod := &corev1.Pod{}
+		key := client.ObjectKey{Namespace: instance.Namespace, Name: peerPodName(instance, ordinal)}
+		Expect(reconciler.Get(ctx, key, pod)).To(Succeed())
+		for i := range pod.Status.Conditions {
+			if pod.Status.Conditions[i].Type == clusterv1alpha1.PodConditionClusterMember {
+				return &pod.Status.Conditions[i]
+			}
+		}
+		return nil
+	}
+
+	It("gates the readiness of the peers on their membership", func() {
+		scheme := runtime.NewScheme()
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler.Scheme = scheme
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-membership", "ipfs-cluster-membership",
+			"ipfs-cluster-membership", "ipfs-cluster-scripts-membership", "")()).To(Succeed())
+		Expect(sts.Spec.Template.Spec.ReadinessGates).To(ConsistOf(corev1.PodReadinessGate{
+			ConditionType: clusterv1alpha1.PodConditionClusterMember,
+		}))
+	})
+
+	It("sets the condition from the cluster peerset", func() {
+		peer(0, corev1.ContainersReady, corev1.PodReady)
+		peer(1, corev1.ContainersReady)
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWA", Peername: peerPodName(instance, 0)})
+
+		pending, err := reconciler.syncMembership(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(BeTrue())
+		Expect(member(0).Status).To(Equal(corev1.ConditionTrue))
+		Expect(member(0).Reason).To(Equal(memberReasonJoined))
+		Expect(member(1).Status).To(Equal(corev1.ConditionFalse))
+		Expect(member(1).Reason).To(Equal(memberReasonNotJoined))
+
+		By("keeping the conditions of the kubelet")
+		pod := &corev1.Pod{}
+		Expect(reconciler.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: peerPodName(instance, 0)},
+			pod)).To(Succeed())
+		Expect(podIsReady(pod)).To(BeTrue())
+
+		By("letting in the peer once it joins")
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWB", Peername: peerPodName(instance, 1)})
+		pending, err = reconciler.syncMembership(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(BeFalse())
+		Expect(member(1).Status).To(Equal(corev1.ConditionTrue))
+	})
+
+	It("leaves out the peers the cluster reports an error for", func() {
+		peer(0, corev1.ContainersReady, corev1.PodReady)
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWA", Peername: peerPodName(instance, 0), Error: "unreachable"})
+
+		_, err := reconciler.syncMembership(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(member(0).Status).To(Equal(corev1.ConditionFalse))
+	})
+
+	It("keeps out a peer running another identity than the one recorded for it", func() {
+		peer(0, corev1.ContainersReady, corev1.PodReady)
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{{Name: peerPodName(instance, 0), ID: "12D3KooWA"}}
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWZ", Peername: peerPodName(instance, 0)})
+
+		_, err := reconciler.syncMembership(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(member(0).Status).To(Equal(corev1.ConditionFalse))
+		Expect(member(0).Reason).To(Equal(memberReasonNotJoined))
+	})
+
+	It("lets the peers in while the cluster bootstraps", func() {
+		peer(0, corev1.ContainersReady)
+		peer(1)
+		cluster.Close()
+
+		pending, err := reconciler.syncMembership(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(BeTrue())
+		Expect(member(0).Status).To(Equal(corev1.ConditionTrue))
+		Expect(member(0).Reason).To(Equal(memberReasonBootstrapping))
+		Expect(member(1)).To(BeNil())
+	})
+
+	It("keeps the conditions while the cluster cannot be reached", func() {
+		peer(0, corev1.ContainersReady, corev1.PodReady)
+		peer(1, corev1.ContainersReady)
+		cluster.Close()
+
+		pending, err := reconciler.syncMembership(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(BeTrue())
+		Expect(member(0)).To(BeNil())
+		Expect(member(1)).To(BeNil())
+	})
+})
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+// meshMember Is a ready peer taking part in a mesh check.
+type meshMember struct {
+	pod string
+	id  string
+	api kuboapi.API
+	// connected Are the peer IDs the node was connected to when the check
+	// listed them, nil when they could not be listed.
+	connected map[string]bool
+}
+
+// syncMesh Checks, once per status-sync interval, that the kubo node of
+// every ready peer is connected to those of the other ready peers. A node
+// missing a connection is asked to connect to the missing peer through its
+// pod address once, before the connection counts as missing. The number of
+// connected peers, the peer ID of its kubo node, its swarm addresses and the
+// addresses browsers dial it at are recorded for each peer, and the
+// MeshDegraded condition is set once connections have been missing for
+// longer than the threshold. A post-partition pass is queued once the
+// condition clears. The links of the peers to the circuit relays are checked
+// along. Peers which cannot be queried are left out of the check.
+func (r *IpfsReconciler) syncMesh(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	defaults := r.Defaults.Get()
+	now := metav1.Now()
+	mesh := instance.Status.Mesh
+	if mesh != nil && now.Sub(mesh.CheckedAt.Time) < r.statusSyncInterval() {
+		return nil
+	}
+
+	members, err := r.meshMembers(ctx, instance)
+	if err != nil {
+		return err
+	}
+	unreachable := r.unreachablePeers(ctx, instance, members)
+
+	if mesh == nil {
+		mesh = &clusterv1alpha1.MeshStatus{}
+		instance.Status.Mesh = mesh
+	}
+	mesh.CheckedAt = now
+	switch {
+	case len(unreachable) == 0:
+		mesh.IncompleteSince = nil
+	case mesh.IncompleteSince == nil:
+		mesh.IncompleteSince = &now
+	}
+	if err = r.syncRelayLinks(ctx, instance, members); err != nil {
+		return err
+	}
+	wasDegraded := meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionMeshDegraded)
+	setMeshCondition(instance, defaults.MeshDegradedAfter.Duration, unreachable)
+	schedulePostPartitionAudit(instance, wasDegraded, now)
+	return nil
+}
+
+// meshMembers Returns the ready peers whose kubo node tells its identity,
+// recording the peer ID, the swarm addresses and the addresses browsers dial
+// it at in the status of each peer.
+func (r *IpfsReconciler) meshMembers(ctx context.Context, instance *clusterv1alpha1.Ipfs) ([]meshMember, error) {
+	log := ctrllog.FromContext(ctx)
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return nil, fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	var members []meshMember
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := peerOrdinal(pod.Name)
+		if pod.DeletionTimestamp != nil || ordinal < 0 || ordinal >= peerCount(instance) || !podIsReady(pod) {
+			continue
+		}
+		var id *kuboapi.IDOutput
+		api, err := r.kuboAPI(instance, ordinal)
+		if err == nil {
+			id, err = api.ID(ctx)
+		}
+		if err != nil {
+			log.Info("cannot get kubo identity", "peer", pod.Name, "error", err.Error())
+			continue
+		}
+		peer := peerStatus(instance, pod.Name)
+		peer.KuboID = id.ID
+		peer.BrowserAddresses = browserAddresses(instance, pod.Name, id)
+		listen, err := api.SwarmAddrsListen(ctx)
+		if err != nil {
+			log.Info("cannot list swarm listen addresses", "peer", pod.Name, "error", err.Error())
+			if peer.Swarm != nil {
+				listen = peer.Swarm.Listen
+			}
+		}
+		peer.Swarm = &clusterv1alpha1.SwarmAddresses{Listen: listen, Announce: id.Addresses}
+		members = append(members, meshMember{pod: pod.Name, id: id.ID, api: api})
+	}
+
+	return members, nil
+}
+
+// unreachablePeers Returns the connections missing between the members, as
+// "pod -> other pod", recording the number of connected peers of each member.
+func (r *IpfsReconciler) unreachablePeers(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	members []meshMember,
+) []string {
+	log := ctrllog.FromContext(ctx)
+	var unreachable []string
+	for i := range members {
+		member := &members[i]
+		missing, err := r.missingConnections(ctx, instance, member, members)
+		if err != nil {
+			log.Info("cannot check the connections of the peer", "peer", member.pod, "error", err.Error())
+			continue
+		}
+		connected := int32(len(members) - 1 - len(missing))
+		peerStatus(instance, member.pod).ConnectedPeers = &connected
+		peerMissingConnections.WithLabelValues(instance.Namespace, instance.Name, member.pod).Set(float64(len(missing)))
+		for _, other := range missing {
+			unreachable = append(unreachable, member.pod+" -> "+other)
+		}
+	}
+
+	return unreachable
+}
+
+// missingConnections Returns the pods of the other members the member is
+// not connected to, after asking it to connect to each of them once. The
+// connections of the member are recorded on it for the relay links check.
+func (r *IpfsReconciler) missingConnections(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	member *meshMember,
+	members []meshMember,
+) ([]string, error) {
+	log := ctrllog.FromContext(ctx)
+	peers, err := member.api.SwarmPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	member.connected = make(map[string]bool, len(peers))
+	for _, p := range peers {
+		member.connected[p.Peer] = true
+	}
+	var missing []string
+	for _, other := range members {
+		if other.pod == member.pod || member.connected[other.id] {
+			continue
+		}
+		if err := member.api.SwarmConnect(ctx, meshAddress(instance, other)); err != nil {
+			log.Info("cannot connect peers", "peer", member.pod, "to", other.pod, "error", err.Error())
+			missing = append(missing, other.pod)
+			continue
+		}
+		log.Info("connected peers", "peer", member.pod, "to", other.pod)
+	}
+	return missing, nil
+}
+
+// meshAddress Returns the swarm multiaddress of the member, through the DNS
+// name of its pod on the Service governing the peers.
+func meshAddress(instance *clusterv1alpha1.Ipfs, member meshMember) string {
+	return fmt.Sprintf("/dns4/%s.%s.%s.svc/tcp/%d/p2p/%s",
+		member.pod, peerServiceName(instance), instance.Namespace, portSwarm, member.id)
+}
+
+// setMeshCondition Sets the MeshDegraded condition from the connections
+// missing between the peers. It only turns true once the mesh has been
+// incomplete for longer than the threshold.
+func setMeshCondition(instance *clusterv1alpha1.Ipfs, threshold time.Duration, unreachable []string) {
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionMeshDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.MeshDegradedReasonMeshComplete,
+		Message:            "every ready peer is connected to every other one",
+		ObservedGeneration: instance.Generation,
+	}
+	if since := instance.Status.Mesh.IncompleteSince; since != nil {
+		cond.Reason = clusterv1alpha1.MeshDegradedReasonSettling
+		cond.Message = "missing connections: " + strings.Join(unreachable, ", ")
+		if instance.Status.Mesh.CheckedAt.Sub(since.Time) >= threshold {
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = clusterv1alpha1.MeshDegradedReasonPeersUnreachable
+			cond.Message = fmt.Sprintf("missing connections since %s: %s",
+				since.UTC().Format(time.RFC3339), strings.Join(unreachable, ", "))
+		}
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs peer mesh", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		nodes      []*kubofake.Node
+	)
+
+	// check Runs a mesh check as if the previous one happened the given time ago.
+	check := func(ago time.Duration) *metav1.Condition {
+		if instance.Status.Mesh != nil {
+			instance.Status.Mesh.CheckedAt = metav1.NewTime(time.Now().Add(-ago))
+			if since := instance.Status.Mesh.IncompleteSince; since != nil {
+				*since = metav1.NewTime(since.Add(-ago))
+			}
+		}
+		Expect(reconciler.syncMesh(ctx, instance)).To(Succeed())
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionMeshDegraded)
+	}
+
+	connected := func(name string) int32 {
+		peer := peerStatus(instance, name)
+		Expect(peer.ConnectedPeers).NotTo(BeNil())
+		return *peer.ConnectedPeers
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "mesh"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 3
+
+		builder := fake.NewClientBuilder()
+		dialer := kubofake.NewDialer()
+		nodes = nil
+		for i, id := range []string{"12D3KooWA", "12D3KooWB", "12D3KooWC"} {
+			pod := &corev1.Pod{}
+			pod.Name = peerPodName(instance, int32(i))
+			pod.Namespace = instance.Namespace
+			pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			builder = builder.WithObjects(pod)
+			node := kubofake.NewNode(id)
+			dialer.Add(instance.Namespace, pod.Name, node)
+			nodes = append(nodes, node)
+		}
+		// The peers 0 and 1 are connected to each other, but not to 2.
+		nodes[0].Peers = []kuboapi.SwarmPeer{{Peer: "12D3KooWB"}}
+		nodes[1].Peers = []kuboapi.SwarmPeer{{Peer: "12D3KooWA"}}
+		nodes[0].Listen = []string{"/ip4/0.0.0.0/tcp/4001"}
+		nodes[0].Identity.Addresses = []string{"/ip4/10.0.0.1/tcp/4001/p2p/12D3KooWA"}
+		reconciler = &IpfsReconciler{Client: builder.Build(), Kubo: dialer}
+	})
+
+	It("connects the peers missing each other once", func() {
+		cond := check(0)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.MeshDegradedReasonMeshComplete))
+		for i := range nodes {
+			Expect(connected(peerPodName(instance, int32(i)))).To(Equal(int32(2)))
+		}
+		Expect(nodes[2].Calls["swarm/connect"]).To(Equal(2))
+		Expect(peerStatus(instance, "ipfs-cluster-mesh-0").Swarm).To(Equal(&clusterv1alpha1.SwarmAddresses{
+			Listen:   []string{"/ip4/0.0.0.0/tcp/4001"},
+			Announce: []string{"/ip4/10.0.0.1/tcp/4001/p2p/12D3KooWA"},
+		}))
+		Expect(nodes[0].Peers).To(ContainElement(kuboapi.SwarmPeer{
+			Addr: "/dns4/ipfs-cluster-mesh-2.ipfs-cluster-mesh.default.svc/tcp/4001",
+			Peer: "12D3KooWC",
+		}))
+	})
+
+	It("reports a partitioned peer once the threshold passes", func() {
+		nodes[2].Errs["swarm/connect"] = errors.New("failed to dial")
+		nodes[0].Errs["swarm/connect"] = errors.New("failed to dial")
+		nodes[1].Errs["swarm/connect"] = errors.New("failed to dial")
+		cond := check(0)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.MeshDegradedReasonSettling))
+		Expect(connected("ipfs-cluster-mesh-0")).To(Equal(int32(1)))
+		Expect(connected("ipfs-cluster-mesh-2")).To(Equal(int32(0)))
+		Expect(instance.Status.Mesh.IncompleteSince).NotTo(BeNil())
+
+		By("checking again within the interval")
+		check(0)
+		Expect(nodes[0].Calls["swarm/peers"]).To(Equal(1))
+
+		By("checking again past the threshold")
+		cond = check(6 * time.Minute)
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.MeshDegradedReasonPeersUnreachable))
+		Expect(cond.Message).To(ContainSubstring("ipfs-cluster-mesh-0 -> ipfs-cluster-mesh-2"))
+		Expect(cond.Message).To(ContainSubstring("ipfs-cluster-mesh-2 -> ipfs-cluster-mesh-1"))
+
+		By("recovering once the peers reach each other")
+		delete(nodes[0].Errs, "swarm/connect")
+		delete(nodes[1].Errs, "swarm/connect")
+		delete(nodes[2].Errs, "swarm/connect")
+		cond = check(time.Minute)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(instance.Status.Mesh.IncompleteSince).To(BeNil())
+	})
+
+	It("leaves out the peers which cannot be queried", func() {
+		nodes[2].Err = errors.New("connection refused")
+		check(0)
+		Expect(connected("ipfs-cluster-mesh-0")).To(Equal(int32(1)))
+		Expect(peerStatus(instance, "ipfs-cluster-mesh-2").ConnectedPeers).To(BeNil())
+	})
+})
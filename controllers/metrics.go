@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// skewedPeers Counts the peers of each cluster running images or a
+	// configuration other than the requested ones.
+	skewedPeers = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_skewed_peers",
+			Help: "Number of peers running images or a configuration other than the requested ones.",
+		},
+		[]string{"namespace", "name"},
+	)
+	// peerRepoUtilization Is the share of StorageMax used by the repo of each peer.
+	peerRepoUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_peer_repo_utilization_ratio",
+			Help: "Share of StorageMax used by the kubo repo of each peer, as of its last check.",
+		},
+		[]string{"namespace", "name", "peer"},
+	)
+	// peerBandwidthRate Is the rate each peer receives and sends data at.
+	peerBandwidthRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_peer_bandwidth_bytes_per_second",
+			Help: "Rate the kubo node of each peer receives (in) or sends (out) data at, as of its last check.",
+		},
+		[]string{"namespace", "name", "peer", "direction"},
+	)
+	// peerMissingConnections Is the number of the other ready peers the kubo
+	// node of each peer is not connected to.
+	peerMissingConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_peer_missing_connections",
+			Help: "Number of the other ready peers the kubo node of each peer is not connected to, as of its last check.",
+		},
+		[]string{"namespace", "name", "peer"},
+	)
+	// gatewayRequests Counts the requests served by the gateways of each
+	// monitored cluster.
+	gatewayRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ipfs_operator_gateway_requests_total",
+			Help: "Number of requests served by the gateways of each monitored cluster, as sampled by the operator.",
+		},
+		[]string{"namespace", "name"},
+	)
+	// gatewayResponseBytes Counts the bytes served by the gateways of each
+	// monitored cluster.
+	gatewayResponseBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ipfs_operator_gateway_response_bytes_total",
+			Help: "Number of bytes served by the gateways of each monitored cluster, as sampled by the operator.",
+		},
+		[]string{"namespace", "name"},
+	)
+	// reconciles Counts the reconciles of each cluster.
+	reconciles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ipfs_operator_reconciles_total",
+			Help: "Number of reconciles of each cluster.",
+		},
+		[]string{"namespace", "name"},
+	)
+	// reconcileErrors Counts the reconciles of each cluster which stopped at
+	// an error, by the reason of the Reconciled condition of its class.
+	reconcileErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ipfs_operator_reconcile_errors_total",
+			Help: "Number of reconciles of each cluster which stopped at an error, by the class of the error.",
+		},
+		[]string{"namespace", "name", "reason"},
+	)
+	// apiBreakerOpen Tells whether the circuit breaker of each API of each
+	// cluster is open.
+	apiBreakerOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ipfs_operator_api_breaker_open",
+			Help: "Whether the circuit breaker of each API of each cluster is open, or half-open, stopping the calls.",
+		},
+		[]string{"namespace", "name", "api"},
+	)
+	// reconcileWrites Is the number of objects each reconcile created or
+	// patched.
+	reconcileWrites = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ipfs_operator_reconcile_object_writes",
+			Help:    "Number of objects each reconcile created or patched, unchanged objects being skipped.",
+			Buckets: []float64{0, 1, 2, 4, 8, 16, 32},
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(skewedPeers, peerRepoUtilization, peerBandwidthRate, peerMissingConnections,
+		gatewayRequests, gatewayResponseBytes, reconciles, reconcileErrors, apiBreakerOpen, missingPermissions,
+		pendingInitialReconciles, fleetClustersPerNode, fleetClustersPerStorageClass, fleetClustersPerRelay,
+		fleetSharedResources, fleetHealthClusters, reconcileWrites, heldPins)
+}
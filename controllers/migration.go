@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// hasFinalizer Returns whether the cluster carries the finalizer of the
+// operator, current or legacy.
+func hasFinalizer(instance *clusterv1alpha1.Ipfs) bool {
+	return controllerutil.ContainsFinalizer(instance, finalizer) ||
+		controllerutil.ContainsFinalizer(instance, legacyFinalizer)
+}
+
+// migrateLegacyFinalizer Replaces the legacy finalizer of a cluster created
+// by an older operator with the current one in a single update, so that the
+// cluster is never left without a finalizer, and records the
+// MigratedFromLegacyNaming condition. It returns whether the cluster was
+// migrated. Clusters without the legacy finalizer are left alone, as are
+// clusters being deleted, which cannot take a new finalizer and are
+// finalized under the legacy one. The objects of the cluster kept their
+// names, so they are adopted as they are reconciled.
+func (r *IpfsReconciler) migrateLegacyFinalizer(ctx context.Context, instance *clusterv1alpha1.Ipfs) (bool, error) {
+	if instance.DeletionTimestamp != nil || !controllerutil.ContainsFinalizer(instance, legacyFinalizer) {
+		return false, nil
+	}
+	key := client.ObjectKeyFromObject(instance)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(ctx, key, instance); err != nil {
+			return err
+		}
+		if !controllerutil.ContainsFinalizer(instance, legacyFinalizer) {
+			return nil
+		}
+		controllerutil.RemoveFinalizer(instance, legacyFinalizer)
+		controllerutil.AddFinalizer(instance, finalizer)
+		return r.Update(ctx, instance)
+	})
+	if err != nil {
+		return false, fmt.Errorf("cannot replace legacy finalizer: %w", err)
+	}
+	if err = r.updateStatusRetrying(ctx, instance, func(latest *clusterv1alpha1.Ipfs) {
+		meta.SetStatusCondition(&latest.Status.Conditions, metav1.Condition{
+			Type:               clusterv1alpha1.ConditionMigratedFromLegacyNaming,
+			Status:             metav1.ConditionTrue,
+			Reason:             clusterv1alpha1.MigratedFromLegacyNamingReasonFinalizerReplaced,
+			Message:            fmt.Sprintf("replaced the %s finalizer with %s", legacyFinalizer, finalizer),
+			ObservedGeneration: latest.Generation,
+		})
+	}); err != nil {
+		return false, err
+	}
+	ctrllog.FromContext(ctx).Info("replaced legacy finalizer", "finalizer", finalizer)
+	r.eventf(instance, corev1.EventTypeNormal, "MigratedFromLegacyNaming",
+		"replaced the %s finalizer with %s", legacyFinalizer, finalizer)
+	return true, nil
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs legacy naming migration", func() {
+	var (
+		ctx        context.Context
+		scheme     *runtime.Scheme
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() ctrl.Result {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	get := func() *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	// legacyDeployment Returns a cluster and its StatefulSet as an older
+	// operator left them: the misspelled finalizer, and a StatefulSet only
+	// labeled with its name.
+	legacyDeployment := func() []client.Object {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.UID = "7d5c1f0e-legacy"
+		instance.Finalizers = []string{legacyFinalizer}
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-" + key.Name
+		sts.Namespace = key.Namespace
+		sts.UID = "0b9e4a6d-statefulset"
+		sts.Labels = map[string]string{labelName: sts.Name}
+		sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{labelName: sts.Name}}
+		sts.Spec.Template.Labels = map[string]string{labelName: sts.Name}
+		Expect(ctrl.SetControllerReference(instance, sts, scheme)).To(Succeed())
+		return []client.Object{instance, sts}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "legacy"}
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+	})
+
+	It("replaces the legacy finalizer and keeps the StatefulSet of an old deployment", func() {
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(legacyDeployment()...).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+
+		Expect(reconcile().Requeue).To(BeTrue())
+		instance := get()
+		Expect(instance.Finalizers).To(Equal([]string{finalizer}))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionMigratedFromLegacyNaming)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.MigratedFromLegacyNamingReasonFinalizerReplaced))
+		migratedAt := cond.LastTransitionTime
+
+		By("adopting the StatefulSet in place")
+		reconcile()
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name},
+			sts)).To(Succeed())
+		Expect(sts.UID).To(Equal(types.UID("0b9e4a6d-statefulset")))
+		Expect(sts.Labels).To(HaveKeyWithValue(labelComponent, componentPeer))
+		Expect(sts.Spec.Selector.MatchLabels).To(Equal(map[string]string{labelName: sts.Name}))
+
+		By("doing nothing more on the next passes")
+		reconcile()
+		instance = get()
+		Expect(instance.Finalizers).To(Equal([]string{finalizer}))
+		cond = meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionMigratedFromLegacyNaming)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.LastTransitionTime).To(Equal(migratedAt))
+	})
+
+	It("leaves fresh installations alone", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+
+		reconcile()
+		reconcile()
+		instance = get()
+		Expect(instance.Finalizers).To(Equal([]string{finalizer}))
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionMigratedFromLegacyNaming)).To(BeNil())
+	})
+
+	It("finalizes a deleted cluster under the legacy finalizer", func() {
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(legacyDeployment()...).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+		Expect(fakeClient.Delete(ctx, get())).To(Succeed())
+
+		reconcile()
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, key, &clusterv1alpha1.Ipfs{}))).To(BeTrue())
+	})
+})
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+// serviceMonitorGVK Is the ServiceMonitor kind of the Prometheus operator,
+// which is managed as unstructured objects like the cert-manager Certificates.
+var serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+
+// metricsPortName Is the name of the port Prometheus scrapes the peers on.
+const metricsPortName = "metrics"
+
+// gatewayMetricsRegex Matches the name and handler labels of the metrics kept
+// by the scrapes: those of the requests served by the gateway.
+const gatewayMetricsRegex = "ipfs_http_.+;gateway"
+
+// gatewayExposed Returns whether the gateway of the cluster is served outside
+// of Kubernetes, through a LoadBalancer Service or an Ingress.
+func gatewayExposed(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Public || m.Spec.Expose.Host != ""
+}
+
+// gatewayMonitored Returns whether the gateway metrics of the peers are scraped.
+func gatewayMonitored(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Monitoring != nil && gatewayExposed(m)
+}
+
+// metricsServiceName Returns the name of the Service Prometheus discovers the
+// peers through, which is also the name of the ServiceMonitor.
+func metricsServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-metrics-" + m.Name
+}
+
+// serviceMetrics Returns a mutate function for the headless Service listing
+// the metrics endpoint of every peer.
+func (r *IpfsReconciler) serviceMetrics(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) (controllerutil.MutateFn, string) {
+	svcName := metricsServiceName(m)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       metricsPortName,
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portAPI,
+					TargetPort: intstr.FromString("api"),
+				},
+			},
+			Selector: peerSelector(m),
+		},
+	}
+	expected.DeepCopyInto(svc)
+	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		svc.Spec = expected.Spec
+		return nil
+	}, svcName
+}
+
+// serviceMonitorGateway Returns a mutate function for the ServiceMonitor
+// scraping the peers through the metrics Service. Only the metrics of the
+// gateway are kept, labeled with the instance label of the Service, so that
+// Prometheus can sum them for each cluster.
+func (r *IpfsReconciler) serviceMonitorGateway(
+	m *clusterv1alpha1.Ipfs,
+	sm *unstructured.Unstructured,
+) (controllerutil.MutateFn, string) {
+	smName := metricsServiceName(m)
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetName(smName)
+	sm.SetNamespace(m.Namespace)
+	if err := ctrl.SetControllerReference(m, sm, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	endpoint := map[string]interface{}{
+		"port": metricsPortName,
+		"path": kuboapi.MetricsPath,
+		"metricRelabelings": []interface{}{
+			map[string]interface{}{
+				"sourceLabels": []interface{}{"__name__", "handler"},
+				"regex":        gatewayMetricsRegex,
+				"action":       "keep",
+			},
+		},
+	}
+	if interval := m.Spec.Monitoring.Interval; interval != nil {
+		endpoint["interval"] = interval.Duration.String()
+	}
+	spec := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{
+				labelInstance:  m.Name,
+				labelComponent: componentMonitoring,
+			},
+		},
+		"endpoints":    []interface{}{endpoint},
+		"targetLabels": []interface{}{labelInstance},
+	}
+	return func() error {
+		return unstructured.SetNestedMap(sm.Object, spec, "spec")
+	}, smName
+}
+
+// deleteMonitoring Deletes the metrics Service and the ServiceMonitor once
+// the gateway is no longer monitored. The ServiceMonitor is only looked for
+// when the Service exists, so clusters which never enabled monitoring need
+// no Prometheus operator.
+func (r *IpfsReconciler) deleteMonitoring(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	if gatewayMonitored(m) {
+		return nil
+	}
+	svc := corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: metricsServiceName(m)}, &svc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	sm := unstructured.Unstructured{}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetNamespace(m.Namespace)
+	sm.SetName(metricsServiceName(m))
+	if err := r.Delete(ctx, &sm); err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return fmt.Errorf("cannot delete service monitor: %w", err)
+	}
+	if err := r.Delete(ctx, &svc); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete metrics service: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// OperatorConfigReconciler reconciles the IpfsOperatorConfig object, feeding
+// its spec to the operator defaults and reporting the Ipfs resources out of
+// policy in its status.
+type OperatorConfigReconciler struct {
+	client.Client
+	// APIReader reads the namespaces, for their budget.
+	APIReader client.Reader
+	Defaults  *DefaultsStore
+}
+
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfsoperatorconfigs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cluster.ipfs.io,resources=ipfsoperatorconfigs/status,verbs=get;update;patch
+
+func (r *OperatorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	config := &clusterv1alpha1.IpfsOperatorConfig{}
+	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
+		if !errors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("cannot get IpfsOperatorConfig: %w", err)
+		}
+		if req.Name == clusterv1alpha1.OperatorConfigName {
+			r.Defaults.SetConfig(nil)
+		}
+		return ctrl.Result{}, nil
+	}
+	observed := config.Status.DeepCopy()
+
+	applied := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             clusterv1alpha1.AppliedReasonApplied,
+		Message:            "The configuration applies to every Ipfs resource.",
+		ObservedGeneration: config.Generation,
+	}
+	if config.Name != clusterv1alpha1.OperatorConfigName {
+		applied.Status = metav1.ConditionFalse
+		applied.Reason = clusterv1alpha1.AppliedReasonIgnored
+		applied.Message = fmt.Sprintf("Only the IpfsOperatorConfig named %s applies.",
+			clusterv1alpha1.OperatorConfigName)
+		meta.SetStatusCondition(&config.Status.Conditions, applied)
+		return ctrl.Result{}, r.updateStatus(ctx, config, observed)
+	}
+	if errs := clusterv1alpha1.ValidateOperatorConfigSpec(&config.Spec); len(errs) > 0 {
+		// Keep applying the previous configuration until this one is fixed.
+		applied.Status = metav1.ConditionFalse
+		applied.Reason = clusterv1alpha1.AppliedReasonInvalid
+		applied.Message = fmt.Sprintf("The configuration is not applied: %s", errs.ToAggregate().Error())
+	} else {
+		r.Defaults.SetConfig(&config.Spec)
+		config.Status.ObservedGeneration = config.Generation
+	}
+	meta.SetStatusCondition(&config.Status.Conditions, applied)
+
+	outOfPolicy, err := r.outOfPolicy(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	config.Status.OutOfPolicy = outOfPolicy
+	if err = r.updateStatus(ctx, config, observed); err != nil {
+		return ctrl.Result{}, err
+	}
+	// The budgets of the namespaces are not watched, so check them again periodically.
+	return ctrl.Result{RequeueAfter: r.Defaults.Get().StatusSyncInterval.Duration}, nil
+}
+
+// updateStatus Writes the status of the configuration, unless it is unchanged.
+func (r *OperatorConfigReconciler) updateStatus(
+	ctx context.Context,
+	config *clusterv1alpha1.IpfsOperatorConfig,
+	observed *clusterv1alpha1.IpfsOperatorConfigStatus,
+) error {
+	if equality.Semantic.DeepEqual(observed, &config.Status) {
+		return nil
+	}
+	if err := r.Status().Update(ctx, config); err != nil {
+		return fmt.Errorf("cannot update IpfsOperatorConfig status: %w", err)
+	}
+	return nil
+}
+
+// outOfPolicy Returns the Ipfs resources exceeding the budget which applies
+// to them, sorted by namespace and name.
+func (r *OperatorConfigReconciler) outOfPolicy(ctx context.Context) ([]clusterv1alpha1.OutOfPolicyIpfs, error) {
+	ipfsList := clusterv1alpha1.IpfsList{}
+	if err := r.List(ctx, &ipfsList); err != nil {
+		return nil, fmt.Errorf("cannot list Ipfs resources: %w", err)
+	}
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	budgets := Budgets{Reader: reader, Defaults: r.Defaults}
+	var outOfPolicy []clusterv1alpha1.OutOfPolicyIpfs
+	for i := range ipfsList.Items {
+		m := &ipfsList.Items[i]
+		budget, source, err := budgets.BudgetFor(ctx, m.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if errs := clusterv1alpha1.ValidateBudget(&m.Spec, nil, budget, source); len(errs) > 0 {
+			outOfPolicy = append(outOfPolicy, clusterv1alpha1.OutOfPolicyIpfs{
+				Namespace: m.Namespace,
+				Name:      m.Name,
+				Message:   errs.ToAggregate().Error(),
+			})
+		}
+	}
+	sort.Slice(outOfPolicy, func(i, j int) bool {
+		if outOfPolicy[i].Namespace != outOfPolicy[j].Namespace {
+			return outOfPolicy[i].Namespace < outOfPolicy[j].Namespace
+		}
+		return outOfPolicy[i].Name < outOfPolicy[j].Name
+	})
+	return outOfPolicy, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *OperatorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Check the resources against their budget again whenever a spec changes.
+	toConfig := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: clusterv1alpha1.OperatorConfigName}}}
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&clusterv1alpha1.IpfsOperatorConfig{}).
+		Watches(&source.Kind{Type: &clusterv1alpha1.Ipfs{}}, toConfig,
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("IpfsOperatorConfig controller", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *OperatorConfigReconciler
+	)
+
+	reconcile := func(name string) *clusterv1alpha1.IpfsOperatorConfig {
+		key := types.NamespacedName{Name: name}
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		config := &clusterv1alpha1.IpfsOperatorConfig{}
+		Expect(fakeClient.Get(ctx, key, config)).To(Succeed())
+		return config
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		small := &clusterv1alpha1.Ipfs{}
+		small.Name = "small"
+		small.Namespace = "default"
+		small.Spec.Replicas = 2
+		large := small.DeepCopy()
+		large.Name = "large"
+		large.Spec.Replicas = 6
+		config := &clusterv1alpha1.IpfsOperatorConfig{}
+		config.Name = clusterv1alpha1.OperatorConfigName
+		config.Spec.ClusterImage = "config/cluster"
+		config.Spec.Budget = &clusterv1alpha1.Budget{MaxReplicas: 4}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(small, large, config).Build()
+		store, err := NewDefaultsStore("")
+		Expect(err).NotTo(HaveOccurred())
+		reconciler = &OperatorConfigReconciler{Client: fakeClient, Defaults: store}
+	})
+
+	It("feeds the defaults and reports the resources out of policy", func() {
+		config := reconcile(clusterv1alpha1.OperatorConfigName)
+		Expect(meta.IsStatusConditionTrue(config.Status.Conditions, clusterv1alpha1.ConditionApplied)).To(BeTrue())
+		Expect(reconciler.Defaults.Get().ClusterImage).To(Equal("config/cluster"))
+		Expect(reconciler.Defaults.Get().Budget.MaxReplicas).To(Equal(int32(4)))
+		Expect(config.Status.OutOfPolicy).To(HaveLen(1))
+		Expect(config.Status.OutOfPolicy[0].Name).To(Equal("large"))
+		Expect(config.Status.OutOfPolicy[0].Message).To(ContainSubstring("the operator configuration"))
+
+		By("falling back to the other sources once it is deleted")
+		Expect(fakeClient.Delete(ctx, config)).To(Succeed())
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{
+			Name: clusterv1alpha1.OperatorConfigName,
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconciler.Defaults.Get()).To(Equal(builtinDefaults()))
+	})
+
+	It("keeps the previous configuration while the new one is invalid", func() {
+		reconcile(clusterv1alpha1.OperatorConfigName)
+		config := &clusterv1alpha1.IpfsOperatorConfig{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Name: clusterv1alpha1.OperatorConfigName}, config)).To(Succeed())
+		config.Spec.ClusterImage = "invalid/cluster"
+		config.Spec.MaintenanceWindow = &clusterv1alpha1.MaintenanceWindow{Schedules: []string{"not a schedule"}}
+		Expect(fakeClient.Update(ctx, config)).To(Succeed())
+
+		config = reconcile(clusterv1alpha1.OperatorConfigName)
+		applied := meta.FindStatusCondition(config.Status.Conditions, clusterv1alpha1.ConditionApplied)
+		Expect(applied).NotTo(BeNil())
+		Expect(applied.Reason).To(Equal(clusterv1alpha1.AppliedReasonInvalid))
+		Expect(reconciler.Defaults.Get().ClusterImage).To(Equal("config/cluster"))
+	})
+
+	It("ignores the configurations with another name", func() {
+		other := &clusterv1alpha1.IpfsOperatorConfig{}
+		other.Name = "other"
+		other.Spec.IpfsImage = "other/kubo"
+		Expect(fakeClient.Create(ctx, other)).To(Succeed())
+
+		other = reconcile("other")
+		applied := meta.FindStatusCondition(other.Status.Conditions, clusterv1alpha1.ConditionApplied)
+		Expect(applied).NotTo(BeNil())
+		Expect(applied.Reason).To(Equal(clusterv1alpha1.AppliedReasonIgnored))
+		Expect(reconciler.Defaults.Get().IpfsImage).To(Equal(ipfsImage))
+	})
+})
@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// PeerPodWebhookPath Is the path of the webhook applying the overrides to the
+// peer pods as the StatefulSet creates them.
+const PeerPodWebhookPath = "/mutate-ipfs-peer-pod"
+
+// ipfsClaimName Returns the name of the volume claim holding the repo of the
+// peer with the given ordinal, as the StatefulSet names it.
+func ipfsClaimName(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	return fmt.Sprintf("ipfs-storage-ipfs-cluster-%s-%d", m.Name, ordinal)
+}
+
+// ipfsStorageFor Returns the size of the volume holding the repo of the peer
+// with the given ordinal.
+func ipfsStorageFor(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	if override := m.Spec.OverrideFor(ordinal); override != nil && override.IpfsStorage != "" {
+		return override.IpfsStorage
+	}
+	return m.Spec.IpfsStorage
+}
+
+// storageClassFor Returns the storage class of the volume holding the repo of
+// the peer with the given ordinal.
+func storageClassFor(m *clusterv1alpha1.Ipfs, ordinal int32) *string {
+	if override := m.Spec.OverrideFor(ordinal); override != nil && override.StorageClassName != nil {
+		return override.StorageClassName
+	}
+	return m.Spec.StorageClassName
+}
+
+// podOverride Returns whether the override changes the pod of the peer, as
+// opposed to only its volume.
+func podOverride(override *clusterv1alpha1.PeerOverride) bool {
+	return len(override.NodeSelector) > 0 || override.Resources != nil
+}
+
+// overrideClaims Creates the volume of each peer whose override changes its
+// storage and which has none yet, before the StatefulSet creates one from its
+// volume claim template. The volumes of the other peers are left to the
+// StatefulSet.
+func (r *IpfsReconciler) overrideClaims(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	for ordinal := int32(0); ordinal < m.Spec.Replicas; ordinal++ {
+		override := m.Spec.OverrideFor(ordinal)
+		if override == nil || (override.IpfsStorage == "" && override.StorageClassName == nil) {
+			continue
+		}
+		key := client.ObjectKey{Namespace: m.Namespace, Name: ipfsClaimName(m, ordinal)}
+		if err := r.Get(ctx, key, &corev1.PersistentVolumeClaim{}); !errors.IsNotFound(err) {
+			if err != nil {
+				return fmt.Errorf("cannot get volume claim %s: %w", key.Name, err)
+			}
+			continue
+		}
+		claim := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: m.Namespace,
+				Labels:    ipfsLabels(m, componentPeer),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: storageClassFor(m, ordinal),
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceStorage: resource.MustParse(ipfsStorageFor(m, ordinal)),
+					},
+				},
+			},
+		}
+		if err := r.Create(ctx, claim); err != nil {
+			return fmt.Errorf("cannot create volume claim %s: %w", key.Name, err)
+		}
+	}
+	return nil
+}
+
+// overrideSettings Returns the settings of the overrides changing the pods of
+// the peers, one per line, so that the pods roll when they change and are
+// recreated with them.
+func overrideSettings(m *clusterv1alpha1.Ipfs) []string {
+	var settings []string
+	for i := range m.Spec.Overrides {
+		override := &m.Spec.Overrides[i]
+		if !podOverride(override) {
+			continue
+		}
+		ordinals := strings.Join(override.Ordinals, ",")
+		keys := make([]string, 0, len(override.NodeSelector))
+		for k := range override.NodeSelector {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			settings = append(settings, fmt.Sprintf("override/%s/nodeSelector/%s=%s", ordinals, k, override.NodeSelector[k]))
+		}
+		if override.Resources != nil {
+			settings = append(settings, fmt.Sprintf("override/%s/resources=%s", ordinals, override.Resources.String()))
+		}
+	}
+	return settings
+}
+
+// mergeResources Returns the resources with the given ones replacing those
+// of the same name.
+func mergeResources(resources, overrides corev1.ResourceList) corev1.ResourceList {
+	if len(overrides) == 0 {
+		return resources
+	}
+	merged := corev1.ResourceList{}
+	for name, quantity := range resources {
+		merged[name] = quantity
+	}
+	for name, quantity := range overrides {
+		merged[name] = quantity
+	}
+	return merged
+}
+
+// applyOverride Adds the node selector of the override to the pod of a peer,
+// and sets the resources of its ipfs container, sizing the Go runtime after
+// the new limits unless the spec sets its environment.
+func applyOverride(m *clusterv1alpha1.Ipfs, pod *corev1.Pod, override *clusterv1alpha1.PeerOverride) {
+	if len(override.NodeSelector) > 0 && pod.Spec.NodeSelector == nil {
+		pod.Spec.NodeSelector = map[string]string{}
+	}
+	for k, v := range override.NodeSelector {
+		pod.Spec.NodeSelector[k] = v
+	}
+	if override.Resources == nil {
+		return
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if c.Name != "ipfs" {
+			continue
+		}
+		c.Resources.Limits = mergeResources(c.Resources.Limits, override.Resources.Limits)
+		c.Resources.Requests = mergeResources(c.Resources.Requests, override.Resources.Requests)
+		c.Env = mergeEnv(mergeEnv(c.Env, goRuntimeEnv(c.Resources.Limits)), m.Spec.Env)
+	}
+}
+
+// podOverridden Returns whether the pod of a peer runs with the node selector
+// and the resources of its override.
+func podOverridden(pod *corev1.Pod, override *clusterv1alpha1.PeerOverride) bool {
+	for k, v := range override.NodeSelector {
+		if pod.Spec.NodeSelector[k] != v {
+			return false
+		}
+	}
+	if override.Resources == nil {
+		return true
+	}
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		if c.Name != "ipfs" {
+			continue
+		}
+		return sameResources(c.Resources.Limits, override.Resources.Limits) &&
+			sameResources(c.Resources.Requests, override.Resources.Requests)
+	}
+	return false
+}
+
+// sameResources Returns whether the resources hold the wanted quantities.
+func sameResources(resources, wanted corev1.ResourceList) bool {
+	for name, quantity := range wanted {
+		if actual, ok := resources[name]; !ok || actual.Cmp(quantity) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// podOverrideOrdinals Returns the ordinals of the overrides changing the pods
+// of the peers.
+func podOverrideOrdinals(m *clusterv1alpha1.Ipfs) []string {
+	var ordinals []string
+	for i := range m.Spec.Overrides {
+		if podOverride(&m.Spec.Overrides[i]) {
+			ordinals = append(ordinals, m.Spec.Overrides[i].Ordinals...)
+		}
+	}
+	return ordinals
+}
+
+// syncOverrides Sets the OverridesPending condition when some peer pods run
+// without the node selector or the resources of their override, or when
+// these overrides are refused as the operator does not serve its webhooks.
+func (r *IpfsReconciler) syncOverrides(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	ordinals := podOverrideOrdinals(instance)
+	if len(ordinals) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionOverridesPending)
+		return nil
+	}
+	if !r.Webhooks {
+		r.refuseOverrides(instance, ordinals)
+		return nil
+	}
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	var pending []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := peerOrdinal(pod.Name)
+		if pod.DeletionTimestamp != nil || ordinal < 0 || ordinal >= peerCount(instance) {
+			continue
+		}
+		override := instance.Spec.OverrideFor(ordinal)
+		if override != nil && !podOverridden(pod, override) {
+			pending = append(pending, pod.Name)
+		}
+	}
+	sort.Strings(pending)
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionOverridesPending,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.OverridesPendingReasonPodsOverridden,
+		Message:            "every peer pod runs with its override",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(pending) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.OverridesPendingReasonPodsNotOverridden
+		cond.Message = fmt.Sprintf("pods %s run without their override: they were created before it, "+
+			"or the webhooks of the operator are not deployed", strings.Join(pending, ", "))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+	return nil
+}
+
+// refuseOverrides Sets the OverridesPending condition refusing the node
+// selector and resources overrides of the given ordinals, which the
+// StatefulSet cannot apply to single pods, and warns about it once.
+func (r *IpfsReconciler) refuseOverrides(instance *clusterv1alpha1.Ipfs, ordinals []string) {
+	message := fmt.Sprintf("the nodeSelector and resources overrides of ordinals %s are refused: only the "+
+		"webhooks of the operator apply them, and they are not served; deploy the operator with "+
+		"ENABLE_WEBHOOKS=true and its webhooks", strings.Join(ordinals, ", "))
+	current := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionOverridesPending)
+	if current == nil || current.Message != message {
+		r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.OverridesPendingReasonWebhooksDisabled,
+			"%s", message)
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionOverridesPending,
+		Status:             metav1.ConditionTrue,
+		Reason:             clusterv1alpha1.OverridesPendingReasonWebhooksDisabled,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
+
+// peerStorage Returns the volume holding the repo of the named peer pod, or
+// nil when it has not been claimed yet.
+func (r *IpfsReconciler) peerStorage(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	podName string,
+) (*clusterv1alpha1.PeerStorage, error) {
+	claim := corev1.PersistentVolumeClaim{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: "ipfs-storage-" + podName}
+	if err := r.Get(ctx, key, &claim); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cannot get volume claim %s: %w", key.Name, err)
+	}
+	storage := &clusterv1alpha1.PeerStorage{Size: claim.Spec.Resources.Requests[corev1.ResourceStorage]}
+	if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+		storage.Size = capacity
+	}
+	if claim.Spec.StorageClassName != nil {
+		storage.StorageClassName = *claim.Spec.StorageClassName
+	}
+	return storage, nil
+}
+
+//+kubebuilder:webhook:path=/mutate-ipfs-peer-pod,mutating=true,failurePolicy=ignore,sideEffects=None,groups="",resources=pods,verbs=create,versions=v1,name=mpeerpod.ipfs.io,admissionReviewVersions=v1
+
+// PeerPodOverrides Applies the node selector and the resources of their
+// override to the peer pods as they are created, which the StatefulSet
+// cannot vary from one pod to the next, and refuses the pods of the peer
+// the cordon-peer annotation names. The other pods are left unchanged.
+type PeerPodOverrides struct {
+	// Reader Reads the clusters, usually from the cache of the manager.
+	Reader client.Reader
+}
+
+var _ admission.Handler = PeerPodOverrides{}
+
+// Handle Patches the pod of a peer with its override, if any, unless the
+// peer is cordoned.
+func (h PeerPodOverrides) Handle(ctx context.Context, req admission.Request) admission.Response {
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if pod.Labels[labelManagedBy] != managedBy || pod.Labels[labelComponent] != componentPeer {
+		return admission.Allowed("not a peer pod")
+	}
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = req.Namespace
+	}
+	instance := clusterv1alpha1.Ipfs{}
+	err := h.Reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: pod.Labels[labelInstance]}, &instance)
+	if errors.IsNotFound(err) {
+		return admission.Allowed("the cluster of the peer does not exist")
+	}
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if ordinal, ok := cordonedOrdinal(&instance); ok && ordinal == peerOrdinal(pod.Name) {
+		return admission.Denied(fmt.Sprintf("peer %s is cordoned through the %s annotation", pod.Name,
+			clusterv1alpha1.AnnotationCordonPeer))
+	}
+	override := instance.Spec.OverrideFor(peerOrdinal(pod.Name))
+	if override == nil || !podOverride(override) {
+		return admission.Allowed("the peer has no override")
+	}
+	applyOverride(&instance, &pod, override)
+	patched, err := json.Marshal(&pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, patched)
+}
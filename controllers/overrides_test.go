@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs peer overrides", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	fast := "fast"
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	get := func() *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	// peerPod Returns a pod of the given peer as the StatefulSet creates it.
+	peerPod := func(ordinal int32) *corev1.Pod {
+		instance := get()
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = key.Namespace
+		pod.Labels = ipfsLabels(instance, componentPeer)
+		pod.Spec.Containers = []corev1.Container{
+			{Name: "ipfs", Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("2Gi"),
+			}}},
+			{Name: "ipfs-cluster"},
+		}
+		return pod
+	}
+
+	// admit Returns the paths the webhook patches in the pod.
+	admit := func(pod *corev1.Pod) []string {
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		resp := PeerPodOverrides{Reader: fakeClient}.Handle(ctx, admission.Request{
+			AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: key.Namespace,
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(resp.Allowed).To(BeTrue())
+		paths := []string{}
+		for _, patch := range resp.Patches {
+			paths = append(paths, patch.Path)
+		}
+		return paths
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "overrides"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "100Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Overrides = []clusterv1alpha1.PeerOverride{
+			{Ordinals: []string{"0-1"}, IpfsStorage: "2Ti", StorageClassName: &fast},
+			{
+				Ordinals:     []string{"2"},
+				NodeSelector: map[string]string{"disktype": "ssd"},
+				Resources: &corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				}},
+			},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Webhooks: true}
+	})
+
+	It("creates the volumes of the overridden peers ahead of the StatefulSet", func() {
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+
+		for _, ordinal := range []int32{0, 1} {
+			claim := &corev1.PersistentVolumeClaim{}
+			Expect(fakeClient.Get(ctx, types.NamespacedName{
+				Namespace: key.Namespace, Name: ipfsClaimName(get(), ordinal),
+			}, claim)).To(Succeed())
+			Expect(claim.Spec.Resources.Requests[corev1.ResourceStorage]).To(Equal(resource.MustParse("2Ti")))
+			Expect(claim.Spec.StorageClassName).To(Equal(&fast))
+		}
+		claim := &corev1.PersistentVolumeClaim{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: ipfsClaimName(get(), 2)},
+			claim)).To(Succeed())
+		Expect(claim.Spec.Resources.Requests[corev1.ResourceStorage]).To(Equal(resource.MustParse("100Gi")))
+		Expect(claim.Spec.StorageClassName).To(BeNil())
+
+		By("reporting the storage of each peer")
+		Expect(fakeClient.Create(ctx, peerPod(0))).To(Succeed())
+		reconcile()
+		peers := get().Status.Peers
+		Expect(peers).To(HaveLen(1))
+		Expect(peers[0].Storage).NotTo(BeNil())
+		Expect(peers[0].Storage.Size).To(Equal(resource.MustParse("2Ti")))
+		Expect(peers[0].Storage.StorageClassName).To(Equal(fast))
+	})
+
+	It("patches the placement and the resources of the overridden peer pods", func() {
+		Expect(admit(peerPod(2))).To(ContainElements("/spec/nodeSelector",
+			"/spec/containers/0/resources/limits/memory"))
+
+		pod := peerPod(2)
+		instance := get()
+		applyOverride(instance, pod, instance.Spec.OverrideFor(2))
+		Expect(pod.Spec.NodeSelector).To(Equal(map[string]string{"disktype": "ssd"}))
+		limits := pod.Spec.Containers[0].Resources.Limits
+		Expect(limits[corev1.ResourceMemory]).To(Equal(resource.MustParse("8Gi")))
+		Expect(limits[corev1.ResourceCPU]).To(Equal(resource.MustParse("1")))
+		Expect(pod.Spec.Containers[1].Resources.Limits).To(BeEmpty())
+		Expect(podOverridden(pod, instance.Spec.OverrideFor(2))).To(BeTrue())
+
+		By("leaving the peers without a pod override alone")
+		Expect(admit(peerPod(0))).To(BeEmpty())
+
+		By("leaving the other pods alone")
+		other := peerPod(2)
+		other.Labels = map[string]string{labelName: "other"}
+		Expect(admit(other)).To(BeEmpty())
+	})
+
+	It("flags the peer pods running without their override", func() {
+		instance := get()
+		Expect(fakeClient.Create(ctx, peerPod(2))).To(Succeed())
+		Expect(reconciler.syncOverrides(ctx, instance)).To(Succeed())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionOverridesPending)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Message).To(ContainSubstring(peerPodName(instance, 2)))
+
+		pod := peerPod(2)
+		Expect(fakeClient.Delete(ctx, pod)).To(Succeed())
+		applyOverride(instance, pod, instance.Spec.OverrideFor(2))
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+		Expect(reconciler.syncOverrides(ctx, instance)).To(Succeed())
+		cond = meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionOverridesPending)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("refuses the pod overrides when the webhooks are not served", func() {
+		reconciler.Webhooks = false
+		instance := get()
+		before, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconciler.syncOverrides(ctx, instance)).To(Succeed())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionOverridesPending)
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.OverridesPendingReasonWebhooksDisabled))
+		Expect(cond.Message).To(ContainSubstring("ordinals 2 are refused"))
+
+		By("leaving the peers running as they are")
+		instance.Spec.Overrides[1].NodeSelector["disktype"] = "nvme"
+		after, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).To(Equal(before))
+
+		By("dropping the condition once the pod overrides are removed")
+		instance.Spec.Overrides = instance.Spec.Overrides[:1]
+		Expect(reconciler.syncOverrides(ctx, instance)).To(Succeed())
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionOverridesPending)).To(BeNil())
+	})
+
+	It("rolls the peers when the overrides of their pods change", func() {
+		instance := get()
+		before, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+
+		instance.Spec.Overrides[0].IpfsStorage = "4Ti"
+		unchanged, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unchanged).To(Equal(before))
+
+		instance.Spec.Overrides[1].NodeSelector["disktype"] = "nvme"
+		after, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(after).NotTo(Equal(before))
+	})
+})
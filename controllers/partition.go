@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+// maxDivergedPins Is how many items still diverged after a post-partition
+// pass are listed in the status.
+const maxDivergedPins = 20
+
+// partitionRecoveryEnabled Returns whether the pinset is recovered once the
+// mesh heals from a partition.
+func partitionRecoveryEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.PartitionRecovery == nil || !m.Spec.PartitionRecovery.Disabled
+}
+
+// schedulePostPartitionAudit Queues a post-partition pass when the mesh is
+// found complete after having been degraded. A single pass runs for each
+// healing of the mesh, however many checks find it complete afterwards.
+func schedulePostPartitionAudit(instance *clusterv1alpha1.Ipfs, wasDegraded bool, now metav1.Time) {
+	if !wasDegraded || instance.Status.Mesh.IncompleteSince != nil || !partitionRecoveryEnabled(instance) {
+		return
+	}
+	instance.Status.PostPartitionAudit = &clusterv1alpha1.PostPartitionAudit{HealedAt: now}
+}
+
+// syncPostPartitionAudit Runs the pending post-partition pass: the peers
+// which accepted pinset updates on both sides of a partition may disagree
+// about the pinset once they reach each other again. The state of every item
+// on every peer is compared with its allocations, the diverged items are
+// recovered, and those still diverged afterwards are recorded and reported.
+// The pass stays pending while the REST API cannot be reached.
+func (r *IpfsReconciler) syncPostPartitionAudit(ctx context.Context, instance *clusterv1alpha1.Ipfs) {
+	log := ctrllog.FromContext(ctx)
+	audit := instance.Status.PostPartitionAudit
+	if audit == nil || audit.CompletedAt != nil || !partitionRecoveryEnabled(instance) {
+		return
+	}
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		log.Info("cannot reach the cluster API, postponing the post-partition pass", "error", err.Error())
+		return
+	}
+	infos, err := api.StatusAll(ctx)
+	if err != nil {
+		log.Info("cannot get the status of the pinset, postponing the post-partition pass", "error", err.Error())
+		return
+	}
+	var recovered int32
+	var diverged []clusterv1alpha1.DivergedPin
+	for i := range infos {
+		info := &infos[i]
+		if len(divergedPeers(info)) == 0 {
+			continue
+		}
+		if after, err := api.Recover(ctx, string(info.Cid)); err != nil {
+			log.Info("cannot recover a diverged pin", "cid", info.Cid, "error", err.Error())
+		} else {
+			info = after
+		}
+		if peers := divergedPeers(info); len(peers) > 0 {
+			diverged = append(diverged, clusterv1alpha1.DivergedPin{CID: string(info.Cid), Peers: peers})
+			continue
+		}
+		recovered++
+	}
+
+	now := metav1.Now()
+	audit.CompletedAt = &now
+	audit.Checked = int32(len(infos))
+	audit.Recovered = recovered
+	audit.DivergedCount = int32(len(diverged))
+	audit.Diverged = nil
+	if len(diverged) > maxDivergedPins {
+		audit.Diverged = diverged[:maxDivergedPins]
+	} else if len(diverged) > 0 {
+		audit.Diverged = diverged
+	}
+	if len(diverged) == 0 {
+		r.eventf(instance, corev1.EventTypeNormal, "PostPartitionAuditComplete",
+			"the mesh healed, %d of %d pins were recovered and none is diverged", recovered, len(infos))
+		return
+	}
+	cids := make([]string, 0, len(audit.Diverged))
+	for _, pin := range audit.Diverged {
+		cids = append(cids, pin.CID)
+	}
+	r.eventf(instance, corev1.EventTypeWarning, "PostPartitionDivergence",
+		"the mesh healed, %d pins are still diverged after recovering them: %s",
+		len(diverged), strings.Join(cids, ", "))
+}
+
+// divergedPeers Returns the sorted names of the peers whose state of the pin
+// disagrees with its allocations: the allocated peers which neither pin it
+// nor are pinning it, and the peers pinning it without being allocated it. A
+// pin without allocations is allocated to every peer.
+func divergedPeers(info *clusterapi.GlobalPinInfo) []string {
+	allocated := make(map[string]bool, len(info.Allocations))
+	for _, id := range info.Allocations {
+		allocated[id] = true
+	}
+	var peers []string
+	for id, state := range info.PeerMap {
+		var agrees bool
+		if len(allocated) == 0 || allocated[id] {
+			agrees = state.Status == clusterapi.TrackerStatusPinned ||
+				state.Status == clusterapi.TrackerStatusPinning ||
+				state.Status == clusterapi.TrackerStatusPinQueued
+		} else {
+			agrees = state.Status == clusterapi.TrackerStatusRemote ||
+				state.Status == clusterapi.TrackerStatusUnpinned ||
+				state.Status == clusterapi.TrackerStatusUnpinning ||
+				state.Status == clusterapi.TrackerStatusUnpinQueued
+		}
+		if agrees {
+			continue
+		}
+		name := state.Peername
+		if name == "" {
+			name = id
+		}
+		peers = append(peers, name)
+	}
+	sort.Strings(peers)
+	return peers
+}
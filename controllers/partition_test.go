@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs post-partition audit", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+		nodes      []*kubofake.Node
+	)
+
+	// check Runs a mesh check as if the previous one happened the given time
+	// ago, then the pending post-partition pass.
+	check := func(ago time.Duration) {
+		if mesh := instance.Status.Mesh; mesh != nil {
+			mesh.CheckedAt = metav1.NewTime(time.Now().Add(-ago))
+			if since := mesh.IncompleteSince; since != nil {
+				*since = metav1.NewTime(since.Add(-ago))
+			}
+		}
+		Expect(reconciler.syncMesh(ctx, instance)).To(Succeed())
+		reconciler.syncPostPartitionAudit(ctx, instance)
+	}
+
+	// partition Cuts the kubo nodes off each other until the mesh is degraded.
+	partition := func() {
+		for _, node := range nodes {
+			node.Errs["swarm/connect"] = errors.New("failed to dial")
+		}
+		check(0)
+		check(6 * time.Minute)
+		Expect(instance.Status.PostPartitionAudit).To(BeNil())
+	}
+
+	heal := func() {
+		for _, node := range nodes {
+			delete(node.Errs, "swarm/connect")
+		}
+		check(time.Minute)
+	}
+
+	pin := func(cid string) {
+		_, err := clusterapi.New(cluster.URL()).Pin(ctx, cid, clusterapi.PinOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "partition"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+
+		cluster = clusterfake.NewCluster(
+			clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)},
+			clusterapi.Peer{ID: "12D3KooWClusterB", Peername: peerPodName(instance, 1)},
+		)
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		builder := fake.NewClientBuilder().WithObjects(credentials)
+		dialer := kubofake.NewDialer()
+		nodes = nil
+		for i, id := range []string{"12D3KooWA", "12D3KooWB"} {
+			pod := &corev1.Pod{}
+			pod.Name = peerPodName(instance, int32(i))
+			pod.Namespace = instance.Namespace
+			pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			builder = builder.WithObjects(pod)
+			node := kubofake.NewNode(id)
+			dialer.Add(instance.Namespace, pod.Name, node)
+			nodes = append(nodes, node)
+		}
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{
+			Client:   builder.Build(),
+			Kubo:     dialer,
+			Recorder: recorder,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+		pin("bafyconverged")
+		pin("bafyhealing")
+		pin("bafystuck")
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("recovers the diverged pins once after the mesh heals", func() {
+		partition()
+		cluster.Diverge("bafyhealing", "12D3KooWClusterB", clusterapi.TrackerStatusUnpinned, 1)
+		cluster.Diverge("bafystuck", "12D3KooWClusterA", clusterapi.TrackerStatusClusterErr, -1)
+
+		heal()
+		audit := instance.Status.PostPartitionAudit
+		Expect(audit).NotTo(BeNil())
+		Expect(audit.CompletedAt).NotTo(BeNil())
+		Expect(audit.Checked).To(Equal(int32(3)))
+		Expect(audit.Recovered).To(Equal(int32(1)))
+		Expect(audit.DivergedCount).To(Equal(int32(1)))
+		Expect(audit.Diverged).To(Equal([]clusterv1alpha1.DivergedPin{{
+			CID: "bafystuck", Peers: []string{peerPodName(instance, 0)},
+		}}))
+		Expect(cluster.Calls(clusterfake.RouteRecover)).To(Equal(2))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring("PostPartitionDivergence"),
+			ContainSubstring("bafystuck"))))
+
+		By("running a single pass for the healing")
+		statusCalls := cluster.Calls(clusterfake.RouteStatus)
+		check(time.Minute)
+		Expect(instance.Status.PostPartitionAudit.HealedAt).To(Equal(audit.HealedAt))
+		Expect(cluster.Calls(clusterfake.RouteStatus)).To(Equal(statusCalls))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("stays pending while the cluster API cannot be reached", func() {
+		partition()
+		cluster.FailNext(clusterfake.RouteStatus, 503)
+		heal()
+		Expect(instance.Status.PostPartitionAudit.CompletedAt).To(BeNil())
+
+		reconciler.syncPostPartitionAudit(ctx, instance)
+		Expect(instance.Status.PostPartitionAudit.CompletedAt).NotTo(BeNil())
+		Expect(instance.Status.PostPartitionAudit.DivergedCount).To(BeZero())
+		Expect(recorder.Events).To(Receive(ContainSubstring("PostPartitionAuditComplete")))
+	})
+
+	It("skips the pass when disabled", func() {
+		instance.Spec.PartitionRecovery = &clusterv1alpha1.PartitionRecoveryConfig{Disabled: true}
+		partition()
+		heal()
+		Expect(instance.Status.PostPartitionAudit).To(BeNil())
+		Expect(cluster.Calls(clusterfake.RouteStatus)).To(BeZero())
+	})
+
+	It("tells the peers disagreeing with the allocations", func() {
+		info := &clusterapi.GlobalPinInfo{
+			Allocations: []string{"A"},
+			PeerMap: map[string]clusterapi.PinInfo{
+				"A": {Peername: "peer-a", Status: clusterapi.TrackerStatusPinning},
+				"B": {Peername: "peer-b", Status: clusterapi.TrackerStatusPinned},
+				"C": {Status: clusterapi.TrackerStatusRemote},
+			},
+		}
+		Expect(divergedPeers(info)).To(Equal([]string{"peer-b"}))
+		info.Allocations = nil
+		Expect(divergedPeers(info)).To(Equal([]string{"C"}))
+	})
+})
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// peerDirectoryKey Is the key of the peer directory ConfigMap holding the
+	// peers, as a JSON object keyed by pod name.
+	peerDirectoryKey = "peers.json"
+	// peerDirectoryMaxSize Bounds the size of the peer directory, well below
+	// the 1MiB a ConfigMap may hold.
+	peerDirectoryMaxSize = 512 * 1024
+)
+
+// directoryEntry Is the entry of a peer in the peer directory.
+type directoryEntry struct {
+	// Node Is the node the pod of the peer is scheduled on.
+	Node string `json:"node,omitempty"`
+	// Ready Is false while the pod of the peer is not ready, in which case it
+	// lists no addresses.
+	Ready bool `json:"ready"`
+	// Addresses Are the multiaddresses the kubo node of the peer can be
+	// dialed at, ending with its peer ID.
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// peerDirectoryName Returns the name of the ConfigMap listing the peers of
+// the cluster with their node and addresses.
+func peerDirectoryName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-peers"
+}
+
+// peerDirectory Returns the entries of the peer directory, keyed by pod name.
+// Unless full, the addresses are truncated to the address of each peer
+// through the DNS name of its pod, which can always be dialed from within
+// the cluster.
+func peerDirectory(m *clusterv1alpha1.Ipfs, pods []corev1.Pod, full bool) map[string]directoryEntry {
+	statuses := make(map[string]*clusterv1alpha1.PeerStatus, len(m.Status.Peers))
+	for i := range m.Status.Peers {
+		statuses[m.Status.Peers[i].Name] = &m.Status.Peers[i]
+	}
+	entries := make(map[string]directoryEntry, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		ordinal := peerOrdinal(pod.Name)
+		if pod.DeletionTimestamp != nil || ordinal < 0 || ordinal >= peerCount(m) {
+			continue
+		}
+		entry := directoryEntry{Node: pod.Spec.NodeName, Ready: podIsReady(pod)}
+		peer := statuses[pod.Name]
+		if entry.Ready && peer != nil && peer.KuboID != "" {
+			entry.Addresses = []string{meshAddress(m, meshMember{pod: pod.Name, id: peer.KuboID})}
+			if full && peer.Swarm != nil {
+				for _, addr := range peer.Swarm.Announce {
+					if loopbackAddress(addr) {
+						continue
+					}
+					if !strings.Contains(addr, "/p2p/") {
+						addr += "/p2p/" + peer.KuboID
+					}
+					entry.Addresses = append(entry.Addresses, addr)
+				}
+			}
+		}
+		entries[pod.Name] = entry
+	}
+	return entries
+}
+
+// syncPeerDirectory Writes the peer directory ConfigMap, mapping each peer
+// pod to its node and the addresses of its kubo node, for the workloads of
+// the cluster to dial a given peer, such as the one on their node, rather
+// than going through the Service. Peers which are not ready are listed
+// without addresses. The addresses are truncated to the essential ones when
+// the directory would grow past its size limit.
+func (r *IpfsReconciler) syncPeerDirectory(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if instance.DeletionTimestamp != nil {
+		return nil
+	}
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	data, err := json.Marshal(peerDirectory(instance, pods.Items, true))
+	if err == nil && len(data) > peerDirectoryMaxSize {
+		data, err = json.Marshal(peerDirectory(instance, pods.Items, false))
+	}
+	if err != nil {
+		return fmt.Errorf("cannot encode peer directory: %w", err)
+	}
+	cm := corev1.ConfigMap{}
+	cm.Name = peerDirectoryName(instance)
+	cm.Namespace = instance.Namespace
+	_, err = controllerutil.CreateOrPatch(ctx, r.Client, &cm, func() error {
+		cm.Labels = ipfsLabels(instance, componentDirectory)
+		cm.Data = map[string]string{peerDirectoryKey: string(data)}
+		return ctrl.SetControllerReference(instance, &cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write peer directory configmap: %w", err)
+	}
+	return nil
+}
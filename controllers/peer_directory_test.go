@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs peer directory", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	// peer Creates the pod of the peer with the given ordinal on the given node.
+	peer := func(ordinal int32, node string, ready bool) {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+		pod.Spec.NodeName = node
+		if ready {
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	directory := func() map[string]directoryEntry {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: peerDirectoryName(instance)}, cm)).To(Succeed())
+		Expect(cm.Labels).To(HaveKeyWithValue(labelComponent, componentDirectory))
+		entries := map[string]directoryEntry{}
+		Expect(json.Unmarshal([]byte(cm.Data[peerDirectoryKey]), &entries)).To(Succeed())
+		return entries
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "directory"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{
+			{
+				Name:   peerPodName(instance, 0),
+				KuboID: "12D3KooWA",
+				Swarm: &clusterv1alpha1.SwarmAddresses{
+					Listen: []string{"/ip4/0.0.0.0/tcp/4001"},
+					Announce: []string{
+						"/ip4/127.0.0.1/tcp/4001/p2p/12D3KooWA",
+						"/ip4/10.0.0.1/tcp/4001/p2p/12D3KooWA",
+						"/ip4/10.0.0.1/udp/4001/quic",
+					},
+				},
+			},
+			{Name: peerPodName(instance, 1), KuboID: "12D3KooWB"},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("maps each peer to its node and addresses, marking those not ready", func() {
+		peer(0, "node-a", true)
+		peer(1, "node-b", false)
+
+		Expect(reconciler.syncPeerDirectory(ctx, instance)).To(Succeed())
+		entries := directory()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[peerPodName(instance, 0)]).To(Equal(directoryEntry{
+			Node:  "node-a",
+			Ready: true,
+			Addresses: []string{
+				"/dns4/ipfs-cluster-directory-0.ipfs-cluster-directory.default.svc/tcp/4001/p2p/12D3KooWA",
+				"/ip4/10.0.0.1/tcp/4001/p2p/12D3KooWA",
+				"/ip4/10.0.0.1/udp/4001/quic/p2p/12D3KooWA",
+			},
+		}))
+		Expect(entries[peerPodName(instance, 1)]).To(Equal(directoryEntry{Node: "node-b"}))
+	})
+
+	It("truncates the addresses once the directory grows too large", func() {
+		peer(0, "node-a", true)
+		for i := 0; len(instance.Status.Peers[0].Swarm.Announce)*20 < peerDirectoryMaxSize; i++ {
+			instance.Status.Peers[0].Swarm.Announce = append(instance.Status.Peers[0].Swarm.Announce,
+				fmt.Sprintf("/ip4/10.0.%d.%d/tcp/4001", i/256%256, i%256))
+		}
+
+		Expect(reconciler.syncPeerDirectory(ctx, instance)).To(Succeed())
+		Expect(directory()[peerPodName(instance, 0)].Addresses).To(ConsistOf(
+			"/dns4/ipfs-cluster-directory-0.ipfs-cluster-directory.default.svc/tcp/4001/p2p/12D3KooWA"))
+	})
+})
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/maddr"
+)
+
+// peeringAddrInfos Returns the peers of the peering entries of the spec, with
+// their normalized addresses grouped by peer and sorted by ID, so that the
+// rendered configuration only changes with the entries.
+func peeringAddrInfos(m *clusterv1alpha1.Ipfs) ([]*peer.AddrInfo, error) {
+	addrs, err := maddr.Normalize("spec.peering", m.Spec.Peering, maddr.PeerRequired)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[peer.ID]*peer.AddrInfo{}
+	infos := []*peer.AddrInfo{}
+	for _, addr := range addrs {
+		parsed, err := peer.AddrInfoFromP2pAddr(ma.StringCast(addr))
+		if err != nil {
+			return nil, fmt.Errorf("cannot split the peer ID of %s: %w", addr, err)
+		}
+		ai, ok := byID[parsed.ID]
+		if !ok {
+			ai = &peer.AddrInfo{ID: parsed.ID}
+			byID[parsed.ID] = ai
+			infos = append(infos, ai)
+		}
+		ai.Addrs = append(ai.Addrs, parsed.Addrs...)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
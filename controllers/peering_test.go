@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs peering", func() {
+	const (
+		first  = "12D3KooWQ8sp1QoAbJRHUFveCdD1tyGPGxB2f3mWupSLaXQBt8Qv"
+		second = "12D3KooWSAxzBsGMkwFHQCtcCCLjDYS8s2KW5hC7dPoZkh6mXTe8"
+	)
+
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler = &IpfsReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme}
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "peering"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+	})
+
+	It("groups the addresses of the entries by peer in a stable order", func() {
+		instance.Spec.Peering = []string{
+			"/ip4/198.51.100.1/tcp/4001/p2p/" + second,
+			" /dns4/peer.example.com/tcp/4001/ipfs/" + second,
+			"/ip4/198.51.100.2/tcp/4001/p2p/" + first,
+			"/dns4/peer.example.com/tcp/4001/p2p/" + second,
+			"/ip4/198.51.100.2/udp/4001/quic-v1/webtransport/p2p/" + first,
+		}
+		infos, err := peeringAddrInfos(instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(HaveLen(2))
+		Expect(infos[0].ID.String()).To(Equal(first))
+		Expect(infos[0].Addrs).To(HaveLen(2))
+		Expect(infos[1].ID.String()).To(Equal(second))
+		Expect(infos[1].Addrs).To(HaveLen(2))
+		Expect(infos[1].Addrs[0].String()).To(Equal("/dns4/peer.example.com/tcp/4001"))
+
+		By("rendering the same configuration whatever the order of the entries")
+		cm := &corev1.ConfigMap{}
+		mut, _ := reconciler.configMapScripts(ctx, instance, cm)
+		Expect(mut()).To(Succeed())
+		rendered := cm.Data["configure-ipfs.sh"]
+		Expect(rendered).To(ContainSubstring(first))
+
+		instance.Spec.Peering[0], instance.Spec.Peering[3] = instance.Spec.Peering[3], instance.Spec.Peering[0]
+		mut, _ = reconciler.configMapScripts(ctx, instance, cm)
+		Expect(mut()).To(Succeed())
+		Expect(cm.Data["configure-ipfs.sh"]).To(Equal(rendered))
+	})
+
+	It("names the entry which is not the address of a peer", func() {
+		instance.Spec.Peering = []string{"/ip4/198.51.100.1/tcp/4001/p2p/" + first, "/ip4/198.51.100.2/tcp/4001"}
+		_, err := peeringAddrInfos(instance)
+		Expect(err).To(MatchError(
+			`spec.peering[1]: invalid multiaddress "/ip4/198.51.100.2/tcp/4001": must end with /p2p/ and the peer ID`))
+	})
+})
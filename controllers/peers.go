@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// peerStatus Returns the status entry of the named peer, adding it if needed.
+func peerStatus(instance *clusterv1alpha1.Ipfs, name string) *clusterv1alpha1.PeerStatus {
+	for i := range instance.Status.Peers {
+		if instance.Status.Peers[i].Name == name {
+			return &instance.Status.Peers[i]
+		}
+	}
+	instance.Status.Peers = append(instance.Status.Peers, clusterv1alpha1.PeerStatus{Name: name})
+	return &instance.Status.Peers[len(instance.Status.Peers)-1]
+}
+
+// syncPeers Records the images, configuration and volume each peer actually
+// runs with, and sets the VersionSkew and ConfigSkew conditions when peers disagree with
+// the resolved spec or with each other. Peers beyond the number of replicas
+// are dropped from the status.
+func (r *IpfsReconciler) syncPeers(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+	configHash string,
+) error {
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return fmt.Errorf("cannot list peer pods: %w", err)
+	}
+
+	peers := instance.Status.Peers[:0]
+	for i := range instance.Status.Peers {
+		if peerOrdinal(instance.Status.Peers[i].Name) < peerCount(instance) {
+			peers = append(peers, instance.Status.Peers[i])
+		} else {
+			peerRepoUtilization.DeleteLabelValues(instance.Namespace, instance.Name, instance.Status.Peers[i].Name)
+			peerMissingConnections.DeleteLabelValues(instance.Namespace, instance.Name, instance.Status.Peers[i].Name)
+			forgetPeerBandwidth(instance, instance.Status.Peers[i].Name)
+		}
+	}
+	instance.Status.Peers = peers
+
+	clusterIDs, err := r.clusterPeerIDs(ctx, instance)
+	if err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || peerOrdinal(pod.Name) >= peerCount(instance) {
+			continue
+		}
+		peer := peerStatus(instance, pod.Name)
+		if peer.ID == "" {
+			peer.ID = clusterIDs[peerOrdinal(pod.Name)]
+		}
+		peer.NodeName = pod.Spec.NodeName
+		peer.IpfsImage = runningImage(pod, "ipfs")
+		peer.ClusterImage = runningImage(pod, "ipfs-cluster")
+		peer.ConfigHash = pod.Annotations[clusterv1alpha1.AnnotationConfigHash]
+		peer.Revision = pod.Labels[appsv1.StatefulSetRevisionLabel]
+		if peer.Storage, err = r.peerStorage(ctx, instance, pod.Name); err != nil {
+			return err
+		}
+		if resolved.Spec.Seed != nil {
+			r.syncSeeded(instance, pod, peer)
+		}
+		syncRepoInitialized(pod, peer)
+	}
+	syncRepoLayout(instance, resolved)
+	sort.Slice(instance.Status.Peers, func(i, j int) bool {
+		return peerOrdinal(instance.Status.Peers[i].Name) < peerOrdinal(instance.Status.Peers[j].Name)
+	})
+
+	versionSkew := versionSkew(instance.Status.Peers, resolved.Spec.IpfsImage, resolved.Spec.ClusterImage)
+	configSkew := configSkew(instance.Status.Peers, configHash)
+	setSkewCondition(instance, clusterv1alpha1.ConditionVersionSkew, versionSkew)
+	setSkewCondition(instance, clusterv1alpha1.ConditionConfigSkew, configSkew)
+	skewedPeers.WithLabelValues(instance.Namespace, instance.Name).Set(float64(len(union(versionSkew, configSkew))))
+	return nil
+}
+
+// clusterPeerIDs Returns the ipfs-cluster peer IDs the operator stores for
+// the peers of the cluster, keyed by ordinal. The ID of a peer is only
+// recorded until the peer has one, so that an identity being rotated is only
+// reported once the peer joined with it.
+func (r *IpfsReconciler) clusterPeerIDs(ctx context.Context, instance *clusterv1alpha1.Ipfs) (map[int32]string, error) {
+	sec, err := r.configSecret(ctx, instance.Namespace, instance.Name)
+	if sec == nil || err != nil {
+		return nil, err
+	}
+	ids := map[int32]string{}
+	if id, err := peerIDOf(sec.Data[bootstrapPrivateKeyKey]); err == nil {
+		ids[0] = id.String()
+	}
+	for ordinal := int32(1); ordinal < peerCount(instance); ordinal++ {
+		idKey, _ := peerIdentityKeys(ordinal)
+		if id, ok := sec.Data[idKey]; ok {
+			ids[ordinal] = string(id)
+		}
+	}
+	return ids, nil
+}
+
+// peerOrdinal Returns the StatefulSet ordinal of the named peer pod, or -1
+// when the name carries none.
+func peerOrdinal(name string) int32 {
+	ordinal, err := strconv.ParseInt(name[strings.LastIndex(name, "-")+1:], 10, 32)
+	if err != nil {
+		return -1
+	}
+	return int32(ordinal)
+}
+
+// runningImage Returns the image the named container of the pod runs, as
+// reported by its status, or the requested one until it started.
+func runningImage(pod *corev1.Pod, container string) string {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == container && cs.Image != "" {
+			return cs.Image
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == container {
+			return c.Image
+		}
+	}
+	return ""
+}
+
+// sameImage Returns whether both references name the same image, ignoring
+// the default registry which the container runtime may add.
+func sameImage(a, b string) bool {
+	normalize := func(ref string) string {
+		ref = strings.TrimPrefix(ref, "docker.io/")
+		return strings.TrimPrefix(ref, "library/")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// versionSkew Returns the names of the peers running images other than the
+// requested ones. Until a peer has started, it is not reported.
+func versionSkew(peers []clusterv1alpha1.PeerStatus, ipfsImage, clusterImage string) []string {
+	var skewed []string
+	for _, p := range peers {
+		if (p.IpfsImage != "" && !sameImage(p.IpfsImage, ipfsImage)) ||
+			(p.ClusterImage != "" && !sameImage(p.ClusterImage, clusterImage)) {
+			skewed = append(skewed, p.Name)
+		}
+	}
+	return skewed
+}
+
+// configSkew Returns the names of the peers started with a configuration
+// other than the current one.
+func configSkew(peers []clusterv1alpha1.PeerStatus, configHash string) []string {
+	var skewed []string
+	for _, p := range peers {
+		if p.ConfigHash != configHash {
+			skewed = append(skewed, p.Name)
+		}
+	}
+	return skewed
+}
+
+func union(a, b []string) []string {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, s := range append(append([]string{}, a...), b...) {
+		set[s] = struct{}{}
+	}
+	names := make([]string, 0, len(set))
+	for s := range set {
+		names = append(names, s)
+	}
+	return names
+}
+
+func setSkewCondition(instance *clusterv1alpha1.Ipfs, condType string, skewed []string) {
+	cond := metav1.Condition{
+		Type:               condType,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.SkewReasonPeersAgree,
+		Message:            "every peer matches the spec",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(skewed) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.SkewReasonPeersDiffer
+		cond.Message = fmt.Sprintf("peers differing from the spec: %s", strings.Join(skewed, ", "))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs peer version skew", func() {
+	const configHash = "config-1"
+
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		resolved   *clusterv1alpha1.Ipfs
+	)
+
+	// peer Creates the pod of the peer with the given ordinal, running the
+	// given kubo image once started.
+	peer := func(ordinal int32, running string) {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+		pod.Annotations = map[string]string{clusterv1alpha1.AnnotationConfigHash: configHash}
+		pod.Spec.Containers = []corev1.Container{
+			{Name: "ipfs", Image: ipfsImage},
+			{Name: "ipfs-cluster", Image: ipfsClusterImage},
+		}
+		if running != "" {
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+				{Name: "ipfs", Image: running},
+				{Name: "ipfs-cluster", Image: "docker.io/" + ipfsClusterImage},
+			}
+		}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	skew := func() *metav1.Condition {
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionVersionSkew)
+	}
+
+	gauge := func() float64 {
+		return testutil.ToFloat64(skewedPeers.WithLabelValues(instance.Namespace, instance.Name))
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "skew"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 3
+		resolved = builtinDefaults().apply(instance)
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	AfterEach(func() {
+		skewedPeers.DeleteLabelValues(instance.Namespace, instance.Name)
+	})
+
+	It("reports the peers running other image tags than the spec", func() {
+		peer(0, "docker.io/"+ipfsImage)
+		peer(1, "ipfs/go-ipfs:v0.11.0")
+		peer(2, "")
+
+		Expect(reconciler.syncPeers(ctx, instance, resolved, configHash)).To(Succeed())
+		Expect(instance.Status.Peers).To(HaveLen(3))
+		Expect(instance.Status.Peers[1].IpfsImage).To(Equal("ipfs/go-ipfs:v0.11.0"))
+		Expect(instance.Status.Peers[2].IpfsImage).To(Equal(ipfsImage))
+		Expect(skew().Status).To(Equal(metav1.ConditionTrue))
+		Expect(skew().Reason).To(Equal(clusterv1alpha1.SkewReasonPeersDiffer))
+		Expect(skew().Message).To(Equal("peers differing from the spec: " + peerPodName(instance, 1)))
+		Expect(gauge()).To(Equal(1.0))
+
+		By("counting a peer differing both in version and configuration once")
+		Expect(reconciler.syncPeers(ctx, instance, resolved, "config-2")).To(Succeed())
+		Expect(gauge()).To(Equal(3.0))
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions,
+			clusterv1alpha1.ConditionConfigSkew)).To(BeTrue())
+	})
+
+	It("clears the skew once every peer runs the requested tags", func() {
+		peer(0, ipfsImage)
+		peer(1, "docker.io/"+ipfsImage)
+		peer(2, "")
+
+		Expect(reconciler.syncPeers(ctx, instance, resolved, configHash)).To(Succeed())
+		Expect(skew().Status).To(Equal(metav1.ConditionFalse))
+		Expect(skew().Reason).To(Equal(clusterv1alpha1.SkewReasonPeersAgree))
+		Expect(gauge()).To(BeZero())
+	})
+})
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=selfsubjectaccessreviews,verbs=create
+
+const (
+	verbsRead   = "get;list;watch"
+	verbsManage = "get;list;watch;create;update;patch;delete"
+)
+
+// permission Is a permission the operator needs on the objects of a kind,
+// as granted by the RBAC markers of the controllers.
+type permission struct {
+	kind        schema.GroupVersionKind
+	resource    string
+	subresource string
+	verbs       string
+}
+
+// String Returns the permission as the rules of a role list it.
+func (p permission) String() string {
+	resource := p.resource
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	group := p.kind.Group
+	if group == "" {
+		group = "core"
+	}
+	return fmt.Sprintf("%s/%s:%s", group, resource, p.verbs)
+}
+
+// requiredPermissions Are the permissions the operator checks it has at
+// startup. They mirror the RBAC markers, which the tests check.
+var requiredPermissions = []permission{
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "Ipfs"}, "ipfs", "", verbsManage},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "Ipfs"}, "ipfs", "status",
+		"get;update;patch"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "Ipfs"}, "ipfs", "finalizers",
+		"update"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "CircuitRelay"}, "circuitrelays",
+		"", verbsManage},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsOperatorConfig"},
+		"ipfsoperatorconfigs", "", verbsRead},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsOperatorConfig"},
+		"ipfsoperatorconfigs", "status", "get;update;patch"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsContent"},
+		"ipfscontents", "", verbsRead},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsContent"},
+		"ipfscontents", "status", "get;update;patch"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsIngest"},
+		"ipfsingests", "", "get;list;watch;update;patch"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsIngest"},
+		"ipfsingests", "status", "get;update;patch"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsFleetReport"},
+		"ipfsfleetreports", "", "get;list;watch;create"},
+	{schema.GroupVersionKind{Group: "cluster.ipfs.io", Version: "v1alpha1", Kind: "IpfsFleetReport"},
+		"ipfsfleetreports", "status", "get;update;patch"},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, "statefulsets", "", verbsManage},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, "daemonsets", "", verbsManage},
+	{schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, "deployments", "", verbsManage},
+	{schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, "jobs", "", "get;list;watch;create;delete"},
+	{schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, "configmaps", "", verbsManage},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, "secrets", "", verbsManage},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Service"}, "services", "", verbsManage},
+	{schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, "serviceaccounts", "", verbsManage},
+	{schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}, "persistentvolumeclaims", "", verbsManage},
+	{schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolume"}, "persistentvolumes", "",
+		"get;list;watch;update;patch"},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, "pods", "", "get;list;watch;patch;delete"},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, "pods", "status", "get;update;patch"},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Event"}, "events", "", "get;list;create;patch"},
+	{schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, "namespaces", "", "get;list"},
+	{schema.GroupVersionKind{Version: "v1", Kind: "ResourceQuota"}, "resourcequotas", "", "get;list"},
+	{schema.GroupVersionKind{Group: "storage.k8s.io", Version: "v1", Kind: "StorageClass"}, "storageclasses", "",
+		verbsRead},
+	{schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}, "ingresses", "",
+		verbsManage},
+	{schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "NetworkPolicy"}, "networkpolicies", "",
+		verbsManage},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"}, "roles", "",
+		verbsManage},
+	{schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"}, "rolebindings",
+		"", verbsManage},
+	{certificateGVK, "certificates", "", verbsManage},
+	{serviceMonitorGVK, "servicemonitors", "", verbsManage},
+}
+
+// missingPermissions Tells which permissions the operator found missing at
+// startup.
+var missingPermissions = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ipfs_operator_missing_permissions",
+		Help: "Whether the operator found it lacks each permission it needs, as of its startup.",
+	},
+	[]string{"group", "resource", "verb"},
+)
+
+// PermissionCheck Checks the operator has the permissions it needs with
+// SelfSubjectAccessReviews, once the manager starts. The kinds the cluster
+// does not serve, such as those of the Prometheus operator or cert-manager
+// when they are not installed, are skipped, so that the check only reports
+// the permissions the operator would actually miss.
+type PermissionCheck struct {
+	Client client.Client
+	Mapper meta.RESTMapper
+}
+
+// Missing Returns the permissions the operator lacks on the kinds the
+// cluster serves.
+func (c PermissionCheck) Missing(ctx context.Context) ([]permission, error) {
+	var missing []permission
+	for _, p := range requiredPermissions {
+		if _, err := c.Mapper.RESTMapping(p.kind.GroupKind(), p.kind.Version); err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, fmt.Errorf("cannot map %s: %w", p.kind, err)
+		}
+		var denied []string
+		for _, verb := range strings.Split(p.verbs, ";") {
+			review := authorizationv1.SelfSubjectAccessReview{Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:       p.kind.Group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+					Verb:        verb,
+				},
+			}}
+			if err := c.Client.Create(ctx, &review); err != nil {
+				return nil, fmt.Errorf("cannot review access to %s: %w", p, err)
+			}
+			lacking := 0.0
+			if !review.Status.Allowed {
+				denied = append(denied, verb)
+				lacking = 1
+			}
+			missingPermissions.WithLabelValues(p.kind.Group, p.resource, verb).Set(lacking)
+		}
+		if len(denied) > 0 {
+			p.verbs = strings.Join(denied, ";")
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// Start Logs the missing permissions. It never stops the manager: the
+// reconciles needing a missing permission fail and are retried once it is
+// granted.
+func (c PermissionCheck) Start(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx).WithName("permissions")
+	missing, err := c.Missing(ctx)
+	if err != nil {
+		log.Error(err, "unable to check the permissions of the operator")
+		return nil
+	}
+	for _, p := range missing {
+		log.Info("missing permission, the features needing it will fail", "permission", p.String())
+	}
+	return nil
+}
+
+// NeedLeaderElection Runs the check on every replica of the operator, as
+// each may become the leader.
+func (c PermissionCheck) NeedLeaderElection() bool {
+	return false
+}
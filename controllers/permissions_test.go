@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// accessReviewingClient Answers the access reviews of the operator as the
+// API server would, denying the given verbs on the given resources.
+type accessReviewingClient struct {
+	client.Client
+	denied map[string]string
+}
+
+func (c accessReviewingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if review, ok := obj.(*authorizationv1.SelfSubjectAccessReview); ok {
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = c.denied[attrs.Resource] != attrs.Verb
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("Ipfs permissions", func() {
+	It("generates a role granting the permissions the operator checks, without wildcards", func() {
+		data, err := os.ReadFile("../config/rbac/role.yaml")
+		Expect(err).NotTo(HaveOccurred())
+		role := rbacv1.ClusterRole{}
+		Expect(yaml.Unmarshal(data, &role)).To(Succeed())
+		granted := map[string]bool{}
+		for _, rule := range role.Rules {
+			Expect(rule.APIGroups).NotTo(ContainElement("*"))
+			Expect(rule.Resources).NotTo(ContainElement("*"))
+			Expect(rule.Verbs).NotTo(ContainElement("*"))
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					for _, verb := range rule.Verbs {
+						granted[group+"/"+resource+":"+verb] = true
+					}
+				}
+			}
+		}
+		for _, p := range requiredPermissions {
+			resource := p.resource
+			if p.subresource != "" {
+				resource += "/" + p.subresource
+			}
+			for _, verb := range strings.Split(p.verbs, ";") {
+				Expect(granted).To(HaveKey(p.kind.Group+"/"+resource+":"+verb), p.String())
+			}
+		}
+	})
+
+	It("reports the permissions missing on the kinds the cluster serves", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		for _, p := range requiredPermissions {
+			if p.kind != serviceMonitorGVK {
+				mapper.Add(p.kind, meta.RESTScopeNamespace)
+			}
+		}
+		check := PermissionCheck{
+			Client: accessReviewingClient{
+				Client: fake.NewClientBuilder().Build(),
+				denied: map[string]string{"statefulsets": "delete", "servicemonitors": "get"},
+			},
+			Mapper: mapper,
+		}
+		missing, err := check.Missing(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(HaveLen(1))
+		Expect(missing[0].String()).To(Equal("apps/statefulsets:delete"))
+		Expect(testutil.ToFloat64(missingPermissions.WithLabelValues(
+			"apps", "statefulsets", "delete"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(missingPermissions.WithLabelValues(
+			"apps", "statefulsets", "get"))).To(Equal(0.0))
+
+		By("skipping the kinds of the add-ons which are not installed")
+		Expect(mapper.RESTMapping(schema.GroupKind{Group: serviceMonitorGVK.Group, Kind: serviceMonitorGVK.Kind},
+			serviceMonitorGVK.Version)).Error().To(Satisfy(meta.IsNoMatchError))
+	})
+})
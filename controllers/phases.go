@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// reconcilePhase Groups the tracked objects the objects of the next phases
+// depend on, such as the Secrets mounted by the StatefulSet.
+type reconcilePhase struct {
+	// reason Is the reason of the Reconciled condition while the phase
+	// cannot complete.
+	reason  string
+	objects map[client.Object]controllerutil.MutateFn
+}
+
+// incompletePhase Describes the phase the reconcile stopped at.
+type incompletePhase struct {
+	reason  string
+	message string
+}
+
+// maxConcurrentWrites Bounds how many objects of a phase are applied at once.
+const maxConcurrentWrites = 4
+
+// appliedObject Is the outcome of applying a tracked object.
+type appliedObject struct {
+	obj    client.Object
+	mut    controllerutil.MutateFn
+	result controllerutil.OperationResult
+	err    error
+}
+
+// applyPhases Applies the tracked objects phase by phase. Before moving on to
+// the next phase, every object of the phase is read back, so that no object
+// is created before those it depends on exist. It returns the phase the
+// reconcile stopped at, or nil when every phase completed. The changes made
+// to the objects are logged when requested for the cluster, and the number of
+// writes issued is recorded.
+func (r *IpfsReconciler) applyPhases(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	phases []reconcilePhase,
+) *incompletePhase {
+	log := ctrllog.FromContext(ctx)
+	defaults := r.Defaults.Get()
+	diffLog, logDiffs := r.diffLogger(ctx, instance)
+	writes := 0
+	defer func() { reconcileWrites.Observe(float64(writes)) }()
+	for _, phase := range phases {
+		applied := r.sortedObjects(phase)
+		for _, a := range applied {
+			if a.mut == nil {
+				return &incompletePhase{
+					reason:  phase.reason,
+					message: fmt.Sprintf("cannot render %s %s", r.kindOf(a.obj), a.obj.GetName()),
+				}
+			}
+			if defaults.ArgoCDIgnoreExtraneous {
+				a.mut = ignoredByArgoCD(a.obj, a.mut)
+			}
+			if logDiffs {
+				a.mut = loggingDiff(diffLog, r.kindOf(a.obj), a.obj, a.mut)
+			}
+		}
+		r.applyObjects(ctx, applied)
+		var failed *appliedObject
+		for _, a := range applied {
+			if a.result != controllerutil.OperationResultNone {
+				writes++
+				log.Info("object changed", "objName", a.obj.GetName(), "objKind", r.kindOf(a.obj), "result", a.result)
+			}
+			if a.err != nil {
+				log.Error(a.err, "error creating object", "objName", a.obj.GetName(), "objKind", r.kindOf(a.obj))
+				if failed == nil {
+					failed = a
+				}
+			}
+		}
+		if failed != nil {
+			// The other objects of the phase were applied, so that the next
+			// reconcile only writes the missing ones.
+			return &incompletePhase{
+				reason:  phase.reason,
+				message: fmt.Sprintf("cannot apply %s %s: %s", r.kindOf(failed.obj), failed.obj.GetName(), failed.err),
+			}
+		}
+		for _, a := range applied {
+			if err := r.Get(ctx, client.ObjectKeyFromObject(a.obj), a.obj); err != nil {
+				return &incompletePhase{
+					reason:  phase.reason,
+					message: fmt.Sprintf("%s %s is missing: %s", r.kindOf(a.obj), a.obj.GetName(), err),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sortedObjects Returns the objects of the phase sorted by kind and name, so
+// that they are applied, logged and reported in the same order on every
+// reconcile.
+func (r *IpfsReconciler) sortedObjects(phase reconcilePhase) []*appliedObject {
+	applied := make([]*appliedObject, 0, len(phase.objects))
+	for obj, mut := range phase.objects {
+		applied = append(applied, &appliedObject{obj: obj, mut: mut})
+	}
+	sort.Slice(applied, func(i, j int) bool {
+		ki, kj := r.kindOf(applied[i].obj), r.kindOf(applied[j].obj)
+		if ki != kj {
+			return ki < kj
+		}
+		return applied[i].obj.GetName() < applied[j].obj.GetName()
+	})
+	return applied
+}
+
+// applyObjects Creates or patches the objects concurrently, at most
+// maxConcurrentWrites at a time, as the objects of a phase do not depend on
+// each other. An object is only written when it differs from the live one in
+// the cache. A failed object does not stop the others.
+func (r *IpfsReconciler) applyObjects(ctx context.Context, applied []*appliedObject) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxConcurrentWrites)
+	for _, a := range applied {
+		a := a
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			a.result, a.err = controllerutil.CreateOrPatch(ctx, r.Client, a.obj, a.mut)
+		}()
+	}
+	wg.Wait()
+}
+
+// kindOf Returns the kind of the object for messages.
+func (r *IpfsReconciler) kindOf(obj client.Object) string {
+	if gvk, err := apiutil.GVKForObject(obj, r.Scheme); err == nil {
+		return gvk.Kind
+	}
+	return fmt.Sprintf("%T", obj)
+}
+
+// waitingForPhase Returns whether the reconcile stopped before the workload
+// phase, or at a missing dependency, so that the peers were not created
+// because of a missing dependency.
+func waitingForPhase(reason string) bool {
+	switch reason {
+	case clusterv1alpha1.ReconciledReasonDependencyMissing,
+		clusterv1alpha1.ReconciledReasonIdentityNotReady,
+		clusterv1alpha1.ReconciledReasonConfigNotReady,
+		clusterv1alpha1.ReconciledReasonServiceNotReady:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// secretFailingClient Fails to create Secrets while failing is set.
+type secretFailingClient struct {
+	client.Client
+	failing bool
+}
+
+func (c *secretFailingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.Secret); ok && c.failing {
+		return fmt.Errorf("admission webhook denied the request")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// nameFailingClient Fails to create the object named failing.
+type nameFailingClient struct {
+	client.Client
+	failing string
+}
+
+func (c *nameFailingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if obj.GetName() == c.failing {
+		return fmt.Errorf("admission webhook denied the request")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("Ipfs reconcile phases", func() {
+	var (
+		ctx        context.Context
+		fakeClient *secretFailingClient
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "phases"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = &secretFailingClient{
+			Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build(),
+			failing: true,
+		}
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("does not create the peers before their Secrets", func() {
+		reconcile()
+		instance := reconcile()
+
+		sts := &appsv1.StatefulSet{}
+		stsKey := types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		err := fakeClient.Get(ctx, stsKey, sts)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReconciled)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.ReconciledReasonIdentityNotReady))
+		Expect(cond.Message).To(ContainSubstring("Secret"))
+		Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhasePending))
+
+		By("creating the peers once the Secrets exist")
+		fakeClient.failing = false
+		instance = reconcile()
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionReconciled)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Applying the tracked objects", func() {
+	var (
+		ctx        context.Context
+		fakeClient *writeCountingClient
+		failing    *nameFailingClient
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	// configMap Returns a tracked ConfigMap holding the given data.
+	configMap := func(name, data string) (client.Object, controllerutil.MutateFn) {
+		cm := &corev1.ConfigMap{}
+		cm.Name = name
+		cm.Namespace = instance.Namespace
+		return cm, func() error {
+			cm.Data = map[string]string{"data": data}
+			return nil
+		}
+	}
+
+	// phases Returns a phase of three ConfigMaps and a phase of one which
+	// depends on them.
+	phases := func(data string) []reconcilePhase {
+		first := map[client.Object]controllerutil.MutateFn{}
+		for _, name := range []string{"config-a", "config-b", "config-c"} {
+			obj, mut := configMap(name, data)
+			first[obj] = mut
+		}
+		obj, mut := configMap("config-d", data)
+		return []reconcilePhase{
+			{reason: clusterv1alpha1.ReconciledReasonConfigNotReady, objects: first},
+			{reason: clusterv1alpha1.ReconciledReasonWorkloadNotReady, objects: map[client.Object]controllerutil.MutateFn{
+				obj: mut,
+			}},
+		}
+	}
+
+	exists := func(name string) bool {
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: name}, &corev1.ConfigMap{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "writes"
+		instance.Namespace = "default"
+		failing = &nameFailingClient{Client: fake.NewClientBuilder().Build(), failing: "config-b"}
+		fakeClient = &writeCountingClient{Client: failing}
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme.Scheme}
+	})
+
+	It("applies the rest of a phase when an object fails and then writes only the missing ones", func() {
+		incomplete := reconciler.applyPhases(ctx, instance, phases("v1"))
+		Expect(incomplete).NotTo(BeNil())
+		Expect(incomplete.reason).To(Equal(clusterv1alpha1.ReconciledReasonConfigNotReady))
+		Expect(incomplete.message).To(Equal("cannot apply ConfigMap config-b: admission webhook denied the request"))
+		Expect(exists("config-a")).To(BeTrue())
+		Expect(exists("config-b")).To(BeFalse())
+		Expect(exists("config-c")).To(BeTrue())
+		Expect(exists("config-d")).To(BeFalse())
+		Expect(fakeClient.writes).To(Equal(int32(3)))
+
+		By("writing only the objects which are missing")
+		failing.failing = ""
+		fakeClient.writes = 0
+		Expect(reconciler.applyPhases(ctx, instance, phases("v1"))).To(BeNil())
+		Expect(exists("config-b")).To(BeTrue())
+		Expect(exists("config-d")).To(BeTrue())
+		Expect(fakeClient.writes).To(Equal(int32(2)))
+
+		By("writing nothing when nothing changed")
+		fakeClient.writes = 0
+		Expect(reconciler.applyPhases(ctx, instance, phases("v1"))).To(BeNil())
+		Expect(fakeClient.writes).To(BeZero())
+
+		By("patching every object which changed")
+		Expect(reconciler.applyPhases(ctx, instance, phases("v2"))).To(BeNil())
+		Expect(fakeClient.writes).To(Equal(int32(4)))
+	})
+
+	It("reports the first failing object in a stable order", func() {
+		failing.failing = "config-c"
+		for i := 0; i < 5; i++ {
+			incomplete := reconciler.applyPhases(ctx, instance, phases("v1"))
+			Expect(incomplete).NotTo(BeNil())
+			Expect(incomplete.message).To(ContainSubstring("ConfigMap config-c"))
+		}
+	})
+})
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+const (
+	// pinClusterIndex Maps an Ipfs resource back to the IpfsContent and
+	// IpfsIngest resources pinning to it.
+	pinClusterIndex = ".spec.clusterRef"
+	// pinHoldInterval Is how often a held pin checks whether it may be
+	// submitted, besides whenever its cluster changes.
+	pinHoldInterval = 10 * time.Second
+	// pinAgingInterval Is how long a pin is held before its priority is
+	// raised by one class, so that the low priority pins are not held forever.
+	pinAgingInterval = 10 * time.Minute
+	// highPinThreshold Is the number of high priority pins held or pinning in
+	// a cluster beyond which its low priority pins are held.
+	highPinThreshold = 4
+)
+
+// Kinds of the objects whose pins are held.
+const (
+	heldPinContent = "IpfsContent"
+	heldPinIngest  = "IpfsIngest"
+)
+
+// pinPriorities Are the priorities of the pins, the first submitted first.
+var pinPriorities = []clusterv1alpha1.PinPriority{
+	clusterv1alpha1.PinPriorityHigh, clusterv1alpha1.PinPriorityNormal, clusterv1alpha1.PinPriorityLow,
+}
+
+// heldPins Is the number of pins of each cluster held, by priority.
+var heldPins = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "ipfs_operator_held_pins",
+		Help: "Number of pins of IpfsContent and IpfsIngest resources held for each cluster, by priority.",
+	},
+	[]string{"namespace", "name", "priority"},
+)
+
+// clusterPin Is the pin of an IpfsContent or of an IpfsIngest, as the pins of
+// a cluster are queued.
+type clusterPin struct {
+	kind     string
+	name     string
+	created  metav1.Time
+	priority clusterv1alpha1.PinPriority
+	// heldSince Is when the pin was first held, or nil while it is not held.
+	heldSince *metav1.Time
+}
+
+// contentClusterPin Returns the pin of the content.
+func contentClusterPin(c *clusterv1alpha1.IpfsContent) clusterPin {
+	p := clusterPin{kind: heldPinContent, name: c.Name, created: c.CreationTimestamp, priority: c.Spec.Priority}
+	if c.Status.Queue != nil {
+		p.heldSince = &c.Status.Queue.HeldSince
+	}
+	return p
+}
+
+// ingestClusterPin Returns the pin of the root of the ingest.
+func ingestClusterPin(ing *clusterv1alpha1.IpfsIngest) clusterPin {
+	p := clusterPin{kind: heldPinIngest, name: ing.Name, created: ing.CreationTimestamp, priority: ing.Spec.Priority}
+	if ing.Status.Queue != nil {
+		p.heldSince = &ing.Status.Queue.HeldSince
+	}
+	return p
+}
+
+// pinName Returns the name the pin is submitted with in the cluster of the
+// namespace. It holds the kind of the object, as an IpfsContent and an
+// IpfsIngest may share a name.
+func (p clusterPin) pinName(namespace string) string {
+	return p.kind + "/" + namespace + "/" + p.name
+}
+
+// normalizedPriority Returns the priority of the pin, Normal when unset.
+func (p clusterPin) normalizedPriority() clusterv1alpha1.PinPriority {
+	if p.priority == "" {
+		return clusterv1alpha1.PinPriorityNormal
+	}
+	return p.priority
+}
+
+// class Returns the rank of the priority of the pin as of now, 0 being the
+// highest, raised by one for every pinAgingInterval the pin was held.
+func (p clusterPin) class(now time.Time) int {
+	class := 1
+	for i, priority := range pinPriorities {
+		if p.normalizedPriority() == priority {
+			class = i
+		}
+	}
+	if p.heldSince != nil {
+		class -= int(now.Sub(p.heldSince.Time) / pinAgingInterval)
+	}
+	if class < 0 {
+		return 0
+	}
+	return class
+}
+
+// before Returns whether the pin is submitted before the other one as of now:
+// its class is higher, or its object was created first, the kind then the
+// name breaking ties.
+func (p clusterPin) before(o clusterPin, now time.Time) bool {
+	if pc, oc := p.class(now), o.class(now); pc != oc {
+		return pc < oc
+	}
+	if !p.created.Equal(&o.created) {
+		return p.created.Before(&o.created)
+	}
+	if p.kind != o.kind {
+		return p.kind < o.kind
+	}
+	return p.name < o.name
+}
+
+// degradedCause Returns why the cluster is degraded, or an empty string. It
+// is degraded once its smoke test fails, and while peers which ran are down
+// outside of a rollout. The operator leaves the replication factor of the
+// cluster at its default of every peer, so that a pin submitted meanwhile
+// would only fail on the peers down.
+func degradedCause(m *clusterv1alpha1.Ipfs) string {
+	if cond := meta.FindStatusCondition(m.Status.Conditions, clusterv1alpha1.ConditionDegraded); cond != nil &&
+		cond.Status == metav1.ConditionTrue {
+		return cond.Message
+	}
+	if m.Status.Phase != clusterv1alpha1.PhaseDegraded {
+		return ""
+	}
+	if cond := meta.FindStatusCondition(m.Status.Conditions, clusterv1alpha1.ConditionReady); cond != nil &&
+		cond.Message != "" {
+		return cond.Message
+	}
+	return "its peers are not ready"
+}
+
+// listClusterPins Returns the pins of the objects pinning to the cluster,
+// those held telling so by their status.
+func listClusterPins(ctx context.Context, c client.Reader, m *clusterv1alpha1.Ipfs) ([]clusterPin, error) {
+	var pins []clusterPin
+	contents := clusterv1alpha1.IpfsContentList{}
+	if err := c.List(ctx, &contents, client.InNamespace(m.Namespace),
+		client.MatchingFields{pinClusterIndex: m.Name}); err != nil {
+		return nil, fmt.Errorf("cannot list IpfsContent resources: %w", err)
+	}
+	for i := range contents.Items {
+		if item := &contents.Items[i]; item.Spec.ClusterRef.Name == m.Name {
+			pins = append(pins, contentClusterPin(item))
+		}
+	}
+	ingests := clusterv1alpha1.IpfsIngestList{}
+	if err := c.List(ctx, &ingests, client.InNamespace(m.Namespace),
+		client.MatchingFields{pinClusterIndex: m.Name}); err != nil {
+		return nil, fmt.Errorf("cannot list IpfsIngest resources: %w", err)
+	}
+	for i := range ingests.Items {
+		if item := &ingests.Items[i]; item.Spec.ClusterRef.Name == m.Name {
+			pins = append(pins, ingestClusterPin(item))
+		}
+	}
+	return pins, nil
+}
+
+// holdPin Returns why the pin is held, or an empty string once it may be
+// submitted, along with its position in the queue of the cluster. A pin is
+// held while its cluster is degraded, then until the pins held before it were
+// submitted: those of a higher priority, then those whose objects were
+// created first. Low priority pins are also held while more than
+// highPinThreshold high priority pins are held or pinning. The number of
+// pins held for the cluster is exported along.
+func holdPin(
+	ctx context.Context,
+	c client.Reader,
+	api *clusterapi.Client,
+	m *clusterv1alpha1.Ipfs,
+	pin clusterPin,
+) (string, int32, error) {
+	pins, err := listClusterPins(ctx, c, m)
+	if err != nil {
+		return "", 0, err
+	}
+	now := time.Now()
+	ahead, high := 0, 0
+	priorities := map[string]clusterv1alpha1.PinPriority{}
+	counts := map[clusterv1alpha1.PinPriority]int{}
+	for _, p := range pins {
+		priorities[p.pinName(m.Namespace)] = p.normalizedPriority()
+		if p.heldSince == nil || (p.kind == pin.kind && p.name == pin.name) {
+			continue
+		}
+		counts[p.normalizedPriority()]++
+		if p.before(pin, now) {
+			ahead++
+		}
+		if p.normalizedPriority() == clusterv1alpha1.PinPriorityHigh {
+			high++
+		}
+	}
+
+	var why string
+	if cause := degradedCause(m); cause != "" {
+		why = fmt.Sprintf("the pin is held until Ipfs %s recovers: %s", m.Name, cause)
+	} else if ahead > 0 {
+		why = fmt.Sprintf("the pin is held until the %d pins of Ipfs %s held before it are submitted", ahead, m.Name)
+	}
+	low := pin.class(now) == len(pinPriorities)-1
+	var pending []clusterapi.GlobalPinInfo
+	if why != "" || low {
+		if pending, err = api.Pending(ctx); err != nil {
+			// The pins the cluster is still pinning are left out.
+			ctrllog.FromContext(ctx).V(1).Info("cannot list the pending pins of the cluster", "error", err.Error())
+		}
+	}
+	if why == "" && low {
+		for _, info := range pending {
+			if priorities[info.Name] == clusterv1alpha1.PinPriorityHigh {
+				high++
+			}
+		}
+		if high > highPinThreshold {
+			why = fmt.Sprintf("the low priority pin is held while %d high priority pins of Ipfs %s are held or pinning",
+				high, m.Name)
+		}
+	}
+
+	if why != "" {
+		counts[pin.normalizedPriority()]++
+	}
+	for _, priority := range pinPriorities {
+		heldPins.WithLabelValues(m.Namespace, m.Name, string(priority)).Set(float64(counts[priority]))
+	}
+	if why == "" {
+		return "", 0, nil
+	}
+	return why, int32(len(pending) + ahead + 1), nil
+}
+
+// pinQueueStatus Returns the status of a pin held at the given position,
+// which keeps when it was first held.
+func pinQueueStatus(queue *clusterv1alpha1.PinQueueStatus, position int32) *clusterv1alpha1.PinQueueStatus {
+	if queue == nil {
+		queue = &clusterv1alpha1.PinQueueStatus{HeldSince: metav1.Now()}
+	}
+	queue.Position = position
+	return queue
+}
+
+// forgetHeldPins Deletes the number of pins held for the cluster.
+func forgetHeldPins(m *clusterv1alpha1.Ipfs) {
+	for _, priority := range pinPriorities {
+		heldPins.DeleteLabelValues(m.Namespace, m.Name, string(priority))
+	}
+}
+
+// heldPinsOf Returns a handler which enqueues the held pins of the changed
+// cluster whose objects are of the given kind, the first to submit first, so
+// that they are submitted as soon as it recovers.
+func heldPinsOf(c client.Reader, kind string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		m, ok := obj.(*clusterv1alpha1.Ipfs)
+		if !ok {
+			return nil
+		}
+		pins, err := listClusterPins(context.Background(), c, m)
+		if err != nil {
+			ctrllog.Log.Error(err, "cannot list the held pins of cluster", "name", m.Name, "namespace", m.Namespace)
+			return nil
+		}
+		held := pins[:0]
+		for _, p := range pins {
+			if p.kind == kind && p.heldSince != nil {
+				held = append(held, p)
+			}
+		}
+		now := time.Now()
+		sort.Slice(held, func(i, j int) bool {
+			return held[i].before(held[j], now)
+		})
+		requests := make([]reconcile.Request, 0, len(held))
+		for _, p := range held {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: m.Namespace, Name: p.name},
+			})
+		}
+		return requests
+	})
+}
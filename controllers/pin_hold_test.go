@@ -0,0 +1,316 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Held pins", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *ContentReconciler
+		cluster    *clusterfake.Cluster
+		instance   *clusterv1alpha1.Ipfs
+		older      *clusterv1alpha1.IpfsContent
+		younger    *clusterv1alpha1.IpfsContent
+	)
+
+	// reconcile Reconciles the content and returns it as stored.
+	reconcile := func(c *clusterv1alpha1.IpfsContent) (*clusterv1alpha1.IpfsContent, ctrl.Result) {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(c)})
+		Expect(err).NotTo(HaveOccurred())
+		stored := &clusterv1alpha1.IpfsContent{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(c), stored)).To(Succeed())
+		return stored, result
+	}
+
+	// ready Returns the Ready condition of the content.
+	ready := func(c *clusterv1alpha1.IpfsContent) *metav1.Condition {
+		return meta.FindStatusCondition(c.Status.Conditions, clusterv1alpha1.ConditionReady)
+	}
+
+	// degrade Sets whether the smoke test finds the cluster degraded.
+	degrade := func(degraded bool) {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), instance)).To(Succeed())
+		cond := metav1.Condition{
+			Type: clusterv1alpha1.ConditionDegraded, Status: metav1.ConditionFalse,
+			Reason: clusterv1alpha1.DegradedReasonSmokeTestPassed,
+		}
+		if degraded {
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = clusterv1alpha1.DegradedReasonSmokeTestFailed
+			cond.Message = "the Allocations stage failed"
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, cond)
+		Expect(fakeClient.Status().Update(ctx, instance)).To(Succeed())
+	}
+
+	// held Returns the number of pins of the cluster held with the priority,
+	// as exported.
+	held := func(priority clusterv1alpha1.PinPriority) float64 {
+		return testutil.ToFloat64(heldPins.WithLabelValues(instance.Namespace, instance.Name, string(priority)))
+	}
+
+	// prioritize Sets the priority of the content.
+	prioritize := func(c *clusterv1alpha1.IpfsContent, priority clusterv1alpha1.PinPriority) {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(c), c)).To(Succeed())
+		c.Spec.Priority = priority
+		Expect(fakeClient.Update(ctx, c)).To(Succeed())
+	}
+
+	// content Returns content adding the key of the source, created at the
+	// given time.
+	content := func(name, key string, created time.Time) *clusterv1alpha1.IpfsContent {
+		c := &clusterv1alpha1.IpfsContent{}
+		c.Name = name
+		c.Namespace = instance.Namespace
+		c.CreationTimestamp = metav1.NewTime(created)
+		c.Spec = clusterv1alpha1.IpfsContentSpec{
+			SourceRef: clusterv1alpha1.ContentSourceRef{
+				Kind: clusterv1alpha1.ContentSourceConfigMap,
+				Name: "assets",
+				Key:  key,
+			},
+			ClusterRef: clusterv1alpha1.ContentClusterRef{Name: instance.Name},
+		}
+		return c
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "store"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 1
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		source := &corev1.ConfigMap{}
+		source.Name = "assets"
+		source.Namespace = instance.Namespace
+		source.Data = map[string]string{"logo.svg": "<svg/>", "index.html": "<html/>"}
+		created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		older = content("logo", "logo.svg", created)
+		younger = content("index", "index.html", created.Add(time.Minute))
+
+		cluster = clusterfake.NewCluster(clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)})
+		dialer := kubofake.NewDialer()
+		dialer.Add(instance.Namespace, peerPodName(instance, 0), kubofake.NewNode("12D3KooWA"))
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(instance, credentials, source, older, younger).Build()
+		reconciler = &ContentReconciler{
+			Client: fakeClient,
+			Clusters: &IpfsReconciler{
+				Client: fakeClient,
+				Scheme: scheme,
+				Kubo:   dialer,
+				ClusterAPI: []clusterapi.Option{
+					clusterapi.WithBaseURL(cluster.URL()),
+					clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+		forgetHeldPins(instance)
+	})
+
+	It("tells why the cluster is degraded", func() {
+		m := &clusterv1alpha1.Ipfs{}
+		m.Status.Phase = clusterv1alpha1.PhaseRunning
+		Expect(degradedCause(m)).To(BeEmpty())
+
+		m.Status.Phase = clusterv1alpha1.PhaseDegraded
+		Expect(degradedCause(m)).To(Equal("its peers are not ready"))
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type: clusterv1alpha1.ConditionReady, Status: metav1.ConditionFalse,
+			Reason: "Test", Message: "2/3 peers ready, 3/3 updated",
+		})
+		Expect(degradedCause(m)).To(Equal("2/3 peers ready, 3/3 updated"))
+
+		m.Status.Phase = clusterv1alpha1.PhaseRunning
+		meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+			Type: clusterv1alpha1.ConditionDegraded, Status: metav1.ConditionTrue,
+			Reason: clusterv1alpha1.DegradedReasonSmokeTestFailed, Message: "the Fetch stage failed",
+		})
+		Expect(degradedCause(m)).To(Equal("the Fetch stage failed"))
+	})
+
+	It("holds the pins until the cluster recovers, unless forced", func() {
+		degrade(true)
+		waiting, result := reconcile(older)
+		Expect(ready(waiting).Reason).To(Equal(clusterv1alpha1.ContentReasonWaiting))
+		Expect(ready(waiting).Message).To(Equal(
+			"the pin is held until Ipfs store recovers: the Allocations stage failed"))
+		Expect(result.RequeueAfter).To(Equal(pinHoldInterval))
+		Expect(waiting.Status.CID).NotTo(BeEmpty())
+		Expect(waiting.Status.Pinned).To(BeFalse())
+		Expect(cluster.Calls(clusterfake.RoutePin)).To(BeZero())
+		Expect(waiting.Status.Queue).NotTo(BeNil())
+		Expect(waiting.Status.Queue.Position).To(Equal(int32(1)))
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(Equal(1.0))
+
+		By("submitting the pin of a forced content")
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(younger), younger)).To(Succeed())
+		younger.Spec.Force = true
+		Expect(fakeClient.Update(ctx, younger)).To(Succeed())
+		forced, _ := reconcile(younger)
+		Expect(ready(forced).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(Equal(1.0))
+
+		By("submitting the held pin once the cluster recovered")
+		degrade(false)
+		pinned, result := reconcile(older)
+		Expect(ready(pinned).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(result.RequeueAfter).To(BeZero())
+		Expect(pinned.Status.Queue).To(BeNil())
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(BeZero())
+	})
+
+	It("submits the held pins in the order their objects were created", func() {
+		degrade(true)
+		reconcile(younger)
+		reconcile(older)
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(Equal(2.0))
+
+		By("enqueuing the held pins, the first to submit first, when the cluster changes")
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+		heldPinsOf(fakeClient, heldPinContent).Update(event.UpdateEvent{ObjectOld: instance, ObjectNew: instance}, queue)
+		Expect(queue.Len()).To(Equal(2))
+		first, _ := queue.Get()
+		Expect(first).To(Equal(ctrl.Request{NamespacedName: client.ObjectKeyFromObject(older)}))
+
+		By("holding the younger pin until the older one was submitted")
+		degrade(false)
+		behind, _ := reconcile(younger)
+		Expect(ready(behind).Reason).To(Equal(clusterv1alpha1.ContentReasonWaiting))
+		Expect(ready(behind).Message).To(Equal(
+			"the pin is held until the 1 pins of Ipfs store held before it are submitted"))
+		Expect(behind.Status.Queue.Position).To(Equal(int32(2)))
+		submitted, _ := reconcile(older)
+		Expect(ready(submitted).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(Equal(1.0))
+		second, _ := reconcile(younger)
+		Expect(ready(second).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(BeZero())
+	})
+
+	It("submits the high priority pins first, until the others were held long", func() {
+		prioritize(younger, clusterv1alpha1.PinPriorityHigh)
+		degrade(true)
+		reconcile(older)
+		reconcile(younger)
+		Expect(held(clusterv1alpha1.PinPriorityHigh)).To(Equal(1.0))
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(Equal(1.0))
+
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+		heldPinsOf(fakeClient, heldPinContent).Update(event.UpdateEvent{ObjectOld: instance, ObjectNew: instance}, queue)
+		first, _ := queue.Get()
+		Expect(first).To(Equal(ctrl.Request{NamespacedName: client.ObjectKeyFromObject(younger)}))
+
+		By("holding the older pin of a lower priority")
+		degrade(false)
+		behind, _ := reconcile(older)
+		Expect(ready(behind).Reason).To(Equal(clusterv1alpha1.ContentReasonWaiting))
+		Expect(behind.Status.Queue.Position).To(Equal(int32(2)))
+
+		By("raising the priority of the pin held long")
+		behind.Status.Queue.HeldSince = metav1.NewTime(time.Now().Add(-pinAgingInterval - time.Minute))
+		Expect(fakeClient.Status().Update(ctx, behind)).To(Succeed())
+		aged, _ := reconcile(older)
+		Expect(ready(aged).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(held(clusterv1alpha1.PinPriorityNormal)).To(BeZero())
+		urgent, _ := reconcile(younger)
+		Expect(ready(urgent).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(held(clusterv1alpha1.PinPriorityHigh)).To(BeZero())
+	})
+
+	It("holds the low priority pins while many high priority pins are pinning", func() {
+		prioritize(older, clusterv1alpha1.PinPriorityLow)
+		api, err := reconciler.Clusters.clusterAPI(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i <= highPinThreshold; i++ {
+			urgent := content(fmt.Sprintf("urgent-%d", i), "index.html", time.Now())
+			urgent.Spec.Priority = clusterv1alpha1.PinPriorityHigh
+			Expect(fakeClient.Create(ctx, urgent)).To(Succeed())
+			cid := fmt.Sprintf("bafyurgent%d", i)
+			_, err = api.Pin(ctx, cid, clusterapi.PinOptions{Name: contentPinName(urgent)})
+			Expect(err).NotTo(HaveOccurred())
+			cluster.Queue(cid)
+		}
+
+		waiting, _ := reconcile(older)
+		Expect(ready(waiting).Reason).To(Equal(clusterv1alpha1.ContentReasonWaiting))
+		Expect(ready(waiting).Message).To(Equal(
+			"the low priority pin is held while 5 high priority pins of Ipfs store are held or pinning"))
+		Expect(waiting.Status.Queue.Position).To(Equal(int32(6)))
+		Expect(held(clusterv1alpha1.PinPriorityLow)).To(Equal(1.0))
+
+		By("submitting the pin once the high priority pins are pinned")
+		_, err = api.Recover(ctx, "bafyurgent0")
+		Expect(err).NotTo(HaveOccurred())
+		pinned, _ := reconcile(older)
+		Expect(ready(pinned).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(pinned.Status.Queue).To(BeNil())
+		Expect(held(clusterv1alpha1.PinPriorityLow)).To(BeZero())
+	})
+
+	It("tells the pins of an IpfsContent from those of an IpfsIngest of the same name", func() {
+		prioritize(older, clusterv1alpha1.PinPriorityLow)
+		api, err := reconciler.Clusters.clusterAPI(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i <= highPinThreshold; i++ {
+			twin := content(fmt.Sprintf("twin-%d", i), "index.html", time.Now())
+			Expect(fakeClient.Create(ctx, twin)).To(Succeed())
+			cid := fmt.Sprintf("bafytwin%d", i)
+			_, err = api.Pin(ctx, cid, clusterapi.PinOptions{Name: contentPinName(twin)})
+			Expect(err).NotTo(HaveOccurred())
+			cluster.Queue(cid)
+			ing := &clusterv1alpha1.IpfsIngest{}
+			ing.Name = twin.Name
+			ing.Namespace = instance.Namespace
+			ing.Spec.ClusterRef.Name = instance.Name
+			ing.Spec.Priority = clusterv1alpha1.PinPriorityHigh
+			Expect(fakeClient.Create(ctx, ing)).To(Succeed())
+			Expect(ingestPinName(ing)).NotTo(Equal(contentPinName(twin)))
+		}
+
+		pinned, _ := reconcile(older)
+		Expect(ready(pinned).Reason).To(Equal(clusterv1alpha1.ContentReasonPinned))
+		Expect(held(clusterv1alpha1.PinPriorityLow)).To(BeZero())
+	})
+})
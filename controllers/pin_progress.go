@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+const (
+	// pinProgressInterval Is how often a submitted pin is checked until every
+	// peer it is allocated to pinned it.
+	pinProgressInterval = 30 * time.Second
+	// defaultPinStallAfter Is how long a pin may go without progress before
+	// it is stalled, unless the spec of its object tells otherwise.
+	defaultPinStallAfter = 30 * time.Minute
+)
+
+// pinState Is where a submitted pin stands.
+type pinState int
+
+const (
+	pinStatePinning pinState = iota
+	pinStateStalled
+	pinStateTimedOut
+	pinStatePinned
+)
+
+// pinLimits Are how long a pin may go without progress, and take in all, as
+// the spec of its object tells.
+type pinLimits struct {
+	stallAfter time.Duration
+	// timeout Is zero when the pin is tracked until it completes.
+	timeout time.Duration
+}
+
+// newPinLimits Returns the limits of a pin from the fields of the spec of its
+// object.
+func newPinLimits(stallAfter, timeout *metav1.Duration) pinLimits {
+	limits := pinLimits{stallAfter: defaultPinStallAfter}
+	if stallAfter != nil && stallAfter.Duration > 0 {
+		limits.stallAfter = stallAfter.Duration
+	}
+	if timeout != nil {
+		limits.timeout = timeout.Duration
+	}
+	return limits
+}
+
+// newPinProgress Returns the progress of the pin of the CID, submitted now
+// for the given generation of the spec.
+func newPinProgress(cid string, generation int64) *clusterv1alpha1.PinProgress {
+	now := metav1.Now()
+	return &clusterv1alpha1.PinProgress{CID: cid, Generation: generation, StartedAt: now, LastProgressTime: now}
+}
+
+// pinTracked Returns whether the pin is still to be checked: it was
+// submitted, and did not complete yet.
+func pinTracked(p *clusterv1alpha1.PinProgress) bool {
+	return p != nil && p.CompletedAt == nil
+}
+
+// pinTimedOut Returns whether the pin of the CID timed out for the given
+// generation of the spec. Such a pin is neither submitted nor tracked again
+// until the spec changes.
+func pinTimedOut(p *clusterv1alpha1.PinProgress, cid string, generation int64) bool {
+	return p != nil && p.TimedOutAt != nil && p.CID == cid && p.Generation == generation
+}
+
+// resumePinProgress Returns the progress to track the pin of the CID with. A
+// pin which timed out for a previous generation of the spec is tracked again
+// from now while it is still pinned, and forgotten otherwise.
+func resumePinProgress(
+	p *clusterv1alpha1.PinProgress,
+	cid string,
+	generation int64,
+	pinned bool,
+) *clusterv1alpha1.PinProgress {
+	if p == nil || p.TimedOutAt == nil || pinTimedOut(p, cid, generation) {
+		return p
+	}
+	if pinned && p.CID == cid {
+		return newPinProgress(cid, generation)
+	}
+	return nil
+}
+
+// trackPin Samples the state of the pin on the peers it is allocated to and
+// records its progress, along with the time a peer last moved on. A pin
+// which went without progress for longer than the stall limit is stalled,
+// and recovered once; a pin submitted longer than the timeout ago times out.
+// As the checks only rely on the times recorded in the progress, they
+// survive restarts of the operator. It returns the state of the pin and a
+// message telling how far it got.
+func trackPin(
+	ctx context.Context,
+	api *clusterapi.Client,
+	p *clusterv1alpha1.PinProgress,
+	limits pinLimits,
+) (pinState, string) {
+	now := metav1.Now()
+	switch {
+	case p.CompletedAt != nil:
+		return pinStatePinned, ""
+	case p.TimedOutAt != nil:
+		return pinStateTimedOut, pinTimedOutMessage(p)
+	case limits.timeout > 0 && now.Sub(p.StartedAt.Time) >= limits.timeout:
+		p.TimedOutAt = &now
+		return pinStateTimedOut, pinTimedOutMessage(p)
+	}
+	info, err := api.Status(ctx, p.CID)
+	if err != nil {
+		return pinStatePinning, fmt.Sprintf("cannot get the state of the pin of %s: %s", p.CID, err.Error())
+	}
+	before := pinScore(p)
+	countPinStates(p, info)
+	if pinScore(p) > before {
+		p.LastProgressTime = now
+	}
+	if p.Peers > 0 && p.Pinned == p.Peers {
+		p.CompletedAt = &now
+		return pinStatePinned, ""
+	}
+	idle := now.Sub(p.LastProgressTime.Time)
+	if idle < limits.stallAfter {
+		return pinStatePinning, pinProgressMessage(p)
+	}
+	message := fmt.Sprintf("no peer progressed for %s: %s", idle.Round(time.Second), pinProgressMessage(p))
+	if p.RecoveredAt == nil {
+		if _, err = api.Recover(ctx, p.CID); err != nil {
+			return pinStateStalled, fmt.Sprintf("%s; cannot recover the pin: %s", message, err.Error())
+		}
+		p.RecoveredAt = &now
+	}
+	return pinStateStalled, fmt.Sprintf("%s; the pin was recovered at %s", message,
+		p.RecoveredAt.UTC().Format(time.RFC3339))
+}
+
+// countPinStates Counts the peers the pin is allocated to by the state of the
+// pin on each, and estimates how far the pin got.
+func countPinStates(p *clusterv1alpha1.PinProgress, info *clusterapi.GlobalPinInfo) {
+	p.Peers, p.Queued, p.Pinning, p.Pinned, p.Failed = 0, 0, 0, 0, 0
+	for _, peer := range info.PeerMap {
+		switch peer.Status {
+		case clusterapi.TrackerStatusRemote:
+			// The pin is not allocated to the peer.
+			continue
+		case clusterapi.TrackerStatusPinned:
+			p.Pinned++
+		case clusterapi.TrackerStatusPinning:
+			p.Pinning++
+		case clusterapi.TrackerStatusPinError, clusterapi.TrackerStatusClusterErr:
+			p.Failed++
+		default:
+			p.Queued++
+		}
+		p.Peers++
+	}
+	p.Percent = 0
+	if p.Peers > 0 {
+		p.Percent = pinScore(p) * 100 / (2 * p.Peers)
+	}
+}
+
+// pinScore Returns how far the pin got, a peer pinning counting for half of
+// a peer which pinned it.
+func pinScore(p *clusterv1alpha1.PinProgress) int32 {
+	return 2*p.Pinned + p.Pinning
+}
+
+// pinProgressMessage Describes how far the pin got.
+func pinProgressMessage(p *clusterv1alpha1.PinProgress) string {
+	message := fmt.Sprintf("%s is pinned on %d of the %d peers it is allocated to (%d%%), %d pinning, %d queued",
+		p.CID, p.Pinned, p.Peers, p.Percent, p.Pinning, p.Queued)
+	if p.Failed > 0 {
+		message += fmt.Sprintf(", %d failed", p.Failed)
+	}
+	return message
+}
+
+// pinTimedOutMessage Describes the pin which timed out.
+func pinTimedOutMessage(p *clusterv1alpha1.PinProgress) string {
+	return fmt.Sprintf("the pin of %s timed out after %s, pinned on %d of the %d peers it is allocated to",
+		p.CID, p.TimedOutAt.Sub(p.StartedAt.Time).Round(time.Second), p.Pinned, p.Peers)
+}
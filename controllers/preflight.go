@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/preflight"
+)
+
+// preflightContainerName Is the name of the init container running the
+// preflight checks.
+const preflightContainerName = "preflight"
+
+// bootstrapAddresses Are the TCP addresses of public IPFS bootstrap nodes,
+// one of which a peer must reach for the outbound check to pass.
+var bootstrapAddresses = []string{
+	"104.131.131.82:4001",
+	"sg1.bootstrap.libp2p.io:4001",
+	"ny5.bootstrap.libp2p.io:4001",
+}
+
+// preflightEnabled Returns whether the peers run the preflight checks.
+func (r *IpfsReconciler) preflightEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return r.OperatorImage != "" && (m.Spec.Preflight == nil || !m.Spec.Preflight.Disabled)
+}
+
+// preflightContainer Returns the init container checking the network of a
+// peer with the operator binary. The checks never fail the container, so
+// that they only delay the daemons by their timeout. Their results are left
+// in its termination message.
+func preflightContainer(m *clusterv1alpha1.Ipfs, image, serviceName string) corev1.Container {
+	ports := fmt.Sprintf("swarm=%d,cluster-swarm=%d", portSwarm, portClusterSwarm)
+	var outbound string
+	if m.Spec.Preflight == nil || !m.Spec.Preflight.SkipOutbound {
+		outbound = strings.Join(bootstrapAddresses, ",")
+	}
+	return corev1.Container{
+		Name:            preflightContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/manager", "--preflight"},
+		Env: []corev1.EnvVar{
+			{Name: preflight.ServiceEnv, Value: fmt.Sprintf("%s.%s.svc", serviceName, m.Namespace)},
+			{Name: preflight.PortsEnv, Value: ports},
+			{Name: preflight.OutboundEnv, Value: outbound},
+		},
+		TerminationMessagePath: preflight.TerminationLog,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("16Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+		},
+	}
+}
+
+// syncPreflight Sets the PreflightFailed condition from the reports the
+// preflight containers of the peers left in their termination message,
+// naming each failing check of each peer. The condition is removed when the
+// checks are disabled, and left as is until a peer reports.
+func (r *IpfsReconciler) syncPreflight(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) error {
+	if !r.preflightEnabled(resolved) {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionPreflightFailed)
+		return nil
+	}
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return peerOrdinal(pods.Items[i].Name) < peerOrdinal(pods.Items[j].Name)
+	})
+
+	var reported bool
+	var failures []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := peerOrdinal(pod.Name)
+		if pod.DeletionTimestamp != nil || ordinal < 0 || ordinal >= peerCount(instance) {
+			continue
+		}
+		message, ok := preflightMessage(pod)
+		if !ok {
+			continue
+		}
+		reported = true
+		report, err := preflight.Parse(message)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", pod.Name, err))
+			continue
+		}
+		for _, res := range report.Failed() {
+			failures = append(failures, fmt.Sprintf("%s: %s check failed: %s", pod.Name, res.Name, res.Error))
+		}
+	}
+	if !reported {
+		return nil
+	}
+
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionPreflightFailed,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.PreflightFailedReasonChecksPassed,
+		Message:            "every peer passed its preflight checks",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(failures) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.PreflightFailedReasonChecksFailed
+		cond.Message = strings.Join(failures, "; ")
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+	return nil
+}
+
+// preflightMessage Returns the termination message of the preflight
+// container of the pod, once it completed.
+func preflightMessage(pod *corev1.Pod) (string, bool) {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name != preflightContainerName || status.State.Terminated == nil {
+			continue
+		}
+		message := status.State.Terminated.Message
+		return message, message != ""
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs preflight checks", func() {
+	var (
+		instance   *clusterv1alpha1.Ipfs
+		scheme     *runtime.Scheme
+		reconciler *IpfsReconciler
+	)
+
+	BeforeEach(func() {
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "preflight"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		reconciler = &IpfsReconciler{Scheme: scheme, OperatorImage: "quay.io/example/ipfs-operator:v1"}
+	})
+
+	render := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-preflight", "ipfs-cluster-preflight",
+			"ipfs-cluster-preflight", "ipfs-cluster-scripts-preflight", "")()).To(Succeed())
+		return sts
+	}
+
+	peer := func(name, message string) *corev1.Pod {
+		pod := &corev1.Pod{}
+		pod.Name = name
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+			Name: preflightContainerName,
+			State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{Message: message},
+			},
+		}}
+		return pod
+	}
+
+	It("checks the network before the other init containers", func() {
+		initContainers := render().Spec.Template.Spec.InitContainers
+		Expect(initContainers).NotTo(BeEmpty())
+		check := initContainers[0]
+		Expect(check.Name).To(Equal("preflight"))
+		Expect(check.Image).To(Equal("quay.io/example/ipfs-operator:v1"))
+		Expect(check.Command).To(Equal([]string{"/manager", "--preflight"}))
+		Expect(check.Env).To(ContainElement(corev1.EnvVar{
+			Name: "PREFLIGHT_SERVICE", Value: "ipfs-cluster-preflight.default.svc",
+		}))
+		Expect(check.Env).To(ContainElement(corev1.EnvVar{
+			Name: "PREFLIGHT_PORTS", Value: "swarm=4001,cluster-swarm=9096",
+		}))
+
+		By("skipping the outbound check")
+		instance.Spec.Preflight = &clusterv1alpha1.PreflightConfig{SkipOutbound: true}
+		check = render().Spec.Template.Spec.InitContainers[0]
+		Expect(check.Env).To(ContainElement(corev1.EnvVar{Name: "PREFLIGHT_OUTBOUND"}))
+
+		By("skipping every check")
+		instance.Spec.Preflight.Disabled = true
+		for _, c := range render().Spec.Template.Spec.InitContainers {
+			Expect(c.Name).NotTo(Equal("preflight"))
+		}
+		instance.Spec.Preflight = nil
+		reconciler.OperatorImage = ""
+		for _, c := range render().Spec.Template.Spec.InitContainers {
+			Expect(c.Name).NotTo(Equal("preflight"))
+		}
+	})
+
+	It("names the failing checks of each peer", func() {
+		reconciler.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			peer("ipfs-cluster-preflight-0", `{"results":[{"name":"dns"},{"name":"swarm"}]}`),
+			peer("ipfs-cluster-preflight-1",
+				`{"results":[{"name":"dns"},{"name":"outbound","error":"none of 104.131.131.82:4001 is reachable"}]}`),
+		).Build()
+		Expect(reconciler.syncPreflight(context.Background(), instance, instance)).To(Succeed())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionPreflightFailed)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.PreflightFailedReasonChecksFailed))
+		Expect(cond.Message).To(Equal(
+			"ipfs-cluster-preflight-1: outbound check failed: none of 104.131.131.82:4001 is reachable"))
+
+		By("clearing the condition once the checks are disabled")
+		instance.Spec.Preflight = &clusterv1alpha1.PreflightConfig{Disabled: true}
+		Expect(reconciler.syncPreflight(context.Background(), instance, instance)).To(Succeed())
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionPreflightFailed)).To(BeNil())
+	})
+
+	It("reports the checks passed once every peer passed", func() {
+		reconciler.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			peer("ipfs-cluster-preflight-0", `{"results":[{"name":"dns"}]}`),
+		).Build()
+		Expect(reconciler.syncPreflight(context.Background(), instance, instance)).To(Succeed())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionPreflightFailed)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+	})
+})
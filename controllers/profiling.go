@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// profilingMarkerPath Records that the configure script added the pprof
+	// listener to the RPC API of kubo, so that disabling profiling removes it.
+	profilingMarkerPath = "/data/ipfs/.profiling"
+	// profilingPath Is the path of the pprof index of both daemons.
+	profilingPath = "/debug/pprof/"
+	// metricsEnableStatsEnv Turns on the metrics server of ipfs-cluster,
+	// which serves the pprof endpoints.
+	metricsEnableStatsEnv = "CLUSTER_METRICS_ENABLESTATS"
+	// metricsEndpointEnv Is the address the metrics server of ipfs-cluster
+	// listens on, its loopback interface by default.
+	metricsEndpointEnv = "CLUSTER_METRICS_PROMETHEUSENDPOINT"
+	// profileBundleMaxBytes Bounds the profiles captured into a ConfigMap,
+	// below the size of an object.
+	profileBundleMaxBytes = 900 << 10
+	// profileErrorsKey Is the key of the captured profiles listing those
+	// which could not be captured.
+	profileErrorsKey = "errors.yaml"
+	// profileFetchTimeout Bounds the capture of a profile.
+	profileFetchTimeout = 30 * time.Second
+)
+
+// ProfileFetcher fetches the pprof profiles of the daemons of the peers.
+type ProfileFetcher interface {
+	// Fetch Returns the profile served at the URL.
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// httpProfileFetcher Fetches the profiles over HTTP, refusing those larger
+// than the ConfigMap holding them can be.
+type httpProfileFetcher struct {
+	client *http.Client
+}
+
+// Fetch Returns the profile served at the URL.
+func (f httpProfileFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, profileBundleMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", url, err)
+	}
+	if len(data) > profileBundleMaxBytes {
+		return nil, fmt.Errorf("GET %s: the profile exceeds %d bytes", url, profileBundleMaxBytes)
+	}
+	return data, nil
+}
+
+// profileFetcher Returns the fetcher of the profiles of the peers.
+func (r *IpfsReconciler) profileFetcher() ProfileFetcher {
+	if r.Profiles != nil {
+		return r.Profiles
+	}
+	return httpProfileFetcher{client: &http.Client{Timeout: profileFetchTimeout}}
+}
+
+// profilingEnabled Returns whether the daemons of the peers expose their
+// pprof endpoints.
+func profilingEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Debug != nil && m.Spec.Debug.EnableProfiling
+}
+
+// profilingName Returns the name of the NetworkPolicy guarding the pprof
+// endpoints of the peers.
+func profilingName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-profiling"
+}
+
+// profileName Returns the name of the ConfigMap of the profiles of the
+// given kind captured at the given time.
+func profileName(m *clusterv1alpha1.Ipfs, kind string, at time.Time) string {
+	return m.Name + "-profile-" + kind + "-" + at.UTC().Format("20060102-150405")
+}
+
+// profilingCommands Returns the commands of the tune function of the
+// configure script adding a listener of the RPC API of kubo on the pprof
+// port, which kubo serves its pprof endpoints on as any listener of the
+// API. The listener is removed once profiling is disabled.
+func profilingCommands(m *clusterv1alpha1.Ipfs) string {
+	if profilingEnabled(m) {
+		return fmt.Sprintf("\tipfs config --json Addresses.API '[\"/ip4/0.0.0.0/tcp/%d\",\"/ip4/0.0.0.0/tcp/%d\"]'\n"+
+			"\ttouch %s\n", portAPI, portPprof, profilingMarkerPath)
+	}
+	return fmt.Sprintf("\tif [ -f %[1]s ]; then\n\t\tipfs config Addresses.API /ip4/0.0.0.0/tcp/%[2]d\n"+
+		"\t\trm %[1]s\n\tfi\n", profilingMarkerPath, portAPI)
+}
+
+// profilingEnv Returns the environment of the ipfs-cluster container
+// starting its metrics server on the cluster-pprof port, which serves the
+// pprof endpoints of the daemon along with its metrics.
+func profilingEnv(m *clusterv1alpha1.Ipfs) []corev1.EnvVar {
+	if !profilingEnabled(m) {
+		return nil
+	}
+	return []corev1.EnvVar{
+		{Name: metricsEnableStatsEnv, Value: "true"},
+		{Name: metricsEndpointEnv, Value: fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", portClusterPprof)},
+	}
+}
+
+// addProfilingPorts Declares the pprof ports of the kubo and ipfs-cluster
+// containers of the peers, while profiling is enabled.
+func addProfilingPorts(m *clusterv1alpha1.Ipfs, spec *corev1.PodSpec) {
+	if !profilingEnabled(m) {
+		return
+	}
+	spec.Containers[0].Ports = append(spec.Containers[0].Ports, corev1.ContainerPort{
+		Name:          "pprof",
+		ContainerPort: portPprof,
+		Protocol:      corev1.ProtocolTCP,
+	})
+	spec.Containers[1].Ports = append(spec.Containers[1].Ports, corev1.ContainerPort{
+		Name:          "cluster-pprof",
+		ContainerPort: portClusterPprof,
+		Protocol:      corev1.ProtocolTCP,
+	})
+}
+
+// profilingEndpoints Returns the pprof endpoints of the daemons of every
+// peer, or none when profiling is disabled.
+func profilingEndpoints(m *clusterv1alpha1.Ipfs) []clusterv1alpha1.ProfilingEndpoints {
+	if !profilingEnabled(m) {
+		return nil
+	}
+	endpoints := make([]clusterv1alpha1.ProfilingEndpoints, 0, m.Spec.Replicas)
+	for ordinal := int32(0); ordinal < m.Spec.Replicas; ordinal++ {
+		pod := peerPodName(m, ordinal)
+		endpoints = append(endpoints, clusterv1alpha1.ProfilingEndpoints{
+			Name:    pod,
+			Kubo:    profilingURL(m, pod, portPprof),
+			Cluster: profilingURL(m, pod, portClusterPprof),
+		})
+	}
+	return endpoints
+}
+
+// profilingURL Returns the URL of the pprof index served by the peer pod on
+// the given port.
+func profilingURL(m *clusterv1alpha1.Ipfs, pod string, port int) string {
+	return fmt.Sprintf("http://%s.%s.%s.svc:%d%s", pod, peerServiceName(m), m.Namespace, port, profilingPath)
+}
+
+// networkPolicyProfiling Returns a mutate function for the NetworkPolicy
+// letting the operator and the pods matched by the profiling sources reach
+// the pprof ports of the peers. A NetworkPolicy isolates the pods it selects,
+// so unless another NetworkPolicy already does, it also lets anyone reach
+// the other ports of the pods, as before. The ports are those of the pod
+// template of the peers.
+func (r *IpfsReconciler) networkPolicyProfiling(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	np *networkingv1.NetworkPolicy,
+	template *corev1.PodTemplateSpec,
+) controllerutil.MutateFn {
+	np.Name = profilingName(m)
+	np.Namespace = m.Namespace
+	if err := ctrl.SetControllerReference(m, np, r.Scheme); err != nil {
+		return func() error { return err }
+	}
+	return func() error {
+		policies := networkingv1.NetworkPolicyList{}
+		if err := r.List(ctx, &policies, client.InNamespace(m.Namespace)); err != nil {
+			return fmt.Errorf("cannot list network policies: %w", err)
+		}
+		isolated := peersIsolated(policies.Items, np.Name, template.Labels)
+		expected := profilingPolicySpec(m, r.OperatorNamespace, &template.Spec, isolated)
+		unchanged, err := specUnchanged(np, expected, np.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		np.Spec = expected
+		return nil
+	}
+}
+
+// peersIsolated Returns whether a NetworkPolicy other than the named one
+// selects the pods with the given labels for their ingress.
+func peersIsolated(policies []networkingv1.NetworkPolicy, name string, podLabels map[string]string) bool {
+	for i := range policies {
+		policy := &policies[i]
+		if policy.Name == name || policy.DeletionTimestamp != nil {
+			continue
+		}
+		ingress := len(policy.Spec.PolicyTypes) == 0
+		for _, t := range policy.Spec.PolicyTypes {
+			ingress = ingress || t == networkingv1.PolicyTypeIngress
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err == nil && ingress && selector.Matches(labels.Set(podLabels)) {
+			return true
+		}
+	}
+	return false
+}
+
+// profilingPolicySpec Returns the spec of the NetworkPolicy of the pprof
+// ports of the peers. The operator is matched by its namespace, when known.
+func profilingPolicySpec(
+	m *clusterv1alpha1.Ipfs,
+	operatorNamespace string,
+	spec *corev1.PodSpec,
+	isolated bool,
+) networkingv1.NetworkPolicySpec {
+	tcp := corev1.ProtocolTCP
+	kuboPort, clusterPort := intstr.FromString("pprof"), intstr.FromString("cluster-pprof")
+	var sources []networkingv1.NetworkPolicyPeer
+	if operatorNamespace != "" {
+		sources = append(sources, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{corev1.LabelMetadataName: operatorNamespace},
+			},
+		})
+	}
+	if m.Spec.Debug.ProfilingSources != nil {
+		sources = append(sources, networkingv1.NetworkPolicyPeer{PodSelector: m.Spec.Debug.ProfilingSources.DeepCopy()})
+	}
+	policy := networkingv1.NetworkPolicySpec{
+		PodSelector: metav1.LabelSelector{MatchLabels: peerSelector(m)},
+		PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		Ingress: []networkingv1.NetworkPolicyIngressRule{{
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &tcp, Port: &kuboPort},
+				{Protocol: &tcp, Port: &clusterPort},
+			},
+			From: sources,
+		}},
+	}
+	if len(sources) == 0 {
+		// A rule without sources lets anyone in, so the pprof ports get no
+		// rule at all.
+		policy.Ingress = nil
+	}
+	if !isolated {
+		if open := openPorts(spec); len(open) > 0 {
+			policy.Ingress = append(policy.Ingress, networkingv1.NetworkPolicyIngressRule{Ports: open})
+		}
+	}
+	return policy
+}
+
+// openPorts Returns the ports of the containers of the pod other than the
+// pprof ones, by name when they have one.
+func openPorts(spec *corev1.PodSpec) []networkingv1.NetworkPolicyPort {
+	var ports []networkingv1.NetworkPolicyPort
+	seen := map[string]bool{}
+	for _, c := range spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == "pprof" || p.Name == "cluster-pprof" {
+				continue
+			}
+			protocol := p.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			port := intstr.FromInt(int(p.ContainerPort))
+			if p.Name != "" {
+				port = intstr.FromString(p.Name)
+			}
+			key := string(protocol) + "/" + port.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ports = append(ports, networkingv1.NetworkPolicyPort{Protocol: &protocol, Port: &port})
+		}
+	}
+	return ports
+}
+
+// reconcileProfileCapture Captures the profile named by the capture-profile
+// annotation, heap or goroutine, from the kubo and ipfs-cluster daemons of
+// every peer into a new ConfigMap owned by the cluster. The profiles which
+// cannot be fetched, or would not fit in the ConfigMap, are listed in its
+// errors.yaml key. The operator then removes the annotation and emits an
+// event naming the ConfigMap. The capture is refused while profiling is
+// disabled.
+func (r *IpfsReconciler) reconcileProfileCapture(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	kind, requested := instance.Annotations[clusterv1alpha1.AnnotationCaptureProfile]
+	if !requested {
+		return nil
+	}
+	switch {
+	case kind != clusterv1alpha1.ProfileHeap && kind != clusterv1alpha1.ProfileGoroutine:
+		r.eventf(instance, corev1.EventTypeWarning, "ProfileCaptureFailed",
+			"unknown profile %q, expected %s or %s", kind,
+			clusterv1alpha1.ProfileHeap, clusterv1alpha1.ProfileGoroutine)
+	case !profilingEnabled(instance):
+		r.eventf(instance, corev1.EventTypeWarning, "ProfileCaptureFailed",
+			"profiles are only captured while spec.debug.enableProfiling is set")
+	default:
+		name, err := r.captureProfiles(ctx, instance, kind, time.Now())
+		if err != nil {
+			return err
+		}
+		r.eventf(instance, corev1.EventTypeNormal, "ProfileCaptured",
+			"wrote the %s profiles of the peers to ConfigMap %s", kind, name)
+	}
+	updated := instance.DeepCopy()
+	delete(updated.Annotations, clusterv1alpha1.AnnotationCaptureProfile)
+	if err := r.Patch(ctx, updated, client.MergeFrom(instance)); err != nil {
+		return fmt.Errorf("cannot remove %s annotation: %w", clusterv1alpha1.AnnotationCaptureProfile, err)
+	}
+	instance.Annotations = updated.Annotations
+	instance.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// captureProfiles Writes the profiles of the given kind of the daemons of
+// every peer to a new ConfigMap, and returns its name.
+func (r *IpfsReconciler) captureProfiles(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	kind string,
+	now time.Time,
+) (string, error) {
+	fetcher := r.profileFetcher()
+	profiles := map[string][]byte{}
+	failures := map[string]string{}
+	size := 0
+	for ordinal := int32(0); ordinal < peerCount(instance); ordinal++ {
+		pod := peerPodName(instance, ordinal)
+		for _, daemon := range []struct {
+			name string
+			port int
+		}{{"kubo", portPprof}, {"cluster", portClusterPprof}} {
+			key := fmt.Sprintf("%s.%s.%s.pb.gz", pod, daemon.name, kind)
+			data, err := fetcher.Fetch(ctx, profilingURL(instance, pod, daemon.port)+kind)
+			switch {
+			case err != nil:
+				failures[key] = err.Error()
+			case size+len(data) > profileBundleMaxBytes:
+				failures[key] = fmt.Sprintf("left out, the profiles would exceed %d bytes", profileBundleMaxBytes)
+			default:
+				profiles[key] = data
+				size += len(data)
+			}
+		}
+	}
+
+	cm := corev1.ConfigMap{}
+	cm.Name = profileName(instance, kind, now)
+	cm.Namespace = instance.Namespace
+	cm.Labels = ipfsLabels(instance, componentDebug)
+	cm.BinaryData = profiles
+	if len(failures) > 0 {
+		out, err := yaml.Marshal(failures)
+		if err != nil {
+			return "", fmt.Errorf("cannot serialize the profiles which were not captured: %w", err)
+		}
+		cm.Data = map[string]string{profileErrorsKey: string(out)}
+	}
+	if err := ctrl.SetControllerReference(instance, &cm, r.Scheme); err != nil {
+		return "", err
+	}
+	if err := r.Create(ctx, &cm); err != nil && !errors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("cannot write profiles: %w", err)
+	}
+	return cm.Name, nil
+}
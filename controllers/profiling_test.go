@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// fakeProfiles Serves the profiles by URL, failing for the other URLs.
+type fakeProfiles map[string][]byte
+
+func (f fakeProfiles) Fetch(_ context.Context, url string) ([]byte, error) {
+	data, ok := f[url]
+	if !ok {
+		return nil, fmt.Errorf("GET %s: connection refused", url)
+	}
+	return data, nil
+}
+
+var _ = Describe("Ipfs profiling", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "pprof"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = ipfsImage
+		instance.Spec.ClusterImage = ipfsClusterImage
+		instance.Spec.Debug = &clusterv1alpha1.DebugConfig{
+			EnableProfiling: true,
+			ProfilingSources: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "pprof-viewer"},
+			},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{
+			Client:            fakeClient,
+			Scheme:            scheme,
+			Recorder:          recorder,
+			OperatorNamespace: "ipfs-operator-system",
+		}
+	})
+
+	render := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSet(instance, sts, "ipfs-cluster-pprof", "ipfs-cluster-pprof",
+			"ipfs-cluster-pprof", "ipfs-cluster-scripts-pprof", "")()).To(Succeed())
+		return sts
+	}
+
+	It("exposes the pprof endpoints of both daemons while profiling is enabled", func() {
+		containers := render().Spec.Template.Spec.Containers
+		Expect(containers[0].Ports).To(ContainElement(corev1.ContainerPort{
+			Name: "pprof", ContainerPort: portPprof, Protocol: corev1.ProtocolTCP}))
+		Expect(containers[1].Ports).To(ContainElement(corev1.ContainerPort{
+			Name: "cluster-pprof", ContainerPort: portClusterPprof, Protocol: corev1.ProtocolTCP}))
+		Expect(containers[1].Env).To(ContainElements(
+			corev1.EnvVar{Name: metricsEnableStatsEnv, Value: "true"},
+			corev1.EnvVar{Name: metricsEndpointEnv, Value: "/ip4/0.0.0.0/tcp/8888"},
+		))
+		Expect(checkClusterEnv(&corev1.PodSpec{Containers: containers})).To(Succeed())
+		Expect(profilingCommands(instance)).To(ContainSubstring(
+			`ipfs config --json Addresses.API '["/ip4/0.0.0.0/tcp/5001","/ip4/0.0.0.0/tcp/6060"]'`))
+		Expect(profilingEndpoints(instance)).To(Equal([]clusterv1alpha1.ProfilingEndpoints{
+			{
+				Name:    "ipfs-cluster-pprof-0",
+				Kubo:    "http://ipfs-cluster-pprof-0.ipfs-cluster-pprof.default.svc:6060/debug/pprof/",
+				Cluster: "http://ipfs-cluster-pprof-0.ipfs-cluster-pprof.default.svc:8888/debug/pprof/",
+			},
+			{
+				Name:    "ipfs-cluster-pprof-1",
+				Kubo:    "http://ipfs-cluster-pprof-1.ipfs-cluster-pprof.default.svc:6060/debug/pprof/",
+				Cluster: "http://ipfs-cluster-pprof-1.ipfs-cluster-pprof.default.svc:8888/debug/pprof/",
+			},
+		}))
+
+		By("removing the ports and the listener once profiling is disabled")
+		instance.Spec.Debug.EnableProfiling = false
+		containers = render().Spec.Template.Spec.Containers
+		for _, c := range containers {
+			for _, p := range c.Ports {
+				Expect(p.Name).NotTo(HaveSuffix("pprof"))
+			}
+			Expect(c.Env).NotTo(ContainElement(HaveField("Name", metricsEndpointEnv)))
+		}
+		Expect(profilingCommands(instance)).To(ContainSubstring(
+			"ipfs config Addresses.API /ip4/0.0.0.0/tcp/5001\n\t\trm /data/ipfs/.profiling"))
+		Expect(profilingEndpoints(instance)).To(BeNil())
+	})
+
+	It("only lets the operator and the profiling sources reach the pprof ports", func() {
+		sts := render()
+		np := &networkingv1.NetworkPolicy{}
+		Expect(reconciler.networkPolicyProfiling(ctx, instance, np, sts.Spec.Template.DeepCopy())()).To(Succeed())
+		Expect(np.Name).To(Equal("ipfs-cluster-pprof-profiling"))
+		Expect(np.Spec.PodSelector.MatchLabels).To(Equal(peerSelector(instance)))
+		Expect(np.Spec.Ingress).To(HaveLen(2))
+		pprof := np.Spec.Ingress[0]
+		Expect(pprof.Ports).To(HaveLen(2))
+		Expect(*pprof.Ports[0].Port).To(Equal(intstr.FromString("pprof")))
+		Expect(*pprof.Ports[1].Port).To(Equal(intstr.FromString("cluster-pprof")))
+		Expect(pprof.From).To(HaveLen(2))
+		Expect(pprof.From[0].NamespaceSelector.MatchLabels).To(HaveKeyWithValue(
+			corev1.LabelMetadataName, "ipfs-operator-system"))
+		Expect(pprof.From[1].PodSelector.MatchLabels).To(HaveKeyWithValue("app", "pprof-viewer"))
+
+		By("leaving the other ports open to anyone, as they were")
+		open := np.Spec.Ingress[1]
+		Expect(open.From).To(BeEmpty())
+		var names []string
+		for _, p := range open.Ports {
+			names = append(names, p.Port.String())
+		}
+		Expect(names).To(ContainElements("swarm", "swarm-udp", "api", "http", "cluster-swarm"))
+		Expect(names).NotTo(ContainElement("pprof"))
+		Expect(names).NotTo(ContainElement("cluster-pprof"))
+
+		By("leaving the other ports to the policy already isolating the peers")
+		isolating := &networkingv1.NetworkPolicy{}
+		isolating.Name = "default-deny"
+		isolating.Namespace = instance.Namespace
+		Expect(fakeClient.Create(ctx, isolating)).To(Succeed())
+		Expect(reconciler.networkPolicyProfiling(ctx, instance, np, sts.Spec.Template.DeepCopy())()).To(Succeed())
+		Expect(np.Spec.Ingress).To(HaveLen(1))
+		Expect(np.Spec.Ingress[0].Ports).To(HaveLen(2))
+	})
+
+	It("captures the profiles of the daemons into a new ConfigMap", func() {
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationCaptureProfile: clusterv1alpha1.ProfileHeap}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		reconciler.Profiles = fakeProfiles{
+			"http://ipfs-cluster-pprof-0.ipfs-cluster-pprof.default.svc:6060/debug/pprof/heap": []byte("kubo-0"),
+			"http://ipfs-cluster-pprof-0.ipfs-cluster-pprof.default.svc:8888/debug/pprof/heap": []byte("cluster-0"),
+			"http://ipfs-cluster-pprof-1.ipfs-cluster-pprof.default.svc:6060/debug/pprof/heap": []byte(
+				strings.Repeat("x", profileBundleMaxBytes)),
+		}
+		Expect(reconciler.reconcileProfileCapture(ctx, instance)).To(Succeed())
+
+		list := &corev1.ConfigMapList{}
+		Expect(fakeClient.List(ctx, list, client.InNamespace(instance.Namespace),
+			client.MatchingLabels{labelComponent: componentDebug})).To(Succeed())
+		Expect(list.Items).To(HaveLen(1))
+		cm := list.Items[0]
+		Expect(cm.Name).To(MatchRegexp(`^pprof-profile-heap-\d{8}-\d{6}$`))
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+		Expect(cm.BinaryData).To(Equal(map[string][]byte{
+			"ipfs-cluster-pprof-0.kubo.heap.pb.gz":    []byte("kubo-0"),
+			"ipfs-cluster-pprof-0.cluster.heap.pb.gz": []byte("cluster-0"),
+		}))
+		failures := map[string]string{}
+		Expect(yaml.Unmarshal([]byte(cm.Data[profileErrorsKey]), &failures)).To(Succeed())
+		Expect(failures).To(HaveKeyWithValue("ipfs-cluster-pprof-1.kubo.heap.pb.gz",
+			ContainSubstring("would exceed")))
+		Expect(failures).To(HaveKeyWithValue("ipfs-cluster-pprof-1.cluster.heap.pb.gz",
+			ContainSubstring("connection refused")))
+
+		latest := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), latest)).To(Succeed())
+		Expect(latest.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationCaptureProfile))
+		Expect(recorder.Events).To(Receive(And(ContainSubstring("ProfileCaptured"), ContainSubstring(cm.Name))))
+	})
+
+	It("refuses to capture profiles while profiling is disabled", func() {
+		instance.Spec.Debug = nil
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationCaptureProfile: clusterv1alpha1.ProfileGoroutine}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		Expect(reconciler.reconcileProfileCapture(ctx, instance)).To(Succeed())
+		Expect(recorder.Events).To(Receive(And(ContainSubstring("ProfileCaptureFailed"),
+			ContainSubstring("spec.debug.enableProfiling"))))
+		Expect(instance.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationCaptureProfile))
+
+		list := &corev1.ConfigMapList{}
+		Expect(fakeClient.List(ctx, list, client.InNamespace(instance.Namespace))).To(Succeed())
+		Expect(list.Items).To(BeEmpty())
+	})
+})
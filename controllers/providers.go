@@ -0,0 +1,484 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	encjson "encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// defaultProviderWorkers Is the default of Providers.Workers.
+	defaultProviderWorkers = 64
+	// defaultPeerProvideWorkers Is the default of Providers.PeerWorkers.
+	defaultPeerProvideWorkers = 1
+	// providerPinBatch Bounds how many items of its shard each provider node
+	// picks up per reconcile, as each of them fetches a block.
+	providerPinBatch = 100
+	// providerSyncInterval Is how soon the provider nodes pick up the next
+	// items of their shard while some are queued.
+	providerSyncInterval = time.Minute
+	// providerScriptKey Is the key of the provider ConfigMap holding the
+	// script starting the provider nodes.
+	providerScriptKey = "provider.sh"
+)
+
+// providerScript Configures the repo of a provider node at every start, then
+// runs its daemon. The repo only holds the root blocks of the shard of the
+// node, which the operator pins again when the node restarts.
+const providerScript = `#!/bin/sh
+set -e
+if [ ! -f /data/ipfs/config ]; then
+	ipfs init --profile server --empty-repo
+fi
+rm -f /data/ipfs/repo.lock
+ipfs config Addresses.API /ip4/0.0.0.0/tcp/%[1]d
+ipfs config Addresses.Gateway /ip4/127.0.0.1/tcp/%[2]d
+ipfs config Reprovider.Strategy pinned
+ipfs config --json Peering.Peers '%[3]s'
+%[4]sexec ipfs daemon --migrate=true
+`
+
+// providersEnabled Returns whether dedicated provider nodes announce the
+// content of the cluster.
+func providersEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Providers != nil
+}
+
+// providersName Returns the name of the StatefulSet, Service and ConfigMap
+// of the provider nodes.
+func providersName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-providers-" + m.Name
+}
+
+// providerPodName Returns the name of the provider node with the given ordinal.
+func providerPodName(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	return fmt.Sprintf("%s-%d", providersName(m), ordinal)
+}
+
+// providerSelector Returns the labels selecting the provider nodes, which
+// the peer selector does not match.
+func providerSelector(m *clusterv1alpha1.Ipfs) map[string]string {
+	return map[string]string{labelName: providersName(m)}
+}
+
+// providerLabels Returns the labels of the objects of the provider nodes.
+func providerLabels(m *clusterv1alpha1.Ipfs) map[string]string {
+	return recommendedLabels(providersName(m), m.Name, componentProvider, m.Spec.IpfsImage, string(m.UID))
+}
+
+// providerWorkers Returns how many announcements each provider node sends at once.
+func providerWorkers(m *clusterv1alpha1.Ipfs) int32 {
+	if m.Spec.Providers.Workers != nil {
+		return *m.Spec.Providers.Workers
+	}
+	return defaultProviderWorkers
+}
+
+// providerShard Returns the ordinal of the provider node announcing the CID.
+func providerShard(cid string, replicas int32) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(cid))
+	return int32(h.Sum32() % uint32(replicas))
+}
+
+// peeringEntry Is an entry of Peering.Peers in the kubo configuration.
+type peeringEntry struct {
+	ID    string
+	Addrs []string
+}
+
+// kuboPeering Returns the peering entries of the nodes fetching the content
+// of the cluster, such as the provider nodes: the kubo nodes of the peers
+// whose identity is known, through their DNS names.
+func kuboPeering(m *clusterv1alpha1.Ipfs) []peeringEntry {
+	peering := []peeringEntry{}
+	for _, p := range m.Status.Peers {
+		if p.KuboID == "" {
+			continue
+		}
+		peering = append(peering, peeringEntry{
+			ID:    p.KuboID,
+			Addrs: []string{fmt.Sprintf("/dns4/%s.%s.%s.svc/tcp/%d", p.Name, peerServiceName(m), m.Namespace, portSwarm)},
+		})
+	}
+	sort.Slice(peering, func(i, j int) bool { return peering[i].ID < peering[j].ID })
+	return peering
+}
+
+// renderProviderScript Returns the script starting the provider nodes.
+func renderProviderScript(m *clusterv1alpha1.Ipfs) (string, error) {
+	peering, err := encjson.Marshal(kuboPeering(m))
+	if err != nil {
+		return "", fmt.Errorf("cannot render the peering of the provider nodes: %w", err)
+	}
+	var tune strings.Builder
+	if provideWorkersSupported(m.Spec.IpfsImage) {
+		fmt.Fprintf(&tune, "ipfs config --json Provider.WorkerCount %d\n", providerWorkers(m))
+	}
+	if m.Spec.Reprovider != nil && m.Spec.Reprovider.Interval != nil {
+		fmt.Fprintf(&tune, "ipfs config Reprovider.Interval %s\n", m.Spec.Reprovider.Interval.Duration)
+	}
+	return fmt.Sprintf(providerScript, portAPI, portHTTP, peering, tune.String()), nil
+}
+
+// configMapProviders Returns a mutate function for the ConfigMap holding the
+// script of the provider nodes, and the hash of the script, which rolls the
+// provider nodes when it changes.
+func (r *IpfsReconciler) configMapProviders(
+	m *clusterv1alpha1.Ipfs,
+	cm *corev1.ConfigMap,
+) (controllerutil.MutateFn, string) {
+	script, err := renderProviderScript(m)
+	if err != nil {
+		return nil, ""
+	}
+	sum := sha256.Sum256([]byte(script))
+	cm.Name = providersName(m)
+	cm.Namespace = m.Namespace
+	if err = ctrl.SetControllerReference(m, cm, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		cm.Data = map[string]string{providerScriptKey: script}
+		return nil
+	}, hex.EncodeToString(sum[:16])
+}
+
+// serviceProviders Returns a mutate function for the headless Service
+// governing the StatefulSet of the provider nodes, through which the
+// operator reaches their RPC API.
+func (r *IpfsReconciler) serviceProviders(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) controllerutil.MutateFn {
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      providersName(m),
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "swarm",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portSwarm,
+					TargetPort: intstr.FromString("swarm"),
+				},
+				{
+					Name:       "api",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portAPI,
+					TargetPort: intstr.FromString("api"),
+				},
+			},
+			Selector: providerSelector(m),
+		},
+	}
+	expected.DeepCopyInto(svc)
+	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
+		return func() error { return err }
+	}
+	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		svc.Spec.Ports = expected.Spec.Ports
+		svc.Spec.Selector = expected.Spec.Selector
+		return nil
+	}
+}
+
+// statefulSetProviders Returns a mutate function for the StatefulSet of the
+// provider nodes, whose ordinals are the shards of the pinset they announce.
+func (r *IpfsReconciler) statefulSetProviders(
+	m *clusterv1alpha1.Ipfs,
+	sts *appsv1.StatefulSet,
+	scriptHash string,
+) controllerutil.MutateFn {
+	replicas := m.Spec.Providers.Replicas
+	expected := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      providersName(m),
+			Namespace: m.Namespace,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: providerSelector(m),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: providerLabels(m),
+					Annotations: map[string]string{
+						clusterv1alpha1.AnnotationConfigHash: scriptHash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					PriorityClassName: m.Spec.PriorityClassName,
+					Containers:        []corev1.Container{providerContainer(m)},
+					Volumes:           providerVolumes(m),
+				},
+			},
+			ServiceName:         providersName(m),
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+		},
+	}
+	applySecurityProfile(m, &expected.Spec.Template.Spec)
+	expected.DeepCopyInto(sts)
+	if err := ctrl.SetControllerReference(m, sts, r.Scheme); err != nil {
+		return func() error { return err }
+	}
+	return func() error {
+		spec := expected.Spec
+		if sts.ResourceVersion != "" && sts.Spec.Selector != nil {
+			spec.Selector = sts.Spec.Selector
+		}
+		unchanged, err := specUnchanged(sts, spec, sts.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		sts.Spec = spec
+		return nil
+	}
+}
+
+// providerContainer Returns the container of a provider node, running the
+// provider script against a store of its own.
+func providerContainer(m *clusterv1alpha1.Ipfs) corev1.Container {
+	return corev1.Container{
+		Name:            "ipfs",
+		Image:           m.Spec.IpfsImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh", "/custom/" + providerScriptKey},
+		Env: []corev1.EnvVar{
+			{
+				Name:  "IPFS_FD_MAX",
+				Value: "4096",
+			},
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          "swarm",
+				ContainerPort: portSwarm,
+				Protocol:      corev1.ProtocolTCP,
+			},
+			{
+				Name:          "api",
+				ContainerPort: portAPI,
+				Protocol:      corev1.ProtocolTCP,
+			},
+		},
+		ReadinessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{
+					Port: intstr.FromString("api"),
+				},
+			},
+			TimeoutSeconds: tenSeconds,
+		},
+		LivenessProbe: &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{
+					Port: intstr.FromString("swarm"),
+				},
+			},
+			InitialDelaySeconds: thirtySeconds,
+			TimeoutSeconds:      tenSeconds,
+			PeriodSeconds:       secondsPerMinute,
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "ipfs-storage",
+				MountPath: ipfsMountPath,
+			},
+			{
+				Name:      "provider-script",
+				MountPath: "/custom",
+			},
+		},
+		Resources: m.Spec.Providers.Resources,
+	}
+}
+
+// providerVolumes Returns the volumes of a provider node: an empty store and
+// the provider script.
+func providerVolumes(m *clusterv1alpha1.Ipfs) []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: "ipfs-storage",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name: "provider-script",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: providersName(m),
+					},
+				},
+			},
+		},
+	}
+}
+
+// providerAPI Returns a client of the kubo node of the provider node with
+// the given ordinal. The provider nodes do not share the breaker of the
+// peers, so that a provider node which cannot be reached does not stop the
+// calls to the peers.
+func (r *IpfsReconciler) providerAPI(m *clusterv1alpha1.Ipfs, ordinal int32) (kuboapi.API, error) {
+	return r.kuboDialer().Dial(m.Namespace, providersName(m), providerPodName(m, ordinal))
+}
+
+// syncProviders Hands the shards of the pinset to the provider nodes, and
+// records in the status how far behind they are. It returns how soon the
+// provider nodes should pick up the next items while some are queued, or
+// zero.
+func (r *IpfsReconciler) syncProviders(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) (time.Duration, error) {
+	if !providersEnabled(resolved) {
+		instance.Status.Providers = nil
+		return 0, nil
+	}
+	log := ctrllog.FromContext(ctx)
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: providersName(resolved)}, sts); err != nil {
+		if errors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("cannot get provider statefulset: %w", err)
+	}
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		log.Info("cannot reach the cluster API to shard the pinset", "error", err.Error())
+		return 0, nil
+	}
+	infos, err := api.StatusAll(ctx)
+	if err != nil {
+		log.Info("cannot list the pinset to shard it", "error", err.Error())
+		return 0, nil
+	}
+	replicas := resolved.Spec.Providers.Replicas
+	if replicas < 1 {
+		instance.Status.Providers = nil
+		return 0, nil
+	}
+	shards := make([][]string, replicas)
+	for _, info := range infos {
+		cid := string(info.Cid)
+		shard := providerShard(cid, replicas)
+		shards[shard] = append(shards[shard], cid)
+	}
+
+	status := &clusterv1alpha1.ProvidersStatus{
+		ReadyReplicas: sts.Status.ReadyReplicas,
+		Pinset:        int64(len(infos)),
+		CheckedAt:     metav1.Now(),
+	}
+	for ordinal, shard := range shards {
+		sort.Strings(shard)
+		node := clusterv1alpha1.ProviderNodeStatus{
+			Name:     providerPodName(resolved, int32(ordinal)),
+			Assigned: int64(len(shard)),
+		}
+		queue, err := r.syncProviderShard(ctx, resolved, int32(ordinal), shard)
+		if err != nil {
+			node.Error = err.Error()
+		}
+		node.Queue = queue
+		status.Queue += node.Queue
+		status.Nodes = append(status.Nodes, node)
+	}
+	instance.Status.Providers = status
+	if status.Queue > 0 {
+		return providerSyncInterval, nil
+	}
+	return 0, nil
+}
+
+// syncProviderShard Pins the root blocks of the next items of the shard on
+// the provider node directly, up to providerPinBatch of them, and unpins the
+// items which left the shard. It returns the number of items of the shard
+// the node does not announce yet, all of them when the node cannot be
+// reached.
+func (r *IpfsReconciler) syncProviderShard(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	ordinal int32,
+	shard []string,
+) (int64, error) {
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: providerPodName(m, ordinal)}, pod); err != nil {
+		return int64(len(shard)), fmt.Errorf("cannot get provider pod: %w", err)
+	}
+	if !podIsReady(pod) {
+		return int64(len(shard)), fmt.Errorf("the provider node is not ready")
+	}
+	api, err := r.providerAPI(m, ordinal)
+	if err != nil {
+		return int64(len(shard)), err
+	}
+	pins, err := api.PinLs(ctx, kuboapi.PinTypeDirect)
+	if err != nil {
+		return int64(len(shard)), err
+	}
+	assigned := make(map[string]bool, len(shard))
+	for _, cid := range shard {
+		assigned[cid] = true
+	}
+	stale := []string{}
+	for cid := range pins {
+		if !assigned[cid] {
+			stale = append(stale, cid)
+		}
+	}
+	sort.Strings(stale)
+	for _, cid := range stale {
+		if err = api.PinRm(ctx, cid, false); err != nil {
+			return int64(len(shard)), err
+		}
+	}
+	missing := []string{}
+	for _, cid := range shard {
+		if _, ok := pins[cid]; !ok {
+			missing = append(missing, cid)
+		}
+	}
+	for i, cid := range missing {
+		if i == providerPinBatch {
+			break
+		}
+		if err = api.PinAdd(ctx, cid, false); err != nil {
+			return int64(len(missing) - i), err
+		}
+	}
+	if len(missing) > providerPinBatch {
+		return int64(len(missing) - providerPinBatch), nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs provider nodes", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+		nodes      []*kubofake.Node
+		cids       []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "sharded"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.Providers = &clusterv1alpha1.ProvidersConfig{Replicas: 2}
+		instance.Status.Peers = []clusterv1alpha1.PeerStatus{
+			{Name: peerPodName(instance, 0), KuboID: "12D3KooWPeerA"},
+			{Name: peerPodName(instance, 1)},
+		}
+
+		cluster = clusterfake.NewCluster(clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)})
+		cids = nil
+		for i := 0; i < 7; i++ {
+			cid := fmt.Sprintf("bafybeigdyrzt%d", i)
+			_, err := clusterapi.New(cluster.URL()).Pin(ctx, cid, clusterapi.PinOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			cids = append(cids, cid)
+		}
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		sts := &appsv1.StatefulSet{}
+		sts.Name = providersName(instance)
+		sts.Namespace = instance.Namespace
+		sts.Status.ReadyReplicas = 2
+		objects := []client.Object{instance, credentials, sts}
+		dialer := kubofake.NewDialer()
+		nodes = nil
+		for i := int32(0); i < 2; i++ {
+			pod := &corev1.Pod{}
+			pod.Name = providerPodName(instance, i)
+			pod.Namespace = instance.Namespace
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			objects = append(objects, pod)
+			node := kubofake.NewNode(fmt.Sprintf("12D3KooWProvider%d", i))
+			dialer.Add(instance.Namespace, pod.Name, node)
+			nodes = append(nodes, node)
+		}
+		nodes[1].Pins["bafyunpinned"] = kuboapi.PinTypeDirect
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+		reconciler = &IpfsReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			Kubo:   dialer,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("renders the provider nodes and slows down the peers", func() {
+		script, err := renderProviderScript(instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(script).To(ContainSubstring(`'[{"ID":"12D3KooWPeerA","Addrs":` +
+			`["/dns4/ipfs-cluster-sharded-0.ipfs-cluster-sharded.default.svc/tcp/4001"]}]'`))
+		Expect(script).To(ContainSubstring("ipfs config --json Provider.WorkerCount 64\n"))
+		Expect(provideCommands(instance)).To(ContainSubstring("Provider.WorkerCount 1\n"))
+
+		sts := &appsv1.StatefulSet{}
+		Expect(reconciler.statefulSetProviders(instance, sts, "hash")()).To(Succeed())
+		Expect(*sts.Spec.Replicas).To(Equal(int32(2)))
+		Expect(sts.Spec.Template.Labels).NotTo(HaveKeyWithValue(labelName, peerSelector(instance)[labelName]))
+		Expect(sts.Spec.Template.Annotations).To(HaveKeyWithValue(clusterv1alpha1.AnnotationConfigHash, "hash"))
+		Expect(sts.OwnerReferences).To(HaveLen(1))
+		Expect(sts.OwnerReferences[0].Name).To(Equal(instance.Name))
+	})
+
+	It("hands each shard of the pinset to its provider node", func() {
+		requeue, err := reconciler.syncProviders(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		status := instance.Status.Providers
+		Expect(status).NotTo(BeNil())
+		Expect(status.ReadyReplicas).To(Equal(int32(2)))
+		Expect(status.Pinset).To(Equal(int64(7)))
+		Expect(status.Queue).To(BeZero())
+		Expect(status.Nodes).To(HaveLen(2))
+		Expect(status.Nodes[0].Assigned + status.Nodes[1].Assigned).To(Equal(int64(7)))
+		for i, node := range nodes {
+			Expect(node.Pins).To(HaveLen(int(status.Nodes[i].Assigned)))
+			for cid, pinType := range node.Pins {
+				Expect(providerShard(cid, 2)).To(Equal(int32(i)))
+				Expect(pinType).To(Equal(kuboapi.PinTypeDirect))
+			}
+		}
+		Expect(nodes[1].Pins).NotTo(HaveKey("bafyunpinned"))
+
+		By("queuing the shard of a provider node which is not ready")
+		pod := &corev1.Pod{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: providerPodName(instance, 1)}, pod)).To(Succeed())
+		pod.Status.Conditions = nil
+		Expect(fakeClient.Status().Update(ctx, pod)).To(Succeed())
+		requeue, err = reconciler.syncProviders(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(providerSyncInterval))
+		Expect(instance.Status.Providers.Nodes[1].Error).To(ContainSubstring("not ready"))
+		Expect(instance.Status.Providers.Queue).To(Equal(instance.Status.Providers.Nodes[1].Assigned))
+
+		By("clearing the status once the provider nodes are removed")
+		instance.Spec.Providers = nil
+		Expect(reconciler.syncProviders(ctx, instance, instance)).To(BeZero())
+		Expect(instance.Status.Providers).To(BeNil())
+	})
+})
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// DefaultMaxConcurrentReconciles Is the number of clusters reconciled at once.
+	DefaultMaxConcurrentReconciles = 1
+	// DefaultBaseDelay and DefaultMaxDelay Bound the backoff of a cluster
+	// whose reconcile fails, as in the default rate limiter of controller-runtime.
+	DefaultBaseDelay = 5 * time.Millisecond
+	DefaultMaxDelay  = 1000 * time.Second
+	// queueQPS and queueBurst Limit the rate of the requeues of all the
+	// clusters, as in the default rate limiter of controller-runtime.
+	queueQPS   = 10
+	queueBurst = 100
+)
+
+// QueueOptions Tunes how the reconciles of the clusters are queued. Fields
+// left empty keep the defaults.
+type QueueOptions struct {
+	// MaxConcurrentReconciles Is the number of clusters reconciled at once.
+	MaxConcurrentReconciles int
+	// BaseDelay Is the delay before a failed reconcile is retried, doubled on
+	// each failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Coalesce Is how long the events about the objects of a cluster are
+	// collected before the cluster is reconciled, so that a burst of events,
+	// such as during a rollout, causes a single reconcile. Disabled when zero.
+	Coalesce time.Duration
+	// WarmupConcurrency Is the number of clusters reconciled at once until
+	// each cluster found at startup was reconciled once, oldest first.
+	// Disabled when zero.
+	WarmupConcurrency int
+}
+
+// maxConcurrentReconciles Returns the number of clusters reconciled at once.
+func (o QueueOptions) maxConcurrentReconciles() int {
+	if o.MaxConcurrentReconciles <= 0 {
+		return DefaultMaxConcurrentReconciles
+	}
+	return o.MaxConcurrentReconciles
+}
+
+// rateLimiter Returns the rate limiter of the queue: the failures of each
+// cluster back off exponentially, and the requeues of all the clusters are
+// limited by a token bucket.
+func (o QueueOptions) rateLimiter() workqueue.RateLimiter {
+	base, max := o.BaseDelay, o.MaxDelay
+	if base <= 0 {
+		base = DefaultBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultMaxDelay
+	}
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(base, max),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(queueQPS), queueBurst)},
+	)
+}
+
+// owns Watches the objects of the given type owned by a cluster. When
+// coalescing is enabled, the cluster is reconciled once the events received
+// over the coalescing delay were collected instead of on each event.
+func (r *IpfsReconciler) owns(bldr *builder.Builder, obj client.Object) *builder.Builder {
+	if r.Queue.Coalesce <= 0 {
+		return bldr.Owns(obj, builder.OnlyMetadata)
+	}
+	return bldr.Watches(&source.Kind{Type: obj},
+		coalescing{EventHandler: handler.EnqueueRequestsFromMapFunc(owningIpfs), delay: r.Queue.Coalesce},
+		builder.OnlyMetadata)
+}
+
+// owningIpfs Returns a reconcile request for the cluster controlling the object.
+func owningIpfs(obj client.Object) []reconcile.Request {
+	ref := metav1.GetControllerOf(obj)
+	if ref == nil || ref.Kind != "Ipfs" || ref.APIVersion != clusterv1alpha1.GroupVersion.String() {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: ref.Name},
+	}}
+}
+
+// coalescing Enqueues the requests of the wrapped handler after a delay. The
+// queue holds a single request per cluster, so the events received in the
+// meantime are collapsed into it.
+type coalescing struct {
+	handler.EventHandler
+	delay time.Duration
+}
+
+func (c coalescing) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	c.EventHandler.Create(evt, delayedQueue{RateLimitingInterface: q, delay: c.delay})
+}
+
+func (c coalescing) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	c.EventHandler.Update(evt, delayedQueue{RateLimitingInterface: q, delay: c.delay})
+}
+
+func (c coalescing) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	c.EventHandler.Delete(evt, delayedQueue{RateLimitingInterface: q, delay: c.delay})
+}
+
+func (c coalescing) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	c.EventHandler.Generic(evt, delayedQueue{RateLimitingInterface: q, delay: c.delay})
+}
+
+// delayedQueue Adds the items to the queue after a delay. An item already
+// waiting keeps its earlier deadline.
+type delayedQueue struct {
+	workqueue.RateLimitingInterface
+	delay time.Duration
+}
+
+func (q delayedQueue) Add(item interface{}) {
+	q.AddAfter(item, q.delay)
+}
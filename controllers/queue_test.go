@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs reconcile queue", func() {
+	owned := func(kind, apiVersion string) *appsv1.StatefulSet {
+		controller := true
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-queue"
+		sts.Namespace = "default"
+		sts.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: apiVersion,
+			Kind:       kind,
+			Name:       "queue",
+			Controller: &controller,
+		}}
+		return sts
+	}
+
+	It("keeps the defaults of controller-runtime", func() {
+		opts := QueueOptions{}
+		Expect(opts.maxConcurrentReconciles()).To(Equal(1))
+		limiter := opts.rateLimiter()
+		Expect(limiter.When("a")).To(Equal(5 * time.Millisecond))
+		Expect(limiter.When("a")).To(Equal(10 * time.Millisecond))
+
+		opts = QueueOptions{MaxConcurrentReconciles: 4, BaseDelay: time.Second, MaxDelay: 2 * time.Second}
+		Expect(opts.maxConcurrentReconciles()).To(Equal(4))
+		limiter = opts.rateLimiter()
+		Expect(limiter.When("a")).To(Equal(time.Second))
+		Expect(limiter.When("a")).To(Equal(2 * time.Second))
+		Expect(limiter.When("a")).To(Equal(2 * time.Second))
+	})
+
+	It("maps the owned objects to the cluster controlling them", func() {
+		Expect(owningIpfs(owned("Ipfs", clusterv1alpha1.GroupVersion.String()))).To(Equal([]reconcile.Request{{
+			NamespacedName: types.NamespacedName{Namespace: "default", Name: "queue"},
+		}}))
+		Expect(owningIpfs(owned("CircuitRelay", clusterv1alpha1.GroupVersion.String()))).To(BeEmpty())
+		Expect(owningIpfs(owned("Ipfs", "example.com/v1"))).To(BeEmpty())
+	})
+
+	It("collapses a burst of events into a single reconcile", func() {
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+		h := coalescing{EventHandler: handler.EnqueueRequestsFromMapFunc(owningIpfs), delay: 50 * time.Millisecond}
+		sts := owned("Ipfs", clusterv1alpha1.GroupVersion.String())
+		for i := 0; i < 20; i++ {
+			h.Update(event.UpdateEvent{ObjectOld: sts, ObjectNew: sts}, queue)
+		}
+		Expect(queue.Len()).To(BeZero())
+		Eventually(queue.Len).Should(Equal(1))
+		Consistently(queue.Len, 200*time.Millisecond).Should(Equal(1))
+	})
+})
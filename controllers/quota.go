@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+//+kubebuilder:rbac:groups=core,resources=resourcequotas,verbs=get;list
+
+// peerClaims Returns the volume claims of the peer with the given ordinal,
+// named and labeled as the StatefulSet would create them from its volume
+// claim templates.
+func peerClaims(m *clusterv1alpha1.Ipfs, ordinal int32) []*corev1.PersistentVolumeClaim {
+	claim := func(name string, storageClass *string, size string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-ipfs-cluster-%s-%d", name, m.Name, ordinal),
+				Namespace: m.Namespace,
+				Labels:    ipfsLabels(m, componentPeer),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: storageClass,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+				},
+			},
+		}
+	}
+	return []*corev1.PersistentVolumeClaim{
+		claim("cluster-storage", m.Spec.StorageClassName, m.Spec.ClusterStorage),
+		claim("ipfs-storage", storageClassFor(m, ordinal), ipfsStorageFor(m, ordinal)),
+	}
+}
+
+// isQuotaError Returns whether the API server refused a creation because it
+// exceeds a ResourceQuota.
+func isQuotaError(err error) bool {
+	return errors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota")
+}
+
+// scaleUpClaims Creates the volume claims of the peers a scale-up adds before
+// the StatefulSet does, so that a ResourceQuota too tight for them holds the
+// scale-up instead of leaving the new peers pending. It returns the number of
+// peers the StatefulSet may run: the requested one once every claim exists,
+// or its current one when a quota rejected a claim. The claims created by a
+// rejected scale-up are deleted, so that they do not use up the quota, and
+// the rejection is returned as a diagnosis.
+func (r *IpfsReconciler) scaleUpClaims(ctx context.Context, m *clusterv1alpha1.Ipfs) (int32, *diagnosis, error) {
+	current := int32(0)
+	sts := appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}, &sts)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, nil, fmt.Errorf("cannot get statefulset: %w", err)
+	}
+	if err == nil && sts.Spec.Replicas != nil {
+		current = *sts.Spec.Replicas
+	}
+	requested := m.Spec.Replicas
+	if requested <= current {
+		return requested, nil, nil
+	}
+
+	var created []*corev1.PersistentVolumeClaim
+	for ordinal := current; ordinal < requested; ordinal++ {
+		for _, claim := range peerClaims(m, ordinal) {
+			err := r.Get(ctx, client.ObjectKeyFromObject(claim), &corev1.PersistentVolumeClaim{})
+			if !errors.IsNotFound(err) {
+				if err != nil {
+					return 0, nil, fmt.Errorf("cannot get volume claim %s: %w", claim.Name, err)
+				}
+				continue
+			}
+			err = r.Create(ctx, claim)
+			if err == nil {
+				created = append(created, claim)
+				continue
+			}
+			if !isQuotaError(err) {
+				return 0, nil, fmt.Errorf("cannot create volume claim %s: %w", claim.Name, err)
+			}
+			return r.rollbackScaleUp(ctx, m, current, claim, created)
+		}
+	}
+	return requested, nil, nil
+}
+
+// rollbackScaleUp Deletes the claims created by a scale-up a quota rejected,
+// and reports the remaining capacity of the quotas of the namespace.
+func (r *IpfsReconciler) rollbackScaleUp(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	current int32,
+	rejected *corev1.PersistentVolumeClaim,
+	created []*corev1.PersistentVolumeClaim,
+) (int32, *diagnosis, error) {
+	for _, claim := range created {
+		if err := r.Delete(ctx, claim); client.IgnoreNotFound(err) != nil {
+			return 0, nil, fmt.Errorf("cannot delete volume claim %s: %w", claim.Name, err)
+		}
+	}
+	remaining, err := r.remainingQuota(ctx, m.Namespace, rejected.Spec.StorageClassName)
+	if err != nil {
+		return 0, nil, err
+	}
+	size := rejected.Spec.Resources.Requests[corev1.ResourceStorage]
+	message := fmt.Sprintf("quota rejected claim %s of %s, keeping %d of %d peers", rejected.Name,
+		size.String(), current, m.Spec.Replicas)
+	if remaining != "" {
+		message += "; remaining: " + remaining
+	}
+	r.eventf(m, corev1.EventTypeWarning, "QuotaExceeded", "%s", message)
+	return current, &diagnosis{reason: clusterv1alpha1.QuotaExceededReasonClaimsRejected, message: message}, nil
+}
+
+// remainingQuota Describes what the ResourceQuotas of the namespace have left
+// of the storage and of the number of claims, overall and for the storage
+// class of the claims. Quotas are read straight from the API server rather
+// than cached.
+func (r *IpfsReconciler) remainingQuota(ctx context.Context, namespace string, storageClass *string) (string, error) {
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	quotas := corev1.ResourceQuotaList{}
+	if err := reader.List(ctx, &quotas, client.InNamespace(namespace)); err != nil {
+		return "", fmt.Errorf("cannot list resource quotas: %w", err)
+	}
+	names := []corev1.ResourceName{corev1.ResourceRequestsStorage, corev1.ResourcePersistentVolumeClaims}
+	if storageClass != nil && *storageClass != "" {
+		prefix := *storageClass + ".storageclass.storage.k8s.io/"
+		names = append(names, corev1.ResourceName(prefix+string(corev1.ResourceRequestsStorage)),
+			corev1.ResourceName(prefix+string(corev1.ResourcePersistentVolumeClaims)))
+	}
+	sort.Slice(quotas.Items, func(i, j int) bool { return quotas.Items[i].Name < quotas.Items[j].Name })
+	var parts []string
+	for i := range quotas.Items {
+		quota := &quotas.Items[i]
+		for _, name := range names {
+			hard, ok := quota.Status.Hard[name]
+			if !ok {
+				continue
+			}
+			left := hard.DeepCopy()
+			left.Sub(quota.Status.Used[name])
+			parts = append(parts, fmt.Sprintf("%s %s %s of %s", quota.Name, name, left.String(), hard.String()))
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// quotaClient Enforces the claim count of a ResourceQuota as its admission
+// plugin would, keeping the used count of the quota up to date.
+type quotaClient struct {
+	client.Client
+	quota types.NamespacedName
+}
+
+func (c quotaClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+		if err := c.use(ctx, obj.GetName(), 1); err != nil {
+			return err
+		}
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c quotaClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if _, ok := obj.(*corev1.PersistentVolumeClaim); ok {
+		if err := c.use(ctx, obj.GetName(), -1); err != nil {
+			return err
+		}
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// use Adds to the claims the quota counts as used, refusing to go over it.
+func (c quotaClient) use(ctx context.Context, name string, claims int64) error {
+	quota := &corev1.ResourceQuota{}
+	if err := c.Client.Get(ctx, c.quota, quota); err != nil {
+		return err
+	}
+	used := quota.Status.Used[corev1.ResourcePersistentVolumeClaims]
+	hard := quota.Status.Hard[corev1.ResourcePersistentVolumeClaims]
+	if used.Value()+claims > hard.Value() {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "persistentvolumeclaims"}, name,
+			fmt.Errorf("exceeded quota: %s, requested: persistentvolumeclaims=1, used: persistentvolumeclaims=%d, "+
+				"limited: persistentvolumeclaims=%d", quota.Name, used.Value(), hard.Value()))
+	}
+	quota.Status.Used[corev1.ResourcePersistentVolumeClaims] = *resource.NewQuantity(used.Value()+claims,
+		resource.DecimalSI)
+	return c.Client.Status().Update(ctx, quota)
+}
+
+var _ = Describe("Ipfs scale-up quota", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		recorder   *record.FakeRecorder
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		quotaKey   types.NamespacedName
+	)
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	get := func() *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	replicas := func() int32 {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name},
+			sts)).To(Succeed())
+		return *sts.Spec.Replicas
+	}
+
+	claims := func() []string {
+		list := &corev1.PersistentVolumeClaimList{}
+		Expect(fakeClient.List(ctx, list, client.InNamespace(key.Namespace))).To(Succeed())
+		names := []string{}
+		for i := range list.Items {
+			names = append(names, list.Items[i].Name)
+		}
+		return names
+	}
+
+	scale := func(peers int32) {
+		instance := get()
+		instance.Spec.Replicas = peers
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+	}
+
+	setQuota := func(claims int64) {
+		quota := &corev1.ResourceQuota{}
+		Expect(fakeClient.Get(ctx, quotaKey, quota)).To(Succeed())
+		quota.Status.Hard[corev1.ResourcePersistentVolumeClaims] = *resource.NewQuantity(claims, resource.DecimalSI)
+		Expect(fakeClient.Status().Update(ctx, quota)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "quota"}
+		quotaKey = types.NamespacedName{Namespace: key.Namespace, Name: "storage"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "10Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		quota := &corev1.ResourceQuota{}
+		quota.Name = quotaKey.Name
+		quota.Namespace = quotaKey.Namespace
+		quota.Spec.Hard = corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("4")}
+		quota.Status.Hard = quota.Spec.Hard.DeepCopy()
+		quota.Status.Used = corev1.ResourceList{corev1.ResourcePersistentVolumeClaims: resource.MustParse("0")}
+		recorder = record.NewFakeRecorder(10)
+		fakeClient = quotaClient{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, quota).Build(),
+			quota:  quotaKey,
+		}
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Recorder: recorder}
+
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+	})
+
+	It("creates the claims of the new peers ahead of the StatefulSet", func() {
+		Expect(replicas()).To(Equal(int32(1)))
+		Expect(claims()).To(ConsistOf("cluster-storage-ipfs-cluster-quota-0", "ipfs-storage-ipfs-cluster-quota-0"))
+
+		scale(2)
+		reconcile()
+		Expect(replicas()).To(Equal(int32(2)))
+		Expect(claims()).To(HaveLen(4))
+		Expect(meta.FindStatusCondition(get().Status.Conditions, clusterv1alpha1.ConditionQuotaExceeded)).To(BeNil())
+	})
+
+	It("holds a scale-up the quota cannot fit and rolls its claims back", func() {
+		scale(3)
+		reconcile()
+		Expect(replicas()).To(Equal(int32(1)))
+		Expect(claims()).To(HaveLen(2))
+		cond := meta.FindStatusCondition(get().Status.Conditions, clusterv1alpha1.ConditionQuotaExceeded)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.QuotaExceededReasonClaimsRejected))
+		Expect(cond.Message).To(Equal("quota rejected claim cluster-storage-ipfs-cluster-quota-2 of 1Gi, " +
+			"keeping 1 of 3 peers; remaining: storage persistentvolumeclaims 2 of 4"))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning QuotaExceeded quota rejected claim")))
+
+		By("scaling up once the quota grows")
+		setQuota(6)
+		reconcile()
+		Expect(replicas()).To(Equal(int32(3)))
+		Expect(claims()).To(HaveLen(6))
+		Expect(meta.FindStatusCondition(get().Status.Conditions, clusterv1alpha1.ConditionQuotaExceeded)).To(BeNil())
+	})
+})
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// ReadinessPathPrefix Is the path of the metrics server under which the
+	// readiness of each cluster is served, as <prefix><namespace>/<name>.
+	ReadinessPathPrefix = "/readyz/ipfs/"
+
+	// readySinceKey Is the key of the ready ConfigMap holding the time the
+	// cluster last became ready.
+	readySinceKey = "readySince"
+	// readyGenerationKey Is the key of the ready ConfigMap holding the
+	// generation of the spec the cluster is ready with.
+	readyGenerationKey = "observedGeneration"
+)
+
+// readyConfigMapName Returns the name of the ConfigMap which exists while the
+// cluster is ready.
+func readyConfigMapName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-ready"
+}
+
+// syncReadyConfigMap Creates the ready ConfigMap of the cluster while its
+// Ready condition is true, and deletes it otherwise, so that the pods
+// mounting it do not start before the cluster is usable. It is called once
+// the status is written, so that the ConfigMap never runs ahead of the
+// condition.
+func (r *IpfsReconciler) syncReadyConfigMap(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReady)
+	if cond == nil || cond.Status != metav1.ConditionTrue || instance.DeletionTimestamp != nil {
+		return r.deleteReadyConfigMap(ctx, instance)
+	}
+	cm := corev1.ConfigMap{}
+	cm.Name = readyConfigMapName(instance)
+	cm.Namespace = instance.Namespace
+	_, err := controllerutil.CreateOrPatch(ctx, r.Client, &cm, func() error {
+		cm.Labels = ipfsLabels(instance, componentReadiness)
+		cm.Data = map[string]string{
+			readySinceKey:      cond.LastTransitionTime.UTC().Format(time.RFC3339),
+			readyGenerationKey: strconv.FormatInt(cond.ObservedGeneration, 10),
+		}
+		return ctrl.SetControllerReference(instance, &cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write ready configmap: %w", err)
+	}
+	return nil
+}
+
+// deleteReadyConfigMap Deletes the ready ConfigMap of the cluster, if any.
+func (r *IpfsReconciler) deleteReadyConfigMap(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	cm := corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: readyConfigMapName(instance)}
+	if err := r.Get(ctx, key, &cm); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.Delete(ctx, &cm); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete ready configmap: %w", err)
+	}
+	return nil
+}
+
+// ReadinessHandler Serves the Ready condition of the clusters, for the init
+// containers of the workloads depending on them. It answers 200 while the
+// cluster is ready, 503 while it is not or is being deleted, and 404 for the
+// clusters which do not exist.
+type ReadinessHandler struct {
+	// Reader Reads the clusters, usually from the cache of the manager.
+	Reader client.Reader
+}
+
+// ServeHTTP Writes the readiness of the cluster named by the path, as
+// <ReadinessPathPrefix><namespace>/<name>.
+func (h ReadinessHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, ReadinessPathPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "expected "+ReadinessPathPrefix+"<namespace>/<name>", http.StatusNotFound)
+		return
+	}
+	instance := &clusterv1alpha1.Ipfs{}
+	if err := h.Reader.Get(req.Context(), client.ObjectKey{Namespace: parts[0], Name: parts[1]}, instance); err != nil {
+		if errors.IsNotFound(err) {
+			http.Error(w, "cluster not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReady)
+	switch {
+	case instance.DeletionTimestamp != nil:
+		http.Error(w, "cluster is being deleted", http.StatusServiceUnavailable)
+	case cond == nil:
+		http.Error(w, "cluster readiness is unknown", http.StatusServiceUnavailable)
+	case cond.Status != metav1.ConditionTrue:
+		http.Error(w, cond.Message, http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
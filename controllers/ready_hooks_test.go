@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs ready hooks", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() ctrl.Result {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	// setPeersReady Sets how many peers of the StatefulSet are ready.
+	setPeersReady := func(ready int32) {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name},
+			sts)).To(Succeed())
+		sts.Status.ObservedGeneration = sts.Generation
+		sts.Status.Replicas = 1
+		sts.Status.ReadyReplicas = ready
+		sts.Status.UpdatedReplicas = 1
+		sts.Status.CurrentRevision = "rev-1"
+		sts.Status.UpdateRevision = "rev-1"
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+	}
+
+	readyConfigMap := func() (*corev1.ConfigMap, error) {
+		cm := &corev1.ConfigMap{}
+		err := fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "hooks-ready"}, cm)
+		return cm, err
+	}
+
+	probe := func(path string) int {
+		rec := httptest.NewRecorder()
+		ReadinessHandler{Reader: fakeClient}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "hooks"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("follows the Ready condition across its transitions", func() {
+		reconcile()
+		reconcile()
+		_, err := readyConfigMap()
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(probe("/readyz/ipfs/default/hooks")).To(Equal(http.StatusServiceUnavailable))
+
+		By("creating the ConfigMap once the peers are ready")
+		setPeersReady(1)
+		reconcile()
+		cm, err := readyConfigMap()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm.Data).To(HaveKey(readySinceKey))
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+		Expect(probe("/readyz/ipfs/default/hooks")).To(Equal(http.StatusOK))
+
+		By("withdrawing both within one sync interval once a peer becomes unready")
+		setPeersReady(0)
+		result := reconcile()
+		interval := reconciler.Defaults.Get().StatusSyncInterval.Duration
+		Expect(result.RequeueAfter).To(BeNumerically("<=", interval))
+		Expect(result.RequeueAfter).To(BeNumerically(">=", interval-time.Duration(statusSyncJitter*float64(interval))))
+		_, err = readyConfigMap()
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(probe("/readyz/ipfs/default/hooks")).To(Equal(http.StatusServiceUnavailable))
+
+		By("restoring both once the peer recovers")
+		setPeersReady(1)
+		reconcile()
+		_, err = readyConfigMap()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(probe("/readyz/ipfs/default/hooks")).To(Equal(http.StatusOK))
+	})
+
+	It("serves unknown clusters as not found", func() {
+		Expect(probe("/readyz/ipfs/default/missing")).To(Equal(http.StatusNotFound))
+		Expect(probe("/readyz/ipfs/default")).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// Field indexes over the names of the objects referenced by an Ipfs spec.
+// References are local object references, so they never cross namespaces.
+const (
+	secretRefsIndex = ".spec.secretRefs"
+	// tlsSecretIndex Maps the certificate Secret of the gateway back to its
+	// Ipfs resource. It is kept apart from the other Secrets, which roll the
+	// pods when they change.
+	tlsSecretIndex = ".spec.expose.tls.secretName"
+	// federationSecretIndex Maps the Secrets holding imported peer bundles
+	// back to their Ipfs resources. Like the certificate, they do not roll
+	// the pods.
+	federationSecretIndex = ".spec.federation.importSecretRefs"
+)
+
+// secretIndexes Holds the function extracting the names of the Secrets
+// referenced by a spec for each of the field indexes.
+var secretIndexes = map[string]func(*clusterv1alpha1.Ipfs) []string{
+	secretRefsIndex:       referencedSecrets,
+	tlsSecretIndex:        referencedTLSSecrets,
+	federationSecretIndex: referencedFederationSecrets,
+}
+
+// referencedSecrets Returns the names of the user-provided Secrets the
+// cluster consumes.
+func referencedSecrets(m *clusterv1alpha1.Ipfs) []string {
+	var names []string
+	if auth := m.Spec.Expose.Auth; auth != nil {
+		if auth.BasicAuthSecretRef != nil {
+			names = append(names, auth.BasicAuthSecretRef.Name)
+		}
+		if auth.OIDC != nil {
+			names = append(names, auth.OIDC.ClientSecretRef.Name)
+		}
+	}
+	if m.Spec.Datastore != nil && m.Spec.Datastore.S3 != nil {
+		names = append(names, m.Spec.Datastore.S3.CredentialsSecretRef.Name)
+	}
+	if m.Spec.Routing != nil {
+		for _, router := range m.Spec.Routing.Routers {
+			if router.AuthSecretRef != nil {
+				names = append(names, router.AuthSecretRef.Name)
+			}
+		}
+	}
+	return names
+}
+
+// referencedTLSSecrets Returns the name of the Secret holding the certificate
+// of the gateway, when it is served over TLS.
+func referencedTLSSecrets(m *clusterv1alpha1.Ipfs) []string {
+	if m.Spec.Expose.TLS == nil {
+		return nil
+	}
+	return []string{tlsSecretName(m)}
+}
+
+// indexReferences Registers the field indexes used to map a Secret back to
+// the Ipfs resources referencing it.
+func indexReferences(ctx context.Context, mgr ctrl.Manager) error {
+	indexer := mgr.GetFieldIndexer()
+	for index, referenced := range secretIndexes {
+		referenced := referenced
+		if err := indexer.IndexField(ctx, &clusterv1alpha1.Ipfs{}, index, func(obj client.Object) []string {
+			return referenced(obj.(*clusterv1alpha1.Ipfs))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userSecret Filters out the events of the Secrets the operator generates
+// for its clusters, which no spec references and which the controller
+// already watches as their owner, and those of the service account tokens.
+var userSecret = predicate.NewPredicateFuncs(func(obj client.Object) bool {
+	if sec, ok := obj.(*corev1.Secret); ok && sec.Type == corev1.SecretTypeServiceAccountToken {
+		return false
+	}
+	owner := metav1.GetControllerOf(obj)
+	return owner == nil || owner.Kind != "Ipfs" || owner.APIVersion != clusterv1alpha1.GroupVersion.String()
+})
+
+// referencingIpfs Returns a handler which enqueues every Ipfs resource whose
+// spec references the changed object through any of the given indexes.
+func (r *IpfsReconciler) referencingIpfs(indexes ...string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(r.mapReferencing(indexes...))
+}
+
+// mapReferencing Returns the function mapping an object to the Ipfs
+// resources of its namespace referencing it through any of the given indexes,
+// each resource once.
+func (r *IpfsReconciler) mapReferencing(indexes ...string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		seen := map[types.NamespacedName]bool{}
+		var requests []reconcile.Request
+		for _, index := range indexes {
+			ipfsList := clusterv1alpha1.IpfsList{}
+			if err := r.List(context.Background(), &ipfsList,
+				client.InNamespace(obj.GetNamespace()),
+				client.MatchingFields{index: obj.GetName()},
+			); err != nil {
+				ctrllog.Log.Error(err, "cannot list Ipfs resources referencing object",
+					"index", index, "name", obj.GetName(), "namespace", obj.GetNamespace())
+				continue
+			}
+			for _, req := range ipfsRequests(ipfsList.Items) {
+				if !seen[req.NamespacedName] {
+					seen[req.NamespacedName] = true
+					requests = append(requests, req)
+				}
+			}
+		}
+		return requests
+	}
+}
+
+// allIpfs Returns a handler which enqueues every Ipfs resource.
+func (r *IpfsReconciler) allIpfs() handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		ipfsList := clusterv1alpha1.IpfsList{}
+		if err := r.List(context.Background(), &ipfsList); err != nil {
+			ctrllog.Log.Error(err, "cannot list Ipfs resources")
+			return nil
+		}
+		return ipfsRequests(ipfsList.Items)
+	})
+}
+
+// ipfsRequests Returns a reconcile request for each of the given resources.
+func ipfsRequests(items []clusterv1alpha1.Ipfs) []reconcile.Request {
+	requests := make([]reconcile.Request, 0, len(items))
+	for i := range items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      items[i].Name,
+				Namespace: items[i].Namespace,
+			},
+		})
+	}
+	return requests
+}
+
+// referencedConfigHash Returns a hash over the contents of the Secrets
+// referenced by the spec, over the settings the peers only read as they
+// start and over the peer IDs of the relays they peer with, or an empty
+// string when there are none. It is set on
+// the pod template so that the pods roll when the contents change.
+func (r *IpfsReconciler) referencedConfigHash(ctx context.Context, m *clusterv1alpha1.Ipfs) (string, error) {
+	secrets := referencedSecrets(m)
+	settings := dnsSettings(m)
+	if r.Webhooks {
+		settings = append(settings, overrideSettings(m)...)
+	}
+	settings = append(settings, swarmSettings(m)...)
+	settings = append(settings, resourceManagerSettings(m)...)
+	settings = append(settings, bandwidthSettings(m)...)
+	settings = append(settings, routingSettings(m)...)
+	settings = append(settings, httpHeadersSettings(m)...)
+	relays, err := r.clusterRelays(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	for _, relay := range relays {
+		settings = append(settings, "relay="+relay.id)
+	}
+	if len(secrets) == 0 && len(settings) == 0 {
+		return "", nil
+	}
+	sort.Strings(secrets)
+
+	hash := sha256.New()
+	for _, name := range secrets {
+		sec := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: name}, &sec); err != nil {
+			if errors.IsNotFound(err) {
+				return "", dependencyMissing("Secret", name, err)
+			}
+			return "", fmt.Errorf("cannot get referenced secret %s: %w", name, err)
+		}
+		fmt.Fprintf(hash, "secret/%s\n", name)
+		keys := make([]string, 0, len(sec.Data))
+		for k := range sec.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(hash, "%s=%x\n", k, sec.Data[k])
+		}
+	}
+	for _, setting := range settings {
+		fmt.Fprintf(hash, "setting/%s\n", setting)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
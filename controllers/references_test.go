@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// indexingClient Serves the lists of Ipfs resources matching a field index
+// from the index functions, as the cache of the manager does. The fake client
+// ignores field selectors.
+type indexingClient struct {
+	client.Client
+}
+
+func (c indexingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	ipfsList, ok := list.(*clusterv1alpha1.IpfsList)
+	if !ok || listOpts.FieldSelector == nil {
+		return c.Client.List(ctx, list, opts...)
+	}
+	if err := c.Client.List(ctx, ipfsList, client.InNamespace(listOpts.Namespace)); err != nil {
+		return err
+	}
+	items := ipfsList.Items[:0]
+	for _, item := range ipfsList.Items {
+		for index, referenced := range secretIndexes {
+			name, found := listOpts.FieldSelector.RequiresExactMatch(index)
+			if !found {
+				continue
+			}
+			for _, ref := range referenced(&item) {
+				if ref == name {
+					items = append(items, item)
+					break
+				}
+			}
+		}
+	}
+	ipfsList.Items = items
+	return nil
+}
+
+var _ = Describe("Ipfs referenced secrets", func() {
+	var reconciler *IpfsReconciler
+
+	newIpfs := func(namespace, name string) *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = name
+		instance.Namespace = namespace
+		return instance
+	}
+
+	secret := func(namespace, name string) *corev1.Secret {
+		sec := &corev1.Secret{}
+		sec.Name = name
+		sec.Namespace = namespace
+		return sec
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		s3 := newIpfs("default", "s3")
+		s3.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{Backend: "s3", S3: &clusterv1alpha1.S3Datastore{
+			CredentialsSecretRef: corev1.LocalObjectReference{Name: "shared"},
+		}}
+		auth := newIpfs("default", "auth")
+		auth.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+			BasicAuthSecretRef: &corev1.LocalObjectReference{Name: "shared"},
+		}
+		auth.Spec.Expose.Host = "gateway.example.com"
+		auth.Spec.Expose.TLS = &clusterv1alpha1.TLSConfig{SecretName: "gateway-cert"}
+		federated := newIpfs("default", "federated")
+		federated.Spec.Federation = &clusterv1alpha1.FederationConfig{
+			ImportSecretRefs: []corev1.LocalObjectReference{{Name: "gateway-cert"}, {Name: "bundle"}},
+		}
+		elsewhere := newIpfs("other", "s3")
+		elsewhere.Spec.Datastore = s3.Spec.Datastore.DeepCopy()
+
+		reconciler = &IpfsReconciler{
+			Client: indexingClient{fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(s3, auth, federated, elsewhere).Build()},
+			Scheme: scheme,
+		}
+	})
+
+	It("indexes the names of the secrets each spec references", func() {
+		instance := newIpfs("default", "indexed")
+		Expect(referencedSecrets(instance)).To(BeEmpty())
+		Expect(referencedTLSSecrets(instance)).To(BeEmpty())
+		Expect(referencedFederationSecrets(instance)).To(BeEmpty())
+
+		instance.Spec.Expose.Auth = &clusterv1alpha1.AuthConfig{
+			OIDC: &clusterv1alpha1.OIDCConfig{ClientSecretRef: corev1.LocalObjectReference{Name: "oidc"}},
+		}
+		instance.Spec.Datastore = &clusterv1alpha1.DatastoreConfig{S3: &clusterv1alpha1.S3Datastore{
+			CredentialsSecretRef: corev1.LocalObjectReference{Name: "s3"},
+		}}
+		instance.Spec.Expose.TLS = &clusterv1alpha1.TLSConfig{
+			IssuerRef: &clusterv1alpha1.IssuerReference{Name: "letsencrypt"},
+		}
+		Expect(referencedSecrets(instance)).To(ConsistOf("oidc", "s3"))
+		Expect(referencedTLSSecrets(instance)).To(ConsistOf("ipfs-gateway-indexed-tls"))
+	})
+
+	It("maps a secret to the clusters of its namespace referencing it", func() {
+		mapFunc := reconciler.mapReferencing(secretRefsIndex, tlsSecretIndex, federationSecretIndex)
+		request := func(name string) reconcile.Request {
+			return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: name}}
+		}
+
+		Expect(mapFunc(secret("default", "shared"))).To(ConsistOf(request("s3"), request("auth")))
+		Expect(mapFunc(secret("default", "gateway-cert"))).To(ConsistOf(request("auth"), request("federated")))
+		Expect(mapFunc(secret("default", "bundle"))).To(ConsistOf(request("federated")))
+		Expect(mapFunc(secret("default", "unrelated"))).To(BeEmpty())
+		Expect(mapFunc(secret("other", "shared"))).To(ConsistOf(reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: "other", Name: "s3"},
+		}))
+
+		By("only looking up the given indexes")
+		Expect(reconciler.mapReferencing(tlsSecretIndex)(secret("default", "gateway-cert"))).To(
+			ConsistOf(request("auth")))
+	})
+
+	It("ignores the secrets the operator generates and the service account tokens", func() {
+		generated := secret("default", "ipfs-cluster-s3-cluster-secret")
+		generated.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(
+			newIpfs("default", "s3"), clusterv1alpha1.GroupVersion.WithKind("Ipfs"))}
+		Expect(userSecret.Create(event.CreateEvent{Object: generated})).To(BeFalse())
+
+		token := secret("default", "token")
+		token.Type = corev1.SecretTypeServiceAccountToken
+		Expect(userSecret.Update(event.UpdateEvent{ObjectOld: token, ObjectNew: token})).To(BeFalse())
+
+		Expect(userSecret.Create(event.CreateEvent{Object: secret("default", "shared")})).To(BeTrue())
+	})
+})
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// relayPeer Is a circuit relay of the cluster, with the peer ID of its daemon.
+type relayPeer struct {
+	name string
+	id   string
+}
+
+// clusterRelays Returns the circuit relays of the cluster which published
+// their peer ID, in the order of the status. Relays which no longer exist
+// are left out.
+func (r *IpfsReconciler) clusterRelays(ctx context.Context, m *clusterv1alpha1.Ipfs) ([]relayPeer, error) {
+	var relays []relayPeer
+	for _, name := range m.Status.CircuitRelays {
+		relay := clusterv1alpha1.CircuitRelay{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: name}, &relay)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot get circuit relay %s: %w", name, err)
+		}
+		if relay.Status.AddrInfo.ID == "" {
+			continue
+		}
+		relays = append(relays, relayPeer{name: name, id: relay.Status.AddrInfo.ID})
+	}
+	return relays, nil
+}
+
+// syncRelayLinks Checks that the kubo node of every member is connected to
+// each circuit relay of the cluster, and that the relay is in its peering
+// set, so that the connection manager does not prune the connection the
+// peer is reachable through. The RelayLinkDown condition names the relays
+// some members are not linked to, a missing connection taking precedence
+// over a missing protection. The connections are those the mesh check
+// listed, so that the nodes are not queried twice per check. Members which
+// cannot be queried are left out.
+func (r *IpfsReconciler) syncRelayLinks(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	members []meshMember,
+) error {
+	log := ctrllog.FromContext(ctx)
+	relays, err := r.clusterRelays(ctx, instance)
+	if err != nil {
+		return err
+	}
+	var disconnected, unprotected []string
+	for _, member := range members {
+		if len(relays) == 0 || member.connected == nil {
+			continue
+		}
+		peering, err := member.api.SwarmPeeringLs(ctx)
+		if err != nil {
+			log.Info("cannot list the peering set of the peer", "peer", member.pod, "error", err.Error())
+			continue
+		}
+		protected := make(map[string]bool, len(peering))
+		for _, id := range peering {
+			protected[id] = true
+		}
+		for _, relay := range relays {
+			switch {
+			case !member.connected[relay.id]:
+				disconnected = append(disconnected, member.pod+" -> "+relay.name)
+			case !protected[relay.id]:
+				unprotected = append(unprotected, member.pod+" -> "+relay.name)
+			}
+		}
+	}
+
+	var d *diagnosis
+	switch {
+	case len(disconnected) > 0:
+		d = &diagnosis{
+			reason:  clusterv1alpha1.RelayLinkDownReasonDisconnected,
+			message: "peers not connected to their relays: " + strings.Join(disconnected, ", "),
+		}
+	case len(unprotected) > 0:
+		d = &diagnosis{
+			reason: clusterv1alpha1.RelayLinkDownReasonUnprotected,
+			message: "peers whose connection to their relays is not protected, " +
+				"as the relays are missing from their peering set: " + strings.Join(unprotected, ", "),
+		}
+	}
+	setDiagnosisCondition(instance, clusterv1alpha1.ConditionRelayLinkDown, d)
+	return nil
+}
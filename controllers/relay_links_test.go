@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs relay links", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		nodes      []*kubofake.Node
+		relayID    string
+	)
+
+	// check Runs a status sync and returns the RelayLinkDown condition.
+	check := func() *metav1.Condition {
+		instance.Status.Mesh = nil
+		Expect(reconciler.syncMesh(ctx, instance)).To(Succeed())
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionRelayLinkDown)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "linked"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.Networking.CircuitRelays = 1
+		instance.Status.CircuitRelays = []string{"linked-0"}
+		relayID = newID()
+		relay := &clusterv1alpha1.CircuitRelay{}
+		relay.Name = "linked-0"
+		relay.Namespace = instance.Namespace
+		relay.Status.AddrInfo = clusterv1alpha1.AddrInfoBasicType{
+			ID:    relayID,
+			Addrs: []string{"/ip4/203.0.113.7/tcp/4001"},
+		}
+
+		builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, relay)
+		dialer := kubofake.NewDialer()
+		nodes = nil
+		for i, id := range []string{"12D3KooWA", "12D3KooWB"} {
+			pod := &corev1.Pod{}
+			pod.Name = peerPodName(instance, int32(i))
+			pod.Namespace = instance.Namespace
+			pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			builder = builder.WithObjects(pod)
+			node := kubofake.NewNode(id)
+			node.Peering = []string{relayID}
+			dialer.Add(instance.Namespace, pod.Name, node)
+			nodes = append(nodes, node)
+		}
+		nodes[0].Peers = []kuboapi.SwarmPeer{{Peer: "12D3KooWB"}, {Peer: relayID}}
+		nodes[1].Peers = []kuboapi.SwarmPeer{{Peer: "12D3KooWA"}, {Peer: relayID}}
+		fakeClient = builder.Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer}
+	})
+
+	It("names the relays the peers are not linked to", func() {
+		Expect(check()).To(BeNil())
+
+		By("dropping the connection of a peer to the relay")
+		nodes[1].Peers = []kuboapi.SwarmPeer{{Peer: "12D3KooWA"}}
+		cond := check()
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.RelayLinkDownReasonDisconnected))
+		Expect(cond.Message).To(HaveSuffix("ipfs-cluster-linked-1 -> linked-0"))
+
+		By("reconnecting it without protecting the connection")
+		nodes[1].Peers = append(nodes[1].Peers, kuboapi.SwarmPeer{Peer: relayID})
+		nodes[1].Peering = nil
+		cond = check()
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.RelayLinkDownReasonUnprotected))
+		Expect(cond.Message).To(HaveSuffix("ipfs-cluster-linked-1 -> linked-0"))
+
+		nodes[1].Peering = []string{relayID}
+		Expect(check()).To(BeNil())
+	})
+
+	It("rolls the peers once a relay is removed", func() {
+		withRelay, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(withRelay).NotTo(BeEmpty())
+
+		instance.Spec.Networking.CircuitRelays = 0
+		Expect(reconciler.createCircuitRelays(ctx, instance)).To(Succeed())
+		Expect(instance.Status.CircuitRelays).To(BeEmpty())
+		err = fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace, Name: "linked-0"},
+			&clusterv1alpha1.CircuitRelay{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		withoutRelay, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(withoutRelay).NotTo(Equal(withRelay))
+	})
+})
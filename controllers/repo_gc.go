@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+const (
+	// defaultGCQuietPeriod Is how long after the last unpin a garbage
+	// collection pass starts, unless the spec sets it.
+	defaultGCQuietPeriod = 10 * time.Minute
+	// gcPollInterval Is how long a pass waits before collecting the next
+	// peer, and how often a deferred pass checks whether it may go on.
+	gcPollInterval = 15 * time.Second
+)
+
+// gcAfterUnpin Returns whether the repos of the peers are collected after
+// unpins.
+func gcAfterUnpin(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.GC != nil && m.Spec.GC.AfterUnpin
+}
+
+// gcQuietPeriod Returns how long after the last unpin a pass starts.
+func gcQuietPeriod(m *clusterv1alpha1.Ipfs) time.Duration {
+	if m.Spec.GC != nil && m.Spec.GC.QuietPeriod != nil {
+		return m.Spec.GC.QuietPeriod.Duration
+	}
+	return defaultGCQuietPeriod
+}
+
+// lastUnpin Returns the time of the last unpin made by the IpfsContent and
+// IpfsIngest resources of the cluster, or nil when none unpinned anything.
+func (r *IpfsReconciler) lastUnpin(ctx context.Context, instance *clusterv1alpha1.Ipfs) (*metav1.Time, error) {
+	contents := clusterv1alpha1.IpfsContentList{}
+	if err := r.List(ctx, &contents, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, fmt.Errorf("cannot list IpfsContent resources: %w", err)
+	}
+	ingests := clusterv1alpha1.IpfsIngestList{}
+	if err := r.List(ctx, &ingests, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, fmt.Errorf("cannot list IpfsIngest resources: %w", err)
+	}
+	var last *metav1.Time
+	record := func(cluster string, unpin *metav1.Time) {
+		if cluster == instance.Name && unpin != nil && (last == nil || unpin.After(last.Time)) {
+			last = unpin
+		}
+	}
+	for i := range contents.Items {
+		record(contents.Items[i].Spec.ClusterRef.Name, contents.Items[i].Status.LastUnpinTime)
+	}
+	for i := range ingests.Items {
+		record(ingests.Items[i].Spec.ClusterRef.Name, ingests.Items[i].Status.LastUnpinTime)
+	}
+	return last, nil
+}
+
+// gcDeferral Returns why a pass may not collect a repo now, or an empty
+// string. Collecting takes the lock of the blockstore of the peer, which
+// slows down the peers a rollout or a repair relies on, and a rollout could
+// restart the peer being collected.
+func gcDeferral(instance *clusterv1alpha1.Ipfs) string {
+	conds := instance.Status.Conditions
+	audit := instance.Status.PostPartitionAudit
+	switch {
+	case !meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionReady):
+		return "the cluster is not ready"
+	case meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionUpgrading):
+		return "the peers are rolling out"
+	case meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionCompacting):
+		return "the state of the peers is being compacted"
+	case meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionRotating):
+		return "the cluster secret is being rotated"
+	case meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionIdentityRotating):
+		return "the identity of a peer is being rotated"
+	case audit != nil && audit.CompletedAt == nil:
+		return "the pinset is being repaired after a partition"
+	}
+	return ""
+}
+
+// reconcileGC Collects the repos of the peers once the IpfsContent resources
+// of the cluster unpinned content and the quiet period went by without
+// another unpin, so that the unpins made meanwhile are collected by a single
+// pass. The pass collects one peer per reconcile, skipping the peers still
+// fetching pins, and waits while the cluster rolls out, rotates a secret or
+// an identity, compacts its state or repairs its pinset. It returns how long
+// to wait before checking on the pass again.
+func (r *IpfsReconciler) reconcileGC(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) (time.Duration, error) {
+	if !gcAfterUnpin(resolved) {
+		instance.Status.GC = nil
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionCollectingGarbage)
+		return 0, nil
+	}
+	if instance.Status.GC == nil {
+		instance.Status.GC = &clusterv1alpha1.GCStatus{}
+	}
+	status := instance.Status.GC
+	if status.Pass == nil {
+		unpinned, err := r.lastUnpin(ctx, instance)
+		if err != nil {
+			return 0, err
+		}
+		if unpinned == nil || (status.LastPass != nil && !unpinned.After(status.LastPass.UnpinnedAt.Time)) {
+			return 0, nil
+		}
+		if quiet := time.Until(unpinned.Add(gcQuietPeriod(resolved))); quiet > 0 {
+			return quiet, nil
+		}
+		status.Pass = &clusterv1alpha1.GCPass{UnpinnedAt: *unpinned, StartedAt: metav1.Now()}
+	}
+	pass := status.Pass
+
+	if reason := gcDeferral(instance); reason != "" {
+		setCollectingGarbageCondition(instance, metav1.ConditionFalse, clusterv1alpha1.GCReasonDeferred,
+			fmt.Sprintf("collecting the repos after %d of %d peers waits: %s",
+				len(pass.Peers), peerCount(instance), reason))
+		return gcPollInterval, nil
+	}
+	if ordinal := int32(len(pass.Peers)); ordinal < peerCount(instance) {
+		record, err := r.collectPeer(ctx, instance, ordinal)
+		if err != nil {
+			setCollectingGarbageCondition(instance, metav1.ConditionFalse, clusterv1alpha1.GCReasonDeferred,
+				fmt.Sprintf("collecting the repo of %s waits: %s", peerPodName(instance, ordinal), err.Error()))
+			return gcPollInterval, nil
+		}
+		pass.Peers = append(pass.Peers, record)
+		pass.ReclaimedBytes += record.ReclaimedBytes
+	}
+	if int32(len(pass.Peers)) < peerCount(instance) {
+		setCollectingGarbageCondition(instance, metav1.ConditionTrue, clusterv1alpha1.GCReasonInProgress,
+			fmt.Sprintf("collected the repos of %d of %d peers, reclaiming %d bytes",
+				len(pass.Peers), peerCount(instance), pass.ReclaimedBytes))
+		return gcPollInterval, nil
+	}
+
+	completed := metav1.Now()
+	pass.CompletedAt = &completed
+	status.LastPass = pass
+	status.Pass = nil
+	message := fmt.Sprintf("reclaimed %d bytes from the repos of %d peers", pass.ReclaimedBytes, len(pass.Peers))
+	if skipped := skippedPeers(pass); skipped > 0 {
+		message += fmt.Sprintf(", skipping %d", skipped)
+	}
+	setCollectingGarbageCondition(instance, metav1.ConditionFalse, clusterv1alpha1.GCReasonComplete, message)
+	r.eventf(instance, corev1.EventTypeNormal, "GarbageCollected", "%s", message)
+	return 0, nil
+}
+
+// collectPeer Collects the repo of the peer with the given ordinal and
+// returns the space it reclaimed, as the size of the repo before and after.
+// A peer fetching pins is skipped rather than slowed down, as is a peer whose
+// kubo node cannot be reached. It fails when the pins in progress cannot be
+// listed, so that the peer is tried again.
+func (r *IpfsReconciler) collectPeer(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	ordinal int32,
+) (clusterv1alpha1.PeerGC, error) {
+	log := ctrllog.FromContext(ctx)
+	name := peerPodName(instance, ordinal)
+	record := clusterv1alpha1.PeerGC{Name: name}
+	pinning, err := r.pinsInProgress(ctx, instance, name)
+	if err != nil {
+		return record, err
+	}
+	if pinning > 0 {
+		record.Skipped = fmt.Sprintf("%d pins in progress", pinning)
+		return record, nil
+	}
+	kubo, err := r.kuboAPI(instance, ordinal)
+	if err != nil {
+		record.Skipped = err.Error()
+		return record, nil
+	}
+	before, err := kubo.RepoStat(ctx)
+	if err != nil {
+		record.Skipped = err.Error()
+		return record, nil
+	}
+	record.RemovedBlocks, err = kubo.RepoGC(ctx)
+	if err != nil {
+		record.Skipped = err.Error()
+		log.Info("cannot collect the repo of a peer", "peer", name, "error", err.Error())
+	}
+	after, err := kubo.RepoStat(ctx)
+	if err == nil && after.RepoSize < before.RepoSize {
+		record.ReclaimedBytes = int64(before.RepoSize - after.RepoSize)
+	}
+	log.Info("collected the repo of a peer", "peer", name,
+		"removedBlocks", record.RemovedBlocks, "reclaimedBytes", record.ReclaimedBytes)
+	return record, nil
+}
+
+// pinsInProgress Returns how many pins the named peer is fetching or has
+// queued.
+func (r *IpfsReconciler) pinsInProgress(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	name string,
+) (int, error) {
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return 0, err
+	}
+	infos, err := api.StatusAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot list the pins in progress: %w", err)
+	}
+	pinning := 0
+	for _, info := range infos {
+		for _, pin := range info.PeerMap {
+			if pin.Peername == name && (pin.Status == clusterapi.TrackerStatusPinning ||
+				pin.Status == clusterapi.TrackerStatusPinQueued) {
+				pinning++
+			}
+		}
+	}
+	return pinning, nil
+}
+
+// skippedPeers Returns how many peers the pass did not collect.
+func skippedPeers(pass *clusterv1alpha1.GCPass) int {
+	skipped := 0
+	for _, p := range pass.Peers {
+		if p.Skipped != "" {
+			skipped++
+		}
+	}
+	return skipped
+}
+
+func setCollectingGarbageCondition(
+	instance *clusterv1alpha1.Ipfs,
+	status metav1.ConditionStatus,
+	reason, message string,
+) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionCollectingGarbage,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
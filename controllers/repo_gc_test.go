@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs garbage collection after unpins", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		cluster    *clusterfake.Cluster
+		nodes      []*kubofake.Node
+		instance   *clusterv1alpha1.Ipfs
+		content    *clusterv1alpha1.IpfsContent
+	)
+
+	// unpin Records an unpin of the content at the given time.
+	unpin := func(at time.Time) {
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(content), content)).To(Succeed())
+		unpinned := metav1.NewTime(at)
+		content.Status.LastUnpinTime = &unpinned
+		Expect(fakeClient.Status().Update(ctx, content)).To(Succeed())
+	}
+
+	// collecting Returns the CollectingGarbage condition of the cluster.
+	collecting := func() *metav1.Condition {
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionCollectingGarbage)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "archive"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 2
+		instance.Spec.GC = &clusterv1alpha1.GCConfig{
+			AfterUnpin:  true,
+			QuietPeriod: &metav1.Duration{Duration: time.Minute},
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:   clusterv1alpha1.ConditionReady,
+			Status: metav1.ConditionTrue,
+			Reason: "AllPeersReady",
+		})
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		content = &clusterv1alpha1.IpfsContent{}
+		content.Name = "release"
+		content.Namespace = instance.Namespace
+		content.Spec.ClusterRef.Name = instance.Name
+		other := &clusterv1alpha1.IpfsContent{}
+		other.Name = "elsewhere"
+		other.Namespace = instance.Namespace
+		other.Spec.ClusterRef.Name = "other"
+		recent := metav1.Now()
+		other.Status.LastUnpinTime = &recent
+
+		cluster = clusterfake.NewCluster(
+			clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)},
+			clusterapi.Peer{ID: "12D3KooWClusterB", Peername: peerPodName(instance, 1)},
+		)
+		dialer := kubofake.NewDialer()
+		nodes = nil
+		for ordinal, id := range []string{"12D3KooWA", "12D3KooWB"} {
+			node := kubofake.NewNode(id)
+			node.Repo.RepoSize = 10000
+			node.Repo.NumObjects = 3
+			node.Garbage = map[string]uint64{"bafkreiold": 3000, "bafkreiolder": 1000}
+			dialer.Add(instance.Namespace, peerPodName(instance, int32(ordinal)), node)
+			nodes = append(nodes, node)
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(instance, credentials, content, other).Build()
+		reconciler = &IpfsReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			Kubo:   dialer,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("collects one peer at a time once the unpins quieted down", func() {
+		requeue, err := reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(instance.Status.GC.Pass).To(BeNil())
+
+		By("waiting for the quiet period after the last unpin")
+		unpin(time.Now().Add(-20 * time.Second))
+		requeue, err = reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeNumerically("~", 40*time.Second, time.Second))
+		Expect(instance.Status.GC.Pass).To(BeNil())
+
+		By("collecting the first peer, then the second one")
+		unpin(time.Now().Add(-2 * time.Minute))
+		requeue, err = reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(gcPollInterval))
+		Expect(nodes[0].Calls["repo/gc"]).To(Equal(1))
+		Expect(nodes[1].Calls["repo/gc"]).To(BeZero())
+		Expect(collecting().Reason).To(Equal(clusterv1alpha1.GCReasonInProgress))
+
+		requeue, err = reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		last := instance.Status.GC.LastPass
+		Expect(instance.Status.GC.Pass).To(BeNil())
+		Expect(last.CompletedAt).NotTo(BeNil())
+		Expect(last.ReclaimedBytes).To(Equal(int64(8000)))
+		Expect(last.Peers).To(Equal([]clusterv1alpha1.PeerGC{
+			{Name: peerPodName(instance, 0), ReclaimedBytes: 4000, RemovedBlocks: 2},
+			{Name: peerPodName(instance, 1), ReclaimedBytes: 4000, RemovedBlocks: 2},
+		}))
+		Expect(collecting().Reason).To(Equal(clusterv1alpha1.GCReasonComplete))
+
+		By("running no other pass until content is unpinned again")
+		_, err = reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Status.GC.Pass).To(BeNil())
+		Expect(nodes[0].Calls["repo/gc"]).To(Equal(1))
+	})
+
+	It("waits for the rollouts and repairs and skips the peers fetching pins", func() {
+		unpin(time.Now().Add(-2 * time.Minute))
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:   clusterv1alpha1.ConditionUpgrading,
+			Status: metav1.ConditionTrue,
+			Reason: clusterv1alpha1.UpgradingReasonRollingOut,
+		})
+		requeue, err := reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(gcPollInterval))
+		Expect(collecting().Reason).To(Equal(clusterv1alpha1.GCReasonDeferred))
+		Expect(collecting().Message).To(ContainSubstring("rolling out"))
+		Expect(nodes[0].Calls["repo/gc"]).To(BeZero())
+
+		By("waiting for the pinset to be repaired after a partition")
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionUpgrading)
+		instance.Status.PostPartitionAudit = &clusterv1alpha1.PostPartitionAudit{HealedAt: metav1.Now()}
+		_, err = reconciler.reconcileGC(ctx, instance, instance)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(collecting().Message).To(ContainSubstring("repaired"))
+		Expect(nodes[0].Calls["repo/gc"]).To(BeZero())
+
+		By("skipping the peer which is fetching a pin")
+		completed := metav1.Now()
+		instance.Status.PostPartitionAudit.CompletedAt = &completed
+		_, err = clusterapi.New(cluster.URL()).Pin(ctx, "bafkreinew", clusterapi.PinOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		cluster.Diverge("bafkreinew", "12D3KooWClusterB", clusterapi.TrackerStatusPinning, -1)
+		for i := 0; i < 2; i++ {
+			_, err = reconciler.reconcileGC(ctx, instance, instance)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		last := instance.Status.GC.LastPass
+		Expect(last.Peers[0].Skipped).To(BeEmpty())
+		Expect(last.Peers[1].Skipped).To(Equal("1 pins in progress"))
+		Expect(last.ReclaimedBytes).To(Equal(int64(4000)))
+		Expect(nodes[1].Calls["repo/gc"]).To(BeZero())
+		Expect(collecting().Message).To(ContainSubstring("skipping 1"))
+	})
+})
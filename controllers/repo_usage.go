@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+// syncRepoUsage Records the repo usage, the provide statistics, the resource
+// limits and the traffic of every ready peer whose last check is older than
+// the status-sync interval, then sets the StoragePressure,
+// StorageMaxMismatch, ReprovideBehind and BandwidthConstrained conditions
+// from the usage of all the peers. A Warning
+// event is emitted when a peer crosses the threshold. Peers which cannot be
+// queried keep their previous usage.
+func (r *IpfsReconciler) syncRepoUsage(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	resolved *clusterv1alpha1.Ipfs,
+) error {
+	log := ctrllog.FromContext(ctx)
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return fmt.Errorf("cannot list peer pods: %w", err)
+	}
+
+	interval := r.statusSyncInterval()
+	threshold := *resolved.Spec.StoragePressureThreshold
+	now := metav1.Now()
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		ordinal := peerOrdinal(pod.Name)
+		if pod.DeletionTimestamp != nil || ordinal < 0 || ordinal >= peerCount(instance) || !podIsReady(pod) {
+			continue
+		}
+		peer := peerStatus(instance, pod.Name)
+		if peer.Repo != nil && now.Sub(peer.Repo.CheckedAt.Time) < interval {
+			continue
+		}
+		var stat *kuboapi.RepoStat
+		api, err := r.kuboAPI(instance, ordinal)
+		if err == nil {
+			stat, err = api.RepoStat(ctx)
+		}
+		if err != nil {
+			log.Info("cannot get repo usage", "peer", pod.Name, "error", err.Error())
+			continue
+		}
+		crossed := peer.Repo == nil || peer.Repo.Utilization < threshold
+		peer.Repo = &clusterv1alpha1.RepoUsage{
+			Size:        *resource.NewQuantity(int64(stat.RepoSize), resource.BinarySI),
+			StorageMax:  *resource.NewQuantity(int64(stat.StorageMax), resource.BinarySI),
+			NumObjects:  int64(stat.NumObjects),
+			Utilization: utilization(stat.RepoSize, stat.StorageMax),
+			CheckedAt:   now,
+		}
+		peer.Provide = r.provideStatus(ctx, api, peer, resolved)
+		peer.ResourceLimits = peerResourceLimits(ctx, resolved, api, peer)
+		peer.Bandwidth = peerBandwidth(ctx, api, peer)
+		if crossed && peer.Repo.Utilization >= threshold {
+			r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.StoragePressureReasonThresholdExceeded,
+				"repo of peer %s uses %d%% of its StorageMax of %s", pod.Name, peer.Repo.Utilization,
+				peer.Repo.StorageMax.String())
+		}
+	}
+
+	storageMax, err := ipfsStorageMax(resolved)
+	if err != nil {
+		return err
+	}
+	var pressured, mismatched []string
+	for _, p := range instance.Status.Peers {
+		if p.Bandwidth != nil {
+			peerBandwidthRate.WithLabelValues(instance.Namespace, instance.Name, p.Name, "in").
+				Set(float64(p.Bandwidth.RateIn))
+			peerBandwidthRate.WithLabelValues(instance.Namespace, instance.Name, p.Name, "out").
+				Set(float64(p.Bandwidth.RateOut))
+		}
+		if p.Repo == nil {
+			continue
+		}
+		peerRepoUtilization.WithLabelValues(instance.Namespace, instance.Name, p.Name).
+			Set(float64(p.Repo.Utilization) / 100)
+		if p.Repo.Utilization >= threshold {
+			pressured = append(pressured, fmt.Sprintf("%s (%d%%)", p.Name, p.Repo.Utilization))
+		}
+		if p.Repo.StorageMax.Value() != storageMax {
+			mismatched = append(mismatched, fmt.Sprintf("%s (%s)", p.Name, p.Repo.StorageMax.String()))
+		}
+	}
+	setStoragePressureCondition(instance, threshold, pressured)
+	setStorageMaxCondition(instance, resolved.Spec.IpfsStorage, mismatched)
+	setReprovideCondition(instance, resolved)
+	setBandwidthCondition(instance, resolved)
+	return nil
+}
+
+// ipfsStorageMax Returns the StorageMax of the repos of the peers, in bytes,
+// which is the size of their volume.
+func ipfsStorageMax(m *clusterv1alpha1.Ipfs) (int64, error) {
+	size, err := resource.ParseQuantity(m.Spec.IpfsStorage)
+	if err != nil {
+		return 0, invalidSpec(fmt.Errorf("cannot parse ipfsStorage %q: %w", m.Spec.IpfsStorage, err))
+	}
+	return size.Value(), nil
+}
+
+// utilization Returns the share of storageMax used, in percent.
+func utilization(size, storageMax uint64) int32 {
+	if storageMax == 0 {
+		return 0
+	}
+	return int32(size * 100 / storageMax)
+}
+
+// setStoragePressureCondition Sets the StoragePressure condition from the
+// peers above the threshold.
+func setStoragePressureCondition(
+	instance *clusterv1alpha1.Ipfs,
+	threshold int32,
+	pressured []string,
+) {
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionStoragePressure,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.StoragePressureReasonWithinThreshold,
+		Message:            fmt.Sprintf("every peer repo is below %d%% of its StorageMax", threshold),
+		ObservedGeneration: instance.Generation,
+	}
+	if len(pressured) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.StoragePressureReasonThresholdExceeded
+		cond.Message = fmt.Sprintf("peer repos above %d%% of their StorageMax: %s",
+			threshold, strings.Join(pressured, ", "))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
+
+// setStorageMaxCondition Sets the StorageMaxMismatch condition from the peers
+// whose repo reports a StorageMax other than the size of their volume. The
+// freespace informer derives the free space of the peers from their
+// StorageMax, so that pins are allocated to peers which cannot hold them.
+func setStorageMaxCondition(instance *clusterv1alpha1.Ipfs, volumeSize string, mismatched []string) {
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionStorageMaxMismatch,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.StorageMaxMismatchReasonMatchesVolume,
+		Message:            fmt.Sprintf("every peer repo has a StorageMax of %s", volumeSize),
+		ObservedGeneration: instance.Generation,
+	}
+	if len(mismatched) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.StorageMaxMismatchReasonVolumeSize
+		cond.Message = fmt.Sprintf("peer repos with a StorageMax other than their volume size of %s: %s",
+			volumeSize, strings.Join(mismatched, ", "))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
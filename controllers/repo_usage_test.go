@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs repo usage", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		node       *kubofake.Node
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	peerPod := func(name string, ready corev1.ConditionStatus) *corev1.Pod {
+		pod := &corev1.Pod{}
+		pod.Name = name
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{"app.kubernetes.io/name": "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}
+		return pod
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "usage"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			instance,
+			peerPod("ipfs-cluster-usage-0", corev1.ConditionTrue),
+			peerPod("ipfs-cluster-usage-1", corev1.ConditionFalse),
+		).Build()
+
+		node = kubofake.NewNode("12D3KooWA")
+		node.Repo = kuboapi.RepoStat{RepoSize: 90, StorageMax: 100, NumObjects: 7}
+		dialer := kubofake.NewDialer()
+		dialer.Add(key.Namespace, "ipfs-cluster-usage-0", node)
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer, Recorder: recorder}
+	})
+
+	It("reports ready peers above the threshold once per sync interval", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(instance.Status.Peers).NotTo(BeEmpty())
+		peer := instance.Status.Peers[0]
+		Expect(peer.Name).To(Equal("ipfs-cluster-usage-0"))
+		Expect(peer.Repo).NotTo(BeNil())
+		Expect(peer.Repo.Utilization).To(Equal(int32(90)))
+		Expect(peer.Repo.NumObjects).To(Equal(int64(7)))
+		for _, p := range instance.Status.Peers[1:] {
+			Expect(p.Repo).To(BeNil())
+		}
+
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionStoragePressure)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.StoragePressureReasonThresholdExceeded))
+		Expect(cond.Message).To(ContainSubstring("ipfs-cluster-usage-0 (90%)"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("ipfs-cluster-usage-0")))
+
+		By("reconciling again within the interval")
+		reconcile()
+		Expect(node.Calls["repo/stat"]).To(Equal(1))
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("honors a custom threshold", func() {
+		instance := reconcile()
+		threshold := int32(95)
+		instance.Spec.StoragePressureThreshold = &threshold
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(meta.IsStatusConditionFalse(instance.Status.Conditions,
+			clusterv1alpha1.ConditionStoragePressure)).To(BeTrue())
+	})
+
+	It("reports peers whose StorageMax is not the size of their volume", func() {
+		reconcile()
+		instance := reconcile()
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionStorageMaxMismatch)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.StorageMaxMismatchReasonVolumeSize))
+		Expect(cond.Message).To(ContainSubstring("ipfs-cluster-usage-0 (100)"))
+
+		By("checking the repo again once its StorageMax matches")
+		node.Repo.StorageMax = 1 << 30
+		instance.Status.Peers[0].Repo = nil
+		Expect(fakeClient.Status().Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(meta.IsStatusConditionFalse(instance.Status.Conditions,
+			clusterv1alpha1.ConditionStorageMaxMismatch)).To(BeTrue())
+	})
+})
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// defaultReprovideInterval Is the reprovider interval of the kubo
+	// release the operator deploys.
+	defaultReprovideInterval = 12 * time.Hour
+	// defaultProvideWorkers Is the default of Provider.WorkerCount.
+	defaultProvideWorkers = 16
+	// provideWorkersMinor Is the first kubo v0 minor release supporting
+	// Provider.WorkerCount.
+	provideWorkersMinor = 35
+)
+
+// reprovideInterval Returns how often the peers announce their content again.
+func reprovideInterval(m *clusterv1alpha1.Ipfs) time.Duration {
+	if m.Spec.Reprovider != nil && m.Spec.Reprovider.Interval != nil {
+		return m.Spec.Reprovider.Interval.Duration
+	}
+	return defaultReprovideInterval
+}
+
+// provideWorkersSupported Returns whether the kubo image supports
+// Provider.WorkerCount. Images whose tag is not a version, such as latest,
+// are taken to be recent releases.
+func provideWorkersSupported(image string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(imageTag(image), "v"), ".", 3)
+	if len(parts) < 2 {
+		return true
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return true
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return true
+	}
+	return major > 0 || minor >= provideWorkersMinor
+}
+
+// provideWorkers Returns how many announcements each peer sends at once.
+// Kubo releases without Provider.WorkerCount send one at a time. The peers
+// send fewer of them while provider nodes announce the roots of the pinset.
+func provideWorkers(m *clusterv1alpha1.Ipfs) int32 {
+	if !provideWorkersSupported(m.Spec.IpfsImage) {
+		return 1
+	}
+	if m.Spec.Providers != nil {
+		if m.Spec.Providers.PeerWorkers != nil {
+			return *m.Spec.Providers.PeerWorkers
+		}
+		return defaultPeerProvideWorkers
+	}
+	if m.Spec.Provide != nil && m.Spec.Provide.Workers != nil {
+		return *m.Spec.Provide.Workers
+	}
+	return defaultProvideWorkers
+}
+
+// provideCommands Returns the commands of the configure script applying the
+// reprovider and provide settings of the spec, at every start of the peers.
+// Settings the kubo image does not support are left out.
+func provideCommands(m *clusterv1alpha1.Ipfs) string {
+	var b strings.Builder
+	if m.Spec.Reprovider != nil && m.Spec.Reprovider.Interval != nil {
+		fmt.Fprintf(&b, "\tipfs config Reprovider.Interval %s\n", m.Spec.Reprovider.Interval.Duration)
+	}
+	workersSet := m.Spec.Providers != nil || (m.Spec.Provide != nil && m.Spec.Provide.Workers != nil)
+	if workersSet && provideWorkersSupported(m.Spec.IpfsImage) {
+		fmt.Fprintf(&b, "\tipfs config --json Provider.WorkerCount %d\n", provideWorkers(m))
+	}
+	return b.String()
+}
+
+// estimateReprovide Returns the time a peer takes to announce its objects,
+// given the average time of an announcement and the announcements sent at
+// once.
+func estimateReprovide(objects uint64, avg time.Duration, workers int32) time.Duration {
+	if workers < 1 {
+		workers = 1
+	}
+	estimate := float64(objects) * float64(avg) / float64(workers)
+	if estimate > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(estimate)
+}
+
+// provideStatus Returns how the peer announces its content, from the
+// provide statistics of its node and the objects of its repo. The previous
+// status is kept when the statistics cannot be read, which kubo refuses for
+// some of its routing configurations.
+func (r *IpfsReconciler) provideStatus(
+	ctx context.Context,
+	api kuboapi.API,
+	peer *clusterv1alpha1.PeerStatus,
+	resolved *clusterv1alpha1.Ipfs,
+) *clusterv1alpha1.ProvideStatus {
+	stats, err := api.ProvideStats(ctx)
+	if err != nil {
+		ctrllog.FromContext(ctx).Info("cannot get provide stats", "peer", peer.Name, "error", err.Error())
+		return peer.Provide
+	}
+	if peer.Repo == nil {
+		return peer.Provide
+	}
+	return &clusterv1alpha1.ProvideStatus{
+		AvgProvideDuration: metav1.Duration{Duration: stats.AvgProvideDuration},
+		EstimatedReprovide: metav1.Duration{Duration: estimateReprovide(uint64(peer.Repo.NumObjects),
+			stats.AvgProvideDuration, provideWorkers(resolved))},
+	}
+}
+
+// setReprovideCondition Sets the ReprovideBehind condition from the estimated
+// reprovide time of the peers. The condition is removed while reproviding is
+// disabled or no peer could be estimated.
+func setReprovideCondition(instance *clusterv1alpha1.Ipfs, resolved *clusterv1alpha1.Ipfs) {
+	interval := reprovideInterval(resolved)
+	estimated := false
+	var behind []string
+	for _, p := range instance.Status.Peers {
+		if p.Provide == nil {
+			continue
+		}
+		estimated = true
+		if estimate := p.Provide.EstimatedReprovide.Duration; estimate > interval {
+			behind = append(behind, fmt.Sprintf("%s (%s)", p.Name, estimate.Round(time.Minute)))
+		}
+	}
+	if interval == 0 || !estimated {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionReprovideBehind)
+		return
+	}
+	condition := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionReprovideBehind,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.ReprovideBehindReasonWithinInterval,
+		Message:            fmt.Sprintf("every peer reprovides its content within %s", interval),
+		ObservedGeneration: instance.Generation,
+	}
+	if len(behind) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = clusterv1alpha1.ReprovideBehindReasonEstimateExceedsInterval
+		condition.Message = fmt.Sprintf("estimated reprovide time exceeds the interval of %s: %s",
+			interval, strings.Join(behind, ", "))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+}
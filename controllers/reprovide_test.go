@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs reprovider", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		node       *kubofake.Node
+		key        types.NamespacedName
+	)
+
+	tuned := func(image string) *clusterv1alpha1.Ipfs {
+		workers := int32(64)
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Spec.IpfsImage = image
+		instance.Spec.Reprovider = &clusterv1alpha1.ReproviderConfig{Interval: &metav1.Duration{Duration: 22 * time.Hour}}
+		instance.Spec.Provide = &clusterv1alpha1.ProvideConfig{Workers: &workers}
+		return instance
+	}
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "provide"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Reprovider = &clusterv1alpha1.ReproviderConfig{Interval: &metav1.Duration{Duration: time.Hour}}
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-provide-0"
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{"app.kubernetes.io/name": "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, pod).Build()
+
+		node = kubofake.NewNode("12D3KooWA")
+		node.Repo = kuboapi.RepoStat{RepoSize: 10, StorageMax: 100, NumObjects: 10000}
+		node.Provide = kuboapi.ProvideStats{AvgProvideDuration: time.Second}
+		dialer := kubofake.NewDialer()
+		dialer.Add(key.Namespace, pod.Name, node)
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer, Recorder: record.NewFakeRecorder(10)}
+	})
+
+	It("renders the reprovider and provide settings", func() {
+		expectGolden("provide-tuned", provideCommands(tuned("ipfs/kubo:v0.36.0")))
+		expectGolden("provide-legacy", provideCommands(tuned("ipfs/go-ipfs:v0.12.2")))
+		Expect(provideCommands(&clusterv1alpha1.Ipfs{})).To(BeEmpty())
+	})
+
+	It("estimates the reprovide time from the workers of the kubo release", func() {
+		Expect(provideWorkersSupported("ipfs/kubo:latest")).To(BeTrue())
+		Expect(provideWorkersSupported("ipfs/kubo:v0.35.0")).To(BeTrue())
+		Expect(provideWorkersSupported("ipfs/go-ipfs:v0.12.2")).To(BeFalse())
+		Expect(provideWorkers(tuned("ipfs/go-ipfs:v0.12.2"))).To(Equal(int32(1)))
+		Expect(estimateReprovide(1000, time.Second, 10)).To(Equal(100 * time.Second))
+		Expect(estimateReprovide(1000, time.Second, 0)).To(Equal(1000 * time.Second))
+	})
+
+	It("warns when the peers cannot reprovide within the interval", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(instance.Status.Peers[0].Provide).NotTo(BeNil())
+		Expect(instance.Status.Peers[0].Provide.AvgProvideDuration.Duration).To(Equal(time.Second))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReprovideBehind)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.ReprovideBehindReasonEstimateExceedsInterval))
+		Expect(cond.Message).To(ContainSubstring("ipfs-cluster-provide-0"))
+
+		By("clearing the warning once the interval is long enough")
+		instance.Spec.Reprovider.Interval.Duration = 24 * time.Hour
+		setReprovideCondition(instance, instance)
+		cond = meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReprovideBehind)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+
+		By("removing the condition while reproviding is disabled")
+		instance.Spec.Reprovider.Interval.Duration = 0
+		setReprovideCondition(instance, instance)
+		Expect(meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReprovideBehind)).To(BeNil())
+	})
+})
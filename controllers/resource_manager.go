@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// resourceLimitsPath Is the file kubo reads the limits of its resource
+	// manager from.
+	resourceLimitsPath = "/data/ipfs/libp2p-resource-manager-limits.json"
+	// connMgrDefaultsPath Holds the connection manager settings of the repo
+	// from before the operator changed them, to restore them once the limits
+	// are removed.
+	connMgrDefaultsPath = "/data/ipfs/.connmgr-defaults"
+)
+
+// resourcePreset Is the limits of the resource manager of the peers of a role.
+type resourcePreset struct {
+	connections   int32
+	streams       int32
+	memoryPercent int32
+}
+
+// resourcePresets Maps the roles of the peers to their limits. Gateway peers
+// are dialed by the whole DHT, and hold few connections and streams so that
+// they are not overwhelmed. Storage peers transfer content within the
+// cluster, and need many streams for it.
+var resourcePresets = map[string]resourcePreset{
+	clusterv1alpha1.PeerRoleGateway: {connections: 512, streams: 2048, memoryPercent: 25},
+	clusterv1alpha1.PeerRoleStorage: {connections: 256, streams: 16384, memoryPercent: 50},
+}
+
+// resourceManagerEnabled Returns whether the operator writes the limits of
+// the resource manager of the peers. The limits are left out for kubo
+// releases which would ignore them.
+func resourceManagerEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.Swarm != nil && m.Spec.Swarm.ResourceManager != nil &&
+		clusterv1alpha1.ResourceManagerSupported(m.Spec.IpfsImage)
+}
+
+// resourceManagerRole Returns the role selecting the preset limits of the
+// peers, which is Gateway for the peers of a public gateway by default.
+func resourceManagerRole(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.Swarm != nil && m.Spec.Swarm.ResourceManager != nil && m.Spec.Swarm.ResourceManager.Role != "" {
+		return m.Spec.Swarm.ResourceManager.Role
+	}
+	if m.Spec.Public {
+		return clusterv1alpha1.PeerRoleGateway
+	}
+	return clusterv1alpha1.PeerRoleStorage
+}
+
+// resourceLimits Returns the limits of the resource manager of the peers:
+// the preset of their role, with the limits set in the spec instead.
+func resourceLimits(m *clusterv1alpha1.Ipfs) resourcePreset {
+	limits := resourcePresets[resourceManagerRole(m)]
+	rm := m.Spec.Swarm.ResourceManager
+	if rm.MaxConnections != nil {
+		limits.connections = *rm.MaxConnections
+	}
+	if rm.MaxStreams != nil {
+		limits.streams = *rm.MaxStreams
+	}
+	if rm.MemoryPercent != nil {
+		limits.memoryPercent = *rm.MemoryPercent
+	}
+	return limits
+}
+
+// resourceManagerCommands Returns the commands of the configure script
+// writing the limits of the resource manager of the peers, at every start of
+// the peers. The memory limit is a share of the memory limit of the cgroup
+// of the ipfs container, so that it follows the resources of each peer. The
+// connection manager trims the connections before they reach the inbound
+// limit, which kubo requires, and its settings are saved before the first
+// change, so that removing the limits restores them.
+func resourceManagerCommands(m *clusterv1alpha1.Ipfs) string {
+	var b strings.Builder
+	if !resourceManagerEnabled(m) {
+		fmt.Fprintf(&b, "\trm -f %s\n", resourceLimitsPath)
+		fmt.Fprintf(&b, "\tif [ -f %[1]s ]; then\n\t\tipfs config --json Swarm.ConnMgr \"$(cat %[1]s)\"\n"+
+			"\t\trm %[1]s\n\tfi\n", connMgrDefaultsPath)
+		return b.String()
+	}
+	limits := resourceLimits(m)
+	fmt.Fprintf(&b, "\t[ -f %[1]s ] || ipfs config Swarm.ConnMgr > %[1]s\n", connMgrDefaultsPath)
+	b.WriteString("\tmemory=$(cat /sys/fs/cgroup/memory.max 2>/dev/null || " +
+		"cat /sys/fs/cgroup/memory/memory.limit_in_bytes 2>/dev/null)\n")
+	// cgroup v1 reports an unlimited container with a limit of 19 digits.
+	fmt.Fprintf(&b, "\tcase \"$memory\" in\n\t\t''|max|*[!0-9]*|???????????????????*) memory='' ;;\n"+
+		"\t\t*) memory=\",\\\"Memory\\\":$((memory / 100 * %d))\" ;;\n\tesac\n", limits.memoryPercent)
+	fmt.Fprintf(&b, "\tprintf '{\"System\":{\"Conns\":%d,\"ConnsInbound\":%d,"+
+		"\"Streams\":%d,\"StreamsInbound\":%d%%s}}\\n' \"$memory\" > %s\n",
+		limits.connections, limits.connections/2, limits.streams, limits.streams/2, resourceLimitsPath)
+	fmt.Fprintf(&b, "\tipfs config --json Swarm.ConnMgr.LowWater %d\n", limits.connections/4)
+	fmt.Fprintf(&b, "\tipfs config --json Swarm.ConnMgr.HighWater %d\n", limits.connections*3/8)
+	return b.String()
+}
+
+// resourceManagerSettings Returns the limits of the resource manager of the
+// peers, for the config hash rolling the peers when they change.
+func resourceManagerSettings(m *clusterv1alpha1.Ipfs) []string {
+	if !resourceManagerEnabled(m) {
+		return nil
+	}
+	limits := resourceLimits(m)
+	return []string{fmt.Sprintf("swarm/resourcemanager=%d/%d/%d",
+		limits.connections, limits.streams, limits.memoryPercent)}
+}
+
+// peerResourceLimits Returns the limits the resource manager of the kubo
+// node of a peer enforces, as it reports them. The previous limits are kept
+// when they cannot be read, and dropped once the operator no longer writes
+// them.
+func peerResourceLimits(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+	api kuboapi.API,
+	peer *clusterv1alpha1.PeerStatus,
+) *clusterv1alpha1.ResourceLimits {
+	if !resourceManagerEnabled(m) {
+		return nil
+	}
+	limits, err := api.SwarmResources(ctx)
+	if err != nil {
+		ctrllog.FromContext(ctx).Info("cannot get swarm resources", "peer", peer.Name, "error", err.Error())
+		return peer.ResourceLimits
+	}
+	status := &clusterv1alpha1.ResourceLimits{
+		Connections:        int64(limits.Conns),
+		ConnectionsInbound: int64(limits.ConnsInbound),
+		Streams:            int64(limits.Streams),
+		StreamsInbound:     int64(limits.StreamsInbound),
+		CheckedAt:          metav1.Now(),
+	}
+	if limits.Memory != kuboapi.Unlimited {
+		status.Memory = resource.NewQuantity(int64(limits.Memory), resource.BinarySI)
+	}
+	return status
+}
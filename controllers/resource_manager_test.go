@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs resource manager", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		node       *kubofake.Node
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	script := func() string {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-scripts-" + key.Name}, cm)).To(Succeed())
+		return cm.Data["configure-ipfs.sh"]
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "limits"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.29.0"
+		instance.Spec.Swarm = &clusterv1alpha1.SwarmConfig{
+			ResourceManager: &clusterv1alpha1.ResourceManagerConfig{},
+		}
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-limits-0"
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{"app.kubernetes.io/name": "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, pod).Build()
+
+		node = kubofake.NewNode("12D3KooWA")
+		node.Repo = kuboapi.RepoStat{RepoSize: 10, StorageMax: 100}
+		node.Resources = kuboapi.ResourceLimits{
+			Memory: 268435456, Conns: 256, ConnsInbound: 128, Streams: 16384, StreamsInbound: kuboapi.Unlimited,
+		}
+		dialer := kubofake.NewDialer()
+		dialer.Add(key.Namespace, "ipfs-cluster-limits-0", node)
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer}
+	})
+
+	It("selects the preset of the role of the peers and the limits of the spec", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Spec.Swarm = &clusterv1alpha1.SwarmConfig{ResourceManager: &clusterv1alpha1.ResourceManagerConfig{}}
+		Expect(resourceManagerRole(instance)).To(Equal(clusterv1alpha1.PeerRoleStorage))
+		Expect(resourceLimits(instance)).To(Equal(resourcePreset{connections: 256, streams: 16384, memoryPercent: 50}))
+
+		instance.Spec.Public = true
+		Expect(resourceManagerRole(instance)).To(Equal(clusterv1alpha1.PeerRoleGateway))
+		Expect(resourceLimits(instance)).To(Equal(resourcePreset{connections: 512, streams: 2048, memoryPercent: 25}))
+
+		By("taking the limits of the spec instead of the preset")
+		streams := int32(4096)
+		instance.Spec.Swarm.ResourceManager.Role = clusterv1alpha1.PeerRoleStorage
+		instance.Spec.Swarm.ResourceManager.MaxStreams = &streams
+		Expect(resourceLimits(instance)).To(Equal(resourcePreset{connections: 256, streams: 4096, memoryPercent: 50}))
+
+		By("giving every role a preset")
+		for _, role := range []string{clusterv1alpha1.PeerRoleGateway, clusterv1alpha1.PeerRoleStorage} {
+			preset, ok := resourcePresets[role]
+			Expect(ok).To(BeTrue())
+			// kubo refuses to start with a connection manager above the
+			// inbound connection limit.
+			Expect(preset.connections * 3 / 8).To(BeNumerically("<", preset.connections/2))
+		}
+	})
+
+	It("writes the limits and reports those the peers enforce", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(script()).To(ContainSubstring(`printf '{"System":{"Conns":256,"ConnsInbound":128,` +
+			`"Streams":16384,"StreamsInbound":8192%s}}\n' "$memory" > ` +
+			`/data/ipfs/libp2p-resource-manager-limits.json`))
+		Expect(script()).To(ContainSubstring(`memory=",\"Memory\":$((memory / 100 * 50))"`))
+		Expect(script()).To(ContainSubstring("ipfs config --json Swarm.ConnMgr.HighWater 96"))
+
+		Expect(instance.Status.Peers).To(HaveLen(1))
+		limits := instance.Status.Peers[0].ResourceLimits
+		Expect(limits).NotTo(BeNil())
+		Expect(limits.Connections).To(Equal(int64(256)))
+		Expect(limits.StreamsInbound).To(Equal(int64(-1)))
+		Expect(limits.Memory.Cmp(resource.MustParse("256Mi"))).To(Equal(0))
+
+		By("rolling the peers when the limits change")
+		hash, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		connections := int32(1024)
+		instance.Spec.Swarm.ResourceManager.MaxConnections = &connections
+		Expect(reconciler.referencedConfigHash(ctx, instance)).NotTo(Equal(hash))
+
+		By("restoring the connection manager once the limits are removed")
+		instance.Spec.Swarm = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance.Status.Peers[0].Repo = nil
+		Expect(fakeClient.Status().Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(script()).To(ContainSubstring("rm -f /data/ipfs/libp2p-resource-manager-limits.json"))
+		Expect(script()).To(ContainSubstring(`ipfs config --json Swarm.ConnMgr "$(cat /data/ipfs/.connmgr-defaults)"`))
+		Expect(instance.Status.Peers[0].ResourceLimits).To(BeNil())
+	})
+
+	It("leaves the limits out for kubo releases ignoring them", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.18.1"
+		instance.Spec.Swarm = &clusterv1alpha1.SwarmConfig{ResourceManager: &clusterv1alpha1.ResourceManagerConfig{}}
+		Expect(resourceManagerSettings(instance)).To(BeEmpty())
+		Expect(resourceManagerCommands(instance)).NotTo(ContainSubstring("printf"))
+	})
+})
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// minReadySeconds Returns how long a restarted peer must be ready before it
+// counts as available.
+func minReadySeconds(m *clusterv1alpha1.Ipfs) int32 {
+	if m.Spec.Rollout == nil {
+		return 0
+	}
+	return m.Spec.Rollout.MinReadySeconds
+}
+
+// rolloutPause Returns how long the operator waits between two restarted
+// peers, once the first one is available.
+func rolloutPause(m *clusterv1alpha1.Ipfs) time.Duration {
+	if m.Spec.Rollout == nil || m.Spec.Rollout.PauseBetweenPods == nil {
+		return 0
+	}
+	return m.Spec.Rollout.PauseBetweenPods.Duration
+}
+
+// rolloutPaced Returns whether the operator drives the partition of the
+// StatefulSet to pause between the restarted peers or run the rollout hooks.
+// OnDelete updates are left to whoever deletes the pods.
+func rolloutPaced(m *clusterv1alpha1.Ipfs) bool {
+	if rolloutPause(m) <= 0 && rolloutHooksOf(m) == nil {
+		return false
+	}
+	strategy := m.Spec.UpdateStrategy
+	return strategy == nil || strategy.Type == "" ||
+		strategy.Type == string(appsv1.RollingUpdateStatefulSetStrategyType)
+}
+
+// rolloutPacing Describes the configured pacing of the rollouts, for the
+// Upgrading condition.
+func rolloutPacing(m *clusterv1alpha1.Ipfs) string {
+	return fmt.Sprintf("minReadySeconds %d, pause between peers %s", minReadySeconds(m), rolloutPause(m))
+}
+
+// rolloutPartition Returns the partition the StatefulSet of the cluster is
+// applied with, and how long to wait before advancing it. Between rollouts
+// the partition is held at the last ordinal, so that a new revision only
+// restarts the last peer, or above it while the preUpgrade hook has to run
+// first. The partition is lowered by one once the peer at the partition runs
+// the new revision, has been ready for minReadySeconds and the pause, and
+// its postPodUpdate hook succeeded, down to the partition requested in
+// spec.updateStrategy. A failed hook holds the partition until the next
+// revision, and is recorded in the status of the instance. The partition of
+// the spec is returned as is when the rollout is not paced.
+func (r *IpfsReconciler) rolloutPartition(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	m *clusterv1alpha1.Ipfs,
+) (*int32, time.Duration, error) {
+	var requested *int32
+	if m.Spec.UpdateStrategy != nil {
+		requested = m.Spec.UpdateStrategy.Partition
+	}
+	if !rolloutPaced(m) {
+		return requested, 0, nil
+	}
+	var floor int32
+	if requested != nil {
+		floor = *requested
+	}
+	sts := appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}
+	if err := r.Get(ctx, key, &sts); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, 0, fmt.Errorf("cannot get statefulset: %w", err)
+		}
+		return &floor, 0, nil
+	}
+	hold := rolloutHold(m, floor)
+	if sts.Status.UpdateRevision == sts.Status.CurrentRevision {
+		return &hold, 0, nil
+	}
+
+	current := floor
+	if rolling := sts.Spec.UpdateStrategy.RollingUpdate; rolling != nil && rolling.Partition != nil {
+		current = *rolling.Partition
+	}
+	if current > hold {
+		current = hold
+	}
+	revision := sts.Status.UpdateRevision
+	postHook := rolloutHookOf(m, hookPostPodUpdate) != nil
+	if current < floor || current == floor && (current == peerCount(m) || !postHook) {
+		return &floor, 0, nil
+	}
+	if failedRolloutHook(instance, revision) != nil {
+		return &current, 0, nil
+	}
+	if current == peerCount(m) {
+		// No peer restarted yet.
+		passed, requeue, err := r.runRolloutHook(ctx, instance, m, hookPreUpgrade, revision, -1)
+		if err != nil || !passed {
+			return &current, requeue, err
+		}
+		next := current - 1
+		return &next, 0, nil
+	}
+	return r.advancePartition(ctx, instance, m, &sts, current, floor)
+}
+
+// rolloutHold Returns the partition held between rollouts: the last
+// ordinal, or above it while the preUpgrade hook has to run first, and never
+// below the requested floor.
+func rolloutHold(m *clusterv1alpha1.Ipfs, floor int32) int32 {
+	hold := peerCount(m) - 1
+	if rolloutHookOf(m, hookPreUpgrade) != nil {
+		hold = peerCount(m)
+	}
+	if hold < floor {
+		return floor
+	}
+	return hold
+}
+
+// advancePartition Returns the partition once the peer at the current one
+// ran the new revision, has been ready for minReadySeconds and the pause, and
+// its postPodUpdate hook succeeded, and how long to wait otherwise.
+func (r *IpfsReconciler) advancePartition(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	m *clusterv1alpha1.Ipfs,
+	sts *appsv1.StatefulSet,
+	current int32,
+	floor int32,
+) (*int32, time.Duration, error) {
+	pod := corev1.Pod{}
+	podKey := client.ObjectKey{Namespace: m.Namespace, Name: sts.Name + "-" + strconv.Itoa(int(current))}
+	if err := r.Get(ctx, podKey, &pod); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, 0, fmt.Errorf("cannot get pod %s: %w", podKey.Name, err)
+		}
+		return &current, 0, nil
+	}
+	if pod.Labels[appsv1.ControllerRevisionHashLabelKey] != sts.Status.UpdateRevision || !podIsReady(&pod) {
+		return &current, 0, nil
+	}
+	wait := time.Duration(minReadySeconds(m))*time.Second + rolloutPause(m)
+	readySince := podReadySince(&pod)
+	if remaining := time.Until(readySince.Add(wait)); remaining > 0 {
+		return &current, remaining, nil
+	}
+	passed, requeue, err := r.runRolloutHook(ctx, instance, m, hookPostPodUpdate, sts.Status.UpdateRevision, current)
+	if err != nil || !passed || current == floor {
+		return &current, requeue, err
+	}
+	next := current - 1
+	return &next, 0, nil
+}
+
+// podReadySince Returns the time the Ready condition of the pod last changed.
+func podReadySince(pod *corev1.Pod) time.Time {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// withPartition Returns a copy of the cluster whose update strategy uses the
+// given partition.
+func withPartition(m *clusterv1alpha1.Ipfs, partition *int32) *clusterv1alpha1.Ipfs {
+	paced := m.DeepCopy()
+	if paced.Spec.UpdateStrategy == nil {
+		paced.Spec.UpdateStrategy = &clusterv1alpha1.UpdateStrategy{}
+	}
+	paced.Spec.UpdateStrategy.Partition = partition
+	return paced
+}
+
+// syncUpgrading Sets the Upgrading condition from the revisions of the
+// StatefulSet, with the pacing of the rollout in its message.
+func syncUpgrading(instance *clusterv1alpha1.Ipfs, sts *appsv1.StatefulSet) {
+	if sts == nil {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionUpgrading)
+		return
+	}
+	replicas := peerCount(instance)
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionUpgrading,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.UpgradingReasonUpToDate,
+		Message:            rolloutPacing(instance),
+		ObservedGeneration: instance.Generation,
+	}
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.UpgradingReasonRollingOut
+		cond.Message = fmt.Sprintf("%d/%d peers updated, %s",
+			sts.Status.UpdatedReplicas, replicas, rolloutPacing(instance))
+		if failedRolloutHook(instance, sts.Status.UpdateRevision) != nil {
+			cond.Reason = clusterv1alpha1.UpgradingReasonHookFailed
+		}
+	}
+	if hooks := rolloutHooksSummary(instance, sts.Status.UpdateRevision); hooks != "" {
+		cond.Message += ", " + hooks
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
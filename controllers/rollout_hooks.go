@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	hookPreUpgrade    = "preUpgrade"
+	hookPostPodUpdate = "postPodUpdate"
+
+	// defaultHookTimeout Is how long a hook Job may run when the spec does
+	// not tell.
+	defaultHookTimeout = 10 * time.Minute
+	// defaultHookTTL Is how long the finished hook Jobs are kept when the
+	// spec does not tell.
+	defaultHookTTL = time.Hour
+	// hookPollInterval Is how often a hook Job in progress is checked.
+	hookPollInterval = 10 * time.Second
+)
+
+// rolloutHooksOf Returns the hooks run around the rollouts, or nil.
+func rolloutHooksOf(m *clusterv1alpha1.Ipfs) *clusterv1alpha1.RolloutHooks {
+	if m.Spec.Rollout == nil {
+		return nil
+	}
+	return m.Spec.Rollout.Hooks
+}
+
+// rolloutHookOf Returns the spec of the named hook, or nil when it is not
+// configured.
+func rolloutHookOf(m *clusterv1alpha1.Ipfs, hook string) *clusterv1alpha1.RolloutHook {
+	hooks := rolloutHooksOf(m)
+	switch {
+	case hooks == nil:
+		return nil
+	case hook == hookPreUpgrade:
+		return hooks.PreUpgrade
+	default:
+		return hooks.PostPodUpdate
+	}
+}
+
+// hookJobName Returns the name of the Job running a hook for a revision of
+// the StatefulSet, after the peer with the given ordinal restarted, or
+// before the rollout starts when the ordinal is negative.
+func hookJobName(m *clusterv1alpha1.Ipfs, revision string, ordinal int32) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(revision))
+	if ordinal < 0 {
+		return fmt.Sprintf("ipfs-cluster-%s-hook-pre-%08x", m.Name, h.Sum32())
+	}
+	return fmt.Sprintf("ipfs-cluster-%s-hook-%d-%08x", m.Name, ordinal, h.Sum32())
+}
+
+// rolloutHookResult Returns the recorded result of the hook Job, if it
+// completed.
+func rolloutHookResult(instance *clusterv1alpha1.Ipfs, revision, job string) *clusterv1alpha1.RolloutHookResult {
+	status := instance.Status.RolloutHooks
+	if status == nil || status.Revision != revision {
+		return nil
+	}
+	for i := range status.Results {
+		if status.Results[i].Job == job {
+			return &status.Results[i]
+		}
+	}
+	return nil
+}
+
+// failedRolloutHook Returns the hook which failed for the given revision,
+// if any.
+func failedRolloutHook(instance *clusterv1alpha1.Ipfs, revision string) *clusterv1alpha1.RolloutHookResult {
+	status := instance.Status.RolloutHooks
+	if status == nil || status.Revision != revision {
+		return nil
+	}
+	for i := range status.Results {
+		if !status.Results[i].Succeeded {
+			return &status.Results[i]
+		}
+	}
+	return nil
+}
+
+// runRolloutHook Runs the named hook for a revision of the StatefulSet, after
+// the peer with the given ordinal restarted, or before the rollout when the
+// ordinal is negative. It returns whether the hook succeeded, and how long to
+// wait before checking on it again while it runs. Once the Job completes,
+// its result is recorded in the status and reported by an Event, and the
+// Job is left to its TTL. A failed hook is not run again for the revision.
+func (r *IpfsReconciler) runRolloutHook(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	m *clusterv1alpha1.Ipfs,
+	hook string,
+	revision string,
+	ordinal int32,
+) (bool, time.Duration, error) {
+	spec := rolloutHookOf(m, hook)
+	if spec == nil {
+		return true, 0, nil
+	}
+	name := hookJobName(m, revision, ordinal)
+	if result := rolloutHookResult(instance, revision, name); result != nil {
+		return result.Succeeded, 0, nil
+	}
+	job := batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: name}, &job)
+	switch {
+	case errors.IsNotFound(err):
+		created, err := r.rolloutHookJob(m, spec, hook, revision, ordinal)
+		if err != nil {
+			return false, 0, err
+		}
+		if err = r.Create(ctx, created); err != nil && !errors.IsAlreadyExists(err) {
+			return false, 0, fmt.Errorf("cannot create %s hook job: %w", hook, err)
+		}
+		return false, hookPollInterval, nil
+	case err != nil:
+		return false, 0, fmt.Errorf("cannot get %s hook job: %w", hook, err)
+	}
+
+	succeeded, message, done := hookJobOutcome(ctx, r.Client, &job)
+	if !done {
+		return false, hookPollInterval, nil
+	}
+	result := clusterv1alpha1.RolloutHookResult{
+		Hook:        hook,
+		Job:         name,
+		Succeeded:   succeeded,
+		Message:     message,
+		CompletedAt: metav1.Now(),
+	}
+	if ordinal >= 0 {
+		result.Peer = peerPodName(m, ordinal)
+	}
+	status := instance.Status.RolloutHooks
+	if status == nil || status.Revision != revision {
+		status = &clusterv1alpha1.RolloutHooksStatus{Revision: revision}
+		instance.Status.RolloutHooks = status
+	}
+	status.Results = append(status.Results, result)
+	if succeeded {
+		r.eventf(instance, corev1.EventTypeNormal, "RolloutHookSucceeded", "%s", describeHookResult(&result))
+	} else {
+		r.eventf(instance, corev1.EventTypeWarning, "RolloutHookFailed", "%s, halting the rollout",
+			describeHookResult(&result))
+	}
+	return succeeded, 0, nil
+}
+
+// hookJobOutcome Returns whether the Job completed, whether it succeeded,
+// and its termination message or why it failed.
+func hookJobOutcome(ctx context.Context, c client.Client, job *batchv1.Job) (bool, string, bool) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return true, jobTerminationMessage(ctx, c, job), true
+		case batchv1.JobFailed:
+			if message := jobTerminationMessage(ctx, c, job); message != "" {
+				return false, message, true
+			}
+			return false, cond.Message, true
+		}
+	}
+	switch {
+	case job.Status.Succeeded > 0:
+		return true, jobTerminationMessage(ctx, c, job), true
+	case job.Status.Failed > 0:
+		return false, jobTerminationMessage(ctx, c, job), true
+	}
+	return false, "", false
+}
+
+// describeHookResult Describes the result of a hook, for the Events and the
+// Upgrading condition.
+func describeHookResult(result *clusterv1alpha1.RolloutHookResult) string {
+	var b strings.Builder
+	b.WriteString(result.Hook)
+	b.WriteString(" hook")
+	if result.Peer != "" {
+		fmt.Fprintf(&b, " of %s", result.Peer)
+	}
+	if result.Succeeded {
+		b.WriteString(" succeeded")
+	} else {
+		b.WriteString(" failed")
+	}
+	if message := strings.TrimSpace(result.Message); message != "" {
+		fmt.Fprintf(&b, ": %s", message)
+	}
+	return b.String()
+}
+
+// rolloutHooksSummary Describes the hooks run for the given revision, for
+// the Upgrading condition: the failed hook if any, or the last one.
+func rolloutHooksSummary(instance *clusterv1alpha1.Ipfs, revision string) string {
+	if failed := failedRolloutHook(instance, revision); failed != nil {
+		return describeHookResult(failed)
+	}
+	status := instance.Status.RolloutHooks
+	if status == nil || status.Revision != revision || len(status.Results) == 0 {
+		return ""
+	}
+	return describeHookResult(&status.Results[len(status.Results)-1])
+}
+
+// rolloutHookJob Returns the Job running a hook. It runs once, within the
+// timeout of the hooks, and is deleted once the TTL of the hooks passed.
+func (r *IpfsReconciler) rolloutHookJob(
+	m *clusterv1alpha1.Ipfs,
+	spec *clusterv1alpha1.RolloutHook,
+	hook string,
+	revision string,
+	ordinal int32,
+) (*batchv1.Job, error) {
+	timeout, ttl := defaultHookTimeout, defaultHookTTL
+	if hooks := rolloutHooksOf(m); hooks != nil {
+		if hooks.Timeout != nil {
+			timeout = hooks.Timeout.Duration
+		}
+		if hooks.TTLAfterFinished != nil {
+			ttl = hooks.TTLAfterFinished.Duration
+		}
+	}
+	backoffLimit := int32(0)
+	deadline := int64(timeout.Seconds())
+	ttlSeconds := int32(ttl.Seconds())
+	env := []corev1.EnvVar{
+		{Name: "ROLLOUT_HOOK", Value: hook},
+		{Name: "ROLLOUT_REVISION", Value: revision},
+	}
+	if ordinal >= 0 {
+		env = append(env, corev1.EnvVar{Name: "ROLLOUT_PEER", Value: peerPodName(m, ordinal)})
+	}
+	env = append(env, spec.Env...)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hookJobName(m, revision, ordinal),
+			Namespace: m.Namespace,
+			Labels:    ipfsLabels(m, componentRolloutHook),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttlSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: ipfsLabels(m, componentRolloutHook),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:                     "hook",
+							Image:                    spec.Image,
+							ImagePullPolicy:          corev1.PullIfNotPresent,
+							Command:                  spec.Command,
+							Env:                      env,
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(m, job, r.Scheme); err != nil {
+		return nil, fmt.Errorf("cannot set controller reference for %s hook job: %w", hook, err)
+	}
+	return job, nil
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs rollout hooks", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		recorder   *record.FakeRecorder
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		stsKey     types.NamespacedName
+	)
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	current := func() *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	partition := func() int32 {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		Expect(sts.Spec.UpdateStrategy.RollingUpdate).NotTo(BeNil())
+		return *sts.Spec.UpdateStrategy.RollingUpdate.Partition
+	}
+
+	setRevisions := func(currentRevision, update string, updated int32) {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		sts.Status.ObservedGeneration = sts.Generation
+		sts.Status.Replicas = 3
+		sts.Status.ReadyReplicas = 3
+		sts.Status.UpdatedReplicas = updated
+		sts.Status.CurrentRevision = currentRevision
+		sts.Status.UpdateRevision = update
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+	}
+
+	createPeer := func(ordinal int32, revision string) {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(current(), ordinal)
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{appsv1.ControllerRevisionHashLabelKey: revision}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+		pod.Status.Conditions = []corev1.PodCondition{{
+			Type:               corev1.PodReady,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+		}}
+		Expect(fakeClient.Status().Update(ctx, pod)).To(Succeed())
+	}
+
+	// finishJob Completes the hook Job, as its pod would.
+	finishJob := func(name string, conditionType batchv1.JobConditionType, message string) {
+		job := &batchv1.Job{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: name}, job)).To(Succeed())
+		job.Status.Conditions = []batchv1.JobCondition{{
+			Type: conditionType, Status: corev1.ConditionTrue, Message: message,
+		}}
+		if conditionType == batchv1.JobFailed {
+			job.Status.Failed = 1
+		} else {
+			job.Status.Succeeded = 1
+		}
+		Expect(fakeClient.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	jobs := func() []batchv1.Job {
+		list := batchv1.JobList{}
+		Expect(fakeClient.List(ctx, &list, client.InNamespace(key.Namespace),
+			client.MatchingLabels{labelComponent: componentRolloutHook})).To(Succeed())
+		return list.Items
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "hooks"}
+		stsKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Rollout = &clusterv1alpha1.RolloutConfig{Hooks: &clusterv1alpha1.RolloutHooks{
+			PostPodUpdate: &clusterv1alpha1.RolloutHook{
+				Image:   "curlimages/curl",
+				Command: []string{"sh", "-c", "curl -fs http://gateway/ipfs/bafycanary"},
+			},
+			TTLAfterFinished: &metav1.Duration{Duration: 10 * time.Minute},
+		}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		recorder = record.NewFakeRecorder(1000)
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Recorder: recorder}
+
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+		setRevisions("rev-1", "rev-1", 3)
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+	})
+
+	It("advances the partition once the hook of the restarted peer succeeded", func() {
+		setRevisions("rev-1", "rev-2", 1)
+		createPeer(2, "rev-2")
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+		created := jobs()
+		Expect(created).To(HaveLen(1))
+		job := created[0]
+		Expect(job.OwnerReferences).To(HaveLen(1))
+		Expect(*job.Spec.TTLSecondsAfterFinished).To(Equal(int32(600)))
+		Expect(*job.Spec.ActiveDeadlineSeconds).To(Equal(int64(defaultHookTimeout.Seconds())))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ContainElement(
+			corev1.EnvVar{Name: "ROLLOUT_PEER", Value: "ipfs-cluster-hooks-2"}))
+
+		finishJob(job.Name, batchv1.JobComplete, "")
+		reconcile()
+		Expect(partition()).To(Equal(int32(1)))
+		cond := meta.FindStatusCondition(current().Status.Conditions, clusterv1alpha1.ConditionUpgrading)
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UpgradingReasonRollingOut))
+		Expect(cond.Message).To(ContainSubstring("postPodUpdate hook of ipfs-cluster-hooks-2 succeeded"))
+	})
+
+	It("halts the rollout when the hook of a restarted peer fails", func() {
+		setRevisions("rev-1", "rev-2", 1)
+		createPeer(2, "rev-2")
+		reconcile()
+		finishJob(hookJobName(current(), "rev-2", 2), batchv1.JobFailed, "canary did not resolve")
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+
+		instance := current()
+		Expect(instance.Status.RolloutHooks).NotTo(BeNil())
+		Expect(instance.Status.RolloutHooks.Revision).To(Equal("rev-2"))
+		Expect(instance.Status.RolloutHooks.Results).To(HaveLen(1))
+		Expect(instance.Status.RolloutHooks.Results[0].Succeeded).To(BeFalse())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionUpgrading)
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UpgradingReasonHookFailed))
+		Expect(cond.Message).To(ContainSubstring(
+			"postPodUpdate hook of ipfs-cluster-hooks-2 failed: canary did not resolve"))
+		Eventually(recorder.Events).Should(Receive(ContainSubstring("RolloutHookFailed")))
+
+		By("keeping the partition without running the hook again")
+		createPeer(1, "rev-1")
+		reconcile()
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+		Expect(jobs()).To(HaveLen(1))
+	})
+
+	It("runs the preUpgrade hook before the first peer restarts", func() {
+		instance := current()
+		instance.Spec.Rollout.Hooks.PreUpgrade = &clusterv1alpha1.RolloutHook{Image: "busybox"}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		reconcile()
+		Expect(partition()).To(Equal(int32(3)))
+
+		setRevisions("rev-1", "rev-2", 0)
+		reconcile()
+		Expect(partition()).To(Equal(int32(3)))
+		finishJob(hookJobName(instance, "rev-2", -1), batchv1.JobComplete, "")
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+	})
+})
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs rollout pacing", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		stsKey     types.NamespacedName
+	)
+
+	reconcile := func() ctrl.Result {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	statefulSet := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		return sts
+	}
+
+	partition := func() int32 {
+		rolling := statefulSet().Spec.UpdateStrategy.RollingUpdate
+		Expect(rolling).NotTo(BeNil())
+		Expect(rolling.Partition).NotTo(BeNil())
+		return *rolling.Partition
+	}
+
+	// setRevisions Sets the revisions of the StatefulSet and how many peers run the update one.
+	setRevisions := func(current, update string, updated int32) {
+		sts := statefulSet()
+		sts.Status.ObservedGeneration = sts.Generation
+		sts.Status.Replicas = 3
+		sts.Status.ReadyReplicas = 3
+		sts.Status.UpdatedReplicas = updated
+		sts.Status.CurrentRevision = current
+		sts.Status.UpdateRevision = update
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+	}
+
+	// createPeer Creates the pod of the given ordinal, running the given
+	// revision and ready since the given time.
+	createPeer := func(ordinal string, revision string, readySince time.Time) {
+		pod := &corev1.Pod{}
+		pod.Name = stsKey.Name + "-" + ordinal
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{appsv1.ControllerRevisionHashLabelKey: revision}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+		pod.Status.Conditions = []corev1.PodCondition{{
+			Type:               corev1.PodReady,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(readySince),
+		}}
+		Expect(fakeClient.Status().Update(ctx, pod)).To(Succeed())
+	}
+
+	upgrading := func() *metav1.Condition {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionUpgrading)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "pace"}
+		stsKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Rollout = &clusterv1alpha1.RolloutConfig{
+			MinReadySeconds:  10,
+			PauseBetweenPods: &metav1.Duration{Duration: time.Minute},
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("leaves the StatefulSet alone when no pacing is configured", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		instance.Spec.Rollout = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+		setRevisions("rev-1", "rev-1", 3)
+		reconcile()
+		sts := statefulSet()
+		Expect(sts.Spec.MinReadySeconds).To(BeZero())
+		Expect(sts.Spec.UpdateStrategy.RollingUpdate).To(BeNil())
+	})
+
+	It("waits for minReadySeconds and the pause before restarting the next peer", func() {
+		reconcile()
+		reconcile()
+		Expect(statefulSet().Spec.MinReadySeconds).To(Equal(int32(10)))
+
+		By("holding the partition at the last peer between rollouts")
+		setRevisions("rev-1", "rev-1", 3)
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+		cond := upgrading()
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Message).To(Equal("minReadySeconds 10, pause between peers 1m0s"))
+
+		By("keeping the partition while the restarted peer is within its pause")
+		setRevisions("rev-1", "rev-2", 1)
+		createPeer("2", "rev-2", time.Now())
+		result := reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+		Expect(result.RequeueAfter).To(BeNumerically("~", 70*time.Second, 5*time.Second))
+		cond = upgrading()
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UpgradingReasonRollingOut))
+		Expect(cond.Message).To(Equal("1/3 peers updated, minReadySeconds 10, pause between peers 1m0s"))
+
+		By("advancing the partition once the pause is over")
+		pod := &corev1.Pod{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: stsKey.Name + "-2"},
+			pod)).To(Succeed())
+		pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-2 * time.Minute))
+		Expect(fakeClient.Status().Update(ctx, pod)).To(Succeed())
+		reconcile()
+		Expect(partition()).To(Equal(int32(1)))
+
+		By("waiting for the next peer to restart")
+		createPeer("1", "rev-1", time.Now().Add(-time.Hour))
+		reconcile()
+		Expect(partition()).To(Equal(int32(1)))
+	})
+
+	It("stops at the partition requested in the update strategy", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		floor := int32(2)
+		instance.Spec.UpdateStrategy = &clusterv1alpha1.UpdateStrategy{Partition: &floor}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+
+		reconcile()
+		reconcile()
+		setRevisions("rev-1", "rev-2", 1)
+		createPeer("2", "rev-2", time.Now().Add(-time.Hour))
+		reconcile()
+		Expect(partition()).To(Equal(int32(2)))
+	})
+})
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// rotationTimeout Is how long peers have to rejoin the cluster after the
+	// secret was rotated before the rotation is declared failed.
+	rotationTimeout = 10 * time.Minute
+	// rotationPollInterval Is how often a rotation in progress is checked.
+	rotationPollInterval = 15 * time.Second
+)
+
+// reconcileSecretRotation Drives the cluster secret rotation requested through
+// the rotate-secret annotation. Peers with mismatched secrets cannot talk to
+// each other, so all of them are restarted at once and the Rotating condition
+// documents the split until every peer rejoined. A rotation only starts within
+// the maintenance window. It returns how long to wait before checking on a
+// rotation in progress, or zero when there is nothing to do.
+func (r *IpfsReconciler) reconcileSecretRotation(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	gate *maintenanceGate,
+) (time.Duration, error) {
+	_, requested := instance.Annotations[clusterv1alpha1.AnnotationRotateSecret]
+	rotation := &instance.Status.SecretRotation
+	cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionRotating)
+	failed := cond != nil && cond.Reason == clusterv1alpha1.RotatingReasonFailed
+
+	switch {
+	case rotation.StartedAt == nil && !requested:
+		if failed {
+			meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionRotating)
+		}
+		return 0, nil
+	case rotation.StartedAt == nil && !gate.allow(operationSecretRotation):
+		return 0, nil
+	case rotation.StartedAt == nil:
+		return rotationPollInterval, r.startSecretRotation(ctx, instance)
+	case failed && requested:
+		// A failed rotation halts until the annotation is removed.
+		return 0, nil
+	case failed:
+		rotation.StartedAt = nil
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionRotating)
+		return 0, nil
+	}
+
+	if time.Since(rotation.StartedAt.Time) > rotationTimeout {
+		setRotatingCondition(instance, metav1.ConditionFalse, clusterv1alpha1.RotatingReasonFailed,
+			fmt.Sprintf("peers did not rejoin the cluster within %s; remove the %s annotation to retry",
+				rotationTimeout, clusterv1alpha1.AnnotationRotateSecret))
+		return 0, nil
+	}
+
+	done, message, err := r.secretRotationProgress(ctx, instance)
+	if err != nil {
+		return 0, err
+	}
+	if !done {
+		setRotatingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.RotatingReasonInProgress, message)
+		return rotationPollInterval, nil
+	}
+	return 0, r.finishSecretRotation(ctx, instance)
+}
+
+// startSecretRotation Writes a new cluster secret and restarts every peer so
+// that they pick it up.
+func (r *IpfsReconciler) startSecretRotation(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	log := ctrllog.FromContext(ctx)
+	clusSec, err := newClusterSecret()
+	if err != nil {
+		return fmt.Errorf("cannot generate new cluster secret: %w", err)
+	}
+	sec := corev1.Secret{}
+	secKey := client.ObjectKey{Namespace: instance.Namespace, Name: clusterSecretName(instance)}
+	if err = r.Get(ctx, secKey, &sec); err != nil {
+		return fmt.Errorf("cannot get cluster secret: %w", err)
+	}
+	if sec.Data == nil {
+		sec.Data = make(map[string][]byte)
+	}
+	sec.Data[clusterSecretKey] = []byte(clusSec)
+	if err = r.Update(ctx, &sec); err != nil {
+		return fmt.Errorf("cannot update cluster secret: %w", err)
+	}
+
+	now := metav1.Now()
+	instance.Status.SecretRotation.StartedAt = &now
+	setRotatingCondition(instance, metav1.ConditionTrue, clusterv1alpha1.RotatingReasonInProgress,
+		"cluster secret rotated, restarting peers")
+	if err = r.Status().Update(ctx, instance); err != nil {
+		return err
+	}
+	log.Info("rotated cluster secret, restarting peers")
+	_, err = r.restartPeersStartedBefore(ctx, instance, now.Time)
+	return err
+}
+
+// secretRotationProgress Restarts any peer still running with the old secret
+// and reports whether all of them rejoined the cluster.
+func (r *IpfsReconciler) secretRotationProgress(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) (bool, string, error) {
+	startedAt := instance.Status.SecretRotation.StartedAt.Time
+	pending, err := r.restartPeersStartedBefore(ctx, instance, startedAt)
+	if err != nil {
+		return false, "", err
+	}
+	if pending > 0 {
+		return false, fmt.Sprintf("%d peers still restarting with the new secret", pending), nil
+	}
+
+	api, err := r.clusterAPI(ctx, instance)
+	if err != nil {
+		return false, "", err
+	}
+	peers, err := api.Peers(ctx)
+	if err != nil {
+		return false, fmt.Sprintf("waiting for the cluster API: %v", err), nil
+	}
+	joined := 0
+	for _, p := range peers {
+		if p.Error == "" {
+			joined++
+		}
+	}
+	if joined < int(peerCount(instance)) {
+		return false, fmt.Sprintf("%d of %d peers rejoined the cluster", joined, peerCount(instance)), nil
+	}
+	return true, "", nil
+}
+
+// restartPeersStartedBefore Deletes the peer pods created before the given
+// time and returns how many peers are not running and ready since then.
+func (r *IpfsReconciler) restartPeersStartedBefore(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	t time.Time,
+) (int, error) {
+	pods := corev1.PodList{}
+	if err := r.List(ctx, &pods, client.InNamespace(instance.Namespace),
+		client.MatchingLabels(peerSelector(instance))); err != nil {
+		return 0, fmt.Errorf("cannot list peer pods: %w", err)
+	}
+	ready := 0
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.CreationTimestamp.Time.Before(t) {
+			if pod.DeletionTimestamp == nil {
+				if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+					return 0, fmt.Errorf("cannot restart peer %s: %w", pod.Name, err)
+				}
+			}
+			continue
+		}
+		if podIsReady(pod) {
+			ready++
+		}
+	}
+	return int(peerCount(instance)) - ready, nil
+}
+
+// finishSecretRotation Records the successful rotation and removes the
+// annotation which requested it.
+func (r *IpfsReconciler) finishSecretRotation(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	now := metav1.Now()
+	instance.Status.SecretRotation.StartedAt = nil
+	instance.Status.SecretRotation.LastRotationTime = &now
+	setRotatingCondition(instance, metav1.ConditionFalse, clusterv1alpha1.RotatingReasonComplete,
+		"all peers rejoined the cluster with the new secret")
+	if err := r.Status().Update(ctx, instance); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	delete(instance.Annotations, clusterv1alpha1.AnnotationRotateSecret)
+	return r.Patch(ctx, instance, patch)
+}
+
+func setRotatingCondition(instance *clusterv1alpha1.Ipfs, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionRotating,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
+
+// podIsReady Reports whether the pod has the Ready condition.
+func podIsReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
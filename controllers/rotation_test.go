@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+)
+
+var _ = Describe("Ipfs cluster secret rotation", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+		open       *maintenanceGate
+	)
+
+	get := func() *clusterv1alpha1.Ipfs {
+		current := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), current)).To(Succeed())
+		return current
+	}
+
+	clusterSecret := func() string {
+		sec := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: clusterSecretName(instance)}, sec)).To(Succeed())
+		return string(sec.Data[clusterSecretKey])
+	}
+
+	// peer Creates the pod of the peer with the given ordinal at the given time.
+	peer := func(ordinal int32, created time.Time, ready bool) {
+		pod := &corev1.Pod{}
+		pod.Name = peerPodName(instance, ordinal)
+		pod.Namespace = instance.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + instance.Name}
+		pod.CreationTimestamp = metav1.NewTime(created)
+		if ready {
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	podExists := func(ordinal int32) bool {
+		err := fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: peerPodName(instance, ordinal)}, &corev1.Pod{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	rotating := func() *metav1.Condition {
+		return meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionRotating)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "rotation"
+		instance.Namespace = "default"
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationRotateSecret: "true"}
+		instance.Spec.Replicas = 2
+		sec := &corev1.Secret{}
+		sec.Name = clusterSecretName(instance)
+		sec.Namespace = instance.Namespace
+		sec.Data = map[string][]byte{clusterSecretKey: []byte("old")}
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, sec, credentials).Build()
+		cluster = clusterfake.NewCluster()
+		reconciler = &IpfsReconciler{
+			Client: fakeClient,
+			Scheme: scheme,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+		instance = get()
+		open = &maintenanceGate{open: true}
+
+		hourAgo := time.Now().Add(-time.Hour)
+		peer(0, hourAgo, true)
+		peer(1, hourAgo, true)
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("restarts every peer with a new secret and completes once they rejoin", func() {
+		requeue, err := reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(rotationPollInterval))
+		Expect(clusterSecret()).NotTo(Equal("old"))
+		Expect(instance.Status.SecretRotation.StartedAt).NotTo(BeNil())
+		Expect(rotating().Status).To(Equal(metav1.ConditionTrue))
+		Expect(rotating().Reason).To(Equal(clusterv1alpha1.RotatingReasonInProgress))
+		Expect(podExists(0)).To(BeFalse())
+		Expect(podExists(1)).To(BeFalse())
+		Expect(get().Status.SecretRotation.StartedAt).NotTo(BeNil())
+
+		By("waiting for the peers to restart")
+		instance = get()
+		requeue, err = reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(rotationPollInterval))
+		Expect(rotating().Message).To(Equal("2 peers still restarting with the new secret"))
+
+		By("waiting for the peers to rejoin the cluster")
+		restarted := time.Now().Add(time.Minute)
+		peer(0, restarted, true)
+		peer(1, restarted, true)
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWA", Peername: peerPodName(instance, 0)})
+		requeue, err = reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(Equal(rotationPollInterval))
+		Expect(rotating().Message).To(Equal("1 of 2 peers rejoined the cluster"))
+		Expect(podExists(0)).To(BeTrue())
+
+		cluster.AddPeer(clusterapi.Peer{ID: "12D3KooWB", Peername: peerPodName(instance, 1)})
+		requeue, err = reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		current := get()
+		Expect(current.Status.SecretRotation.StartedAt).To(BeNil())
+		Expect(current.Status.SecretRotation.LastRotationTime).NotTo(BeNil())
+		cond := meta.FindStatusCondition(current.Status.Conditions, clusterv1alpha1.ConditionRotating)
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.RotatingReasonComplete))
+		Expect(current.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRotateSecret))
+	})
+
+	It("fails once the peers did not rejoin in time and halts until the annotation is removed", func() {
+		_, err := reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		rotated := clusterSecret()
+		instance = get()
+		startedAt := metav1.NewTime(time.Now().Add(-rotationTimeout - time.Minute))
+		instance.Status.SecretRotation.StartedAt = &startedAt
+
+		requeue, err := reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(rotating().Status).To(Equal(metav1.ConditionFalse))
+		Expect(rotating().Reason).To(Equal(clusterv1alpha1.RotatingReasonFailed))
+
+		By("halting while the annotation is set")
+		requeue, err = reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(instance.Status.SecretRotation.StartedAt).NotTo(BeNil())
+		Expect(rotating().Reason).To(Equal(clusterv1alpha1.RotatingReasonFailed))
+		Expect(clusterSecret()).To(Equal(rotated))
+
+		By("resetting once the annotation is removed")
+		delete(instance.Annotations, clusterv1alpha1.AnnotationRotateSecret)
+		requeue, err = reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(instance.Status.SecretRotation.StartedAt).To(BeNil())
+		Expect(rotating()).To(BeNil())
+		Expect(clusterSecret()).To(Equal(rotated))
+	})
+
+	It("waits for the maintenance window", func() {
+		closed := &maintenanceGate{configured: true, at: time.Now().Add(time.Hour)}
+		requeue, err := reconciler.reconcileSecretRotation(ctx, instance, closed)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(closed.waiting).To(ConsistOf(operationSecretRotation))
+		Expect(clusterSecret()).To(Equal("old"))
+		Expect(instance.Status.SecretRotation.StartedAt).To(BeNil())
+		Expect(podExists(0)).To(BeTrue())
+		Expect(podExists(1)).To(BeTrue())
+	})
+
+	It("does nothing until requested", func() {
+		delete(instance.Annotations, clusterv1alpha1.AnnotationRotateSecret)
+		requeue, err := reconciler.reconcileSecretRotation(ctx, instance, open)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(requeue).To(BeZero())
+		Expect(clusterSecret()).To(Equal("old"))
+		Expect(rotating()).To(BeNil())
+	})
+})
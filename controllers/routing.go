@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// routingMarkerPath Marks the repos whose routing the operator wrote, to
+	// restore the defaults of kubo once the spec no longer sets it.
+	routingMarkerPath = "/data/ipfs/.routing"
+	// routerAuthEnvPrefix Prefixes the environment variables holding the
+	// credentials of the delegated routers, suffixed with their index.
+	routerAuthEnvPrefix = "ROUTER_AUTH_"
+	// routerTimeout Bounds each query to a delegated router.
+	routerTimeout = "30s"
+)
+
+// routerMethods Are the routing methods of kubo, all delegated to the routers
+// of the custom type.
+var routerMethods = []string{"find-peers", "find-providers", "get-ipns", "put-ipns", "provide"}
+
+// routingType Returns the routing type of the spec, or an empty string when
+// the peers keep the one of kubo.
+func routingType(m *clusterv1alpha1.Ipfs) string {
+	if m.Spec.Routing == nil {
+		return ""
+	}
+	return m.Spec.Routing.Type
+}
+
+// routerEndpoint Returns the endpoint of the delegated router with the given
+// index, as written into the configure script: the credentials of the router
+// are expanded from the environment by the shell.
+func routerEndpoint(router clusterv1alpha1.DelegatedRouter, i int) string {
+	if router.AuthSecretRef == nil {
+		return router.Endpoint
+	}
+	scheme, rest, _ := strings.Cut(router.Endpoint, "://")
+	return fmt.Sprintf("%s://${%s%d}@%s", scheme, routerAuthEnvPrefix, i, rest)
+}
+
+// routingConfig Returns the Routing section of the kubo config for the spec.
+// The delegated routers of the custom type are queried in parallel for every
+// method, ignoring the errors of each router so that one router failing
+// does not fail the queries the others answer.
+func routingConfig(m *clusterv1alpha1.Ipfs) ([]byte, error) {
+	routing := m.Spec.Routing
+	config := map[string]interface{}{"Type": routing.Type}
+	if routing.Type == clusterv1alpha1.RoutingTypeCustom {
+		routers := map[string]interface{}{}
+		parallel := make([]map[string]interface{}, 0, len(routing.Routers))
+		for i, router := range routing.Routers {
+			routers[router.Name] = map[string]interface{}{
+				"Type":       "http",
+				"Parameters": map[string]string{"Endpoint": routerEndpoint(router, i)},
+			}
+			parallel = append(parallel, map[string]interface{}{
+				"RouterName":   router.Name,
+				"IgnoreErrors": true,
+				"Timeout":      routerTimeout,
+			})
+		}
+		routers[clusterv1alpha1.ReservedRouterName] = map[string]interface{}{
+			"Type":       "parallel",
+			"Parameters": map[string]interface{}{"Routers": parallel},
+		}
+		methods := map[string]interface{}{}
+		for _, method := range routerMethods {
+			methods[method] = map[string]string{"RouterName": clusterv1alpha1.ReservedRouterName}
+		}
+		config["Routers"] = routers
+		config["Methods"] = methods
+	}
+	return json.Marshal(config)
+}
+
+// routingCommands Returns the commands of the configure script writing the
+// routing of the spec, at every start of the peers. The routing of the repos
+// the operator wrote is reset to the defaults of kubo once the spec no longer
+// sets it.
+func routingCommands(m *clusterv1alpha1.Ipfs) (string, error) {
+	if m.Spec.Routing == nil {
+		return fmt.Sprintf("\tif [ -f %[1]s ]; then\n\t\tipfs config --json Routing '{}'\n"+
+			"\t\trm %[1]s\n\tfi\n", routingMarkerPath), nil
+	}
+	config, err := routingConfig(m)
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize the routing config: %w", err)
+	}
+	// Close the single quotes around the credentials, for the shell to
+	// expand them, without tracing the command holding them.
+	quoted := string(config)
+	command := "\tipfs config --json Routing '%s'\n\ttouch %s\n"
+	for i, router := range m.Spec.Routing.Routers {
+		if router.AuthSecretRef != nil {
+			env := fmt.Sprintf("${%s%d}", routerAuthEnvPrefix, i)
+			quoted = strings.ReplaceAll(quoted, env, `'"`+env+`"'`)
+			command = "\tset +x\n\tipfs config --json Routing '%s'\n\tset -x\n\ttouch %s\n"
+		}
+	}
+	return fmt.Sprintf(command, quoted, routingMarkerPath), nil
+}
+
+// routingEnv Returns the environment holding the credentials of the
+// delegated routers, for the configure script.
+func routingEnv(m *clusterv1alpha1.Ipfs) []corev1.EnvVar {
+	if m.Spec.Routing == nil {
+		return nil
+	}
+	var env []corev1.EnvVar
+	for i, router := range m.Spec.Routing.Routers {
+		if router.AuthSecretRef == nil {
+			continue
+		}
+		env = append(env, corev1.EnvVar{
+			Name:      fmt.Sprintf("%s%d", routerAuthEnvPrefix, i),
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: router.AuthSecretRef},
+		})
+	}
+	return env
+}
+
+// routingSettings Returns the routing of the spec, for the config hash
+// rolling the peers when it changes.
+func routingSettings(m *clusterv1alpha1.Ipfs) []string {
+	if m.Spec.Routing == nil {
+		return nil
+	}
+	settings := []string{"routing/type=" + m.Spec.Routing.Type}
+	for _, router := range m.Spec.Routing.Routers {
+		settings = append(settings, fmt.Sprintf("routing/router/%s=%s", router.Name, router.Endpoint))
+	}
+	return settings
+}
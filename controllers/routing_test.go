@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs routing", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	script := func() string {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-scripts-" + key.Name}, cm)).To(Succeed())
+		return cm.Data["configure-ipfs.sh"]
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "routed"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.18.1"
+		instance.Spec.Routing = &clusterv1alpha1.RoutingConfig{
+			Type: clusterv1alpha1.RoutingTypeCustom,
+			Routers: []clusterv1alpha1.DelegatedRouter{
+				{Name: "cid-contact", Endpoint: "https://cid.contact"},
+				{Name: "private", Endpoint: "https://router.example.com/v1", AuthSecretRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "router-auth"},
+					Key:                  "userinfo",
+				}},
+			},
+		}
+		sec := &corev1.Secret{}
+		sec.Name = "router-auth"
+		sec.Namespace = key.Namespace
+		sec.Data = map[string][]byte{"userinfo": []byte("ipfs:s3cret")}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, sec).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("renders the delegated routers into the routing of kubo", func() {
+		config, err := routingConfig(&clusterv1alpha1.Ipfs{Spec: clusterv1alpha1.IpfsSpec{
+			Routing: &clusterv1alpha1.RoutingConfig{
+				Type:    clusterv1alpha1.RoutingTypeCustom,
+				Routers: []clusterv1alpha1.DelegatedRouter{{Name: "cid-contact", Endpoint: "https://cid.contact"}},
+			},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		routing := map[string]interface{}{}
+		Expect(json.Unmarshal(config, &routing)).To(Succeed())
+		Expect(routing).To(HaveKeyWithValue("Type", "custom"))
+		Expect(routing["Routers"]).To(HaveKeyWithValue("cid-contact", map[string]interface{}{
+			"Type": "http", "Parameters": map[string]interface{}{"Endpoint": "https://cid.contact"},
+		}))
+		Expect(routing["Routers"]).To(HaveKey("parallel"))
+		Expect(routing["Methods"]).To(HaveLen(len(routerMethods)))
+		Expect(routing["Methods"]).To(HaveKeyWithValue("provide", map[string]interface{}{"RouterName": "parallel"}))
+
+		By("writing only the type for the other types")
+		config, err = routingConfig(&clusterv1alpha1.Ipfs{Spec: clusterv1alpha1.IpfsSpec{
+			Routing: &clusterv1alpha1.RoutingConfig{Type: clusterv1alpha1.RoutingTypeNone},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(config)).To(Equal(`{"Type":"none"}`))
+	})
+
+	It("configures the peers with the routing of the spec and reports it", func() {
+		reconcile()
+		instance := reconcile()
+		Expect(instance.Status.Routing).To(Equal(clusterv1alpha1.RoutingTypeCustom))
+		Expect(script()).To(ContainSubstring(`"Endpoint":"https://'"${ROUTER_AUTH_1}"'@router.example.com/v1"`))
+		Expect(script()).To(ContainSubstring("\tset +x\n\tipfs config --json Routing '"))
+		Expect(script()).To(ContainSubstring("\tset -x\n\ttouch /data/ipfs/.routing"))
+
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-routed"}, sts)).To(Succeed())
+		configure := sts.Spec.Template.Spec.InitContainers[len(sts.Spec.Template.Spec.InitContainers)-1]
+		Expect(configure.Name).To(Equal("configure-ipfs"))
+		Expect(configure.Env).To(ContainElement(HaveField("Name", "ROUTER_AUTH_1")))
+
+		By("rolling the peers when the routing changes")
+		hash, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		instance.Spec.Routing = &clusterv1alpha1.RoutingConfig{Type: clusterv1alpha1.RoutingTypeDHTClient}
+		Expect(reconciler.referencedConfigHash(ctx, instance)).NotTo(Equal(hash))
+
+		By("restoring the routing of kubo once the spec no longer sets it")
+		instance.Spec.Routing = nil
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(instance.Status.Routing).To(BeEmpty())
+		Expect(script()).To(ContainSubstring("ipfs config --json Routing '{}'"))
+	})
+})
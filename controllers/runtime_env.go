@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// goMemLimitPercent Is the share of the container memory limit handed to the
+// Go garbage collector, leaving headroom for memory it does not account for.
+const goMemLimitPercent = 90
+
+// goRuntimeEnv Returns the GOMEMLIMIT and GOMAXPROCS variables matching the
+// given container limits. The Go runtime does not read the cgroup limits by
+// itself, and otherwise gets OOM-killed right below the memory limit.
+func goRuntimeEnv(limits corev1.ResourceList) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	if mem, ok := limits[corev1.ResourceMemory]; ok && !mem.IsZero() {
+		env = append(env, corev1.EnvVar{
+			Name:  "GOMEMLIMIT",
+			Value: strconv.FormatInt(goMemLimit(mem.Value()), 10),
+		})
+	}
+	if cpu, ok := limits[corev1.ResourceCPU]; ok && !cpu.IsZero() {
+		env = append(env, corev1.EnvVar{
+			Name:  "GOMAXPROCS",
+			Value: strconv.FormatInt(goMaxProcs(cpu.MilliValue()), 10),
+		})
+	}
+	return env
+}
+
+// goMemLimit Returns the soft memory limit, in bytes, for a container
+// limited to the given amount of bytes.
+func goMemLimit(limitBytes int64) int64 {
+	return limitBytes / 100 * goMemLimitPercent
+}
+
+// goMaxProcs Returns the number of OS threads running Go code for a
+// container limited to the given amount of millicores. Fractional
+// cores are rounded up, and at least one thread is always allowed.
+func goMaxProcs(limitMilli int64) int64 {
+	procs := (limitMilli + 999) / 1000
+	if procs < 1 {
+		procs = 1
+	}
+	return procs
+}
+
+// mergeEnv Returns env with the variables of overrides replacing the ones
+// of the same name, and the remaining overrides appended.
+func mergeEnv(env, overrides []corev1.EnvVar) []corev1.EnvVar {
+	merged := make([]corev1.EnvVar, 0, len(env)+len(overrides))
+	index := make(map[string]int, len(env))
+	for _, e := range env {
+		index[e.Name] = len(merged)
+		merged = append(merged, e)
+	}
+	for _, e := range overrides {
+		if i, ok := index[e.Name]; ok {
+			merged[i] = e
+			continue
+		}
+		index[e.Name] = len(merged)
+		merged = append(merged, e)
+	}
+	return merged
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("Go runtime environment", func() {
+	DescribeTable("GOMAXPROCS rounds fractional CPU limits up",
+		func(limit string, expected int64) {
+			quantity := resource.MustParse(limit)
+			Expect(goMaxProcs(quantity.MilliValue())).To(Equal(expected))
+		},
+		Entry("a tenth of a core", "100m", int64(1)),
+		Entry("one core", "1", int64(1)),
+		Entry("one and a half cores", "1500m", int64(2)),
+		Entry("just above two cores", "2001m", int64(3)),
+		Entry("four cores", "4000m", int64(4)),
+	)
+
+	DescribeTable("GOMEMLIMIT is 90% of the memory limit",
+		func(limit string, expected int64) {
+			quantity := resource.MustParse(limit)
+			Expect(goMemLimit(quantity.Value())).To(Equal(expected))
+		},
+		Entry("one gibibyte", "1Gi", int64(966367620)),
+		Entry("five hundred megabytes", "500M", int64(450000000)),
+	)
+
+	It("only sets the variables whose limits are set", func() {
+		env := goRuntimeEnv(corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("1500m"),
+		})
+		Expect(env).To(Equal([]corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}}))
+		Expect(goRuntimeEnv(nil)).To(BeEmpty())
+	})
+
+	It("lets user-provided variables win", func() {
+		env := mergeEnv(
+			goRuntimeEnv(corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}),
+			[]corev1.EnvVar{
+				{Name: "GOMAXPROCS", Value: "8"},
+				{Name: "GOGC", Value: "50"},
+			},
+		)
+		Expect(env).To(Equal([]corev1.EnvVar{
+			{Name: "GOMEMLIMIT", Value: "966367620"},
+			{Name: "GOMAXPROCS", Value: "8"},
+			{Name: "GOGC", Value: "50"},
+		}))
+	})
+})
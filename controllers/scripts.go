@@ -28,6 +28,12 @@ user=ipfs
 # node running in the cluster. It has been set up using a configmap to
 # allow changes on the fly.
 
+# A compaction Job owns the state of the peer while the marker exists. A
+# marker older than the deadline of the Job is left over.
+while [ -f ` + compactionMarker + ` ] && [ -z "$(find ` + compactionMarker + ` -mmin +60)" ]; do
+	sleep 5
+done
+rm -f ` + compactionMarker + `
 
 if [ ! -f /data/ipfs-cluster/service.json ]; then
 	ipfs-cluster-service init --consensus crdt
@@ -35,13 +41,36 @@ fi
 
 PEER_HOSTNAME=$(cat /proc/sys/kernel/hostname)
 
-grep -q ".*-0$" /proc/sys/kernel/hostname
-if [ $? -eq 0 ]; then
-	CLUSTER_ID=${BOOTSTRAP_PEER_ID} \
-	CLUSTER_PRIVATEKEY=${BOOTSTRAP_PEER_PRIV_KEY} \
+# Every peer runs with the ipfs-cluster identity the operator stores for its
+# ordinal, which is distinct from the identity of its kubo node. The first
+# peer is the bootstrap peer.
+ORDINAL=${PEER_HOSTNAME##*-}
+if [ "${ORDINAL}" = "0" ]; then
+	CLUSTER_ID=${BOOTSTRAP_PEER_ID}
+	CLUSTER_PRIVATEKEY=${BOOTSTRAP_PEER_PRIV_KEY}
+elif [ -f /identities/PEER_PRIV_KEY_${ORDINAL} ]; then
+	CLUSTER_ID=$(cat /identities/PEER_ID_${ORDINAL})
+	CLUSTER_PRIVATEKEY=$(cat /identities/PEER_PRIV_KEY_${ORDINAL})
+fi
+if [ -n "${CLUSTER_ID}" ]; then
+	printf '{\n  "id": "%s",\n  "private_key": "%s"\n}\n' "${CLUSTER_ID}" "${CLUSTER_PRIVATEKEY}" \
+		> /data/ipfs-cluster/identity.json
+fi
+unset CLUSTER_ID CLUSTER_PRIVATEKEY
+
+# The bootstrap peers publish a role tag instead of the group tag. The
+# allocator only places pins on the peers publishing every metric it sorts
+# by, tag:group among them, so that it leaves the bootstrap peers out.
+if [ "${ORDINAL}" -lt "${BOOTSTRAP_PEERS:-0}" ]; then
+	export CLUSTER_TAGS_TAGS=role:bootstrap
+else
+	export CLUSTER_TAGS_TAGS=group:default
+fi
+
+if [ "${ORDINAL}" = "0" ]; then
 	exec ipfs-cluster-service daemon --upgrade
 else
-	BOOTSTRAP_ADDR=/dns4/${SVC_NAME}-0.${SVC_NAME}/tcp/9096/ipfs/${BOOTSTRAP_PEER_ID}
+	BOOTSTRAP_ADDR=/dns4/${PEER_HOSTNAME%-*}-0.${SVC_NAME}/tcp/9096/ipfs/${BOOTSTRAP_PEER_ID}
 
 	if [ -z $BOOTSTRAP_ADDR ]; then
 		exit 1
@@ -59,25 +88,39 @@ user=ipfs
 # This is a custom entrypoint for k8s designed to run ipfs nodes in an appropriate
 # setup for production scenarios.
 
-if [ -f /data/ipfs/config ]; then
-	if [ -f /data/ipfs/repo.lock ]; then
-		rm /data/ipfs/repo.lock
-	fi
+# tune Applies the settings which follow the spec, at every start of the
+# peer, whether its repo is new or not.
+tune() {
+	ipfs config --json Peering.Peers '%[3]s'
+%[7]s}
+
+if [ -f /data/ipfs/repo.lock ]; then
+	rm /data/ipfs/repo.lock
+fi
+if [ -f /data/ipfs/.seed-pending ]; then
+	# The repo comes from a seed: drop the keys it came with and give the
+	# node a new identity, keeping the datastore as it is.
+	rm -rf /data/ipfs/keystore
+	ipfs key rotate --oldkey=seed-identity
+	ipfs key rm seed-identity
+	rm /data/ipfs/.seed-pending
+elif [ -f /data/ipfs/config ]; then
+	tune
 	exit 0
+else
+	%[1]s
 fi
-
-ipfs init --profile=badgerds,server
 MYSELF=$(ipfs id -f="<id>")
 
 ipfs config Addresses.API /ip4/0.0.0.0/tcp/5001
 ipfs config Addresses.Gateway /ip4/0.0.0.0/tcp/8080
 ipfs config --json Swarm.ConnMgr.HighWater 2000
 ipfs config --json Datastore.BloomFilterSize 1048576
-ipfs config --json Swarm.RelayClient '%s'
+ipfs config --json Swarm.RelayClient '%[2]s'
 ipfs config --json Swarm.EnableHolePunching true
-ipfs config --json Peering.Peers '%s'
-ipfs config Datastore.StorageMax 100GB
-
+tune
+ipfs config Datastore.StorageMax %[5]dB
+%[6]s%[4]s
 chown -R ipfs: /data/ipfs
 `
 )
@@ -88,30 +131,26 @@ func (r *IpfsReconciler) configMapScripts(
 	cm *corev1.ConfigMap,
 ) (controllerutil.MutateFn, string) {
 	log := ctrllog.FromContext(ctx)
-	relayPeers := []*peer.AddrInfo{}
-	relayStatic := []*ma.Multiaddr{}
-	for _, relayName := range m.Status.CircuitRelays {
-		relay := clusterv1alpha1.CircuitRelay{}
-		relay.Name = relayName
-		relay.Namespace = m.Namespace
-		err := r.Get(ctx, client.ObjectKeyFromObject(&relay), &relay)
-		if err != nil {
-			log.Error(err, "could not lookup circuitRelay during confgMapScripts", "relay", relayName)
-			return nil, ""
-		}
-		if err = relay.Status.AddrInfo.Parse(); err != nil {
-			log.Error(err, "could not parse AddrInfo. Information will not be included in config", "relay", relayName)
-			continue
-		}
-		ai := relay.Status.AddrInfo.AddrInfo()
-		relayPeers = append(relayPeers, ai)
-		p2ppart, err := ma.NewMultiaddr("/p2p/" + ai.ID.String())
-		if err != nil {
-			log.Error(err, "could not create p2p component during configMapScripts", "relay", relayName)
-		}
+	relayPeers, relayStatic, err := r.staticRelays(ctx, m)
+	if err != nil {
+		log.Error(err, "could not lookup circuitRelay during confgMapScripts")
+		return nil, ""
+	}
+
+	// The peers of the federated clusters are peered with as the relays are,
+	// and bootstrapped from.
+	federated := r.importedAddrInfos(ctx, m)
+	relayPeers = append(relayPeers, federated...)
+	peering, err := peeringAddrInfos(m)
+	if err != nil {
+		log.Error(err, "could not parse the peering entries during configMapScripts")
+		return nil, ""
+	}
+	relayPeers = append(relayPeers, peering...)
+	bootstrap := ""
+	for _, ai := range federated {
 		for _, addr := range ai.Addrs {
-			fullMa := addr.Encapsulate(p2ppart)
-			relayStatic = append(relayStatic, &fullMa)
+			bootstrap += fmt.Sprintf("ipfs bootstrap add %s/p2p/%s\n", addr, ai.ID)
 		}
 	}
 
@@ -124,7 +163,23 @@ func (r *IpfsReconciler) configMapScripts(
 	relayClientConfigJSON, _ := json.Marshal(relayClientConfig)
 	peeringConfigJSON, _ := encjson.Marshal(relayPeers)
 
-	configureIpfsFixed := fmt.Sprintf(configureIpfs, string(relayClientConfigJSON), string(peeringConfigJSON))
+	datastoreConfig, err := datastoreCommands(m)
+	if err != nil {
+		log.Error(err, "could not render the datastore config during configMapScripts")
+		return nil, ""
+	}
+	storageMax, err := ipfsStorageMax(m)
+	if err != nil {
+		log.Error(err, "could not size the repo during configMapScripts")
+		return nil, ""
+	}
+	extraConfig, err := extraConfigCommands(m)
+	if err != nil {
+		log.Error(err, "could not render the config during configMapScripts")
+		return nil, ""
+	}
+	configureIpfsFixed := fmt.Sprintf(configureIpfs, ipfsInitCommand(m), string(relayClientConfigJSON),
+		string(peeringConfigJSON), datastoreConfig, storageMax, bootstrap, extraConfig)
 
 	expected := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -141,6 +196,61 @@ func (r *IpfsReconciler) configMapScripts(
 		return nil, ""
 	}
 	return func() error {
+		// Keep the peering up to date for the next start of the peers.
+		cm.Data = expected.Data
 		return nil
 	}, cmName
 }
+
+// staticRelays Returns the relays of the cluster, and the addresses the peers
+// statically relay through. A relay whose address does not parse is left out.
+func (r *IpfsReconciler) staticRelays(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) ([]*peer.AddrInfo, []*ma.Multiaddr, error) {
+	log := ctrllog.FromContext(ctx)
+	relayPeers := []*peer.AddrInfo{}
+	relayStatic := []*ma.Multiaddr{}
+	for _, relayName := range m.Status.CircuitRelays {
+		relay := clusterv1alpha1.CircuitRelay{}
+		relay.Name = relayName
+		relay.Namespace = m.Namespace
+		if err := r.Get(ctx, client.ObjectKeyFromObject(&relay), &relay); err != nil {
+			return nil, nil, fmt.Errorf("cannot get CircuitRelay %s: %w", relayName, err)
+		}
+		if err := relay.Status.AddrInfo.Parse(); err != nil {
+			log.Error(err, "could not parse AddrInfo. Information will not be included in config", "relay", relayName)
+			continue
+		}
+		ai := relay.Status.AddrInfo.AddrInfo()
+		relayPeers = append(relayPeers, ai)
+		p2ppart, err := ma.NewMultiaddr("/p2p/" + ai.ID.String())
+		if err != nil {
+			log.Error(err, "could not create p2p component during configMapScripts", "relay", relayName)
+		}
+		for _, addr := range ai.Addrs {
+			fullMa := addr.Encapsulate(p2ppart)
+			relayStatic = append(relayStatic, &fullMa)
+		}
+	}
+	return relayPeers, relayStatic, nil
+}
+
+// extraConfigCommands Returns the commands applying the settings of the spec
+// which the script does not set itself.
+func extraConfigCommands(m *clusterv1alpha1.Ipfs) (string, error) {
+	dnsConfig, err := dnsCommands(m)
+	if err != nil {
+		return "", fmt.Errorf("cannot render the DNS config: %w", err)
+	}
+	routing, err := routingCommands(m)
+	if err != nil {
+		return "", fmt.Errorf("cannot render the routing config: %w", err)
+	}
+	headers, err := httpHeadersCommands(m)
+	if err != nil {
+		return "", fmt.Errorf("cannot render the HTTP headers: %w", err)
+	}
+	return provideCommands(m) + dnsConfig + headers + routing + swarmCommands(m) + resourceManagerCommands(m) +
+		bandwidthCommands(m) + bootstrapCommands(m) + datastoreGCCommands(m) + profilingCommands(m), nil
+}
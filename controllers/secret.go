@@ -1,29 +1,59 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
 )
 
-func (r *IpfsReconciler) secretConfig(
+// identitiesSecretName Returns the name of the Secret holding the
+// ipfs-cluster identities of the peers.
+func identitiesSecretName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-identities"
+}
+
+// clusterSecretName Returns the name of the Secret holding the cluster
+// secret the peers share. The operator generates it, or copies it from the
+// source of a clone, apart from the identities of the peers.
+func clusterSecretName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-cluster-secret"
+}
+
+// legacyConfigSecretName Returns the name of the Secret which held both the
+// identities and the cluster secret, before they were split.
+func legacyConfigSecretName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name
+}
+
+// isIdentityKey Returns whether the key of a Secret holds an identity of a
+// peer, rather than the cluster secret.
+func isIdentityKey(key string) bool {
+	return key != clusterSecretKey
+}
+
+func (r *IpfsReconciler) secretIdentities(
 	m *clusterv1alpha1.Ipfs,
 	sec *corev1.Secret,
-	clusterSecret,
 	bootstrapPrivateKey []byte,
 ) (controllerutil.MutateFn, string) {
-	secName := "ipfs-cluster-" + m.Name
+	secName := identitiesSecretName(m)
 	expected := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      secName,
 			Namespace: m.Namespace,
 		},
 		Data: map[string][]byte{
-			"CLUSTER_SECRET":          clusterSecret,
-			"BOOTSTRAP_PEER_PRIV_KEY": bootstrapPrivateKey,
+			bootstrapPrivateKeyKey: bootstrapPrivateKey,
 		},
 	}
 	expected.DeepCopyInto(sec)
@@ -32,6 +62,174 @@ func (r *IpfsReconciler) secretConfig(
 		return func() error { return err }, ""
 	}
 	return func() error {
-		return nil
+		return ensurePeerIdentities(sec, m.Spec.Replicas)
 	}, secName
 }
+
+func (r *IpfsReconciler) secretClusterSecret(
+	m *clusterv1alpha1.Ipfs,
+	sec *corev1.Secret,
+	clusterSecret []byte,
+) (controllerutil.MutateFn, string) {
+	secName := clusterSecretName(m)
+	expected := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secName,
+			Namespace: m.Namespace,
+		},
+		Data: map[string][]byte{
+			clusterSecretKey: clusterSecret,
+		},
+	}
+	expected.DeepCopyInto(sec)
+	if err := ctrl.SetControllerReference(m, sec, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error { return nil }, secName
+}
+
+// ensurePeerIdentities Adds an ipfs-cluster identity to the Secret for each
+// peer but the bootstrap one which has none yet. Every peer then runs with an
+// identity of its own, distinct from the identity of its kubo node, which
+// outlives its pod and its volume. The identities of the peers removed by a
+// scale down are kept for when they come back.
+func ensurePeerIdentities(sec *corev1.Secret, replicas int32) error {
+	for ordinal := int32(1); ordinal < replicas; ordinal++ {
+		idKey, privKey := peerIdentityKeys(ordinal)
+		if _, ok := sec.Data[privKey]; ok {
+			continue
+		}
+		id, priv, err := generateIdentity()
+		if err != nil {
+			return err
+		}
+		if sec.Data == nil {
+			sec.Data = map[string][]byte{}
+		}
+		sec.Data[idKey] = []byte(id.String())
+		sec.Data[privKey] = []byte(priv)
+	}
+	return nil
+}
+
+// configSecret Returns the identities and the cluster secret of the named
+// cluster, merged from the Secrets holding them, or the Secret which held
+// both when the cluster was not split yet. It returns nil when the cluster
+// has none of them.
+func (r *IpfsReconciler) configSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	m := &clusterv1alpha1.Ipfs{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	var merged *corev1.Secret
+	for _, secName := range []string{identitiesSecretName(m), clusterSecretName(m)} {
+		sec := corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: secName}, &sec); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("cannot get secret %s: %w", secName, err)
+			}
+			continue
+		}
+		if merged == nil {
+			merged = sec.DeepCopy()
+			continue
+		}
+		if merged.Data == nil {
+			merged.Data = map[string][]byte{}
+		}
+		for key, value := range sec.Data {
+			merged.Data[key] = value
+		}
+	}
+	if merged != nil {
+		return merged, nil
+	}
+	legacy := corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: legacyConfigSecretName(m)}, &legacy)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot get secret %s: %w", legacyConfigSecretName(m), err)
+	}
+	return &legacy, nil
+}
+
+// splitConfigSecret Creates the Secrets holding the identities and the
+// cluster secret from the Secret which held both, so that the peers keep
+// their identities and their cluster secret once they mount the new ones.
+// The Secrets which already exist are left alone.
+func (r *IpfsReconciler) splitConfigSecret(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	legacy := corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: legacyConfigSecretName(m)}, &legacy)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	for _, secName := range []string{identitiesSecretName(m), clusterSecretName(m)} {
+		sec := corev1.Secret{}
+		sec.Name = secName
+		sec.Namespace = m.Namespace
+		sec.Labels = ipfsLabels(m, componentPeer)
+		sec.Data = map[string][]byte{}
+		for key, value := range legacy.Data {
+			if isIdentityKey(key) == (secName == identitiesSecretName(m)) {
+				sec.Data[key] = value
+			}
+		}
+		if err = ctrl.SetControllerReference(m, &sec, r.Scheme); err != nil {
+			return err
+		}
+		if err = r.Create(ctx, &sec); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("cannot create secret %s: %w", secName, err)
+		}
+	}
+	return nil
+}
+
+// deleteLegacyConfigSecret Deletes the Secret which held both the
+// identities and the cluster secret once every peer rolled onto the Secrets
+// they were split into, as the pods started before still reference it.
+func (r *IpfsReconciler) deleteLegacyConfigSecret(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	legacy := corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: legacyConfigSecretName(m)}, &legacy)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	sts := appsv1.StatefulSet{}
+	if err = r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}, &sts); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !rolledOut(&sts) || referencesSecret(&sts.Spec.Template.Spec, legacy.Name) {
+		return nil
+	}
+	if err = r.Delete(ctx, &legacy); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete secret %s: %w", legacy.Name, err)
+	}
+	ctrllog.FromContext(ctx).Info("the peers rolled onto the split secrets, deleted the combined one",
+		"secret", legacy.Name)
+	return nil
+}
+
+// rolledOut Returns whether every pod of the StatefulSet runs its latest
+// template.
+func rolledOut(sts *appsv1.StatefulSet) bool {
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		sts.Status.UpdateRevision == sts.Status.CurrentRevision &&
+		sts.Status.UpdatedReplicas == sts.Status.Replicas
+}
+
+// referencesSecret Returns whether the pods of the template mount the named
+// Secret or read their environment from it.
+func referencesSecret(spec *corev1.PodSpec, name string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs peer identities", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	secretData := func() map[string][]byte {
+		sec := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name + "-identities"},
+			sec)).To(Succeed())
+		return sec.Data
+	}
+
+	// peer Creates the ready pod of the peer with the given ordinal.
+	peer := func(ordinal int32) {
+		pod := &corev1.Pod{}
+		pod.Name = fmt.Sprintf("ipfs-cluster-%s-%d", key.Name, ordinal)
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{labelName: "ipfs-cluster-" + key.Name}
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "identities"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		dialer := kubofake.NewDialer()
+		for i := 0; i < 3; i++ {
+			dialer.Add(key.Namespace, fmt.Sprintf("ipfs-cluster-%s-%d", key.Name, i),
+				kubofake.NewNode(fmt.Sprintf("12D3KooWKubo%d", i)))
+		}
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme, Kubo: dialer}
+	})
+
+	It("stores a cluster identity for every peer, distinct from its kubo identity", func() {
+		for i := int32(0); i < 3; i++ {
+			peer(i)
+		}
+		reconcile()
+		instance := reconcile()
+		data := secretData()
+		Expect(data).NotTo(HaveKey("PEER_ID_0"))
+		Expect(data).To(HaveKey("PEER_PRIV_KEY_1"))
+		Expect(data).To(HaveKey("PEER_PRIV_KEY_2"))
+		Expect(data["PEER_ID_1"]).NotTo(Equal(data["PEER_ID_2"]))
+		bootstrapID, err := peerIDOf(data["BOOTSTRAP_PEER_PRIV_KEY"])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(instance.Status.Peers).To(HaveLen(3))
+		Expect(instance.Status.Peers[0].ID).To(Equal(bootstrapID.String()))
+		Expect(instance.Status.Peers[1].ID).To(Equal(string(data["PEER_ID_1"])))
+		for i, p := range instance.Status.Peers {
+			Expect(p.KuboID).To(Equal(fmt.Sprintf("12D3KooWKubo%d", i)))
+			Expect(p.ID).NotTo(Equal(p.KuboID))
+		}
+
+		By("keeping the identities across restarts of the pods")
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-identities-1"
+		pod.Namespace = key.Namespace
+		Expect(fakeClient.Delete(ctx, pod)).To(Succeed())
+		peer(1)
+		instance = reconcile()
+		Expect(secretData()).To(Equal(data))
+		Expect(instance.Status.Peers[1].ID).To(Equal(string(data["PEER_ID_1"])))
+
+		By("adding an identity for a new peer only")
+		instance.Spec.Replicas = 4
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		reconcile()
+		scaled := secretData()
+		Expect(scaled).To(HaveKey("PEER_PRIV_KEY_3"))
+		for k, v := range data {
+			Expect(scaled).To(HaveKeyWithValue(k, v))
+		}
+	})
+
+	It("splits the combined secret of a live cluster without changing the identities", func() {
+		bootstrapID, bootstrapKey, err := generateIdentity()
+		Expect(err).NotTo(HaveOccurred())
+		legacy := &corev1.Secret{}
+		legacy.Name = "ipfs-cluster-" + key.Name
+		legacy.Namespace = key.Namespace
+		legacy.Data = map[string][]byte{
+			"CLUSTER_SECRET":          []byte("0123abcd"),
+			"BOOTSTRAP_PEER_PRIV_KEY": []byte(bootstrapKey),
+			"PEER_ID_1":               []byte("12D3KooWPeer1"),
+			"PEER_PRIV_KEY_1":         []byte("peer-1-key"),
+		}
+		Expect(fakeClient.Create(ctx, legacy)).To(Succeed())
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-" + key.Name
+		sts.Namespace = key.Namespace
+		Expect(fakeClient.Create(ctx, sts)).To(Succeed())
+		sts.Status = appsv1.StatefulSetStatus{Replicas: 3, CurrentRevision: "before", UpdateRevision: "before"}
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+
+		reconcile()
+		reconcile()
+		identities := secretData()
+		Expect(identities).NotTo(HaveKey("CLUSTER_SECRET"))
+		Expect(identities).To(HaveKeyWithValue("BOOTSTRAP_PEER_PRIV_KEY", []byte(bootstrapKey)))
+		Expect(identities).To(HaveKeyWithValue("PEER_ID_1", []byte("12D3KooWPeer1")))
+		Expect(identities).To(HaveKeyWithValue("PEER_PRIV_KEY_1", []byte("peer-1-key")))
+		clusterSecret := &corev1.Secret{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-" + key.Name + "-cluster-secret"}, clusterSecret)).To(Succeed())
+		Expect(clusterSecret.Data).To(Equal(map[string][]byte{"CLUSTER_SECRET": []byte("0123abcd")}))
+		ids, err := reconciler.clusterPeerIDs(ctx, reconcile())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ids).To(HaveKeyWithValue(int32(0), bootstrapID.String()))
+
+		By("rolling the peers once onto the split secrets")
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: sts.Name}, sts)).To(Succeed())
+		template := sts.Spec.Template.DeepCopy()
+		Expect(referencesSecret(&template.Spec, legacy.Name)).To(BeFalse())
+		Expect(referencesSecret(&template.Spec, "ipfs-cluster-"+key.Name+"-identities")).To(BeTrue())
+		Expect(referencesSecret(&template.Spec, "ipfs-cluster-"+key.Name+"-cluster-secret")).To(BeTrue())
+		reconcile()
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: sts.Name}, sts)).To(Succeed())
+		Expect(sts.Spec.Template).To(Equal(*template))
+
+		By("keeping the combined secret until the peers rolled")
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: legacy.Name},
+			&corev1.Secret{})).To(Succeed())
+		sts.Status.UpdateRevision = "after"
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+		reconcile()
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: legacy.Name},
+			&corev1.Secret{})).To(Succeed())
+		sts.Status.CurrentRevision = "after"
+		sts.Status.UpdatedReplicas = 3
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+		reconcile()
+		err = fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: legacy.Name}, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(secretData()).To(Equal(identities))
+	})
+})
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+const (
+	// seedContainerName Is the name of the init container seeding the repo.
+	seedContainerName = "seed-repo"
+	// seedImagePath Is where seed images hold the repo snapshot.
+	seedImagePath = "/repo"
+)
+
+// seedRepo Runs on the first boot of each peer, guarded by a marker file. It
+// copies the repo snapshot of a seed image, or keeps the repo of a volume
+// cloned from a seed claim, and flags it for configure-ipfs.sh to replace
+// the identity it came with.
+const seedRepo = `
+set -e
+if [ -f /data/ipfs/.seeded ]; then
+	echo "the repo was already seeded"
+	exit 0
+fi
+if [ -n "${SEED_PATH}" ] && [ ! -f /data/ipfs/.seed-pending ]; then
+	echo "copying the repo from ${SEED_PATH}"
+	cp -a "${SEED_PATH}/." /data/ipfs/
+fi
+if [ -f /data/ipfs/config ]; then
+	touch /data/ipfs/.seed-pending
+fi
+rm -f /data/ipfs/api /data/ipfs/repo.lock
+touch /data/ipfs/.seeded
+`
+
+// seedContainer Returns the init container seeding the repo of the peers.
+// Seed images copy their snapshot themselves, while the volumes of seed
+// claims are already cloned when the container runs.
+func seedContainer(m *clusterv1alpha1.Ipfs) corev1.Container {
+	container := corev1.Container{
+		Name:            seedContainerName,
+		Image:           m.Spec.IpfsImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh", "-c", seedRepo},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "ipfs-storage",
+				MountPath: ipfsMountPath,
+			},
+		},
+	}
+	if m.Spec.Seed.Image != "" {
+		container.Image = m.Spec.Seed.Image
+		container.Env = []corev1.EnvVar{{Name: "SEED_PATH", Value: seedImagePath}}
+	}
+	return container
+}
+
+// seedClaims Creates the volume of each peer which has none yet as a clone
+// of its seed claim, before the StatefulSet creates an empty one. Peers
+// without a seed claim start from an empty repo.
+func (r *IpfsReconciler) seedClaims(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	if m.Spec.Seed == nil || m.Spec.Seed.ExistingClaimName == "" {
+		return nil
+	}
+	ssName := "ipfs-cluster-" + m.Name
+	for ordinal := int32(0); ordinal < m.Spec.Replicas; ordinal++ {
+		key := client.ObjectKey{Namespace: m.Namespace, Name: fmt.Sprintf("ipfs-storage-%s-%d", ssName, ordinal)}
+		if err := r.Get(ctx, key, &corev1.PersistentVolumeClaim{}); !errors.IsNotFound(err) {
+			if err != nil {
+				return fmt.Errorf("cannot get volume claim %s: %w", key.Name, err)
+			}
+			continue
+		}
+		source := corev1.PersistentVolumeClaim{}
+		sourceKey := client.ObjectKey{
+			Namespace: m.Namespace,
+			Name:      fmt.Sprintf("%s-%d", m.Spec.Seed.ExistingClaimName, ordinal),
+		}
+		if err := r.Get(ctx, sourceKey, &source); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("cannot get seed claim %s: %w", sourceKey.Name, err)
+		}
+
+		// A clone is at least as large as its source.
+		size := resource.MustParse(ipfsStorageFor(m, ordinal))
+		if sourceSize := source.Spec.Resources.Requests[corev1.ResourceStorage]; sourceSize.Cmp(size) > 0 {
+			size = sourceSize
+		}
+		claim := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: m.Namespace,
+				Labels:    ipfsLabels(m, componentPeer),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: storageClassFor(m, ordinal),
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+				},
+				DataSource: &corev1.TypedLocalObjectReference{
+					Kind: "PersistentVolumeClaim",
+					Name: source.Name,
+				},
+			},
+		}
+		if err := r.Create(ctx, claim); err != nil {
+			return fmt.Errorf("cannot clone seed claim %s: %w", source.Name, err)
+		}
+		r.eventf(m, corev1.EventTypeNormal, "Seeding", "cloning seed claim %s into %s", source.Name, claim.Name)
+	}
+	return nil
+}
+
+// syncSeeded Records whether the repo of the peer went through seeding, as
+// reported by the seed container of its pod, and emits events as seeding
+// progresses.
+func (r *IpfsReconciler) syncSeeded(m *clusterv1alpha1.Ipfs, pod *corev1.Pod, peer *clusterv1alpha1.PeerStatus) {
+	if peer.Seeded {
+		return
+	}
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if cs.Name != seedContainerName {
+			continue
+		}
+		switch {
+		case cs.State.Terminated != nil && cs.State.Terminated.ExitCode == 0:
+			peer.Seeded = true
+			r.eventf(m, corev1.EventTypeNormal, "Seeded", "seeded the repo of peer %s", pod.Name)
+		case cs.State.Running != nil:
+			r.eventf(m, corev1.EventTypeNormal, "Seeding", "seeding the repo of peer %s", pod.Name)
+		case cs.LastTerminationState.Terminated != nil && cs.LastTerminationState.Terminated.ExitCode != 0:
+			r.eventf(m, corev1.EventTypeWarning, "SeedFailed", "cannot seed the repo of peer %s: %s",
+				pod.Name, cs.LastTerminationState.Terminated.Message)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs repo seeding", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	claim := func(name, size string) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{}
+		pvc.Name = name
+		pvc.Namespace = "default"
+		pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)}
+		return pvc
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "seeded"
+		instance.Namespace = "default"
+		instance.Spec.Replicas = 3
+		instance.Spec.IpfsStorage = "10Gi"
+		instance.Spec.IpfsImage = ipfsImage
+		instance.Spec.Seed = &clusterv1alpha1.SeedConfig{ExistingClaimName: "legacy"}
+		fakeClient = fake.NewClientBuilder().WithObjects(
+			claim("legacy-0", "2Ti"),
+			claim("legacy-1", "1Gi"),
+			claim("ipfs-storage-ipfs-cluster-seeded-1", "10Gi"),
+		).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{Client: fakeClient, Recorder: recorder}
+	})
+
+	It("clones the seed claim of the peers without a volume", func() {
+		Expect(reconciler.seedClaims(ctx, instance)).To(Succeed())
+
+		pvc := &corev1.PersistentVolumeClaim{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: "default",
+			Name:      "ipfs-storage-ipfs-cluster-seeded-0",
+		}, pvc)).To(Succeed())
+		Expect(pvc.Spec.DataSource).To(Equal(&corev1.TypedLocalObjectReference{
+			Kind: "PersistentVolumeClaim",
+			Name: "legacy-0",
+		}))
+		size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		Expect(size.String()).To(Equal("2Ti"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("legacy-0")))
+
+		By("leaving existing volumes and peers without a seed claim alone")
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: "default",
+			Name:      "ipfs-storage-ipfs-cluster-seeded-1",
+		}, pvc)).To(Succeed())
+		Expect(pvc.Spec.DataSource).To(BeNil())
+		err := fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: "default",
+			Name:      "ipfs-storage-ipfs-cluster-seeded-2",
+		}, pvc)
+		Expect(err).To(HaveOccurred())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("copies seed images in the init container", func() {
+		instance.Spec.Seed = &clusterv1alpha1.SeedConfig{Image: "registry.example.com/repo-snapshot:1"}
+		container := seedContainer(instance)
+		Expect(container.Image).To(Equal("registry.example.com/repo-snapshot:1"))
+		Expect(container.Env).To(ConsistOf(corev1.EnvVar{Name: "SEED_PATH", Value: "/repo"}))
+		Expect(reconciler.seedClaims(ctx, instance)).To(Succeed())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("flags the peers once their seed container completed", func() {
+		pod := &corev1.Pod{}
+		pod.Name = "ipfs-cluster-seeded-0"
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+			Name:  seedContainerName,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		}}
+		peer := &clusterv1alpha1.PeerStatus{Name: pod.Name}
+		reconciler.syncSeeded(instance, pod, peer)
+		Expect(peer.Seeded).To(BeFalse())
+		Expect(recorder.Events).To(Receive(ContainSubstring("Seeding")))
+
+		pod.Status.InitContainerStatuses[0].State = corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 0},
+		}
+		reconciler.syncSeeded(instance, pod, peer)
+		Expect(peer.Seeded).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("Seeded")))
+
+		reconciler.syncSeeded(instance, pod, peer)
+		Expect(recorder.Events).NotTo(Receive())
+	})
+})
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs StatefulSet selector", func() {
+	var (
+		ctx        context.Context
+		scheme     *runtime.Scheme
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		stsKey     types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	statefulSet := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, stsKey, sts)).To(Succeed())
+		return sts
+	}
+
+	// start Creates the cluster along with a StatefulSet an older operator
+	// created with the given selector.
+	start := func(selector map[string]string) {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		old := &appsv1.StatefulSet{}
+		old.Name = stsKey.Name
+		old.Namespace = stsKey.Namespace
+		old.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+		old.Spec.Template.Labels = selector
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, old).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+		// The first reconcile only adds the finalizer.
+		reconcile()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "selector"}
+		stsKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+	})
+
+	It("keeps the selector of an older StatefulSet and labels the pods with it", func() {
+		start(map[string]string{"app": stsKey.Name})
+
+		instance := reconcile()
+		sts := statefulSet()
+		Expect(sts.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": stsKey.Name}))
+		Expect(sts.Spec.Template.Labels).To(HaveKeyWithValue("app", stsKey.Name))
+		Expect(sts.Spec.Template.Labels).To(HaveKeyWithValue(labelName, stsKey.Name))
+		Expect(sts.Spec.Template.Labels).To(HaveKeyWithValue(labelComponent, componentPeer))
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionSelectorOutdated)).To(BeNil())
+	})
+
+	It("recreates a StatefulSet with a conflicting selector only once asked to", func() {
+		outdated := map[string]string{labelName: stsKey.Name, labelComponent: "ipfs"}
+		start(outdated)
+		pod := &corev1.Pod{}
+		pod.Name = stsKey.Name + "-0"
+		pod.Namespace = key.Namespace
+		pod.Labels = map[string]string{labelName: stsKey.Name, labelComponent: "ipfs"}
+		Expect(fakeClient.Create(ctx, pod)).To(Succeed())
+
+		instance := reconcile()
+		Expect(statefulSet().Spec.Selector.MatchLabels).To(Equal(outdated))
+		Expect(statefulSet().Spec.Template.Labels).To(HaveKeyWithValue(labelComponent, "ipfs"))
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions,
+			clusterv1alpha1.ConditionSelectorOutdated)).To(BeTrue())
+
+		By("recreating it behind the annotation")
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationRecreateStatefulSet: "true"}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(instance.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRecreateStatefulSet))
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, stsKey, &appsv1.StatefulSet{}))).To(BeTrue())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+		Expect(pod.Labels).To(HaveKeyWithValue(labelComponent, componentPeer))
+
+		instance = reconcile()
+		Expect(statefulSet().Spec.Selector.MatchLabels).To(Equal(peerSelector(instance)))
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionSelectorOutdated)).To(BeNil())
+	})
+
+	It("finds the labels conflicting with the selector", func() {
+		labels := map[string]string{labelName: "ipfs-cluster-a", labelComponent: componentPeer}
+		Expect(selectorConflicts(&metav1.LabelSelector{MatchLabels: map[string]string{
+			labelName: "ipfs-cluster-a", "app": "a",
+		}}, labels)).To(BeEmpty())
+		Expect(selectorConflicts(&metav1.LabelSelector{MatchLabels: map[string]string{
+			labelName: "ipfs-cluster-a", labelComponent: "ipfs",
+		}}, labels)).To(Equal([]string{labelComponent}))
+	})
+})
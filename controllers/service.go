@@ -1,25 +1,148 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
 )
 
-func (r *IpfsReconciler) serviceCluster(
+// legacyServiceName Returns the name of the Service older operators exposed
+// every port of the peers through, and governed the StatefulSet with.
+func legacyServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name
+}
+
+// swarmServiceName Returns the name of the headless Service governing the
+// StatefulSet of the peers, through which they discover each other.
+func swarmServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-swarm"
+}
+
+// apiServiceName Returns the name of the Service of the REST API of the
+// cluster and of the RPC API of the kubo nodes.
+func apiServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-api"
+}
+
+// gwServiceName Returns the name of the Service of the gateway of the peers,
+// which the Ingress of the gateway targets.
+func gwServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-gw"
+}
+
+// peerServiceName Returns the name of the Service governing the StatefulSet
+// of the peers, under which their pods have DNS names. StatefulSets created
+// by older operators are governed by the legacy Service until they are
+// recreated.
+func peerServiceName(m *clusterv1alpha1.Ipfs) string {
+	if m.Status.PeerService != "" {
+		return m.Status.PeerService
+	}
+	return legacyServiceName(m)
+}
+
+// legacyServiceKept Returns whether the legacy Service is kept, which it is
+// for as long as the pods have their DNS names under it.
+func legacyServiceKept(m *clusterv1alpha1.Ipfs) bool {
+	legacy := m.Spec.Expose.LegacyService
+	return legacy == nil || *legacy || peerServiceName(m) == legacyServiceName(m)
+}
+
+// livePeerService Returns the Service governing the live StatefulSet of the
+// peers, or the swarm Service a new StatefulSet is created with, along with
+// the live StatefulSet if any.
+func (r *IpfsReconciler) livePeerService(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) (string, *appsv1.StatefulSet, error) {
+	sts := appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}
+	if err := r.Get(ctx, key, &sts); err != nil {
+		return swarmServiceName(m), nil, client.IgnoreNotFound(err)
+	}
+	if sts.Spec.ServiceName == "" {
+		return swarmServiceName(m), &sts, nil
+	}
+	return sts.Spec.ServiceName, &sts, nil
+}
+
+// recreateStatefulSetOnServiceChange Records the Service governing the
+// StatefulSet of the peers, and sets the PeerServiceOutdated condition while
+// it is not the swarm Service, as for StatefulSets created by older
+// operators. The Service of a StatefulSet is immutable, so the StatefulSet
+// keeps it until the recreate-statefulset annotation is set, which deletes
+// the StatefulSet without its pods. The StatefulSet created in its place
+// adopts them, then rolls them onto the swarm Service. It returns whether
+// the StatefulSet is going away, in which case it must not be patched.
+func (r *IpfsReconciler) recreateStatefulSetOnServiceChange(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	m *clusterv1alpha1.Ipfs,
+) (bool, error) {
+	service, sts, err := r.livePeerService(ctx, m)
+	if err != nil {
+		return false, err
+	}
+	if sts != nil && sts.DeletionTimestamp != nil {
+		return true, nil
+	}
+	instance.Status.PeerService = service
+	m.Status.PeerService = service
+	if service == swarmServiceName(m) {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionPeerServiceOutdated)
+		return false, nil
+	}
+	if instance.Annotations[clusterv1alpha1.AnnotationRecreateStatefulSet] != "true" {
+		message := fmt.Sprintf("StatefulSet %s is governed by the Service %s rather than %s. "+
+			"Set the %s annotation to \"true\" to recreate it.", sts.Name, service, swarmServiceName(m),
+			clusterv1alpha1.AnnotationRecreateStatefulSet)
+		if !meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionPeerServiceOutdated) {
+			r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.ConditionPeerServiceOutdated, "%s", message)
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               clusterv1alpha1.ConditionPeerServiceOutdated,
+			Status:             metav1.ConditionTrue,
+			Reason:             clusterv1alpha1.PeerServiceOutdatedReasonLegacyService,
+			Message:            message,
+			ObservedGeneration: instance.Generation,
+		})
+		return false, nil
+	}
+
+	ctrllog.FromContext(ctx).Info("recreating statefulset to change its service",
+		"statefulset", sts.Name, "from", service, "to", swarmServiceName(m))
+	err = r.Delete(ctx, sts, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	delete(instance.Annotations, clusterv1alpha1.AnnotationRecreateStatefulSet)
+	meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionPeerServiceOutdated)
+	return true, r.Patch(ctx, instance, patch)
+}
+
+// serviceLegacy Returns a mutate function for the legacy Service, which keeps
+// every port it exposed for the clients still using its name.
+func (r *IpfsReconciler) serviceLegacy(
 	m *clusterv1alpha1.Ipfs,
 	svc *corev1.Service,
 ) (controllerutil.MutateFn, string) {
-	svcName := "ipfs-cluster-" + m.Name
+	svcName := legacyServiceName(m)
 	expected := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      svcName,
 			Namespace: m.Namespace,
-			// TODO: annotations for external dns
 		},
 		Spec: corev1.ServiceSpec{
 			Ports: []corev1.ServicePort{
@@ -32,13 +155,13 @@ func (r *IpfsReconciler) serviceCluster(
 				{
 					Name:       "swarm-udp",
 					Protocol:   corev1.ProtocolUDP,
-					Port:       portSwarmUDP,
+					Port:       webTransportPort(m),
 					TargetPort: intstr.FromString("swarm-udp"),
 				},
 				{
 					Name:       "ws",
 					Protocol:   corev1.ProtocolTCP,
-					Port:       portWS,
+					Port:       webSocketPort(m),
 					TargetPort: intstr.FromString("ws"),
 				},
 				{
@@ -66,18 +189,204 @@ func (r *IpfsReconciler) serviceCluster(
 					TargetPort: intstr.FromString("cluster-swarm"),
 				},
 			},
-			Selector: map[string]string{
-				"app.kubernetes.io/name": "ipfs-cluster-" + m.Name,
+			Selector: peerSelector(m),
+		},
+	}
+	if m.Spec.Expose.Auth != nil {
+		expected.Spec.Ports = append(expected.Spec.Ports, corev1.ServicePort{
+			Name:       authProxyPortName,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       portAuthProxy,
+			TargetPort: intstr.FromString(authProxyPortName),
+		})
+	}
+	return r.serviceMutate(m, svc, expected), svcName
+}
+
+// serviceSwarm Returns a mutate function for the headless Service governing
+// the StatefulSet of the peers. It lists the peers before they are ready, as
+// they only turn ready once they joined each other.
+func (r *IpfsReconciler) serviceSwarm(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) (controllerutil.MutateFn, string) {
+	svcName := swarmServiceName(m)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			PublishNotReadyAddresses: true,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "swarm",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portSwarm,
+					TargetPort: intstr.FromString("swarm"),
+				},
+				{
+					Name:       "swarm-udp",
+					Protocol:   corev1.ProtocolUDP,
+					Port:       webTransportPort(m),
+					TargetPort: intstr.FromString("swarm-udp"),
+				},
+				{
+					Name:       "ws",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       webSocketPort(m),
+					TargetPort: intstr.FromString("ws"),
+				},
+				{
+					Name:       "cluster-swarm",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portClusterSwarm,
+					TargetPort: intstr.FromString("cluster-swarm"),
+				},
 			},
+			Selector: peerSelector(m),
 		},
 	}
+	return r.serviceMutate(m, svc, expected), svcName
+}
+
+// serviceAPI Returns a mutate function for the Service of the REST API of the
+// cluster, of its IPFS proxy and of the RPC API of the kubo nodes, which is
+// never exposed outside of Kubernetes.
+func (r *IpfsReconciler) serviceAPI(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) (controllerutil.MutateFn, string) {
+	svcName := apiServiceName(m)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "api",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portAPI,
+					TargetPort: intstr.FromString("api"),
+				},
+				{
+					Name:       "api-http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portAPIHTTP,
+					TargetPort: intstr.FromString("api-http"),
+				},
+				{
+					Name:       "proxy-http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portProxyHTTP,
+					TargetPort: intstr.FromString("proxy-http"),
+				},
+			},
+			Selector: peerSelector(m),
+		},
+	}
+	return r.serviceMutate(m, svc, expected), svcName
+}
+
+// serviceGatewayCluster Returns a mutate function for the Service of the
+// gateway of the peers, through the authenticating proxy when auth is
+// requested, so that exposing it never bypasses the proxy.
+func (r *IpfsReconciler) serviceGatewayCluster(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) (controllerutil.MutateFn, string) {
+	svcName := gwServiceName(m)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portHTTP,
+					TargetPort: intstr.FromString(gatewayPortName(m)),
+				},
+			},
+			Selector: peerSelector(m),
+		},
+	}
+	return r.serviceMutate(m, svc, expected), svcName
+}
+
+// serviceMutate Returns a mutate function setting the spec of a Service of
+// the peers to the expected one, unless it is unchanged.
+func (r *IpfsReconciler) serviceMutate(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+	expected *corev1.Service,
+) controllerutil.MutateFn {
 	expected.DeepCopyInto(svc)
 	// FIXME: catch this error before we run the function being returned
 	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
-		return func() error { return err }, ""
+		return func() error { return err }
 	}
 	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
 		svc.Spec = expected.Spec
 		return nil
+	}
+}
+
+// serviceGateway Returns a mutate function for the LoadBalancer Service which
+// exposes the IPFS gateway of a public cluster, through the authenticating
+// proxy when auth is requested.
+func (r *IpfsReconciler) serviceGateway(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+) (controllerutil.MutateFn, string) {
+	svcName := gatewayServiceName(m)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "http",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       portHTTP,
+					TargetPort: intstr.FromString(gatewayPortName(m)),
+				},
+			},
+			Selector: peerSelector(m),
+		},
+	}
+	expected.DeepCopyInto(svc)
+	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil {
+			return err
+		}
+		if !unchanged {
+			svc.Spec.Type = expected.Spec.Type
+			svc.Spec.Ports = expected.Spec.Ports
+			svc.Spec.Selector = expected.Spec.Selector
+		}
+		applyDNSAnnotations(m, svc, true)
+		return nil
 	}, svcName
 }
+
+// gatewayServiceName Returns the name of the Service exposing the gateway of a public cluster.
+func gatewayServiceName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-gateway-" + m.Name
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs Services", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		instance   *clusterv1alpha1.Ipfs
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		reconciled := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, reconciled)).To(Succeed())
+		return reconciled
+	}
+
+	service := func(name string) *corev1.Service {
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: name}, svc)).To(Succeed())
+		return svc
+	}
+
+	portNames := func(svc *corev1.Service) []string {
+		names := make([]string, 0, len(svc.Spec.Ports))
+		for _, p := range svc.Spec.Ports {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	statefulSet := func() *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name},
+			sts)).To(Succeed())
+		return sts
+	}
+
+	// start Creates the cluster without the legacy Service, along with the
+	// given objects, and adds the finalizer.
+	start := func(objs ...client.Object) {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, instance)...).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+		reconcile()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "split"}
+		legacy := false
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.Expose.LegacyService = &legacy
+	})
+
+	It("governs the peers of a new cluster through the swarm Service", func() {
+		start()
+		reconciled := reconcile()
+		Expect(reconciled.Status.PeerService).To(Equal("ipfs-cluster-split-swarm"))
+		Expect(statefulSet().Spec.ServiceName).To(Equal("ipfs-cluster-split-swarm"))
+
+		swarm := service("ipfs-cluster-split-swarm")
+		Expect(swarm.Spec.ClusterIP).To(Equal(corev1.ClusterIPNone))
+		Expect(swarm.Spec.PublishNotReadyAddresses).To(BeTrue())
+		Expect(portNames(swarm)).To(Equal([]string{"swarm", "swarm-udp", "ws", "cluster-swarm"}))
+		Expect(portNames(service("ipfs-cluster-split-api"))).To(Equal([]string{"api", "api-http", "proxy-http"}))
+		gw := service("ipfs-cluster-split-gw")
+		Expect(portNames(gw)).To(Equal([]string{"http"}))
+		Expect(gw.Labels).To(HaveKeyWithValue(labelComponent, componentGateway))
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-split"}, &corev1.Service{}))).To(BeTrue())
+
+		By("keeping the legacy Service as an alias unless switched off")
+		reconciled.Spec.Expose.LegacyService = nil
+		Expect(fakeClient.Update(ctx, reconciled)).To(Succeed())
+		reconcile()
+		Expect(portNames(service("ipfs-cluster-split"))).To(ContainElements("swarm", "http", "api-http"))
+	})
+
+	It("moves an older StatefulSet to the swarm Service only once asked to", func() {
+		old := &appsv1.StatefulSet{}
+		old.Name = "ipfs-cluster-" + key.Name
+		old.Namespace = key.Namespace
+		old.Spec.ServiceName = "ipfs-cluster-split"
+		old.Spec.Selector = &metav1.LabelSelector{MatchLabels: peerSelector(instance)}
+		old.Spec.Template.Labels = peerSelector(instance)
+		start(old)
+
+		reconciled := reconcile()
+		Expect(reconciled.Status.PeerService).To(Equal("ipfs-cluster-split"))
+		Expect(meta.IsStatusConditionTrue(reconciled.Status.Conditions,
+			clusterv1alpha1.ConditionPeerServiceOutdated)).To(BeTrue())
+		sts := statefulSet()
+		Expect(sts.Spec.ServiceName).To(Equal("ipfs-cluster-split"))
+		Expect(sts.Spec.Template.Spec.Containers[1].Env).To(ContainElement(
+			corev1.EnvVar{Name: "SVC_NAME", Value: "ipfs-cluster-split"}))
+		Expect(meshAddress(reconciled, meshMember{pod: "ipfs-cluster-split-0", id: "12D3KooWA"})).To(Equal(
+			"/dns4/ipfs-cluster-split-0.ipfs-cluster-split.default.svc/tcp/4001/p2p/12D3KooWA"))
+		By("keeping the legacy Service the pods are named under")
+		service("ipfs-cluster-split")
+		service("ipfs-cluster-split-swarm")
+
+		By("recreating the StatefulSet behind the annotation")
+		reconciled.Annotations = map[string]string{clusterv1alpha1.AnnotationRecreateStatefulSet: "true"}
+		Expect(fakeClient.Update(ctx, reconciled)).To(Succeed())
+		reconciled = reconcile()
+		Expect(reconciled.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRecreateStatefulSet))
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, client.ObjectKeyFromObject(old),
+			&appsv1.StatefulSet{}))).To(BeTrue())
+
+		reconciled = reconcile()
+		Expect(statefulSet().Spec.ServiceName).To(Equal("ipfs-cluster-split-swarm"))
+		Expect(meta.FindStatusCondition(reconciled.Status.Conditions,
+			clusterv1alpha1.ConditionPeerServiceOutdated)).To(BeNil())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-split"}, &corev1.Service{}))).To(BeTrue())
+	})
+})
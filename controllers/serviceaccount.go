@@ -1,18 +1,44 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
+
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// serviceAccountCreated Returns whether the operator creates the
+// ServiceAccount of the peers.
+func serviceAccountCreated(m *clusterv1alpha1.Ipfs) bool {
+	sa := m.Spec.ServiceAccount
+	return sa == nil || sa.Create == nil || *sa.Create
+}
+
+// serviceAccountName Returns the name of the ServiceAccount the peers run as.
+func serviceAccountName(m *clusterv1alpha1.Ipfs) string {
+	if sa := m.Spec.ServiceAccount; sa != nil && sa.Name != "" {
+		return sa.Name
+	}
+	return "ipfs-cluster-" + m.Name
+}
+
+// peerRoleName Returns the name of the Role and the RoleBinding granting the
+// ServiceAccount of the peers access to the objects of the cluster.
+func peerRoleName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-cluster-" + m.Name + "-peer"
+}
+
 func (r *IpfsReconciler) serviceAccount(m *clusterv1alpha1.Ipfs, sa *corev1.ServiceAccount) controllerutil.MutateFn {
 	// Define a new Service Account object
 	expected := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "ipfs-cluster-" + m.Name,
+			Name:      serviceAccountName(m),
 			Namespace: m.Namespace,
 		},
 	}
@@ -25,3 +51,129 @@ func (r *IpfsReconciler) serviceAccount(m *clusterv1alpha1.Ipfs, sa *corev1.Serv
 		return nil
 	}
 }
+
+// peerRules Returns the rules of the Role of the peers. Each rule names the
+// objects of the cluster it applies to, so that the Role grants nothing
+// beyond them, and only rules with objects are returned.
+func peerRules(m *clusterv1alpha1.Ipfs) []rbacv1.PolicyRule {
+	access := m.Spec.ServiceAccount.RBAC
+	var rules []rbacv1.PolicyRule
+	if access.Pods {
+		var pods []string
+		for ordinal := int32(0); ordinal < peerCount(m); ordinal++ {
+			pods = append(pods, peerPodName(m, ordinal))
+		}
+		if len(pods) > 0 {
+			rules = append(rules, rbacv1.PolicyRule{
+				APIGroups:     []string{""},
+				Resources:     []string{"pods"},
+				ResourceNames: pods,
+				Verbs:         []string{"get", "patch"},
+			})
+		}
+	}
+	if access.ConfigMaps {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{"ipfs-cluster-" + m.Name, "ipfs-cluster-scripts-" + m.Name},
+			Verbs:         []string{"get"},
+		})
+	}
+	if access.Secrets {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			ResourceNames: []string{identitiesSecretName(m), clusterSecretName(m)},
+			Verbs:         []string{"get"},
+		})
+	}
+	return rules
+}
+
+// peerRBACEnabled Returns whether the ServiceAccount of the peers is granted
+// access to some objects of the cluster.
+func peerRBACEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.ServiceAccount != nil && m.Spec.ServiceAccount.RBAC != nil && len(peerRules(m)) > 0
+}
+
+// peerRole Returns a mutate function keeping the Role of the peers in sync
+// with the access selected by the spec.
+func (r *IpfsReconciler) peerRole(m *clusterv1alpha1.Ipfs, role *rbacv1.Role) controllerutil.MutateFn {
+	role.Name = peerRoleName(m)
+	role.Namespace = m.Namespace
+	return func() error {
+		role.Rules = peerRules(m)
+		return ctrl.SetControllerReference(m, role, r.Scheme)
+	}
+}
+
+// peerRoleBinding Returns a mutate function binding the Role of the peers to
+// their ServiceAccount, in the namespace of the cluster.
+func (r *IpfsReconciler) peerRoleBinding(m *clusterv1alpha1.Ipfs, rb *rbacv1.RoleBinding) controllerutil.MutateFn {
+	rb.Name = peerRoleName(m)
+	rb.Namespace = m.Namespace
+	return func() error {
+		// The role of an existing RoleBinding is immutable, and never changes.
+		rb.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     peerRoleName(m),
+		}
+		rb.Subjects = []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccountName(m),
+			Namespace: m.Namespace,
+		}}
+		return ctrl.SetControllerReference(m, rb, r.Scheme)
+	}
+}
+
+// cleanupServiceAccount Deletes the Role and the RoleBinding of the peers
+// once they are granted nothing, and lets go of the default ServiceAccount
+// the operator created once the peers run as another one. It is deleted,
+// unless the peers still run as it and the operator no longer manages it, in
+// which case it is only released so that it outlives the cluster.
+func (r *IpfsReconciler) cleanupServiceAccount(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	if !peerRBACEnabled(m) {
+		key := client.ObjectKey{Namespace: m.Namespace, Name: peerRoleName(m)}
+		for _, obj := range []client.Object{&rbacv1.RoleBinding{}, &rbacv1.Role{}} {
+			if err := r.Get(ctx, key, obj); err != nil {
+				if client.IgnoreNotFound(err) != nil {
+					return fmt.Errorf("cannot get peer rbac: %w", err)
+				}
+				continue
+			}
+			if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("cannot delete peer rbac: %w", err)
+			}
+		}
+	}
+	sa := corev1.ServiceAccount{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}
+	if err := r.Get(ctx, key, &sa); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	switch {
+	case !metav1.IsControlledBy(&sa, m):
+		return nil
+	case sa.Name != serviceAccountName(m):
+		if err := r.Delete(ctx, &sa); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete service account: %w", err)
+		}
+		return nil
+	case serviceAccountCreated(m):
+		return nil
+	}
+	var refs []metav1.OwnerReference
+	for _, ref := range sa.OwnerReferences {
+		if ref.UID != m.UID {
+			refs = append(refs, ref)
+		}
+	}
+	sa.OwnerReferences = refs
+	if err := r.Update(ctx, &sa); err != nil {
+		return fmt.Errorf("cannot release service account: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs service account", func() {
+	var (
+		ctx        context.Context
+		scheme     *runtime.Scheme
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	update := func(mutate func(*clusterv1alpha1.Ipfs)) {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		mutate(instance)
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+	}
+
+	roleKey := func() types.NamespacedName {
+		return types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name + "-peer"}
+	}
+
+	serviceAccountName := func() string {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name},
+			sts)).To(Succeed())
+		return sts.Spec.Template.Spec.ServiceAccountName
+	}
+
+	start := func(objs ...client.Object) {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.UID = "5f0c2b1e-automation"
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(objs, instance)...).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "team-a", Name: "automation"}
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+	})
+
+	It("grants the peers access to the objects of their cluster only", func() {
+		start()
+		Expect(serviceAccountName()).To(Equal("ipfs-cluster-automation"))
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, roleKey(), &rbacv1.Role{}))).To(BeTrue())
+
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Spec.ServiceAccount = &clusterv1alpha1.ServiceAccountConfig{
+				RBAC: &clusterv1alpha1.ServiceAccountRBAC{Pods: true, ConfigMaps: true, Secrets: true},
+			}
+		})
+		reconcile()
+		role := &rbacv1.Role{}
+		Expect(fakeClient.Get(ctx, roleKey(), role)).To(Succeed())
+		Expect(role.Rules).To(ConsistOf(
+			rbacv1.PolicyRule{
+				APIGroups:     []string{""},
+				Resources:     []string{"pods"},
+				ResourceNames: []string{"ipfs-cluster-automation-0", "ipfs-cluster-automation-1"},
+				Verbs:         []string{"get", "patch"},
+			},
+			rbacv1.PolicyRule{
+				APIGroups:     []string{""},
+				Resources:     []string{"configmaps"},
+				ResourceNames: []string{"ipfs-cluster-automation", "ipfs-cluster-scripts-automation"},
+				Verbs:         []string{"get"},
+			},
+			rbacv1.PolicyRule{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				ResourceNames: []string{"ipfs-cluster-automation-identities", "ipfs-cluster-automation-cluster-secret"},
+				Verbs:         []string{"get"},
+			},
+		))
+
+		By("never granting anything beyond the named objects of the namespace")
+		for _, rule := range role.Rules {
+			Expect(rule.ResourceNames).NotTo(BeEmpty())
+			Expect(rule.NonResourceURLs).To(BeEmpty())
+			Expect(rule.Verbs).NotTo(ContainElement(rbacv1.VerbAll))
+			Expect(rule.Resources).NotTo(ContainElement(rbacv1.ResourceAll))
+			Expect(rule.APIGroups).To(Equal([]string{""}))
+		}
+		rb := &rbacv1.RoleBinding{}
+		Expect(fakeClient.Get(ctx, roleKey(), rb)).To(Succeed())
+		Expect(rb.RoleRef).To(Equal(rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName, Kind: "Role", Name: "ipfs-cluster-automation-peer",
+		}))
+		Expect(rb.Subjects).To(Equal([]rbacv1.Subject{{
+			Kind: rbacv1.ServiceAccountKind, Name: "ipfs-cluster-automation", Namespace: "team-a",
+		}}))
+		clusterRoles := &rbacv1.ClusterRoleList{}
+		Expect(fakeClient.List(ctx, clusterRoles)).To(Succeed())
+		Expect(clusterRoles.Items).To(BeEmpty())
+		clusterBindings := &rbacv1.ClusterRoleBindingList{}
+		Expect(fakeClient.List(ctx, clusterBindings)).To(Succeed())
+		Expect(clusterBindings.Items).To(BeEmpty())
+
+		By("following the peers as the cluster scales")
+		update(func(instance *clusterv1alpha1.Ipfs) { instance.Spec.Replicas = 3 })
+		reconcile()
+		Expect(fakeClient.Get(ctx, roleKey(), role)).To(Succeed())
+		Expect(role.Rules[0].ResourceNames).To(ContainElement("ipfs-cluster-automation-2"))
+
+		By("deleting the Role once the peers are granted nothing")
+		update(func(instance *clusterv1alpha1.Ipfs) { instance.Spec.ServiceAccount.RBAC = nil })
+		reconcile()
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, roleKey(), &rbacv1.Role{}))).To(BeTrue())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, roleKey(), &rbacv1.RoleBinding{}))).To(BeTrue())
+	})
+
+	It("runs the peers as an existing ServiceAccount without managing it", func() {
+		create := false
+		external := &corev1.ServiceAccount{}
+		external.Name = "ipfs-peers"
+		external.Namespace = key.Namespace
+		external.Annotations = map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::1:role/ipfs"}
+		start(external)
+
+		By("deleting the ServiceAccount the operator created")
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Spec.ServiceAccount = &clusterv1alpha1.ServiceAccountConfig{
+				Create: &create,
+				Name:   "ipfs-peers",
+				RBAC:   &clusterv1alpha1.ServiceAccountRBAC{ConfigMaps: true},
+			}
+		})
+		reconcile()
+		Expect(serviceAccountName()).To(Equal("ipfs-peers"))
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-automation"}, &corev1.ServiceAccount{}))).To(BeTrue())
+		sa := &corev1.ServiceAccount{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(external), sa)).To(Succeed())
+		Expect(sa.OwnerReferences).To(BeEmpty())
+		Expect(sa.Labels).To(BeEmpty())
+		Expect(sa.Annotations).To(Equal(external.Annotations))
+		rb := &rbacv1.RoleBinding{}
+		Expect(fakeClient.Get(ctx, roleKey(), rb)).To(Succeed())
+		Expect(rb.Subjects[0].Name).To(Equal("ipfs-peers"))
+	})
+
+	It("releases its ServiceAccount when it stops managing it", func() {
+		create := false
+		start()
+		update(func(instance *clusterv1alpha1.Ipfs) {
+			instance.Spec.ServiceAccount = &clusterv1alpha1.ServiceAccountConfig{
+				Create: &create,
+				Name:   "ipfs-cluster-automation",
+			}
+		})
+		reconcile()
+		sa := &corev1.ServiceAccount{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-automation"},
+			sa)).To(Succeed())
+		Expect(sa.OwnerReferences).To(BeEmpty())
+		Expect(serviceAccountName()).To(Equal("ipfs-cluster-automation"))
+	})
+})
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+const (
+	// smokeTestPinName Names the payload of the smoke test in the pinset.
+	smokeTestPinName = "ipfs-operator-smoke-test"
+	// smokeTestPinTimeout Bounds how long the allocations of the payload may
+	// take to be pinned.
+	smokeTestPinTimeout = 30 * time.Second
+	// smokeTestPollInterval Is how often the allocations of the payload are
+	// checked while they are being pinned.
+	smokeTestPollInterval = time.Second
+	// smokeTestRetryInterval Is how long after failing the smoke test runs
+	// again for the same revision.
+	smokeTestRetryInterval = 10 * time.Minute
+)
+
+// smokeTestFailure Is the stage of the smoke test which failed and why.
+type smokeTestFailure struct {
+	stage   string
+	message string
+}
+
+// smokeTestEnabled Returns whether the smoke test runs.
+func smokeTestEnabled(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.SmokeTest != nil && m.Spec.SmokeTest.Enabled
+}
+
+// smokeTestPayload Returns the payload of the smoke test. It only depends
+// on the cluster, so that every run pins the same CID and the pinset does
+// not grow.
+func smokeTestPayload(m *clusterv1alpha1.Ipfs) []byte {
+	return []byte(fmt.Sprintf("ipfs-operator smoke test of %s/%s (%s)\n", m.Namespace, m.Name, m.UID))
+}
+
+// smokeTestDue Returns whether the smoke test should run for the revision:
+// on demand, when it never ran for it, or once the retry interval passed
+// since it failed. Otherwise, it returns how long until a failed test runs
+// again, or zero.
+func smokeTestDue(instance *clusterv1alpha1.Ipfs, revision string, now time.Time) (bool, time.Duration) {
+	last := instance.Status.SmokeTest
+	switch {
+	case instance.Annotations[clusterv1alpha1.AnnotationRunSmokeTest] == "true",
+		last == nil || last.Revision != revision:
+		return true, 0
+	case last.Passed:
+		return false, 0
+	}
+	if wait := last.CompletedAt.Add(smokeTestRetryInterval).Sub(now); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// reconcileSmokeTest Runs the smoke test once every peer is ready and runs
+// the latest revision of the StatefulSet, records its outcome in the status
+// and the Degraded condition, and removes the run-smoke-test annotation. It
+// returns how long to wait before a failed test runs again, or zero.
+func (r *IpfsReconciler) reconcileSmokeTest(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+) (time.Duration, error) {
+	if !smokeTestEnabled(instance) {
+		instance.Status.SmokeTest = nil
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionDegraded)
+		return 0, nil
+	}
+	sts := &appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: "ipfs-cluster-" + instance.Name}
+	if err := r.Get(ctx, key, sts); err != nil {
+		if errors.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("cannot get statefulset: %w", err)
+	}
+	if ready, _ := statefulSetReady(sts, runningPeers(instance)); !ready ||
+		statefulSetProgressing(sts, runningPeers(instance)) {
+		return 0, nil
+	}
+	revision := sts.Status.CurrentRevision
+	due, wait := smokeTestDue(instance, revision, time.Now())
+	if !due {
+		return wait, nil
+	}
+
+	started := time.Now()
+	cid, failure := r.runSmokeTest(ctx, instance)
+	result := &clusterv1alpha1.SmokeTestStatus{
+		CID:         cid,
+		Revision:    revision,
+		Passed:      failure == nil,
+		Latency:     &metav1.Duration{Duration: time.Since(started).Round(time.Millisecond)},
+		CompletedAt: metav1.Now(),
+	}
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.DegradedReasonSmokeTestPassed,
+		Message:            fmt.Sprintf("the smoke test passed in %s", result.Latency.Duration),
+		ObservedGeneration: instance.Generation,
+	}
+	if failure != nil {
+		result.FailedStage = failure.stage
+		result.Message = failure.message
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.DegradedReasonSmokeTestFailed
+		cond.Message = fmt.Sprintf("the smoke test failed at the %s stage: %s", failure.stage, failure.message)
+		r.eventf(instance, corev1.EventTypeWarning, "SmokeTestFailed", "%s", cond.Message)
+	} else {
+		r.eventf(instance, corev1.EventTypeNormal, "SmokeTestPassed", "the smoke test of %s passed in %s",
+			cid, result.Latency.Duration)
+	}
+	instance.Status.SmokeTest = result
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+
+	if _, requested := instance.Annotations[clusterv1alpha1.AnnotationRunSmokeTest]; requested {
+		updated := instance.DeepCopy()
+		delete(updated.Annotations, clusterv1alpha1.AnnotationRunSmokeTest)
+		if err := r.Patch(ctx, updated, client.MergeFrom(instance)); err != nil {
+			return 0, fmt.Errorf("cannot remove %s annotation: %w", clusterv1alpha1.AnnotationRunSmokeTest, err)
+		}
+		instance.Annotations = updated.Annotations
+		instance.ResourceVersion = updated.ResourceVersion
+	}
+	if failure != nil {
+		return smokeTestRetryInterval, nil
+	}
+	return 0, nil
+}
+
+// runSmokeTest Adds the payload through the first peer, pins it through the
+// REST API of the cluster, waits for every allocation to be pinned and reads
+// it back through the last peer, which is the first one in clusters of a
+// single peer. It returns the CID of the payload, once added, and the stage
+// which failed, if any.
+func (r *IpfsReconciler) runSmokeTest(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) (string, *smokeTestFailure) {
+	payload := smokeTestPayload(m)
+	source, err := r.kuboAPI(m, 0)
+	if err != nil {
+		return "", &smokeTestFailure{clusterv1alpha1.SmokeTestStageAdd, err.Error()}
+	}
+	cid, err := source.Add(ctx, smokeTestPinName, payload)
+	if err != nil {
+		return "", &smokeTestFailure{clusterv1alpha1.SmokeTestStageAdd, err.Error()}
+	}
+
+	api, err := r.clusterAPI(ctx, m)
+	if err != nil {
+		return cid, &smokeTestFailure{clusterv1alpha1.SmokeTestStagePin, err.Error()}
+	}
+	if _, err = api.Pin(ctx, cid, clusterapi.PinOptions{Name: smokeTestPinName}); err != nil {
+		return cid, &smokeTestFailure{clusterv1alpha1.SmokeTestStagePin, err.Error()}
+	}
+	if failure := waitSmokeTestPinned(ctx, api, cid); failure != nil {
+		return cid, failure
+	}
+
+	reader, err := r.kuboAPI(m, peerCount(m)-1)
+	if err != nil {
+		return cid, &smokeTestFailure{clusterv1alpha1.SmokeTestStageFetch, err.Error()}
+	}
+	data, err := reader.Cat(ctx, cid)
+	switch {
+	case err != nil:
+		return cid, &smokeTestFailure{clusterv1alpha1.SmokeTestStageFetch, err.Error()}
+	case !bytes.Equal(data, payload):
+		return cid, &smokeTestFailure{clusterv1alpha1.SmokeTestStageFetch,
+			fmt.Sprintf("%s returned %d bytes which differ from the payload", peerPodName(m, peerCount(m)-1), len(data))}
+	}
+	return cid, nil
+}
+
+// waitSmokeTestPinned Waits for every allocation of the payload to be
+// pinned, failing at once when a peer reports an error, or once the timeout
+// passed.
+func waitSmokeTestPinned(ctx context.Context, api *clusterapi.Client, cid string) *smokeTestFailure {
+	deadline := time.Now().Add(smokeTestPinTimeout)
+	for {
+		info, err := api.Status(ctx, cid)
+		if err != nil {
+			return &smokeTestFailure{clusterv1alpha1.SmokeTestStageAllocations, err.Error()}
+		}
+		pending, failed := unpinnedAllocations(info)
+		switch {
+		case len(failed) > 0:
+			return &smokeTestFailure{clusterv1alpha1.SmokeTestStageAllocations,
+				"cannot pin on " + strings.Join(failed, ", ")}
+		case len(pending) == 0:
+			return nil
+		case time.Now().After(deadline):
+			return &smokeTestFailure{clusterv1alpha1.SmokeTestStageAllocations,
+				fmt.Sprintf("not pinned within %s on %s", smokeTestPinTimeout, strings.Join(pending, ", "))}
+		}
+		timer := time.NewTimer(smokeTestPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &smokeTestFailure{clusterv1alpha1.SmokeTestStageAllocations, ctx.Err().Error()}
+		case <-timer.C:
+		}
+	}
+}
+
+// unpinnedAllocations Returns the sorted states of the allocated peers
+// which did not pin the item yet, and of those which failed to. A pin
+// without allocations is allocated to every peer.
+func unpinnedAllocations(info *clusterapi.GlobalPinInfo) ([]string, []string) {
+	allocated := make(map[string]bool, len(info.Allocations))
+	for _, id := range info.Allocations {
+		allocated[id] = true
+	}
+	var pending, failed []string
+	for id, state := range info.PeerMap {
+		if len(allocated) > 0 && !allocated[id] {
+			continue
+		}
+		name := state.Peername
+		if name == "" {
+			name = id
+		}
+		switch state.Status {
+		case clusterapi.TrackerStatusPinned:
+		case clusterapi.TrackerStatusPinError, clusterapi.TrackerStatusClusterErr:
+			failed = append(failed, fmt.Sprintf("%s (%s)", name, strings.TrimSpace(state.Error)))
+		default:
+			pending = append(pending, fmt.Sprintf("%s (%s)", name, state.Status))
+		}
+	}
+	sort.Strings(pending)
+	sort.Strings(failed)
+	return pending, failed
+}
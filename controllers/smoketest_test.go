@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	clusterfake "github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
+)
+
+var _ = Describe("Ipfs smoke test", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		recorder   *record.FakeRecorder
+		instance   *clusterv1alpha1.Ipfs
+		cluster    *clusterfake.Cluster
+		nodes      []*kubofake.Node
+	)
+
+	// rollOut Completes a rollout of the StatefulSet to the given revision.
+	rollOut := func(revision string) {
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: "ipfs-cluster-" + instance.Name}, sts)).To(Succeed())
+		sts.Status.CurrentRevision = revision
+		sts.Status.UpdateRevision = revision
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+	}
+
+	run := func() time.Duration {
+		requeue, err := reconciler.reconcileSmokeTest(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		return requeue
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instance = &clusterv1alpha1.Ipfs{}
+		instance.Name = "smoke"
+		instance.Namespace = "default"
+		instance.UID = "6b1f5e0c-5b7e-4a53-9d1e-0f5d9b6c2f11"
+		instance.Spec.Replicas = 2
+		instance.Spec.SmokeTest = &clusterv1alpha1.SmokeTestConfig{Enabled: true}
+
+		cluster = clusterfake.NewCluster(
+			clusterapi.Peer{ID: "12D3KooWClusterA", Peername: peerPodName(instance, 0)},
+			clusterapi.Peer{ID: "12D3KooWClusterB", Peername: peerPodName(instance, 1)},
+		)
+		credentials := &corev1.Secret{}
+		credentials.Name = apiCredentialsName(instance)
+		credentials.Namespace = instance.Namespace
+		credentials.Data = map[string][]byte{
+			clusterapi.UsernameKey: []byte("admin"),
+			clusterapi.PasswordKey: []byte("secret"),
+		}
+		sts := &appsv1.StatefulSet{}
+		sts.Name = "ipfs-cluster-" + instance.Name
+		sts.Namespace = instance.Namespace
+		sts.Status = appsv1.StatefulSetStatus{
+			Replicas:        2,
+			ReadyReplicas:   2,
+			UpdatedReplicas: 2,
+			CurrentRevision: "rev-1",
+			UpdateRevision:  "rev-1",
+		}
+		dialer := kubofake.NewDialer()
+		blocks := map[string][]byte{}
+		nodes = nil
+		for i, id := range []string{"12D3KooWA", "12D3KooWB"} {
+			node := kubofake.NewNode(id)
+			node.Blocks = blocks
+			dialer.Add(instance.Namespace, peerPodName(instance, int32(i)), node)
+			nodes = append(nodes, node)
+		}
+		fakeClient = fake.NewClientBuilder().WithObjects(instance, credentials, sts).Build()
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &IpfsReconciler{
+			Client:   fakeClient,
+			Kubo:     dialer,
+			Recorder: recorder,
+			ClusterAPI: []clusterapi.Option{
+				clusterapi.WithBaseURL(cluster.URL()),
+				clusterapi.WithBackoff(wait.Backoff{Steps: 1}),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("pins the same payload again after each rollout", func() {
+		Expect(run()).To(BeZero())
+		result := instance.Status.SmokeTest
+		Expect(result).NotTo(BeNil())
+		Expect(result.Passed).To(BeTrue())
+		Expect(result.CID).NotTo(BeEmpty())
+		Expect(result.Revision).To(Equal("rev-1"))
+		Expect(result.Latency).NotTo(BeNil())
+		Expect(meta.IsStatusConditionFalse(instance.Status.Conditions, clusterv1alpha1.ConditionDegraded)).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("SmokeTestPassed")))
+		Expect(nodes[0].Calls["add"]).To(Equal(1))
+		Expect(nodes[1].Calls["cat"]).To(Equal(1))
+
+		By("running once for each revision")
+		run()
+		Expect(cluster.Calls(clusterfake.RoutePin)).To(Equal(1))
+
+		rollOut("rev-2")
+		run()
+		Expect(cluster.Calls(clusterfake.RoutePin)).To(Equal(2))
+		Expect(instance.Status.SmokeTest.Revision).To(Equal("rev-2"))
+		Expect(instance.Status.SmokeTest.CID).To(Equal(result.CID))
+		infos, err := clusterapi.New(cluster.URL()).StatusAll(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+	})
+
+	It("names the failing stage in the Degraded condition", func() {
+		nodes[1].Blocks = map[string][]byte{}
+		Expect(run()).To(Equal(smokeTestRetryInterval))
+		Expect(instance.Status.SmokeTest.Passed).To(BeFalse())
+		Expect(instance.Status.SmokeTest.FailedStage).To(Equal(clusterv1alpha1.SmokeTestStageFetch))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDegraded)
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.DegradedReasonSmokeTestFailed))
+		Expect(cond.Message).To(ContainSubstring("failed at the Fetch stage"))
+		Expect(recorder.Events).To(Receive(ContainSubstring("SmokeTestFailed")))
+		Expect(derivePhase(instance, nil)).To(Equal(clusterv1alpha1.PhaseDegraded))
+
+		By("waiting for the retry interval before running again")
+		Expect(run()).To(BeNumerically("~", smokeTestRetryInterval, time.Minute))
+		Expect(nodes[0].Calls["add"]).To(Equal(1))
+
+		By("running on demand")
+		cid := instance.Status.SmokeTest.CID
+		cluster.FailPinning(cid, 1)
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationRunSmokeTest: "true"}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		run()
+		Expect(instance.Status.SmokeTest.FailedStage).To(Equal(clusterv1alpha1.SmokeTestStageAllocations))
+		Expect(instance.Status.SmokeTest.Message).To(ContainSubstring("cannot pin on ipfs-cluster-smoke-0"))
+		updated := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(instance), updated)).To(Succeed())
+		Expect(updated.Annotations).NotTo(HaveKey(clusterv1alpha1.AnnotationRunSmokeTest))
+	})
+
+	It("waits for every peer to be ready", func() {
+		rollOut("rev-1")
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Namespace: instance.Namespace,
+			Name: "ipfs-cluster-" + instance.Name}, sts)).To(Succeed())
+		sts.Status.ReadyReplicas = 1
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+		run()
+		Expect(instance.Status.SmokeTest).To(BeNil())
+		Expect(nodes[0].Calls["add"]).To(BeZero())
+	})
+})
@@ -1,16 +1,22 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
 
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
 )
@@ -31,14 +37,17 @@ const (
 	portSwarmUDP     = 4002
 	portAPI          = 5001
 	portPprof        = 6060
-	portWS           = 8081
+	portClusterPprof = 8888
 	portHTTP         = 8080
+	portAuthProxy    = 4180
 )
 
 // Misclaneous constants.
 const (
 	// notDNSPattern Defines a ReGeX pattern to match non-DNS names.
 	notDNSPattern = "[[:^alnum:]]"
+	// ipfsImage Defines which container image to use when pulling IPFS.
+	ipfsImage = "ipfs/go-ipfs:v0.12.2"
 	// ipfsClusterImage Defines which container image to use when pulling IPFS Cluster.
 	// HACK: break this up so the version is parameterized, and we can inject the image locally.
 	ipfsClusterImage = "ipfs/ipfs-cluster:v1.0.1"
@@ -56,11 +65,43 @@ const (
 func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 	sts *appsv1.StatefulSet,
 	serviceName string,
-	secretName string,
+	identitiesName string,
 	configMapName string,
-	configMapBootstrapScriptName string) controllerutil.MutateFn {
+	configMapBootstrapScriptName string,
+	configHash string) controllerutil.MutateFn {
 	ssName := "ipfs-cluster-" + m.Name
 
+	clusterSecretRef := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{
+			Name: clusterSecretName(m),
+		},
+		Key: clusterSecretKey,
+	}
+	ipfsResources := withEphemeralStorage(m, corev1.ResourceRequirements{
+		Limits:   m.Spec.Resources.Limits,
+		Requests: m.Spec.Resources.Requests,
+	})
+	clusterResources := corev1.ResourceRequirements{}
+	if m.Spec.Resources.Cluster != nil {
+		clusterResources = *m.Spec.Resources.Cluster
+	}
+	clusterResources = withEphemeralStorage(m, clusterResources)
+	podAnnotations := evictionAnnotations(m)
+	if configHash != "" {
+		if podAnnotations == nil {
+			podAnnotations = map[string]string{}
+		}
+		podAnnotations[clusterv1alpha1.AnnotationConfigHash] = configHash
+	}
+	if caps := bandwidthAnnotations(m); caps != nil {
+		if podAnnotations == nil {
+			podAnnotations = map[string]string{}
+		}
+		for k, v := range caps {
+			podAnnotations[k] = v
+		}
+	}
+
 	expected := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      ssName,
@@ -68,23 +109,28 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 		},
 		Spec: appsv1.StatefulSetSpec{
 			Replicas: &m.Spec.Replicas,
+			// The selector of an existing StatefulSet is immutable, so it
+			// keeps matching on the name label only, while the pods carry
+			// every recommended label. The volume claim templates are
+			// immutable too and are left without labels.
 			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app.kubernetes.io/name": ssName,
-				},
+				MatchLabels: peerSelector(m),
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app.kubernetes.io/name": ssName,
-					},
+					Labels:      ipfsLabels(m, componentPeer),
+					Annotations: podAnnotations,
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: ssName,
+					ServiceAccountName: serviceAccountName(m),
+					PriorityClassName:  m.Spec.PriorityClassName,
+					ReadinessGates: []corev1.PodReadinessGate{{
+						ConditionType: clusterv1alpha1.PodConditionClusterMember,
+					}},
 					InitContainers: []corev1.Container{
 						{
 							Name:  "configure-ipfs",
-							Image: "ipfs/go-ipfs:v0.12.2",
+							Image: m.Spec.IpfsImage,
 							Command: []string{
 								"sh",
 								"/custom/configure-ipfs.sh",
@@ -104,8 +150,9 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 					Containers: []corev1.Container{
 						{
 							Name:            "ipfs",
-							Image:           "ipfs/go-ipfs:v0.12.2",
+							Image:           m.Spec.IpfsImage,
 							ImagePullPolicy: corev1.PullIfNotPresent,
+							Args:            ipfsArgs(m),
 							Env: []corev1.EnvVar{
 								{
 									Name:  "IPFS_FD_MAX",
@@ -120,7 +167,7 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 								},
 								{
 									Name:          "swarm-udp",
-									ContainerPort: portSwarmUDP,
+									ContainerPort: webTransportPort(m),
 									Protocol:      corev1.ProtocolUDP,
 								},
 								{
@@ -130,7 +177,7 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 								},
 								{
 									Name:          "ws",
-									ContainerPort: portWS,
+									ContainerPort: webSocketPort(m),
 									Protocol:      corev1.ProtocolTCP,
 								},
 								{
@@ -155,11 +202,11 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 									MountPath: ipfsMountPath,
 								},
 							},
-							Resources: corev1.ResourceRequirements{},
+							Resources: ipfsResources,
 						},
 						{
 							Name:            "ipfs-cluster",
-							Image:           ipfsClusterImage,
+							Image:           m.Spec.ClusterImage,
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Command: []string{
 								"sh",
@@ -182,27 +229,29 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 									ValueFrom: &corev1.EnvVarSource{
 										SecretKeyRef: &corev1.SecretKeySelector{
 											LocalObjectReference: corev1.LocalObjectReference{
-												Name: secretName,
+												Name: identitiesName,
 											},
-											Key: "BOOTSTRAP_PEER_PRIV_KEY",
+											Key: bootstrapPrivateKeyKey,
 										},
 									},
 								},
 								{
 									Name: "CLUSTER_SECRET",
+									ValueFrom: &corev1.EnvVarSource{
+										SecretKeyRef: clusterSecretRef,
+									},
+								},
+								{
+									Name: apiCredentialsEnv,
 									ValueFrom: &corev1.EnvVarSource{
 										SecretKeyRef: &corev1.SecretKeySelector{
 											LocalObjectReference: corev1.LocalObjectReference{
-												Name: secretName,
+												Name: apiCredentialsName(m),
 											},
-											Key: "CLUSTER_SECRET",
+											Key: apiCredentialsEnv,
 										},
 									},
 								},
-								{
-									Name:  "CLUSTER_MONITOR_PING_INTERVAL",
-									Value: "3m",
-								},
 								{
 									Name:  "SVC_NAME",
 									Value: serviceName,
@@ -244,8 +293,13 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 									Name:      "configure-script",
 									MountPath: "custom",
 								},
+								{
+									Name:      "identities",
+									MountPath: "/identities",
+									ReadOnly:  true,
+								},
 							},
-							Resources: corev1.ResourceRequirements{},
+							Resources: clusterResources,
 						},
 					},
 					Volumes: []corev1.Volume{
@@ -259,6 +313,14 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 								},
 							},
 						},
+						{
+							Name: "identities",
+							VolumeSource: corev1.VolumeSource{
+								Secret: &corev1.SecretVolumeSource{
+									SecretName: identitiesName,
+								},
+							},
+						},
 					},
 				},
 			},
@@ -268,6 +330,7 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 						Name: "cluster-storage",
 					},
 					Spec: corev1.PersistentVolumeClaimSpec{
+						StorageClassName: m.Spec.StorageClassName,
 						AccessModes: []corev1.PersistentVolumeAccessMode{
 							corev1.ReadWriteOnce,
 						},
@@ -283,6 +346,7 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 						Name: "ipfs-storage",
 					},
 					Spec: corev1.PersistentVolumeClaimSpec{
+						StorageClassName: m.Spec.StorageClassName,
 						AccessModes: []corev1.PersistentVolumeAccessMode{
 							corev1.ReadWriteOnce,
 						},
@@ -294,20 +358,112 @@ func (r *IpfsReconciler) statefulSet(m *clusterv1alpha1.Ipfs,
 					},
 				},
 			},
-			ServiceName: serviceName,
+			ServiceName:         serviceName,
+			PodManagementPolicy: podManagementPolicy(m),
+			UpdateStrategy:      updateStrategy(m),
+			MinReadySeconds:     minReadySeconds(m),
 		},
 	}
 
+	// Size the Go runtime of the daemons after their limits, letting the
+	// user-provided environment take precedence.
+	ipfsContainer := &expected.Spec.Template.Spec.Containers[0]
+	ipfsContainer.Env = append(ipfsContainer.Env, datastoreEnv(m)...)
+	ipfsContainer.Env = append(ipfsContainer.Env, logEnv(m, "ipfs.log")...)
+	clusterContainer := &expected.Spec.Template.Spec.Containers[1]
+	clusterContainer.Env = append(clusterContainer.Env, clusterConfigEnv(m)...)
+	clusterContainer.Env = append(clusterContainer.Env, bootstrapEnv(m)...)
+	clusterContainer.Env = append(clusterContainer.Env, logEnv(m, "ipfs-cluster.log")...)
+	clusterContainer.Env = append(clusterContainer.Env, profilingEnv(m)...)
+	addProfilingPorts(m, &expected.Spec.Template.Spec)
+	for i := range expected.Spec.Template.Spec.Containers {
+		c := &expected.Spec.Template.Spec.Containers[i]
+		c.Env = mergeEnv(append(c.Env, goRuntimeEnv(c.Resources.Limits)...), m.Spec.Env)
+	}
+
+	// The configure script reads the credentials of the delegated routers.
+	configureContainer := &expected.Spec.Template.Spec.InitContainers[0]
+	configureContainer.Env = append(configureContainer.Env, routingEnv(m)...)
+
+	if m.Spec.Seed != nil {
+		expected.Spec.Template.Spec.InitContainers = append([]corev1.Container{seedContainer(m)},
+			expected.Spec.Template.Spec.InitContainers...)
+	}
+	if r.preflightEnabled(m) {
+		expected.Spec.Template.Spec.InitContainers = append(
+			[]corev1.Container{preflightContainer(m, r.OperatorImage, serviceName)},
+			expected.Spec.Template.Spec.InitContainers...)
+	}
+
+	if r.apiScoped(m) {
+		// The proxy takes over the port of the REST API, which only listens
+		// locally.
+		clusterContainer = &expected.Spec.Template.Spec.Containers[1]
+		ports := clusterContainer.Ports[:0]
+		for _, p := range clusterContainer.Ports {
+			if p.Name != "api-http" {
+				ports = append(ports, p)
+			}
+		}
+		clusterContainer.Ports = ports
+		clusterContainer.Env = append(clusterContainer.Env, corev1.EnvVar{
+			Name:  apiListenEnv,
+			Value: fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", portAPIUpstream),
+		})
+		proxy, volume := apiProxyContainer(m, r.OperatorImage)
+		expected.Spec.Template.Spec.Containers = append(expected.Spec.Template.Spec.Containers, proxy)
+		expected.Spec.Template.Spec.Volumes = append(expected.Spec.Template.Spec.Volumes, volume)
+	}
+
 	// Add a follower container for each follow.
 	follows := followContainers(m)
 	expected.Spec.Template.Spec.Containers = append(expected.Spec.Template.Spec.Containers, follows...)
+	if m.Spec.Expose.Auth != nil {
+		proxy, volumes := authProxyContainer(m)
+		expected.Spec.Template.Spec.Containers = append(expected.Spec.Template.Spec.Containers, proxy)
+		expected.Spec.Template.Spec.Volumes = append(expected.Spec.Template.Spec.Volumes, volumes...)
+	}
+	if loggingMode(m) == clusterv1alpha1.LoggingModeFile {
+		logMount := corev1.VolumeMount{Name: logVolumeName, MountPath: logMountPath}
+		ipfsContainer = &expected.Spec.Template.Spec.Containers[0]
+		ipfsContainer.VolumeMounts = append(ipfsContainer.VolumeMounts, logMount)
+		clusterContainer = &expected.Spec.Template.Spec.Containers[1]
+		clusterContainer.VolumeMounts = append(clusterContainer.VolumeMounts, logMount)
+		expected.Spec.Template.Spec.Containers = append(expected.Spec.Template.Spec.Containers, logRotateContainer(m))
+		expected.Spec.Template.Spec.Volumes = append(expected.Spec.Template.Spec.Volumes, logVolume(m))
+	}
+	applySecurityProfile(m, &expected.Spec.Template.Spec)
 	expected.DeepCopyInto(sts)
 	// FIXME: catch this error before returning a function that just errors
 	if err := ctrl.SetControllerReference(m, sts, r.Scheme); err != nil {
 		return func() error { return err }
 	}
 	return func() error {
-		sts.Spec = expected.Spec
+		// The volume claim templates of an existing StatefulSet are immutable.
+		spec := expected.Spec
+		if !sts.CreationTimestamp.IsZero() {
+			spec.VolumeClaimTemplates = sts.Spec.VolumeClaimTemplates
+		}
+		// So is its selector, which is never regenerated once created: the
+		// pods keep carrying the labels it matches.
+		if sts.ResourceVersion != "" && sts.Spec.Selector != nil {
+			spec.Selector = sts.Spec.Selector
+			spec.Template.Labels = selectedLabels(spec.Template.Labels, sts.Spec.Selector)
+		}
+		template, err := shortHash(spec.Template)
+		if err != nil {
+			return fmt.Errorf("cannot hash the pod template of the peers: %w", err)
+		}
+		unchanged, err := specUnchanged(sts, spec, sts.Spec)
+		if err != nil {
+			return err
+		}
+		if sts.Annotations[templateHashAnnotation] != template {
+			metav1.SetMetaDataAnnotation(&sts.ObjectMeta, templateHashAnnotation, template)
+		}
+		if !unchanged {
+			sts.Spec = spec
+		}
 		return nil
 	}
 }
@@ -323,7 +479,7 @@ func followContainers(m *clusterv1alpha1.Ipfs) []corev1.Container {
 	for _, follow := range m.Spec.Follows {
 		container := corev1.Container{
 			Name:            "ipfs-cluster-follow-" + notdns.ReplaceAllString(strings.ToLower(follow.Name), "-"),
-			Image:           ipfsClusterImage,
+			Image:           m.Spec.ClusterImage,
 			ImagePullPolicy: corev1.PullIfNotPresent,
 			Command: []string{
 				"ipfs-cluster-follow",
@@ -343,3 +499,171 @@ func followContainers(m *clusterv1alpha1.Ipfs) []corev1.Container {
 	}
 	return containers
 }
+
+// podManagementPolicy Returns the pod management policy requested by the spec.
+func podManagementPolicy(m *clusterv1alpha1.Ipfs) appsv1.PodManagementPolicyType {
+	if m.Spec.PodManagementPolicy == "" {
+		return appsv1.OrderedReadyPodManagement
+	}
+	return appsv1.PodManagementPolicyType(m.Spec.PodManagementPolicy)
+}
+
+// updateStrategy Returns the update strategy requested by the spec.
+func updateStrategy(m *clusterv1alpha1.Ipfs) appsv1.StatefulSetUpdateStrategy {
+	strategy := m.Spec.UpdateStrategy
+	if strategy == nil || strategy.Type == "" || strategy.Type == string(appsv1.RollingUpdateStatefulSetStrategyType) {
+		rolling := appsv1.StatefulSetUpdateStrategy{
+			Type: appsv1.RollingUpdateStatefulSetStrategyType,
+		}
+		if strategy != nil && strategy.Partition != nil {
+			rolling.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{
+				Partition: strategy.Partition,
+			}
+		}
+		return rolling
+	}
+	return appsv1.StatefulSetUpdateStrategy{
+		Type: appsv1.StatefulSetUpdateStrategyType(strategy.Type),
+	}
+}
+
+// recreateStatefulSetOnPolicyChange Deletes the StatefulSet of the cluster
+// when its pod management policy, which is immutable, differs from the spec.
+// The pods are orphaned so that they keep running, and are adopted by the
+// StatefulSet created in its place. It returns whether the StatefulSet is
+// gone or going away, in which case it must not be patched.
+func (r *IpfsReconciler) recreateStatefulSetOnPolicyChange(
+	ctx context.Context,
+	m *clusterv1alpha1.Ipfs,
+) (bool, error) {
+	sts := appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}
+	if err := r.Get(ctx, key, &sts); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if sts.DeletionTimestamp != nil {
+		return true, nil
+	}
+	current := sts.Spec.PodManagementPolicy
+	if current == "" {
+		current = appsv1.OrderedReadyPodManagement
+	}
+	if current == podManagementPolicy(m) {
+		return false, nil
+	}
+	ctrllog.FromContext(ctx).Info("recreating statefulset to change its pod management policy",
+		"statefulset", sts.Name, "from", current, "to", podManagementPolicy(m))
+	err := r.Delete(ctx, &sts, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	return true, client.IgnoreNotFound(err)
+}
+
+// selectedLabels Returns a copy of the labels of the pods with the labels the
+// selector matches, which take precedence.
+func selectedLabels(labels map[string]string, selector *metav1.LabelSelector) map[string]string {
+	merged := make(map[string]string, len(labels)+len(selector.MatchLabels))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range selector.MatchLabels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// selectorConflicts Returns the keys of the labels the selector matches with
+// other values than the given labels, sorted. A selector with expressions,
+// which the operator never generates, conflicts as a whole.
+func selectorConflicts(selector *metav1.LabelSelector, labels map[string]string) []string {
+	var conflicts []string
+	for k, v := range selector.MatchLabels {
+		if current, ok := labels[k]; ok && current != v {
+			conflicts = append(conflicts, k)
+		}
+	}
+	for _, expr := range selector.MatchExpressions {
+		conflicts = append(conflicts, expr.Key)
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// recreateStatefulSetOnSelectorChange Sets the SelectorOutdated condition
+// when the selector of the StatefulSet of the cluster conflicts with the
+// labels of its pods, as happens when an operator upgrade changes them. The
+// selector is immutable, so the StatefulSet keeps it until the
+// recreate-statefulset annotation is set, which deletes the StatefulSet
+// without its pods. The pods are first relabeled so that the StatefulSet
+// created in its place adopts them. It returns whether the StatefulSet is
+// going away, in which case it must not be patched.
+func (r *IpfsReconciler) recreateStatefulSetOnSelectorChange(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	m *clusterv1alpha1.Ipfs,
+) (bool, error) {
+	sts := appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: "ipfs-cluster-" + m.Name}
+	err := r.Get(ctx, key, &sts)
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	if sts.DeletionTimestamp != nil {
+		return true, nil
+	}
+	var conflicts []string
+	if err == nil && sts.Spec.Selector != nil {
+		conflicts = selectorConflicts(sts.Spec.Selector, ipfsLabels(m, componentPeer))
+	}
+	if len(conflicts) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionSelectorOutdated)
+		return false, nil
+	}
+	if instance.Annotations[clusterv1alpha1.AnnotationRecreateStatefulSet] != "true" {
+		message := fmt.Sprintf("The selector of StatefulSet %s matches the labels %s with outdated values. "+
+			"Set the %s annotation to \"true\" to recreate it.", sts.Name, strings.Join(conflicts, ", "),
+			clusterv1alpha1.AnnotationRecreateStatefulSet)
+		if !meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionSelectorOutdated) {
+			r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.ConditionSelectorOutdated, "%s", message)
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               clusterv1alpha1.ConditionSelectorOutdated,
+			Status:             metav1.ConditionTrue,
+			Reason:             clusterv1alpha1.SelectorOutdatedReasonConflictingLabels,
+			Message:            message,
+			ObservedGeneration: instance.Generation,
+		})
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse selector of statefulset %s: %w", sts.Name, err)
+	}
+	pods := corev1.PodList{}
+	if err = r.List(ctx, &pods, client.InNamespace(m.Namespace),
+		client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return false, fmt.Errorf("cannot list pods of statefulset %s: %w", sts.Name, err)
+	}
+	labels := ipfsLabels(m, componentPeer)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		patch := client.MergeFrom(pod.DeepCopy())
+		for _, k := range conflicts {
+			if v, ok := labels[k]; ok {
+				pod.Labels[k] = v
+			}
+		}
+		if err = r.Patch(ctx, pod, patch); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("cannot relabel pod %s: %w", pod.Name, err)
+		}
+	}
+	ctrllog.FromContext(ctx).Info("recreating statefulset to change its selector",
+		"statefulset", sts.Name, "labels", conflicts)
+	err = r.Delete(ctx, &sts, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	if client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	patch := client.MergeFrom(instance.DeepCopy())
+	delete(instance.Annotations, clusterv1alpha1.AnnotationRecreateStatefulSet)
+	meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionSelectorOutdated)
+	return true, r.Patch(ctx, instance, patch)
+}
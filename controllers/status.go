@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// syncReadiness Sets the Ready and Upgrading conditions from the StatefulSet
+// of the cluster, then derives the phase from the conditions.
+func (r *IpfsReconciler) syncReadiness(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	sts := &appsv1.StatefulSet{}
+	key := client.ObjectKey{Namespace: instance.Namespace, Name: "ipfs-cluster-" + instance.Name}
+	if err := r.Get(ctx, key, sts); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("cannot get statefulset: %w", err)
+		}
+		sts = nil
+	}
+
+	// The pods of the cordoned peers are missing on purpose.
+	ready, message := statefulSetReady(sts, runningPeers(instance))
+	if cordoned := cordonedPeers(instance); len(cordoned) > 0 {
+		message = fmt.Sprintf("%s, %s cordoned", message, strings.Join(cordoned, ", "))
+	}
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.ReadyReasonPeersNotReady,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	}
+	if ready {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = clusterv1alpha1.ReadyReasonPeersReady
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+	syncUpgrading(instance, sts)
+	instance.Status.Phase = derivePhase(instance, sts)
+	return nil
+}
+
+// statefulSetReady Returns whether all the replicas of the StatefulSet run
+// its latest revision and are ready, with a message describing its progress.
+func statefulSetReady(sts *appsv1.StatefulSet, replicas int32) (bool, string) {
+	if sts == nil {
+		return false, "the peers have not been created yet"
+	}
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return false, "the statefulset has not been processed yet"
+	}
+	message := fmt.Sprintf("%d/%d peers ready, %d/%d updated",
+		sts.Status.ReadyReplicas, replicas, sts.Status.UpdatedReplicas, replicas)
+	ready := sts.Status.ReadyReplicas >= replicas &&
+		sts.Status.UpdatedReplicas >= replicas &&
+		sts.Status.UpdateRevision == sts.Status.CurrentRevision
+	return ready, message
+}
+
+// derivePhase Summarizes the conditions of the cluster. Clusters whose peers
+// wait for the objects they depend on are pending. Peers which are not ready
+// after having run are upgrading while the StatefulSet rolls out or scales,
+// and degraded otherwise. Peers that never were ready are still initializing.
+// Clusters failing their smoke test are degraded.
+func derivePhase(instance *clusterv1alpha1.Ipfs, sts *appsv1.StatefulSet) clusterv1alpha1.Phase {
+	conds := instance.Status.Conditions
+	switch {
+	case instance.DeletionTimestamp != nil:
+		return clusterv1alpha1.PhaseTerminating
+	case sts == nil && meta.IsStatusConditionFalse(conds, clusterv1alpha1.ConditionReconciled) &&
+		waitingForPhase(meta.FindStatusCondition(conds, clusterv1alpha1.ConditionReconciled).Reason):
+		return clusterv1alpha1.PhasePending
+	case hasReason(conds, clusterv1alpha1.ConditionRotating, clusterv1alpha1.RotatingReasonFailed),
+		hasReason(conds, clusterv1alpha1.ConditionIdentityRotating, clusterv1alpha1.RotatingReasonFailed),
+		meta.IsStatusConditionFalse(conds, clusterv1alpha1.ConditionReconciled),
+		meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionProvisioningFailed),
+		meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionQuotaExceeded),
+		meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionUnschedulable),
+		meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionDegraded):
+		return clusterv1alpha1.PhaseDegraded
+	case meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionRotating),
+		meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionIdentityRotating):
+		return clusterv1alpha1.PhaseUpgrading
+	case meta.IsStatusConditionTrue(conds, clusterv1alpha1.ConditionReady):
+		return clusterv1alpha1.PhaseRunning
+	case sts == nil:
+		return clusterv1alpha1.PhasePending
+	}
+	switch instance.Status.Phase {
+	case clusterv1alpha1.PhaseRunning, clusterv1alpha1.PhaseUpgrading:
+		if statefulSetProgressing(sts, runningPeers(instance)) {
+			return clusterv1alpha1.PhaseUpgrading
+		}
+		return clusterv1alpha1.PhaseDegraded
+	case clusterv1alpha1.PhaseDegraded:
+		return clusterv1alpha1.PhaseDegraded
+	}
+	return clusterv1alpha1.PhaseInitializing
+}
+
+// statefulSetProgressing Returns whether the StatefulSet is rolling out a new
+// revision or scaling to the given number of replicas.
+func statefulSetProgressing(sts *appsv1.StatefulSet, replicas int32) bool {
+	return sts.Status.ObservedGeneration < sts.Generation ||
+		sts.Status.UpdateRevision != sts.Status.CurrentRevision ||
+		sts.Status.Replicas != replicas ||
+		sts.Status.UpdatedReplicas < replicas
+}
+
+// hasReason Returns whether the condition of the given type has the given reason.
+func hasReason(conds []metav1.Condition, condType, reason string) bool {
+	cond := meta.FindStatusCondition(conds, condType)
+	return cond != nil && cond.Reason == reason
+}
+
+func setReconciledCondition(
+	instance *clusterv1alpha1.Ipfs,
+	status metav1.ConditionStatus,
+	reason, message string,
+) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionReconciled,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	})
+}
+
+// eventf Records an event about the cluster when the reconciler has a recorder.
+func (r *IpfsReconciler) eventf(instance *clusterv1alpha1.Ipfs, eventType, reason, format string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(instance, eventType, reason, format, args...)
+	}
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs status", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "status"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Generation = 1
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("records the reconciled generation and the phase", func() {
+		By("adding the finalizer first")
+		instance := reconcile()
+		Expect(instance.Status.ObservedGeneration).To(BeZero())
+
+		By("creating the peers")
+		instance = reconcile()
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(1)))
+		Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhaseInitializing))
+		Expect(meta.IsStatusConditionFalse(instance.Status.Conditions, clusterv1alpha1.ConditionReady)).To(BeTrue())
+
+		By("reporting the peers ready")
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{
+			Namespace: key.Namespace,
+			Name:      "ipfs-cluster-" + key.Name,
+		}, sts)).To(Succeed())
+		sts.Status.Replicas = 2
+		sts.Status.ReadyReplicas = 2
+		sts.Status.UpdatedReplicas = 2
+		Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+		instance = reconcile()
+		Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhaseRunning))
+		Expect(meta.IsStatusConditionTrue(instance.Status.Conditions, clusterv1alpha1.ConditionReady)).To(BeTrue())
+
+		By("bumping the generation")
+		instance.Generation = 2
+		instance.Spec.Replicas = 3
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(1)))
+
+		instance = reconcile()
+		Expect(instance.Status.ObservedGeneration).To(Equal(int64(2)))
+		Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhaseUpgrading))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReady)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.ObservedGeneration).To(Equal(int64(2)))
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+	})
+
+	// condition Returns a condition of the given type, status and reason.
+	condition := func(condType string, status metav1.ConditionStatus, reason string) metav1.Condition {
+		return metav1.Condition{Type: condType, Status: status, Reason: reason}
+	}
+
+	// statefulSet Returns a StatefulSet with the given replicas of which the
+	// given number run the latest revision.
+	statefulSet := func(replicas, ready, updated int32) *appsv1.StatefulSet {
+		sts := &appsv1.StatefulSet{}
+		sts.Generation = 1
+		sts.Status.ObservedGeneration = 1
+		sts.Status.Replicas = replicas
+		sts.Status.ReadyReplicas = ready
+		sts.Status.UpdatedReplicas = updated
+		sts.Status.CurrentRevision = "rev-1"
+		sts.Status.UpdateRevision = "rev-1"
+		if updated < replicas {
+			sts.Status.UpdateRevision = "rev-2"
+		}
+		return sts
+	}
+
+	DescribeTable("derives the phase from the conditions and the statefulset",
+		func(previous clusterv1alpha1.Phase, sts *appsv1.StatefulSet, deleting bool,
+			expected clusterv1alpha1.Phase, conds ...metav1.Condition) {
+			instance := &clusterv1alpha1.Ipfs{}
+			instance.Spec.Replicas = 2
+			instance.Status.Phase = previous
+			instance.Status.Conditions = conds
+			if deleting {
+				now := metav1.Now()
+				instance.DeletionTimestamp = &now
+			}
+			Expect(derivePhase(instance, sts)).To(Equal(expected))
+		},
+		Entry("terminating whatever the conditions",
+			clusterv1alpha1.PhaseRunning, statefulSet(2, 2, 2), true, clusterv1alpha1.PhaseTerminating,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionTrue, clusterv1alpha1.ReadyReasonPeersReady)),
+		Entry("pending while the peers wait for their config",
+			clusterv1alpha1.Phase(""), nil, false, clusterv1alpha1.PhasePending,
+			condition(clusterv1alpha1.ConditionReconciled, metav1.ConditionFalse,
+				clusterv1alpha1.ReconciledReasonConfigNotReady)),
+		Entry("pending before the statefulset exists",
+			clusterv1alpha1.Phase(""), nil, false, clusterv1alpha1.PhasePending),
+		Entry("degraded when the reconcile failed",
+			clusterv1alpha1.PhaseRunning, statefulSet(2, 2, 2), false, clusterv1alpha1.PhaseDegraded,
+			condition(clusterv1alpha1.ConditionReconciled, metav1.ConditionFalse, clusterv1alpha1.ReconciledReasonError),
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionTrue, clusterv1alpha1.ReadyReasonPeersReady)),
+		Entry("degraded when a rotation failed",
+			clusterv1alpha1.PhaseRunning, statefulSet(2, 2, 2), false, clusterv1alpha1.PhaseDegraded,
+			condition(clusterv1alpha1.ConditionRotating, metav1.ConditionFalse, clusterv1alpha1.RotatingReasonFailed),
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionTrue, clusterv1alpha1.ReadyReasonPeersReady)),
+		Entry("degraded when the peers cannot be scheduled",
+			clusterv1alpha1.PhaseInitializing, statefulSet(2, 0, 2), false, clusterv1alpha1.PhaseDegraded,
+			condition(clusterv1alpha1.ConditionUnschedulable, metav1.ConditionTrue, "Unschedulable")),
+		Entry("upgrading while the identity of a peer rotates",
+			clusterv1alpha1.PhaseRunning, statefulSet(2, 1, 2), false, clusterv1alpha1.PhaseUpgrading,
+			condition(clusterv1alpha1.ConditionIdentityRotating, metav1.ConditionTrue,
+				clusterv1alpha1.RotatingReasonInProgress)),
+		Entry("running once the peers are ready",
+			clusterv1alpha1.PhaseInitializing, statefulSet(2, 2, 2), false, clusterv1alpha1.PhaseRunning,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionTrue, clusterv1alpha1.ReadyReasonPeersReady)),
+		Entry("upgrading while a running cluster rolls out",
+			clusterv1alpha1.PhaseRunning, statefulSet(2, 1, 1), false, clusterv1alpha1.PhaseUpgrading,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionFalse, clusterv1alpha1.ReadyReasonPeersNotReady)),
+		Entry("upgrading while a running cluster scales",
+			clusterv1alpha1.PhaseRunning, statefulSet(1, 1, 1), false, clusterv1alpha1.PhaseUpgrading,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionFalse, clusterv1alpha1.ReadyReasonPeersNotReady)),
+		Entry("degraded when the peers of a settled cluster are lost",
+			clusterv1alpha1.PhaseRunning, statefulSet(2, 1, 2), false, clusterv1alpha1.PhaseDegraded,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionFalse, clusterv1alpha1.ReadyReasonPeersNotReady)),
+		Entry("staying degraded until the peers are ready",
+			clusterv1alpha1.PhaseDegraded, statefulSet(2, 1, 1), false, clusterv1alpha1.PhaseDegraded,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionFalse, clusterv1alpha1.ReadyReasonPeersNotReady)),
+		Entry("initializing until the peers were ever ready",
+			clusterv1alpha1.PhaseInitializing, statefulSet(2, 0, 2), false, clusterv1alpha1.PhaseInitializing,
+			condition(clusterv1alpha1.ConditionReady, metav1.ConditionFalse, clusterv1alpha1.ReadyReasonPeersNotReady)),
+	)
+
+	DescribeTable("sets the Ready condition from the statefulset",
+		func(sts *appsv1.StatefulSet, ready metav1.ConditionStatus, message string) {
+			instance := &clusterv1alpha1.Ipfs{}
+			instance.Name = key.Name
+			instance.Namespace = key.Namespace
+			instance.Spec.Replicas = 2
+			if sts != nil {
+				sts.Name = "ipfs-cluster-" + key.Name
+				sts.Namespace = key.Namespace
+				Expect(fakeClient.Create(ctx, sts)).To(Succeed())
+				Expect(fakeClient.Status().Update(ctx, sts)).To(Succeed())
+			}
+			Expect(reconciler.syncReadiness(ctx, instance)).To(Succeed())
+			cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionReady)
+			Expect(cond).NotTo(BeNil())
+			Expect(cond.Status).To(Equal(ready))
+			Expect(cond.Message).To(Equal(message))
+		},
+		Entry("without a statefulset", nil, metav1.ConditionFalse, "the peers have not been created yet"),
+		Entry("with peers not ready", statefulSet(2, 1, 2), metav1.ConditionFalse, "1/2 peers ready, 2/2 updated"),
+		Entry("with peers on the previous revision",
+			statefulSet(2, 2, 1), metav1.ConditionFalse, "2/2 peers ready, 1/2 updated"),
+		Entry("with every peer ready and updated",
+			statefulSet(2, 2, 2), metav1.ConditionTrue, "2/2 peers ready, 2/2 updated"),
+	)
+})
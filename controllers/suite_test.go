@@ -17,12 +17,15 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"os"
 	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -30,6 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	kubofake "github.com/redhat-et/ipfs-operator/pkg/kuboapi/fake"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -39,6 +43,12 @@ import (
 var k8sClient client.Client
 var testEnv *envtest.Environment
 
+// kuboNodes Holds the kubo nodes of the peer pods the end to end tests create.
+var kuboNodes *kubofake.Dialer
+
+// stopManager Stops the manager running the controllers against testEnv.
+var stopManager context.CancelFunc
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -69,10 +79,44 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the controllers")
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme.Scheme, MetricsBindAddress: "0"})
+	Expect(err).NotTo(HaveOccurred())
+	kuboNodes = kubofake.NewDialer()
+	Expect((&IpfsReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+		Recorder:  mgr.GetEventRecorderFor("ipfs-controller"),
+		Kubo:      kuboNodes,
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&CircuitRelayReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr)).To(Succeed())
+	var ctx context.Context
+	ctx, stopManager = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	stopManager()
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// expectGolden Compares the rendered config with its golden file under
+// testdata. Set UPDATE_GOLDEN to rewrite the file instead.
+func expectGolden(name, rendered string) {
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv("UPDATE_GOLDEN") != "" {
+		Expect(os.WriteFile(path, []byte(rendered), 0o600)).To(Succeed())
+	}
+	golden, err := os.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(rendered).To(Equal(string(golden)))
+}
@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+const (
+	// swarmDefaultsPath Holds the listeners of the repo from before the
+	// operator added those of the transports, to restore them once the
+	// transports are disabled.
+	swarmDefaultsPath = "/data/ipfs/.swarm-defaults"
+	// swarmAnnouncePath Marks the repos announcing their public WebSocket address.
+	swarmAnnouncePath = "/data/ipfs/.swarm-announce"
+	// portWSS Is the port the Ingress serves secure WebSockets on.
+	portWSS = 443
+)
+
+// webSocket Returns the WebSocket transport of the spec, if any.
+func webSocket(m *clusterv1alpha1.Ipfs) *clusterv1alpha1.WebSocketTransport {
+	if m.Spec.Swarm == nil || m.Spec.Swarm.Transports == nil {
+		return nil
+	}
+	return m.Spec.Swarm.Transports.WebSocket
+}
+
+// webTransport Returns the WebTransport transport of the spec, if any.
+func webTransport(m *clusterv1alpha1.Ipfs) *clusterv1alpha1.WebTransportTransport {
+	if m.Spec.Swarm == nil || m.Spec.Swarm.Transports == nil {
+		return nil
+	}
+	return m.Spec.Swarm.Transports.WebTransport
+}
+
+// webSocketPort Returns the TCP port of the WebSocket listener of the peers.
+func webSocketPort(m *clusterv1alpha1.Ipfs) int32 {
+	if ws := webSocket(m); ws != nil && ws.Port != 0 {
+		return ws.Port
+	}
+	return clusterv1alpha1.DefaultWebSocketPort
+}
+
+// webTransportPort Returns the UDP port of the WebTransport listener of the peers.
+func webTransportPort(m *clusterv1alpha1.Ipfs) int32 {
+	if wt := webTransport(m); wt != nil && wt.Port != 0 {
+		return wt.Port
+	}
+	return clusterv1alpha1.DefaultWebTransportPort
+}
+
+// webSocketEnabled Returns whether the peers listen for WebSocket connections.
+func webSocketEnabled(m *clusterv1alpha1.Ipfs) bool {
+	ws := webSocket(m)
+	return ws != nil && ws.Enabled
+}
+
+// webSocketPublic Returns whether the WebSocket listeners of the peers are
+// served through the Ingress of the exposed host, which must be a valid
+// host name to be written into the configure script.
+func webSocketPublic(m *clusterv1alpha1.Ipfs) bool {
+	ws := webSocket(m)
+	return ws != nil && ws.Enabled && ws.Public && m.Spec.Expose.Host != "" &&
+		len(validation.IsDNS1123Subdomain(m.Spec.Expose.Host)) == 0
+}
+
+// webTransportEnabled Returns whether the peers listen for WebTransport
+// sessions. The listener is left out for kubo releases without WebTransport,
+// which would refuse to start with it.
+func webTransportEnabled(m *clusterv1alpha1.Ipfs) bool {
+	wt := webTransport(m)
+	return wt != nil && wt.Enabled && clusterv1alpha1.WebTransportSupported(m.Spec.IpfsImage)
+}
+
+// swarmListeners Returns the listen multiaddresses of the enabled transports.
+func swarmListeners(m *clusterv1alpha1.Ipfs) []string {
+	var listeners []string
+	if webSocketEnabled(m) {
+		listeners = append(listeners, fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", webSocketPort(m)))
+	}
+	if webTransportEnabled(m) {
+		listeners = append(listeners, fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1/webtransport", webTransportPort(m)))
+	}
+	return listeners
+}
+
+// peerWebSocketHost Returns the host the Ingress serves the WebSocket
+// listener of the peer with the given ordinal at.
+func peerWebSocketHost(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	return peerPodName(m, ordinal) + "." + m.Spec.Expose.Host
+}
+
+// swarmCommands Returns the commands of the configure script adding the
+// listeners of the enabled transports to those of the repo, and announcing
+// the public WebSocket address of the peer, at every start of the peers.
+// The listeners of the repo are saved before the first change, so that
+// disabling the transports restores them.
+func swarmCommands(m *clusterv1alpha1.Ipfs) string {
+	var b strings.Builder
+	if listeners := swarmListeners(m); len(listeners) > 0 {
+		quoted := make([]string, len(listeners))
+		for i, listener := range listeners {
+			quoted[i] = `"` + listener + `"`
+		}
+		fmt.Fprintf(&b, "\t[ -f %[1]s ] || ipfs config Addresses.Swarm > %[1]s\n", swarmDefaultsPath)
+		fmt.Fprintf(&b, "\tlisteners=$(tr -d ' \\n' < %s)\n", swarmDefaultsPath)
+		fmt.Fprintf(&b, "\tipfs config --json Addresses.Swarm \"${listeners%%]},\"'%s]'\n", strings.Join(quoted, ","))
+	} else {
+		fmt.Fprintf(&b, "\tif [ -f %[1]s ]; then\n\t\tipfs config --json Addresses.Swarm \"$(cat %[1]s)\"\n"+
+			"\t\trm %[1]s\n\tfi\n", swarmDefaultsPath)
+	}
+	if webSocketPublic(m) {
+		fmt.Fprintf(&b, "\tipfs config --json Addresses.AppendAnnounce "+
+			"'[\"/dns4/'\"$(cat /proc/sys/kernel/hostname)\"'.%s/tcp/%d/wss\"]'\n\ttouch %s\n",
+			m.Spec.Expose.Host, portWSS, swarmAnnouncePath)
+	} else {
+		fmt.Fprintf(&b, "\tif [ -f %[1]s ]; then\n\t\tipfs config --json Addresses.AppendAnnounce '[]'\n"+
+			"\t\trm %[1]s\n\tfi\n", swarmAnnouncePath)
+	}
+	return b.String()
+}
+
+// swarmSettings Returns the transports of the cluster, for the config hash
+// rolling the peers when they change.
+func swarmSettings(m *clusterv1alpha1.Ipfs) []string {
+	var settings []string
+	if webSocketEnabled(m) {
+		settings = append(settings, fmt.Sprintf("swarm/websocket=%d", webSocketPort(m)))
+	}
+	if webSocketPublic(m) {
+		settings = append(settings, "swarm/websocket/public="+m.Spec.Expose.Host)
+	}
+	if webTransportEnabled(m) {
+		settings = append(settings, fmt.Sprintf("swarm/webtransport=%d", webTransportPort(m)))
+	}
+	return settings
+}
+
+// webSocketServiceName Returns the name of the Service of the WebSocket
+// listener of the peer with the given ordinal.
+func webSocketServiceName(m *clusterv1alpha1.Ipfs, ordinal int32) string {
+	return fmt.Sprintf("ipfs-ws-%s-%d", m.Name, ordinal)
+}
+
+// webSocketIngressName Returns the name of the Ingress serving the WebSocket
+// listeners of the peers.
+func webSocketIngressName(m *clusterv1alpha1.Ipfs) string {
+	return "ipfs-ws-" + m.Name
+}
+
+// serviceWebSocket Returns a mutate function for the Service selecting the
+// single peer with the given ordinal, which the Ingress routes its
+// WebSocket host to: a browser dialing a peer must reach that very peer.
+func (r *IpfsReconciler) serviceWebSocket(
+	m *clusterv1alpha1.Ipfs,
+	svc *corev1.Service,
+	ordinal int32,
+) (controllerutil.MutateFn, string) {
+	svcName := webSocketServiceName(m, ordinal)
+	expected := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svcName,
+			Namespace: m.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name:       "ws",
+					Protocol:   corev1.ProtocolTCP,
+					Port:       webSocketPort(m),
+					TargetPort: intstr.FromString("ws"),
+				},
+			},
+			Selector: peerPodSelector(m, ordinal),
+		},
+	}
+	expected.DeepCopyInto(svc)
+	if err := ctrl.SetControllerReference(m, svc, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		unchanged, err := specUnchanged(svc, expected.Spec, svc.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		svc.Spec.Ports = expected.Spec.Ports
+		svc.Spec.Selector = expected.Spec.Selector
+		return nil
+	}, svcName
+}
+
+// ingressWebSocket Returns a mutate function for the Ingress serving the
+// WebSocket listener of each peer at its own host. The Ingress terminates
+// TLS with the certificate of the gateway once it is ready, so that
+// browsers dial the peers over secure WebSockets.
+func (r *IpfsReconciler) ingressWebSocket(
+	m *clusterv1alpha1.Ipfs,
+	ing *networkingv1.Ingress,
+	tlsReady bool,
+) (controllerutil.MutateFn, string) {
+	ingName := webSocketIngressName(m)
+	pathType := networkingv1.PathTypePrefix
+	expected := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ingName,
+			Namespace: m.Namespace,
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: m.Spec.Expose.IngressClassName,
+		},
+	}
+	hosts := make([]string, 0, m.Spec.Replicas)
+	for ordinal := int32(0); ordinal < m.Spec.Replicas; ordinal++ {
+		host := peerWebSocketHost(m, ordinal)
+		hosts = append(hosts, host)
+		expected.Spec.Rules = append(expected.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{
+				HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: webSocketServiceName(m, ordinal),
+									Port: networkingv1.ServiceBackendPort{Name: "ws"},
+								},
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+	if tlsReady {
+		expected.Spec.TLS = []networkingv1.IngressTLS{{Hosts: hosts, SecretName: tlsSecretName(m)}}
+	}
+	expected.DeepCopyInto(ing)
+	if err := ctrl.SetControllerReference(m, ing, r.Scheme); err != nil {
+		return func() error { return err }, ""
+	}
+	return func() error {
+		unchanged, err := specUnchanged(ing, expected.Spec, ing.Spec)
+		if err != nil || unchanged {
+			return err
+		}
+		ing.Spec = expected.Spec
+		return nil
+	}, ingName
+}
+
+// deleteWebSockets Deletes the Services of the WebSocket listeners of the
+// peers removed by a scale-down, and the Ingress and every Service once the
+// listeners are no longer public.
+func (r *IpfsReconciler) deleteWebSockets(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	public := webSocketPublic(m)
+	services := corev1.ServiceList{}
+	if err := r.List(ctx, &services, client.InNamespace(m.Namespace),
+		client.MatchingLabels{labelInstance: m.Name, labelComponent: componentSwarm}); err != nil {
+		return fmt.Errorf("cannot list websocket services: %w", err)
+	}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if public && peerOrdinal(svc.Name) >= 0 && peerOrdinal(svc.Name) < m.Spec.Replicas {
+			continue
+		}
+		if err := r.Delete(ctx, svc); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete websocket service %s: %w", svc.Name, err)
+		}
+	}
+	if public {
+		return nil
+	}
+	ing := networkingv1.Ingress{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: m.Namespace, Name: webSocketIngressName(m)}, &ing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if err := r.Delete(ctx, &ing); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete websocket ingress: %w", err)
+	}
+	return nil
+}
+
+// browserAddresses Returns the multiaddresses browsers dial the kubo node
+// of the peer at: the secure WebSocket address the Ingress serves, and the
+// WebTransport addresses the node reports, which carry the hashes of its
+// certificates. Loopback addresses are left out.
+func browserAddresses(m *clusterv1alpha1.Ipfs, pod string, id *kuboapi.IDOutput) []string {
+	var addrs []string
+	if webSocketPublic(m) {
+		addrs = append(addrs, fmt.Sprintf("/dns4/%s.%s/tcp/%d/wss/p2p/%s", pod, m.Spec.Expose.Host, portWSS, id.ID))
+	}
+	if wt := webTransport(m); wt != nil && wt.Enabled {
+		for _, addr := range id.Addresses {
+			if !strings.Contains(addr+"/", "/webtransport/") || loopbackAddress(addr) {
+				continue
+			}
+			if !strings.Contains(addr, "/p2p/") {
+				addr += "/p2p/" + id.ID
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// loopbackAddress Returns whether the multiaddress can only be dialed from
+// the pod itself.
+func loopbackAddress(addr string) bool {
+	return strings.HasPrefix(addr, "/ip4/127.") || strings.HasPrefix(addr, "/ip6/::1/")
+}
@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+var _ = Describe("Ipfs swarm transports", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	get := func() *clusterv1alpha1.Ipfs {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	update := func(mutate func(*clusterv1alpha1.Ipfs)) {
+		instance := get()
+		mutate(instance)
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+	}
+
+	ingress := func() (*networkingv1.Ingress, error) {
+		ing := &networkingv1.Ingress{}
+		err := fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-ws-" + key.Name}, ing)
+		return ing, err
+	}
+
+	services := func() []string {
+		list := &corev1.ServiceList{}
+		Expect(fakeClient.List(ctx, list, client.InNamespace(key.Namespace),
+			client.MatchingLabels{labelComponent: componentSwarm})).To(Succeed())
+		names := []string{}
+		for i := range list.Items {
+			names = append(names, list.Items[i].Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "browser"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.Spec.IpfsImage = "ipfs/kubo:v0.29.0"
+		instance.Spec.Expose.Host = "ipfs.example.com"
+		instance.Spec.Expose.TLS = &clusterv1alpha1.TLSConfig{SecretName: "ipfs-tls"}
+		instance.Spec.Swarm = &clusterv1alpha1.SwarmConfig{Transports: &clusterv1alpha1.SwarmTransports{
+			WebSocket:    &clusterv1alpha1.WebSocketTransport{Enabled: true, Port: 8443, Public: true},
+			WebTransport: &clusterv1alpha1.WebTransportTransport{Enabled: true},
+		}}
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+
+		// The first reconcile only adds the finalizer.
+		reconcile()
+		reconcile()
+	})
+
+	It("listens on the enabled transports and serves the WebSockets of each peer", func() {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-scripts-" + key.Name}, cm)).To(Succeed())
+		script := cm.Data["configure-ipfs.sh"]
+		Expect(script).To(ContainSubstring(`"${listeners%]},"'"/ip4/0.0.0.0/tcp/8443/ws",` +
+			`"/ip4/0.0.0.0/udp/4002/quic-v1/webtransport"]'`))
+		Expect(script).To(ContainSubstring(`ipfs config --json Addresses.AppendAnnounce ` +
+			`'["/dns4/'"$(cat /proc/sys/kernel/hostname)"'.ipfs.example.com/tcp/443/wss"]'`))
+
+		sts := &appsv1.StatefulSet{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name},
+			sts)).To(Succeed())
+		Expect(sts.Spec.Template.Spec.Containers[0].Ports).To(ContainElement(corev1.ContainerPort{
+			Name: "ws", ContainerPort: 8443, Protocol: corev1.ProtocolTCP,
+		}))
+
+		Expect(services()).To(ConsistOf("ipfs-ws-browser-0", "ipfs-ws-browser-1"))
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-ws-browser-1"},
+			svc)).To(Succeed())
+		Expect(svc.Spec.Selector).To(HaveKeyWithValue(appsv1.StatefulSetPodNameLabel, "ipfs-cluster-browser-1"))
+		ing, err := ingress()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ing.Spec.Rules).To(HaveLen(2))
+		Expect(ing.Spec.Rules[1].Host).To(Equal("ipfs-cluster-browser-1.ipfs.example.com"))
+		Expect(ing.Spec.Rules[1].HTTP.Paths[0].Backend.Service.Name).To(Equal("ipfs-ws-browser-1"))
+		Expect(ing.Spec.TLS).To(BeEmpty())
+
+		By("terminating TLS once the certificate is ready")
+		sec := &corev1.Secret{}
+		sec.Name = "ipfs-tls"
+		sec.Namespace = key.Namespace
+		sec.Data = map[string][]byte{corev1.TLSCertKey: []byte("cert"), corev1.TLSPrivateKeyKey: []byte("key")}
+		Expect(fakeClient.Create(ctx, sec)).To(Succeed())
+		reconcile()
+		ing, err = ingress()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ing.Spec.TLS).To(ConsistOf(networkingv1.IngressTLS{
+			Hosts:      []string{"ipfs-cluster-browser-0.ipfs.example.com", "ipfs-cluster-browser-1.ipfs.example.com"},
+			SecretName: "ipfs-tls",
+		}))
+
+		By("dropping the Service of a removed peer")
+		update(func(instance *clusterv1alpha1.Ipfs) { instance.Spec.Replicas = 1 })
+		reconcile()
+		Expect(services()).To(ConsistOf("ipfs-ws-browser-0"))
+		ing, err = ingress()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ing.Spec.Rules).To(HaveLen(1))
+
+		By("restoring the listeners and the Ingress once the transports are disabled")
+		update(func(instance *clusterv1alpha1.Ipfs) { instance.Spec.Swarm = nil })
+		reconcile()
+		Expect(services()).To(BeEmpty())
+		_, err = ingress()
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-cluster-scripts-" + key.Name}, cm)).To(Succeed())
+		Expect(cm.Data["configure-ipfs.sh"]).To(ContainSubstring(
+			`ipfs config --json Addresses.Swarm "$(cat /data/ipfs/.swarm-defaults)"`))
+		Expect(cm.Data["configure-ipfs.sh"]).To(ContainSubstring(`ipfs config --json Addresses.AppendAnnounce '[]'`))
+	})
+
+	It("rolls the peers when the transports change", func() {
+		instance := get()
+		hash, err := reconciler.referencedConfigHash(ctx, instance)
+		Expect(err).NotTo(HaveOccurred())
+		instance.Spec.Swarm.Transports.WebTransport.Enabled = false
+		Expect(reconciler.referencedConfigHash(ctx, instance)).NotTo(Equal(hash))
+
+		By("leaving WebTransport out for kubo releases without it")
+		instance.Spec.Swarm.Transports.WebTransport.Enabled = true
+		instance.Spec.IpfsImage = "ipfs/go-ipfs:v0.12.2"
+		Expect(swarmListeners(instance)).To(Equal([]string{"/ip4/0.0.0.0/tcp/8443/ws"}))
+	})
+
+	It("reports the addresses browsers dial the peers at", func() {
+		id := &kuboapi.IDOutput{
+			ID: "12D3KooWA",
+			Addresses: []string{
+				"/ip4/127.0.0.1/udp/4002/quic-v1/webtransport/certhash/uEiA/certhash/uEiB/p2p/12D3KooWA",
+				"/ip4/10.0.0.5/tcp/4001/p2p/12D3KooWA",
+				"/ip4/10.0.0.5/udp/4002/quic-v1/webtransport/certhash/uEiA/certhash/uEiB/p2p/12D3KooWA",
+				"/ip4/203.0.113.7/udp/4002/quic-v1/webtransport/certhash/uEiA/certhash/uEiB",
+			},
+		}
+		addrs := browserAddresses(get(), "ipfs-cluster-browser-0", id)
+		Expect(addrs).To(Equal([]string{
+			"/dns4/ipfs-cluster-browser-0.ipfs.example.com/tcp/443/wss/p2p/12D3KooWA",
+			"/ip4/10.0.0.5/udp/4002/quic-v1/webtransport/certhash/uEiA/certhash/uEiB/p2p/12D3KooWA",
+			"/ip4/203.0.113.7/udp/4002/quic-v1/webtransport/certhash/uEiA/certhash/uEiB/p2p/12D3KooWA",
+		}))
+		// Browsers only dial secure WebSockets and WebTransport with the
+		// hashes of the self-signed certificates of the node.
+		for _, addr := range addrs {
+			Expect(addr).To(Or(ContainSubstring("/tcp/443/wss/"), ContainSubstring("/webtransport/certhash/")))
+			Expect(addr).To(HaveSuffix("/p2p/12D3KooWA"))
+		}
+
+		By("leaving them out without browser transports")
+		instance := get()
+		instance.Spec.Swarm = nil
+		Expect(browserAddresses(instance, "ipfs-cluster-browser-0", id)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// cleanupStep Is a step of the cleanup of a cluster being deleted. Steps are
+// idempotent, and are recorded in the status once completed, so that a
+// cleanup interrupted midway resumes with the step which did not complete.
+type cleanupStep struct {
+	name clusterv1alpha1.CleanupStep
+	run  func(context.Context, *clusterv1alpha1.Ipfs) error
+}
+
+// cleanupSteps Returns the steps of the cleanup, in order.
+func (r *IpfsReconciler) cleanupSteps() []cleanupStep {
+	return []cleanupStep{
+		{name: clusterv1alpha1.CleanupStepReadiness, run: r.deleteReadyConfigMap},
+		{name: clusterv1alpha1.CleanupStepExposure, run: r.cleanupExposure},
+		{name: clusterv1alpha1.CleanupStepPeers, run: r.cleanupPeers},
+		{name: clusterv1alpha1.CleanupStepRelays, run: r.cleanupRelays},
+		{name: clusterv1alpha1.CleanupStepVolumes, run: r.cleanupVolumes},
+		{name: clusterv1alpha1.CleanupStepMetrics, run: r.cleanupMetrics},
+	}
+}
+
+// finalize Runs the finalizer of a cluster being deleted: the cleanup steps
+// not completed yet, then the removal of the finalizer. The data the
+// deletion loses is reported through warning events as it starts. Errors are
+// returned so that the deletion is retried.
+func (r *IpfsReconciler) finalize(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if instance.Status.Phase != clusterv1alpha1.PhaseTerminating {
+		for _, warning := range instance.DeletionWarnings() {
+			r.eventf(instance, corev1.EventTypeWarning, clusterv1alpha1.ConditionDeletionLosesData, "%s", warning)
+		}
+		if err := r.updateStatusRetrying(ctx, instance, func(latest *clusterv1alpha1.Ipfs) {
+			latest.Status.Phase = clusterv1alpha1.PhaseTerminating
+		}); err != nil {
+			return err
+		}
+	}
+	for _, step := range r.cleanupSteps() {
+		if cleanupCompleted(instance, step.name) {
+			continue
+		}
+		if err := step.run(ctx, instance); err != nil {
+			return fmt.Errorf("cannot clean up %s: %w", step.name, err)
+		}
+		if err := r.updateStatusRetrying(ctx, instance, func(latest *clusterv1alpha1.Ipfs) {
+			if !cleanupCompleted(latest, step.name) {
+				latest.Status.CleanupProgress = append(latest.Status.CleanupProgress, step.name)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return r.setFinalizer(ctx, instance, false)
+}
+
+// cleanupCompleted Returns whether the step is recorded as completed.
+func cleanupCompleted(instance *clusterv1alpha1.Ipfs, step clusterv1alpha1.CleanupStep) bool {
+	for _, done := range instance.Status.CleanupProgress {
+		if done == step {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupExposure Deletes the Ingress and the Service exposing the gateway,
+// along with the Certificates the operator requested for it. They are owned
+// by the cluster, but the garbage collector only deletes them after the
+// cluster is gone, and not at all when their owner references were stripped,
+// e.g. by a restore from backup.
+func (r *IpfsReconciler) cleanupExposure(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	for _, obj := range []client.Object{&networkingv1.Ingress{}, &corev1.Service{}} {
+		obj.SetNamespace(instance.Namespace)
+		obj.SetName(gatewayServiceName(instance))
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete %s: %w", obj.GetName(), err)
+		}
+	}
+	return r.deleteGatewayCertificates(ctx, instance, "")
+}
+
+// cleanupPeers Deregisters the peers of the cluster: deletes the bundle it
+// exports to the federated clusters, and the directory listing its peers.
+func (r *IpfsReconciler) cleanupPeers(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	if err := r.deleteFederationExport(ctx, instance); err != nil {
+		return err
+	}
+	directory := &corev1.ConfigMap{}
+	directory.Namespace = instance.Namespace
+	directory.Name = peerDirectoryName(instance)
+	if err := r.Delete(ctx, directory); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("cannot delete peer directory %s: %w", directory.Name, err)
+	}
+	return nil
+}
+
+// cleanupRelays Deletes the circuit relays created for the peers.
+func (r *IpfsReconciler) cleanupRelays(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	for _, name := range instance.Status.CircuitRelays {
+		relay := &clusterv1alpha1.CircuitRelay{}
+		relay.Namespace = instance.Namespace
+		relay.Name = name
+		if err := r.Delete(ctx, relay); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot delete circuit relay %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// cleanupVolumes Handles the volume claims of the peers as the reclaim
+// policy tells. With Delete, the claims are deleted, including those the
+// StatefulSet created since the cluster was last reconciled, which it does
+// not own yet and the garbage collector would leave behind. Otherwise, the
+// cluster is removed from their owners, so that the garbage collector keeps
+// them even when the policy changed since they were last labeled. The claims
+// owned by another Ipfs resource are left alone.
+func (r *IpfsReconciler) cleanupVolumes(ctx context.Context, instance *clusterv1alpha1.Ipfs) error {
+	claims := corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, &claims, client.InNamespace(instance.Namespace)); err != nil {
+		return fmt.Errorf("cannot list volume claims: %w", err)
+	}
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if claim.DeletionTimestamp != nil || !isPeerClaim(instance, claim.Name) || ownedByOtherIpfs(instance, claim) {
+			continue
+		}
+		if volumesDeleted(instance) {
+			if err := r.Delete(ctx, claim); client.IgnoreNotFound(err) != nil {
+				return fmt.Errorf("cannot delete volume claim %s: %w", claim.Name, err)
+			}
+			continue
+		}
+		released := claim.DeepCopy()
+		removeOwnerReference(released, instance.UID)
+		if len(released.OwnerReferences) == len(claim.OwnerReferences) {
+			continue
+		}
+		if err := r.Patch(ctx, released, client.MergeFrom(claim)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot release volume claim %s: %w", claim.Name, err)
+		}
+	}
+	return nil
+}
+
+// cleanupMetrics Deletes the metrics of the cluster and of its peers.
+func (r *IpfsReconciler) cleanupMetrics(_ context.Context, instance *clusterv1alpha1.Ipfs) error {
+	skewedPeers.DeleteLabelValues(instance.Namespace, instance.Name)
+	reconciles.DeleteLabelValues(instance.Namespace, instance.Name)
+	gatewayRequests.DeleteLabelValues(instance.Namespace, instance.Name)
+	gatewayResponseBytes.DeleteLabelValues(instance.Namespace, instance.Name)
+	forgetHeldPins(instance)
+	r.gatewayUsage.forget(client.ObjectKeyFromObject(instance))
+	r.breakers.forget(client.ObjectKeyFromObject(instance))
+	r.admission.forget(client.ObjectKeyFromObject(instance))
+	for _, api := range guardedAPIs {
+		apiBreakerOpen.DeleteLabelValues(instance.Namespace, instance.Name, api)
+	}
+	for _, p := range instance.Status.Peers {
+		peerRepoUtilization.DeleteLabelValues(instance.Namespace, instance.Name, p.Name)
+		peerMissingConnections.DeleteLabelValues(instance.Namespace, instance.Name, p.Name)
+		forgetPeerBandwidth(instance, p.Name)
+	}
+	return nil
+}
+
+// updateStatusRetrying Applies the mutation to the latest status of the
+// cluster and writes it, reading the cluster again as long as the write
+// conflicts with another writer. The instance is left with the status written.
+func (r *IpfsReconciler) updateStatusRetrying(
+	ctx context.Context,
+	instance *clusterv1alpha1.Ipfs,
+	mutate func(*clusterv1alpha1.Ipfs),
+) error {
+	key := client.ObjectKeyFromObject(instance)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(ctx, key, instance); err != nil {
+			return err
+		}
+		mutate(instance)
+		return r.Status().Update(ctx, instance)
+	})
+}
+
+// setFinalizer Adds or removes the finalizer of the cluster, reading the
+// cluster again as long as the update conflicts with another writer. The
+// legacy finalizer is removed along with the current one. A cluster already
+// gone needs no finalizer removed.
+func (r *IpfsReconciler) setFinalizer(ctx context.Context, instance *clusterv1alpha1.Ipfs, present bool) error {
+	key := client.ObjectKeyFromObject(instance)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := r.Get(ctx, key, instance); err != nil {
+			return err
+		}
+		if hasFinalizer(instance) == present {
+			return nil
+		}
+		if present {
+			controllerutil.AddFinalizer(instance, finalizer)
+		} else {
+			controllerutil.RemoveFinalizer(instance, finalizer)
+			controllerutil.RemoveFinalizer(instance, legacyFinalizer)
+		}
+		return r.Update(ctx, instance)
+	})
+	if !present {
+		return client.IgnoreNotFound(err)
+	}
+	return err
+}
+
+// DrainIpfs Deletes every Ipfs resource of the cluster and runs its
+// finalizer. It runs when the operator is uninstalled, so that no resource
+// is left with a finalizer nobody processes, which would block the deletion
+// of its namespace and of the CRD.
+func (r *IpfsReconciler) DrainIpfs(ctx context.Context) error {
+	log := ctrllog.FromContext(ctx)
+	list := clusterv1alpha1.IpfsList{}
+	if err := r.List(ctx, &list); err != nil {
+		return fmt.Errorf("cannot list ipfs resources: %w", err)
+	}
+	for i := range list.Items {
+		instance := &list.Items[i]
+		if instance.DeletionTimestamp == nil {
+			if err := r.Delete(ctx, instance); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("cannot delete ipfs %s/%s: %w", instance.Namespace, instance.Name, err)
+			}
+		}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(instance), instance); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("cannot get ipfs %s/%s: %w", instance.Namespace, instance.Name, err)
+		}
+		if hasFinalizer(instance) {
+			if err := r.finalize(ctx, instance); err != nil {
+				return fmt.Errorf("cannot finalize ipfs %s/%s: %w", instance.Namespace, instance.Name, err)
+			}
+		}
+		log.Info("drained ipfs cluster", "namespace", instance.Namespace, "name", instance.Name)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// conflictingClient Fails the first updates made through it, status
+// included, with a conflict, the deletions of circuit relays while
+// relaysFail is set and those of volume claims while claimsFail is set.
+type conflictingClient struct {
+	client.Client
+	conflicts  int
+	relaysFail bool
+	claimsFail bool
+}
+
+func (c *conflictingClient) conflict(obj client.Object) error {
+	if c.conflicts == 0 {
+		return nil
+	}
+	c.conflicts--
+	return errors.NewConflict(schema.GroupResource{Group: "cluster.ipfs.io", Resource: "ipfs"},
+		obj.GetName(), fmt.Errorf("the object has been modified"))
+}
+
+func (c *conflictingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.conflict(obj); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *conflictingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if _, ok := obj.(*clusterv1alpha1.CircuitRelay); ok && c.relaysFail {
+		return fmt.Errorf("connection refused")
+	}
+	if _, ok := obj.(*corev1.PersistentVolumeClaim); ok && c.claimsFail {
+		return fmt.Errorf("connection refused")
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *conflictingClient) Status() client.StatusWriter {
+	return &conflictingStatusWriter{StatusWriter: c.Client.Status(), client: c}
+}
+
+type conflictingStatusWriter struct {
+	client.StatusWriter
+	client *conflictingClient
+}
+
+func (w *conflictingStatusWriter) Update(
+	ctx context.Context, obj client.Object, opts ...client.UpdateOption,
+) error {
+	if err := w.client.conflict(obj); err != nil {
+		return err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+var _ = Describe("Ipfs finalizer", func() {
+	var (
+		ctx        context.Context
+		fakeClient *conflictingClient
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "teardown"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		instance.UID = "7d2b9f41"
+		instance.Status.CircuitRelays = []string{"teardown-0"}
+		relay := &clusterv1alpha1.CircuitRelay{}
+		relay.Name = "teardown-0"
+		relay.Namespace = key.Namespace
+		ing := &networkingv1.Ingress{}
+		ing.Name = "ipfs-gateway-teardown"
+		ing.Namespace = key.Namespace
+		svc := &corev1.Service{}
+		svc.Name = ing.Name
+		svc.Namespace = key.Namespace
+		fakeClient = &conflictingClient{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance, relay, ing, svc).Build(),
+		}
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("adds the finalizer despite conflicting writers", func() {
+		fakeClient.conflicts = 2
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		Expect(instance.Finalizers).To(ContainElement(finalizer))
+	})
+
+	It("resumes an interrupted cleanup and removes the finalizer", func() {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		Expect(fakeClient.Delete(ctx, instance)).To(Succeed())
+
+		fakeClient.conflicts = 3
+		fakeClient.relaysFail = true
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).To(HaveOccurred())
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		Expect(instance.Status.Phase).To(Equal(clusterv1alpha1.PhaseTerminating))
+		Expect(instance.Status.CleanupProgress).To(Equal([]clusterv1alpha1.CleanupStep{
+			clusterv1alpha1.CleanupStepReadiness,
+			clusterv1alpha1.CleanupStepExposure,
+			clusterv1alpha1.CleanupStepPeers,
+		}))
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "ipfs-gateway-teardown"}, &networkingv1.Ingress{}))).To(BeTrue())
+
+		By("not running the completed steps again")
+		ing := &networkingv1.Ingress{}
+		ing.Name = "ipfs-gateway-teardown"
+		ing.Namespace = key.Namespace
+		Expect(fakeClient.Create(ctx, ing)).To(Succeed())
+		fakeClient.conflicts = 3
+		fakeClient.relaysFail = false
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, key, instance))).To(BeTrue())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace,
+			Name: "teardown-0"}, &clusterv1alpha1.CircuitRelay{}))).To(BeTrue())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(ing), ing)).To(Succeed())
+	})
+
+	// deleteCluster Sets the reclaim policy of the cluster, and deletes it
+	// once the finalizer is added.
+	deleteCluster := func(policy string) *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		instance.Spec.VolumeReclaimPolicy = policy
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		Expect(fakeClient.Delete(ctx, instance)).To(Succeed())
+		return instance
+	}
+
+	// claim Creates a volume claim with the given owner, if any.
+	claim := func(name string, owner *clusterv1alpha1.Ipfs) *corev1.PersistentVolumeClaim {
+		pvc := &corev1.PersistentVolumeClaim{}
+		pvc.Name = name
+		pvc.Namespace = key.Namespace
+		if owner != nil {
+			Expect(controllerutil.SetOwnerReference(owner, pvc, reconciler.Scheme)).To(Succeed())
+		}
+		Expect(fakeClient.Create(ctx, pvc)).To(Succeed())
+		return pvc
+	}
+
+	// exists Returns whether the object exists.
+	exists := func(obj client.Object) bool {
+		err := fakeClient.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		Expect(client.IgnoreNotFound(err)).To(Succeed())
+		return err == nil
+	}
+
+	It("deregisters the peers and deletes their volumes, resuming after a failure", func() {
+		recorder := record.NewFakeRecorder(10)
+		reconciler.Recorder = recorder
+		instance := deleteCluster(clusterv1alpha1.VolumeReclaimDelete)
+		bundle := &corev1.Secret{}
+		bundle.Name = federationSecretName(instance)
+		bundle.Namespace = key.Namespace
+		Expect(fakeClient.Create(ctx, bundle)).To(Succeed())
+		directory := &corev1.ConfigMap{}
+		directory.Name = peerDirectoryName(instance)
+		directory.Namespace = key.Namespace
+		Expect(fakeClient.Create(ctx, directory)).To(Succeed())
+		owned := claim("ipfs-storage-ipfs-cluster-teardown-0", instance)
+		unowned := claim("cluster-storage-ipfs-cluster-teardown-0", nil)
+		other := &clusterv1alpha1.Ipfs{}
+		other.Name = "teardown-archive"
+		other.Namespace = key.Namespace
+		other.UID = "a5c1e0f3"
+		otherCluster := claim("ipfs-storage-ipfs-cluster-teardown-archive-0", other)
+		otherOwner := claim("ipfs-storage-ipfs-cluster-teardown-1", other)
+
+		fakeClient.claimsFail = true
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).To(HaveOccurred())
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		Expect(instance.Status.CleanupProgress).To(Equal([]clusterv1alpha1.CleanupStep{
+			clusterv1alpha1.CleanupStepReadiness,
+			clusterv1alpha1.CleanupStepExposure,
+			clusterv1alpha1.CleanupStepPeers,
+			clusterv1alpha1.CleanupStepRelays,
+		}))
+		Expect(exists(bundle)).To(BeFalse())
+		Expect(exists(directory)).To(BeFalse())
+		Expect(exists(owned)).To(BeTrue())
+		Expect(recorder.Events).To(Receive(ContainSubstring("deletes the volume claims of its peers")))
+
+		By("deleting the claims of the peers once the cleanup resumes")
+		fakeClient.claimsFail = false
+		_, err = reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, key, instance))).To(BeTrue())
+		Expect(exists(owned)).To(BeFalse())
+		Expect(exists(unowned)).To(BeFalse())
+		Expect(exists(otherCluster)).To(BeTrue())
+		Expect(exists(otherOwner)).To(BeTrue())
+		Expect(recorder.Events).To(BeEmpty())
+	})
+
+	It("releases the claims of the peers from the cluster when they are retained", func() {
+		instance := deleteCluster(clusterv1alpha1.VolumeReclaimRetain)
+		owned := claim("ipfs-storage-ipfs-cluster-teardown-0", instance)
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(errors.IsNotFound(fakeClient.Get(ctx, key, instance))).To(BeTrue())
+		Expect(exists(owned)).To(BeTrue())
+		Expect(owned.OwnerReferences).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Ipfs uninstall drain", func() {
+	It("finalizes every cluster", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		cluster := func(namespace, name string, finalizers ...string) *clusterv1alpha1.Ipfs {
+			instance := &clusterv1alpha1.Ipfs{}
+			instance.Namespace = namespace
+			instance.Name = name
+			instance.Finalizers = finalizers
+			return instance
+		}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			cluster("team-a", "archive", finalizer),
+			cluster("team-b", "cdn", finalizer),
+			cluster("team-b", "scratch"),
+		).Build()
+		reconciler := &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+
+		Expect(reconciler.DrainIpfs(ctx)).To(Succeed())
+		list := clusterv1alpha1.IpfsList{}
+		Expect(fakeClient.List(ctx, &list)).To(Succeed())
+		Expect(list.Items).To(BeEmpty())
+	})
+})
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// auditReportKey Is the key of the audit ConfigMap holding the report.
+const auditReportKey = "report.yaml"
+
+// Operations the operator would make on a drifted object.
+const (
+	driftCreate = "create"
+	driftPatch  = "patch"
+)
+
+// auditReport Lists the objects of a cluster the operator would change.
+type auditReport struct {
+	// Generation Is the generation of the Ipfs resource audited.
+	Generation int64           `json:"generation"`
+	Drift      []driftedObject `json:"drift"`
+}
+
+// driftedObject Describes the changes the operator would make to an object.
+type driftedObject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Operation string `json:"operation"`
+	// Fields Lists the paths of the fields a patch would change.
+	Fields []string `json:"fields,omitempty"`
+	// Patch Is the JSON merge patch the operator would send, with the values
+	// of Secrets redacted.
+	Patch string `json:"patch,omitempty"`
+}
+
+// verifying Returns whether the cluster is audited rather than reconciled.
+func (r *IpfsReconciler) verifying(instance *clusterv1alpha1.Ipfs) bool {
+	return r.VerifyOnly || instance.Annotations[clusterv1alpha1.AnnotationVerifyOnly] == "true"
+}
+
+// verify Audits the cluster: it renders the tracked objects as a reconcile
+// would, compares them with the live objects and records what would change
+// in the audit ConfigMap and the Drifted condition. Nothing else is written,
+// not even the finalizer.
+func (r *IpfsReconciler) verify(ctx context.Context, instance *clusterv1alpha1.Ipfs) (ctrl.Result, error) {
+	log := ctrllog.FromContext(ctx)
+	interval := r.Defaults.Get().StatusSyncInterval.Duration
+	if instance.DeletionTimestamp != nil {
+		log.Info("cluster is being deleted, which is left to the operator reconciling it")
+		return ctrl.Result{}, nil
+	}
+
+	// The helpers rendering the objects record conditions in the status,
+	// which are left out of the audit.
+	scratch := instance.DeepCopy()
+	configHash, err := r.referencedConfigHash(ctx, scratch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	resolved := r.Defaults.For(scratch).apply(scratch)
+	r.fenceSpec(scratch, resolved)
+	resolved.Spec.Replicas = peerCount(scratch)
+	if resolved.Status.PeerService, _, err = r.livePeerService(ctx, resolved); err != nil {
+		return ctrl.Result{}, err
+	}
+	tlsReady, err := r.syncTLS(ctx, scratch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// The identity and secrets only end up in the objects which are missing,
+	// which are reported as created without their contents.
+	peerID, privateString, err := generateIdentity()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	clusterSecret, err := newClusterSecret()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	phases := r.createTrackedObjects(ctx, resolved, peerID, clusterSecret, privateString, configHash, tlsReady)
+	drift, err := r.audit(ctx, phases)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err = r.writeAudit(ctx, instance, auditReport{Generation: instance.Generation, Drift: drift}); err != nil {
+		return ctrl.Result{}, err
+	}
+	observed := instance.Status.DeepCopy()
+	setDriftedCondition(instance, drift)
+	if !equality.Semantic.DeepEqual(observed, &instance.Status) {
+		if err = r.Status().Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	log.Info("cluster audited", "drifted", len(drift))
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// audit Returns the changes the mutate functions of the phases would make to
+// the live objects, without making them.
+func (r *IpfsReconciler) audit(ctx context.Context, phases []reconcilePhase) ([]driftedObject, error) {
+	defaults := r.Defaults.Get()
+	var drift []driftedObject
+	for _, phase := range phases {
+		for obj, mut := range phase.objects {
+			kind := r.kindOf(obj)
+			if mut == nil {
+				return nil, fmt.Errorf("cannot render %s %s", kind, obj.GetName())
+			}
+			if defaults.ArgoCDIgnoreExtraneous {
+				mut = ignoredByArgoCD(obj, mut)
+			}
+			drifted, err := r.auditObject(ctx, kind, obj, mut)
+			if err != nil {
+				return nil, err
+			}
+			if drifted != nil {
+				drift = append(drift, *drifted)
+			}
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool {
+		if drift[i].Kind != drift[j].Kind {
+			return drift[i].Kind < drift[j].Kind
+		}
+		return drift[i].Name < drift[j].Name
+	})
+	return drift, nil
+}
+
+// auditObject Returns the change controllerutil.CreateOrPatch would make to
+// the object, or nil when it would leave it alone.
+func (r *IpfsReconciler) auditObject(
+	ctx context.Context,
+	kind string,
+	obj client.Object,
+	mut controllerutil.MutateFn,
+) (*driftedObject, error) {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot get %s %s: %w", kind, obj.GetName(), err)
+		}
+		return &driftedObject{Kind: kind, Name: obj.GetName(), Operation: driftCreate}, nil
+	}
+	before, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("cannot copy %s %s", kind, obj.GetName())
+	}
+	if err := mut(); err != nil {
+		return nil, fmt.Errorf("cannot render %s %s: %w", kind, obj.GetName(), err)
+	}
+	patch, err := client.MergeFrom(before).Data(obj)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compare %s %s: %w", kind, obj.GetName(), err)
+	}
+	if string(patch) == "{}" {
+		return nil, nil
+	}
+	fields, err := patchedFields(patch)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := obj.(*corev1.Secret); ok {
+		patch = redactSecret(patch)
+	}
+	return &driftedObject{
+		Kind:      kind,
+		Name:      obj.GetName(),
+		Operation: driftPatch,
+		Fields:    fields,
+		Patch:     string(patch),
+	}, nil
+}
+
+// patchedFields Returns the sorted paths of the fields the JSON merge patch
+// sets or removes. Lists are replaced whole by merge patches, so their path
+// ends at the list.
+func patchedFields(patch []byte) ([]string, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return nil, fmt.Errorf("cannot read patch: %w", err)
+	}
+	var paths []string
+	var walk func(prefix string, fields map[string]interface{})
+	walk = func(prefix string, fields map[string]interface{}) {
+		for name, value := range fields {
+			path := prefix + name
+			if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(path+".", nested)
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	walk("", fields)
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// writeAudit Creates or updates the audit ConfigMap of the cluster with the
+// report. The ConfigMap is owned by the Ipfs resource.
+func (r *IpfsReconciler) writeAudit(ctx context.Context, instance *clusterv1alpha1.Ipfs, report auditReport) error {
+	data, err := yaml.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("cannot serialize audit report: %w", err)
+	}
+	cm := corev1.ConfigMap{}
+	cm.Name = auditConfigMapName(instance)
+	cm.Namespace = instance.Namespace
+	_, err = controllerutil.CreateOrPatch(ctx, r.Client, &cm, func() error {
+		cm.Labels = ipfsLabels(instance, componentAudit)
+		cm.Data = map[string]string{auditReportKey: string(data)}
+		return ctrl.SetControllerReference(instance, &cm, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write audit report: %w", err)
+	}
+	return nil
+}
+
+// auditConfigMapName Returns the name of the ConfigMap holding the audit
+// report of the cluster.
+func auditConfigMapName(m *clusterv1alpha1.Ipfs) string {
+	return m.Name + "-audit"
+}
+
+// setDriftedCondition Sets the Drifted condition summing up the drift.
+func setDriftedCondition(instance *clusterv1alpha1.Ipfs, drift []driftedObject) {
+	condition := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionDrifted,
+		Status:             metav1.ConditionFalse,
+		Reason:             clusterv1alpha1.DriftedReasonObjectsMatch,
+		Message:            "the operator would change no object",
+		ObservedGeneration: instance.Generation,
+	}
+	if len(drift) > 0 {
+		objects := make([]string, 0, len(drift))
+		for _, d := range drift {
+			objects = append(objects, fmt.Sprintf("%s %s %s", d.Operation, d.Kind, d.Name))
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = clusterv1alpha1.DriftedReasonObjectsDiffer
+		condition.Message = fmt.Sprintf("the operator would %s; see ConfigMap %s",
+			strings.Join(objects, ", "), auditConfigMapName(instance))
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+}
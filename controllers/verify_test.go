@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs verify-only mode", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+		svcKey     types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	// report Returns the audit report of the cluster.
+	report := func() auditReport {
+		cm := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: key.Name + "-audit"},
+			cm)).To(Succeed())
+		audit := auditReport{}
+		Expect(yaml.Unmarshal([]byte(cm.Data[auditReportKey]), &audit)).To(Succeed())
+		return audit
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "verify"}
+		svcKey = types.NamespacedName{Namespace: key.Namespace, Name: "ipfs-cluster-" + key.Name}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 1
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("reports the objects of a new cluster without creating them", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		instance.Annotations = map[string]string{clusterv1alpha1.AnnotationVerifyOnly: "true"}
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+
+		instance = reconcile()
+		Expect(instance.Finalizers).To(BeEmpty())
+		err := fakeClient.Get(ctx, svcKey, &appsv1.StatefulSet{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		drift := report().Drift
+		Expect(drift).To(ContainElement(driftedObject{Kind: "StatefulSet", Name: svcKey.Name, Operation: driftCreate}))
+		Expect(drift).To(ContainElement(driftedObject{Kind: "Service", Name: svcKey.Name, Operation: driftCreate}))
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDrifted)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Message).To(ContainSubstring("create StatefulSet " + svcKey.Name))
+	})
+
+	It("reports exactly the fields changed by hand", func() {
+		for i := 0; i < 3; i++ {
+			reconcile()
+		}
+		reconciler.VerifyOnly = true
+		instance := reconcile()
+		Expect(report().Drift).To(BeEmpty())
+		cond := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDrifted)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.DriftedReasonObjectsMatch))
+
+		By("changing a port of the Service")
+		svc := &corev1.Service{}
+		Expect(fakeClient.Get(ctx, svcKey, svc)).To(Succeed())
+		svc.Spec.Ports[0].Port = 14001
+		Expect(fakeClient.Update(ctx, svc)).To(Succeed())
+		reconcile()
+		drift := report().Drift
+		Expect(drift).To(HaveLen(1))
+		Expect(drift[0].Kind).To(Equal("Service"))
+		Expect(drift[0].Operation).To(Equal(driftPatch))
+		Expect(drift[0].Fields).To(Equal([]string{"spec.ports"}))
+
+		By("leaving the Service as it is")
+		Expect(fakeClient.Get(ctx, svcKey, svc)).To(Succeed())
+		Expect(svc.Spec.Ports[0].Port).To(Equal(int32(14001)))
+
+		By("clearing the condition once reconciling again")
+		reconciler.VerifyOnly = false
+		instance = reconcile()
+		Expect(meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDrifted)).To(BeNil())
+		Expect(fakeClient.Get(ctx, svcKey, svc)).To(Succeed())
+		Expect(svc.Spec.Ports[0].Port).To(Equal(int32(portSwarm)))
+	})
+
+	It("lists the paths set or removed by a patch", func() {
+		fields, err := patchedFields([]byte(`{"metadata":{"labels":{"a":"b","c":null}},"spec":{"ports":[]}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fields).To(Equal([]string{"metadata.labels.a", "metadata.labels.c", "spec.ports"}))
+	})
+})
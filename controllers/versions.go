@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// syncVersionCombination Looks the kubo and ipfs-cluster images of the
+// resolved spec up in the compatibility table and records the outcome in the
+// UnverifiedVersionCombination condition. The images are checked after the
+// operator defaults apply, so the condition also covers clusters relying on
+// them, which the validating webhook does not check.
+func syncVersionCombination(instance, resolved *clusterv1alpha1.Ipfs) {
+	combination, message := clusterv1alpha1.CheckVersionCombination(resolved.Spec.IpfsImage, resolved.Spec.ClusterImage)
+	cond := metav1.Condition{
+		Type:               clusterv1alpha1.ConditionUnverifiedVersionCombination,
+		Status:             metav1.ConditionTrue,
+		Message:            message,
+		ObservedGeneration: instance.Generation,
+	}
+	switch combination {
+	case clusterv1alpha1.VersionCombinationSupported:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = clusterv1alpha1.UnverifiedVersionCombinationReasonSupported
+	case clusterv1alpha1.VersionCombinationUnsupported:
+		cond.Reason = clusterv1alpha1.UnverifiedVersionCombinationReasonUnsupported
+	default:
+		cond.Reason = clusterv1alpha1.UnverifiedVersionCombinationReasonUnknownVersion
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, cond)
+}
@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs version combination", func() {
+	combination := func(ipfsImage, clusterImage string) *metav1.Condition {
+		instance := &clusterv1alpha1.Ipfs{}
+		resolved := instance.DeepCopy()
+		resolved.Spec.IpfsImage = ipfsImage
+		resolved.Spec.ClusterImage = clusterImage
+		syncVersionCombination(instance, resolved)
+		return meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionUnverifiedVersionCombination)
+	}
+
+	It("clears the warning for the default images", func() {
+		cond := combination(ipfsImage, ipfsClusterImage)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(metav1.ConditionFalse))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UnverifiedVersionCombinationReasonSupported))
+	})
+
+	It("warns about digest-pinned images without refusing them", func() {
+		cond := combination("registry.example.com/ipfs/kubo@sha256:0123456789abcdef", ipfsClusterImage)
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UnverifiedVersionCombinationReasonUnknownVersion))
+	})
+
+	It("warns about the unsupported combinations which run anyway", func() {
+		cond := combination("ipfs/go-ipfs:v0.9.1", ipfsClusterImage)
+		Expect(cond.Status).To(Equal(metav1.ConditionTrue))
+		Expect(cond.Reason).To(Equal(clusterv1alpha1.UnverifiedVersionCombinationReasonUnsupported))
+		Expect(cond.Message).To(ContainSubstring("supports kubo 0.12-0.29"))
+	})
+})
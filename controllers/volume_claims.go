@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// claimTemplates Are the volume claim templates of the StatefulSet, which
+// name the claims of the peers <template>-ipfs-cluster-<name>-<ordinal>.
+var claimTemplates = []string{"cluster-storage", "ipfs-storage"}
+
+// isPeerClaim Returns whether the claim was created for a peer of the
+// cluster from a volume claim template. The name must end with the ordinal
+// right after the name of the cluster, so that the claims of a cluster whose
+// name starts with the same prefix are not taken.
+func isPeerClaim(m *clusterv1alpha1.Ipfs, name string) bool {
+	for _, template := range claimTemplates {
+		prefix := fmt.Sprintf("%s-ipfs-cluster-%s-", template, m.Name)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 32); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// volumesDeleted Returns whether the volume claims of the peers are deleted
+// along with the cluster.
+func volumesDeleted(m *clusterv1alpha1.Ipfs) bool {
+	return m.Spec.VolumeReclaimPolicy == clusterv1alpha1.VolumeReclaimDelete
+}
+
+// syncClaimLabels Labels the volume claims the StatefulSet created for the
+// peers as the other objects of the cluster, so that they can be listed by
+// cluster, and makes the cluster an owner of the claims when its volumes are
+// deleted along with it. The claims being deleted and those owned by another
+// Ipfs resource are left alone. Claims retained from a deleted cluster of the
+// same name are taken over.
+func (r *IpfsReconciler) syncClaimLabels(ctx context.Context, m *clusterv1alpha1.Ipfs) error {
+	claims := corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, &claims, client.InNamespace(m.Namespace)); err != nil {
+		return fmt.Errorf("cannot list volume claims: %w", err)
+	}
+	labels := ipfsLabels(m, componentPeer)
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if claim.DeletionTimestamp != nil || !isPeerClaim(m, claim.Name) || ownedByOtherIpfs(m, claim) {
+			continue
+		}
+		updated := claim.DeepCopy()
+		if updated.Labels == nil {
+			updated.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			updated.Labels[k] = v
+		}
+		if volumesDeleted(m) {
+			if err := controllerutil.SetOwnerReference(m, updated, r.Scheme); err != nil {
+				return err
+			}
+		} else {
+			removeOwnerReference(updated, m.UID)
+		}
+		if equality.Semantic.DeepEqual(claim.ObjectMeta, updated.ObjectMeta) {
+			continue
+		}
+		if err := r.Patch(ctx, updated, client.MergeFrom(claim)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("cannot label volume claim %s: %w", claim.Name, err)
+		}
+	}
+	return nil
+}
+
+// syncDeletionWarnings Sets the DeletionLosesData condition while deleting
+// the cluster loses data for good, so that the warnings the validating
+// webhook gives kubectl delete also show when it is not deployed.
+func syncDeletionWarnings(instance *clusterv1alpha1.Ipfs) {
+	warnings := instance.DeletionWarnings()
+	if len(warnings) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, clusterv1alpha1.ConditionDeletionLosesData)
+		return
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               clusterv1alpha1.ConditionDeletionLosesData,
+		Status:             metav1.ConditionTrue,
+		Reason:             clusterv1alpha1.DeletionLosesDataReasonNotRetained,
+		Message:            strings.Join(warnings, "; "),
+		ObservedGeneration: instance.Generation,
+	})
+}
+
+// ownedByOtherIpfs Returns whether an Ipfs resource other than the cluster
+// owns the object.
+func ownedByOtherIpfs(m *clusterv1alpha1.Ipfs, obj metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "Ipfs" && ref.UID != m.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// removeOwnerReference Removes the owner reference to the given UID from the
+// object.
+func removeOwnerReference(obj metav1.Object, uid types.UID) {
+	refs := obj.GetOwnerReferences()
+	kept := refs[:0]
+	for _, ref := range refs {
+		if ref.UID != uid {
+			kept = append(kept, ref)
+		}
+	}
+	obj.SetOwnerReferences(kept)
+}
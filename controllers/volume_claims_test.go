@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs volume claims", func() {
+	var (
+		ctx        context.Context
+		fakeClient client.Client
+		reconciler *IpfsReconciler
+		key        types.NamespacedName
+	)
+
+	reconcile := func() *clusterv1alpha1.Ipfs {
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		instance := &clusterv1alpha1.Ipfs{}
+		Expect(fakeClient.Get(ctx, key, instance)).To(Succeed())
+		return instance
+	}
+
+	newClaim := func(name string) *corev1.PersistentVolumeClaim {
+		claim := &corev1.PersistentVolumeClaim{}
+		claim.Name = name
+		claim.Namespace = key.Namespace
+		return claim
+	}
+
+	getClaim := func(name string) *corev1.PersistentVolumeClaim {
+		claim := &corev1.PersistentVolumeClaim{}
+		Expect(fakeClient.Get(ctx, types.NamespacedName{Namespace: key.Namespace, Name: name}, claim)).To(Succeed())
+		return claim
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key = types.NamespacedName{Namespace: "default", Name: "vol"}
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = key.Name
+		instance.Namespace = key.Namespace
+		instance.Spec.Replicas = 2
+		instance.Spec.IpfsStorage = "1Gi"
+		instance.Spec.ClusterStorage = "1Gi"
+		other := newClaim("ipfs-storage-ipfs-cluster-vol-archive-0")
+		fakeClient = fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance,
+			newClaim("cluster-storage-ipfs-cluster-vol-0"), newClaim("ipfs-storage-ipfs-cluster-vol-0"), other).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme}
+	})
+
+	It("labels the claims of the peers as they are created", func() {
+		reconcile()
+		instance := reconcile()
+		for _, name := range []string{"cluster-storage-ipfs-cluster-vol-0", "ipfs-storage-ipfs-cluster-vol-0"} {
+			claim := getClaim(name)
+			Expect(claim.Labels).To(HaveKeyWithValue(labelInstance, "vol"))
+			Expect(claim.Labels).To(HaveKeyWithValue(labelManagedBy, managedBy))
+			Expect(claim.OwnerReferences).To(BeEmpty())
+		}
+
+		By("leaving the claims of a cluster sharing the prefix of the name alone")
+		Expect(getClaim("ipfs-storage-ipfs-cluster-vol-archive-0").Labels).To(BeEmpty())
+
+		By("labeling the claims of a new peer on the next reconcile")
+		Expect(fakeClient.Create(ctx, newClaim("ipfs-storage-ipfs-cluster-vol-2"))).To(Succeed())
+		reconcile()
+		Expect(getClaim("ipfs-storage-ipfs-cluster-vol-2").Labels).To(HaveKeyWithValue(labelInstance, "vol"))
+
+		By("owning the claims once the volumes are deleted with the cluster")
+		instance.Spec.VolumeReclaimPolicy = clusterv1alpha1.VolumeReclaimDelete
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		claim := getClaim("ipfs-storage-ipfs-cluster-vol-0")
+		Expect(claim.OwnerReferences).To(ConsistOf(HaveField("UID", instance.UID)))
+		lost := meta.FindStatusCondition(instance.Status.Conditions, clusterv1alpha1.ConditionDeletionLosesData)
+		Expect(lost.Status).To(Equal(metav1.ConditionTrue))
+		Expect(lost.Message).To(ContainSubstring("deletes the volume claims of its peers"))
+		version := claim.ResourceVersion
+		reconcile()
+		Expect(getClaim("ipfs-storage-ipfs-cluster-vol-0").ResourceVersion).To(Equal(version))
+
+		By("releasing the claims once they are retained again")
+		instance.Spec.VolumeReclaimPolicy = clusterv1alpha1.VolumeReclaimRetain
+		Expect(fakeClient.Update(ctx, instance)).To(Succeed())
+		instance = reconcile()
+		Expect(getClaim("ipfs-storage-ipfs-cluster-vol-0").OwnerReferences).To(BeEmpty())
+		Expect(meta.FindStatusCondition(instance.Status.Conditions,
+			clusterv1alpha1.ConditionDeletionLosesData)).To(BeNil())
+	})
+
+	It("skips the claims owned by another cluster", func() {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = "vol"
+		instance.UID = "new"
+		claim := newClaim("ipfs-storage-ipfs-cluster-vol-1")
+		claim.OwnerReferences = []metav1.OwnerReference{{Kind: "Ipfs", Name: "vol", UID: "old"}}
+		Expect(ownedByOtherIpfs(instance, claim)).To(BeTrue())
+		claim.OwnerReferences[0].UID = "new"
+		Expect(ownedByOtherIpfs(instance, claim)).To(BeFalse())
+
+		Expect(isPeerClaim(instance, "ipfs-storage-ipfs-cluster-vol-12")).To(BeTrue())
+		Expect(isPeerClaim(instance, "cluster-storage-ipfs-cluster-vol-0")).To(BeTrue())
+		Expect(isPeerClaim(instance, "ipfs-storage-ipfs-cluster-vol-b-0")).To(BeFalse())
+		Expect(isPeerClaim(instance, "data-ipfs-cluster-vol-0")).To(BeFalse())
+	})
+})
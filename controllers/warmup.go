@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+// DefaultWarmupConcurrency Is the number of clusters reconciled at once
+// until each cluster found at startup was reconciled once.
+const DefaultWarmupConcurrency = 2
+
+// pendingInitialReconciles Is the number of clusters found at startup which
+// were not reconciled yet.
+var pendingInitialReconciles = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "ipfs_operator_pending_initial_reconciles",
+		Help: "Number of the clusters found when the operator started which were not reconciled yet.",
+	},
+)
+
+// warmup Paces the first reconcile of the clusters found when the operator
+// starts, so that they do not all list their objects at once. The clusters
+// are reconciled oldest first, at most a budget of them at once, whatever the
+// number of workers of the controller. The clusters which were reconciled at
+// their generation and are ready are not reconciled at once, but when their
+// status is next refreshed. Once every cluster was reconciled once, the
+// reconciles are no longer paced.
+type warmup struct {
+	mu      sync.Mutex
+	listed  bool
+	budget  int
+	pending []types.NamespacedName
+	started map[types.NamespacedName]bool
+	// events Enqueues the clusters whose turn came.
+	events chan event.GenericEvent
+}
+
+// warmupEvents Returns the channel enqueuing the clusters whose turn came
+// during the warm-up.
+func (w *warmup) warmupEvents() chan event.GenericEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.events == nil {
+		w.events = make(chan event.GenericEvent)
+	}
+	return w.events
+}
+
+// warmupOrder Sorts the clusters oldest first, then by namespace and name.
+func warmupOrder(items []clusterv1alpha1.Ipfs) []types.NamespacedName {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	keys := make([]types.NamespacedName, 0, len(items))
+	for i := range items {
+		keys = append(keys, types.NamespacedName{Namespace: items[i].Namespace, Name: items[i].Name})
+	}
+	return keys
+}
+
+// settled Returns whether the cluster was reconciled at its generation and is
+// ready, so that its first reconcile can wait for its next status refresh.
+func settled(m *clusterv1alpha1.Ipfs) bool {
+	return m.DeletionTimestamp == nil && m.Status.ObservedGeneration == m.Generation &&
+		meta.IsStatusConditionTrue(m.Status.Conditions, clusterv1alpha1.ConditionReady)
+}
+
+// admitWarmup Tells whether the cluster may be reconciled now. During the
+// warm-up, a cluster found at startup waits until it is among the budget of
+// oldest clusters not reconciled yet, and a settled one is deferred to its
+// next status refresh. The returned function must be called once the
+// reconcile of an admitted cluster ends.
+func (r *IpfsReconciler) admitWarmup(
+	ctx context.Context,
+	key types.NamespacedName,
+) (admitted bool, deferred ctrl.Result, done func(), err error) {
+	budget := r.Queue.WarmupConcurrency
+	if budget <= 0 {
+		return true, ctrl.Result{}, func() {}, nil
+	}
+	w := &r.warmup
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.listed {
+		ipfsList := clusterv1alpha1.IpfsList{}
+		if err = r.List(ctx, &ipfsList); err != nil {
+			return false, ctrl.Result{}, nil, fmt.Errorf("cannot list the clusters to warm up: %w", err)
+		}
+		w.listed = true
+		w.budget = budget
+		w.pending = warmupOrder(ipfsList.Items)
+		w.started = map[types.NamespacedName]bool{}
+		pendingInitialReconciles.Set(float64(len(w.pending)))
+	}
+	position := w.position(key)
+	if position < 0 || w.started[key] {
+		// Clusters created since the start, or whose first reconcile runs,
+		// are no longer paced.
+		return true, ctrl.Result{}, func() {}, nil
+	}
+	instance := clusterv1alpha1.Ipfs{}
+	if err = r.Get(ctx, key, &instance); err == nil && settled(&instance) {
+		w.remove(position)
+		return false, ctrl.Result{RequeueAfter: r.Defaults.Get().StatusSyncInterval.Duration}, nil, nil
+	}
+	if position >= budget {
+		// The cluster is enqueued again once its turn comes.
+		return false, ctrl.Result{}, nil, nil
+	}
+	w.started[key] = true
+	return true, ctrl.Result{}, func() { w.finish(key) }, nil
+}
+
+// position Returns the position of the cluster among the clusters not
+// reconciled yet, or -1 when it is not one of them.
+func (w *warmup) position(key types.NamespacedName) int {
+	for i, pending := range w.pending {
+		if pending == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// finish Records the first reconcile of the cluster, then enqueues the
+// clusters whose turn came.
+func (w *warmup) finish(key types.NamespacedName) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.started, key)
+	if position := w.position(key); position >= 0 {
+		w.remove(position)
+	}
+}
+
+// remove Removes the cluster at the given position from the clusters not
+// reconciled yet, and enqueues the clusters within the budget which did not
+// start their first reconcile.
+func (w *warmup) remove(position int) {
+	w.pending = append(w.pending[:position], w.pending[position+1:]...)
+	pendingInitialReconciles.Set(float64(len(w.pending)))
+	if w.events == nil {
+		return
+	}
+	var waiting []types.NamespacedName
+	for i := 0; i < len(w.pending) && i < w.budget; i++ {
+		if !w.started[w.pending[i]] {
+			waiting = append(waiting, w.pending[i])
+		}
+	}
+	events := w.events
+	go func() {
+		for _, key := range waiting {
+			ipfs := &clusterv1alpha1.Ipfs{}
+			ipfs.Namespace = key.Namespace
+			ipfs.Name = key.Name
+			events <- event.GenericEvent{Object: ipfs}
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
+)
+
+var _ = Describe("Ipfs warm-up", func() {
+	var (
+		ctx        context.Context
+		reconciler *IpfsReconciler
+	)
+
+	key := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: "default", Name: name}
+	}
+
+	cluster := func(name string, age time.Duration) client.Object {
+		instance := &clusterv1alpha1.Ipfs{}
+		instance.Name = name
+		instance.Namespace = "default"
+		instance.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+		return instance
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(clusterv1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		ready := cluster("ready", 2*time.Hour).(*clusterv1alpha1.Ipfs)
+		ready.Generation = 3
+		ready.Status.ObservedGeneration = 3
+		ready.Status.Conditions = []metav1.Condition{{Type: clusterv1alpha1.ConditionReady,
+			Status: metav1.ConditionTrue, Reason: "Ready", LastTransitionTime: metav1.Now()}}
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).
+			WithObjects(cluster("newest", time.Hour), ready, cluster("oldest", 3*time.Hour)).Build()
+		reconciler = &IpfsReconciler{Client: fakeClient, Scheme: scheme,
+			Queue: QueueOptions{WarmupConcurrency: 1}}
+	})
+
+	It("reconciles the clusters found at startup oldest first, within the budget", func() {
+		events := reconciler.warmup.warmupEvents()
+		admitted, deferred, _, err := reconciler.admitWarmup(ctx, key("newest"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted).To(BeFalse())
+		Expect(deferred).To(BeZero())
+		Expect(testutil.ToFloat64(pendingInitialReconciles)).To(Equal(3.0))
+
+		admitted, _, done, err := reconciler.admitWarmup(ctx, key("oldest"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted).To(BeTrue())
+
+		By("deferring the settled clusters to their next status refresh")
+		admitted, deferred, _, err = reconciler.admitWarmup(ctx, key("ready"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted).To(BeFalse())
+		Expect(deferred.RequeueAfter).To(Equal(builtinDefaults().StatusSyncInterval.Duration))
+		Expect(testutil.ToFloat64(pendingInitialReconciles)).To(Equal(2.0))
+
+		By("enqueuing the next cluster once the first reconcile ends")
+		done()
+		Expect((<-events).Object.GetName()).To(Equal("newest"))
+		admitted, _, done, err = reconciler.admitWarmup(ctx, key("newest"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted).To(BeTrue())
+		done()
+		Expect(testutil.ToFloat64(pendingInitialReconciles)).To(BeZero())
+
+		By("no longer pacing the reconciles once every cluster was reconciled")
+		admitted, _, _, err = reconciler.admitWarmup(ctx, key("oldest"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted).To(BeTrue())
+	})
+
+	It("does not pace the reconciles when disabled", func() {
+		reconciler.Queue.WarmupConcurrency = 0
+		admitted, _, done, err := reconciler.admitWarmup(ctx, key("newest"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(admitted).To(BeTrue())
+		done()
+	})
+})
@@ -17,8 +17,14 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,11 +34,18 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	clusterv1alpha1 "github.com/redhat-et/ipfs-operator/api/v1alpha1"
 	"github.com/redhat-et/ipfs-operator/controllers"
+	"github.com/redhat-et/ipfs-operator/pkg/apiproxy"
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+	"github.com/redhat-et/ipfs-operator/pkg/connpool"
+	"github.com/redhat-et/ipfs-operator/pkg/preflight"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,21 +58,46 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// The build of the operator, set by the linker.
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(clusterv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
+// options Are the command line options of the operator.
+type options struct {
+	metricsAddr          string
+	enableLeaderElection bool
+	probeAddr            string
+	defaultsFile         string
+	defaultsFlags        controllers.DefaultsFlags
+	uninstallDrain       bool
+	runPreflight         bool
+	runAPIProxy          bool
+	queue                controllers.QueueOptions
+	kubeAPIQPS           float64
+	kubeAPIBurst         int
+	diffLogLevel         int
+	verifyOnly           bool
+	apiBreaker           breaker.Settings
+	apiPool              connpool.Settings
+	dashboardAddr        string
+	dashboardTokenSecret string
+	dashboardCertDir     string
+	fleetReport          controllers.FleetReporter
+	fleetReportObject    bool
+	fleetHealthInterval  time.Duration
+}
+
 func main() {
-	var metricsAddr string
-	var enableLeaderElection bool
-	var probeAddr string
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
-	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
+	var o options
+	bindFlags(&o)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -68,12 +106,28 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	if o.uninstallDrain {
+		drain()
+		return
+	}
+	if o.runPreflight {
+		checkPeer()
+		return
+	}
+	if o.runAPIProxy {
+		proxyClusterAPI()
+		return
+	}
+
+	cfg := ctrl.GetConfigOrDie()
+	cfg.QPS = float32(o.kubeAPIQPS)
+	cfg.Burst = o.kubeAPIBurst
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
+		MetricsBindAddress:     o.metricsAddr,
 		Port:                   MgrPort,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
+		HealthProbeBindAddress: o.probeAddr,
+		LeaderElection:         o.enableLeaderElection,
 		LeaderElectionID:       "658003f6.ipfs.io",
 	})
 	if err != nil {
@@ -81,20 +135,30 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.IpfsReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Ipfs")
-		os.Exit(1)
+	defaults := setupDefaults(mgr, o.defaultsFile, o.defaultsFlags)
+
+	ipfsReconciler := setupIpfsController(mgr, defaults, o.queue, o.apiBreaker, o.apiPool, o.diffLogLevel,
+		o.verifyOnly)
+	setupOperatorConfigController(mgr, defaults)
+	if !o.verifyOnly {
+		setupCircuitRelayController(mgr)
+		setupContentController(mgr, ipfsReconciler)
+		setupIngestController(mgr, ipfsReconciler)
 	}
-	if err = (&controllers.CircuitRelayReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "CircuitRelay")
+	if err = mgr.Add(controllers.PermissionCheck{Client: mgr.GetClient(), Mapper: mgr.GetRESTMapper()}); err != nil {
+		setupLog.Error(err, "unable to check the permissions of the operator")
 		os.Exit(1)
 	}
+	if o.dashboardAddr != "" {
+		setupDashboard(mgr, o.dashboardAddr, o.dashboardTokenSecret, o.dashboardCertDir)
+	}
+	if o.fleetReport.Interval > 0 {
+		setupFleetReport(mgr, defaults, o.fleetReport, o.fleetReportObject && !o.verifyOnly)
+	}
+	if webhooksEnabled() {
+		setupWebhooks(mgr, defaults)
+	}
+	setupFleetHealth(mgr, o.fleetHealthInterval, o.verifyOnly)
 	//+kubebuilder:scaffold:builder
 
 	if err = mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -112,3 +176,415 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// bindFlags Binds the command line flags of the operator.
+func bindFlags(o *options) {
+	flag.StringVar(&o.metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&o.probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.BoolVar(&o.enableLeaderElection, "leader-elect", false,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&o.defaultsFile, "defaults-file", "",
+		"Path to a file holding the defaults used wherever an Ipfs resource leaves a field empty.")
+	bindDefaultsFlags(&o.defaultsFlags)
+	flag.BoolVar(&o.uninstallDrain, "uninstall-drain", false,
+		"Delete every Ipfs resource and run its finalizer, then exit. Run it before uninstalling the operator.")
+	flag.BoolVar(&o.runPreflight, "preflight", false,
+		"Check the network of an ipfs peer and report the results in the termination log, then exit.")
+	flag.BoolVar(&o.runAPIProxy, "cluster-api-proxy", false,
+		"Serve the REST API of an ipfs-cluster peer to the admin and read-only credentials of its cluster.")
+	bindQueueFlags(&o.queue, &o.kubeAPIQPS, &o.kubeAPIBurst)
+	bindBreakerFlags(&o.apiBreaker)
+	bindPoolFlags(&o.apiPool)
+	flag.IntVar(&o.diffLogLevel, "diff-log-level", -1,
+		"Log the changes made to the objects of every Ipfs resource at this verbosity. Disabled when negative.")
+	flag.BoolVar(&o.verifyOnly, "verify-only", false,
+		"Audit every Ipfs resource and report the changes the operator would make, without making them.")
+	bindDashboardFlags(o)
+	bindFleetFlags(o)
+}
+
+// bindDashboardFlags Binds the flags serving the dashboard.
+func bindDashboardFlags(o *options) {
+	flag.StringVar(&o.dashboardAddr, "dashboard-bind-address", "",
+		"The address the read-only dashboard summing up every Ipfs resource binds to. Disabled when empty.")
+	flag.StringVar(&o.dashboardTokenSecret, "dashboard-token-secret", "",
+		"The namespace/name of a Secret whose token key holds the bearer token of the dashboard. "+
+			"When empty, the tokens are Kubernetes tokens allowed to get the "+controllers.DashboardPath+" URL.")
+	flag.StringVar(&o.dashboardCertDir, "dashboard-cert-dir", "",
+		"The directory holding the tls.crt and tls.key files the dashboard is served over TLS with. "+
+			"When empty, the dashboard is served over plain HTTP and must bind a loopback address.")
+}
+
+// bindFleetFlags Binds the flags tuning the report and the health of the
+// fleet.
+func bindFleetFlags(o *options) {
+	flag.DurationVar(&o.fleetReport.Interval, "fleet-report-interval", controllers.DefaultFleetReportInterval,
+		"How often the leader maps every Ipfs resource to the nodes, storage classes and relays it shares "+
+			"with the others, and exports the report as metrics. Disabled when zero.")
+	flag.IntVar(&o.fleetReport.RelayThreshold, "fleet-report-relay-threshold", controllers.DefaultFleetRelayThreshold,
+		"Number of Ipfs resources depending on the same circuit relay from which the fleet report flags it.")
+	flag.BoolVar(&o.fleetReportObject, "fleet-report-object", false,
+		"Also write the fleet report to the cluster-scoped IpfsFleetReport named "+
+			clusterv1alpha1.FleetReportName+".")
+	flag.DurationVar(&o.fleetHealthInterval, "fleet-health-interval", controllers.DefaultFleetHealthInterval,
+		"How often the share of the Ipfs resources which are ready is summarized, served at "+
+			controllers.FleetHealthPath+" and, under OLM, written to the OperatorCondition of the operator.")
+}
+
+// webhooksEnabled Returns whether the operator serves its webhooks, as the
+// deployment patch of the webhooks tells it to.
+func webhooksEnabled() bool {
+	return os.Getenv("ENABLE_WEBHOOKS") == "true"
+}
+
+// setupWebhooks Registers the validating webhook of the Ipfs resources and
+// the mutating webhook of the peer pods.
+func setupWebhooks(mgr ctrl.Manager, defaults *controllers.DefaultsStore) {
+	budgets := controllers.Budgets{Reader: mgr.GetAPIReader(), Defaults: defaults}
+	if err := (&clusterv1alpha1.Ipfs{}).SetupWebhookWithManager(mgr, budgets); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Ipfs")
+		os.Exit(1)
+	}
+	mgr.GetWebhookServer().Register(controllers.PeerPodWebhookPath,
+		&webhook.Admission{Handler: controllers.PeerPodOverrides{Reader: mgr.GetClient()}})
+}
+
+// bindQueueFlags Binds the flags tuning how fast the operator works through
+// the Ipfs resources and how hard it queries the API server. Their defaults
+// are those of controller-runtime.
+func bindQueueFlags(queue *controllers.QueueOptions, kubeAPIQPS *float64, kubeAPIBurst *int) {
+	flag.IntVar(&queue.MaxConcurrentReconciles, "max-concurrent-reconciles",
+		controllers.DefaultMaxConcurrentReconciles,
+		"Number of Ipfs resources reconciled at once.")
+	flag.DurationVar(&queue.BaseDelay, "reconcile-base-delay", controllers.DefaultBaseDelay,
+		"Delay before a failed reconcile is retried, doubled on each failure.")
+	flag.DurationVar(&queue.MaxDelay, "reconcile-max-delay", controllers.DefaultMaxDelay,
+		"Longest delay before a failed reconcile is retried.")
+	flag.DurationVar(&queue.Coalesce, "reconcile-coalesce", 0,
+		"Collect the events about the objects of an Ipfs resource over this delay before reconciling it once. "+
+			"Disabled when zero.")
+	flag.IntVar(&queue.WarmupConcurrency, "warmup-concurrency", controllers.DefaultWarmupConcurrency,
+		"Number of Ipfs resources reconciled at once after a start, oldest first, until each was reconciled once. "+
+			"Disabled when zero.")
+	flag.Float64Var(kubeAPIQPS, "kube-api-qps", 20, "Queries per second the operator sends to the API server.")
+	flag.IntVar(kubeAPIBurst, "kube-api-burst", 30, "Burst of queries the operator sends to the API server.")
+}
+
+// bindBreakerFlags Binds the flags tuning the circuit breakers guarding the
+// calls to the APIs of the clusters.
+func bindBreakerFlags(settings *breaker.Settings) {
+	flag.IntVar(&settings.Threshold, "api-breaker-threshold", breaker.DefaultThreshold,
+		"Consecutive failed calls to an API of an Ipfs resource after which the calls stop.")
+	flag.DurationVar(&settings.Cooldown, "api-breaker-cooldown", breaker.DefaultCooldown,
+		"Delay before a single call probes an API whose calls stopped.")
+	flag.DurationVar(&settings.Deadline, "api-call-deadline", breaker.DefaultDeadline,
+		"Longest time a call to an API of an Ipfs resource may take, its retries included.")
+}
+
+// bindPoolFlags Binds the flags bounding the connections the operator opens
+// to the APIs of the peers.
+func bindPoolFlags(settings *connpool.Settings) {
+	flag.IntVar(&settings.MaxInFlight, "api-max-in-flight", connpool.DefaultMaxInFlight,
+		"Most calls to the APIs of the peers in flight at once, across every Ipfs resource.")
+	flag.IntVar(&settings.MaxPerHost, "api-max-per-host", connpool.DefaultMaxPerHost,
+		"Most calls to the API of a single peer in flight at once.")
+	flag.IntVar(&settings.MaxIdlePerHost, "api-max-idle-per-host", connpool.DefaultMaxIdlePerHost,
+		"Idle connections kept open to the API of each peer.")
+	flag.DurationVar(&settings.IdleTimeout, "api-idle-timeout", connpool.DefaultIdleTimeout,
+		"Delay after which an idle connection to the API of a peer is closed.")
+}
+
+// bindDefaultsFlags Binds the flags setting operator defaults, which take
+// precedence over the defaults file and the IpfsOperatorConfig.
+func bindDefaultsFlags(flags *controllers.DefaultsFlags) {
+	flag.StringVar(&flags.IpfsImage, "ipfs-image", "",
+		"The image of the ipfs containers of the Ipfs resources which do not set one.")
+	flag.StringVar(&flags.ClusterImage, "cluster-image", "",
+		"The image of the ipfs-cluster containers of the Ipfs resources which do not set one.")
+	flag.StringVar(&flags.AuthProxyImage, "auth-proxy-image", "",
+		"The image of the authenticating proxy of the Ipfs resources which do not set one.")
+}
+
+// setupDefaults Loads the operator defaults, which the manager watches for
+// changes and serves on the metrics endpoint.
+func setupDefaults(
+	mgr ctrl.Manager,
+	defaultsFile string,
+	flags controllers.DefaultsFlags,
+) *controllers.DefaultsStore {
+	defaults, err := controllers.NewDefaultsStore(defaultsFile)
+	if err != nil {
+		setupLog.Error(err, "unable to load operator defaults")
+		os.Exit(1)
+	}
+	defaults.SetFlags(flags)
+	setupLog.Info("operator defaults", "defaults", defaults.Get())
+	if err := mgr.Add(defaults); err != nil {
+		setupLog.Error(err, "unable to watch operator defaults")
+		os.Exit(1)
+	}
+	if err := mgr.AddMetricsExtraHandler("/configz", defaults); err != nil {
+		setupLog.Error(err, "unable to serve operator defaults")
+		os.Exit(1)
+	}
+	return defaults
+}
+
+// setupIpfsController Sets up the controller of the Ipfs resources, and serves
+// their readiness on the metrics endpoint.
+func setupIpfsController(
+	mgr ctrl.Manager,
+	defaults *controllers.DefaultsStore,
+	queue controllers.QueueOptions,
+	apiBreaker breaker.Settings,
+	apiPool connpool.Settings,
+	diffLogLevel int,
+	verifyOnly bool,
+) *controllers.IpfsReconciler {
+	image := operatorImage(mgr.GetAPIReader())
+	if image == "" {
+		setupLog.Info("cannot tell the operator image, the peers will not run preflight checks")
+	}
+	pool := connpool.New(apiPool)
+	metrics.Registry.MustRegister(pool.Collectors()...)
+	reconciler := &controllers.IpfsReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Defaults:          defaults,
+		APIReader:         mgr.GetAPIReader(),
+		Recorder:          mgr.GetEventRecorderFor("ipfs-controller"),
+		OperatorImage:     image,
+		Queue:             queue,
+		APIBreaker:        apiBreaker,
+		Pool:              pool,
+		DiffLogLevel:      logLevel(diffLogLevel),
+		VerifyOnly:        verifyOnly,
+		Webhooks:          webhooksEnabled(),
+		Build:             controllers.BuildInfo{Version: version, Commit: commit},
+		OperatorNamespace: os.Getenv("POD_NAMESPACE"),
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Ipfs")
+		os.Exit(1)
+	}
+	readiness := controllers.ReadinessHandler{Reader: mgr.GetClient()}
+	if err := mgr.AddMetricsExtraHandler(controllers.ReadinessPathPrefix, readiness); err != nil {
+		setupLog.Error(err, "unable to serve the readiness of the clusters")
+		os.Exit(1)
+	}
+	return reconciler
+}
+
+// setupDashboard Serves the summary of the clusters on the given address,
+// over TLS when a certificate directory is given. The requests bear the token
+// of the given Secret, or a Kubernetes token when it is empty.
+func setupDashboard(mgr ctrl.Manager, addr string, tokenSecret string, certDir string) {
+	var authorizer controllers.DashboardAuthorizer = controllers.TokenReviewAuthorizer{Client: mgr.GetClient()}
+	if tokenSecret != "" {
+		parts := strings.Split(tokenSecret, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(nil, "the dashboard token secret must be namespace/name", "secret", tokenSecret)
+			os.Exit(1)
+		}
+		authorizer = controllers.StaticTokenAuthorizer{
+			Reader: mgr.GetClient(),
+			Secret: client.ObjectKey{Namespace: parts[0], Name: parts[1]},
+		}
+	}
+	dashboard := &controllers.DashboardServer{
+		Addr:    addr,
+		Handler: controllers.DashboardHandler{Reader: mgr.GetClient(), Authorizer: authorizer},
+	}
+	if certDir != "" {
+		dashboard.CertFile = filepath.Join(certDir, "tls.crt")
+		dashboard.KeyFile = filepath.Join(certDir, "tls.key")
+	}
+	if err := mgr.Add(dashboard); err != nil {
+		setupLog.Error(err, "unable to serve the dashboard")
+		os.Exit(1)
+	}
+}
+
+// setupFleetReport Builds the fleet report on the leader from the cache of
+// the manager, writing it to the IpfsFleetReport when asked to.
+func setupFleetReport(
+	mgr ctrl.Manager,
+	defaults *controllers.DefaultsStore,
+	reporter controllers.FleetReporter,
+	writeObject bool,
+) {
+	reporter.Reader = mgr.GetClient()
+	reporter.Defaults = defaults
+	if writeObject {
+		reporter.Writer = mgr.GetClient()
+	}
+	if err := mgr.Add(&reporter); err != nil {
+		setupLog.Error(err, "unable to build the fleet report")
+		os.Exit(1)
+	}
+}
+
+// setupFleetHealth Summarizes the health of the fleet on every replica from
+// the cache of the manager, for the readyz check of the operator, the metrics
+// server and, under OLM and unless in verify-only mode, the OperatorCondition
+// of the operator.
+func setupFleetHealth(mgr ctrl.Manager, interval time.Duration, verifyOnly bool) {
+	reporter := &controllers.FleetHealthReporter{
+		Reader:   mgr.GetClient(),
+		Elected:  mgr.Elected(),
+		Interval: interval,
+	}
+	if name, ok := controllers.OperatorConditionFromEnv(); ok && !verifyOnly {
+		reporter.Writer = mgr.GetClient()
+		reporter.OperatorCondition = name
+	} else {
+		setupLog.Info("not running under OLM, the health of the fleet is not written to an OperatorCondition")
+	}
+	if err := mgr.Add(reporter); err != nil {
+		setupLog.Error(err, "unable to summarize the health of the fleet")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("ipfs-fleet", reporter.Check); err != nil {
+		setupLog.Error(err, "unable to set up the fleet health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddMetricsExtraHandler(controllers.FleetHealthPath, reporter); err != nil {
+		setupLog.Error(err, "unable to serve the health of the fleet")
+		os.Exit(1)
+	}
+}
+
+// setupCircuitRelayController Sets up the controller of the circuit relays.
+// It does not run in verify-only mode, as it would change the relays.
+func setupCircuitRelayController(mgr ctrl.Manager) {
+	if err := (&controllers.CircuitRelayReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CircuitRelay")
+		os.Exit(1)
+	}
+}
+
+// setupContentController Sets up the controller of the IpfsContent
+// resources, which reaches the clusters as the Ipfs controller does. It does
+// not run in verify-only mode, as it would add and pin content.
+func setupContentController(mgr ctrl.Manager, clusters *controllers.IpfsReconciler) {
+	if err := (&controllers.ContentReconciler{
+		Client:   mgr.GetClient(),
+		Clusters: clusters,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IpfsContent")
+		os.Exit(1)
+	}
+}
+
+// setupIngestController Sets up the controller of the IpfsIngest resources,
+// which reaches the clusters as the Ipfs controller does. It does not run in
+// verify-only mode, as it would add and pin directories.
+func setupIngestController(mgr ctrl.Manager, clusters *controllers.IpfsReconciler) {
+	if err := (&controllers.IngestReconciler{
+		Client:   mgr.GetClient(),
+		Clusters: clusters,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IpfsIngest")
+		os.Exit(1)
+	}
+}
+
+// setupOperatorConfigController Sets up the controller of the
+// IpfsOperatorConfig, which feeds the operator defaults. It also runs in
+// verify-only mode, so that the audit uses the same defaults.
+func setupOperatorConfigController(mgr ctrl.Manager, defaults *controllers.DefaultsStore) {
+	if err := (&controllers.OperatorConfigReconciler{
+		Client:    mgr.GetClient(),
+		APIReader: mgr.GetAPIReader(),
+		Defaults:  defaults,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IpfsOperatorConfig")
+		os.Exit(1)
+	}
+}
+
+// logLevel Returns the given verbosity, or nil when it is negative.
+func logLevel(level int) *int {
+	if level < 0 {
+		return nil
+	}
+	return &level
+}
+
+// drain Finalizes every Ipfs resource without starting the manager.
+func drain() {
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+	ctx := ctrl.LoggerInto(context.Background(), ctrl.Log.WithName("drain"))
+	if err = (&controllers.IpfsReconciler{Client: c, Scheme: scheme}).DrainIpfs(ctx); err != nil {
+		setupLog.Error(err, "unable to drain ipfs resources")
+		os.Exit(1)
+	}
+	setupLog.Info("drained every ipfs resource")
+}
+
+// checkPeer Runs the preflight checks of an ipfs peer. It never fails, so that
+// a failing check only shows in the status of the Ipfs resource.
+func checkPeer() {
+	log := ctrl.Log.WithName("preflight")
+	cfg, err := preflight.ConfigFromEnv()
+	if err != nil {
+		log.Error(err, "unable to configure preflight checks")
+		return
+	}
+	report := preflight.Run(context.Background(), cfg)
+	for _, res := range report.Failed() {
+		log.Info("preflight check failed", "check", res.Name, "error", res.Error)
+	}
+	if err = preflight.Write(preflight.TerminationLog, report); err != nil {
+		log.Error(err, "unable to write preflight report")
+	}
+}
+
+// proxyClusterAPI Serves the REST API of the ipfs-cluster peer it runs next
+// to, scoping the credentials of the cluster.
+func proxyClusterAPI() {
+	log := ctrl.Log.WithName("cluster-api-proxy")
+	cfg, err := apiproxy.ConfigFromEnv()
+	if err != nil {
+		log.Error(err, "unable to configure the cluster API proxy")
+		os.Exit(1)
+	}
+	log.Info("serving the cluster API", "address", cfg.Listen, "upstream", cfg.Upstream.String())
+	if err = apiproxy.Run(cfg); err != nil {
+		log.Error(err, "cluster API proxy failed")
+		os.Exit(1)
+	}
+}
+
+// operatorImage Returns the image the operator runs, which the peers run
+// their preflight checks with. It is read from the OPERATOR_IMAGE variable,
+// or else from the pod of the operator.
+func operatorImage(reader client.Reader) string {
+	if image := os.Getenv("OPERATOR_IMAGE"); image != "" {
+		return image
+	}
+	name, namespace := os.Getenv("POD_NAME"), os.Getenv("POD_NAMESPACE")
+	if name == "" || namespace == "" {
+		return ""
+	}
+	pod := &corev1.Pod{}
+	key := client.ObjectKey{Namespace: namespace, Name: name}
+	if err := reader.Get(context.Background(), key, pod); err != nil {
+		setupLog.Error(err, "unable to read the operator pod")
+		return ""
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == "manager" {
+			return c.Image
+		}
+	}
+	return ""
+}
@@ -0,0 +1,232 @@
+// Package apiproxy Scopes the credentials of the REST API of an ipfs-cluster
+// peer. The REST API only knows a flat list of users, all of them allowed
+// everything, so the proxy runs next to it, authenticates the requests
+// against the credentials mounted from the API Secret of the cluster and
+// forwards them with the credentials of the REST API itself. The admin
+// credentials may send any request, while the read-only credentials may only
+// list pins, their status and the health of the cluster.
+package apiproxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+// Environment variables the proxy is configured from.
+const (
+	// ListenEnv Holds the address the proxy listens on.
+	ListenEnv = "API_PROXY_LISTEN"
+	// UpstreamEnv Holds the URL of the REST API the requests are forwarded to.
+	UpstreamEnv = "API_PROXY_UPSTREAM"
+	// CredentialsEnv Holds the directory the API Secret is mounted in.
+	CredentialsEnv = "API_PROXY_CREDENTIALS"
+)
+
+const (
+	// UpstreamCredentialsKey Holds the credentials of the REST API itself, in
+	// the user:password format of its basic_auth_credentials. They are
+	// allowed every request, as the admin credentials are.
+	UpstreamCredentialsKey = "CLUSTER_RESTAPI_BASICAUTHCREDENTIALS"
+	// DefaultReload Is how long the credentials are cached, so that the
+	// rotated ones are picked up once the kubelet updated the mounted files.
+	DefaultReload = 10 * time.Second
+)
+
+// Scopes of the credentials.
+const (
+	ScopeAdmin    = "admin"
+	ScopeReadOnly = "read-only"
+)
+
+// Credentials Are a username and password pair.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// matches Returns whether the request carries the credentials, in constant
+// time.
+func (c Credentials) matches(username, password string) bool {
+	if c.Username == "" || c.Password == "" {
+		return false
+	}
+	user := subtle.ConstantTimeCompare([]byte(c.Username), []byte(username))
+	pass := subtle.ConstantTimeCompare([]byte(c.Password), []byte(password))
+	return user&pass == 1
+}
+
+// Set Holds every credential the proxy knows of.
+type Set struct {
+	Admin    Credentials
+	ReadOnly Credentials
+	Upstream Credentials
+}
+
+// scope Returns the scope of the given credentials, or an empty string when
+// they match none.
+func (s Set) scope(username, password string) string {
+	switch {
+	case s.Admin.matches(username, password), s.Upstream.matches(username, password):
+		return ScopeAdmin
+	case s.ReadOnly.matches(username, password):
+		return ScopeReadOnly
+	}
+	return ""
+}
+
+// Allowed Returns whether credentials of the given scope may send a request
+// with the given method. Every mutation of the REST API, from pinning to
+// removing a peer, uses another method than GET or HEAD.
+func Allowed(scope, method string) bool {
+	switch scope {
+	case ScopeAdmin:
+		return true
+	case ScopeReadOnly:
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	}
+	return false
+}
+
+// Handler Authenticates the requests and forwards the allowed ones to the
+// REST API.
+type Handler struct {
+	// Credentials Returns the credentials the requests are authenticated
+	// against.
+	Credentials func() (Set, error)
+	proxy       *httputil.ReverseProxy
+}
+
+// NewHandler Returns a handler forwarding the allowed requests to the REST
+// API at the given URL.
+func NewHandler(upstream *url.URL, credentials func() (Set, error)) *Handler {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	// Pins are listed as a stream, which must reach the client as it comes.
+	proxy.FlushInterval = -1
+	return &Handler{Credentials: credentials, proxy: proxy}
+}
+
+// ServeHTTP Rejects the unauthenticated requests with 401 and the requests
+// out of the scope of their credentials with 403.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	set, err := h.Credentials()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot read credentials: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	username, password, ok := req.BasicAuth()
+	scope := ""
+	if ok {
+		scope = set.scope(username, password)
+	}
+	if scope == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ipfs-cluster"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !Allowed(scope, req.Method) {
+		http.Error(w, fmt.Sprintf("%s credentials cannot %s %s", scope, req.Method, req.URL.Path),
+			http.StatusForbidden)
+		return
+	}
+	req.SetBasicAuth(set.Upstream.Username, set.Upstream.Password)
+	h.proxy.ServeHTTP(w, req)
+}
+
+// FileCredentials Reads the credentials from the files of a mounted Secret,
+// caching them for the reload interval.
+type FileCredentials struct {
+	Dir    string
+	Reload time.Duration
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	set      Set
+}
+
+// Get Returns the credentials, reading them again once the cache expired.
+func (f *FileCredentials) Get() (Set, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.loadedAt.IsZero() && time.Since(f.loadedAt) < f.Reload {
+		return f.set, nil
+	}
+	read := func(key string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(f.Dir, key))
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return strings.TrimSpace(string(data)), err
+	}
+	var set Set
+	var upstream string
+	for key, value := range map[string]*string{
+		clusterapi.UsernameKey:         &set.Admin.Username,
+		clusterapi.PasswordKey:         &set.Admin.Password,
+		clusterapi.ReadOnlyUsernameKey: &set.ReadOnly.Username,
+		clusterapi.ReadOnlyPasswordKey: &set.ReadOnly.Password,
+		UpstreamCredentialsKey:         &upstream,
+	} {
+		var err error
+		if *value, err = read(key); err != nil {
+			return Set{}, err
+		}
+	}
+	parts := strings.SplitN(upstream, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Set{}, fmt.Errorf("%s does not hold user:password credentials", UpstreamCredentialsKey)
+	}
+	set.Upstream = Credentials{Username: parts[0], Password: parts[1]}
+	f.set, f.loadedAt = set, time.Now()
+	return set, nil
+}
+
+// Config Describes where the proxy listens and forwards to.
+type Config struct {
+	Listen      string
+	Upstream    *url.URL
+	Credentials string
+}
+
+// ConfigFromEnv Returns the proxy configured in the environment.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Listen:      os.Getenv(ListenEnv),
+		Credentials: os.Getenv(CredentialsEnv),
+	}
+	for env, value := range map[string]string{
+		ListenEnv:      cfg.Listen,
+		UpstreamEnv:    os.Getenv(UpstreamEnv),
+		CredentialsEnv: cfg.Credentials,
+	} {
+		if value == "" {
+			return cfg, fmt.Errorf("%s is not set", env)
+		}
+	}
+	upstream, err := url.Parse(os.Getenv(UpstreamEnv))
+	if err != nil {
+		return cfg, fmt.Errorf("invalid %s: %w", UpstreamEnv, err)
+	}
+	cfg.Upstream = upstream
+	return cfg, nil
+}
+
+// Run Serves the proxy until it fails.
+func Run(cfg Config) error {
+	creds := &FileCredentials{Dir: cfg.Credentials, Reload: DefaultReload}
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           NewHandler(cfg.Upstream, creds.Get),
+		ReadHeaderTimeout: DefaultReload,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,112 @@
+package apiproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API proxy", func() {
+	var (
+		upstream *httptest.Server
+		proxy    *httptest.Server
+		received []string
+	)
+
+	BeforeEach(func() {
+		received = nil
+		upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if user, pass, ok := req.BasicAuth(); !ok || user != "root" || pass != "internal" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			received = append(received, req.Method+" "+req.URL.Path)
+			w.WriteHeader(http.StatusOK)
+		}))
+		target, err := url.Parse(upstream.URL)
+		Expect(err).NotTo(HaveOccurred())
+		proxy = httptest.NewServer(NewHandler(target, func() (Set, error) {
+			return Set{
+				Admin:    Credentials{Username: "admin", Password: "hunter2"},
+				ReadOnly: Credentials{Username: "viewer", Password: "peek"},
+				Upstream: Credentials{Username: "root", Password: "internal"},
+			}, nil
+		}))
+	})
+
+	AfterEach(func() {
+		proxy.Close()
+		upstream.Close()
+	})
+
+	send := func(method, path, username, password string) int {
+		req, err := http.NewRequest(method, proxy.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(res.Body.Close()).To(Succeed())
+		return res.StatusCode
+	}
+
+	It("lets the read-only credentials read but rejects their mutations", func() {
+		Expect(send(http.MethodGet, "/pins", "viewer", "peek")).To(Equal(http.StatusOK))
+		Expect(send(http.MethodGet, "/health", "viewer", "peek")).To(Equal(http.StatusOK))
+		Expect(send(http.MethodPost, "/add", "viewer", "peek")).To(Equal(http.StatusForbidden))
+		Expect(send(http.MethodPost, "/pins/bafybeigdyrzt", "viewer", "peek")).To(Equal(http.StatusForbidden))
+		Expect(send(http.MethodDelete, "/pins/bafybeigdyrzt", "viewer", "peek")).To(Equal(http.StatusForbidden))
+		Expect(send(http.MethodDelete, "/peers/12D3KooW", "viewer", "peek")).To(Equal(http.StatusForbidden))
+		Expect(received).To(Equal([]string{"GET /pins", "GET /health"}))
+	})
+
+	It("forwards every request of the admin credentials", func() {
+		Expect(send(http.MethodPost, "/pins/bafybeigdyrzt", "admin", "hunter2")).To(Equal(http.StatusOK))
+		Expect(send(http.MethodDelete, "/pins/bafybeigdyrzt", "root", "internal")).To(Equal(http.StatusOK))
+		Expect(received).To(Equal([]string{"POST /pins/bafybeigdyrzt", "DELETE /pins/bafybeigdyrzt"}))
+	})
+
+	It("rejects unknown credentials", func() {
+		Expect(send(http.MethodGet, "/pins", "", "")).To(Equal(http.StatusUnauthorized))
+		Expect(send(http.MethodGet, "/pins", "viewer", "hunter2")).To(Equal(http.StatusUnauthorized))
+		Expect(received).To(BeEmpty())
+	})
+
+	It("reads the credentials from the mounted Secret until they rotate", func() {
+		dir, err := os.MkdirTemp("", "apiproxy")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { Expect(os.RemoveAll(dir)).To(Succeed()) }()
+		write := func(key, value string) {
+			Expect(os.WriteFile(filepath.Join(dir, key), []byte(value), 0o600)).To(Succeed())
+		}
+		write("username", "admin")
+		write("password", "hunter2")
+		write("readonly-username", "viewer")
+		write("readonly-password", "peek")
+		write(UpstreamCredentialsKey, "root:internal")
+
+		creds := &FileCredentials{Dir: dir, Reload: time.Hour}
+		set, err := creds.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(set.ReadOnly).To(Equal(Credentials{Username: "viewer", Password: "peek"}))
+		Expect(set.Upstream).To(Equal(Credentials{Username: "root", Password: "internal"}))
+
+		By("caching them for the reload interval")
+		write("readonly-password", "rotated")
+		set, err = creds.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(set.ReadOnly.Password).To(Equal("peek"))
+		creds.Reload = 0
+		set, err = creds.Get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(set.ReadOnly.Password).To(Equal("rotated"))
+		Expect(set.Admin.Password).To(Equal("hunter2"))
+	})
+})
@@ -0,0 +1,155 @@
+// Package breaker Is a circuit breaker guarding the calls the controllers make
+// to the APIs of a cluster, so that a cluster which cannot be reached fails
+// its calls at once instead of holding up every reconcile for the full
+// timeout of each of them.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State Is the state of a breaker.
+type State string
+
+const (
+	// Closed Lets every call through.
+	Closed State = "Closed"
+	// Open Fails every call at once until the cooldown passed.
+	Open State = "Open"
+	// HalfOpen Lets a single probe through, whose outcome closes or opens
+	// the breaker again.
+	HalfOpen State = "HalfOpen"
+)
+
+// Defaults of the breakers.
+const (
+	DefaultThreshold = 3
+	DefaultCooldown  = 30 * time.Second
+	DefaultDeadline  = 15 * time.Second
+)
+
+// ErrOpen Is returned instead of calling an API whose breaker is open.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Settings Tunes the breakers. Fields left empty keep the defaults.
+type Settings struct {
+	// Threshold Is the number of consecutive failures opening the breaker.
+	Threshold int
+	// Cooldown Is how long the breaker stays open before letting a probe
+	// through.
+	Cooldown time.Duration
+	// Deadline Bounds every call, its retries included.
+	Deadline time.Duration
+}
+
+// withDefaults Returns the settings with the defaults filled in.
+func (s Settings) withDefaults() Settings {
+	if s.Threshold <= 0 {
+		s.Threshold = DefaultThreshold
+	}
+	if s.Cooldown <= 0 {
+		s.Cooldown = DefaultCooldown
+	}
+	if s.Deadline <= 0 {
+		s.Deadline = DefaultDeadline
+	}
+	return s
+}
+
+// Breaker Counts the consecutive failures of the calls to an API. It opens
+// once they reach the threshold, failing the calls at once, then lets a
+// single probe through after the cooldown.
+type Breaker struct {
+	mu       sync.Mutex
+	settings Settings
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+	// now Returns the current time. It is a field so that tests can move
+	// the clock.
+	now func() time.Time
+}
+
+// New Returns a closed breaker.
+func New(settings Settings) *Breaker {
+	return &Breaker{settings: settings.withDefaults(), state: Closed, now: time.Now}
+}
+
+// State Returns the state of the breaker. An open breaker whose cooldown
+// passed is reported half-open, as its next call is a probe.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.settings.Cooldown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Do Runs the call under the deadline of the breaker, derived from ctx, unless
+// the breaker is open. failed Tells the errors meaning the API could not be
+// reached, which count towards opening the breaker, from those it answered
+// with. A call given up by the caller counts neither way.
+func (b *Breaker) Do(ctx context.Context, call func(context.Context) error, failed func(error) bool) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, b.settings.Deadline)
+	defer cancel()
+	err := call(callCtx)
+	if ctx.Err() != nil {
+		b.release()
+		return err
+	}
+	b.record(err != nil && failed(err))
+	return err
+}
+
+// allow Returns ErrOpen unless the call may go through. Once the cooldown
+// passed, a single call goes through as a probe.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		return nil
+	case Open:
+		if b.now().Sub(b.openedAt) < b.settings.Cooldown {
+			return ErrOpen
+		}
+		b.state = HalfOpen
+	}
+	if b.probing {
+		return ErrOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// release Lets another probe through after a probe was given up.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// record Records the outcome of a call.
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.settings.Threshold {
+		b.state = Open
+		b.openedAt = b.now()
+	}
+}
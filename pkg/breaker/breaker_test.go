@@ -0,0 +1,80 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Breaker", func() {
+	var (
+		ctx     context.Context
+		b       *Breaker
+		clock   time.Time
+		calls   int
+		refused = errors.New("connection refused")
+	)
+
+	unreachable := func(err error) bool { return !errors.Is(err, context.Canceled) }
+	call := func(err error) error {
+		return b.Do(ctx, func(context.Context) error {
+			calls++
+			return err
+		}, unreachable)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = time.Now()
+		calls = 0
+		b = New(Settings{Threshold: 2, Cooldown: time.Minute})
+		b.now = func() time.Time { return clock }
+	})
+
+	It("opens after consecutive failures and probes after the cooldown", func() {
+		Expect(call(refused)).To(MatchError(refused))
+		Expect(call(nil)).To(Succeed())
+		Expect(call(refused)).To(MatchError(refused))
+		Expect(b.State()).To(Equal(Closed))
+		Expect(call(refused)).To(MatchError(refused))
+		Expect(b.State()).To(Equal(Open))
+
+		Expect(call(nil)).To(MatchError(ErrOpen))
+		Expect(calls).To(Equal(4))
+
+		By("opening again when the probe fails")
+		clock = clock.Add(time.Minute)
+		Expect(b.State()).To(Equal(HalfOpen))
+		Expect(call(refused)).To(MatchError(refused))
+		Expect(b.State()).To(Equal(Open))
+		Expect(call(nil)).To(MatchError(ErrOpen))
+
+		By("closing when the probe succeeds")
+		clock = clock.Add(time.Minute)
+		Expect(call(nil)).To(Succeed())
+		Expect(b.State()).To(Equal(Closed))
+	})
+
+	It("bounds every call with its deadline", func() {
+		b = New(Settings{Deadline: 10 * time.Millisecond})
+		start := time.Now()
+		err := b.Do(ctx, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, unreachable)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("does not count the calls given up by the caller", func() {
+		b = New(Settings{Threshold: 1})
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		err := b.Do(canceled, func(ctx context.Context) error { return ctx.Err() }, unreachable)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(b.State()).To(Equal(Closed))
+	})
+})
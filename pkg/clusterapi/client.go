@@ -0,0 +1,442 @@
+// Package clusterapi Is a client for the REST API of ipfs-cluster, shared by
+// every controller which needs to talk to a cluster so that they authenticate,
+// retry and report errors the same way.
+package clusterapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+	"github.com/redhat-et/ipfs-operator/pkg/connpool"
+)
+
+// Keys of the Secret holding the credentials of the REST API.
+const (
+	UsernameKey = "username"
+	PasswordKey = "password"
+	// ReadOnlyUsernameKey and ReadOnlyPasswordKey hold the credentials only
+	// allowed to read from the REST API.
+	ReadOnlyUsernameKey = "readonly-username"
+	ReadOnlyPasswordKey = "readonly-password"
+	// CACertKey holds the PEM encoded certificate authority of the REST API
+	// when it is served over TLS.
+	CACertKey = "ca.crt"
+)
+
+const (
+	// DefaultTimeout Bounds a single attempt of a request.
+	DefaultTimeout = 10 * time.Second
+	// streamErrorTrailer carries the error of a response the REST API
+	// failed to stream completely.
+	streamErrorTrailer = "X-Stream-Error"
+)
+
+// DefaultBackoff Is the schedule used to retry requests which failed because
+// the cluster could not be reached or was not ready to answer.
+var DefaultBackoff = wait.Backoff{
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
+}
+
+// Client Talks to the REST API of a single ipfs-cluster.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+	timeout    time.Duration
+	backoff    wait.Backoff
+	breaker    *breaker.Breaker
+	pool       *connpool.Pool
+}
+
+// Option Configures a Client.
+type Option func(*Client)
+
+// WithBasicAuth Authenticates every request with the given credentials.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithHTTPClient Sends the requests through the given HTTP client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout Bounds every attempt of a request to the given duration.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithBackoff Retries failed requests on the given schedule. A backoff of a
+// single step disables retries.
+func WithBackoff(backoff wait.Backoff) Option {
+	return func(c *Client) {
+		c.backoff = backoff
+	}
+}
+
+// WithBreaker Guards every request, its retries included, with the given
+// circuit breaker, so that the requests fail at once while the cluster
+// cannot be reached.
+func WithBreaker(b *breaker.Breaker) Option {
+	return func(c *Client) {
+		c.breaker = b
+	}
+}
+
+// WithBaseURL Sends the requests to the REST API served at the given URL
+// instead, such as a fake cluster in tests.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithPool Sends the requests through the given pool, which bounds the calls
+// in flight across the clients sharing it.
+func WithPool(pool *connpool.Pool) Option {
+	return func(c *Client) {
+		c.pool = pool
+	}
+}
+
+// New Returns a client of the REST API served at baseURL.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    baseURL,
+		httpClient: http.DefaultClient,
+		timeout:    DefaultTimeout,
+		backoff:    DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.pool != nil {
+		c.httpClient = c.pool.Client(c.httpClient)
+	}
+	return c
+}
+
+// NewForService Returns a client of the REST API served by the named Service
+// on the given port, authenticated with the credentials held by the Secret.
+// The API is dialed over TLS when the Secret holds a certificate authority.
+// A nil Secret leaves the requests unauthenticated.
+func NewForService(service, namespace string, port int32, secret *corev1.Secret, opts ...Option) (*Client, error) {
+	scheme := "http"
+	var base []Option
+	if secret != nil {
+		username, password := string(secret.Data[UsernameKey]), string(secret.Data[PasswordKey])
+		if username == "" || password == "" {
+			return nil, fmt.Errorf("secret %s does not hold the %s and %s keys",
+				secret.Name, UsernameKey, PasswordKey)
+		}
+		base = append(base, WithBasicAuth(username, password))
+		if ca := secret.Data[CACertKey]; len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("secret %s holds an invalid %s", secret.Name, CACertKey)
+			}
+			defaultTransport, ok := http.DefaultTransport.(*http.Transport)
+			if !ok {
+				return nil, fmt.Errorf("cannot configure TLS on %T", http.DefaultTransport)
+			}
+			transport := defaultTransport.Clone()
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+			base = append(base, WithHTTPClient(&http.Client{Transport: transport}))
+			scheme = "https"
+		}
+	}
+	baseURL := fmt.Sprintf("%s://%s.%s.svc:%d", scheme, service, namespace, port)
+	return New(baseURL, append(base, opts...)...), nil
+}
+
+// Health Returns an error unless the peer answering the request is up.
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/health", nil, nil)
+}
+
+// ID Returns the peer answering the request.
+func (c *Client) ID(ctx context.Context) (*Peer, error) {
+	peer := &Peer{}
+	if err := c.do(ctx, http.MethodGet, "/id", nil, decodeInto(peer)); err != nil {
+		return nil, fmt.Errorf("cannot get cluster peer id: %w", err)
+	}
+	return peer, nil
+}
+
+// Peers Lists the peers as seen by the peer answering the request.
+func (c *Client) Peers(ctx context.Context) ([]Peer, error) {
+	peers := make([]Peer, 0)
+	add := func(raw json.RawMessage) error {
+		var peer Peer
+		if err := json.Unmarshal(raw, &peer); err != nil {
+			return err
+		}
+		peers = append(peers, peer)
+		return nil
+	}
+	if err := c.do(ctx, http.MethodGet, "/peers", nil, decodeStream(add)); err != nil {
+		return nil, fmt.Errorf("cannot list cluster peers: %w", err)
+	}
+	return peers, nil
+}
+
+// RemovePeer Removes the peer with the given ID from the cluster peerset.
+func (c *Client) RemovePeer(ctx context.Context, id string) error {
+	if err := c.do(ctx, http.MethodDelete, "/peers/"+url.PathEscape(id), nil, nil); err != nil {
+		return fmt.Errorf("cannot remove cluster peer %s: %w", id, err)
+	}
+	return nil
+}
+
+// Pin Adds the content identifier to the cluster pinset.
+func (c *Client) Pin(ctx context.Context, cid string, opts PinOptions) (*Pin, error) {
+	query := url.Values{}
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+	if opts.ReplicationMin != 0 {
+		query.Set("replication-min", strconv.Itoa(opts.ReplicationMin))
+	}
+	if opts.ReplicationMax != 0 {
+		query.Set("replication-max", strconv.Itoa(opts.ReplicationMax))
+	}
+	pin := &Pin{}
+	if err := c.do(ctx, http.MethodPost, "/pins/"+url.PathEscape(cid), query, decodeInto(pin)); err != nil {
+		return nil, fmt.Errorf("cannot pin %s: %w", cid, err)
+	}
+	return pin, nil
+}
+
+// Unpin Removes the content identifier from the cluster pinset.
+func (c *Client) Unpin(ctx context.Context, cid string) error {
+	if err := c.do(ctx, http.MethodDelete, "/pins/"+url.PathEscape(cid), nil, nil); err != nil {
+		return fmt.Errorf("cannot unpin %s: %w", cid, err)
+	}
+	return nil
+}
+
+// Status Returns the state of the content identifier on every peer.
+func (c *Client) Status(ctx context.Context, cid string) (*GlobalPinInfo, error) {
+	info := &GlobalPinInfo{}
+	if err := c.do(ctx, http.MethodGet, "/pins/"+url.PathEscape(cid), nil, decodeInto(info)); err != nil {
+		return nil, fmt.Errorf("cannot get the status of %s: %w", cid, err)
+	}
+	return info, nil
+}
+
+// StatusAll Returns the state of every item of the pinset on every peer.
+func (c *Client) StatusAll(ctx context.Context) ([]GlobalPinInfo, error) {
+	infos := make([]GlobalPinInfo, 0)
+	if err := c.do(ctx, http.MethodGet, "/pins", nil, decodeStream(appendPinInfo(&infos))); err != nil {
+		return nil, fmt.Errorf("cannot get the status of the pinset: %w", err)
+	}
+	return infos, nil
+}
+
+// Pending Returns the state of the items of the pinset queued or being
+// pinned on some peer.
+func (c *Client) Pending(ctx context.Context) ([]GlobalPinInfo, error) {
+	infos := make([]GlobalPinInfo, 0)
+	query := url.Values{}
+	query.Set("filter", string(TrackerStatusPinQueued)+","+string(TrackerStatusPinning))
+	if err := c.do(ctx, http.MethodGet, "/pins", query, decodeStream(appendPinInfo(&infos))); err != nil {
+		return nil, fmt.Errorf("cannot get the pending items of the pinset: %w", err)
+	}
+	return infos, nil
+}
+
+// Recover Retries pinning or unpinning the content identifier on the peers
+// where it failed.
+func (c *Client) Recover(ctx context.Context, cid string) (*GlobalPinInfo, error) {
+	info := &GlobalPinInfo{}
+	if err := c.do(ctx, http.MethodPost, "/pins/"+url.PathEscape(cid)+"/recover", nil, decodeInto(info)); err != nil {
+		return nil, fmt.Errorf("cannot recover %s: %w", cid, err)
+	}
+	return info, nil
+}
+
+// RecoverAll Retries every failed pin or unpin of the peer answering the request.
+func (c *Client) RecoverAll(ctx context.Context) ([]GlobalPinInfo, error) {
+	infos := make([]GlobalPinInfo, 0)
+	if err := c.do(ctx, http.MethodPost, "/pins/recover", nil, decodeStream(appendPinInfo(&infos))); err != nil {
+		return nil, fmt.Errorf("cannot recover the pinset: %w", err)
+	}
+	return infos, nil
+}
+
+// do Sends the request through the breaker of the client, if any.
+func (c *Client) do(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	decode func(io.Reader) error,
+) error {
+	if c.breaker == nil {
+		return c.send(ctx, method, path, query, decode)
+	}
+	return c.breaker.Do(ctx, func(ctx context.Context) error {
+		return c.send(ctx, method, path, query, decode)
+	}, unreachable)
+}
+
+// send Sends the request, retrying on the backoff schedule while the cluster
+// cannot be reached or answers that it is unavailable. Every operation of the
+// REST API the client exposes is idempotent, so retrying is always safe. The
+// body of a successful response is handed to decode.
+func (c *Client) send(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	decode func(io.Reader) error,
+) error {
+	backoff := c.backoff
+	for {
+		retry, err := c.attempt(ctx, method, path, query, decode)
+		if err == nil || !retry || backoff.Steps <= 1 {
+			return err
+		}
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt Sends the request once and returns whether it may be retried.
+func (c *Client) attempt(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	decode func(io.Reader) error,
+) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return false, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The caller gave up; only the attempt timing out is worth a retry.
+		return ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded), err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return retriable(resp.StatusCode), responseError(resp)
+	}
+	if decode == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	if err = decode(resp.Body); err != nil {
+		return false, fmt.Errorf("cannot decode response: %w", err)
+	}
+	if msg := resp.Trailer.Get(streamErrorTrailer); msg != "" {
+		return false, fmt.Errorf("response interrupted: %s", msg)
+	}
+	return false, nil
+}
+
+// responseError Builds the error describing a failed response. The REST API
+// describes errors as JSON, but proxies in front of it may not.
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = string(bytes.TrimSpace(body))
+	}
+	apiErr.StatusCode = resp.StatusCode
+	return apiErr
+}
+
+// decodeInto Returns a decoder of a single JSON object.
+func decodeInto(v interface{}) func(io.Reader) error {
+	return func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(v)
+	}
+}
+
+// decodeStream Returns a decoder of a list of objects, streamed by ipfs-cluster
+// v1 as one JSON object after the other and sent as a JSON array by older
+// versions. Each object is handed to add.
+func decodeStream(add func(json.RawMessage) error) func(io.Reader) error {
+	return func(r io.Reader) error {
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && body[0] == '[' {
+			var items []json.RawMessage
+			if err = json.Unmarshal(body, &items); err != nil {
+				return err
+			}
+			for _, item := range items {
+				if err = add(item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		for {
+			var item json.RawMessage
+			if err = dec.Decode(&item); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if err = add(item); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// appendPinInfo Returns a function adding the decoded pin status to infos.
+func appendPinInfo(infos *[]GlobalPinInfo) func(json.RawMessage) error {
+	return func(raw json.RawMessage) error {
+		var info GlobalPinInfo
+		if err := json.Unmarshal(raw, &info); err != nil {
+			return err
+		}
+		*infos = append(*infos, info)
+		return nil
+	}
+}
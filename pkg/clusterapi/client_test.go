@@ -0,0 +1,176 @@
+package clusterapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		ctx      context.Context
+		mux      *http.ServeMux
+		server   *httptest.Server
+		client   *Client
+		requests int32
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests = 0
+		mux = http.NewServeMux()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"code": 401, "message": "Unauthorized"}`)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}))
+		client = New(server.URL,
+			WithBasicAuth("admin", "secret"),
+			WithBackoff(wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("decodes streamed peers", func() {
+		mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Method).To(Equal(http.MethodGet))
+			fmt.Fprintln(w, `{"id": "12D3KooWA", "peername": "ipfs-cluster-test-0"}`)
+			fmt.Fprintln(w, `{"id": "12D3KooWB", "peername": "ipfs-cluster-test-1", "error": "unreachable"}`)
+		})
+		peers, err := client.Peers(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(HaveLen(2))
+		Expect(peers[0].Peername).To(Equal("ipfs-cluster-test-0"))
+		Expect(peers[1].Error).To(Equal("unreachable"))
+	})
+
+	It("decodes peers sent as an array", func() {
+		mux.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"id": "12D3KooWA"}]`)
+		})
+		peers, err := client.Peers(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(HaveLen(1))
+	})
+
+	It("pins with options and decodes both encodings of a cid", func() {
+		mux.HandleFunc("/pins/bafyexample", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost:
+				Expect(r.URL.Query().Get("name")).To(Equal("site"))
+				Expect(r.URL.Query().Get("replication-min")).To(Equal("2"))
+				Expect(r.URL.Query().Has("replication-max")).To(BeFalse())
+				fmt.Fprint(w, `{"cid": {"/": "bafyexample"}, "name": "site", "replication_factor_min": 2}`)
+			case http.MethodGet:
+				fmt.Fprint(w, `{"cid": "bafyexample", "peer_map": {"12D3KooWA": {"status": "pinned"}}}`)
+			}
+		})
+		pin, err := client.Pin(ctx, "bafyexample", PinOptions{Name: "site", ReplicationMin: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pin.Cid).To(Equal(Cid("bafyexample")))
+		Expect(pin.ReplicationMin).To(Equal(2))
+
+		info, err := client.Status(ctx, "bafyexample")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Cid).To(Equal(Cid("bafyexample")))
+		Expect(info.PeerMap["12D3KooWA"].Status).To(Equal(TrackerStatusPinned))
+	})
+
+	It("retries while the cluster is unavailable", func() {
+		var calls int32
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+		Expect(client.Health(ctx)).To(Succeed())
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(3)))
+	})
+
+	It("gives up after the last retry", func() {
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		err := client.Health(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(3)))
+	})
+
+	It("maps errors without retrying them", func() {
+		mux.HandleFunc("/pins/bafymissing", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"code": 404, "message": "cid is not part of the global state"}`)
+		})
+		err := client.Unpin(ctx, "bafymissing")
+		Expect(IsNotFound(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("cid is not part of the global state"))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+
+	It("reports refused credentials", func() {
+		client = New(server.URL, WithBasicAuth("admin", "wrong"))
+		err := client.RemovePeer(ctx, "12D3KooWA")
+		Expect(IsUnauthorized(err)).To(BeTrue())
+	})
+
+	It("bounds every attempt with the timeout", func() {
+		mux.HandleFunc("/id", func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+		client = New(server.URL,
+			WithBasicAuth("admin", "secret"),
+			WithTimeout(20*time.Millisecond),
+			WithBackoff(wait.Backoff{Duration: time.Millisecond, Steps: 2}))
+		_, err := client.ID(ctx)
+		Expect(err).To(MatchError(ContainSubstring("deadline exceeded")))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(2)))
+	})
+
+	It("stops retrying once the context is done", func() {
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		client = New(server.URL,
+			WithBasicAuth("admin", "secret"),
+			WithBackoff(wait.Backoff{Duration: time.Hour, Steps: 5}))
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		Expect(client.Health(ctx)).NotTo(Succeed())
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+})
+
+var _ = Describe("NewForService", func() {
+	It("builds the URL of the service and reads the credentials", func() {
+		secret := &corev1.Secret{Data: map[string][]byte{
+			UsernameKey: []byte("admin"),
+			PasswordKey: []byte("secret"),
+		}}
+		client, err := NewForService("ipfs-cluster-test", "default", 9094, secret)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.baseURL).To(Equal("http://ipfs-cluster-test.default.svc:9094"))
+		Expect(client.username).To(Equal("admin"))
+	})
+
+	It("refuses incomplete credentials", func() {
+		secret := &corev1.Secret{Data: map[string][]byte{UsernameKey: []byte("admin")}}
+		_, err := NewForService("ipfs-cluster-test", "default", 9094, secret)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,53 @@
+package clusterapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error Is a request the REST API answered with an error status.
+type Error struct {
+	StatusCode int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cluster API returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound Returns whether the error is the REST API reporting a missing
+// peer or pin.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized Returns whether the REST API refused the credentials.
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+func hasStatus(err error, code int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == code
+}
+
+// retriable Returns whether a request answered with the given status may
+// succeed when sent again.
+func retriable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// unreachable Returns whether the error means the cluster could not be
+// reached or was not ready to answer, rather than that it answered.
+func unreachable(err error) bool {
+	var apiErr *Error
+	return !errors.As(err, &apiErr) || retriable(apiErr.StatusCode)
+}
@@ -0,0 +1,414 @@
+// Package fake Provides an in-memory ipfs-cluster serving the subset of its
+// REST API the operator uses, for the tests of the controllers which talk to
+// a cluster. Every new feature calling the REST API extends it.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+)
+
+// Routes of the REST API, as passed to FailNext and Calls.
+const (
+	RouteHealth     = "GET /health"
+	RouteID         = "GET /id"
+	RoutePeers      = "GET /peers"
+	RouteRemovePeer = "DELETE /peers"
+	RoutePin        = "POST /pins"
+	RouteUnpin      = "DELETE /pins"
+	RouteStatus     = "GET /pins"
+	RouteRecover    = "POST /pins/recover"
+)
+
+// Cluster Is an in-memory ipfs-cluster served over HTTP. Pins are pinned on
+// every peer at once, unless pinning them was set to fail.
+type Cluster struct {
+	mu     sync.Mutex
+	server *httptest.Server
+
+	username string
+	password string
+	latency  time.Duration
+	healthy  bool
+
+	peers []clusterapi.Peer
+	pins  map[string]*pin
+	// failures Holds the errors injected for the next requests of each route.
+	failures map[string][]int
+	// pinFailures Counts the pinning attempts still to fail for each CID.
+	pinFailures map[string]int
+	calls       map[string]int
+}
+
+// pin Is an item of the pinset along with its state on each peer.
+type pin struct {
+	clusterapi.Pin
+	status clusterapi.TrackerStatus
+	err    string
+	// diverged Holds the peers whose state of the pin was set apart from
+	// the others, keyed by peer ID.
+	diverged map[string]*divergence
+}
+
+// divergence Is the state of a pin on a peer, kept until the pin is
+// recovered that many times.
+type divergence struct {
+	status     clusterapi.TrackerStatus
+	recoveries int
+}
+
+// NewCluster Starts a healthy cluster made of the given peers. It is stopped
+// with Close.
+func NewCluster(peers ...clusterapi.Peer) *Cluster {
+	c := &Cluster{
+		healthy:     true,
+		peers:       append([]clusterapi.Peer(nil), peers...),
+		pins:        map[string]*pin{},
+		failures:    map[string][]int{},
+		pinFailures: map[string]int{},
+		calls:       map[string]int{},
+	}
+	c.server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	return c
+}
+
+// URL Returns the base URL of the REST API, to be passed to clusterapi.WithBaseURL.
+func (c *Cluster) URL() string {
+	return c.server.URL
+}
+
+// Close Stops serving the REST API.
+func (c *Cluster) Close() {
+	c.server.Close()
+}
+
+// SetCredentials Requires every request to authenticate with the given
+// credentials. Requests are not authenticated by default.
+func (c *Cluster) SetCredentials(username, password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username, c.password = username, password
+}
+
+// SetLatency Delays every response by the given duration.
+func (c *Cluster) SetLatency(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = latency
+}
+
+// SetHealthy Sets whether the health endpoint reports the cluster up.
+func (c *Cluster) SetHealthy(healthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.healthy = healthy
+}
+
+// FailNext Answers the next requests of the route with the given status
+// codes, one request per code.
+func (c *Cluster) FailNext(route string, codes ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures[route] = append(c.failures[route], codes...)
+}
+
+// FailPinning Fails the next attempts at pinning the CID, leaving it in the
+// pin_error state until it is recovered that many times.
+func (c *Cluster) FailPinning(cid string, attempts int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinFailures[cid] = attempts
+}
+
+// Diverge Leaves the pinned CID in the given state on the peer, whatever its
+// state on the others, until it is recovered that many times, at least
+// once. It never converges with a negative count.
+func (c *Cluster) Diverge(cid, peerID string, status clusterapi.TrackerStatus, recoveries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pins[cid]
+	if p.diverged == nil {
+		p.diverged = map[string]*divergence{}
+	}
+	p.diverged[peerID] = &divergence{status: status, recoveries: recoveries}
+}
+
+// Queue Sets the pin back to queued on every peer, as ipfs-cluster reports
+// the pins it did not start pinning yet, until it is recovered.
+func (c *Cluster) Queue(cid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.pins[cid]; ok {
+		p.status, p.err = clusterapi.TrackerStatusPinQueued, ""
+	}
+}
+
+// AddPeer Adds the peer to the peerset, as when it joins the cluster.
+func (c *Cluster) AddPeer(peer clusterapi.Peer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers = append(c.peers, peer)
+}
+
+// Peers Returns the current peerset.
+func (c *Cluster) Peers() []clusterapi.Peer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]clusterapi.Peer(nil), c.peers...)
+}
+
+// Calls Returns the number of requests received on the route.
+func (c *Cluster) Calls(route string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[route]
+}
+
+// serveHTTP Routes the request after authenticating it and applying the
+// injected latency and failures.
+func (c *Cluster) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	latency := c.latency
+	c.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.username != "" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != c.username || pass != c.password {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+	}
+	route, arg := routeOf(r.Method, r.URL.Path)
+	c.calls[route]++
+	if codes := c.failures[route]; len(codes) > 0 {
+		c.failures[route] = codes[1:]
+		writeError(w, codes[0], "injected failure")
+		return
+	}
+
+	switch route {
+	case RouteHealth:
+		if !c.healthy {
+			writeError(w, http.StatusInternalServerError, "cluster is unhealthy")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case RouteID:
+		if len(c.peers) == 0 {
+			writeError(w, http.StatusInternalServerError, "no peer")
+			return
+		}
+		writeJSON(w, c.peers[0])
+	case RoutePeers:
+		for _, p := range c.peers {
+			writeJSON(w, p)
+		}
+	case RouteRemovePeer:
+		c.removePeer(w, arg)
+	case RoutePin:
+		c.pin(w, r, arg)
+	case RouteUnpin:
+		if _, ok := c.pins[arg]; !ok {
+			writeError(w, http.StatusNotFound, "cid is not part of the global state")
+			return
+		}
+		delete(c.pins, arg)
+		w.WriteHeader(http.StatusNoContent)
+	case RouteStatus:
+		c.status(w, arg, r.URL.Query().Get("filter"))
+	case RouteRecover:
+		c.recover(w, arg)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// routeOf Returns the route of the request and the peer ID or CID it names.
+func routeOf(method, path string) (string, string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 2 && parts[0] == "pins" && parts[1] == "recover":
+		return RouteRecover, ""
+	case len(parts) == 3 && parts[0] == "pins" && parts[2] == "recover":
+		return RouteRecover, parts[1]
+	case len(parts) == 2:
+		return method + " /" + parts[0], parts[1]
+	}
+	return method + " /" + parts[0], ""
+}
+
+// removePeer Removes the peer from the peerset and drops it from the
+// allocations of the pins, as ipfs-cluster re-allocates them to the other
+// peers.
+func (c *Cluster) removePeer(w http.ResponseWriter, id string) {
+	for i, p := range c.peers {
+		if p.ID == id {
+			c.peers = append(c.peers[:i], c.peers[i+1:]...)
+			for _, pin := range c.pins {
+				allocations := pin.Allocations[:0]
+				for _, allocated := range pin.Allocations {
+					if allocated != id {
+						allocations = append(allocations, allocated)
+					}
+				}
+				pin.Allocations = allocations
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("peer %s is not part of the peerset", id))
+}
+
+func (c *Cluster) pin(w http.ResponseWriter, r *http.Request, cid string) {
+	query := r.URL.Query()
+	p := &pin{Pin: clusterapi.Pin{Cid: clusterapi.Cid(cid), Name: query.Get("name")}}
+	// Missing replication factors keep the defaults of the cluster.
+	p.ReplicationMin, _ = strconv.Atoi(query.Get("replication-min"))
+	p.ReplicationMax, _ = strconv.Atoi(query.Get("replication-max"))
+	for _, peer := range c.peers {
+		p.Allocations = append(p.Allocations, peer.ID)
+	}
+	c.pins[cid] = p
+	c.attemptPinning(p)
+	writeJSON(w, p.Pin)
+}
+
+// attemptPinning Pins the item on every peer, unless the attempt was set to fail.
+func (c *Cluster) attemptPinning(p *pin) {
+	cid := string(p.Cid)
+	if c.pinFailures[cid] > 0 {
+		c.pinFailures[cid]--
+		p.status, p.err = clusterapi.TrackerStatusPinError, "context deadline exceeded"
+		return
+	}
+	p.status, p.err = clusterapi.TrackerStatusPinned, ""
+}
+
+// status Writes the state of the pin, or of the pins whose state on some
+// peer is one of the comma-separated statuses of the filter, if any.
+func (c *Cluster) status(w http.ResponseWriter, cid, filter string) {
+	if cid != "" {
+		p, ok := c.pins[cid]
+		if !ok {
+			writeError(w, http.StatusNotFound, "cid is not part of the global state")
+			return
+		}
+		writeJSON(w, c.pinInfo(p))
+		return
+	}
+	for _, cid := range c.sortedPins() {
+		info := c.pinInfo(c.pins[cid])
+		if filter == "" || matchesFilter(info, strings.Split(filter, ",")) {
+			writeJSON(w, info)
+		}
+	}
+}
+
+// matchesFilter Returns whether the state of the pin on some peer is one of
+// the statuses.
+func matchesFilter(info clusterapi.GlobalPinInfo, statuses []string) bool {
+	for _, pi := range info.PeerMap {
+		for _, status := range statuses {
+			if string(pi.Status) == status {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Cluster) recover(w http.ResponseWriter, cid string) {
+	if cid != "" {
+		p, ok := c.pins[cid]
+		if !ok {
+			writeError(w, http.StatusNotFound, "cid is not part of the global state")
+			return
+		}
+		c.recoverPin(p)
+		writeJSON(w, c.pinInfo(p))
+		return
+	}
+	for _, cid := range c.sortedPins() {
+		p := c.pins[cid]
+		c.recoverPin(p)
+		writeJSON(w, c.pinInfo(p))
+	}
+}
+
+// recoverPin Retries pinning the item where it failed or is still queued,
+// and brings the diverged peers one recovery closer to the others.
+func (c *Cluster) recoverPin(p *pin) {
+	if p.status == clusterapi.TrackerStatusPinError || p.status == clusterapi.TrackerStatusPinQueued {
+		c.attemptPinning(p)
+	}
+	for id, d := range p.diverged {
+		if d.recoveries < 0 {
+			continue
+		}
+		if d.recoveries--; d.recoveries <= 0 {
+			delete(p.diverged, id)
+		}
+	}
+}
+
+// pinInfo Returns the state of the pin on every peer.
+func (c *Cluster) pinInfo(p *pin) clusterapi.GlobalPinInfo {
+	info := clusterapi.GlobalPinInfo{
+		Cid:         p.Cid,
+		Name:        p.Name,
+		Allocations: p.Allocations,
+		PeerMap:     map[string]clusterapi.PinInfo{},
+	}
+	for _, peer := range c.peers {
+		status, err := p.status, p.err
+		if d := p.diverged[peer.ID]; d != nil {
+			status, err = d.status, ""
+		}
+		info.PeerMap[peer.ID] = clusterapi.PinInfo{
+			Peername:  peer.Peername,
+			Status:    status,
+			Timestamp: time.Now(),
+			Error:     err,
+		}
+	}
+	return info
+}
+
+func (c *Cluster) sortedPins() []string {
+	cids := make([]string, 0, len(c.pins))
+	for cid := range c.pins {
+		cids = append(cids, cid)
+	}
+	sort.Strings(cids)
+	return cids
+}
+
+// writeJSON Writes the value as one object of a streamed response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(clusterapi.Error{StatusCode: code, Message: message})
+}
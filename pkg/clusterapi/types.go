@@ -0,0 +1,89 @@
+package clusterapi
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Cid Is a content identifier. The REST API of ipfs-cluster v1 encodes it as
+// {"/": "<cid>"}, while older versions use a plain string; both are accepted.
+type Cid string
+
+// UnmarshalJSON Decodes either encoding of a content identifier.
+func (c *Cid) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = Cid(s)
+		return nil
+	}
+	var link struct {
+		Value string `json:"/"`
+	}
+	if err := json.Unmarshal(data, &link); err != nil {
+		return err
+	}
+	*c = Cid(link.Value)
+	return nil
+}
+
+// Peer Is a member of the cluster peerset, as reported by the peer answering
+// the request.
+type Peer struct {
+	ID           string   `json:"id"`
+	Peername     string   `json:"peername"`
+	Addresses    []string `json:"addresses"`
+	ClusterPeers []string `json:"cluster_peers"`
+	Version      string   `json:"version"`
+	Error        string   `json:"error"`
+}
+
+// Pin Is an item of the cluster pinset.
+type Pin struct {
+	Cid            Cid      `json:"cid"`
+	Name           string   `json:"name"`
+	ReplicationMin int      `json:"replication_factor_min"`
+	ReplicationMax int      `json:"replication_factor_max"`
+	Allocations    []string `json:"allocations"`
+}
+
+// PinOptions Holds the optional settings of a new pin. Zero values leave the
+// cluster defaults in place.
+type PinOptions struct {
+	Name           string
+	ReplicationMin int
+	ReplicationMax int
+}
+
+// TrackerStatus Is the state of a pin on a single peer.
+type TrackerStatus string
+
+// Tracker statuses the operator acts upon.
+const (
+	TrackerStatusPinned      TrackerStatus = "pinned"
+	TrackerStatusPinning     TrackerStatus = "pinning"
+	TrackerStatusPinQueued   TrackerStatus = "pin_queued"
+	TrackerStatusPinError    TrackerStatus = "pin_error"
+	TrackerStatusUnpinned    TrackerStatus = "unpinned"
+	TrackerStatusUnpinning   TrackerStatus = "unpinning"
+	TrackerStatusUnpinQueued TrackerStatus = "unpin_queued"
+	TrackerStatusUnpinError  TrackerStatus = "unpin_error"
+	TrackerStatusRemote      TrackerStatus = "remote"
+	TrackerStatusClusterErr  TrackerStatus = "cluster_error"
+)
+
+// PinInfo Is the state of a pin on a single peer.
+type PinInfo struct {
+	Peername  string        `json:"peername"`
+	Status    TrackerStatus `json:"status"`
+	Timestamp time.Time     `json:"timestamp"`
+	Error     string        `json:"error"`
+}
+
+// GlobalPinInfo Is the state of a pin on every peer of the cluster, keyed by
+// peer ID.
+type GlobalPinInfo struct {
+	Cid         Cid                `json:"cid"`
+	Name        string             `json:"name"`
+	Allocations []string           `json:"allocations"`
+	PeerMap     map[string]PinInfo `json:"peer_map"`
+}
@@ -0,0 +1,144 @@
+package clusterapi_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi"
+	"github.com/redhat-et/ipfs-operator/pkg/clusterapi/fake"
+)
+
+var _ = Describe("Cluster API workflows against a fake cluster", func() {
+	const cid = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	var (
+		ctx     context.Context
+		cluster *fake.Cluster
+		client  *clusterapi.Client
+	)
+
+	// statuses Returns the state of the pin on each peer.
+	statuses := func() []clusterapi.TrackerStatus {
+		info, err := client.Status(ctx, cid)
+		Expect(err).NotTo(HaveOccurred())
+		var statuses []clusterapi.TrackerStatus
+		for _, p := range info.PeerMap {
+			statuses = append(statuses, p.Status)
+		}
+		return statuses
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cluster = fake.NewCluster(
+			clusterapi.Peer{ID: "12D3KooWA", Peername: "ipfs-cluster-test-0"},
+			clusterapi.Peer{ID: "12D3KooWB", Peername: "ipfs-cluster-test-1"},
+		)
+		cluster.SetCredentials("admin", "secret")
+		client = clusterapi.New("http://unused.invalid",
+			clusterapi.WithBaseURL(cluster.URL()),
+			clusterapi.WithBasicAuth("admin", "secret"),
+			clusterapi.WithBackoff(wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}))
+	})
+
+	AfterEach(func() {
+		cluster.Close()
+	})
+
+	It("pins on every peer", func() {
+		pin, err := client.Pin(ctx, cid, clusterapi.PinOptions{Name: "dataset", ReplicationMin: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pin.Name).To(Equal("dataset"))
+		Expect(pin.ReplicationMin).To(Equal(2))
+		Expect(pin.Allocations).To(ConsistOf("12D3KooWA", "12D3KooWB"))
+		Expect(statuses()).To(ConsistOf(clusterapi.TrackerStatusPinned, clusterapi.TrackerStatusPinned))
+
+		all, err := client.StatusAll(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(all).To(HaveLen(1))
+		Expect(client.Unpin(ctx, cid)).To(Succeed())
+		_, err = client.Status(ctx, cid)
+		Expect(clusterapi.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("recovers a pin which failed", func() {
+		cluster.FailPinning(cid, 1)
+		_, err := client.Pin(ctx, cid, clusterapi.PinOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses()).To(ConsistOf(clusterapi.TrackerStatusPinError, clusterapi.TrackerStatusPinError))
+
+		info, err := client.Recover(ctx, cid)
+		Expect(err).NotTo(HaveOccurred())
+		for _, p := range info.PeerMap {
+			Expect(p.Status).To(Equal(clusterapi.TrackerStatusPinned))
+			Expect(p.Error).To(BeEmpty())
+		}
+		infos, err := client.RecoverAll(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+	})
+
+	It("lists the pins still queued or pinning", func() {
+		_, err := client.Pin(ctx, cid, clusterapi.PinOptions{Name: "dataset"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Pin(ctx, "bafyqueued", clusterapi.PinOptions{Name: "backfill"})
+		Expect(err).NotTo(HaveOccurred())
+		cluster.Queue("bafyqueued")
+
+		pending, err := client.Pending(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(HaveLen(1))
+		Expect(pending[0].Name).To(Equal("backfill"))
+
+		_, err = client.Recover(ctx, "bafyqueued")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.Pending(ctx)).To(BeEmpty())
+	})
+
+	It("removes a peer from the peerset", func() {
+		Expect(client.RemovePeer(ctx, "12D3KooWB")).To(Succeed())
+		peers, err := client.Peers(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(HaveLen(1))
+		Expect(peers[0].ID).To(Equal("12D3KooWA"))
+		Expect(clusterapi.IsNotFound(client.RemovePeer(ctx, "12D3KooWB"))).To(BeTrue())
+	})
+
+	It("reports the health of the cluster", func() {
+		Expect(client.Health(ctx)).To(Succeed())
+		cluster.SetHealthy(false)
+		Expect(client.Health(ctx)).NotTo(Succeed())
+		cluster.SetHealthy(true)
+		Expect(client.Health(ctx)).To(Succeed())
+	})
+
+	It("injects failures and latency", func() {
+		By("retrying while the cluster is unavailable")
+		cluster.FailNext(fake.RoutePeers, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+		_, err := client.Peers(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster.Calls(fake.RoutePeers)).To(Equal(3))
+
+		By("failing on errors which are not retried")
+		cluster.FailNext(fake.RouteRemovePeer, http.StatusInternalServerError)
+		Expect(client.RemovePeer(ctx, "12D3KooWA")).NotTo(Succeed())
+		Expect(cluster.Peers()).To(HaveLen(2))
+
+		By("timing out slow responses")
+		cluster.SetLatency(100 * time.Millisecond)
+		slow := clusterapi.New(cluster.URL(),
+			clusterapi.WithBasicAuth("admin", "secret"),
+			clusterapi.WithTimeout(10*time.Millisecond),
+			clusterapi.WithBackoff(wait.Backoff{Steps: 1}))
+		Expect(slow.Health(ctx)).NotTo(Succeed())
+	})
+
+	It("refuses wrong credentials", func() {
+		anonymous := clusterapi.New(cluster.URL(), clusterapi.WithBackoff(wait.Backoff{Steps: 1}))
+		Expect(clusterapi.IsUnauthorized(anonymous.Health(ctx))).To(BeTrue())
+	})
+})
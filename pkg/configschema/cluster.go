@@ -0,0 +1,261 @@
+package configschema
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// clusterMain Is the schema of the cluster section of service.json. The
+// schema of each section follows the configuration of the component of
+// ipfs-cluster. ipfs-cluster reads the override of a field from the
+// environment variable named after the prefix of its component and the
+// upper-cased name of the field, so the Go names of the fields are those of
+// ipfs-cluster. The min tag is the lowest value of a number and the enum tag
+// lists the values of a string.
+type clusterMain struct {
+	ConnectionManager     clusterConnMgr `json:"connection_manager"`
+	DialPeerTimeout       Duration       `json:"dial_peer_timeout"`
+	DisableRepinning      bool           `json:"disable_repinning"`
+	EnableRelayHop        bool           `json:"enable_relay_hop"`
+	FollowerMode          bool           `json:"follower_mode"`
+	LeaveOnShutdown       bool           `json:"leave_on_shutdown"`
+	ListenMultiaddress    []Multiaddr    `json:"listen_multiaddress"`
+	MDNSInterval          Duration       `json:"mdns_interval"`
+	MonitorPingInterval   Duration       `json:"monitor_ping_interval"`
+	PeerAddresses         []Multiaddr    `json:"peer_addresses"`
+	PeerWatchInterval     Duration       `json:"peer_watch_interval"`
+	Peername              string         `json:"peername"`
+	PeerstoreFile         string         `json:"peerstore_file"`
+	PinOnlyOnTrustedPeers bool           `json:"pin_only_on_trusted_peers"`
+	PinRecoverInterval    Duration       `json:"pin_recover_interval"`
+	ReplicationFactorMax  int            `json:"replication_factor_max" min:"-1"`
+	ReplicationFactorMin  int            `json:"replication_factor_min" min:"-1"`
+	Secret                string         `json:"secret"`
+	StateSyncInterval     Duration       `json:"state_sync_interval"`
+}
+
+type clusterConnMgr struct {
+	GracePeriod Duration `json:"grace_period"`
+	HighWater   int      `json:"high_water" min:"0"`
+	LowWater    int      `json:"low_water" min:"0"`
+}
+
+type clusterIdentity struct {
+	ID         string `json:"id"`
+	PrivateKey string `json:"private_key"`
+}
+
+type clusterRESTAPI struct {
+	BasicAuthCredentials     map[string]string `json:"basic_auth_credentials"`
+	CORSAllowCredentials     bool              `json:"cors_allow_credentials"`
+	CORSAllowedHeaders       []string          `json:"cors_allowed_headers"`
+	CORSAllowedMethods       []string          `json:"cors_allowed_methods"`
+	CORSAllowedOrigins       []string          `json:"cors_allowed_origins"`
+	CORSExposedHeaders       []string          `json:"cors_exposed_headers"`
+	CORSMaxAge               Duration          `json:"cors_max_age"`
+	HTTPListenMultiaddress   []Multiaddr       `json:"http_listen_multiaddress"`
+	HTTPLogFile              string            `json:"http_log_file"`
+	ID                       string            `json:"id"`
+	IdleTimeout              Duration          `json:"idle_timeout"`
+	Libp2pListenMultiaddress []Multiaddr       `json:"libp2p_listen_multiaddress"`
+	MaxHeaderBytes           int               `json:"max_header_bytes" min:"1"`
+	PrivateKey               string            `json:"private_key"`
+	ReadHeaderTimeout        Duration          `json:"read_header_timeout"`
+	ReadTimeout              Duration          `json:"read_timeout"`
+	SSLCertFile              string            `json:"ssl_cert_file"`
+	SSLKeyFile               string            `json:"ssl_key_file"`
+	WriteTimeout             Duration          `json:"write_timeout"`
+}
+
+type clusterIPFSProxy struct {
+	ListenMultiaddress []Multiaddr `json:"listen_multiaddress"`
+	NodeMultiaddress   Multiaddr   `json:"node_multiaddress"`
+}
+
+type clusterIPFSHTTP struct {
+	ConnectSwarmsDelay      Duration  `json:"connect_swarms_delay"`
+	IPFSRequestTimeout      Duration  `json:"ipfs_request_timeout"`
+	InformerTriggerInterval int       `json:"informer_trigger_interval" min:"0"`
+	NodeMultiaddress        Multiaddr `json:"node_multiaddress"`
+	PinTimeout              Duration  `json:"pin_timeout"`
+	RepoGCTimeout           Duration  `json:"repogc_timeout"`
+	UnpinTimeout            Duration  `json:"unpin_timeout"`
+}
+
+type clusterStateless struct {
+	ConcurrentPins        int      `json:"concurrent_pins" min:"1"`
+	MaxPinQueueSize       int      `json:"max_pin_queue_size" min:"1"`
+	PriorityPinMaxAge     Duration `json:"priority_pin_max_age"`
+	PriorityPinMaxRetries int      `json:"priority_pin_max_retries" min:"0"`
+}
+
+type clusterPubsubmon struct {
+	CheckInterval Duration `json:"check_interval"`
+}
+
+type clusterDisk struct {
+	MetricTTL  Duration `json:"metric_ttl"`
+	MetricType string   `json:"metric_type" enum:"freespace,reposize"`
+}
+
+type clusterTags struct {
+	MetricTTL Duration          `json:"metric_ttl"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type clusterBalanced struct {
+	AllocateBy []string `json:"allocate_by"`
+}
+
+type clusterCRDT struct {
+	Batching            clusterBatching `json:"batching"`
+	ClusterName         string          `json:"cluster_name"`
+	DatastoreNamespace  string          `json:"datastore_namespace"`
+	PeersetMetric       string          `json:"peerset_metric"`
+	RebroadcastInterval Duration        `json:"rebroadcast_interval"`
+	RepairInterval      Duration        `json:"repair_interval"`
+	TrustedPeers        []string        `json:"trusted_peers"`
+}
+
+type clusterBatching struct {
+	MaxBatchAge  Duration `json:"max_batch_age"`
+	MaxBatchSize int      `json:"max_batch_size" min:"0"`
+	MaxQueueSize int      `json:"max_queue_size" min:"1"`
+}
+
+type clusterMetrics struct {
+	EnableStats        bool      `json:"enable_stats"`
+	PrometheusEndpoint Multiaddr `json:"prometheus_endpoint"`
+	ReportingInterval  Duration  `json:"reporting_interval"`
+}
+
+// clusterSection Is a component of ipfs-cluster, with the path of its
+// section in service.json and the prefix of the environment variables
+// overriding it.
+type clusterSection struct {
+	path   string
+	env    string
+	schema interface{}
+}
+
+// clusterSections Are the components of ipfs-cluster the peers run. The
+// identity lives in identity.json rather than service.json.
+var clusterSections = []clusterSection{
+	{path: "cluster", env: "CLUSTER", schema: clusterMain{}},
+	{path: "identity", env: "CLUSTER", schema: clusterIdentity{}},
+	{path: "api.restapi", env: "CLUSTER_RESTAPI", schema: clusterRESTAPI{}},
+	{path: "api.ipfsproxy", env: "CLUSTER_IPFSPROXY", schema: clusterIPFSProxy{}},
+	{path: "ipfs_connector.ipfshttp", env: "CLUSTER_IPFSHTTP", schema: clusterIPFSHTTP{}},
+	{path: "pin_tracker.stateless", env: "CLUSTER_STATELESS", schema: clusterStateless{}},
+	{path: "monitor.pubsubmon", env: "CLUSTER_PUBSUBMON", schema: clusterPubsubmon{}},
+	{path: "informer.disk", env: "CLUSTER_DISK", schema: clusterDisk{}},
+	{path: "informer.tags", env: "CLUSTER_TAGS", schema: clusterTags{}},
+	{path: "allocator.balanced", env: "CLUSTER_BALANCED", schema: clusterBalanced{}},
+	{path: "consensus.crdt", env: "CLUSTER_CRDT", schema: clusterCRDT{}},
+	{path: "observations.metrics", env: "CLUSTER_METRICS", schema: clusterMetrics{}},
+}
+
+// clusterSetting Is a field of service.json, along with its path.
+type clusterSetting struct {
+	path  string
+	field reflect.StructField
+}
+
+// clusterEnv Maps the environment variables ipfs-cluster reads to the
+// fields of service.json they override.
+var clusterEnv = func() map[string]clusterSetting {
+	env := map[string]clusterSetting{}
+	var add func(prefix, path string, t reflect.Type)
+	add = func(prefix, path string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := prefix + "_" + strings.ToUpper(f.Name)
+			if f.Type.Kind() == reflect.Struct {
+				add(name, child(path, jsonName(f)), f.Type)
+				continue
+			}
+			env[name] = clusterSetting{path: child(path, jsonName(f)), field: f}
+		}
+	}
+	for _, section := range clusterSections {
+		add(section.env, section.path, reflect.TypeOf(section.schema))
+	}
+	return env
+}()
+
+// IsClusterEnv Returns whether ipfs-cluster may read the environment
+// variable as the override of a field of service.json.
+func IsClusterEnv(name string) bool {
+	return strings.HasPrefix(name, "CLUSTER_")
+}
+
+// CheckClusterEnv Checks the environment variable overriding a field of
+// the service.json of ipfs-cluster, with its value unless it is only known
+// once the pod runs, such as a value read from a Secret. The values of the
+// string fields are not checked, nor written into the errors.
+func CheckClusterEnv(name string, value *string) error {
+	setting, ok := clusterEnv[name]
+	if !ok {
+		return &Error{Path: name, Reason: "no field of the ipfs-cluster configuration is read from it"}
+	}
+	if value == nil {
+		return nil
+	}
+	if reason := clusterValueError(setting.field, *value); reason != "" {
+		return &Error{Path: setting.path, Reason: fmt.Sprintf("set by %s: %s", name, reason)}
+	}
+	return nil
+}
+
+// clusterValueError Returns why the value of the environment variable does
+// not fit the field, or an empty string.
+func clusterValueError(f reflect.StructField, value string) string {
+	switch f.Type {
+	case reflect.TypeOf(Duration("")):
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Sprintf("invalid duration %q", value)
+		}
+	case reflect.TypeOf(Multiaddr("")):
+		if _, err := ma.NewMultiaddr(value); err != nil {
+			return fmt.Sprintf("invalid multiaddress %q", value)
+		}
+	case reflect.TypeOf([]Multiaddr{}):
+		for _, item := range strings.Split(value, ",") {
+			if _, err := ma.NewMultiaddr(item); err != nil {
+				return fmt.Sprintf("invalid multiaddress %q", item)
+			}
+		}
+	case reflect.TypeOf(map[string]string{}):
+		for _, item := range strings.Split(value, ",") {
+			if !strings.Contains(item, ":") {
+				return "expected key:value pairs separated by commas"
+			}
+		}
+	}
+	switch f.Type.Kind() {
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Sprintf("expected an integer, not %q", value)
+		}
+		if min, ok := f.Tag.Lookup("min"); ok {
+			if bound, _ := strconv.Atoi(min); n < bound {
+				return fmt.Sprintf("%d is below %d", n, bound)
+			}
+		}
+	case reflect.Bool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Sprintf("expected a boolean, not %q", value)
+		}
+	case reflect.String:
+		if enum, ok := f.Tag.Lookup("enum"); ok && value != "" && !contains(strings.Split(enum, ","), value) {
+			return fmt.Sprintf("not one of %s", strings.ReplaceAll(enum, ",", ", "))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package configschema
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+// setting Is a value set at a key of the kubo config, nil when it is only
+// known once the pod runs.
+type setting struct {
+	key   string
+	value []byte
+}
+
+// set Returns the setting of the JSON value at the key.
+func set(key, value string) setting {
+	return setting{key: key, value: []byte(value)}
+}
+
+var _ = Describe("Config schema", func() {
+	latest := Release{}
+	current := Release{Major: 0, Minor: 35}
+
+	// apply Sets the settings in turn into a config of the release, and
+	// checks them together once they are all set.
+	apply := func(release Release, settings ...setting) error {
+		config := NewKuboConfig(release)
+		for _, s := range settings {
+			if err := config.Set(s.key, s.value); err != nil {
+				return err
+			}
+		}
+		return config.Check()
+	}
+
+	DescribeTable("accepts the settings the release reads",
+		func(release Release, settings ...setting) {
+			Expect(apply(release, settings...)).To(Succeed())
+		},
+		Entry("addresses", current, set("Addresses.API", `"/ip4/0.0.0.0/tcp/5001"`),
+			set("Addresses.Swarm",
+				`["/ip4/0.0.0.0/tcp/4001","/ip4/0.0.0.0/udp/4001/quic-v1","/ip4/0.0.0.0/udp/4002/quic-v1/webtransport"]`)),
+		Entry("sizes and durations", current, set("Datastore.StorageMax", `"10GB"`),
+			set("Datastore.GCPeriod", `"1h0m0s"`), set("Reprovider.Interval", `"0"`)),
+		Entry("the sections known by name only", current, set("API.HTTPHeaders", `{"X":["y"]}`)),
+		Entry("a field of a later release on an image without version", latest,
+			set("Provider.WorkerCount", "16")),
+		Entry("a value reset with null", current, set("Routing", "null")),
+		Entry("the bitswap engine", current, set("Internal.Bitswap.EngineTaskWorkerCount", "4"),
+			set("Internal.Bitswap.MaxOutstandingBytesPerPeer", "262144")),
+		Entry("a value only known once the pod runs", current, set("Swarm.ConnMgr.HighWater", "100"),
+			set("Swarm.ConnMgr.LowWater", "200"), setting{key: "Swarm.ConnMgr"}),
+		Entry("delegated routers", Release{Major: 0, Minor: 18}, set("Routing", `{"Type":"custom",`+
+			`"Routers":{"a":{"Type":"http","Parameters":{"Endpoint":"https://a.example"}},`+
+			`"both":{"Type":"parallel","Parameters":{"Routers":[{"RouterName":"a","Timeout":"30s"}]}}},`+
+			`"Methods":{"find-providers":{"RouterName":"both"}}}`)),
+	)
+
+	DescribeTable("refuses the invalid settings, naming their path",
+		func(release Release, message string, settings ...setting) {
+			Expect(apply(release, settings...)).To(MatchError(message))
+		},
+		Entry("an unknown field", current, "Swarm.ConnMgr.Lowwater: unknown field",
+			set("Swarm.ConnMgr.Lowwater", "20")),
+		Entry("a key below a value", current, "Gateway.NoDNSLink.X: is below a value which is not an object",
+			set("Gateway.NoDNSLink.X", "true")),
+		Entry("a value of the wrong type", current, "Swarm.ConnMgr.HighWater: expected an integer, not a string",
+			set("Swarm.ConnMgr.HighWater", `"300"`)),
+		Entry("a nested value of the wrong type", current, "Swarm.RelayClient.Enabled: expected a boolean, not a string",
+			set("Swarm.RelayClient", `{"Enabled":"yes"}`)),
+		Entry("a value below its minimum", current, "Provider.WorkerCount: 0 is below 1",
+			set("Provider.WorkerCount", "0")),
+		Entry("a bitswap setting below its minimum", current, "Internal.Bitswap.MaxOutstandingBytesPerPeer: 0 is below 1",
+			set("Internal.Bitswap.MaxOutstandingBytesPerPeer", "0")),
+		Entry("a value out of its enum", current,
+			`Reprovider.Strategy: "everything" is not one of all, flat, pinned, roots`,
+			set("Reprovider.Strategy", `"everything"`)),
+		Entry("an invalid size", current, `Datastore.StorageMax: invalid size "10 parsecs"`,
+			set("Datastore.StorageMax", `"10 parsecs"`)),
+		Entry("a field the release does not read", Release{Major: 0, Minor: 34},
+			"Provider.WorkerCount: unknown to kubo 0.34, read since kubo 0.35",
+			set("Provider.WorkerCount", "16")),
+		Entry("a nested field the release does not read", Release{Major: 0, Minor: 17},
+			"Routing.Methods: unknown to kubo 0.17, read since kubo 0.18",
+			set("Routing", `{"Methods":{},"Type":"dht"}`)),
+		Entry("crossed bounds of the connection manager", current,
+			"Swarm.ConnMgr.LowWater: 200 exceeds HighWater 100",
+			set("Swarm.ConnMgr.HighWater", "100"), set("Swarm.ConnMgr.LowWater", "200")),
+		Entry("the custom routing without routers", current, "Routing.Routers: the custom type requires routers",
+			set("Routing.Type", `"custom"`)),
+		Entry("a parameter of a router", current,
+			"Routing.Routers.a.Parameters.MaxProvideBatchSize: 0 is below 1",
+			set("Routing.Routers", `{"a":{"Type":"http",`+
+				`"Parameters":{"Endpoint":"https://a.example","MaxProvideBatchSize":0}}}`)),
+		Entry("the endpoint of a router", current, `Routing.Routers.a.Parameters.Endpoint: invalid endpoint "ftp://a"`,
+			set("Routing.Routers", `{"a":{"Type":"http","Parameters":{"Endpoint":"ftp://a"}}}`)),
+		Entry("a router of a composite router", current,
+			`Routing.Routers.both.Parameters.Routers[0].RouterName: no router is named "b"`,
+			set("Routing.Routers", `{"both":{"Type":"sequential","Parameters":{"Routers":[{"RouterName":"b"}]}}}`)),
+		Entry("the router of a method", current, `Routing.Methods.find-peers.RouterName: no router is named "b"`,
+			set("Routing.Routers", `{"a":{"Type":"dht","Parameters":{"Mode":"client"}}}`),
+			set("Routing.Methods", `{"find-peers":{"RouterName":"b"}}`)),
+	)
+
+	It("names the item of a list holding an invalid value", func() {
+		err := apply(current, set("Addresses.Swarm", `["/ip4/0.0.0.0/tcp/4001","/ip4/nope"]`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix(`Addresses.Swarm[1]: invalid multiaddress "/ip4/nope"`))
+	})
+
+	It("checks the keys which are only read", func() {
+		config := NewKuboConfig(Release{Major: 0, Minor: 12})
+		Expect(config.CheckKey("Swarm.ConnMgr")).To(Succeed())
+		Expect(config.CheckKey("Swarm.ResourceMgr")).To(MatchError(
+			"Swarm.ResourceMgr: unknown to kubo 0.12, read since kubo 0.13"))
+	})
+
+	DescribeTable("checks the environment overriding the service.json of ipfs-cluster",
+		func(name string, value *string, message string) {
+			Expect(IsClusterEnv(name)).To(BeTrue())
+			err := CheckClusterEnv(name, value)
+			if message == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(message))
+		},
+		Entry("a duration", "CLUSTER_MONITORPINGINTERVAL", strPtr("15s"), ""),
+		Entry("a value read from a Secret", "CLUSTER_SECRET", nil, ""),
+		Entry("a list of multiaddresses", "CLUSTER_RESTAPI_HTTPLISTENMULTIADDRESS",
+			strPtr("/ip4/0.0.0.0/tcp/9094,/ip4/127.0.0.1/tcp/9095"), ""),
+		Entry("the tags", "CLUSTER_TAGS_TAGS", strPtr("group:default"), ""),
+		Entry("a field of a nested section", "CLUSTER_CRDT_BATCHING_MAXBATCHSIZE", strPtr("100"), ""),
+		Entry("the metrics server", "CLUSTER_METRICS_PROMETHEUSENDPOINT", strPtr("/ip4/0.0.0.0/tcp/8888"), ""),
+		Entry("an unknown variable", "CLUSTER_MONITOR_PING_INTERVAL", strPtr("3m"),
+			"CLUSTER_MONITOR_PING_INTERVAL: no field of the ipfs-cluster configuration is read from it"),
+		Entry("an invalid duration", "CLUSTER_MONITORPINGINTERVAL", strPtr("often"),
+			`cluster.monitor_ping_interval: set by CLUSTER_MONITORPINGINTERVAL: invalid duration "often"`),
+		Entry("an invalid multiaddress", "CLUSTER_RESTAPI_HTTPLISTENMULTIADDRESS",
+			strPtr("/ip4/0.0.0.0/tcp/9094,/ip4/nope"), `api.restapi.http_listen_multiaddress: `+
+				`set by CLUSTER_RESTAPI_HTTPLISTENMULTIADDRESS: invalid multiaddress "/ip4/nope"`),
+		Entry("a value below its minimum", "CLUSTER_CRDT_BATCHING_MAXBATCHSIZE", strPtr("-1"),
+			"consensus.crdt.batching.max_batch_size: set by CLUSTER_CRDT_BATCHING_MAXBATCHSIZE: -1 is below 0"),
+		Entry("a value out of its enum", "CLUSTER_DISK_METRICTYPE", strPtr("cpu"),
+			"informer.disk.metric_type: set by CLUSTER_DISK_METRICTYPE: not one of freespace, reposize"),
+		Entry("an invalid tag", "CLUSTER_TAGS_TAGS", strPtr("default"),
+			"informer.tags.tags: set by CLUSTER_TAGS_TAGS: expected key:value pairs separated by commas"),
+	)
+})
+
+// strPtr Returns a pointer to the string.
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,590 @@
+package configschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kuboConfig Is the schema of the kubo config, following the config package
+// of kubo. The sections the operator writes are described field by field,
+// the others are known by name only. The kubo tag of a field is the first
+// release reading it, the enum tag lists the values of a string besides the
+// empty one, and the min tag is the lowest value of a number.
+type kuboConfig struct {
+	API          interface{}    `json:"API"`
+	Addresses    kuboAddresses  `json:"Addresses"`
+	AutoNAT      interface{}    `json:"AutoNAT"`
+	Bootstrap    []Multiaddr    `json:"Bootstrap"`
+	DNS          kuboDNS        `json:"DNS" kubo:"0.9"`
+	Datastore    kuboDatastore  `json:"Datastore"`
+	Discovery    interface{}    `json:"Discovery"`
+	Experimental interface{}    `json:"Experimental"`
+	Gateway      kuboGateway    `json:"Gateway"`
+	Identity     interface{}    `json:"Identity"`
+	Internal     kuboInternal   `json:"Internal" kubo:"0.13"`
+	Ipns         interface{}    `json:"Ipns"`
+	Migration    interface{}    `json:"Migration" kubo:"0.10"`
+	Mounts       interface{}    `json:"Mounts"`
+	Peering      kuboPeering    `json:"Peering" kubo:"0.6"`
+	Pinning      interface{}    `json:"Pinning" kubo:"0.8"`
+	Plugins      interface{}    `json:"Plugins"`
+	Provider     kuboProvider   `json:"Provider"`
+	Pubsub       interface{}    `json:"Pubsub"`
+	Reprovider   kuboReprovider `json:"Reprovider"`
+	Routing      kuboRouting    `json:"Routing"`
+	Swarm        kuboSwarm      `json:"Swarm"`
+	Version      interface{}    `json:"Version" kubo:"0.28"`
+}
+
+type kuboAddresses struct {
+	API            Multiaddrs  `json:"API"`
+	Announce       []Multiaddr `json:"Announce"`
+	AppendAnnounce []Multiaddr `json:"AppendAnnounce" kubo:"0.10"`
+	Gateway        Multiaddrs  `json:"Gateway"`
+	NoAnnounce     []Multiaddr `json:"NoAnnounce"`
+	Swarm          []Multiaddr `json:"Swarm"`
+}
+
+type kuboDNS struct {
+	MaxCacheTTL Duration          `json:"MaxCacheTTL" kubo:"0.28"`
+	Resolvers   map[string]string `json:"Resolvers"`
+}
+
+type kuboDatastore struct {
+	BloomFilterSize    int64                  `json:"BloomFilterSize" min:"0"`
+	GCPeriod           Duration               `json:"GCPeriod"`
+	HashOnRead         bool                   `json:"HashOnRead"`
+	Spec               map[string]interface{} `json:"Spec"`
+	StorageGCWatermark int64                  `json:"StorageGCWatermark" min:"0"`
+	StorageMax         Size                   `json:"StorageMax"`
+}
+
+type kuboGateway struct {
+	APICommands           []string            `json:"APICommands"`
+	FastDirIndexThreshold int64               `json:"FastDirIndexThreshold" kubo:"0.13" min:"0"`
+	HTTPHeaders           map[string][]string `json:"HTTPHeaders"`
+	NoDNSLink             bool                `json:"NoDNSLink"`
+	NoFetch               bool                `json:"NoFetch"`
+	PathPrefixes          []string            `json:"PathPrefixes"`
+	PublicGateways        interface{}         `json:"PublicGateways"`
+	RootRedirect          string              `json:"RootRedirect"`
+	Writable              bool                `json:"Writable"`
+}
+
+type kuboInternal struct {
+	BackupBootstrapInterval     interface{} `json:"BackupBootstrapInterval"`
+	Bitswap                     kuboBitswap `json:"Bitswap"`
+	Libp2pForceReachability     interface{} `json:"Libp2pForceReachability"`
+	UnixFSShardingSizeThreshold interface{} `json:"UnixFSShardingSizeThreshold"`
+}
+
+type kuboBitswap struct {
+	EngineBlockstoreWorkerCount int64       `json:"EngineBlockstoreWorkerCount" min:"1"`
+	EngineTaskWorkerCount       int64       `json:"EngineTaskWorkerCount" min:"1"`
+	MaxOutstandingBytesPerPeer  int64       `json:"MaxOutstandingBytesPerPeer" min:"1"`
+	ProviderSearchDelay         interface{} `json:"ProviderSearchDelay"`
+	TaskWorkerCount             int64       `json:"TaskWorkerCount" min:"1"`
+}
+
+type kuboPeering struct {
+	Peers []kuboPeer `json:"Peers"`
+}
+
+type kuboPeer struct {
+	Addrs []Multiaddr `json:"Addrs"`
+	ID    string      `json:"ID"`
+}
+
+type kuboProvider struct {
+	Strategy    string `json:"Strategy"`
+	WorkerCount int64  `json:"WorkerCount" kubo:"0.35" min:"1"`
+}
+
+type kuboReprovider struct {
+	Interval Duration `json:"Interval"`
+	Strategy string   `json:"Strategy" enum:"all,flat,pinned,roots"`
+}
+
+type kuboRouting struct {
+	Methods *kuboMethods          `json:"Methods" kubo:"0.18"`
+	Routers map[string]kuboRouter `json:"Routers" kubo:"0.16"`
+	Type    string                `json:"Type" enum:"auto,autoclient,custom,dht,dhtclient,dhtserver,none"`
+}
+
+type kuboRouter struct {
+	Parameters interface{} `json:"Parameters"`
+	Type       string      `json:"Type" enum:"dht,http,parallel,reframe,sequential"`
+}
+
+type kuboMethods struct {
+	FindPeers     *kuboMethod `json:"find-peers"`
+	FindProviders *kuboMethod `json:"find-providers"`
+	GetIPNS       *kuboMethod `json:"get-ipns"`
+	Provide       *kuboMethod `json:"provide"`
+	PutIPNS       *kuboMethod `json:"put-ipns"`
+}
+
+type kuboMethod struct {
+	RouterName string `json:"RouterName"`
+}
+
+// kuboHTTPRouter Is the schema of the parameters of the routers of the http
+// and reframe types.
+type kuboHTTPRouter struct {
+	Endpoint              string `json:"Endpoint"`
+	MaxProvideBatchSize   int64  `json:"MaxProvideBatchSize" min:"1"`
+	MaxProvideConcurrency int64  `json:"MaxProvideConcurrency" min:"1"`
+}
+
+// kuboDHTRouter Is the schema of the parameters of the routers of the dht
+// type.
+type kuboDHTRouter struct {
+	AcceleratedDHTClient bool   `json:"AcceleratedDHTClient"`
+	Mode                 string `json:"Mode" enum:"auto,client,server"`
+	PublicIPNetwork      bool   `json:"PublicIPNetwork"`
+}
+
+// kuboCompositeRouter Is the schema of the parameters of the routers of the
+// parallel and sequential types.
+type kuboCompositeRouter struct {
+	Routers []kuboRouterRef `json:"Routers"`
+}
+
+type kuboRouterRef struct {
+	ExecuteAfter Duration `json:"ExecuteAfter"`
+	IgnoreErrors bool     `json:"IgnoreErrors"`
+	RouterName   string   `json:"RouterName"`
+	Timeout      Duration `json:"Timeout"`
+}
+
+type kuboSwarm struct {
+	AddrFilters             []string        `json:"AddrFilters"`
+	ConnMgr                 kuboConnMgr     `json:"ConnMgr"`
+	DisableBandwidthMetrics bool            `json:"DisableBandwidthMetrics"`
+	DisableNatPortMap       bool            `json:"DisableNatPortMap"`
+	EnableHolePunching      bool            `json:"EnableHolePunching" kubo:"0.11"`
+	RelayClient             kuboRelayClient `json:"RelayClient" kubo:"0.11"`
+	RelayService            interface{}     `json:"RelayService" kubo:"0.11"`
+	ResourceMgr             interface{}     `json:"ResourceMgr" kubo:"0.13"`
+	Transports              interface{}     `json:"Transports"`
+}
+
+type kuboConnMgr struct {
+	GracePeriod Duration `json:"GracePeriod"`
+	HighWater   int64    `json:"HighWater" min:"0"`
+	LowWater    int64    `json:"LowWater" min:"0"`
+	Type        string   `json:"Type" enum:"basic,none"`
+}
+
+type kuboRelayClient struct {
+	Enabled      bool        `json:"Enabled"`
+	StaticRelays []Multiaddr `json:"StaticRelays"`
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// KuboConfig Collects the settings written into the config of a kubo
+// release, checking each one against the schema of the release as it is
+// set, and the settings depending on each other once they are all set.
+type KuboConfig struct {
+	release Release
+	doc     map[string]interface{}
+}
+
+// NewKuboConfig Returns an empty config of the given kubo release.
+func NewKuboConfig(release Release) *KuboConfig {
+	return &KuboConfig{release: release, doc: map[string]interface{}{}}
+}
+
+// Set Checks the value set at the dotted key, as ipfs config --json sets
+// it. A nil value is only known once the pod runs, such as a value read from
+// a file, and only the key is checked.
+func (c *KuboConfig) Set(key string, value []byte) error {
+	t, leaf, err := c.lookup(key)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		c.unset(key)
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err = decoder.Decode(&decoded); err != nil {
+		return &Error{Path: key, Reason: fmt.Sprintf("invalid JSON: %s", err.Error())}
+	}
+	if err = c.conform(key, decoded, t); err != nil {
+		return err
+	}
+	if leaf != nil {
+		if err = checkTags(key, *leaf, decoded); err != nil {
+			return err
+		}
+	}
+	c.store(key, decoded)
+	return nil
+}
+
+// CheckKey Checks the dotted key, as read by ipfs config.
+func (c *KuboConfig) CheckKey(key string) error {
+	_, _, err := c.lookup(key)
+	return err
+}
+
+// Check Checks the settings depending on each other: the bounds of the
+// connection manager and the routers the routing methods and the composite
+// routers refer to.
+func (c *KuboConfig) Check() error {
+	raw, err := json.Marshal(c.doc)
+	if err != nil {
+		return err
+	}
+	// The parameters of the routers are checked as the values being set are,
+	// with their numbers kept as they were written.
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var config kuboConfig
+	if err = decoder.Decode(&config); err != nil {
+		return err
+	}
+	connMgr := config.Swarm.ConnMgr
+	if connMgr.LowWater > 0 && connMgr.HighWater > 0 && connMgr.LowWater > connMgr.HighWater {
+		return &Error{Path: "Swarm.ConnMgr.LowWater", Reason: fmt.Sprintf("%d exceeds HighWater %d",
+			connMgr.LowWater, connMgr.HighWater)}
+	}
+	return c.checkRouting(&config.Routing)
+}
+
+// checkRouting Checks the parameters of the routers against their type, and
+// that the routers referred to exist.
+func (c *KuboConfig) checkRouting(routing *kuboRouting) error {
+	if routing.Type == "custom" && len(routing.Routers) == 0 {
+		return &Error{Path: "Routing.Routers", Reason: "the custom type requires routers"}
+	}
+	names := make([]string, 0, len(routing.Routers))
+	for name := range routing.Routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := "Routing.Routers." + name
+		router := routing.Routers[name]
+		if err := c.checkRouter(path, router, routing.Routers); err != nil {
+			return err
+		}
+	}
+	if routing.Methods == nil {
+		return nil
+	}
+	methods := reflect.ValueOf(*routing.Methods)
+	for i := 0; i < methods.NumField(); i++ {
+		method, ok := methods.Field(i).Interface().(*kuboMethod)
+		if !ok || method == nil {
+			continue
+		}
+		if _, ok = routing.Routers[method.RouterName]; !ok {
+			name := jsonName(methods.Type().Field(i))
+			return &Error{Path: "Routing.Methods." + name + ".RouterName",
+				Reason: fmt.Sprintf("no router is named %q", method.RouterName)}
+		}
+	}
+	return nil
+}
+
+// checkRouter Checks the parameters of the router against its type.
+func (c *KuboConfig) checkRouter(path string, router kuboRouter, routers map[string]kuboRouter) error {
+	parameters := child(path, "Parameters")
+	switch router.Type {
+	case "http", "reframe":
+		if err := c.conform(parameters, router.Parameters, reflect.TypeOf(kuboHTTPRouter{})); err != nil {
+			return err
+		}
+		fields, _ := router.Parameters.(map[string]interface{})
+		endpoint, _ := fields["Endpoint"].(string)
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &Error{Path: child(parameters, "Endpoint"), Reason: fmt.Sprintf("invalid endpoint %q", endpoint)}
+		}
+	case "dht":
+		return c.conform(parameters, router.Parameters, reflect.TypeOf(kuboDHTRouter{}))
+	case "parallel", "sequential":
+		if err := c.conform(parameters, router.Parameters, reflect.TypeOf(kuboCompositeRouter{})); err != nil {
+			return err
+		}
+		raw, _ := json.Marshal(router.Parameters)
+		composite := kuboCompositeRouter{}
+		_ = json.Unmarshal(raw, &composite)
+		for i, ref := range composite.Routers {
+			if _, ok := routers[ref.RouterName]; !ok {
+				return &Error{Path: fmt.Sprintf("%s.Routers[%d].RouterName", parameters, i),
+					Reason: fmt.Sprintf("no router is named %q", ref.RouterName)}
+			}
+		}
+	}
+	return nil
+}
+
+// lookup Returns the type of the schema at the dotted key, which is known
+// to the release, along with the field of the last name of the key when it
+// names the field of a section.
+func (c *KuboConfig) lookup(key string) (reflect.Type, *reflect.StructField, error) {
+	t := reflect.TypeOf(kuboConfig{})
+	var leaf *reflect.StructField
+	path := ""
+	for _, name := range strings.Split(key, ".") {
+		path = child(path, name)
+		if name == "" {
+			return nil, nil, &Error{Path: path, Reason: "empty key"}
+		}
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		leaf = nil
+		switch {
+		case t.Kind() == reflect.Interface:
+			return t, nil, nil
+		case reflect.PtrTo(t).Implements(unmarshalerType):
+			return nil, nil, &Error{Path: path, Reason: "is below a value which is not an object"}
+		case t.Kind() == reflect.Map:
+			t = t.Elem()
+		case t.Kind() == reflect.Struct:
+			field, err := c.field(path, t, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			t = field.Type
+			leaf = &field
+		default:
+			return nil, nil, &Error{Path: path, Reason: "is below a value which is not an object"}
+		}
+	}
+	return t, leaf, nil
+}
+
+// field Returns the field of the struct with the given JSON name, which is
+// known to the release.
+func (c *KuboConfig) field(path string, t reflect.Type, name string) (reflect.StructField, error) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if jsonName(f) != name {
+			continue
+		}
+		if since, ok := f.Tag.Lookup("kubo"); ok && !c.release.latest() && c.release.before(parseRelease(since)) {
+			return f, &Error{Path: path, Reason: fmt.Sprintf("unknown to kubo %s, read since kubo %s", c.release, since)}
+		}
+		return f, nil
+	}
+	return reflect.StructField{}, &Error{Path: path, Reason: "unknown field"}
+}
+
+// jsonName Returns the name of the field in the JSON config.
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// conform Checks that the decoded JSON value fits the type of the schema at
+// the path. A null value resets the setting and fits any type.
+func (c *KuboConfig) conform(path string, value interface{}, t reflect.Type) error {
+	if value == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		raw, _ := json.Marshal(value)
+		target, _ := reflect.New(t).Interface().(json.Unmarshaler)
+		if err := target.UnmarshalJSON(raw); err != nil {
+			return &Error{Path: path, Reason: err.Error()}
+		}
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil
+	case reflect.Struct:
+		return c.conformFields(path, value, t)
+	case reflect.Map:
+		return c.conformEntries(path, value, t)
+	case reflect.Slice:
+		return c.conformItems(path, value, t)
+	}
+	if scalar, ok := scalarKinds[t.Kind()]; ok && !scalar.fits(value) {
+		return mismatch(path, scalar.expected, value)
+	}
+	return nil
+}
+
+// scalarKinds Tells, for the kinds of the scalar settings, what the value is
+// expected to be and whether a decoded JSON value fits.
+var scalarKinds = map[reflect.Kind]struct {
+	expected string
+	fits     func(interface{}) bool
+}{
+	reflect.String: {"a string", func(v interface{}) bool {
+		_, ok := v.(string)
+		return ok
+	}},
+	reflect.Bool: {"a boolean", func(v interface{}) bool {
+		_, ok := v.(bool)
+		return ok
+	}},
+	reflect.Int64: {"an integer", func(v interface{}) bool {
+		n, ok := v.(json.Number)
+		_, err := n.Int64()
+		return ok && err == nil
+	}},
+}
+
+// conformFields Checks that the value is an object whose fields are fields
+// of the struct type, each fitting its type and tags.
+func (c *KuboConfig) conformFields(path string, value interface{}, t reflect.Type) error {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return mismatch(path, "an object", value)
+	}
+	for _, name := range sortedKeys(fields) {
+		f, err := c.field(child(path, name), t, name)
+		if err != nil {
+			return err
+		}
+		if err = c.conform(child(path, name), fields[name], f.Type); err != nil {
+			return err
+		}
+		if err = checkTags(child(path, name), f, fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conformEntries Checks that the value is an object whose entries fit the
+// element type of the map type.
+func (c *KuboConfig) conformEntries(path string, value interface{}, t reflect.Type) error {
+	entries, ok := value.(map[string]interface{})
+	if !ok {
+		return mismatch(path, "an object", value)
+	}
+	for _, name := range sortedKeys(entries) {
+		if err := c.conform(child(path, name), entries[name], t.Elem()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conformItems Checks that the value is a list whose items fit the element
+// type of the slice type.
+func (c *KuboConfig) conformItems(path string, value interface{}, t reflect.Type) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return mismatch(path, "a list", value)
+	}
+	for i, item := range items {
+		if err := c.conform(fmt.Sprintf("%s[%d]", path, i), item, t.Elem()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTags Checks the value of the field against the enum and min tags of
+// the field.
+func checkTags(path string, f reflect.StructField, value interface{}) error {
+	if enum, ok := f.Tag.Lookup("enum"); ok {
+		s, _ := value.(string)
+		allowed := strings.Split(enum, ",")
+		if s != "" && !contains(allowed, s) {
+			return &Error{Path: path, Reason: fmt.Sprintf("%q is not one of %s", s, strings.Join(allowed, ", "))}
+		}
+	}
+	if min, ok := f.Tag.Lookup("min"); ok {
+		n, _ := value.(json.Number)
+		bound, _ := strconv.ParseInt(min, 10, 64)
+		if v, err := n.Int64(); err == nil && v < bound {
+			return &Error{Path: path, Reason: fmt.Sprintf("%d is below %d", v, bound)}
+		}
+	}
+	return nil
+}
+
+// store Writes the value at the dotted key of the collected config.
+func (c *KuboConfig) store(key string, value interface{}) {
+	names := strings.Split(key, ".")
+	doc := c.doc
+	for _, name := range names[:len(names)-1] {
+		next, ok := doc[name].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			doc[name] = next
+		}
+		doc = next
+	}
+	doc[names[len(names)-1]] = value
+}
+
+// unset Removes the value at the dotted key from the collected config, for
+// the values only known once the pod runs.
+func (c *KuboConfig) unset(key string) {
+	names := strings.Split(key, ".")
+	doc := c.doc
+	for _, name := range names[:len(names)-1] {
+		next, ok := doc[name].(map[string]interface{})
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, names[len(names)-1])
+}
+
+// mismatch Returns the error of a value of the wrong type.
+func mismatch(path, expected string, value interface{}) error {
+	var got string
+	switch value.(type) {
+	case map[string]interface{}:
+		got = "an object"
+	case []interface{}:
+		got = "a list"
+	case string:
+		got = "a string"
+	case bool:
+		got = "a boolean"
+	case json.Number:
+		got = "a number"
+	default:
+		got = fmt.Sprintf("%T", value)
+	}
+	return &Error{Path: path, Reason: fmt.Sprintf("expected %s, not %s", expected, got)}
+}
+
+// sortedKeys Returns the keys of the object in order, so that the first
+// error found does not change from one check to the next.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains Returns whether the list holds the value.
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+// Package configschema Checks the settings the operator writes into the
+// config of kubo and into the service.json of ipfs-cluster against the
+// schema of the releases the peers run, so that an unknown field, a value of
+// the wrong type or out of range is refused while reconciling, naming its
+// path, rather than by the daemons once a pod starts with it.
+package configschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Error Is a setting the schema refuses, along with its path in the config.
+type Error struct {
+	Path   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Reason)
+}
+
+// child Returns the path of the field with the given name below the path.
+func child(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// Release Is the major and minor version of a kubo release. The zero
+// Release stands for the latest release, for the images whose version
+// cannot be told from their tag.
+type Release struct {
+	Major int
+	Minor int
+}
+
+// String Returns the release as major.minor.
+func (r Release) String() string {
+	return fmt.Sprintf("%d.%d", r.Major, r.Minor)
+}
+
+// latest Returns whether the release stands for the latest release.
+func (r Release) latest() bool {
+	return r == Release{}
+}
+
+// before Returns whether the release is older than the other one.
+func (r Release) before(other Release) bool {
+	return r.Major < other.Major || (r.Major == other.Major && r.Minor < other.Minor)
+}
+
+// parseRelease Parses a release written as major.minor, as in the tags of
+// the schema.
+func parseRelease(s string) Release {
+	major, minor, _ := strings.Cut(s, ".")
+	r := Release{}
+	r.Major, _ = strconv.Atoi(major)
+	r.Minor, _ = strconv.Atoi(minor)
+	return r
+}
+
+// Duration Is a duration written as a string, as time.ParseDuration reads it.
+type Duration string
+
+// UnmarshalJSON Refuses the strings which are not durations.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a duration string")
+	}
+	if _, err := time.ParseDuration(s); err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(s)
+	return nil
+}
+
+// sizeUnits Are the units of the sizes kubo reads, in lower case.
+var sizeUnits = map[string]bool{
+	"": true, "b": true,
+	"k": true, "kb": true, "kib": true,
+	"m": true, "mb": true, "mib": true,
+	"g": true, "gb": true, "gib": true,
+	"t": true, "tb": true, "tib": true,
+	"p": true, "pb": true, "pib": true,
+}
+
+// Size Is a size written as a string with an optional unit, such as 10GB.
+type Size string
+
+// UnmarshalJSON Refuses the strings which are not sizes.
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("expected a size string")
+	}
+	trimmed := strings.TrimSpace(v)
+	i := strings.IndexFunc(trimmed, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(trimmed)
+	}
+	unit := strings.ToLower(strings.TrimSpace(trimmed[i:]))
+	if _, err := strconv.ParseFloat(trimmed[:i], 64); err != nil || !sizeUnits[unit] {
+		return fmt.Errorf("invalid size %q", v)
+	}
+	*s = Size(v)
+	return nil
+}
+
+// Multiaddr Is a multiaddress written as a string.
+type Multiaddr string
+
+// UnmarshalJSON Refuses the strings which are not multiaddresses.
+func (m *Multiaddr) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a multiaddress string")
+	}
+	if _, err := ma.NewMultiaddr(s); err != nil {
+		return fmt.Errorf("invalid multiaddress %q: %w", s, err)
+	}
+	*m = Multiaddr(s)
+	return nil
+}
+
+// Multiaddrs Is a multiaddress or a list of them, as kubo reads the
+// addresses of its API and gateway.
+type Multiaddrs []Multiaddr
+
+// UnmarshalJSON Reads a single multiaddress or a list of them.
+func (m *Multiaddrs) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), `"`) {
+		var single Multiaddr
+		if err := single.UnmarshalJSON(data); err != nil {
+			return err
+		}
+		*m = Multiaddrs{single}
+		return nil
+	}
+	var list []Multiaddr
+	if err := json.Unmarshal(data, &list); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return fmt.Errorf("expected a multiaddress or a list of them")
+		}
+		return err
+	}
+	*m = list
+	return nil
+}
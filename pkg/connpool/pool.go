@@ -0,0 +1,260 @@
+// Package connpool Bounds the HTTP calls the controllers make to the APIs of
+// the peers, shared by every cluster, so that a large fleet does not open
+// hundreds of connections at once. Calls beyond the budget wait for a slot,
+// and the operator stretches its sync intervals while they do.
+package connpool
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults of the pool.
+const (
+	DefaultMaxInFlight    = 64
+	DefaultMaxPerHost     = 4
+	DefaultMaxIdlePerHost = 2
+	DefaultIdleTimeout    = 90 * time.Second
+	// MaxStretch Is the factor the sync intervals are stretched by while
+	// every call waits for a slot.
+	MaxStretch = 4
+	// saturationWeight Is the weight of the latest call in the share of the
+	// calls which waited for a slot.
+	saturationWeight = 0.05
+)
+
+// Settings Tunes the pool. Fields left empty keep the defaults.
+type Settings struct {
+	// MaxInFlight Bounds the calls in flight across every host.
+	MaxInFlight int
+	// MaxPerHost Bounds the calls in flight to a single host, hence its
+	// connections.
+	MaxPerHost int
+	// MaxIdlePerHost Is the number of idle connections kept open to each
+	// host for the next calls.
+	MaxIdlePerHost int
+	// IdleTimeout Is how long an idle connection is kept open.
+	IdleTimeout time.Duration
+}
+
+// withDefaults Returns the settings with the defaults filled in.
+func (s Settings) withDefaults() Settings {
+	if s.MaxInFlight <= 0 {
+		s.MaxInFlight = DefaultMaxInFlight
+	}
+	if s.MaxPerHost <= 0 {
+		s.MaxPerHost = DefaultMaxPerHost
+	}
+	if s.MaxIdlePerHost <= 0 {
+		s.MaxIdlePerHost = DefaultMaxIdlePerHost
+	}
+	if s.IdleTimeout <= 0 {
+		s.IdleTimeout = DefaultIdleTimeout
+	}
+	return s
+}
+
+// host Is the slots of a host, and the number of calls holding or waiting
+// for one, so that the slots of the hosts no call uses are dropped.
+type host struct {
+	slots chan struct{}
+	users int
+}
+
+// Pool Hands out the slots of the calls. A call holds a slot of its host and
+// a global one from the moment it is sent until its response body is closed.
+type Pool struct {
+	settings  Settings
+	global    chan struct{}
+	transport *http.Transport
+	client    *http.Client
+
+	mu         sync.Mutex
+	hosts      map[string]*host
+	saturation float64
+
+	inFlight  prometheus.Gauge
+	queueWait prometheus.Histogram
+}
+
+// New Returns an empty pool, whose connections are kept open by a transport
+// shared by the clients of the pool.
+func New(settings Settings) *Pool {
+	settings = settings.withDefaults()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = settings.MaxPerHost
+	transport.MaxIdleConnsPerHost = settings.MaxIdlePerHost
+	transport.MaxIdleConns = settings.MaxInFlight
+	transport.IdleConnTimeout = settings.IdleTimeout
+	p := &Pool{
+		settings:  settings,
+		global:    make(chan struct{}, settings.MaxInFlight),
+		transport: transport,
+		hosts:     map[string]*host{},
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfs_operator_api_calls_in_flight",
+			Help: "Number of the calls to the APIs of the peers in flight.",
+		}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ipfs_operator_api_call_queue_wait_seconds",
+			Help:    "Time the calls to the APIs of the peers waited for a slot.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+	}
+	p.client = &http.Client{Transport: p.Wrap(transport)}
+	return p
+}
+
+// Collectors Returns the metrics of the pool, to be registered by the caller.
+func (p *Pool) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.inFlight, p.queueWait}
+}
+
+// Client Returns an HTTP client sending its calls through the pool. The
+// default client is replaced by the shared one, whose connections are
+// reused across the clients of the pool; others keep their transport.
+func (p *Pool) Client(base *http.Client) *http.Client {
+	if base == nil || base == http.DefaultClient {
+		return p.client
+	}
+	c := *base
+	transport := c.Transport
+	if transport == nil {
+		transport = p.transport
+	}
+	c.Transport = p.Wrap(transport)
+	return &c
+}
+
+// Wrap Returns a round tripper holding the slots of the pool around the
+// calls of the given one.
+func (p *Pool) Wrap(next http.RoundTripper) http.RoundTripper {
+	return roundTripper{pool: p, next: next}
+}
+
+// CloseIdleConnections Closes the idle connections of the shared transport.
+func (p *Pool) CloseIdleConnections() {
+	p.transport.CloseIdleConnections()
+}
+
+// Saturation Returns the share of the recent calls which waited for a slot,
+// between 0 and 1.
+func (p *Pool) Saturation() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.saturation
+}
+
+// Stretch Returns the interval stretched after the saturation of the pool,
+// up to MaxStretch times, so that the sync loops slow down rather than pile
+// up calls waiting for a slot.
+func (p *Pool) Stretch(interval time.Duration) time.Duration {
+	return interval + time.Duration(float64(interval)*(MaxStretch-1)*p.Saturation())
+}
+
+// acquire Waits for a slot of the host, then for a global one, in that
+// order so that the calls to a busy host do not hold global slots. It
+// returns the function releasing them, or the error of the request context.
+func (p *Pool) acquire(req *http.Request) (func(), error) {
+	ctx := req.Context()
+	key := req.URL.Host
+	p.mu.Lock()
+	h, ok := p.hosts[key]
+	if !ok {
+		h = &host{slots: make(chan struct{}, p.settings.MaxPerHost)}
+		p.hosts[key] = h
+	}
+	h.users++
+	p.mu.Unlock()
+
+	start := time.Now()
+	waited := false
+	leave := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		h.users--
+		if h.users == 0 {
+			delete(p.hosts, key)
+		}
+	}
+	for _, slots := range []chan struct{}{h.slots, p.global} {
+		select {
+		case slots <- struct{}{}:
+			continue
+		default:
+		}
+		waited = true
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			if slots == p.global {
+				<-h.slots
+			}
+			leave()
+			return nil, ctx.Err()
+		}
+	}
+	p.queueWait.Observe(time.Since(start).Seconds())
+	p.record(waited)
+	p.inFlight.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.inFlight.Dec()
+			<-p.global
+			<-h.slots
+			leave()
+		})
+	}, nil
+}
+
+// record Folds whether a call waited for a slot into the saturation.
+func (p *Pool) record(waited bool) {
+	sample := 0.0
+	if waited {
+		sample = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.saturation += saturationWeight * (sample - p.saturation)
+}
+
+// roundTripper Holds the slots of the pool around the calls of next.
+type roundTripper struct {
+	pool *Pool
+	next http.RoundTripper
+}
+
+// RoundTrip Sends the call once it holds its slots, which are released when
+// the response body is closed, or at once when the call fails.
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.pool.acquire(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody Releases the slots of its call once closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close Closes the body and releases the slots of the call.
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
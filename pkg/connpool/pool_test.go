@@ -0,0 +1,157 @@
+package connpool
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// peer Is the API of a peer, counting the calls it serves at once and the
+// connections opened to it.
+type peer struct {
+	server   *httptest.Server
+	inFlight int32
+	maxCalls int32
+	conns    int32
+	maxConns int32
+}
+
+// raise Bumps the counter and the highest value it reached.
+func raise(counter *int32, highest *int32) {
+	n := atomic.AddInt32(counter, 1)
+	for {
+		old := atomic.LoadInt32(highest)
+		if n <= old || atomic.CompareAndSwapInt32(highest, old, n) {
+			return
+		}
+	}
+}
+
+// newPeer Starts a peer answering after the given delay, and bumps the global
+// count of the calls in flight.
+func newPeer(delay time.Duration, inFlight *int32, maxInFlight *int32) *peer {
+	p := &peer{}
+	p.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		raise(&p.inFlight, &p.maxCalls)
+		raise(inFlight, maxInFlight)
+		defer atomic.AddInt32(&p.inFlight, -1)
+		defer atomic.AddInt32(inFlight, -1)
+		time.Sleep(delay)
+		_, _ = io.WriteString(w, "{}")
+	}))
+	p.server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			raise(&p.conns, &p.maxConns)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt32(&p.conns, -1)
+		}
+	}
+	p.server.Start()
+	return p
+}
+
+var _ = Describe("Pool", func() {
+	It("bounds the calls and the connections of a large fleet", func() {
+		const (
+			peers        = 40
+			callsPerPeer = 12
+		)
+		baseline := runtime.NumGoroutine()
+		var inFlight, maxInFlight int32
+		fleet := make([]*peer, peers)
+		for i := range fleet {
+			fleet[i] = newPeer(5*time.Millisecond, &inFlight, &maxInFlight)
+		}
+		defer func() {
+			for _, p := range fleet {
+				p.server.Close()
+			}
+		}()
+
+		pool := New(Settings{MaxInFlight: 16, MaxPerHost: 2, MaxIdlePerHost: 1, IdleTimeout: time.Second})
+		client := pool.Client(nil)
+		var wg sync.WaitGroup
+		var failures int32
+		for _, p := range fleet {
+			for j := 0; j < callsPerPeer; j++ {
+				wg.Add(1)
+				go func(url string) {
+					defer wg.Done()
+					resp, err := client.Get(url)
+					if err != nil {
+						atomic.AddInt32(&failures, 1)
+						return
+					}
+					_, _ = io.Copy(io.Discard, resp.Body)
+					_ = resp.Body.Close()
+				}(p.server.URL)
+			}
+		}
+		wg.Wait()
+
+		Expect(failures).To(BeZero())
+		Expect(maxInFlight).To(BeNumerically("<=", 16))
+		for _, p := range fleet {
+			Expect(p.maxCalls).To(BeNumerically("<=", 2))
+			Expect(p.maxConns).To(BeNumerically("<=", 2))
+		}
+		Expect(pool.Saturation()).To(BeNumerically(">", 0))
+		Expect(pool.hosts).To(BeEmpty())
+
+		By("dropping the idle connections and their goroutines")
+		pool.CloseIdleConnections()
+		Eventually(func() int32 {
+			var open int32
+			for _, p := range fleet {
+				open += atomic.LoadInt32(&p.conns)
+			}
+			return open
+		}).Should(BeZero())
+		for _, p := range fleet {
+			p.server.Close()
+		}
+		Eventually(runtime.NumGoroutine, 5*time.Second).Should(BeNumerically("<=", baseline+5))
+	})
+
+	It("gives up a queued call when its context ends", func() {
+		release := make(chan struct{})
+		var inFlight, maxInFlight int32
+		p := newPeer(0, &inFlight, &maxInFlight)
+		defer p.server.Close()
+		pool := New(Settings{MaxInFlight: 1})
+		held, err := pool.acquire(httptest.NewRequest(http.MethodGet, p.server.URL, nil))
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			<-release
+			held()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, p.server.URL, nil)
+		_, err = pool.Client(nil).Do(req)
+		Expect(err).To(MatchError(ContainSubstring(context.DeadlineExceeded.Error())))
+		close(release)
+		Eventually(func() int { return len(pool.global) }).Should(BeZero())
+	})
+
+	It("stretches the intervals while the calls wait", func() {
+		pool := New(Settings{})
+		Expect(pool.Stretch(time.Minute)).To(Equal(time.Minute))
+		for i := 0; i < 200; i++ {
+			pool.record(true)
+		}
+		Expect(pool.Stretch(time.Minute)).To(BeNumerically(">", 3*time.Minute))
+		Expect(pool.Stretch(time.Minute)).To(BeNumerically("<=", MaxStretch*time.Minute))
+	})
+})
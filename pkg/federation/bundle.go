@@ -0,0 +1,161 @@
+// Package federation Signs and opens the peer bundles through which the
+// clusters running in different Kubernetes clusters learn about the peers
+// of each other. A bundle is signed by the identity of the bootstrap peer of
+// the cluster exporting it, so that it cannot be altered on its way to the
+// clusters importing it, and it expires, so that a cluster which stopped
+// exporting is eventually forgotten.
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/redhat-et/ipfs-operator/pkg/maddr"
+)
+
+// BundleKey Is the key of the Secrets holding a signed bundle.
+const BundleKey = "bundle.json"
+
+var (
+	// ErrExpired Is returned when opening a bundle past its expiry.
+	ErrExpired = errors.New("bundle expired")
+	// ErrSignature Is returned when opening a bundle which was not signed by
+	// its signer.
+	ErrSignature = errors.New("bundle signature does not match its signer")
+)
+
+// Peer Is a peer of a federated cluster and the addresses it is reachable at.
+type Peer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// Bundle Describes the peers of a cluster to the clusters federated with it.
+type Bundle struct {
+	// Cluster Names the exporting cluster, for the status of the importers.
+	Cluster string `json:"cluster"`
+	// Signer Is the peer ID of the key which signed the bundle.
+	Signer    string    `json:"signer"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// Peers Are the kubo nodes of the cluster, with their externally
+	// reachable addresses.
+	Peers []Peer `json:"peers"`
+	// Relays Are the circuit relays the peers are reachable through.
+	Relays []Peer `json:"relays,omitempty"`
+}
+
+// envelope Is the serialized form of a signed bundle.
+type envelope struct {
+	Bundle    json.RawMessage `json:"bundle"`
+	PublicKey []byte          `json:"publicKey"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign Serializes the bundle signed by the key, which it records as the
+// signer of the bundle.
+func Sign(b Bundle, key ci.PrivKey) ([]byte, error) {
+	signer, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive signer: %w", err)
+	}
+	b.Signer = signer.String()
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize bundle: %w", err)
+	}
+	signature, err := key.Sign(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign bundle: %w", err)
+	}
+	pub, err := ci.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize public key: %w", err)
+	}
+	return json.Marshal(envelope{Bundle: raw, PublicKey: pub, Signature: signature})
+}
+
+// Open Verifies a signed bundle and returns it. It fails with ErrSignature
+// when the bundle was altered, and with ErrExpired when it expired at now.
+func Open(data []byte, now time.Time) (*Bundle, error) {
+	env := envelope{}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("cannot parse bundle: %w", err)
+	}
+	b := &Bundle{}
+	if err := json.Unmarshal(env.Bundle, b); err != nil {
+		return nil, fmt.Errorf("cannot parse bundle: %w", err)
+	}
+	signer, err := peer.IDB58Decode(b.Signer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer %q: %w", b.Signer, err)
+	}
+	pub, err := ci.UnmarshalPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if !signer.MatchesPublicKey(pub) {
+		return nil, ErrSignature
+	}
+	if ok, err := pub.Verify(env.Bundle, env.Signature); err != nil || !ok {
+		return nil, ErrSignature
+	}
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	if !now.Before(b.ExpiresAt) {
+		return nil, fmt.Errorf("%w at %s", ErrExpired, b.ExpiresAt.Format(time.RFC3339))
+	}
+	return b, nil
+}
+
+// validate Checks that the peers of the bundle are well formed. Their
+// addresses leave out the peer ID, which is set apart.
+func (b *Bundle) validate() error {
+	fields := []struct {
+		name  string
+		peers []Peer
+	}{{"peers", b.Peers}, {"relays", b.Relays}}
+	for _, field := range fields {
+		for i, p := range field.peers {
+			if _, err := peer.IDB58Decode(p.ID); err != nil {
+				return fmt.Errorf("%s[%d].id: invalid peer ID %q: %w", field.name, i, p.ID, err)
+			}
+			path := fmt.Sprintf("%s[%d].addrs", field.name, i)
+			if _, err := maddr.Normalize(path, p.Addrs, maddr.PeerForbidden); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddrInfos Returns the peers and relays of the bundle, to peer with, each
+// with its addresses normalized.
+func (b *Bundle) AddrInfos() []*peer.AddrInfo {
+	infos := []*peer.AddrInfo{}
+	for _, peers := range [][]Peer{b.Peers, b.Relays} {
+		for _, p := range peers {
+			id, err := peer.IDB58Decode(p.ID)
+			if err != nil {
+				continue
+			}
+			addrs, err := maddr.Normalize("addrs", p.Addrs, maddr.PeerForbidden)
+			if err != nil {
+				continue
+			}
+			info := &peer.AddrInfo{ID: id}
+			for _, addr := range addrs {
+				info.Addrs = append(info.Addrs, ma.StringCast(addr))
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
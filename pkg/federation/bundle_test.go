@@ -0,0 +1,103 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bundle", func() {
+	var (
+		key    ci.PrivKey
+		now    time.Time
+		bundle Bundle
+	)
+
+	newID := func() string {
+		_, pub, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+		Expect(err).NotTo(HaveOccurred())
+		id, err := peer.IDFromPublicKey(pub)
+		Expect(err).NotTo(HaveOccurred())
+		return id.String()
+	}
+
+	BeforeEach(func() {
+		var err error
+		key, _, err = ci.GenerateKeyPair(ci.Ed25519, 0)
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Now().UTC().Truncate(time.Second)
+		relay := newID()
+		bundle = Bundle{
+			Cluster:   "east/default/ipfs",
+			IssuedAt:  now,
+			ExpiresAt: now.Add(time.Hour),
+			Peers: []Peer{{
+				ID:    newID(),
+				Addrs: []string{"/ip4/203.0.113.7/tcp/4001/p2p/" + relay + "/p2p-circuit"},
+			}},
+			Relays: []Peer{{ID: relay, Addrs: []string{"/ip4/203.0.113.7/tcp/4001"}}},
+		}
+	})
+
+	It("opens the bundle it signed", func() {
+		data, err := Sign(bundle, key)
+		Expect(err).NotTo(HaveOccurred())
+		opened, err := Open(data, now)
+		Expect(err).NotTo(HaveOccurred())
+		signer, err := peer.IDFromPrivateKey(key)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opened.Signer).To(Equal(signer.String()))
+		Expect(opened.Peers).To(Equal(bundle.Peers))
+		infos := opened.AddrInfos()
+		Expect(infos).To(HaveLen(2))
+		Expect(infos[0].Addrs).To(HaveLen(1))
+	})
+
+	It("refuses an altered bundle", func() {
+		data, err := Sign(bundle, key)
+		Expect(err).NotTo(HaveOccurred())
+		env := envelope{}
+		Expect(json.Unmarshal(data, &env)).To(Succeed())
+		signed := Bundle{}
+		Expect(json.Unmarshal(env.Bundle, &signed)).To(Succeed())
+		signed.Peers[0].Addrs = []string{"/ip4/198.51.100.1/tcp/4001"}
+		env.Bundle, err = json.Marshal(signed)
+		Expect(err).NotTo(HaveOccurred())
+		altered, err := json.Marshal(env)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Open(altered, now)
+		Expect(errors.Is(err, ErrSignature)).To(BeTrue())
+
+		By("refusing a bundle signed by another key than its signer")
+		other, _, err := ci.GenerateKeyPair(ci.Ed25519, 0)
+		Expect(err).NotTo(HaveOccurred())
+		env.PublicKey, err = ci.MarshalPublicKey(other.GetPublic())
+		Expect(err).NotTo(HaveOccurred())
+		env.Signature, err = other.Sign(env.Bundle)
+		Expect(err).NotTo(HaveOccurred())
+		forged, err := json.Marshal(env)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Open(forged, now)
+		Expect(errors.Is(err, ErrSignature)).To(BeTrue())
+	})
+
+	It("refuses an expired or malformed bundle", func() {
+		data, err := Sign(bundle, key)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Open(data, now.Add(time.Hour))
+		Expect(errors.Is(err, ErrExpired)).To(BeTrue())
+
+		bundle.Peers[0].Addrs = []string{"not-an-address"}
+		data, err = Sign(bundle, key)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = Open(data, now)
+		Expect(err).To(MatchError(ContainSubstring(`peers[0].addrs[0]: invalid multiaddress "not-an-address"`)))
+		_, err = Open([]byte("{"), now)
+		Expect(err).To(HaveOccurred())
+	})
+})
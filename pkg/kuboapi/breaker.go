@@ -0,0 +1,173 @@
+package kuboapi
+
+import (
+	"context"
+
+	"github.com/redhat-et/ipfs-operator/pkg/breaker"
+)
+
+// guardedAPI Sends the commands of an API through a circuit breaker.
+type guardedAPI struct {
+	api     API
+	breaker *breaker.Breaker
+}
+
+// Guarded Returns the API with every command, its retries included, guarded
+// by the given circuit breaker, so that the commands fail at once while the
+// node cannot be reached. A breaker may guard the nodes of several peers.
+func Guarded(api API, b *breaker.Breaker) API {
+	return &guardedAPI{api: api, breaker: b}
+}
+
+func (g *guardedAPI) ID(ctx context.Context) (*IDOutput, error) {
+	var out *IDOutput
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.ID(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) RepoStat(ctx context.Context) (*RepoStat, error) {
+	var out *RepoStat
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.RepoStat(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) ProvideStats(ctx context.Context) (*ProvideStats, error) {
+	var out *ProvideStats
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.ProvideStats(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) KeyImport(ctx context.Context, name string, key []byte) (*Key, error) {
+	var out *Key
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.KeyImport(ctx, name, key)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) SwarmPeers(ctx context.Context) ([]SwarmPeer, error) {
+	var out []SwarmPeer
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.SwarmPeers(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) SwarmConnect(ctx context.Context, addr string) error {
+	return g.breaker.Do(ctx, func(ctx context.Context) error {
+		return g.api.SwarmConnect(ctx, addr)
+	}, unreachable)
+}
+
+func (g *guardedAPI) SwarmAddrsListen(ctx context.Context) ([]string, error) {
+	var out []string
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.SwarmAddrsListen(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) SwarmPeeringLs(ctx context.Context) ([]string, error) {
+	var out []string
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.SwarmPeeringLs(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) PinLs(ctx context.Context, pinType string) (map[string]string, error) {
+	var out map[string]string
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.PinLs(ctx, pinType)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) GatewayStats(ctx context.Context) (*GatewayStats, error) {
+	var out *GatewayStats
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.GatewayStats(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) SwarmResources(ctx context.Context) (*ResourceLimits, error) {
+	var out *ResourceLimits
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.SwarmResources(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) StatsBW(ctx context.Context) (*BandwidthStats, error) {
+	var out *BandwidthStats
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.StatsBW(ctx)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) Add(ctx context.Context, name string, data []byte) (string, error) {
+	var out string
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.Add(ctx, name, data)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) Cat(ctx context.Context, cid string) ([]byte, error) {
+	var out []byte
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.Cat(ctx, cid)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) FilesLs(ctx context.Context, path string) ([]FilesEntry, error) {
+	var out []FilesEntry
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		out, err = g.api.FilesLs(ctx, path)
+		return err
+	}, unreachable)
+	return out, err
+}
+
+func (g *guardedAPI) PinAdd(ctx context.Context, cid string, recursive bool) error {
+	return g.breaker.Do(ctx, func(ctx context.Context) error {
+		return g.api.PinAdd(ctx, cid, recursive)
+	}, unreachable)
+}
+
+func (g *guardedAPI) PinRm(ctx context.Context, cid string, recursive bool) error {
+	return g.breaker.Do(ctx, func(ctx context.Context) error {
+		return g.api.PinRm(ctx, cid, recursive)
+	}, unreachable)
+}
+
+func (g *guardedAPI) RepoGC(ctx context.Context) (int64, error) {
+	var removed int64
+	err := g.breaker.Do(ctx, func(ctx context.Context) (err error) {
+		removed, err = g.api.RepoGC(ctx)
+		return err
+	}, unreachable)
+	return removed, err
+}
@@ -0,0 +1,656 @@
+// Package kuboapi Is a client for the RPC API of the kubo node running in
+// each peer pod. Controllers depend on the API and Dialer interfaces so that
+// their tests can substitute the fakes of the fake package.
+package kuboapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/redhat-et/ipfs-operator/pkg/connpool"
+)
+
+const (
+	// DefaultPort Is the port kubo serves its RPC API on.
+	DefaultPort = 5001
+	// DefaultTimeout Bounds a single attempt of a command.
+	DefaultTimeout = 10 * time.Second
+	// GCTimeout Bounds a garbage collection of the repo, which walks every
+	// pin and block and outlasts the timeout of the client.
+	GCTimeout = 30 * time.Minute
+	// MetricsPath Is the path kubo serves its Prometheus metrics on, next to
+	// its RPC API.
+	MetricsPath = "/debug/metrics/prometheus"
+)
+
+// Metrics kubo records for the requests served by its HTTP handlers. The
+// gateway ones are labeled with handler="gateway".
+const (
+	metricRequests      = "ipfs_http_requests_total"
+	metricResponseBytes = "ipfs_http_response_size_bytes_sum"
+	gatewayHandler      = `handler="gateway"`
+)
+
+// MaxCatBytes Bounds the content Cat reads, which is meant for small
+// payloads.
+const MaxCatBytes = 1 << 20
+
+// addChunker Is the chunker of the content Add sends, the default of kubo.
+const addChunker = "size-262144"
+
+// DefaultBackoff Is the schedule used to retry read-only commands which failed
+// because the node could not be reached.
+var DefaultBackoff = wait.Backoff{
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    3,
+}
+
+// API Is the subset of the kubo RPC API the operator uses.
+type API interface {
+	// ID Returns the identity of the node.
+	ID(ctx context.Context) (*IDOutput, error)
+	// RepoStat Returns the size of the repository of the node.
+	RepoStat(ctx context.Context) (*RepoStat, error)
+	// KeyImport Imports a libp2p private key into the keystore under the given name.
+	KeyImport(ctx context.Context, name string, key []byte) (*Key, error)
+	// SwarmPeers Lists the peers the node is connected to.
+	SwarmPeers(ctx context.Context) ([]SwarmPeer, error)
+	// SwarmConnect Connects the node to the peer at the given multiaddress.
+	SwarmConnect(ctx context.Context, addr string) error
+	// SwarmAddrsListen Lists the multiaddresses the node listens on.
+	SwarmAddrsListen(ctx context.Context) ([]string, error)
+	// SwarmPeeringLs Lists the peer IDs of the peering set of the node,
+	// whose connections the connection manager protects.
+	SwarmPeeringLs(ctx context.Context) ([]string, error)
+	// PinLs Returns the pins of the given type held by the node, keyed by CID.
+	PinLs(ctx context.Context, pinType string) (map[string]string, error)
+	// PinAdd Pins the CID on the node, fetching its blocks from the other
+	// nodes. A direct pin only fetches the root block.
+	PinAdd(ctx context.Context, cid string, recursive bool) error
+	// PinRm Removes the pin of the CID from the node.
+	PinRm(ctx context.Context, cid string, recursive bool) error
+	// ProvideStats Returns how the node announces its content to the DHT.
+	ProvideStats(ctx context.Context) (*ProvideStats, error)
+	// GatewayStats Returns the requests served by the gateway of the node
+	// since it started.
+	GatewayStats(ctx context.Context) (*GatewayStats, error)
+	// SwarmResources Returns the limits the resource manager of the node
+	// enforces across the whole node.
+	SwarmResources(ctx context.Context) (*ResourceLimits, error)
+	// StatsBW Returns the traffic of the node across all of its protocols.
+	StatsBW(ctx context.Context) (*BandwidthStats, error)
+	// Add Adds the content to the node without pinning it, and returns its
+	// CIDv1. The same content always yields the same CID.
+	Add(ctx context.Context, name string, data []byte) (string, error)
+	// Cat Returns the content of the CID, fetching it from the other nodes
+	// when the node does not hold it.
+	Cat(ctx context.Context, cid string) ([]byte, error)
+	// RepoGC Removes the blocks of the repo no pin holds, and returns how
+	// many it removed.
+	RepoGC(ctx context.Context) (int64, error)
+	// FilesLs Lists the entries of a directory of the MFS of the node.
+	FilesLs(ctx context.Context, path string) ([]FilesEntry, error)
+}
+
+// Dialer Returns the API of the kubo node running in a peer pod.
+type Dialer interface {
+	Dial(namespace, service, pod string) (API, error)
+}
+
+// PodDialer Dials peer pods through their DNS name on the Service governing
+// their StatefulSet.
+type PodDialer struct {
+	// Port Is the port of the RPC API, or of the API proxy in front of it.
+	Port int32
+	// Options Configure every client, for example with the credentials of the API proxy.
+	Options []Option
+}
+
+var _ Dialer = PodDialer{}
+
+// Dial Returns a client of the kubo node of the given pod.
+func (d PodDialer) Dial(namespace, service, pod string) (API, error) {
+	port := d.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return New(fmt.Sprintf("http://%s.%s.%s.svc:%d", pod, service, namespace, port), d.Options...), nil
+}
+
+// Client Talks to the RPC API of a single kubo node.
+type Client struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+	timeout    time.Duration
+	backoff    wait.Backoff
+	pool       *connpool.Pool
+}
+
+var _ API = &Client{}
+
+// Option Configures a Client.
+type Option func(*Client)
+
+// WithBasicAuth Authenticates every command with the given credentials, as
+// required by the API proxy sidecar.
+func WithBasicAuth(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithHTTPClient Sends the commands through the given HTTP client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout Bounds every attempt of a command to the given duration.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithBackoff Retries failed read-only commands on the given schedule. A
+// backoff of a single step disables retries.
+func WithBackoff(backoff wait.Backoff) Option {
+	return func(c *Client) {
+		c.backoff = backoff
+	}
+}
+
+// WithPool Sends the commands through the given pool, which bounds the calls
+// in flight across the clients sharing it.
+func WithPool(pool *connpool.Pool) Option {
+	return func(c *Client) {
+		c.pool = pool
+	}
+}
+
+// New Returns a client of the RPC API served at baseURL.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    baseURL,
+		httpClient: http.DefaultClient,
+		timeout:    DefaultTimeout,
+		backoff:    DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.pool != nil {
+		c.httpClient = c.pool.Client(c.httpClient)
+	}
+	return c
+}
+
+// ID Returns the identity of the node.
+func (c *Client) ID(ctx context.Context) (*IDOutput, error) {
+	out := &IDOutput{}
+	if err := c.read(ctx, "id", nil, out); err != nil {
+		return nil, fmt.Errorf("cannot get node id: %w", err)
+	}
+	return out, nil
+}
+
+// RepoStat Returns the size of the repository of the node.
+func (c *Client) RepoStat(ctx context.Context) (*RepoStat, error) {
+	out := &RepoStat{}
+	if err := c.read(ctx, "repo/stat", nil, out); err != nil {
+		return nil, fmt.Errorf("cannot get repo stat: %w", err)
+	}
+	return out, nil
+}
+
+// ProvideStats Returns how the node announces its content to the DHT.
+func (c *Client) ProvideStats(ctx context.Context) (*ProvideStats, error) {
+	out := &ProvideStats{}
+	if err := c.read(ctx, "stats/provide", nil, out); err != nil {
+		return nil, fmt.Errorf("cannot get provide stats: %w", err)
+	}
+	return out, nil
+}
+
+// KeyImport Imports a libp2p private key into the keystore under the given
+// name. The command is not retried, as kubo refuses to import a name twice.
+func (c *Client) KeyImport(ctx context.Context, name string, key []byte) (*Key, error) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("key", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(key); err != nil {
+		return nil, err
+	}
+	if err = form.Close(); err != nil {
+		return nil, err
+	}
+	out := &Key{}
+	_, err = c.attempt(ctx, "key/import", url.Values{"arg": {name}}, form.FormDataContentType(), body, out)
+	if err != nil {
+		return nil, fmt.Errorf("cannot import key %s: %w", name, err)
+	}
+	return out, nil
+}
+
+// Add Adds the content to the node as a single file, without pinning it, and
+// returns its CIDv1 with raw leaves. The chunker and the hash are set rather
+// than left to the config of the node, so that the same content always gets
+// the same CID. The command is not retried, as the content would have to be
+// sent again.
+func (c *Client) Add(ctx context.Context, name string, data []byte) (string, error) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(data); err != nil {
+		return "", err
+	}
+	if err = form.Close(); err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"pin":         {"false"},
+		"cid-version": {"1"},
+		"raw-leaves":  {"true"},
+		"chunker":     {addChunker},
+		"hash":        {"sha2-256"},
+	}
+	out := struct {
+		Hash string `json:"Hash"`
+	}{}
+	if _, err = c.attempt(ctx, "add", query, form.FormDataContentType(), body, &out); err != nil {
+		return "", fmt.Errorf("cannot add %s: %w", name, err)
+	}
+	return out.Hash, nil
+}
+
+// Cat Returns the content of the CID, up to MaxCatBytes, fetching it from
+// the other nodes when the node does not hold it.
+func (c *Client) Cat(ctx context.Context, cid string) ([]byte, error) {
+	out := &bytes.Buffer{}
+	query := url.Values{"arg": {cid}, "length": {strconv.Itoa(MaxCatBytes)}}
+	if err := c.read(ctx, "cat", query, out); err != nil {
+		return nil, fmt.Errorf("cannot cat %s: %w", cid, err)
+	}
+	return out.Bytes(), nil
+}
+
+// FilesLs Lists the entries of a directory of the MFS of the node, with
+// their CIDs and sizes.
+func (c *Client) FilesLs(ctx context.Context, path string) ([]FilesEntry, error) {
+	out := struct {
+		Entries []FilesEntry `json:"Entries"`
+	}{}
+	if err := c.read(ctx, "files/ls", url.Values{"arg": {path}, "long": {"true"}}, &out); err != nil {
+		return nil, fmt.Errorf("cannot list %s: %w", path, err)
+	}
+	return out.Entries, nil
+}
+
+// SwarmPeers Lists the peers the node is connected to.
+func (c *Client) SwarmPeers(ctx context.Context) ([]SwarmPeer, error) {
+	out := struct {
+		Peers []SwarmPeer `json:"Peers"`
+	}{}
+	if err := c.read(ctx, "swarm/peers", nil, &out); err != nil {
+		return nil, fmt.Errorf("cannot list swarm peers: %w", err)
+	}
+	return out.Peers, nil
+}
+
+// SwarmConnect Connects the node to the peer at the given multiaddress. The
+// command is not retried, as kubo already tries every address it knows.
+func (c *Client) SwarmConnect(ctx context.Context, addr string) error {
+	out := struct {
+		Strings []string `json:"Strings"`
+	}{}
+	if _, err := c.attempt(ctx, "swarm/connect", url.Values{"arg": {addr}}, "", nil, &out); err != nil {
+		return fmt.Errorf("cannot connect to %s: %w", addr, err)
+	}
+	return nil
+}
+
+// SwarmAddrsListen Lists the multiaddresses the node listens on, which
+// differ from those it announces to its peers.
+func (c *Client) SwarmAddrsListen(ctx context.Context) ([]string, error) {
+	out := struct {
+		Strings []string `json:"Strings"`
+	}{}
+	if err := c.read(ctx, "swarm/addrs/listen", nil, &out); err != nil {
+		return nil, fmt.Errorf("cannot list swarm listen addresses: %w", err)
+	}
+	return out.Strings, nil
+}
+
+// SwarmPeeringLs Lists the peer IDs of the peering set of the node. Kubo
+// keeps the connections to these peers open and protects them from the
+// connection manager.
+func (c *Client) SwarmPeeringLs(ctx context.Context) ([]string, error) {
+	out := struct {
+		Peers []struct {
+			ID string `json:"ID"`
+		} `json:"Peers"`
+	}{}
+	if err := c.read(ctx, "swarm/peering/ls", nil, &out); err != nil {
+		return nil, fmt.Errorf("cannot list the peering set: %w", err)
+	}
+	ids := make([]string, 0, len(out.Peers))
+	for _, p := range out.Peers {
+		ids = append(ids, p.ID)
+	}
+	return ids, nil
+}
+
+// PinLs Returns the pins of the given type held by the node, keyed by CID.
+func (c *Client) PinLs(ctx context.Context, pinType string) (map[string]string, error) {
+	out := struct {
+		Keys map[string]struct {
+			Type string `json:"Type"`
+		} `json:"Keys"`
+	}{}
+	if err := c.read(ctx, "pin/ls", url.Values{"type": {pinType}}, &out); err != nil {
+		return nil, fmt.Errorf("cannot list pins: %w", err)
+	}
+	pins := make(map[string]string, len(out.Keys))
+	for cid, pin := range out.Keys {
+		pins[cid] = pin.Type
+	}
+	return pins, nil
+}
+
+// PinAdd Pins the CID on the node, fetching its blocks from the other nodes.
+// A direct pin only fetches the root block. The command is not retried, as
+// it returns once the blocks are fetched, which the timeout of the client
+// bounds.
+func (c *Client) PinAdd(ctx context.Context, cid string, recursive bool) error {
+	out := struct {
+		Pins []string `json:"Pins"`
+	}{}
+	query := url.Values{"arg": {cid}, "recursive": {strconv.FormatBool(recursive)}}
+	if _, err := c.attempt(ctx, "pin/add", query, "", nil, &out); err != nil {
+		return fmt.Errorf("cannot pin %s: %w", cid, err)
+	}
+	return nil
+}
+
+// PinRm Removes the pin of the CID from the node. The command is not
+// retried, as kubo refuses to remove a pin twice.
+func (c *Client) PinRm(ctx context.Context, cid string, recursive bool) error {
+	out := struct {
+		Pins []string `json:"Pins"`
+	}{}
+	query := url.Values{"arg": {cid}, "recursive": {strconv.FormatBool(recursive)}}
+	if _, err := c.attempt(ctx, "pin/rm", query, "", nil, &out); err != nil {
+		return fmt.Errorf("cannot unpin %s: %w", cid, err)
+	}
+	return nil
+}
+
+// RepoGC Removes the blocks of the repo no pin holds, and returns how many
+// it removed. kubo streams the removed blocks as they go, which are counted
+// rather than kept. The command is not retried, and runs for up to GCTimeout
+// rather than the timeout of the client; kubo stops collecting once it is
+// cancelled, keeping the blocks removed so far removed.
+func (c *Client) RepoGC(ctx context.Context) (int64, error) {
+	out := &gcOutput{}
+	query := url.Values{"stream-errors": {"true"}}
+	if _, err := c.attemptWithin(ctx, GCTimeout, "repo/gc", query, "", nil, out); err != nil {
+		return out.removed, fmt.Errorf("cannot collect garbage: %w", err)
+	}
+	out.flush()
+	if out.err != "" {
+		return out.removed, fmt.Errorf("cannot collect garbage: %s", out.err)
+	}
+	return out.removed, nil
+}
+
+// gcOutput Counts the blocks a garbage collection removed from the objects
+// kubo streams, one per line, and keeps the first error it reports.
+type gcOutput struct {
+	line    []byte
+	removed int64
+	err     string
+}
+
+// Write Parses the complete lines of the chunk, holding the last one back
+// until its end arrives.
+func (o *gcOutput) Write(p []byte) (int, error) {
+	data := p
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			o.line = append(o.line, data...)
+			return len(p), nil
+		}
+		o.parse(append(o.line, data[:i]...))
+		o.line = o.line[:0]
+		data = data[i+1:]
+	}
+}
+
+// flush Parses the last line, when the stream does not end with a newline.
+func (o *gcOutput) flush() {
+	o.parse(o.line)
+	o.line = nil
+}
+
+func (o *gcOutput) parse(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	out := struct {
+		Key struct {
+			CID string `json:"/"`
+		} `json:"Key"`
+		Error string `json:"Error"`
+	}{}
+	if err := json.Unmarshal(line, &out); err != nil {
+		return
+	}
+	switch {
+	case out.Error != "" && o.err == "":
+		o.err = out.Error
+	case out.Key.CID != "":
+		o.removed++
+	}
+}
+
+// SwarmResources Returns the limits the resource manager of the node
+// enforces across the whole node, from its system scope.
+func (c *Client) SwarmResources(ctx context.Context) (*ResourceLimits, error) {
+	out := struct {
+		System ResourceLimits `json:"System"`
+	}{}
+	if err := c.read(ctx, "swarm/resources", nil, &out); err != nil {
+		return nil, fmt.Errorf("cannot get swarm resources: %w", err)
+	}
+	return &out.System, nil
+}
+
+// StatsBW Returns the traffic of the node across all of its protocols, as
+// the bandwidth counter of its swarm measures it.
+func (c *Client) StatsBW(ctx context.Context) (*BandwidthStats, error) {
+	out := &BandwidthStats{}
+	if err := c.read(ctx, "stats/bw", nil, out); err != nil {
+		return nil, fmt.Errorf("cannot get bandwidth stats: %w", err)
+	}
+	return out, nil
+}
+
+// GatewayStats Returns the requests served by the gateway of the node since
+// it started, summed from the Prometheus metrics kubo serves. The metrics are
+// read once, as they are sampled periodically anyway.
+func (c *Client) GatewayStats(ctx context.Context) (*GatewayStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+MetricsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("cannot get metrics: %w",
+			&Error{StatusCode: resp.StatusCode, Message: string(bytes.TrimSpace(data))})
+	}
+	stats, err := parseGatewayStats(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read metrics: %w", err)
+	}
+	return stats, nil
+}
+
+// parseGatewayStats Sums the samples of the gateway request metrics found in
+// the Prometheus text exposition.
+func parseGatewayStats(r io.Reader) (*GatewayStats, error) {
+	stats := &GatewayStats{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var sum *float64
+		switch {
+		case strings.HasPrefix(line, metricRequests+"{"):
+			sum = &stats.Requests
+		case strings.HasPrefix(line, metricResponseBytes+"{"):
+			sum = &stats.ResponseBytes
+		default:
+			continue
+		}
+		end := strings.LastIndex(line, "}")
+		if end < 0 || !strings.Contains(line[:end], gatewayHandler) {
+			continue
+		}
+		fields := strings.Fields(line[end+1:])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("malformed sample %q", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed sample %q: %w", line, err)
+		}
+		*sum += value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// read Sends a read-only command, retrying on the backoff schedule while the
+// node cannot be reached.
+func (c *Client) read(ctx context.Context, command string, query url.Values, out interface{}) error {
+	backoff := c.backoff
+	for {
+		retry, err := c.attempt(ctx, command, query, "", nil, out)
+		if err == nil || !retry || backoff.Steps <= 1 {
+			return err
+		}
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt Sends the command once and returns whether it may be retried. The
+// RPC API only accepts POST requests. Responses are decoded into out, unless
+// it is a writer, which receives them as is.
+func (c *Client) attempt(
+	ctx context.Context,
+	command string,
+	query url.Values,
+	contentType string,
+	body io.Reader,
+	out interface{},
+) (bool, error) {
+	return c.attemptWithin(ctx, c.timeout, command, query, contentType, body, out)
+}
+
+// attemptWithin Sends the command once like attempt, bounded by the given
+// timeout rather than the timeout of the client.
+func (c *Client) attemptWithin(
+	ctx context.Context,
+	timeout time.Duration,
+	command string,
+	query url.Values,
+	contentType string,
+	body io.Reader,
+	out interface{},
+) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	u := c.baseURL + "/api/v0/" + command
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return false, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// The caller gave up; only the attempt timing out is worth a retry.
+		return ctx.Err() == nil || errors.Is(ctx.Err(), context.DeadlineExceeded), err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if err = json.Unmarshal(data, apiErr); err != nil || apiErr.Message == "" {
+			apiErr.Message = string(bytes.TrimSpace(data))
+		}
+		apiErr.StatusCode = resp.StatusCode
+		return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusBadGateway, apiErr
+	}
+	if w, ok := out.(io.Writer); ok {
+		if _, err = io.Copy(w, resp.Body); err != nil {
+			return false, fmt.Errorf("cannot read response: %w", err)
+		}
+		return false, nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("cannot decode response: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,280 @@
+package kuboapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		ctx      context.Context
+		mux      *http.ServeMux
+		server   *httptest.Server
+		client   *Client
+		requests int32
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		requests = 0
+		mux = http.NewServeMux()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			if r.URL.Path != MetricsPath {
+				Expect(r.Method).To(Equal(http.MethodPost))
+			}
+			mux.ServeHTTP(w, r)
+		}))
+		client = New(server.URL, WithBackoff(wait.Backoff{Duration: time.Millisecond, Steps: 3}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("decodes the node identity and repo stat", func() {
+		mux.HandleFunc("/api/v0/id", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"ID": "12D3KooWA", "Addresses": ["/ip4/10.0.0.1/tcp/4001"], "AgentVersion": "kubo/0.18.1"}`)
+		})
+		mux.HandleFunc("/api/v0/repo/stat", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"RepoSize": 2048, "StorageMax": 10000000000, "NumObjects": 3}`)
+		})
+		mux.HandleFunc("/api/v0/stats/provide", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"TotalProvides": 10, "AvgProvideDuration": 250000000, "LastReprovideBatchSize": 3}`)
+		})
+		id, err := client.ID(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id.ID).To(Equal("12D3KooWA"))
+		Expect(id.Addresses).To(HaveLen(1))
+
+		stat, err := client.RepoStat(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stat.RepoSize).To(Equal(uint64(2048)))
+		Expect(stat.NumObjects).To(Equal(uint64(3)))
+
+		provide, err := client.ProvideStats(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(provide.AvgProvideDuration).To(Equal(250 * time.Millisecond))
+	})
+
+	It("lists swarm peers, listen addresses and pins", func() {
+		mux.HandleFunc("/api/v0/swarm/peers", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Peers": [{"Addr": "/ip4/10.0.0.2/tcp/4001", "Peer": "12D3KooWB"}]}`)
+		})
+		mux.HandleFunc("/api/v0/pin/ls", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("type")).To(Equal(PinTypeRecursive))
+			fmt.Fprint(w, `{"Keys": {"bafyexample": {"Type": "recursive"}}}`)
+		})
+		mux.HandleFunc("/api/v0/swarm/addrs/listen", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Strings": ["/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic"]}`)
+		})
+		mux.HandleFunc("/api/v0/swarm/peering/ls", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Peers": [{"ID": "12D3KooWR", "Addrs": ["/ip4/10.0.0.9/tcp/4001"]}]}`)
+		})
+		peers, err := client.SwarmPeers(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peers).To(ConsistOf(SwarmPeer{Addr: "/ip4/10.0.0.2/tcp/4001", Peer: "12D3KooWB"}))
+
+		listen, err := client.SwarmAddrsListen(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listen).To(Equal([]string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4001/quic"}))
+
+		peering, err := client.SwarmPeeringLs(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(peering).To(Equal([]string{"12D3KooWR"}))
+
+		pins, err := client.PinLs(ctx, PinTypeRecursive)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pins).To(Equal(map[string]string{"bafyexample": PinTypeRecursive}))
+	})
+
+	It("uploads keys without retrying", func() {
+		mux.HandleFunc("/api/v0/key/import", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("arg")).To(Equal("peer-1"))
+			file, _, err := r.FormFile("key")
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal([]byte("private")))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		_, err := client.KeyImport(ctx, "peer-1", []byte("private"))
+		Expect(err).To(HaveOccurred())
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+
+	It("adds content and reads it back", func() {
+		mux.HandleFunc("/api/v0/add", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("pin")).To(Equal("false"))
+			Expect(r.URL.Query().Get("cid-version")).To(Equal("1"))
+			Expect(r.URL.Query().Get("chunker")).To(Equal("size-262144"))
+			file, header, err := r.FormFile("file")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(header.Filename).To(Equal("canary"))
+			data, err := io.ReadAll(file)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).To(Equal([]byte("hello")))
+			fmt.Fprint(w, `{"Name": "canary", "Hash": "bafkreihello", "Size": "5"}`)
+		})
+		mux.HandleFunc("/api/v0/cat", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("arg")).To(Equal("bafkreihello"))
+			fmt.Fprint(w, "hello")
+		})
+		cid, err := client.Add(ctx, "canary", []byte("hello"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cid).To(Equal("bafkreihello"))
+
+		data, err := client.Cat(ctx, cid)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(Equal([]byte("hello")))
+	})
+
+	It("connects to a peer without retrying", func() {
+		mux.HandleFunc("/api/v0/swarm/connect", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("arg")).To(Equal("/dns4/peer-1.example/tcp/4001/p2p/12D3KooWB"))
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"Message": "connect 12D3KooWB failure: failed to dial", "Code": 0, "Type": "error"}`)
+		})
+		err := client.SwarmConnect(ctx, "/dns4/peer-1.example/tcp/4001/p2p/12D3KooWB")
+		Expect(err).To(MatchError(ContainSubstring("failed to dial")))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+
+	It("counts the blocks a garbage collection removed", func() {
+		mux.HandleFunc("/api/v0/repo/gc", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("stream-errors")).To(Equal("true"))
+			fmt.Fprint(w, `{"Key":{"/":"bafkreia"}}`+"\n")
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, `{"Key":{"/":"bafkreib"}}`+"\n"+`{"Key":{"/":"bafk`)
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, `reic"}}`)
+		})
+		removed, err := client.RepoGC(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(removed).To(Equal(int64(3)))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+
+		By("reporting the error streamed along the removed blocks")
+		mux = http.NewServeMux()
+		mux.HandleFunc("/api/v0/repo/gc", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Key":{"/":"bafkreia"}}`+"\n"+`{"Error":"could not remove bafkreib"}`+"\n")
+		})
+		removed, err = client.RepoGC(ctx)
+		Expect(err).To(MatchError(ContainSubstring("could not remove bafkreib")))
+		Expect(removed).To(Equal(int64(1)))
+	})
+
+	It("sums the gateway metrics", func() {
+		mux.HandleFunc(MetricsPath, func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Method).To(Equal(http.MethodGet))
+			fmt.Fprint(w, `# HELP ipfs_http_requests_total Total number of HTTP requests made.
+# TYPE ipfs_http_requests_total counter
+ipfs_http_requests_total{code="200",handler="gateway",method="get"} 12
+ipfs_http_requests_total{code="404",handler="gateway",method="get"} 3
+ipfs_http_requests_total{code="200",handler="api",method="post"} 40
+ipfs_http_response_size_bytes_sum{handler="gateway"} 2.5e+06
+ipfs_http_response_size_bytes_count{handler="gateway"} 15
+ipfs_http_response_size_bytes_sum{handler="api"} 1024
+`)
+		})
+		stats, err := client.GatewayStats(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*stats).To(Equal(GatewayStats{Requests: 15, ResponseBytes: 2.5e+06}))
+	})
+
+	It("decodes the system limits of the resource manager", func() {
+		mux.HandleFunc("/api/v0/swarm/resources", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"System": {"Memory": 536870912, "MemoryUsage": 1024, "Conns": 512, "ConnsUsage": 40,
+				"ConnsInbound": 256, "Streams": "unlimited", "StreamsInbound": "blockAll"},
+				"Transient": {"Conns": 64}}`)
+		})
+		limits, err := client.SwarmResources(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*limits).To(Equal(ResourceLimits{
+			Memory: 536870912, Conns: 512, ConnsInbound: 256, Streams: Unlimited, StreamsInbound: 0,
+		}))
+	})
+
+	It("decodes the traffic of the node", func() {
+		mux.HandleFunc("/api/v0/stats/bw", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"TotalIn": 2048, "TotalOut": 4096, "RateIn": 12.5, "RateOut": 1024.25}`)
+		})
+		stats, err := client.StatsBW(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*stats).To(Equal(BandwidthStats{TotalIn: 2048, TotalOut: 4096, RateIn: 12.5, RateOut: 1024.25}))
+	})
+
+	It("lists the entries of an MFS directory with their CIDs", func() {
+		mux.HandleFunc("/api/v0/files/ls", func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Query().Get("arg")).To(Equal("/ingest/runs/1"))
+			Expect(r.URL.Query().Get("long")).To(Equal("true"))
+			fmt.Fprint(w, `{"Entries": [{"Name": "manifest", "Type": 0, "Size": 12, "Hash": "bafkreia"},`+
+				`{"Name": "entries", "Type": 1, "Size": 0, "Hash": "QmDir"}]}`)
+		})
+		entries, err := client.FilesLs(ctx, "/ingest/runs/1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(Equal([]FilesEntry{
+			{Name: "manifest", Type: 0, Size: 12, Hash: "bafkreia"},
+			{Name: "entries", Type: 1, Hash: "QmDir"},
+		}))
+	})
+
+	It("retries read-only commands while the node is unavailable", func() {
+		var calls int32
+		mux.HandleFunc("/api/v0/id", func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"ID": "12D3KooWA"}`)
+		})
+		_, err := client.ID(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(3)))
+	})
+
+	It("maps kubo errors", func() {
+		mux.HandleFunc("/api/v0/pin/ls", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"Message": "invalid type 'bogus'", "Code": 0, "Type": "error"}`)
+		})
+		_, err := client.PinLs(ctx, "bogus")
+		Expect(err).To(MatchError(ContainSubstring("invalid type 'bogus'")))
+		Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+	})
+
+	It("authenticates with the API proxy", func() {
+		mux.HandleFunc("/api/v0/id", func(w http.ResponseWriter, r *http.Request) {
+			if user, pass, ok := r.BasicAuth(); !ok || user != "operator" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"ID": "12D3KooWA"}`)
+		})
+		_, err := client.ID(ctx)
+		Expect(IsUnauthorized(err)).To(BeTrue())
+
+		client = New(server.URL, WithBasicAuth("operator", "secret"))
+		_, err = client.ID(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("PodDialer", func() {
+	It("dials the pod through its DNS name", func() {
+		api, err := PodDialer{}.Dial("default", "ipfs-cluster-test", "ipfs-cluster-test-1")
+		Expect(err).NotTo(HaveOccurred())
+		client, ok := api.(*Client)
+		Expect(ok).To(BeTrue())
+		Expect(client.baseURL).To(Equal("http://ipfs-cluster-test-1.ipfs-cluster-test.default.svc:5001"))
+	})
+})
@@ -0,0 +1,32 @@
+package kuboapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error Is a command the RPC API answered with an error status.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("kubo API returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsUnauthorized Returns whether the API proxy refused the credentials.
+func IsUnauthorized(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// unreachable Returns whether the error means the node could not be reached
+// or was not ready to answer, rather than that it answered.
+func unreachable(err error) bool {
+	var apiErr *Error
+	return !errors.As(err, &apiErr) ||
+		apiErr.StatusCode == http.StatusServiceUnavailable || apiErr.StatusCode == http.StatusBadGateway
+}
@@ -0,0 +1,332 @@
+// Package fake Provides in-memory kubo nodes for the tests of controllers
+// which depend on the kuboapi interfaces.
+package fake
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/redhat-et/ipfs-operator/pkg/kuboapi"
+)
+
+// Node Is an in-memory kubo node. Its fields may be set directly by tests;
+// Err, when set, fails every command, and Errs fails the named commands only.
+type Node struct {
+	mu sync.Mutex
+
+	Identity kuboapi.IDOutput
+	Repo     kuboapi.RepoStat
+	Provide  kuboapi.ProvideStats
+	Keys     map[string][]byte
+	Peers    []kuboapi.SwarmPeer
+	// Listen Holds the multiaddresses the node listens on.
+	Listen []string
+	// Peering Holds the peer IDs of the peering set of the node.
+	Peering []string
+	// Pins Holds the type of each pin, keyed by CID.
+	Pins map[string]string
+	// Gateway Holds the requests served by the gateway of the node.
+	Gateway kuboapi.GatewayStats
+	// Resources Holds the limits of the resource manager of the node.
+	Resources kuboapi.ResourceLimits
+	// Bandwidth Holds the traffic of the node.
+	Bandwidth kuboapi.BandwidthStats
+	// Blocks Holds the content added to the node, keyed by CID. Nodes
+	// sharing the map fetch the content of each other, as if connected.
+	Blocks map[string][]byte
+	// Files Holds the entries of the directories of the MFS of the node,
+	// keyed by path.
+	Files map[string][]kuboapi.FilesEntry
+	// Garbage Holds the size of the blocks of the repo no pin holds, keyed
+	// by CID, which RepoGC removes from the repo.
+	Garbage map[string]uint64
+
+	Err  error
+	Errs map[string]error
+	// Calls Counts the commands received, keyed by name.
+	Calls map[string]int
+}
+
+var _ kuboapi.API = &Node{}
+
+// NewNode Returns an empty node with the given peer ID.
+func NewNode(id string) *Node {
+	return &Node{
+		Identity: kuboapi.IDOutput{ID: id},
+		Keys:     map[string][]byte{},
+		Pins:     map[string]string{},
+		Blocks:   map[string][]byte{},
+		Errs:     map[string]error{},
+		Calls:    map[string]int{},
+	}
+}
+
+// call Records the command and returns the error injected for it, if any.
+// The lock is held on return.
+func (n *Node) call(command string) error {
+	n.mu.Lock()
+	if n.Calls == nil {
+		n.Calls = map[string]int{}
+	}
+	n.Calls[command]++
+	if n.Err != nil {
+		return n.Err
+	}
+	return n.Errs[command]
+}
+
+// ID Returns the identity of the node.
+func (n *Node) ID(_ context.Context) (*kuboapi.IDOutput, error) {
+	defer n.mu.Unlock()
+	if err := n.call("id"); err != nil {
+		return nil, err
+	}
+	id := n.Identity
+	return &id, nil
+}
+
+// RepoStat Returns the size of the repository of the node.
+func (n *Node) RepoStat(_ context.Context) (*kuboapi.RepoStat, error) {
+	defer n.mu.Unlock()
+	if err := n.call("repo/stat"); err != nil {
+		return nil, err
+	}
+	stat := n.Repo
+	return &stat, nil
+}
+
+// ProvideStats Returns the provide statistics of the node.
+func (n *Node) ProvideStats(_ context.Context) (*kuboapi.ProvideStats, error) {
+	defer n.mu.Unlock()
+	if err := n.call("stats/provide"); err != nil {
+		return nil, err
+	}
+	stats := n.Provide
+	return &stats, nil
+}
+
+// KeyImport Stores the key under the given name, refusing names already taken.
+func (n *Node) KeyImport(_ context.Context, name string, key []byte) (*kuboapi.Key, error) {
+	defer n.mu.Unlock()
+	if err := n.call("key/import"); err != nil {
+		return nil, err
+	}
+	if _, ok := n.Keys[name]; ok {
+		return nil, &kuboapi.Error{StatusCode: 500, Message: fmt.Sprintf("key with name '%s' already exists", name)}
+	}
+	if n.Keys == nil {
+		n.Keys = map[string][]byte{}
+	}
+	n.Keys[name] = append([]byte(nil), key...)
+	return &kuboapi.Key{Name: name}, nil
+}
+
+// SwarmPeers Lists the peers the node is connected to.
+func (n *Node) SwarmPeers(_ context.Context) ([]kuboapi.SwarmPeer, error) {
+	defer n.mu.Unlock()
+	if err := n.call("swarm/peers"); err != nil {
+		return nil, err
+	}
+	return append([]kuboapi.SwarmPeer(nil), n.Peers...), nil
+}
+
+// SwarmConnect Adds the peer of the multiaddress, which must end with its
+// /p2p/ component, to the peers of the node.
+func (n *Node) SwarmConnect(_ context.Context, addr string) error {
+	defer n.mu.Unlock()
+	if err := n.call("swarm/connect"); err != nil {
+		return err
+	}
+	i := strings.LastIndex(addr, "/p2p/")
+	if i < 0 {
+		return &kuboapi.Error{StatusCode: 500, Message: "invalid peer address " + addr}
+	}
+	n.Peers = append(n.Peers, kuboapi.SwarmPeer{Addr: addr[:i], Peer: addr[i+len("/p2p/"):]})
+	return nil
+}
+
+// SwarmAddrsListen Lists the multiaddresses the node listens on.
+func (n *Node) SwarmAddrsListen(_ context.Context) ([]string, error) {
+	defer n.mu.Unlock()
+	if err := n.call("swarm/addrs/listen"); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), n.Listen...), nil
+}
+
+// SwarmPeeringLs Lists the peer IDs of the peering set of the node.
+func (n *Node) SwarmPeeringLs(_ context.Context) ([]string, error) {
+	defer n.mu.Unlock()
+	if err := n.call("swarm/peering/ls"); err != nil {
+		return nil, err
+	}
+	return append([]string(nil), n.Peering...), nil
+}
+
+// PinLs Returns the pins of the given type held by the node.
+func (n *Node) PinLs(_ context.Context, pinType string) (map[string]string, error) {
+	defer n.mu.Unlock()
+	if err := n.call("pin/ls"); err != nil {
+		return nil, err
+	}
+	pins := map[string]string{}
+	for cid, t := range n.Pins {
+		if pinType == "" || pinType == kuboapi.PinTypeAll || pinType == t {
+			pins[cid] = t
+		}
+	}
+	return pins, nil
+}
+
+// PinAdd Records the pin of the CID with its type.
+func (n *Node) PinAdd(_ context.Context, cid string, recursive bool) error {
+	defer n.mu.Unlock()
+	if err := n.call("pin/add"); err != nil {
+		return err
+	}
+	if n.Pins == nil {
+		n.Pins = map[string]string{}
+	}
+	n.Pins[cid] = kuboapi.PinTypeDirect
+	if recursive {
+		n.Pins[cid] = kuboapi.PinTypeRecursive
+	}
+	return nil
+}
+
+// PinRm Removes the pin of the CID, failing as kubo does when it is not
+// pinned.
+func (n *Node) PinRm(_ context.Context, cid string, _ bool) error {
+	defer n.mu.Unlock()
+	if err := n.call("pin/rm"); err != nil {
+		return err
+	}
+	if _, ok := n.Pins[cid]; !ok {
+		return &kuboapi.Error{StatusCode: 500, Message: "not pinned or pinned indirectly"}
+	}
+	delete(n.Pins, cid)
+	return nil
+}
+
+// RepoGC Removes the garbage from the repo, shrinking its size.
+func (n *Node) RepoGC(_ context.Context) (int64, error) {
+	defer n.mu.Unlock()
+	if err := n.call("repo/gc"); err != nil {
+		return 0, err
+	}
+	removed := int64(0)
+	for cid, size := range n.Garbage {
+		n.Repo.RepoSize -= size
+		n.Repo.NumObjects--
+		delete(n.Garbage, cid)
+		removed++
+	}
+	return removed, nil
+}
+
+// GatewayStats Returns the requests served by the gateway of the node.
+func (n *Node) GatewayStats(_ context.Context) (*kuboapi.GatewayStats, error) {
+	defer n.mu.Unlock()
+	if err := n.call("metrics"); err != nil {
+		return nil, err
+	}
+	stats := n.Gateway
+	return &stats, nil
+}
+
+// SwarmResources Returns the limits of the resource manager of the node.
+func (n *Node) SwarmResources(_ context.Context) (*kuboapi.ResourceLimits, error) {
+	defer n.mu.Unlock()
+	if err := n.call("swarm/resources"); err != nil {
+		return nil, err
+	}
+	limits := n.Resources
+	return &limits, nil
+}
+
+// StatsBW Returns the traffic of the node.
+func (n *Node) StatsBW(_ context.Context) (*kuboapi.BandwidthStats, error) {
+	defer n.mu.Unlock()
+	if err := n.call("stats/bw"); err != nil {
+		return nil, err
+	}
+	stats := n.Bandwidth
+	return &stats, nil
+}
+
+// Add Stores the content under a CID derived from its digest.
+func (n *Node) Add(_ context.Context, _ string, data []byte) (string, error) {
+	defer n.mu.Unlock()
+	if err := n.call("add"); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	cid := "bafkrei" + hex.EncodeToString(sum[:16])
+	if n.Blocks == nil {
+		n.Blocks = map[string][]byte{}
+	}
+	n.Blocks[cid] = append([]byte(nil), data...)
+	return cid, nil
+}
+
+// Cat Returns the content stored under the CID.
+func (n *Node) Cat(_ context.Context, cid string) ([]byte, error) {
+	defer n.mu.Unlock()
+	if err := n.call("cat"); err != nil {
+		return nil, err
+	}
+	data, ok := n.Blocks[cid]
+	if !ok {
+		return nil, &kuboapi.Error{StatusCode: 500, Message: "context deadline exceeded"}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// FilesLs Returns the entries of the MFS directory at the path.
+func (n *Node) FilesLs(_ context.Context, path string) ([]kuboapi.FilesEntry, error) {
+	defer n.mu.Unlock()
+	if err := n.call("files/ls"); err != nil {
+		return nil, err
+	}
+	entries, ok := n.Files[path]
+	if !ok {
+		return nil, &kuboapi.Error{StatusCode: 500, Message: "file does not exist"}
+	}
+	return append([]kuboapi.FilesEntry(nil), entries...), nil
+}
+
+// Dialer Returns the nodes registered for each pod. Pods without a node
+// cannot be dialed.
+type Dialer struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+}
+
+var _ kuboapi.Dialer = &Dialer{}
+
+// NewDialer Returns a dialer without any node.
+func NewDialer() *Dialer {
+	return &Dialer{nodes: map[string]*Node{}}
+}
+
+// Add Registers the node of the given pod.
+func (d *Dialer) Add(namespace, pod string, node *Node) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nodes[namespace+"/"+pod] = node
+}
+
+// Dial Returns the node of the given pod.
+func (d *Dialer) Dial(namespace, _, pod string) (kuboapi.API, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	node, ok := d.nodes[namespace+"/"+pod]
+	if !ok {
+		return nil, fmt.Errorf("no kubo node for pod %s/%s", namespace, pod)
+	}
+	return node, nil
+}
@@ -0,0 +1,115 @@
+package kuboapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IDOutput Is the identity of a kubo node.
+type IDOutput struct {
+	ID              string   `json:"ID"`
+	PublicKey       string   `json:"PublicKey"`
+	Addresses       []string `json:"Addresses"`
+	AgentVersion    string   `json:"AgentVersion"`
+	ProtocolVersion string   `json:"ProtocolVersion"`
+}
+
+// RepoStat Describes the repository of a kubo node. Sizes are in bytes.
+type RepoStat struct {
+	RepoSize   uint64 `json:"RepoSize"`
+	StorageMax uint64 `json:"StorageMax"`
+	NumObjects uint64 `json:"NumObjects"`
+	RepoPath   string `json:"RepoPath"`
+	Version    string `json:"Version"`
+}
+
+// ProvideStats Describes how the node announces its content to the DHT.
+// Durations are in nanoseconds.
+type ProvideStats struct {
+	TotalProvides          uint64        `json:"TotalProvides"`
+	AvgProvideDuration     time.Duration `json:"AvgProvideDuration"`
+	LastReprovideDuration  time.Duration `json:"LastReprovideDuration"`
+	LastReprovideBatchSize uint64        `json:"LastReprovideBatchSize"`
+}
+
+// GatewayStats Sums up the requests served by the gateway of a kubo node
+// since it started.
+type GatewayStats struct {
+	Requests      float64
+	ResponseBytes float64
+}
+
+// BandwidthStats Is the traffic of a kubo node since it started, and its
+// current rates. Sizes are in bytes, and rates in bytes per second.
+type BandwidthStats struct {
+	TotalIn  int64   `json:"TotalIn"`
+	TotalOut int64   `json:"TotalOut"`
+	RateIn   float64 `json:"RateIn"`
+	RateOut  float64 `json:"RateOut"`
+}
+
+// FilesEntry Is an entry of a directory of the MFS of a kubo node. The size
+// of directories is 0.
+type FilesEntry struct {
+	Name string `json:"Name"`
+	Type int    `json:"Type"`
+	Size uint64 `json:"Size"`
+	Hash string `json:"Hash"`
+}
+
+// LimitVal Is a limit of the resource manager of a kubo node. Unlimited
+// limits are -1, and blocked ones 0.
+type LimitVal int64
+
+// Unlimited Is the value of unlimited limits.
+const Unlimited LimitVal = -1
+
+// UnmarshalJSON Decodes a number, or the names kubo gives to the limits
+// which are not numbers.
+func (l *LimitVal) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return json.Unmarshal(data, (*int64)(l))
+	}
+	switch name {
+	case "unlimited", "default":
+		*l = Unlimited
+	case "blockAll":
+		*l = 0
+	default:
+		return fmt.Errorf("unknown limit %q", name)
+	}
+	return nil
+}
+
+// ResourceLimits Are the limits the resource manager of a kubo node
+// enforces across the whole node.
+type ResourceLimits struct {
+	Memory         LimitVal `json:"Memory"`
+	Conns          LimitVal `json:"Conns"`
+	ConnsInbound   LimitVal `json:"ConnsInbound"`
+	Streams        LimitVal `json:"Streams"`
+	StreamsInbound LimitVal `json:"StreamsInbound"`
+}
+
+// Key Is a key held in the keystore of a kubo node.
+type Key struct {
+	Name string `json:"Name"`
+	ID   string `json:"Id"`
+}
+
+// SwarmPeer Is a peer a kubo node is connected to.
+type SwarmPeer struct {
+	Addr    string `json:"Addr"`
+	Peer    string `json:"Peer"`
+	Latency string `json:"Latency"`
+}
+
+// Pin types accepted by PinLs.
+const (
+	PinTypeAll       = "all"
+	PinTypeRecursive = "recursive"
+	PinTypeDirect    = "direct"
+	PinTypeIndirect  = "indirect"
+)
@@ -0,0 +1,81 @@
+// Package maddr Validates and normalizes the multiaddresses the operator is
+// given, so that a malformed entry is refused where it is set, naming the
+// field holding it, rather than by the daemons once they run with it. The
+// normalized form is stable, so that rendering it does not change the
+// configuration of the peers from one reconcile to the next.
+package maddr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Peer Tells whether the multiaddresses of a field carry the ID of the peer
+// they reach.
+type Peer int
+
+const (
+	// PeerOptional Accepts the multiaddresses with or without a peer ID.
+	PeerOptional Peer = iota
+	// PeerRequired Requires the multiaddresses to end with /p2p/ and the ID
+	// of the peer they reach.
+	PeerRequired
+	// PeerForbidden Refuses the multiaddresses ending with /p2p/, for the
+	// fields holding the peer ID apart.
+	PeerForbidden
+)
+
+// Error Is a multiaddress which cannot be used, along with the path of the
+// field holding it.
+type Error struct {
+	Path   string
+	Addr   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: invalid multiaddress %q: %s", e.Path, e.Addr, e.Reason)
+}
+
+// Parse Parses the multiaddress held by the field at the given path,
+// ignoring the surrounding spaces, and checks its peer ID.
+func Parse(path, addr string, p Peer) (ma.Multiaddr, error) {
+	m, err := ma.NewMultiaddr(strings.TrimSpace(addr))
+	if err != nil {
+		return nil, &Error{Path: path, Addr: addr, Reason: err.Error()}
+	}
+	rest, last := ma.SplitLast(m)
+	endsWithPeer := last != nil && last.Protocol().Code == ma.P_P2P
+	switch {
+	case p == PeerRequired && !endsWithPeer:
+		return nil, &Error{Path: path, Addr: addr, Reason: "must end with /p2p/ and the peer ID"}
+	case p == PeerRequired && rest == nil:
+		return nil, &Error{Path: path, Addr: addr, Reason: "has no address before the peer ID"}
+	case p == PeerForbidden && endsWithPeer:
+		return nil, &Error{Path: path, Addr: addr, Reason: "must not end with /p2p/, the peer ID is set apart"}
+	}
+	return m, nil
+}
+
+// Normalize Parses the multiaddresses of the list at the given path, and
+// returns their canonical forms without duplicates, sorted. The error names
+// the first entry which cannot be used.
+func Normalize(path string, addrs []string, p Peer) ([]string, error) {
+	seen := make(map[string]bool, len(addrs))
+	normalized := make([]string, 0, len(addrs))
+	for i, addr := range addrs {
+		m, err := Parse(fmt.Sprintf("%s[%d]", path, i), addr, p)
+		if err != nil {
+			return nil, err
+		}
+		if s := m.String(); !seen[s] {
+			seen[s] = true
+			normalized = append(normalized, s)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
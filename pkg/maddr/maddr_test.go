@@ -0,0 +1,70 @@
+package maddr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+const peerID = "12D3KooWSAxzBsGMkwFHQCtcCCLjDYS8s2KW5hC7dPoZkh6mXTe8"
+
+var _ = Describe("Multiaddresses", func() {
+	DescribeTable("parses the valid multiaddresses into their canonical form",
+		func(addr string, p Peer, canonical string) {
+			m, err := Parse("spec.peering[0]", addr, p)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(m.String()).To(Equal(canonical))
+		},
+		Entry("ip4", "/ip4/203.0.113.7/tcp/4001/p2p/"+peerID, PeerRequired,
+			"/ip4/203.0.113.7/tcp/4001/p2p/"+peerID),
+		Entry("ip6", "/ip6/0:0::1/udp/4001/quic", PeerForbidden, "/ip6/::1/udp/4001/quic"),
+		Entry("dns4 with the legacy ipfs protocol", "/dns4/peer.example.com/tcp/4001/ipfs/"+peerID, PeerRequired,
+			"/dns4/peer.example.com/tcp/4001/p2p/"+peerID),
+		Entry("dns6", "/dns6/peer.example.com/tcp/4001", PeerOptional, "/dns6/peer.example.com/tcp/4001"),
+		Entry("dnsaddr", "/dnsaddr/bootstrap.libp2p.io/p2p/"+peerID, PeerRequired,
+			"/dnsaddr/bootstrap.libp2p.io/p2p/"+peerID),
+		Entry("ws", "/ip4/203.0.113.7/tcp/8081/ws", PeerForbidden, "/ip4/203.0.113.7/tcp/8081/ws"),
+		Entry("wss", " /dns4/peer.example.com/tcp/443/wss/p2p/"+peerID+" ", PeerOptional,
+			"/dns4/peer.example.com/tcp/443/wss/p2p/"+peerID),
+		Entry("quic-v1", "/ip4/203.0.113.7/udp/4001/quic-v1/p2p/"+peerID, PeerRequired,
+			"/ip4/203.0.113.7/udp/4001/quic-v1/p2p/"+peerID),
+		Entry("webtransport", "/ip4/203.0.113.7/udp/4001/quic-v1/webtransport", PeerForbidden,
+			"/ip4/203.0.113.7/udp/4001/quic-v1/webtransport"),
+		Entry("a relayed address", "/ip4/203.0.113.7/tcp/4001/p2p/"+peerID+"/p2p-circuit", PeerForbidden,
+			"/ip4/203.0.113.7/tcp/4001/p2p/"+peerID+"/p2p-circuit"),
+	)
+
+	DescribeTable("refuses the invalid multiaddresses, quoting them with their field",
+		func(addr string, p Peer, reason string) {
+			_, err := Parse("spec.peering[0]", addr, p)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix(`spec.peering[0]: invalid multiaddress "` + addr + `"`))
+			Expect(err.Error()).To(ContainSubstring(reason))
+		},
+		Entry("garbage", "peer.example.com:4001", PeerOptional, "must begin with /"),
+		Entry("an empty entry", "", PeerOptional, "empty multiaddr"),
+		Entry("an unknown protocol", "/ip4/203.0.113.7/sctcp/4001", PeerOptional, "unknown protocol"),
+		Entry("an invalid ip4", "/ip4/203.0.113.300/tcp/4001", PeerOptional, "203.0.113.300"),
+		Entry("an invalid peer ID", "/ip4/203.0.113.7/tcp/4001/p2p/notapeer", PeerRequired, "notapeer"),
+		Entry("a missing peer ID", "/dns4/peer.example.com/tcp/4001", PeerRequired, "must end with /p2p/"),
+		Entry("a peer ID alone", "/p2p/"+peerID, PeerRequired, "no address"),
+		Entry("a peer ID set apart", "/ip4/203.0.113.7/tcp/4001/p2p/"+peerID, PeerForbidden, "must not end"),
+	)
+
+	It("deduplicates and sorts the normalized multiaddresses", func() {
+		addrs, err := Normalize("spec.peering", []string{
+			"/ip4/203.0.113.8/tcp/4001/p2p/" + peerID,
+			"/ip4/203.0.113.7/tcp/4001/ipfs/" + peerID,
+			"/ip4/203.0.113.7/tcp/4001/p2p/" + peerID,
+		}, PeerRequired)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addrs).To(Equal([]string{
+			"/ip4/203.0.113.7/tcp/4001/p2p/" + peerID,
+			"/ip4/203.0.113.8/tcp/4001/p2p/" + peerID,
+		}))
+
+		_, err = Normalize("spec.peering", []string{"/ip4/203.0.113.7/tcp/4001/p2p/" + peerID, "garbage"},
+			PeerRequired)
+		Expect(err).To(MatchError(ContainSubstring(`spec.peering[1]: invalid multiaddress "garbage"`)))
+	})
+})
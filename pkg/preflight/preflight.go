@@ -0,0 +1,233 @@
+// Package preflight Checks the network of a peer before its daemons start:
+// that the Service of its cluster resolves, that the peers serving it are
+// reachable, and that the peer can reach the public network. It runs in an
+// init container of each peer and reports the results in the termination
+// message of the container, which the operator reads from the pod status.
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Environment variables the checks are configured from.
+const (
+	// ServiceEnv Holds the name of the Service of the cluster.
+	ServiceEnv = "PREFLIGHT_SERVICE"
+	// PortsEnv Holds the ports of the Service to reach, as a comma-separated
+	// list of name=port pairs.
+	PortsEnv = "PREFLIGHT_PORTS"
+	// OutboundEnv Holds the comma-separated host:port addresses of which at
+	// least one must be reachable. The outbound check is skipped when empty.
+	OutboundEnv = "PREFLIGHT_OUTBOUND"
+)
+
+// Names of the checks.
+const (
+	CheckDNS      = "dns"
+	CheckOutbound = "outbound"
+)
+
+const (
+	// DefaultTimeout Bounds every check, so that a healthy peer is only
+	// delayed by the time it takes to dial the Service.
+	DefaultTimeout = 3 * time.Second
+	// TerminationLog Is where the report is written for the kubelet to
+	// copy it to the pod status.
+	TerminationLog = "/dev/termination-log"
+	// maxReportSize Is the size of the termination message kept by the kubelet.
+	maxReportSize = 4096
+)
+
+// Config Describes what to check.
+type Config struct {
+	Service  string
+	Ports    map[string]string
+	Outbound []string
+	Timeout  time.Duration
+}
+
+// Result Is the outcome of a check. Error is empty when the check passed.
+type Result struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// Report Holds the results of every check, sorted by name.
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// Failed Returns the checks which failed.
+func (r Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Error != "" {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// ConfigFromEnv Returns the checks configured in the environment.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Service: os.Getenv(ServiceEnv),
+		Ports:   map[string]string{},
+		Timeout: DefaultTimeout,
+	}
+	if cfg.Service == "" {
+		return cfg, fmt.Errorf("%s is not set", ServiceEnv)
+	}
+	for _, pair := range split(os.Getenv(PortsEnv)) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return cfg, fmt.Errorf("invalid port %q in %s", pair, PortsEnv)
+		}
+		cfg.Ports[parts[0]] = parts[1]
+	}
+	cfg.Outbound = split(os.Getenv(OutboundEnv))
+	return cfg, nil
+}
+
+// split Returns the non-empty elements of a comma-separated list.
+func split(list string) []string {
+	var elems []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// Run Runs every check concurrently and returns their results.
+func Run(ctx context.Context, cfg Config) Report {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results []Result
+	)
+	check := func(name string, run func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := Result{Name: name}
+			if err := run(ctx); err != nil {
+				res.Error = err.Error()
+			}
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}()
+	}
+
+	check(CheckDNS, func(ctx context.Context) error {
+		_, err := net.DefaultResolver.LookupHost(ctx, cfg.Service)
+		return err
+	})
+	for name, port := range cfg.Ports {
+		addr := net.JoinHostPort(cfg.Service, port)
+		check(name, func(ctx context.Context) error {
+			return dialPeer(ctx, addr)
+		})
+	}
+	if len(cfg.Outbound) > 0 {
+		check(CheckOutbound, func(ctx context.Context) error {
+			return dialAny(ctx, cfg.Outbound)
+		})
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return Report{Results: results}
+}
+
+// dialPeer Connects to the address of the Service. A refused connection means
+// no peer serves the Service yet, as when the first peer of a cluster starts,
+// which is not a failure. Filtered connections time out instead.
+func dialPeer(ctx context.Context, addr string) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// dialAny Connects to the addresses concurrently until one of them answers.
+func dialAny(ctx context.Context, addrs []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+			if err == nil {
+				err = conn.Close()
+			}
+			errs <- err
+		}(addr)
+	}
+	var last error
+	for range addrs {
+		if last = <-errs; last == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("none of %s is reachable: %w", strings.Join(addrs, ", "), last)
+}
+
+// Write Writes the report to the given file, trimming the errors so that it
+// fits in a termination message.
+func Write(path string, report Report) error {
+	data, err := encode(report)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// encode Returns the JSON encoding of the report, no larger than a
+// termination message.
+func encode(report Report) ([]byte, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode preflight report: %w", err)
+	}
+	if len(data) <= maxReportSize || len(report.Results) == 0 {
+		return data, nil
+	}
+	budget := maxReportSize / len(report.Results) / 2
+	trimmed := Report{Results: make([]Result, len(report.Results))}
+	for i, res := range report.Results {
+		if len(res.Error) > budget {
+			res.Error = res.Error[:budget]
+		}
+		trimmed.Results[i] = res
+	}
+	return json.Marshal(trimmed)
+}
+
+// Parse Returns the report held in a termination message.
+func Parse(message string) (Report, error) {
+	report := Report{}
+	if err := json.Unmarshal([]byte(message), &report); err != nil {
+		return report, fmt.Errorf("cannot parse preflight report: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,106 @@
+package preflight
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Preflight", func() {
+	var (
+		listener net.Listener
+		port     string
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		_, port, err = net.SplitHostPort(listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(listener.Close()).To(Succeed())
+	})
+
+	It("passes when the Service resolves and answers or has no peer yet", func() {
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		_, closedPort, err := net.SplitHostPort(closed.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(closed.Close()).To(Succeed())
+
+		report := Run(context.Background(), Config{
+			Service:  "localhost",
+			Ports:    map[string]string{"swarm": port, "cluster-swarm": closedPort},
+			Outbound: []string{"127.0.0.1:" + closedPort, "127.0.0.1:" + port},
+			Timeout:  time.Second,
+		})
+		Expect(report.Failed()).To(BeEmpty())
+		names := []string{}
+		for _, res := range report.Results {
+			names = append(names, res.Name)
+		}
+		Expect(names).To(Equal([]string{"cluster-swarm", "dns", "outbound", "swarm"}))
+	})
+
+	It("names the failing checks", func() {
+		report := Run(context.Background(), Config{
+			Service:  "localhost",
+			Outbound: []string{"127.0.0.1:1"},
+			Timeout:  time.Second,
+		})
+		failed := report.Failed()
+		Expect(failed).To(HaveLen(1))
+		Expect(failed[0].Name).To(Equal(CheckOutbound))
+		Expect(failed[0].Error).To(ContainSubstring("127.0.0.1:1"))
+	})
+
+	It("reads its configuration from the environment", func() {
+		Expect(os.Setenv(ServiceEnv, "ipfs-cluster-example.default.svc")).To(Succeed())
+		Expect(os.Setenv(PortsEnv, "swarm=4001, cluster-swarm=9096")).To(Succeed())
+		Expect(os.Setenv(OutboundEnv, "")).To(Succeed())
+		defer func() {
+			Expect(os.Unsetenv(ServiceEnv)).To(Succeed())
+			Expect(os.Unsetenv(PortsEnv)).To(Succeed())
+			Expect(os.Unsetenv(OutboundEnv)).To(Succeed())
+		}()
+
+		cfg, err := ConfigFromEnv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Service).To(Equal("ipfs-cluster-example.default.svc"))
+		Expect(cfg.Ports).To(Equal(map[string]string{"swarm": "4001", "cluster-swarm": "9096"}))
+		Expect(cfg.Outbound).To(BeEmpty())
+
+		Expect(os.Setenv(PortsEnv, "swarm")).To(Succeed())
+		_, err = ConfigFromEnv()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fits the report in a termination message", func() {
+		report := Report{Results: []Result{
+			{Name: "dns", Error: strings.Repeat("x", 8192)},
+			{Name: "swarm"},
+		}}
+		dir, err := os.MkdirTemp("", "preflight")
+		Expect(err).NotTo(HaveOccurred())
+		defer func() { Expect(os.RemoveAll(dir)).To(Succeed()) }()
+		path := filepath.Join(dir, "termination-log")
+		Expect(Write(path, report)).To(Succeed())
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(data)).To(BeNumerically("<=", maxReportSize))
+
+		parsed, err := Parse(string(data))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed.Failed()).To(HaveLen(1))
+		Expect(parsed.Failed()[0].Name).To(Equal("dns"))
+	})
+})